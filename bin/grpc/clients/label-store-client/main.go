@@ -72,7 +72,7 @@ func watchMatches(watcher matchWatcher) {
 		logger.Fatal(err)
 	}
 
-	sel, err := klabels.Parse(*selector)
+	sel, err := labels.ParseSelector(*selector)
 	if err != nil {
 		logger.Fatal(err)
 	}
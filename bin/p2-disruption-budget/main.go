@@ -0,0 +1,119 @@
+// p2-disruption-budget manages the disruption budgets that limit how many pods
+// node transfers, rolling updates, daemon sets and p2-rm may take down at once.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/square/p2/pkg/disruptionbudget/fields"
+	pc_fields "github.com/square/p2/pkg/pc/fields"
+	"github.com/square/p2/pkg/store/consul"
+	"github.com/square/p2/pkg/store/consul/disruptionbudgetstore"
+	"github.com/square/p2/pkg/store/consul/flags"
+	"github.com/square/p2/pkg/types"
+)
+
+const (
+	cmdSetText    = "set"
+	cmdGetText    = "get"
+	cmdDeleteText = "delete"
+	cmdListText   = "list"
+)
+
+// "set" command and flags
+var (
+	cmdSet            = kingpin.Command(cmdSetText, "Create or replace a disruption budget. Reservations already made against the budget are kept")
+	setPodID          = cmdSet.Flag("pod", "The pod ID the budget covers").Required().String()
+	setAZ             = cmdSet.Flag("az", "The availability zone of the pod cluster the budget covers. Must be used with --name").String()
+	setName           = cmdSet.Flag("name", "The cluster name of the pod cluster the budget covers. Must be used with --az").String()
+	setMaxUnavailable = cmdSet.Flag("max-unavailable", "The largest number of pods that may be unavailable after a disruption. Cannot be used with --min-available").String()
+	setMinAvailable   = cmdSet.Flag("min-available", "The smallest number of pods that must stay available after a disruption. Cannot be used with --max-unavailable").String()
+)
+
+// "get" command and flags
+var (
+	cmdGet   = kingpin.Command(cmdGetText, "Show a disruption budget")
+	getPodID = cmdGet.Flag("pod", "The pod ID the budget covers").Required().String()
+	getAZ    = cmdGet.Flag("az", "The availability zone of the pod cluster the budget covers").String()
+	getName  = cmdGet.Flag("name", "The cluster name of the pod cluster the budget covers").String()
+)
+
+// "delete" command and flags
+var (
+	cmdDelete   = kingpin.Command(cmdDeleteText, "Delete a disruption budget")
+	deletePodID = cmdDelete.Flag("pod", "The pod ID the budget covers").Required().String()
+	deleteAZ    = cmdDelete.Flag("az", "The availability zone of the pod cluster the budget covers").String()
+	deleteName  = cmdDelete.Flag("name", "The cluster name of the pod cluster the budget covers").String()
+)
+
+// "list" command
+var (
+	cmdList = kingpin.Command(cmdListText, "List disruption budgets")
+)
+
+func main() {
+	cmd, consulOpts, _ := flags.ParseWithConsulOptions()
+	client := consul.NewConsulClient(consulOpts)
+	store := disruptionbudgetstore.NewConsul(client)
+
+	switch cmd {
+	case cmdSetText:
+		err := store.Set(fields.Budget{
+			PodID:            types.PodID(*setPodID),
+			AvailabilityZone: pc_fields.AvailabilityZone(*setAZ),
+			ClusterName:      pc_fields.ClusterName(*setName),
+			MaxUnavailable:   parseLimit("max unavailable", *setMaxUnavailable),
+			MinAvailable:     parseLimit("min available", *setMinAvailable),
+		})
+		if err != nil {
+			log.Fatalf("Could not set disruption budget: %s", err)
+		}
+	case cmdGetText:
+		budget, err := store.Get(types.PodID(*getPodID), pc_fields.AvailabilityZone(*getAZ), pc_fields.ClusterName(*getName))
+		if err != nil {
+			log.Fatalf("Could not get disruption budget: %s", err)
+		}
+		printJSON(budget)
+	case cmdDeleteText:
+		err := store.Delete(types.PodID(*deletePodID), pc_fields.AvailabilityZone(*deleteAZ), pc_fields.ClusterName(*deleteName))
+		if err != nil {
+			log.Fatalf("Could not delete disruption budget: %s", err)
+		}
+	case cmdListText:
+		budgets, err := store.List()
+		if err != nil {
+			log.Fatalf("Could not list disruption budgets: %s", err)
+		}
+		printJSON(budgets)
+	default:
+		log.Fatalf("Unrecognized command %v", cmd)
+	}
+}
+
+// parseLimit parses the value of a budget limit flag, which is nil if the flag
+// wasn't passed
+func parseLimit(name string, value string) *int {
+	if value == "" {
+		return nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("Invalid value for %s, expected integer", name)
+	}
+	return fields.Limit(limit)
+}
+
+func printJSON(v interface{}) {
+	bytes, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not marshal as JSON: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(string(bytes))
+}
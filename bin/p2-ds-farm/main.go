@@ -8,11 +8,13 @@ import (
 
 	"github.com/hashicorp/consul/api"
 	"github.com/sirupsen/logrus"
+	"github.com/square/p2/pkg/disruptionbudget"
 	"github.com/square/p2/pkg/health/checker"
 	"github.com/square/p2/pkg/labels"
 	"github.com/square/p2/pkg/logging"
 	"github.com/square/p2/pkg/store/consul"
 	"github.com/square/p2/pkg/store/consul/consulutil"
+	"github.com/square/p2/pkg/store/consul/disruptionbudgetstore"
 	"github.com/square/p2/pkg/store/consul/dsstore"
 	"github.com/square/p2/pkg/store/consul/flags"
 	"github.com/square/p2/pkg/store/consul/statusstore"
@@ -76,7 +78,9 @@ func main() {
 		*useCachePodMatches,
 		1*time.Second,
 		ds_farm.DefaultRetryInterval,
-		ds_farm.DSFarmConfig{},
+		ds_farm.DSFarmConfig{
+			DisruptionBudgets: disruptionbudget.NewChecker(disruptionbudgetstore.NewConsul(client), labeler, healthChecker, 0),
+		},
 	)
 
 	go func() {
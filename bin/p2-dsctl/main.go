@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"os/user"
 	"strconv"
+	"text/tabwriter"
 	"time"
 
 	"gopkg.in/alecthomas/kingpin.v2"
@@ -20,6 +22,8 @@ import (
 	"github.com/square/p2/pkg/manifest"
 	"github.com/square/p2/pkg/scheduler"
 	"github.com/square/p2/pkg/store/consul"
+	"github.com/square/p2/pkg/store/consul/auditlogstore"
+	"github.com/square/p2/pkg/store/consul/consulutil"
 	"github.com/square/p2/pkg/store/consul/dsstore"
 	"github.com/square/p2/pkg/store/consul/flags"
 	"github.com/square/p2/pkg/store/consul/transaction"
@@ -37,6 +41,8 @@ const (
 	CmdDelete       = "delete"
 	CmdUpdate       = "update"
 	CmdTestSelector = "test-selector"
+	CmdHistory      = "history"
+	CmdRollback     = "rollback"
 
 	TimeoutNotSpecified = time.Duration(-1)
 )
@@ -56,6 +62,8 @@ var (
 	createName       = cmdCreate.Flag("name", "The cluster name (ie. staging, production)").Required().String()
 	createTimeout    = cmdCreate.Flag("timeout", "Non-zero timeout for replicating hosts. e.g. 1m2s for 1 minute and 2 seconds").Required().Duration()
 	createEverywhere = cmdCreate.Flag("everywhere", "Sets selector to match everything regardless of its value").Bool()
+	createInFlight   = cmdCreate.Flag("max-in-flight", "The number of nodes to deploy to at once, either a count (e.g. 10) or a percentage of eligible nodes (e.g. 25%). Defaults to the replication's maximum").String()
+	createCanaries   = cmdCreate.Flag("canary-nodes", "The number of nodes that must be deployed to and healthy with a new manifest before any other nodes are deployed to").Int()
 
 	cmdGet = kingpin.Command(CmdGet, "Show a daemon set.")
 	getID  = cmdGet.Arg("id", "The uuid for the daemon set").Required().String()
@@ -82,6 +90,18 @@ var (
 	updateName          = cmdUpdate.Flag("name", "The cluster name (ie. staging, production)").String()
 	updateTimeout       = cmdUpdate.Flag("timeout", "Non-zero timeout for replicating hosts. e.g. 1m2s for 1 minute and 2 seconds").Default(TimeoutNotSpecified.String()).Duration()
 	updateEverywhere    = cmdUpdate.Flag("everywhere", "Sets selector to match everything regardless of its value").Bool()
+	updateInFlightGiven = false
+	updateInFlight      = cmdUpdate.Flag("max-in-flight", "The number of nodes to deploy to at once, either a count (e.g. 10) or a percentage of eligible nodes (e.g. 25%). An empty value restores the default").Action(flagUsed(&updateInFlightGiven)).String()
+	updateCanariesGiven = false
+	updateCanaries      = cmdUpdate.Flag("canary-nodes", "The number of nodes that must be deployed to and healthy with a new manifest before any other nodes are deployed to. Setting this to 0 resumes a rollout stopped by a failed canary").Action(flagUsed(&updateCanariesGiven)).Int()
+
+	cmdHistory  = kingpin.Command(CmdHistory, "List the revisions of a daemon set's manifest and node selector.")
+	historyID   = cmdHistory.Arg("id", "The uuid for the daemon set").Required().String()
+	historyJSON = cmdHistory.Flag("json", "output the entire JSON object of each revision").Short('j').Bool()
+
+	cmdRollback      = kingpin.Command(CmdRollback, "Roll a daemon set back to an earlier revision of its manifest and node selector.")
+	rollbackID       = cmdRollback.Arg("id", "The uuid for the daemon set").Required().String()
+	rollbackRevision = cmdRollback.Flag("revision", "The revision to roll back to, as listed by the history command").Required().Int()
 
 	cmdTestSelector = kingpin.Command(CmdTestSelector, `
 		This will output the hosts that match the selector,
@@ -118,6 +138,11 @@ func main() {
 			log.Fatalf("Timeout must be a positive non-zero value, got '%v'", *createTimeout)
 		}
 
+		maxInFlight := ds_fields.MaxInFlight(*createInFlight)
+		if err = maxInFlight.Validate(); err != nil {
+			log.Fatalf("%s", err)
+		}
+
 		selectorString := *createSelector
 		if *createEverywhere {
 			selectorString = klabels.Everything().String()
@@ -136,7 +161,7 @@ func main() {
 
 		ctx, cancelFunc := transaction.New(context.Background())
 		defer cancelFunc()
-		newDS, err := dsstore.Create(ctx, manifest, minHealth, name, selector, podID, *createTimeout)
+		newDS, err := dsstore.Create(ctx, manifest, minHealth, name, selector, podID, *createTimeout, createOptions(maxInFlight, *createCanaries))
 		if err != nil {
 			log.Fatalf("err: %v", err)
 		}
@@ -270,6 +295,25 @@ func main() {
 					ds.Timeout = *updateTimeout
 				}
 			}
+			if updateInFlightGiven {
+				maxInFlight := ds_fields.MaxInFlight(*updateInFlight)
+				if err := maxInFlight.Validate(); err != nil {
+					return ds, err
+				}
+				if ds.MaxInFlight != maxInFlight {
+					changed = true
+					ds.MaxInFlight = maxInFlight
+				}
+			}
+			if updateCanariesGiven {
+				if *updateCanaries < 0 {
+					return ds, util.Errorf("Canary nodes cannot be negative, got %d", *updateCanaries)
+				}
+				if ds.CanaryNodes != *updateCanaries {
+					changed = true
+					ds.CanaryNodes = *updateCanaries
+				}
+			}
 			if *updateManifest != "" {
 				manifest, err := manifest.FromPath(*updateManifest)
 				if err != nil {
@@ -330,6 +374,67 @@ func main() {
 		fmt.Printf("The daemon set '%s' has been successfully updated in consul", id.String())
 		fmt.Println()
 
+	case CmdHistory:
+		id := ds_fields.ID(*historyID)
+		revisions, err := dsstore.History(id)
+		if err != nil {
+			log.Fatalf("err: %v", err)
+		}
+
+		if *historyJSON {
+			for _, revision := range revisions {
+				bytes, err := json.Marshal(revision)
+				if err != nil {
+					log.Fatalf("could not marshal revision %d as json: %s", revision.Number, err)
+				}
+				fmt.Println(string(bytes))
+			}
+			break
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "REVISION\tCREATED\tMANIFEST SHA\tNODE SELECTOR")
+		for _, revision := range revisions {
+			sha, err := revision.Manifest.SHA()
+			if err != nil {
+				log.Fatalf("Unable to get SHA from manifest of revision %d: %v", revision.Number, err)
+			}
+			created := "unknown"
+			if !revision.Created.IsZero() {
+				created = revision.Created.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", revision.Number, created, sha, revision.NodeSelector)
+		}
+		w.Flush()
+
+	case CmdRollback:
+		id := ds_fields.ID(*rollbackID)
+		current, _, err := dsstore.Get(id)
+		if err != nil {
+			log.Fatalf("err: %v", err)
+		}
+		revision, err := dsstore.GetRevision(id, *rollbackRevision)
+		if err != nil {
+			log.Fatalf("Could not find revision %d: %v", *rollbackRevision, err)
+		}
+
+		// the node selector may change as well as the manifest, so give
+		// the user the same chance to back out as the update command
+		_, err = parseNodeSelectorWithPrompt(current.NodeSelector, revision.NodeSelector.String(), applicator)
+		if err != nil {
+			log.Fatalf("Error occurred: %v", err)
+		}
+		if err = confirmMinheathForSelector(current.MinHealth, revision.NodeSelector, applicator); err != nil {
+			log.Fatalf("Error occurred: %v", err)
+		}
+
+		err = rollbackDS(dsstore, client.KV(), id, *rollbackRevision)
+		if err != nil {
+			log.Fatalf("err: %v", err)
+		}
+		fmt.Printf("The daemon set '%s' has been rolled back to revision %d in consul", id.String(), *rollbackRevision)
+		fmt.Println()
+
 	case CmdTestSelector:
 		selectorString := *testSelectorString
 		if *testSelectorEverywhere {
@@ -389,6 +494,37 @@ func parseNodeSelectorWithPrompt(
 	return newSelector, nil
 }
 
+// createOptions returns the rollout settings of a new daemon set. It lives
+// outside main() because the store variable there shadows the dsstore package
+func createOptions(maxInFlight ds_fields.MaxInFlight, canaryNodes int) dsstore.CreateOptions {
+	return dsstore.CreateOptions{
+		MaxInFlight: maxInFlight,
+		CanaryNodes: canaryNodes,
+	}
+}
+
+// rollbackDS rolls the daemon set back to a revision, recording the rollback
+// in the audit log
+func rollbackDS(store *dsstore.ConsulStore, kv consulutil.ConsulKVClient, id ds_fields.ID, revision int) error {
+	auditingStore := dsstore.NewAuditingStore(store, auditlogstore.NewConsulStore(kv))
+	ctx, cancelFunc := transaction.New(context.Background())
+	defer cancelFunc()
+	_, err := auditingStore.Rollback(ctx, id, revision, currentUserName())
+	if err != nil {
+		return err
+	}
+	return transaction.MustCommit(ctx, kv)
+}
+
+func currentUserName() string {
+	username := "unknown user"
+
+	if user, err := user.Current(); err == nil {
+		username = user.Username
+	}
+	return username
+}
+
 func confirmMinheathForSelector(minHealth int, selector klabels.Selector, applicator labels.ApplicatorWithoutWatches) error {
 	matches, err := applicator.GetMatches(selector, labels.NODE)
 	if err != nil {
@@ -404,7 +540,7 @@ func confirmMinheathForSelector(minHealth int, selector klabels.Selector, applic
 }
 
 func parseNodeSelector(selectorString string) (klabels.Selector, error) {
-	selector, err := klabels.Parse(selectorString)
+	selector, err := labels.ParseSelector(selectorString)
 	if err != nil {
 		return selector, util.Errorf("Malformed selector: %v", err)
 	}
@@ -5,14 +5,18 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"time"
 
 	"gopkg.in/alecthomas/kingpin.v2"
 
+	"github.com/square/p2/pkg/health"
 	"github.com/square/p2/pkg/health/checker"
 	"github.com/square/p2/pkg/inspect"
+	"github.com/square/p2/pkg/launch"
 	"github.com/square/p2/pkg/store/consul"
 	"github.com/square/p2/pkg/store/consul/consulutil"
 	"github.com/square/p2/pkg/store/consul/flags"
+	"github.com/square/p2/pkg/store/consul/nodestore"
 	"github.com/square/p2/pkg/types"
 	"github.com/square/p2/pkg/version"
 )
@@ -21,14 +25,31 @@ var (
 	nodeArg = kingpin.Flag("node", "The node to inspect. By default, all nodes are shown.").String()
 	podArg  = kingpin.Flag("pod", "The pod manifest ID to inspect. By default, all pods are shown.").String()
 	format  = kingpin.Flag("format", "Display format").Default("tree").Enum("tree", "list")
+
+	nodesArg   = kingpin.Flag("nodes", "Show the inventory records published by each node's preparer instead of pods.").Bool()
+	staleAfter = kingpin.Flag("stale-after", "With --nodes, how old a record can be before its node is reported as stale.").Default("5m").Duration()
 )
 
+// nodeRecord is a node inventory record as displayed by --nodes
+type nodeRecord struct {
+	nodestore.Record
+	Stale bool `json:"stale"`
+}
+
 func main() {
 	kingpin.Version(version.VERSION)
 	_, opts, _ := flags.ParseWithConsulOptions()
 	client := consul.NewConsulClient(opts)
 	store := consul.NewConsulStore(client)
 
+	if *nodesArg {
+		err := printNodeRecords(nodestore.NewConsul(client), types.NodeName(*nodeArg), *staleAfter)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	var intents []consul.ManifestResult
 	var realities []consul.ManifestResult
 	var err error
@@ -92,6 +113,12 @@ func main() {
 
 			old := statusMap[podID][node]
 			old.Health = result.Status
+			if len(result.Launchables) > 0 {
+				old.LaunchableHealth = make(map[launch.LaunchableID]health.HealthState)
+				for launchableID, status := range result.Launchables {
+					old.LaunchableHealth[launch.LaunchableID(launchableID)] = status
+				}
+			}
 			statusMap[podID][node] = old
 		}
 	}
@@ -122,3 +149,31 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+func printNodeRecords(nodeStore *nodestore.ConsulStore, filterNodeName types.NodeName, staleAfter time.Duration) error {
+	var records []nodestore.Record
+	if filterNodeName != "" {
+		record, err := nodeStore.Get(filterNodeName)
+		if err == nodestore.NoRecord {
+			return fmt.Errorf("%s has not published an inventory record", filterNodeName)
+		} else if err != nil {
+			return err
+		}
+		records = append(records, record)
+	} else {
+		var err error
+		records, err = nodeStore.List()
+		if err != nil {
+			return err
+		}
+	}
+
+	output := make([]nodeRecord, 0, len(records))
+	for _, record := range records {
+		output = append(output, nodeRecord{
+			Record: record,
+			Stale:  record.Stale(staleAfter),
+		})
+	}
+	return json.NewEncoder(os.Stdout).Encode(output)
+}
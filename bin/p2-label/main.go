@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/square/p2/pkg/labels"
 	"github.com/square/p2/pkg/store/consul/flags"
@@ -32,13 +34,21 @@ Example:
 	showLabelType = cmdShow.Flag("labelType", "The type of label to adjust. Sometimes called the \"label tree\". Supported types can be found here:\n\thttps://godoc.org/github.com/square/p2/pkg/labels#pkg-constants").Short('t').Required().String()
 	showID        = cmdShow.Flag("id", "The ID of the entity to show labels for.").Short('i').Required().String()
 
+	cmdHistory       = kingpin.Command(CmdHistory, "Show changes made to labels. Pass --id to show the changes to one entity, or --key to show the changes to a label key on every entity of the type. Only the label types given by --label-history-type when the changes were made are recorded, node labels by default")
+	historyLabelType = cmdHistory.Flag("labelType", "The type of label to show the history of. Sometimes called the \"label tree\". Supported types can be found here:\n\thttps://godoc.org/github.com/square/p2/pkg/labels#pkg-constants").Short('t').Required().String()
+	historyID        = cmdHistory.Flag("id", "The ID of the entity to show label changes for. Exclusive with key").Short('i').String()
+	historyKey       = cmdHistory.Flag("key", "The label key to show changes to. Exclusive with ID").Short('k').String()
+	historySince     = cmdHistory.Flag("since", "How far back to show changes").Default("24h").Duration()
+	historyAt        = cmdHistory.Flag("at", "Instead of listing changes, show the labels the entity given by --id had at this RFC3339 time, e.g. 2017-04-05T15:04:05Z").String()
+
 	// autoConfirm captures the confirmation desire abstractly across commands
 	autoConfirm = false
 )
 
 const (
-	CmdApply = "apply"
-	CmdShow  = "show"
+	CmdApply   = "apply"
+	CmdShow    = "show"
+	CmdHistory = "history"
 )
 
 func main() {
@@ -83,7 +93,7 @@ func main() {
 
 		var matches []labels.Labeled
 		if *applySubjectSelector != "" {
-			subject, err := klabels.Parse(*applySubjectSelector)
+			subject, err := labels.ParseSelector(*applySubjectSelector)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "Error while parsing subject label. Check the syntax.\n%v\n", err)
 				exitCode = 1
@@ -132,11 +142,88 @@ func main() {
 			fmt.Printf("%s/%s: %s\n", labelType, entityID, labelsForEntity.Labels.String())
 		}
 		break
+	case CmdHistory:
+		exitCode = showHistory(applicator)
 	}
 
 	os.Exit(exitCode)
 }
 
+func showHistory(applicator labels.ApplicatorWithoutWatches) int {
+	history, ok := applicator.(labels.HistoryReader)
+	if !ok {
+		fmt.Fprintln(os.Stderr, "Label history can only be read from consul. Don't pass --http-applicator-url")
+		return 1
+	}
+	// if xnor(key, id)
+	if (*historyKey == "") == (*historyID == "") {
+		fmt.Fprintln(os.Stderr, "Must pass either an ID or a label key to show the history of")
+		return 1
+	}
+	labelType, err := labels.AsType(*historyLabelType)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unrecognized type %s. Check the commandline and documentation.\nhttps://godoc.org/github.com/square/p2/pkg/labels#pkg-constants\n", *historyLabelType)
+		return 1
+	}
+
+	if *historyAt != "" {
+		if *historyID == "" {
+			fmt.Fprintln(os.Stderr, "--at requires --id")
+			return 1
+		}
+		at, err := time.Parse(time.RFC3339, *historyAt)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not parse %q as an RFC3339 time: %s\n", *historyAt, err)
+			return 1
+		}
+		labeled, err := history.LabelsAt(labelType, *historyID, at)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Got error while querying label history. %v\n", err)
+			return 1
+		}
+		fmt.Printf("%s/%s at %s: %s\n", labelType, *historyID, at.Format(time.RFC3339), labeled.Labels.String())
+		return 0
+	}
+
+	since := time.Now().Add(-*historySince)
+	var changes []labels.LabelChange
+	if *historyID != "" {
+		changes, err = history.LabelHistory(labelType, *historyID, since)
+	} else {
+		changes, err = history.KeyHistory(labelType, *historyKey, since)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Got error while querying label history. %v\n", err)
+		return 1
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tID\tAUTHOR\tCHANGES")
+	for _, change := range changes {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", change.Time.Local().Format(time.RFC3339), change.ID, change.Author, describeChange(change))
+	}
+	_ = w.Flush()
+	return 0
+}
+
+// describeChange formats a change as e.g. "+foo=bar -baz=qux ~a=1->2"
+func describeChange(change labels.LabelChange) string {
+	var parts []string
+	for _, key := range change.ChangedKeys() {
+		before, hadKey := change.Before[key]
+		after, hasKey := change.After[key]
+		switch {
+		case !hadKey:
+			parts = append(parts, fmt.Sprintf("+%s=%s", key, after))
+		case !hasKey:
+			parts = append(parts, fmt.Sprintf("-%s=%s", key, before))
+		default:
+			parts = append(parts, fmt.Sprintf("~%s=%s->%s", key, before, after))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
 func applyLabels(applicator labels.ApplicatorWithoutWatches, entityID string, labelType labels.Type, additiveLabels map[string]string, destructiveKeys []string) error {
 	var err error
 	if !confirm(fmt.Sprintf("mutate the labels for %s/%s", labelType, entityID)) {
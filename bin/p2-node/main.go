@@ -0,0 +1,164 @@
+// p2-node takes nodes out of service and puts them back into service.
+//
+// Cordoning a node makes it ineligible for every replication controller and
+// daemon set. Draining a node cordons it and then waits for the replication
+// controllers with pods on it to transfer them elsewhere, which they do only
+// as their min health allows. Uncordoning a node makes it eligible again.
+// Decommissioning a drained node whose preparer has stopped cordons it for
+// good and deletes its inventory record, so it is not reported as failed.
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/user"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/square/p2/pkg/labels"
+	"github.com/square/p2/pkg/logging"
+	"github.com/square/p2/pkg/node"
+	"github.com/square/p2/pkg/store/consul"
+	"github.com/square/p2/pkg/store/consul/auditlogstore"
+	"github.com/square/p2/pkg/store/consul/flags"
+	"github.com/square/p2/pkg/store/consul/nodestore"
+	"github.com/square/p2/pkg/store/consul/rcstore"
+	"github.com/square/p2/pkg/types"
+	"github.com/square/p2/pkg/version"
+)
+
+const (
+	cmdCordonText       = "cordon"
+	cmdUncordonText     = "uncordon"
+	cmdDrainText        = "drain"
+	cmdDecommissionText = "decommission"
+	cmdStatusText       = "status"
+)
+
+var (
+	logLevel = kingpin.Flag("log", "Logging level to display").String()
+)
+
+// "cordon" command and flags
+var (
+	cmdCordon    = kingpin.Command(cmdCordonText, "Make a node ineligible for every replication controller and daemon set")
+	cordonNode   = cmdCordon.Arg("node", "The node to cordon").Required().String()
+	cordonReason = cmdCordon.Flag("reason", "Why the node is being taken out of service, recorded in the audit log").Required().String()
+)
+
+// "uncordon" command and flags
+var (
+	cmdUncordon    = kingpin.Command(cmdUncordonText, "Make a cordoned node eligible again")
+	uncordonNode   = cmdUncordon.Arg("node", "The node to uncordon").Required().String()
+	uncordonReason = cmdUncordon.Flag("reason", "Why the node is being put back into service, recorded in the audit log").Required().String()
+)
+
+// "drain" command and flags
+var (
+	cmdDrain     = kingpin.Command(cmdDrainText, "Cordon a node and wait for its replication controller pods to be transferred to other nodes")
+	drainNode    = cmdDrain.Arg("node", "The node to drain").Required().String()
+	drainReason  = cmdDrain.Flag("reason", "Why the node is being taken out of service, recorded in the audit log").Required().String()
+	drainTimeout = cmdDrain.Flag("timeout", "How long to wait for pods to be transferred. The node stays cordoned if the timeout is reached").Default("1h").Duration()
+)
+
+// "decommission" command and flags
+var (
+	cmdDecommission    = kingpin.Command(cmdDecommissionText, "Take a drained node whose preparer has stopped out of service for good by cordoning it and deleting its inventory record")
+	decommissionNode   = cmdDecommission.Arg("node", "The node to decommission").Required().String()
+	decommissionReason = cmdDecommission.Flag("reason", "Why the node is being decommissioned, recorded in the audit log").Required().String()
+)
+
+// "status" command and flags
+var (
+	cmdStatus  = kingpin.Command(cmdStatusText, "Show whether a node is cordoned")
+	statusNode = cmdStatus.Arg("node", "The node to show").Required().String()
+)
+
+func main() {
+	kingpin.Version(version.VERSION)
+	cmd, opts, _ := flags.ParseWithConsulOptions()
+
+	logger := logging.NewLogger(logrus.Fields{})
+	logger.Logger.Formatter = new(logrus.TextFormatter)
+	if *logLevel != "" {
+		lv, err := logrus.ParseLevel(*logLevel)
+		if err != nil {
+			logger.WithErrorAndFields(err, logrus.Fields{"level": *logLevel}).
+				Fatalln("Could not parse log level")
+		}
+		logger.Logger.Level = lv
+	}
+
+	client := consul.NewConsulClient(opts)
+
+	// cordoning uses transactions, which the applicator returned by
+	// flags.ParseWithConsulOptions() may not support
+	labeler := labels.NewConsulApplicator(client, 0, 0)
+
+	manager := node.NewManager(
+		labeler,
+		consul.NewConsulStore(client),
+		rcstore.NewConsul(client, labeler, 3),
+		nodestore.NewConsul(client),
+		auditlogstore.NewConsulStore(client.KV()),
+		client.KV(),
+		logger,
+		currentUserName(),
+	)
+
+	switch cmd {
+	case cmdCordonText:
+		err := manager.Cordon(types.NodeName(*cordonNode), *cordonReason)
+		if err != nil {
+			logger.WithError(err).Fatalln("Could not cordon node")
+		}
+	case cmdUncordonText:
+		err := manager.Uncordon(types.NodeName(*uncordonNode), *uncordonReason)
+		if err != nil {
+			logger.WithError(err).Fatalln("Could not uncordon node")
+		}
+	case cmdDrainText:
+		ctx, cancel := context.WithTimeout(context.Background(), *drainTimeout)
+		defer cancel()
+
+		start := time.Now()
+		remaining, err := manager.Drain(ctx, types.NodeName(*drainNode), *drainReason)
+		for _, podID := range remaining {
+			fmt.Printf("%s was not transferred\n", podID)
+		}
+		if err != nil {
+			logger.WithError(err).Fatalln("Could not drain node")
+		}
+		logger.Infof("Drained %s in %s", *drainNode, time.Since(start))
+	case cmdDecommissionText:
+		err := manager.Decommission(types.NodeName(*decommissionNode), *decommissionReason)
+		if err != nil {
+			logger.WithError(err).Fatalln("Could not decommission node")
+		}
+	case cmdStatusText:
+		cordoned, err := manager.IsCordoned(types.NodeName(*statusNode))
+		if err != nil {
+			logger.WithError(err).Fatalln("Could not get node labels")
+		}
+		if cordoned {
+			fmt.Printf("%s is cordoned\n", *statusNode)
+		} else {
+			fmt.Printf("%s is not cordoned\n", *statusNode)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "Unrecognized command %v\n", cmd)
+		os.Exit(1)
+	}
+}
+
+func currentUserName() string {
+	username := "unknown user"
+
+	if user, err := user.Current(); err == nil {
+		username = user.Username
+	}
+	return username
+}
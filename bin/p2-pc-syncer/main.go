@@ -0,0 +1,101 @@
+// p2-pc-syncer keeps a local load balancer or service discovery config file
+// up to date with the pods of every pod cluster. The config is rendered from
+// a template, either one of the built-in HAProxy and Envoy EDS templates or
+// a custom one.
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/square/p2/pkg/health/checker"
+	"github.com/square/p2/pkg/labels"
+	"github.com/square/p2/pkg/logging"
+	"github.com/square/p2/pkg/pc/lbconfig"
+	"github.com/square/p2/pkg/store/consul"
+	"github.com/square/p2/pkg/store/consul/flags"
+	"github.com/square/p2/pkg/store/consul/pcstore"
+	"github.com/square/p2/pkg/version"
+)
+
+const (
+	formatHAProxy   = "haproxy"
+	formatEnvoyEDS  = "envoy-eds"
+	formatTemplated = "template"
+)
+
+var (
+	logLevel      = kingpin.Flag("log", "Logging level to display").String()
+	outputPath    = kingpin.Flag("output", "The config file to write").Required().String()
+	format        = kingpin.Flag("format", "The built-in template to render, or \"template\" to render the file given by --template").Default(formatHAProxy).Enum(formatHAProxy, formatEnvoyEDS, formatTemplated)
+	templatePath  = kingpin.Flag("template", "A Go text/template file to render when --format=template. It is passed an lbconfig.Config").ExistingFile()
+	reloadCommand = kingpin.Flag("reload-command", "A command to run each time the config file changes, e.g. to reload the load balancer. Arguments are given by repeating --reload-arg").String()
+	reloadArgs    = kingpin.Flag("reload-arg", "An argument to the reload command. Can be specified multiple times").Strings()
+	withHealth    = kingpin.Flag("health", "Watch the health of each pod cluster's pods so that unhealthy pods are disabled in the config").Bool()
+)
+
+func main() {
+	kingpin.Version(version.VERSION)
+	_, opts, applicator := flags.ParseWithConsulOptions()
+
+	logger := logging.NewLogger(logrus.Fields{})
+	logger.Logger.Formatter = new(logrus.TextFormatter)
+	if *logLevel != "" {
+		lv, err := logrus.ParseLevel(*logLevel)
+		if err != nil {
+			logger.WithErrorAndFields(err, logrus.Fields{"level": *logLevel}).
+				Fatalln("Could not parse log level")
+		}
+		logger.Logger.Level = lv
+	}
+
+	templateText := lbconfig.HAProxyTemplate
+	switch *format {
+	case formatEnvoyEDS:
+		templateText = lbconfig.EnvoyEDSTemplate
+	case formatTemplated:
+		if *templatePath == "" {
+			logger.Fatalln("--template is required when --format=template")
+		}
+		contents, err := ioutil.ReadFile(*templatePath)
+		if err != nil {
+			logger.WithError(err).Fatalln("Could not read template")
+		}
+		templateText = string(contents)
+	}
+	tmpl, err := lbconfig.ParseTemplate(*format, templateText)
+	if err != nil {
+		logger.WithError(err).Fatalln("Could not parse template")
+	}
+
+	var reload []string
+	if *reloadCommand != "" {
+		reload = append([]string{*reloadCommand}, *reloadArgs...)
+	}
+
+	client := consul.NewConsulClient(opts)
+	pcStore := pcstore.NewConsul(client, applicator, labels.DefaultAggregationRate, labels.NewConsulApplicator(client, 0, 0), &logger)
+	if *withHealth {
+		pcStore.SetHealthChecker(checker.NewHealthChecker(client), time.Second)
+	}
+	syncer := lbconfig.NewSyncer(consul.NewConsulStore(client), pcStore, tmpl, *outputPath, reload, logger)
+
+	quitCh := make(chan struct{})
+	go func() {
+		signalCh := make(chan os.Signal, 2)
+		signal.Notify(signalCh, syscall.SIGTERM, os.Interrupt)
+		received := <-signalCh
+		logger.Warnf("Received %v, shutting down", received)
+		close(quitCh)
+	}()
+
+	if err := pcStore.WatchAndSync(syncer, quitCh); err != nil {
+		logger.WithError(err).Fatalln("Error syncing pod clusters")
+	}
+}
@@ -281,7 +281,7 @@ func main() {
 			}
 		}
 
-		newSelector, err := klabels.Parse(*updateSelector)
+		newSelector, err := labels.ParseSelector(*updateSelector)
 		if err != nil {
 			log.Fatalf("could not parse %q as label selector: %s", *updateSelector, err)
 		}
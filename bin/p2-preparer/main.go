@@ -86,6 +86,12 @@ func main() {
 
 	go prep.WatchForPodManifestsForNode(quitMainUpdate)
 
+	// Publish this node's inventory so that controllers can tell it is
+	// alive and what it is running
+	quitNodeInventory := make(chan struct{})
+	quitChans = append(quitChans, quitNodeInventory)
+	go prep.PublishNodeInventory(quitNodeInventory)
+
 	if prep.PodProcessReporter != nil {
 		quitPodProcessReporter := make(chan struct{})
 		quitChans = append(quitChans, quitPodProcessReporter)
@@ -102,6 +108,12 @@ func main() {
 		watch.MonitorPodHealth(preparerConfig, &logger, quitMonitorPodHealth)
 	}()
 
+	// Launch liveness watch. This watch restarts launchables on this host
+	// whose liveness checks fail
+	quitMonitorLiveness := make(chan struct{})
+	quitChans = append(quitChans, quitMonitorLiveness)
+	go watch.MonitorLiveness(preparerConfig, prep, &logger, quitMonitorLiveness)
+
 	waitForTermination(logger, quitMainUpdate, quitChans)
 
 	// The preparer should continue to report app health during a shutdown, so terminate
@@ -5,6 +5,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/hashicorp/consul/api"
@@ -16,20 +17,29 @@ import (
 
 	"github.com/square/p2/pkg/alerting"
 	"github.com/square/p2/pkg/artifact"
+	"github.com/square/p2/pkg/autoscale"
+	"github.com/square/p2/pkg/disruptionbudget"
 	"github.com/square/p2/pkg/health/checker"
+	"github.com/square/p2/pkg/labels"
 	"github.com/square/p2/pkg/logging"
 	"github.com/square/p2/pkg/osversion"
 	"github.com/square/p2/pkg/rc"
+	"github.com/square/p2/pkg/replicaschedule"
 	"github.com/square/p2/pkg/roll"
 	"github.com/square/p2/pkg/scheduler"
 	"github.com/square/p2/pkg/store/consul"
 	"github.com/square/p2/pkg/store/consul/auditlogstore"
+	"github.com/square/p2/pkg/store/consul/autoscalestore"
 	"github.com/square/p2/pkg/store/consul/consulutil"
+	"github.com/square/p2/pkg/store/consul/disruptionbudgetstore"
 	"github.com/square/p2/pkg/store/consul/flags"
+	"github.com/square/p2/pkg/store/consul/nodestore"
 	"github.com/square/p2/pkg/store/consul/rcstore"
+	"github.com/square/p2/pkg/store/consul/replicaschedulestore"
 	"github.com/square/p2/pkg/store/consul/rollstore"
 	"github.com/square/p2/pkg/store/consul/statusstore"
 	"github.com/square/p2/pkg/store/consul/statusstore/rcstatus"
+	"github.com/square/p2/pkg/store/consul/statusstore/rustatus"
 	"github.com/square/p2/pkg/uri"
 	"github.com/square/p2/pkg/util/stream"
 	"github.com/square/p2/pkg/version"
@@ -37,8 +47,15 @@ import (
 
 // Command arguments
 var (
-	logLevel            = kingpin.Flag("log", "Logging level to display").String()
-	pagerdutyServiceKey = kingpin.Flag("pagerduty-service-key", "Pagerduty Service Key to use for alerting if provided").String()
+	logLevel               = kingpin.Flag("log", "Logging level to display").String()
+	pagerdutyServiceKey    = kingpin.Flag("pagerduty-service-key", "Pagerduty Service Key to use for alerting if provided").String()
+	runAutoscaler          = kingpin.Flag("autoscale", "Run the autoscaler farm, which adjusts the replica counts of RCs that have autoscaler policies").Bool()
+	runReplicaSchedules    = kingpin.Flag("replica-schedules", "Run the replica schedule farm, which changes the replica counts of RCs at the times given by their replica schedules").Bool()
+	nodeFailureTimeout     = kingpin.Flag("node-failure-timeout", "If set, treat nodes whose preparer hasn't published an inventory record for this long as failed. Pods of RCs with the dynamic allocation strategy are transferred off of failed nodes").Duration()
+	nodeFailureMaxFraction = kingpin.Flag("node-failure-max-fraction", "With --node-failure-timeout, the largest fraction of nodes that may be treated as failed at once. If more nodes look failed, none are treated as failed").Default("0.1").Float64()
+	schedulerPolicy        = kingpin.Flag("resource-scheduler-policy", "If set, allocate nodes to RCs with the dynamic allocation strategy based on the CPU and memory capacity labels of nodes using the given policy. Nodes already running an RC's pods are allocated to it. By default, and for other RCs, every node matching an RC's node selector is eligible").Enum(string(scheduler.BinPackPolicy), string(scheduler.SpreadPolicy))
+	labelHistoryMaxAge     = kingpin.Flag("label-history-max-age", "Prune label history records older than this. Zero keeps records of any age").Default(labels.DefaultHistoryRetention.MaxAge.String()).Duration()
+	labelHistoryMaxCount   = kingpin.Flag("label-history-max-count", "Prune all but this many of the most recent label history records of each object. Zero keeps any number of records").Default(strconv.Itoa(labels.DefaultHistoryRetention.MaxCount)).Int()
 )
 
 // RetryCount defines the number of retries to attempt when accessing some storage
@@ -80,10 +97,15 @@ func main() {
 	consulStore := consul.NewConsulStore(client)
 	rcStore := rcstore.NewConsul(client, labeler, RetryCount)
 	rcStatusStore := rcstatus.NewConsul(statusStoreClient, consul.RCStatusNamespace)
+	ruStatusStore := rustatus.NewConsul(statusStoreClient, consul.RUStatusNamespace)
 
 	rollStore := rollstore.NewConsul(client, labeler, nil)
 	healthChecker := checker.NewHealthChecker(client)
-	sched := scheduler.NewApplicatorScheduler(labeler)
+	disruptions := disruptionbudget.NewChecker(disruptionbudgetstore.NewConsul(client), labeler, healthChecker, 0)
+	var sched rc.Scheduler = scheduler.NewApplicatorScheduler(labeler)
+	if *schedulerPolicy != "" {
+		sched = scheduler.NewResourceScheduler(labeler, client.KV(), client.KV(), scheduler.Policy(*schedulerPolicy))
+	}
 
 	// Start acquiring sessions
 	sessions := make(chan string)
@@ -114,6 +136,27 @@ func main() {
 	// Only works for local files
 	artifactRegistry := artifact.NewRegistry(nil, fetcher, osversion.DefaultDetector)
 
+	var nodeFailures rc.NodeFailureDetector
+	if *nodeFailureTimeout > 0 {
+		monitor := rc.NewNodeFailureMonitor(nodestore.NewConsul(client), *nodeFailureTimeout, *nodeFailureMaxFraction, alerter, logger)
+		go monitor.Run(nil)
+		nodeFailures = monitor
+	}
+
+	if *labelHistoryMaxAge > 0 || *labelHistoryMaxCount > 0 {
+		retention := labels.HistoryRetention{
+			MaxAge:   *labelHistoryMaxAge,
+			MaxCount: *labelHistoryMaxCount,
+		}
+		go labels.NewConsulApplicator(client, 0, 0).RunLockedHistoryPruner(
+			client,
+			pub.Subscribe().Chan(),
+			retention,
+			labels.DefaultHistoryPruneInterval,
+			nil,
+		)
+	}
+
 	// Run the farms!
 	go rc.NewFarm(
 		consulStore,
@@ -134,13 +177,50 @@ func main() {
 		1*time.Second,
 		artifactRegistry,
 		nil,
+		disruptions,
+		nodeFailures,
 	).Start(nil)
+	if *runAutoscaler {
+		go autoscale.NewFarm(
+			consulStore,
+			autoscalestore.NewConsul(client),
+			rcStore,
+			rcStore,
+			auditLogStore,
+			client.KV(),
+			httpClient,
+			pub.Subscribe().Chan(),
+			logger,
+			autoscale.FarmConfig{},
+		).Start(nil)
+	}
+	if *runReplicaSchedules {
+		go replicaschedule.NewFarm(
+			consulStore,
+			replicaschedulestore.NewConsul(client),
+			rcStore,
+			rcStore,
+			auditLogStore,
+			client.KV(),
+			pub.Subscribe().Chan(),
+			logger,
+			replicaschedule.FarmConfig{},
+		).Start(nil)
+	}
 	roll.NewFarm(
 		roll.UpdateFactory{
-			Store:         consulStore,
-			RCStore:       rcStore,
-			HealthChecker: healthChecker,
-			Labeler:       labeler,
+			Store:             consulStore,
+			Client:            client,
+			Txner:             client.KV(),
+			RCLocker:          rcStore,
+			RCStore:           rcStore,
+			RCStatusStore:     rcStatusStore,
+			RUStatusStore:     ruStatusStore,
+			RollStore:         rollStore,
+			HealthChecker:     healthChecker,
+			Labeler:           labeler,
+			Alerter:           alerter,
+			DisruptionBudgets: disruptions,
 		},
 		consulStore,
 		rollStore,
@@ -17,6 +17,7 @@ import (
 	klabels "k8s.io/kubernetes/pkg/labels"
 
 	"github.com/square/p2/pkg/alerting"
+	autoscale_fields "github.com/square/p2/pkg/autoscale/fields"
 	"github.com/square/p2/pkg/cli"
 	"github.com/square/p2/pkg/health/checker"
 	"github.com/square/p2/pkg/labels"
@@ -26,34 +27,49 @@ import (
 	"github.com/square/p2/pkg/rc"
 	"github.com/square/p2/pkg/rc/fields"
 	rc_fields "github.com/square/p2/pkg/rc/fields"
+	schedule_fields "github.com/square/p2/pkg/replicaschedule/fields"
 	"github.com/square/p2/pkg/roll"
 	roll_fields "github.com/square/p2/pkg/roll/fields"
 	"github.com/square/p2/pkg/store/consul"
 	"github.com/square/p2/pkg/store/consul/auditlogstore"
+	"github.com/square/p2/pkg/store/consul/autoscalestore"
 	"github.com/square/p2/pkg/store/consul/consulutil"
 	"github.com/square/p2/pkg/store/consul/flags"
 	"github.com/square/p2/pkg/store/consul/rcstore"
+	"github.com/square/p2/pkg/store/consul/replicaschedulestore"
 	"github.com/square/p2/pkg/store/consul/rollstore"
 	"github.com/square/p2/pkg/store/consul/statusstore"
 	"github.com/square/p2/pkg/store/consul/statusstore/rcstatus"
+	"github.com/square/p2/pkg/store/consul/statusstore/rustatus"
 	"github.com/square/p2/pkg/store/consul/transaction"
 	"github.com/square/p2/pkg/version"
 )
 
 const (
-	cmdCreateText         = "create"
-	cmdDeleteText         = "delete"
-	cmdReplicasText       = "set-replicas"
-	cmdListText           = "list"
-	cmdGetText            = "get"
-	cmdGetStatusText      = "get-status"
-	cmdEnableText         = "enable"
-	cmdDisableText        = "disable"
-	cmdRollText           = "rolling-update"
-	cmdDeleteRollText     = "delete-rolling-update"
-	cmdSchedupText        = "schedule-update"
-	cmdUpdateManifestText = "update-manifest"
-	cmdUpdateStrategyText = "update-strategy"
+	cmdCreateText          = "create"
+	cmdDeleteText          = "delete"
+	cmdReplicasText        = "set-replicas"
+	cmdListText            = "list"
+	cmdGetText             = "get"
+	cmdGetStatusText       = "get-status"
+	cmdEnableText          = "enable"
+	cmdDisableText         = "disable"
+	cmdRollText            = "rolling-update"
+	cmdDeleteRollText      = "delete-rolling-update"
+	cmdSchedupText         = "schedule-update"
+	cmdGetUpdateText       = "get-update"
+	cmdGetUpdateStatusText = "get-update-status"
+	cmdAdvanceUpdateText   = "advance-update"
+	cmdPauseUpdateText     = "pause-update"
+	cmdResumeUpdateText    = "resume-update"
+	cmdUpdateManifestText  = "update-manifest"
+	cmdUpdateStrategyText  = "update-strategy"
+	cmdSetAutoscalerText   = "set-autoscaler"
+	cmdGetAutoscalerText   = "get-autoscaler"
+	cmdDelAutoscalerText   = "delete-autoscaler"
+	cmdSetScheduleText     = "set-schedule"
+	cmdGetScheduleText     = "get-schedule"
+	cmdDelScheduleText     = "delete-schedule"
 )
 
 var (
@@ -68,6 +84,9 @@ var (
 	createAvailabilityZone   = cmdCreate.Flag("availability-zone", "availability zone that RC should belong to").Short('a').Required().String()
 	createClusterName        = cmdCreate.Flag("cluster-name", "availability zone that RC should belong to").Short('c').Required().String()
 	createAllocationStrategy = cmdCreate.Flag("allocation-strategy", "determines how RC will allocate new nodes").Short('s').Required().String()
+	createSpreadKey          = cmdCreate.Flag("spread-key", "a node label, such as rack, whose values are failure domains that replicas should be balanced across").String()
+	createMaxSkew            = cmdCreate.Flag("max-skew", "with --spread-key, the largest allowed difference in replica count between failure domains").Default("1").Int()
+	createAntiAffinity       = cmdCreate.Flag("anti-affinity", "a pod label selector; replicas will not be scheduled on nodes running matching pods with a different pod ID").String()
 
 	cmdDelete   = kingpin.Command(cmdDeleteText, "Delete a replication controller")
 	deleteID    = cmdDelete.Arg("id", "replication controller uuid to delete").Required().String()
@@ -103,11 +122,30 @@ var (
 	cmdDeleteRoll = kingpin.Command(cmdDeleteRollText, "Delete a rolling update.")
 	deleteRollID  = cmdDeleteRoll.Flag("id", "rolling update uuid").Required().Short('i').String()
 
-	cmdSchedup   = kingpin.Command(cmdSchedupText, "Schedule new rolling update (will be run by farm)")
-	schedupOldID = cmdSchedup.Flag("old", "old replication controller uuid").Required().Short('o').String()
-	schedupNewID = cmdSchedup.Flag("new", "new replication controller uuid").Required().Short('n').String()
-	schedupWant  = cmdSchedup.Flag("desired", "number of replicas desired").Required().Short('d').Int()
-	schedupNeed  = cmdSchedup.Flag("minimum", "minimum number of healthy replicas during update").Required().Short('m').Int()
+	cmdSchedup           = kingpin.Command(cmdSchedupText, "Schedule new rolling update (will be run by farm)")
+	schedupOldID         = cmdSchedup.Flag("old", "old replication controller uuid").Required().Short('o').String()
+	schedupNewID         = cmdSchedup.Flag("new", "new replication controller uuid").Required().Short('n').String()
+	schedupWant          = cmdSchedup.Flag("desired", "number of replicas desired").Required().Short('d').Int()
+	schedupNeed          = cmdSchedup.Flag("minimum", "minimum number of healthy replicas during update").Required().Short('m').Int()
+	schedupAutoRollback  = cmdSchedup.Flag("auto-rollback", "roll back to the old replication controller if the new one becomes unhealthy").Bool()
+	schedupRollbackRatio = cmdSchedup.Flag("rollback-unhealthy-ratio", "with --auto-rollback, the fraction of new pods that may be unhealthy before the new replication controller is considered failing").Default("0").Float64()
+	schedupRollbackAfter = cmdSchedup.Flag("rollback-unhealthy-duration", "with --auto-rollback, how long the new replication controller must be failing before rolling back").Default("0s").Duration()
+	schedupSteps         = cmdSchedup.Flag("step", "a stage of the rollout, as a replica count or percentage of desired replicas optionally followed by ',pause=DURATION' and/or ',manual', e.g. '1,manual' or '50%,pause=10m'. Can be specified multiple times.").Strings()
+
+	cmdGetUpdate = kingpin.Command(cmdGetUpdateText, "Get a rolling update, including the step it is on")
+	getUpdateID  = cmdGetUpdate.Arg("id", "rolling update uuid").Required().String()
+
+	cmdGetUpdateStatus = kingpin.Command(cmdGetUpdateStatusText, "Get the progress of a rolling update as most recently recorded by the farm processing it")
+	getUpdateStatusID  = cmdGetUpdateStatus.Arg("id", "rolling update uuid").Required().String()
+
+	cmdAdvanceUpdate = kingpin.Command(cmdAdvanceUpdateText, "Advance a staged rolling update to its next step, approving or skipping the current one")
+	advanceUpdateID  = cmdAdvanceUpdate.Arg("id", "rolling update uuid").Required().String()
+
+	cmdPauseUpdate = kingpin.Command(cmdPauseUpdateText, "Pause a rolling update. The update will make no further changes to replica counts until resumed, but will retain control of its replication controllers")
+	pauseUpdateID  = cmdPauseUpdate.Arg("id", "rolling update uuid").Required().String()
+
+	cmdResumeUpdate = kingpin.Command(cmdResumeUpdateText, "Resume a paused rolling update")
+	resumeUpdateID  = cmdResumeUpdate.Arg("id", "rolling update uuid").Required().String()
 
 	cmdUpdateManifest  = kingpin.Command(cmdUpdateManifestText, "DANGEROUS. Forcefully update the manifest for the given RC. Consider disabling the RC before invoking this command.")
 	updateManifestRCID = cmdUpdateManifest.Arg("id", "replication controller uuid to update").Required().String()
@@ -116,6 +154,32 @@ var (
 	cmdUpdateStrategy  = kingpin.Command(cmdUpdateStrategyText, "Forcefully update the allocation strategy in the manifest.")
 	updateStrategyRCID = cmdUpdateStrategy.Flag("id", "replication controller uuid to update").Required().String()
 	updateStrategy     = cmdUpdateStrategy.Flag("strategy", "allocation strategy to use for the replication controller").Required().String()
+
+	cmdSetAutoscaler          = kingpin.Command(cmdSetAutoscalerText, "Create or replace the autoscaler policy of a replication controller. The policy is carried out by the autoscaler farm. Replication controllers with a replica schedule can't be autoscaled")
+	setAutoscalerID           = cmdSetAutoscaler.Arg("id", "replication controller uuid to autoscale").Required().String()
+	setAutoscalerMin          = cmdSetAutoscaler.Flag("min", "minimum number of replicas").Required().Int()
+	setAutoscalerMax          = cmdSetAutoscaler.Flag("max", "maximum number of replicas").Required().Int()
+	setAutoscalerTarget       = cmdSetAutoscaler.Flag("target", "the value of the metric per replica to aim for").Required().Float64()
+	setAutoscalerMetricURL    = cmdSetAutoscaler.Flag("metric-url", "an HTTP endpoint whose response body is the current value of the metric").Required().String()
+	setAutoscalerUpCooldown   = cmdSetAutoscaler.Flag("scale-up-cooldown", "minimum time between scaling the replication controller and scaling it up").Default("3m").Duration()
+	setAutoscalerDownCooldown = cmdSetAutoscaler.Flag("scale-down-cooldown", "minimum time between scaling the replication controller and scaling it down").Default("5m").Duration()
+
+	cmdGetAutoscaler = kingpin.Command(cmdGetAutoscalerText, "Get the autoscaler policy of a replication controller")
+	getAutoscalerID  = cmdGetAutoscaler.Arg("id", "replication controller uuid").Required().String()
+
+	cmdDelAutoscaler = kingpin.Command(cmdDelAutoscalerText, "Delete the autoscaler policy of a replication controller, leaving its replica count as it is")
+	delAutoscalerID  = cmdDelAutoscaler.Arg("id", "replication controller uuid").Required().String()
+
+	cmdSetSchedule      = kingpin.Command(cmdSetScheduleText, "Create or replace the replica schedule of a replication controller. The schedule is carried out by the replica schedule farm. Replication controllers with an autoscaler policy can't be scheduled")
+	setScheduleID       = cmdSetSchedule.Arg("id", "replication controller uuid to schedule").Required().String()
+	setScheduleEntries  = cmdSetSchedule.Flag("entry", "a cron expression and the replica count to set when it fires, e.g. \"0 22 * * 1-5=4\". May be repeated").Required().Strings()
+	setScheduleTimezone = cmdSetSchedule.Flag("timezone", "IANA time zone to evaluate the cron expressions in, e.g. America/Los_Angeles. Defaults to UTC").String()
+
+	cmdGetSchedule = kingpin.Command(cmdGetScheduleText, "Get the replica schedule of a replication controller")
+	getScheduleID  = cmdGetSchedule.Arg("id", "replication controller uuid").Required().String()
+
+	cmdDelSchedule = kingpin.Command(cmdDelScheduleText, "Delete the replica schedule of a replication controller, leaving its replica count as it is")
+	delScheduleID  = cmdDelSchedule.Arg("id", "replication controller uuid").Required().String()
 )
 
 func main() {
@@ -147,6 +211,7 @@ func main() {
 
 	rcStore := rcstore.NewConsul(client, labeler, 3)
 	rcStatusStore := rcstatus.NewConsul(statusstore.NewConsul(client), consul.RCStatusNamespace)
+	ruStatusStore := rustatus.NewConsul(statusstore.NewConsul(client), consul.RUStatusNamespace)
 
 	// The roll labeler CANT be an http applicator because it uses consul
 	// transactions, so this might be different from labeler returned by
@@ -162,11 +227,15 @@ func main() {
 		rcStatusStore:     rcStatusStore,
 		rollRCStore:       rcStore,
 		rollRCStatusStore: rcStatusStore,
+		ruStatusStore:     ruStatusStore,
+		rollRUStatusStore: ruStatusStore,
 		rcLocker:          rcStore,
 		rls:               rollstore.NewConsul(client, rollLabeler, nil),
 		consuls:           consul.NewConsulStore(client),
 		labeler:           labeler,
 		hcheck:            checker.NewHealthChecker(client),
+		autoscalers:       autoscalestore.NewConsul(client),
+		schedules:         replicaschedulestore.NewConsul(client),
 		logger:            logger,
 	}
 
@@ -180,6 +249,9 @@ func main() {
 			*createPodLabels,
 			*createRCLabels,
 			rc_fields.Strategy(*createAllocationStrategy),
+			*createSpreadKey,
+			*createMaxSkew,
+			*createAntiAffinity,
 		)
 	case cmdDeleteText:
 		rctl.Delete(*deleteID, *deleteForce)
@@ -198,13 +270,61 @@ func main() {
 	case cmdRollText:
 		rctl.RollingUpdate(*rollOldID, *rollNewID, *rollWant, *rollNeed)
 	case cmdSchedupText:
-		rctl.ScheduleUpdate(*schedupOldID, *schedupNewID, *schedupWant, *schedupNeed, client.KV())
+		var rollbackPolicy *roll_fields.RollbackPolicy
+		if *schedupAutoRollback {
+			rollbackPolicy = &roll_fields.RollbackPolicy{
+				MaxUnhealthyRatio:    *schedupRollbackRatio,
+				MaxUnhealthyDuration: *schedupRollbackAfter,
+			}
+		}
+		var steps []roll_fields.Step
+		for _, stepStr := range *schedupSteps {
+			step, err := roll_fields.ParseStep(stepStr)
+			if err != nil {
+				logger.WithError(err).Fatalln("Could not parse rollout step")
+			}
+			steps = append(steps, step)
+		}
+		rctl.ScheduleUpdate(*schedupOldID, *schedupNewID, *schedupWant, *schedupNeed, rollbackPolicy, steps, client.KV())
+	case cmdGetUpdateText:
+		rctl.GetUpdate(*getUpdateID)
+	case cmdGetUpdateStatusText:
+		rctl.GetUpdateStatus(*getUpdateStatusID)
+	case cmdAdvanceUpdateText:
+		rctl.AdvanceUpdate(*advanceUpdateID, client.KV())
+	case cmdPauseUpdateText:
+		rctl.SetUpdatePaused(*pauseUpdateID, true, client.KV())
+	case cmdResumeUpdateText:
+		rctl.SetUpdatePaused(*resumeUpdateID, false, client.KV())
 	case cmdDeleteRollText:
 		rctl.DeleteRollingUpdate(*deleteRollID, client.KV())
 	case cmdUpdateManifestText:
 		rctl.UpdateManifest(fields.ID(*updateManifestRCID), *updateManifestPath)
 	case cmdUpdateStrategyText:
 		rctl.UpdateStrategy(fields.ID(*updateStrategyRCID), fields.Strategy(*updateStrategy))
+	case cmdSetAutoscalerText:
+		rctl.SetAutoscaler(autoscale_fields.Policy{
+			RCID:        fields.ID(*setAutoscalerID),
+			MinReplicas: *setAutoscalerMin,
+			MaxReplicas: *setAutoscalerMax,
+			TargetValue: *setAutoscalerTarget,
+			Metric: autoscale_fields.MetricSourceConfig{
+				Type: autoscale_fields.HTTPMetric,
+				URL:  *setAutoscalerMetricURL,
+			},
+			ScaleUpCooldown:   *setAutoscalerUpCooldown,
+			ScaleDownCooldown: *setAutoscalerDownCooldown,
+		})
+	case cmdGetAutoscalerText:
+		rctl.GetAutoscaler(fields.ID(*getAutoscalerID))
+	case cmdDelAutoscalerText:
+		rctl.DeleteAutoscaler(fields.ID(*delAutoscalerID))
+	case cmdSetScheduleText:
+		rctl.SetSchedule(fields.ID(*setScheduleID), *setScheduleEntries, *setScheduleTimezone)
+	case cmdGetScheduleText:
+		rctl.GetSchedule(fields.ID(*getScheduleID))
+	case cmdDelScheduleText:
+		rctl.DeleteSchedule(fields.ID(*delScheduleID))
 	}
 }
 
@@ -225,7 +345,7 @@ type Store interface {
 }
 
 type ReplicationControllerStore interface {
-	Create(
+	CreateWithConstraints(
 		manifest manifest.Manifest,
 		nodeSelector klabels.Selector,
 		availabilityZone pc_fields.AvailabilityZone,
@@ -233,6 +353,8 @@ type ReplicationControllerStore interface {
 		podLabels klabels.Set,
 		additionalLabels klabels.Set,
 		allocationStrategy rc_fields.Strategy,
+		spreadConstraint *rc_fields.SpreadConstraint,
+		antiAffinity klabels.Selector,
 	) (fields.RC, error)
 	SetDesiredReplicas(id fields.ID, n int) error
 	List() ([]fields.RC, error)
@@ -244,8 +366,25 @@ type ReplicationControllerStore interface {
 	UpdateStrategy(id fields.ID, strategy fields.Strategy) error
 }
 
+type AutoscalerStore interface {
+	Set(policy autoscale_fields.Policy) error
+	Get(rcID fields.ID) (autoscale_fields.Policy, error)
+	Delete(rcID fields.ID) error
+}
+
+type ScheduleStore interface {
+	Set(schedule schedule_fields.Schedule) error
+	Get(rcID fields.ID) (schedule_fields.Schedule, error)
+	Delete(rcID fields.ID) error
+}
+
 type RollingUpdateStore interface {
+	Get(id roll_fields.ID) (roll_fields.Update, error)
+	WatchRU(id roll_fields.ID, quit <-chan struct{}) (<-chan roll_fields.Update, <-chan error)
 	Delete(ctx context.Context, id roll_fields.ID) error
+	SetRollingBackTxn(ctx context.Context, id roll_fields.ID) error
+	AdvanceStepTxn(ctx context.Context, id roll_fields.ID, fromStep int) error
+	SetPausedTxn(ctx context.Context, id roll_fields.ID, paused bool) error
 	CreateRollingUpdateFromExistingRCs(ctx context.Context, u roll_fields.Update, newRCLabels klabels.Set, rollLabels klabels.Set) (roll_fields.Update, error)
 	Watch(quit <-chan struct{}, jitterWindow time.Duration) (<-chan []roll_fields.Update, <-chan error)
 }
@@ -254,6 +393,11 @@ type RCStatusStore interface {
 	Get(rcID rc_fields.ID) (rcstatus.Status, *api.QueryMeta, error)
 }
 
+type RUStatusStore interface {
+	Get(id roll_fields.ID) (rustatus.Status, *api.QueryMeta, error)
+	DeleteTxn(ctx context.Context, id roll_fields.ID) error
+}
+
 // rctl is a struct for the data structures shared between commands
 // each member function represents a single command that takes over from main
 // and terminates the program on failure
@@ -264,12 +408,16 @@ type rctlParams struct {
 	rcStatusStore     RCStatusStore
 	rollRCStore       roll.ReplicationControllerStore
 	rollRCStatusStore roll.RCStatusStore
+	ruStatusStore     RUStatusStore
+	rollRUStatusStore roll.RUStatusStore
 	rcLocker          roll.ReplicationControllerLocker
 	rcWatcher         rc.ReplicationControllerWatcher
 	rls               RollingUpdateStore
 	labeler           labels.ApplicatorWithoutWatches
 	consuls           Store
 	hcheck            checker.HealthChecker
+	autoscalers       AutoscalerStore
+	schedules         ScheduleStore
 	logger            logging.Logger
 }
 
@@ -281,7 +429,30 @@ func (r rctlParams) Create(
 	podLabels map[string]string,
 	rcLabels map[string]string,
 	allocationStrategy rc_fields.Strategy,
+	spreadKey string,
+	maxSkew int,
+	antiAffinity string,
 ) {
+	var spreadConstraint *rc_fields.SpreadConstraint
+	if spreadKey != "" {
+		spreadConstraint = &rc_fields.SpreadConstraint{Key: spreadKey, MaxSkew: maxSkew}
+		err := spreadConstraint.Validate()
+		if err != nil {
+			r.logger.WithError(err).Fatalln("Invalid spread constraint")
+		}
+	}
+
+	var antiAffinitySel klabels.Selector
+	if antiAffinity != "" {
+		var err error
+		antiAffinitySel, err = labels.ParseSelector(antiAffinity)
+		if err != nil {
+			r.logger.WithErrorAndFields(err, logrus.Fields{
+				"selector": antiAffinity,
+			}).Fatalln("Could not parse anti-affinity selector")
+		}
+	}
+
 	manifest, err := manifest.FromPath(manifestPath)
 	if err != nil {
 		r.logger.WithErrorAndFields(err, logrus.Fields{
@@ -289,14 +460,14 @@ func (r rctlParams) Create(
 		}).Fatalln("Could not read pod manifest")
 	}
 
-	nodeSel, err := klabels.Parse(nodeSelector)
+	nodeSel, err := labels.ParseSelector(nodeSelector)
 	if err != nil {
 		r.logger.WithErrorAndFields(err, logrus.Fields{
 			"selector": nodeSelector,
 		}).Fatalln("Could not parse node selector")
 	}
 
-	newRC, err := r.rcs.Create(manifest, nodeSel, availabilityZone, clusterName, klabels.Set(podLabels), rcLabels, allocationStrategy)
+	newRC, err := r.rcs.CreateWithConstraints(manifest, nodeSel, availabilityZone, clusterName, klabels.Set(podLabels), rcLabels, allocationStrategy, spreadConstraint, antiAffinitySel)
 	if err != nil {
 		r.logger.WithError(err).Fatalln("Could not create replication controller in Consul")
 	}
@@ -319,6 +490,11 @@ func (r rctlParams) DeleteRollingUpdate(id string, txner transaction.Txner) {
 		r.logger.WithError(err).Fatalln("Could not delete RU. Consider a retry.")
 	}
 
+	err = r.ruStatusStore.DeleteTxn(ctx, roll_fields.ID(id))
+	if err != nil {
+		r.logger.WithError(err).Fatalln("Could not delete RU status. Consider a retry.")
+	}
+
 	err = transaction.MustCommit(ctx, txner)
 	if err != nil {
 		r.logger.WithError(err).Fatalln("Could not delete RU. Consider a retry.")
@@ -458,6 +634,7 @@ func (r rctlParams) RollingUpdate(oldID, newID string, want, need int) {
 			r.rcLocker,
 			r.rollRCStore,
 			r.rollRCStatusStore,
+			r.rollRUStatusStore,
 			r.rls,
 			r.baseClient.KV(),
 			r.hcheck,
@@ -468,6 +645,7 @@ func (r rctlParams) RollingUpdate(oldID, newID string, want, need int) {
 			alerting.NewNop(),
 			false,                       // no audit logging
 			auditlogstore.ConsulStore{}, // no audit logging
+			nil,                         // no disruption budgets
 		).Run(ctx)
 		close(result)
 	}()
@@ -498,7 +676,11 @@ LOOP:
 	}
 }
 
-func (r rctlParams) ScheduleUpdate(oldID, newID string, want, need int, txner transaction.Txner) {
+func (r rctlParams) ScheduleUpdate(oldID, newID string, want, need int, rollbackPolicy *roll_fields.RollbackPolicy, steps []roll_fields.Step, txner transaction.Txner) {
+	if rollbackPolicy != nil && (rollbackPolicy.MaxUnhealthyRatio < 0 || rollbackPolicy.MaxUnhealthyRatio >= 1) {
+		r.logger.WithField("ratio", rollbackPolicy.MaxUnhealthyRatio).Fatalln("Rollback unhealthy ratio must be at least 0 and less than 1")
+	}
+
 	ctx, cancelFunc := transaction.New(context.Background())
 	defer cancelFunc()
 	_, err := r.rls.CreateRollingUpdateFromExistingRCs(
@@ -508,6 +690,8 @@ func (r rctlParams) ScheduleUpdate(oldID, newID string, want, need int, txner tr
 			NewRC:           rc_fields.ID(newID),
 			DesiredReplicas: want,
 			MinimumReplicas: need,
+			RollbackPolicy:  rollbackPolicy,
+			Steps:           steps,
 		}, nil, nil)
 	if err != nil {
 		r.logger.WithError(err).Fatalln("Could not create rolling update")
@@ -521,6 +705,187 @@ func (r rctlParams) ScheduleUpdate(oldID, newID string, want, need int, txner tr
 	r.logger.WithField("id", newID).Infoln("Created new rolling update")
 }
 
+func (r rctlParams) GetUpdate(id string) {
+	ru, err := r.rls.Get(roll_fields.ID(id))
+	if err != nil {
+		r.logger.WithError(err).Fatalln("Could not get rolling update")
+	}
+	if ru.NewRC == "" {
+		r.logger.WithField("id", id).Fatalln("Rolling update does not exist")
+	}
+
+	out, err := json.MarshalIndent(ru, "", "    ")
+	if err != nil {
+		r.logger.WithError(err).Fatalln("Could not marshal rolling update to JSON")
+	}
+	fmt.Printf("%s\n", out)
+
+	if len(ru.Steps) > 0 {
+		if ru.CurrentStep < len(ru.Steps) {
+			fmt.Printf("on step %d of %d, targeting %d of %d replicas\n", ru.CurrentStep+1, len(ru.Steps), ru.TargetReplicas(), ru.DesiredReplicas)
+		} else {
+			fmt.Printf("all %d steps complete, targeting %d replicas\n", len(ru.Steps), ru.DesiredReplicas)
+		}
+	}
+	if ru.Paused {
+		fmt.Println("paused")
+	}
+}
+
+func (r rctlParams) GetUpdateStatus(id string) {
+	status, _, err := r.ruStatusStore.Get(roll_fields.ID(id))
+	switch {
+	case statusstore.IsNoStatus(err):
+		fmt.Printf("no status found for %s\n", id)
+		return
+	case err != nil:
+		r.logger.WithError(err).Fatalln("could not fetch rolling update status")
+	}
+
+	out, err := json.MarshalIndent(status, "", "    ")
+	if err != nil {
+		r.logger.WithError(err).Fatalln("could not print rolling update status as JSON")
+	}
+	fmt.Printf("%s\n", out)
+}
+
+func (r rctlParams) AdvanceUpdate(id string, txner transaction.Txner) {
+	ru, err := r.rls.Get(roll_fields.ID(id))
+	if err != nil {
+		r.logger.WithError(err).Fatalln("Could not get rolling update")
+	}
+	if ru.NewRC == "" {
+		r.logger.WithField("id", id).Fatalln("Rolling update does not exist")
+	}
+
+	ctx, cancelFunc := transaction.New(context.Background())
+	defer cancelFunc()
+	err = r.rls.AdvanceStepTxn(ctx, ru.ID(), ru.CurrentStep)
+	if err != nil {
+		r.logger.WithError(err).Fatalln("Could not advance rolling update")
+	}
+
+	err = transaction.MustCommit(ctx, txner)
+	if err != nil {
+		r.logger.WithError(err).Fatalln("Could not advance rolling update. Consider a retry.")
+	}
+
+	r.logger.WithField("id", id).Infof("Advanced rolling update past step %d of %d", ru.CurrentStep+1, len(ru.Steps))
+}
+
+func (r rctlParams) SetUpdatePaused(id string, paused bool, txner transaction.Txner) {
+	action := "resume"
+	if paused {
+		action = "pause"
+	}
+
+	ctx, cancelFunc := transaction.New(context.Background())
+	defer cancelFunc()
+	err := r.rls.SetPausedTxn(ctx, roll_fields.ID(id), paused)
+	if err != nil {
+		r.logger.WithError(err).Fatalf("Could not %s rolling update", action)
+	}
+
+	err = transaction.MustCommit(ctx, txner)
+	if err != nil {
+		r.logger.WithError(err).Fatalf("Could not %s rolling update. Consider a retry.", action)
+	}
+
+	if paused {
+		r.logger.WithField("id", id).Infoln("Paused rolling update")
+	} else {
+		r.logger.WithField("id", id).Infoln("Resumed rolling update")
+	}
+}
+
+func (r rctlParams) SetAutoscaler(policy autoscale_fields.Policy) {
+	_, err := r.rcs.Get(policy.RCID)
+	if err != nil {
+		r.logger.WithError(err).Fatalln("Could not get replication controller to autoscale")
+	}
+
+	err = r.autoscalers.Set(policy)
+	if err != nil {
+		r.logger.WithError(err).Fatalln("Could not set autoscaler policy")
+	}
+	r.logger.WithField("id", policy.RCID).Infoln("Set autoscaler policy")
+}
+
+func (r rctlParams) GetAutoscaler(id fields.ID) {
+	policy, err := r.autoscalers.Get(id)
+	switch {
+	case err == autoscalestore.NoPolicy:
+		fmt.Printf("no autoscaler policy found for %s\n", id)
+		return
+	case err != nil:
+		r.logger.WithError(err).Fatalln("Could not get autoscaler policy")
+	}
+
+	out, err := json.MarshalIndent(policy, "", "    ")
+	if err != nil {
+		r.logger.WithError(err).Fatalln("Could not print autoscaler policy as JSON")
+	}
+	fmt.Printf("%s\n", out)
+}
+
+func (r rctlParams) DeleteAutoscaler(id fields.ID) {
+	err := r.autoscalers.Delete(id)
+	if err != nil {
+		r.logger.WithError(err).Fatalln("Could not delete autoscaler policy")
+	}
+	r.logger.WithField("id", id).Infoln("Deleted autoscaler policy")
+}
+
+func (r rctlParams) SetSchedule(id fields.ID, entries []string, timezone string) {
+	_, err := r.rcs.Get(id)
+	if err != nil {
+		r.logger.WithError(err).Fatalln("Could not get replication controller to schedule")
+	}
+
+	schedule := schedule_fields.Schedule{
+		RCID:     id,
+		Timezone: timezone,
+	}
+	for _, entry := range entries {
+		parsed, err := schedule_fields.ParseEntry(entry)
+		if err != nil {
+			r.logger.WithError(err).Fatalln("Could not parse schedule entry")
+		}
+		schedule.Entries = append(schedule.Entries, parsed)
+	}
+
+	err = r.schedules.Set(schedule)
+	if err != nil {
+		r.logger.WithError(err).Fatalln("Could not set replica schedule")
+	}
+	r.logger.WithField("id", id).Infoln("Set replica schedule")
+}
+
+func (r rctlParams) GetSchedule(id fields.ID) {
+	schedule, err := r.schedules.Get(id)
+	switch {
+	case err == replicaschedulestore.NoSchedule:
+		fmt.Printf("no replica schedule found for %s\n", id)
+		return
+	case err != nil:
+		r.logger.WithError(err).Fatalln("Could not get replica schedule")
+	}
+
+	out, err := json.MarshalIndent(schedule, "", "    ")
+	if err != nil {
+		r.logger.WithError(err).Fatalln("Could not print replica schedule as JSON")
+	}
+	fmt.Printf("%s\n", out)
+}
+
+func (r rctlParams) DeleteSchedule(id fields.ID) {
+	err := r.schedules.Delete(id)
+	if err != nil {
+		r.logger.WithError(err).Fatalln("Could not delete replica schedule")
+	}
+	r.logger.WithField("id", id).Infoln("Deleted replica schedule")
+}
+
 func (r rctlParams) UpdateManifest(id fields.ID, manifestPath string) {
 	man, err := manifest.FromPath(manifestPath)
 
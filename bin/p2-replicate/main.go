@@ -11,12 +11,14 @@ import (
 	"github.com/sirupsen/logrus"
 	"gopkg.in/alecthomas/kingpin.v2"
 
+	"github.com/square/p2/pkg/disruptionbudget"
 	"github.com/square/p2/pkg/health"
 	"github.com/square/p2/pkg/health/checker"
 	"github.com/square/p2/pkg/logging"
 	"github.com/square/p2/pkg/manifest"
 	"github.com/square/p2/pkg/replication"
 	"github.com/square/p2/pkg/store/consul"
+	"github.com/square/p2/pkg/store/consul/disruptionbudgetstore"
 	"github.com/square/p2/pkg/store/consul/flags"
 	"github.com/square/p2/pkg/types"
 	"github.com/square/p2/pkg/version"
@@ -95,6 +97,7 @@ func main() {
 		lockMessage,
 		replication.NoTimeout,
 		1*time.Second,
+		disruptionbudget.NewChecker(disruptionbudgetstore.NewConsul(client), labeler, healthChecker, 0),
 	)
 	if err != nil {
 		log.Fatalf("Could not initialize replicator: %s", err)
@@ -8,6 +8,7 @@ import (
 
 	"gopkg.in/alecthomas/kingpin.v2"
 
+	"github.com/square/p2/pkg/disruptionbudget"
 	"github.com/square/p2/pkg/labels"
 	"github.com/square/p2/pkg/rc/fields"
 	"github.com/square/p2/pkg/store/consul"
@@ -24,6 +25,7 @@ var (
 	podUniqueKey = kingpin.Flag("pod-unique-key", "The pod unique key to unschedule. Only applies to \"uuid\" pods. Cannot be used with --node").Short('k').String()
 	deallocation = kingpin.Flag("deallocate", "Specifies that we are deallocating this pod on this node. Using this switch will mutate the desired_replicas value on a managing RC, if one exists.").Bool()
 	removeOrphan = kingpin.Flag("remove-orphan", "Remove the pod even if it is labeled with a replication controller ID, but only if no RC with that ID exists").Bool()
+	ignoreBudget = kingpin.Flag("ignore-disruption-budget", "Remove the pod even if doing so would exceed the disruption budget of its pod ID or pod cluster").Bool()
 )
 
 func main() {
@@ -63,6 +65,9 @@ func handlePodRemoval(consulClient consulutil.ConsulClient, labeler Labeler) err
 
 		rm = NewLegacyP2RM(consulClient, types.PodID(*podName), types.NodeName(*nodeName), labeler)
 	}
+	if *ignoreBudget {
+		rm.Budgets = disruptionbudget.NewNop()
+	}
 
 	podIsManagedByRC, rcID, err := rm.checkForManagingReplicationController(*removeOrphan)
 	if err != nil {
@@ -1,25 +1,31 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"path"
 	"time"
 
 	"github.com/square/p2/pkg/cli"
+	"github.com/square/p2/pkg/disruptionbudget"
+	"github.com/square/p2/pkg/health/checker"
 	"github.com/square/p2/pkg/labels"
+	pc_fields "github.com/square/p2/pkg/pc/fields"
 	"github.com/square/p2/pkg/rc"
 	"github.com/square/p2/pkg/rc/fields"
 	"github.com/square/p2/pkg/store/consul"
 	"github.com/square/p2/pkg/store/consul/consulutil"
+	"github.com/square/p2/pkg/store/consul/disruptionbudgetstore"
 	"github.com/square/p2/pkg/store/consul/podstore"
 	"github.com/square/p2/pkg/store/consul/rcstore"
+	"github.com/square/p2/pkg/store/consul/transaction"
 	"github.com/square/p2/pkg/types"
 )
 
 type store interface {
 	NewSession(name string, renewalCh <-chan time.Time) (consul.Session, chan error, error)
-	DeletePod(podPrefix consul.PodPrefix, nodename types.NodeName, podId types.PodID) (time.Duration, error)
+	DeletePodTxn(ctx context.Context, podPrefix consul.PodPrefix, nodename types.NodeName, podId types.PodID) error
 }
 
 type ReplicationControllerLocker interface {
@@ -45,6 +51,7 @@ type P2RM struct {
 	Client   consulutil.ConsulClient
 	Labeler  Labeler
 	PodStore podstore.Store
+	Budgets  disruptionbudget.Budgets
 
 	LabelID      string
 	NodeName     types.NodeName
@@ -87,6 +94,12 @@ func (rm *P2RM) configureStorage(client consulutil.ConsulClient, labeler Labeler
 
 	rm.Labeler = labeler
 	rm.PodStore = podstore.NewConsul(client.KV())
+	rm.Budgets = disruptionbudget.NewChecker(
+		disruptionbudgetstore.NewConsul(client),
+		labeler,
+		checker.NewHealthChecker(client),
+		0,
+	)
 }
 
 func (rm *P2RM) checkForManagingReplicationController(checkForOrphaned bool) (bool, fields.ID, error) {
@@ -168,16 +181,34 @@ func (rm *P2RM) deletePod() error {
 	return rm.removePodLabels()
 }
 
+// deleteLegacyPod removes the pod and its labels in the same transaction that
+// reserves the removal against the pod's disruption budgets
 func (rm *P2RM) deleteLegacyPod() error {
-	_, err := rm.Store.DeletePod(consul.INTENT_TREE, rm.NodeName, types.PodID(rm.PodID))
+	request, err := rm.disruptionRequest()
+	if err != nil {
+		return err
+	}
+	request.Nodes = []types.NodeName{rm.NodeName}
+
+	ctx, cancelFunc := transaction.New(context.Background())
+	defer cancelFunc()
+	err = rm.Budgets.ReserveTxn(ctx, request)
 	if err != nil {
 		return fmt.Errorf("unable to remove pod: %v", err)
 	}
-	err = rm.Labeler.RemoveAllLabels(labels.POD, labels.MakePodLabelKey(rm.NodeName, rm.PodID))
+	err = rm.Store.DeletePodTxn(ctx, consul.INTENT_TREE, rm.NodeName, types.PodID(rm.PodID))
+	if err != nil {
+		return fmt.Errorf("unable to remove pod: %v", err)
+	}
+	err = rm.Labeler.RemoveAllLabelsTxn(ctx, labels.POD, labels.MakePodLabelKey(rm.NodeName, rm.PodID))
 	if err != nil {
 		return fmt.Errorf("unalbe to remove labels: %s", err)
 	}
 
+	err = transaction.MustCommit(ctx, rm.Client.KV())
+	if err != nil {
+		return fmt.Errorf("unable to remove pod: %v", err)
+	}
 	return nil
 }
 
@@ -198,7 +229,26 @@ func (rm *P2RM) deleteUUIDPod() error {
 			}
 		}
 
-		err = rm.PodStore.Unschedule(rm.PodUniqueKey)
+		// the node of a pod identified by a pod unique key is not known
+		// to its budgets, so the removal is reserved by count
+		request, err := rm.disruptionRequest()
+		if err != nil {
+			return err
+		}
+		request.PodID = pod.Manifest.ID()
+		request.Count = 1
+
+		ctx, cancelFunc := transaction.New(context.Background())
+		defer cancelFunc()
+		err = rm.Budgets.ReserveTxn(ctx, request)
+		if err != nil {
+			return fmt.Errorf("Unable to unschedule pod: %s", err)
+		}
+		err = rm.PodStore.UnscheduleTxn(ctx, rm.PodUniqueKey)
+		if err != nil {
+			return fmt.Errorf("Unable to unschedule pod: %s", err)
+		}
+		err = transaction.MustCommit(ctx, rm.Client.KV())
 		if err != nil {
 			return fmt.Errorf("Unable to unschedule pod: %s", err)
 		}
@@ -211,6 +261,22 @@ func (rm *P2RM) deleteUUIDPod() error {
 	return nil
 }
 
+// disruptionRequest describes the removal of the pod to its disruption budgets.
+// The pod cluster of the pod is read from its labels.
+func (rm *P2RM) disruptionRequest() (disruptionbudget.Request, error) {
+	podLabels, err := rm.Labeler.GetLabels(labels.POD, rm.LabelID)
+	if err != nil {
+		return disruptionbudget.Request{}, fmt.Errorf("unable to check pod for labels: %v", err)
+	}
+
+	return disruptionbudget.Request{
+		PodID:            rm.PodID,
+		AvailabilityZone: pc_fields.AvailabilityZone(podLabels.Labels.Get(types.AvailabilityZoneLabel)),
+		ClusterName:      pc_fields.ClusterName(podLabels.Labels.Get(types.ClusterNameLabel)),
+		Holder:           "p2-rm",
+	}, nil
+}
+
 func (rm *P2RM) removePodLabels() error {
 	err := rm.Labeler.RemoveAllLabels(labels.POD, rm.LabelID)
 	if err != nil {
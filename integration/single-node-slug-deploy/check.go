@@ -17,6 +17,7 @@ import (
 	"os/user"
 	"path"
 	"path/filepath"
+	"reflect"
 	"runtime"
 	"strings"
 	"time"
@@ -1275,7 +1276,7 @@ func verifyHealthChecks(config *preparer.PreparerConfig, services []string) erro
 		res, err := store.GetHealth(sv, node)
 		if err != nil {
 			return err
-		} else if (res == consul.WatchResult{}) {
+		} else if reflect.DeepEqual(res, consul.WatchResult{}) {
 			return fmt.Errorf("No results for %s: \n\n %s%s", sv, targetLogs("hello"), targetLogs("p2-preparer"))
 		} else if res.Status != string(health.Passing) {
 			return fmt.Errorf("%s did not pass health check: \n\n %s%s", sv, targetLogs("hello"), targetLogs("p2-preparer"))
@@ -66,6 +66,7 @@ func NewRegistry(registryURL *url.URL, fetcher uri.Fetcher, osVersionDetector os
 // manifest: ".manifest"
 // manifest signature: ".manifest.sig"
 // build signature: ".sig"
+// attestation: ".intoto.json"
 func (a registry) LocationDataForLaunchable(podID types.PodID, launchableID launch.LaunchableID, stanza launch.LaunchableStanza) (*url.URL, auth.VerificationData, error) {
 	if stanza.Location == "" && stanza.Version.ID == "" {
 		return nil, auth.VerificationData{}, util.Errorf("Launchable must provide either \"location\" or \"version\" fields")
@@ -129,6 +130,7 @@ type RegistryResponse struct {
 	ManifestLocation          string `json:"manifest_location"`
 	ManifestSignatureLocation string `json:"manifest_signature_location"`
 	BuildSignatureLocation    string `json:"signature_location"`
+	AttestationLocation       string `json:"attestation_location"`
 }
 
 func (a registry) fetchRegistryData(podID types.PodID, launchableID launch.LaunchableID, version launch.LaunchableVersion) (*url.URL, auth.VerificationData, error) {
@@ -226,6 +228,14 @@ func (a registry) authDataFromRegistryResponse(registryResponse RegistryResponse
 		verificationData.BuildSignatureLocation = buildSignatureURL
 	}
 
+	if registryResponse.AttestationLocation != "" {
+		attestationURL, err := url.Parse(registryResponse.AttestationLocation)
+		if err != nil {
+			return verificationData, util.Errorf("Couldn't parse attestation URL from registry response: %s", err)
+		}
+		verificationData.AttestationLocation = attestationURL
+	}
+
 	return verificationData, nil
 }
 
@@ -241,9 +251,14 @@ func VerificationDataForLocation(location *url.URL) auth.VerificationData {
 	buildSignatureLocation := &url.URL{}
 	*buildSignatureLocation = *location
 	buildSignatureLocation.Path = location.Path + ".sig"
+
+	attestationLocation := &url.URL{}
+	*attestationLocation = *location
+	attestationLocation.Path = location.Path + ".intoto.json"
 	return auth.VerificationData{
 		ManifestLocation:          manifestLocation,
 		ManifestSignatureLocation: manifestSignatureLocation,
 		BuildSignatureLocation:    buildSignatureLocation,
+		AttestationLocation:       attestationLocation,
 	}
 }
@@ -104,6 +104,15 @@ func TestLocationDataForLaunchableWithLocation(t *testing.T) {
 			artifactData.BuildSignatureLocation.String(),
 		)
 	}
+
+	expectedAttestationLocation := testLocation + ".intoto.json"
+	if artifactData.AttestationLocation.String() != expectedAttestationLocation {
+		t.Errorf(
+			"Didn't properly compute attestation location: wanted '%s' was '%s'",
+			expectedAttestationLocation,
+			artifactData.AttestationLocation.String(),
+		)
+	}
 }
 
 func TestNeitherVersionNorLocationInvalid(t *testing.T) {
@@ -142,12 +151,14 @@ func TestVersionScheme(t *testing.T) {
 	manifestPath := "/path/to/manifest"
 	manifestSignaturePath := "/path/to/manifest/signature"
 	buildSignaturePath := "/path/to/build/signature"
+	attestationPath := "/path/to/attestation"
 
 	cannedRegResponse := RegistryResponse{
 		ArtifactLocation:          artifactPath,
 		ManifestLocation:          manifestPath,
 		ManifestSignatureLocation: manifestSignaturePath,
 		BuildSignatureLocation:    buildSignaturePath,
+		AttestationLocation:       attestationPath,
 	}
 
 	data, err := json.Marshal(cannedRegResponse)
@@ -221,6 +232,17 @@ func TestVersionScheme(t *testing.T) {
 		t.Errorf("Expected build signature URL to be '%s', was '%s'", expectedBuildSignatureURL.String(), verificationData.BuildSignatureLocation.String())
 	}
 
+	if verificationData.AttestationLocation == nil {
+		t.Fatal("Attestation location unexpectedly nil")
+	}
+
+	expectedAttestationURL := &url.URL{
+		Path: attestationPath,
+	}
+	if *verificationData.AttestationLocation != *expectedAttestationURL {
+		t.Errorf("Expected attestation URL to be '%s', was '%s'", expectedAttestationURL.String(), verificationData.AttestationLocation.String())
+	}
+
 	// Now make sure the correct URL was requested
 	if fakeFetcher.FetchedURL.Host != registryHost {
 		t.Errorf("Expected registry to make request to host '%s', but made request to '%s'", registryHost, fakeFetcher.FetchedURL.Host)
@@ -0,0 +1,49 @@
+package audit
+
+import (
+	"encoding/json"
+
+	rc_fields "github.com/square/p2/pkg/rc/fields"
+	"github.com/square/p2/pkg/util"
+)
+
+const (
+	// RCAutoscaledEvent signifies that an autoscaler changed the desired
+	// replica count of a replication controller in response to its metric
+	RCAutoscaledEvent EventType = "REPLICATION_CONTROLLER_AUTOSCALED"
+)
+
+type RCAutoscaledDetails struct {
+	RCID             rc_fields.ID `json:"rc_id"`
+	PreviousReplicas int          `json:"previous_replicas"`
+	NewReplicas      int          `json:"new_replicas"`
+
+	// MetricValue and TargetValue are the metric value that was read and
+	// the target from the autoscaler policy that together determined the
+	// new replica count
+	MetricValue float64 `json:"metric_value"`
+	TargetValue float64 `json:"target_value"`
+}
+
+func NewRCAutoscaledEventDetails(
+	rcID rc_fields.ID,
+	previousReplicas int,
+	newReplicas int,
+	metricValue float64,
+	targetValue float64,
+) (json.RawMessage, error) {
+	details := RCAutoscaledDetails{
+		RCID:             rcID,
+		PreviousReplicas: previousReplicas,
+		NewReplicas:      newReplicas,
+		MetricValue:      metricValue,
+		TargetValue:      targetValue,
+	}
+
+	bytes, err := json.Marshal(details)
+	if err != nil {
+		return nil, util.Errorf("could not marshal rc autoscaled details as json: %s", err)
+	}
+
+	return json.RawMessage(bytes), nil
+}
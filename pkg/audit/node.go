@@ -0,0 +1,82 @@
+package audit
+
+import (
+	"encoding/json"
+
+	rcfields "github.com/square/p2/pkg/rc/fields"
+	"github.com/square/p2/pkg/types"
+	"github.com/square/p2/pkg/util"
+)
+
+const (
+	// NodeCordonedEvent signifies that a node was cordoned, which makes it
+	// ineligible for every replication controller and daemon set
+	NodeCordonedEvent EventType = "NODE_CORDONED"
+
+	// NodeUncordonedEvent signifies that a cordoned node was put back into
+	// service
+	NodeUncordonedEvent EventType = "NODE_UNCORDONED"
+
+	// NodeDrainStartEvent signifies that a drain of a node was started. The
+	// replication controllers with pods on the node are asked to transfer
+	// them to other nodes
+	NodeDrainStartEvent EventType = "NODE_DRAIN_START"
+
+	// NodeDrainCompletionEvent signifies that a drain of a node finished,
+	// either because no replication controller pods were left on the node
+	// or because the drain was stopped. Pods that were not transferred are
+	// listed in the details
+	NodeDrainCompletionEvent EventType = "NODE_DRAIN_COMPLETION"
+
+	// NodeDecommissionedEvent signifies that a node was taken out of
+	// service for good. It is cordoned and its inventory record is deleted
+	NodeDecommissionedEvent EventType = "NODE_DECOMMISSIONED"
+)
+
+// NodeEventDetails defines a JSON structure for the details related to a node
+// lifecycle event. The same schema is used for every node event type, with
+// the drain related fields left empty for the other event types
+type NodeEventDetails struct {
+	Node types.NodeName `json:"node"`
+
+	// User represents the name of the user who executed the action to
+	// which the event record pertains
+	User string `json:"user"`
+
+	// Reason is the operator supplied reason for taking the node out of
+	// service or putting it back
+	Reason string `json:"reason"`
+
+	// ReplicationControllers are the replication controllers that were
+	// asked to transfer their pods off of the node during a drain
+	ReplicationControllers []rcfields.ID `json:"replication_controllers,omitempty"`
+
+	// RemainingPods are the pods that were still scheduled on the node when
+	// a drain completed
+	RemainingPods []types.PodID `json:"remaining_pods,omitempty"`
+}
+
+// NewNodeEventDetails returns the details of a node event. rcIDs and
+// remainingPods should be nil for events other than drains
+func NewNodeEventDetails(
+	node types.NodeName,
+	user string,
+	reason string,
+	rcIDs []rcfields.ID,
+	remainingPods []types.PodID,
+) (json.RawMessage, error) {
+	details := NodeEventDetails{
+		Node:                   node,
+		User:                   user,
+		Reason:                 reason,
+		ReplicationControllers: rcIDs,
+		RemainingPods:          remainingPods,
+	}
+
+	detailBytes, err := json.Marshal(details)
+	if err != nil {
+		return nil, util.Errorf("could not marshal node event as json: %s", err)
+	}
+
+	return json.RawMessage(detailBytes), nil
+}
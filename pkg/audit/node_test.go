@@ -0,0 +1,47 @@
+package audit
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	rcfields "github.com/square/p2/pkg/rc/fields"
+	"github.com/square/p2/pkg/types"
+)
+
+func TestNodeEventDetails(t *testing.T) {
+	node := types.NodeName("node1.example.com")
+	rcIDs := []rcfields.ID{"some_rc_id"}
+	remaining := []types.PodID{"some_pod_id"}
+
+	detailsJSON, err := NewNodeEventDetails(node, "some_user", "hardware failure", rcIDs, remaining)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var details NodeEventDetails
+	err = json.Unmarshal(detailsJSON, &details)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if details.Node != node {
+		t.Errorf("expected node to be %s but was %s", node, details.Node)
+	}
+
+	if details.User != "some_user" {
+		t.Errorf("expected user to be some_user but was %s", details.User)
+	}
+
+	if details.Reason != "hardware failure" {
+		t.Errorf("expected reason to be %q but was %q", "hardware failure", details.Reason)
+	}
+
+	if !reflect.DeepEqual(details.ReplicationControllers, rcIDs) {
+		t.Errorf("expected replication controllers to be %s but were %s", rcIDs, details.ReplicationControllers)
+	}
+
+	if !reflect.DeepEqual(details.RemainingPods, remaining) {
+		t.Errorf("expected remaining pods to be %s but were %s", remaining, details.RemainingPods)
+	}
+}
@@ -0,0 +1,45 @@
+package audit
+
+import (
+	"encoding/json"
+
+	rc_fields "github.com/square/p2/pkg/rc/fields"
+	"github.com/square/p2/pkg/util"
+)
+
+const (
+	// RCScheduledReplicasEvent signifies that an entry of a replication
+	// controller's replica schedule fired and changed its desired replica
+	// count
+	RCScheduledReplicasEvent EventType = "REPLICATION_CONTROLLER_SCHEDULED_REPLICAS"
+)
+
+type RCScheduledReplicasDetails struct {
+	RCID             rc_fields.ID `json:"rc_id"`
+	PreviousReplicas int          `json:"previous_replicas"`
+	NewReplicas      int          `json:"new_replicas"`
+
+	// Cron is the expression of the schedule entry that fired
+	Cron string `json:"cron"`
+}
+
+func NewRCScheduledReplicasEventDetails(
+	rcID rc_fields.ID,
+	previousReplicas int,
+	newReplicas int,
+	cron string,
+) (json.RawMessage, error) {
+	details := RCScheduledReplicasDetails{
+		RCID:             rcID,
+		PreviousReplicas: previousReplicas,
+		NewReplicas:      newReplicas,
+		Cron:             cron,
+	}
+
+	bytes, err := json.Marshal(details)
+	if err != nil {
+		return nil, util.Errorf("could not marshal rc scheduled replicas details as json: %s", err)
+	}
+
+	return json.RawMessage(bytes), nil
+}
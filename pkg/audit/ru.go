@@ -14,6 +14,12 @@ import (
 const (
 	RUCreationEvent   EventType = "ROLLING_UPDATE_CREATION"
 	RUCompletionEvent EventType = "ROLLING_UPDATE_COMPLETION"
+
+	// RURollbackEvent signifies that a rolling update's rollback policy
+	// was triggered and the update reversed direction, returning replicas
+	// from the new RC to the old one. An RUCompletionEvent with Succeeded
+	// set to false will follow once the rollback finishes.
+	RURollbackEvent EventType = "ROLLING_UPDATE_ROLLBACK"
 )
 
 type RUCreationDetails struct {
@@ -34,6 +40,17 @@ type RUCompletionDetails struct {
 	Canceled         bool                       `json:"canceled"`
 }
 
+type RURollbackDetails struct {
+	PodID            types.PodID                `json:"pod_id"`
+	AvailabilityZone pc_fields.AvailabilityZone `json:"availability_zone"`
+	ClusterName      pc_fields.ClusterName      `json:"cluster_name"`
+	RollingUpdateID  roll_fields.ID             `json:"rolling_update_id"`
+
+	// Reason is a human readable explanation of why the rollback policy
+	// was triggered
+	Reason string `json:"reason"`
+}
+
 func NewRUCreationEventDetails(
 	podID types.PodID,
 	az pc_fields.AvailabilityZone,
@@ -96,3 +113,30 @@ func NewRUCompletionEventDetails(
 
 	return json.RawMessage(bytes), nil
 }
+
+func NewRURollbackEventDetails(
+	rollingUpdateID roll_fields.ID,
+	reason string,
+	labeler Labeler,
+) (json.RawMessage, error) {
+	details := RURollbackDetails{
+		RollingUpdateID: rollingUpdateID,
+		Reason:          reason,
+	}
+
+	labels, err := labeler.GetLabels(labels.RU, rollingUpdateID.String())
+	if err != nil {
+		return nil, util.Errorf("could not determine pod cluster for RU %s: %s", rollingUpdateID, err)
+	}
+
+	details.PodID = types.PodID(labels.Labels[pc_fields.PodIDLabel])
+	details.AvailabilityZone = pc_fields.AvailabilityZone(labels.Labels[pc_fields.AvailabilityZoneLabel])
+	details.ClusterName = pc_fields.ClusterName(labels.Labels[pc_fields.ClusterNameLabel])
+
+	bytes, err := json.Marshal(details)
+	if err != nil {
+		return nil, util.Errorf("could not marshal ru rollback details as json: %s", err)
+	}
+
+	return json.RawMessage(bytes), nil
+}
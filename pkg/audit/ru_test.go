@@ -78,3 +78,40 @@ func TestRUCompletionEventDetails(t *testing.T) {
 		t.Errorf("expected ru ID to be %s but was %s", ruID, details.RollingUpdateID)
 	}
 }
+
+func TestRURollbackEventDetails(t *testing.T) {
+	podID := types.PodID("some_pod_id")
+	labeler := fakeLabeler{
+		labelMap: map[string]labels.Labeled{
+			"some_ru": labels.Labeled{
+				Labels: map[string]string{
+					pc_fields.PodIDLabel: podID.String(),
+				},
+			},
+		},
+	}
+
+	ruID := roll_fields.ID("some_ru")
+	detailsJSON, err := NewRURollbackEventDetails(ruID, "too many unhealthy pods", labeler)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var details RURollbackDetails
+	err = json.Unmarshal(detailsJSON, &details)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if details.PodID != podID {
+		t.Errorf("expected pod id to be %s but was %s", podID, details.PodID)
+	}
+
+	if details.Reason != "too many unhealthy pods" {
+		t.Errorf("expected reason to be set but was %q", details.Reason)
+	}
+
+	if details.RollingUpdateID != ruID {
+		t.Errorf("expected ru ID to be %s but was %s", ruID, details.RollingUpdateID)
+	}
+}
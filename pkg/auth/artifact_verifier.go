@@ -32,6 +32,9 @@ type VerificationData struct {
 
 	// Used by BuildVerifier
 	BuildSignatureLocation *url.URL
+
+	// Used by AttestationVerifier
+	AttestationLocation *url.URL
 }
 
 // The artifact verifier is responsible for checking that the artifact
@@ -52,10 +52,15 @@ func VerificationDataForLocation(location *url.URL) VerificationData {
 	buildSignatureLocation := &url.URL{}
 	*buildSignatureLocation = *location
 	buildSignatureLocation.Path = location.Path + ".sig"
+
+	attestationLocation := &url.URL{}
+	*attestationLocation = *location
+	attestationLocation.Path = location.Path + ".intoto.json"
 	return VerificationData{
 		ManifestLocation:          manifestLocation,
 		ManifestSignatureLocation: manifestSignatureLocation,
 		BuildSignatureLocation:    buildSignatureLocation,
+		AttestationLocation:       attestationLocation,
 	}
 }
 
@@ -0,0 +1,308 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+
+	"github.com/square/p2/pkg/logging"
+	"github.com/square/p2/pkg/uri"
+	"github.com/square/p2/pkg/util"
+)
+
+const VerifyAttestation = "attestation"
+
+const (
+	// The payload type of a DSSE envelope holding an in-toto statement
+	InTotoPayloadType = "application/vnd.in-toto+json"
+
+	inTotoStatementV01 = "https://in-toto.io/Statement/v0.1"
+	inTotoStatementV1  = "https://in-toto.io/Statement/v1"
+
+	slsaProvenanceV02 = "https://slsa.dev/provenance/v0.2"
+	slsaProvenanceV1  = "https://slsa.dev/provenance/v1"
+)
+
+// AttestationVerifier ensures that an artifact is attested to by a trusted
+// builder, in the style of sigstore. The attestation is a DSSE envelope whose
+// payload is an in-toto statement with the artifact's SHA-256 digest as one
+// of its subjects and SLSA provenance as its predicate.
+//
+// The envelope must carry a signature by one of the trusted public keys, and
+// the provenance must name one of the trusted builders. Both SLSA provenance
+// v0.2 (predicate.builder.id) and v1 (predicate.runDetails.builder.id) are
+// understood.
+//
+// If the artifact is located here:
+// https://foo.bar.baz/artifacts/myapp_abc123.tar.gz
+//
+// Then its attestation is located here:
+// https://foo.bar.baz/artifacts/myapp_abc123.tar.gz.intoto.json
+type AttestationVerifier struct {
+	keys     map[string]crypto.PublicKey
+	builders map[string]bool
+	fetcher  uri.Fetcher
+	logger   *logging.Logger
+}
+
+// NewAttestationVerifier reads the trusted public keys from a file of
+// PEM-encoded PKIX public keys. Ed25519, ECDSA and RSA keys are supported.
+func NewAttestationVerifier(publicKeysPath string, builders []string, fetcher uri.Fetcher, logger *logging.Logger) (*AttestationVerifier, error) {
+	keys, err := LoadPublicKeys(publicKeysPath)
+	if err != nil {
+		return nil, util.Errorf("Could not load attestation public keys from %v: %v", publicKeysPath, err)
+	}
+	if len(builders) == 0 {
+		return nil, util.Errorf("Attestation verification requires at least one trusted builder")
+	}
+	trustedBuilders := make(map[string]bool)
+	for _, builder := range builders {
+		trustedBuilders[builder] = true
+	}
+	return &AttestationVerifier{
+		keys:     keys,
+		builders: trustedBuilders,
+		fetcher:  fetcher,
+		logger:   logger,
+	}, nil
+}
+
+// DSSEEnvelope is a signed payload in the Dead Simple Signing Envelope
+// format. The payload and signatures are base64 encoded.
+type DSSEEnvelope struct {
+	PayloadType string          `json:"payloadType"`
+	Payload     string          `json:"payload"`
+	Signatures  []DSSESignature `json:"signatures"`
+}
+
+type DSSESignature struct {
+	KeyID string `json:"keyid"`
+	Sig   string `json:"sig"`
+}
+
+// InTotoStatement is the part of an in-toto statement with SLSA provenance
+// that is needed to verify an artifact
+type InTotoStatement struct {
+	Type          string          `json:"_type"`
+	Subject       []InTotoSubject `json:"subject"`
+	PredicateType string          `json:"predicateType"`
+	Predicate     struct {
+		// SLSA provenance v0.2
+		Builder struct {
+			ID string `json:"id"`
+		} `json:"builder"`
+		// SLSA provenance v1
+		RunDetails struct {
+			Builder struct {
+				ID string `json:"id"`
+			} `json:"builder"`
+		} `json:"runDetails"`
+	} `json:"predicate"`
+}
+
+type InTotoSubject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+// BuilderID returns the identity of the builder that produced the subjects
+func (s InTotoStatement) BuilderID() string {
+	if s.Predicate.RunDetails.Builder.ID != "" {
+		return s.Predicate.RunDetails.Builder.ID
+	}
+	return s.Predicate.Builder.ID
+}
+
+// Returns an error if the artifact is not attested to by a trusted builder
+// with a trusted key.
+func (a *AttestationVerifier) VerifyHoistArtifact(localCopy *os.File, verificationData VerificationData) error {
+	attestationLocation := verificationData.AttestationLocation
+	if attestationLocation == nil {
+		return util.Errorf("Attestation verification failed: attestation location not provided")
+	}
+
+	dir, err := ioutil.TempDir("", "artifact_verification")
+	if err != nil {
+		return util.Errorf("Could not create temporary directory for attestation file: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	attestationDst := filepath.Join(dir, "attestation")
+	if err = a.fetcher.CopyLocal(attestationLocation, attestationDst); err != nil {
+		return util.Errorf("Could not download artifact attestation from %v: %v", attestationLocation.String(), err)
+	}
+	attestationBytes, err := ioutil.ReadFile(attestationDst)
+	if err != nil {
+		return err
+	}
+
+	var envelope DSSEEnvelope
+	err = json.Unmarshal(attestationBytes, &envelope)
+	if err != nil {
+		return util.Errorf("Could not unmarshal attestation: %v", err)
+	}
+	payload, err := a.verifyEnvelope(envelope)
+	if err != nil {
+		return err
+	}
+
+	var statement InTotoStatement
+	err = json.Unmarshal(payload, &statement)
+	if err != nil {
+		return util.Errorf("Could not unmarshal in-toto statement: %v", err)
+	}
+	if statement.Type != inTotoStatementV01 && statement.Type != inTotoStatementV1 {
+		return util.Errorf("Unsupported in-toto statement type %q", statement.Type)
+	}
+	if statement.PredicateType != slsaProvenanceV02 && statement.PredicateType != slsaProvenanceV1 {
+		return util.Errorf("Attestation predicate %q is not SLSA provenance", statement.PredicateType)
+	}
+	if builderID := statement.BuilderID(); !a.builders[builderID] {
+		return util.Errorf("Artifact was built by untrusted builder %q", builderID)
+	}
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, localCopy)
+	if err != nil {
+		return util.Errorf("Could not read given local copy of the artifact: %v", err)
+	}
+	realDigest := hex.EncodeToString(hash.Sum(nil))
+	for _, subject := range statement.Subject {
+		if subject.Digest["sha256"] == realDigest {
+			return nil
+		}
+	}
+	return util.Errorf("Artifact hex digest %v is not a subject of the attestation", realDigest)
+}
+
+// verifyEnvelope checks that the envelope is signed by a trusted key and
+// returns its payload
+func (a *AttestationVerifier) verifyEnvelope(envelope DSSEEnvelope) ([]byte, error) {
+	if envelope.PayloadType != InTotoPayloadType {
+		return nil, util.Errorf("Unsupported attestation payload type %q", envelope.PayloadType)
+	}
+	payload, err := base64.StdEncoding.DecodeString(envelope.Payload)
+	if err != nil {
+		return nil, util.Errorf("Could not decode attestation payload: %v", err)
+	}
+	signed := DSSEPreAuthEncoding(envelope.PayloadType, payload)
+
+	for _, signature := range envelope.Signatures {
+		sig, err := base64.StdEncoding.DecodeString(signature.Sig)
+		if err != nil {
+			continue
+		}
+		// The key ID is only a hint. Signers use different key ID schemes,
+		// so if no trusted key has the signature's key ID every trusted key
+		// is tried
+		if key, ok := a.keys[signature.KeyID]; ok {
+			if verifyWithPublicKey(key, signed, sig) == nil {
+				a.logger.WithField("keyid", signature.KeyID).Debugln("verified artifact attestation signature")
+				return payload, nil
+			}
+			continue
+		}
+		for keyID, key := range a.keys {
+			if verifyWithPublicKey(key, signed, sig) == nil {
+				a.logger.WithField("keyid", keyID).Debugln("verified artifact attestation signature")
+				return payload, nil
+			}
+		}
+	}
+	return nil, util.Errorf("Attestation is not signed by a trusted key")
+}
+
+// DSSEPreAuthEncoding returns the bytes that are signed for a DSSE envelope
+func DSSEPreAuthEncoding(payloadType string, payload []byte) []byte {
+	return []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload))
+}
+
+// PublicKeyID returns the ID of a public key used in DSSE signatures: the hex
+// SHA-256 digest of its PKIX encoding
+func PublicKeyID(key crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256(der)
+	return hex.EncodeToString(digest[:]), nil
+}
+
+// LoadPublicKeys reads PEM-encoded PKIX public keys from a file, keyed by
+// their PublicKeyID
+func LoadPublicKeys(path string) (map[string]crypto.PublicKey, error) {
+	if path == "" {
+		return nil, util.Errorf("no public keys configured")
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "PUBLIC KEY" {
+			continue
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		keyID, err := PublicKeyID(key)
+		if err != nil {
+			return nil, err
+		}
+		keys[keyID] = key
+	}
+	if len(keys) == 0 {
+		return nil, util.Errorf("no public keys found in %s", path)
+	}
+	return keys, nil
+}
+
+func verifyWithPublicKey(key crypto.PublicKey, signed []byte, sig []byte) error {
+	switch key := key.(type) {
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, signed, sig) {
+			return util.Errorf("invalid ed25519 signature")
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		var ecdsaSig struct {
+			R, S *big.Int
+		}
+		rest, err := asn1.Unmarshal(sig, &ecdsaSig)
+		if err != nil || len(rest) != 0 {
+			return util.Errorf("malformed ecdsa signature")
+		}
+		digest := sha256.Sum256(signed)
+		if !ecdsa.Verify(key, digest[:], ecdsaSig.R, ecdsaSig.S) {
+			return util.Errorf("invalid ecdsa signature")
+		}
+		return nil
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(signed)
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig)
+	default:
+		return util.Errorf("unsupported public key type %T", key)
+	}
+}
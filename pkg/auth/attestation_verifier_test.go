@@ -0,0 +1,249 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/square/p2/pkg/logging"
+	"github.com/square/p2/pkg/uri"
+)
+
+const testBuilder = "https://ci.example.com/builders/hoist"
+
+var testArtifactContents = []byte("not really a tarball")
+
+// testStatement returns an in-toto statement attesting to the given digest
+// with SLSA provenance v0.2
+func testStatement(digest string, builder string) []byte {
+	statement := map[string]interface{}{
+		"_type":         inTotoStatementV01,
+		"predicateType": slsaProvenanceV02,
+		"subject": []map[string]interface{}{
+			{"name": "myapp_abc123.tar.gz", "digest": map[string]string{"sha256": digest}},
+		},
+		"predicate": map[string]interface{}{
+			"builder": map[string]string{"id": builder},
+		},
+	}
+	payload, _ := json.Marshal(statement)
+	return payload
+}
+
+func testEnvelope(t *testing.T, key crypto.Signer, keyID string, payload []byte) []byte {
+	signed := DSSEPreAuthEncoding(InTotoPayloadType, payload)
+	var sig []byte
+	var err error
+	if _, ok := key.Public().(ed25519.PublicKey); ok {
+		sig, err = key.Sign(rand.Reader, signed, crypto.Hash(0))
+	} else {
+		digest := sha256.Sum256(signed)
+		sig, err = key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope, err := json.Marshal(DSSEEnvelope{
+		PayloadType: InTotoPayloadType,
+		Payload:     base64.StdEncoding.EncodeToString(payload),
+		Signatures:  []DSSESignature{{KeyID: keyID, Sig: base64.StdEncoding.EncodeToString(sig)}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return envelope
+}
+
+func testPublicKeysFile(t *testing.T, dir string, keys ...crypto.Signer) string {
+	var data []byte
+	for _, key := range keys {
+		der, err := x509.MarshalPKIXPublicKey(key.Public())
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})...)
+	}
+	path := filepath.Join(dir, "public_keys.pem")
+	err := ioutil.WriteFile(path, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// verifyWithAttestation writes the artifact and, if not nil, its attestation
+// next to it and runs the verifier against them
+func verifyWithAttestation(t *testing.T, verifier ArtifactVerifier, attestation []byte) error {
+	dir, err := ioutil.TempDir("", "test-attestation-verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	artifactPath := filepath.Join(dir, "myapp_abc123.tar.gz")
+	err = ioutil.WriteFile(artifactPath, testArtifactContents, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attestation != nil {
+		err = ioutil.WriteFile(artifactPath+".intoto.json", attestation, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	localCopy, err := os.Open(artifactPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer localCopy.Close()
+	verificationData := VerificationDataForLocation(&url.URL{Scheme: "file", Path: artifactPath})
+	return verifier.VerifyHoistArtifact(localCopy, verificationData)
+}
+
+func TestAttestationVerifier(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test-attestation-keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, untrustedKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edKeyID, err := PublicKeyID(edKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verifier, err := NewAttestationVerifier(
+		testPublicKeysFile(t, dir, edKey, ecKey),
+		[]string{testBuilder},
+		uri.DefaultFetcher,
+		&logging.DefaultLogger,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digestBytes := sha256.Sum256(testArtifactContents)
+	digest := hex.EncodeToString(digestBytes[:])
+	otherDigestBytes := sha256.Sum256([]byte("some other artifact"))
+	otherDigest := hex.EncodeToString(otherDigestBytes[:])
+	slsaV1Statement, _ := json.Marshal(map[string]interface{}{
+		"_type":         inTotoStatementV1,
+		"predicateType": slsaProvenanceV1,
+		"subject": []map[string]interface{}{
+			{"name": "myapp_abc123.tar.gz", "digest": map[string]string{"sha256": digest}},
+		},
+		"predicate": map[string]interface{}{
+			"runDetails": map[string]interface{}{
+				"builder": map[string]string{"id": testBuilder},
+			},
+		},
+	})
+
+	var vulnScan map[string]interface{}
+	err = json.Unmarshal(testStatement(digest, testBuilder), &vulnScan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vulnScan["predicateType"] = "https://cosign.sigstore.dev/attestation/vuln/v1"
+	vulnScanStatement, _ := json.Marshal(vulnScan)
+
+	tests := []struct {
+		name        string
+		attestation []byte
+		verified    bool
+	}{
+		{"ed25519 with key ID", testEnvelope(t, edKey, edKeyID, testStatement(digest, testBuilder)), true},
+		{"ecdsa without key ID", testEnvelope(t, ecKey, "", testStatement(digest, testBuilder)), true},
+		{"other key ID scheme", testEnvelope(t, ecKey, "SHA256:cosign-key", testStatement(digest, testBuilder)), true},
+		{"slsa v1 provenance", testEnvelope(t, edKey, edKeyID, slsaV1Statement), true},
+		{"wrong key ID", testEnvelope(t, ecKey, edKeyID, testStatement(digest, testBuilder)), false},
+		{"untrusted key", testEnvelope(t, untrustedKey, "", testStatement(digest, testBuilder)), false},
+		{"untrusted key with other key ID scheme", testEnvelope(t, untrustedKey, "SHA256:cosign-key", testStatement(digest, testBuilder)), false},
+		{"untrusted builder", testEnvelope(t, edKey, edKeyID, testStatement(digest, "https://evil.example.com")), false},
+		{"other artifact", testEnvelope(t, edKey, edKeyID, testStatement(otherDigest, testBuilder)), false},
+		{"not provenance", testEnvelope(t, edKey, edKeyID, vulnScanStatement), false},
+		{"missing attestation", nil, false},
+	}
+	for _, test := range tests {
+		err := verifyWithAttestation(t, verifier, test.attestation)
+		if (err == nil) != test.verified {
+			t.Errorf("%s: expected verified to be %t, got error %v", test.name, test.verified, err)
+		}
+	}
+}
+
+func TestAttestationVerifierRejectsTamperedPayload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test-attestation-keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := NewAttestationVerifier(testPublicKeysFile(t, dir, key), []string{testBuilder}, uri.DefaultFetcher, &logging.DefaultLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digestBytes := sha256.Sum256(testArtifactContents)
+	digest := hex.EncodeToString(digestBytes[:])
+	var envelope DSSEEnvelope
+	err = json.Unmarshal(testEnvelope(t, key, "", testStatement("0000", testBuilder)), &envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope.Payload = base64.StdEncoding.EncodeToString(testStatement(digest, testBuilder))
+	tampered, err := json.Marshal(envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := verifyWithAttestation(t, verifier, tampered); err == nil {
+		t.Error("expected an attestation with a modified payload to fail verification")
+	}
+}
+
+func TestAttestationVerifierRequiresBuilders(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test-attestation-keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewAttestationVerifier(testPublicKeysFile(t, dir, key), nil, uri.DefaultFetcher, &logging.DefaultLogger)
+	if err == nil {
+		t.Error("expected an attestation verifier without trusted builders to be rejected")
+	}
+}
@@ -22,6 +22,7 @@ const (
 	Null    = "none"
 	Keyring = "keyring"
 	User    = "user"
+	Cert    = "cert"
 )
 
 // A Policy encapsulates the behavior a p2 node needs to authorize
@@ -0,0 +1,419 @@
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/openpgp/armor"
+
+	"github.com/square/p2/pkg/logging"
+	"github.com/square/p2/pkg/util"
+)
+
+// PEM block types of a certificate signature. The signature is the
+// concatenation of the signing certificate, any intermediate certificates
+// and the raw signature over the signing time and the signed data. The
+// signing time is a header of the signature block.
+const (
+	certificateBlockType   = "CERTIFICATE"
+	certSignatureBlockType = "P2 SIGNATURE"
+	signingTimeHeader      = "Signing-Time"
+)
+
+// DefaultCertExpiryGracePeriod is how long after its signing certificate
+// expires a signature is still accepted, if the policy doesn't set one
+const DefaultCertExpiryGracePeriod = 30 * 24 * time.Hour
+
+// maxSigningTimeSkew bounds how far in the future a signing time may be, to
+// allow for clock skew between the signer and the node
+const maxSigningTimeSkew = 5 * time.Minute
+
+// CertPolicy authorizes manifests signed with the private key of an X.509
+// certificate, such as an Ed25519 certificate issued by a deploy bot. A pod is
+// authorized to be deployed iff:
+// 1. The signing certificate chains up to one of the trusted roots and was
+//    valid for code signing at the time of signing, and
+// 2. The certificate lists the pod's ID in its pod IDs extension, and
+// 3. The certificate lists the pod's run-as user in its users extension.
+//
+// Both extensions hold an ASN.1 sequence of UTF8 strings. They are
+// identified by OIDs chosen by whoever issues the certificates, and may be
+// marked critical. Since nothing but the certificate limits what can be
+// deployed with it, issuers should keep certificates short-lived.
+//
+// The signing time is part of the signed data, so a manifest signed while
+// its certificate was valid stays deployable after the certificate expires,
+// e.g. on new nodes or when the preparer reinstalls a running pod. This only
+// lasts for the ExpiryGracePeriod, after which the manifest must be re-signed
+// with a current certificate. The grace period bounds how long a leaked key
+// can be used to backdate signatures.
+//
+// Artifacts can optionally sign their contents with a certificate. If no
+// digest signature is provided, the deployment is authorized. If a signature
+// exists, deployment is authorized iff the certificate chains up to one of
+// the trusted roots.
+//
+// The trusted roots are read from a PEM file, which is reloaded whenever it
+// changes.
+type CertPolicy struct {
+	PodIDsExtension   asn1.ObjectIdentifier
+	UsersExtension    asn1.ObjectIdentifier
+	ExpiryGracePeriod time.Duration
+	rootsWatcher      util.FileWatcher
+}
+
+var _ Policy = CertPolicy{}
+
+func NewCertPolicy(
+	rootsPath string,
+	podIDsExtension asn1.ObjectIdentifier,
+	usersExtension asn1.ObjectIdentifier,
+	expiryGracePeriod time.Duration,
+) (Policy, error) {
+	if len(podIDsExtension) == 0 || len(usersExtension) == 0 {
+		return nil, util.Errorf("cert auth must identify the pod IDs and users extensions")
+	}
+	if expiryGracePeriod <= 0 {
+		expiryGracePeriod = DefaultCertExpiryGracePeriod
+	}
+	watcher, err := util.NewFileWatcher(
+		func(path string) (interface{}, error) {
+			return LoadCertPool(path)
+		},
+		rootsPath,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return CertPolicy{podIDsExtension, usersExtension, expiryGracePeriod, watcher}, nil
+}
+
+func (p CertPolicy) AuthorizeApp(manifest Manifest, logger logging.Logger) error {
+	plaintext, signature := manifest.SignatureData()
+	if signature == nil {
+		return Error{util.Errorf("received unsigned manifest (expected signature)"), nil}
+	}
+	signer, err := p.checkCertSignature(plaintext, signature)
+	if err != nil {
+		return err
+	}
+	fields := map[string]interface{}{
+		"signer_subject": signer.Subject.String(),
+		"signer_serial":  signer.SerialNumber.String(),
+	}
+	logger.WithFields(fields).Debugln("resolved manifest signature")
+
+	podIDs, err := stringsExtension(signer, p.PodIDsExtension)
+	if err != nil {
+		return Error{err, fields}
+	}
+	if !containsString(podIDs, manifest.ID().String()) {
+		return Error{util.Errorf("manifest signer not authorized to deploy %s", manifest.ID()), fields}
+	}
+
+	users, err := stringsExtension(signer, p.UsersExtension)
+	if err != nil {
+		return Error{err, fields}
+	}
+	if !containsString(users, manifest.RunAsUser()) {
+		return Error{util.Errorf("manifest signer not authorized to deploy app as pod user: %s", manifest.RunAsUser()), fields}
+	}
+	return nil
+}
+
+func (p CertPolicy) Authorize(email, appUser string) bool {
+	return false
+}
+
+func (p CertPolicy) CheckDigest(digest Digest) error {
+	plaintext, signature := digest.SignatureData()
+	if signature == nil {
+		return nil
+	}
+	_, err := p.checkCertSignature(plaintext, signature)
+	return err
+}
+
+func (p CertPolicy) Close() {
+	p.rootsWatcher.Close()
+}
+
+// checkCertSignature verifies the certificate chain of a signature and the
+// signature itself, returning the signing certificate
+func (p CertPolicy) checkCertSignature(plaintext []byte, signature []byte) (*x509.Certificate, error) {
+	roots := (<-p.rootsWatcher.GetAsync()).(*x509.CertPool)
+
+	chain, rawSignature, signingTime, err := parseCertSignature(signature)
+	if err != nil {
+		return nil, Error{util.Errorf("error validating signature: %s", err), nil}
+	}
+	leaf := chain[0]
+	fields := map[string]interface{}{
+		"signer_subject": leaf.Subject.String(),
+		"signing_time":   signingTime,
+	}
+
+	now := time.Now()
+	if signingTime.After(now.Add(maxSigningTimeSkew)) {
+		return nil, Error{util.Errorf("signing time %s is in the future", signingTime), fields}
+	}
+	if now.After(leaf.NotAfter.Add(p.ExpiryGracePeriod)) {
+		return nil, Error{
+			util.Errorf("signing certificate expired more than %s ago, the manifest must be re-signed", p.ExpiryGracePeriod),
+			fields,
+		}
+	}
+
+	// The policy handles its own extensions, which the issuer may have
+	// marked critical
+	var unhandled []asn1.ObjectIdentifier
+	for _, oid := range leaf.UnhandledCriticalExtensions {
+		if !oid.Equal(p.PodIDsExtension) && !oid.Equal(p.UsersExtension) {
+			unhandled = append(unhandled, oid)
+		}
+	}
+	leaf.UnhandledCriticalExtensions = unhandled
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err = leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   signingTime,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	})
+	if err != nil {
+		return nil, Error{util.Errorf("untrusted signer: %s", err), fields}
+	}
+
+	algorithm, _, err := certSignatureAlgorithm(leaf.PublicKey)
+	if err != nil {
+		return nil, Error{util.Errorf("error validating signature: %s", err), nil}
+	}
+	err = leaf.CheckSignature(algorithm, signedWithTime(plaintext, signingTime), rawSignature)
+	if err != nil {
+		return nil, Error{util.Errorf("error validating signature: %s", err), nil}
+	}
+	return leaf, nil
+}
+
+// SignWithCert signs data with the private key of the first certificate in
+// chain, returning a signature that CertPolicy can check. Any further
+// certificates are included as intermediates.
+func SignWithCert(data []byte, key crypto.Signer, chain []*x509.Certificate) ([]byte, error) {
+	return SignWithCertAt(data, key, chain, time.Now())
+}
+
+// SignWithCertAt is like SignWithCert, with the given signing time
+func SignWithCertAt(data []byte, key crypto.Signer, chain []*x509.Certificate, signingTime time.Time) ([]byte, error) {
+	if len(chain) == 0 {
+		return nil, util.Errorf("no signing certificate given")
+	}
+	_, hash, err := certSignatureAlgorithm(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	signingTime = signingTime.UTC().Truncate(time.Second)
+	signed := signedWithTime(data, signingTime)
+	if hash != 0 {
+		digest := sha256.Sum256(signed)
+		signed = digest[:]
+	}
+	rawSignature, err := key.Sign(rand.Reader, signed, hash)
+	if err != nil {
+		return nil, util.Errorf("could not sign: %s", err)
+	}
+
+	var buf bytes.Buffer
+	for _, cert := range chain {
+		err = pem.Encode(&buf, &pem.Block{Type: certificateBlockType, Bytes: cert.Raw})
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = pem.Encode(&buf, &pem.Block{
+		Type:    certSignatureBlockType,
+		Headers: map[string]string{signingTimeHeader: signingTime.Format(time.RFC3339)},
+		Bytes:   rawSignature,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ClearsignWithCert wraps a document in the same clearsigned format as a
+// PGP-signed manifest, with a certificate signature in place of the PGP
+// signature. The result can be read with manifest.FromBytes.
+func ClearsignWithCert(plaintext []byte, key crypto.Signer, chain []*x509.Certificate) ([]byte, error) {
+	return ClearsignWithCertAt(plaintext, key, chain, time.Now())
+}
+
+// ClearsignWithCertAt is like ClearsignWithCert, with the given signing time
+func ClearsignWithCertAt(plaintext []byte, key crypto.Signer, chain []*x509.Certificate, signingTime time.Time) ([]byte, error) {
+	var body bytes.Buffer
+	var signed [][]byte
+	for _, line := range strings.Split(string(plaintext), "\n") {
+		// The signed form of each line is the same one clearsign.Decode
+		// produces: trailing whitespace is dropped and lines are joined
+		// with CRLF. Every line is followed by a newline in the body, so a
+		// final newline in the plaintext ends up as an empty line
+		line = strings.TrimRight(line, " \t\r")
+		signed = append(signed, []byte(line))
+		if strings.HasPrefix(line, "-") {
+			body.WriteString("- ")
+		}
+		body.WriteString(line)
+		body.WriteString("\n")
+	}
+
+	signature, err := SignWithCertAt(bytes.Join(signed, []byte("\r\n")), key, chain, signingTime)
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	out.WriteString("-----BEGIN PGP SIGNED MESSAGE-----\nHash: SHA256\n\n")
+	out.Write(body.Bytes())
+	w, err := armor.Encode(&out, "PGP SIGNATURE", nil)
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Write(signature)
+	if err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+	out.WriteString("\n")
+	return out.Bytes(), nil
+}
+
+// LoadCertPool reads PEM-encoded certificates from a file
+func LoadCertPool(path string) (*x509.CertPool, error) {
+	if path == "" {
+		return nil, util.Errorf("no trusted roots configured")
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, util.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
+
+// ParseOID parses an object identifier in dotted form, such as "1.2.3.4"
+func ParseOID(s string) (asn1.ObjectIdentifier, error) {
+	var oid asn1.ObjectIdentifier
+	for _, part := range strings.Split(s, ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, util.Errorf("invalid object identifier %q", s)
+		}
+		oid = append(oid, n)
+	}
+	if len(oid) < 2 {
+		return nil, util.Errorf("invalid object identifier %q", s)
+	}
+	return oid, nil
+}
+
+// signedWithTime returns the bytes that are signed for data signed at the
+// given time, so that the signing time can't be changed without invalidating
+// the signature
+func signedWithTime(data []byte, signingTime time.Time) []byte {
+	prefix := signingTimeHeader + ": " + signingTime.UTC().Format(time.RFC3339) + "\n"
+	return append([]byte(prefix), data...)
+}
+
+func parseCertSignature(signature []byte) ([]*x509.Certificate, []byte, time.Time, error) {
+	var chain []*x509.Certificate
+	rest := signature
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return nil, nil, time.Time{}, util.Errorf("no %s block found", certSignatureBlockType)
+		}
+		switch block.Type {
+		case certificateBlockType:
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, nil, time.Time{}, err
+			}
+			chain = append(chain, cert)
+		case certSignatureBlockType:
+			if len(chain) == 0 {
+				return nil, nil, time.Time{}, util.Errorf("no signing certificate found")
+			}
+			signingTime, err := time.Parse(time.RFC3339, block.Headers[signingTimeHeader])
+			if err != nil {
+				return nil, nil, time.Time{}, util.Errorf("invalid signing time: %s", err)
+			}
+			return chain, block.Bytes, signingTime, nil
+		default:
+			return nil, nil, time.Time{}, util.Errorf("unexpected %s block", block.Type)
+		}
+	}
+}
+
+// certSignatureAlgorithm returns the signature algorithm used with a key,
+// along with the hash that is signed. Ed25519 keys sign the data itself.
+func certSignatureAlgorithm(publicKey crypto.PublicKey) (x509.SignatureAlgorithm, crypto.Hash, error) {
+	switch publicKey.(type) {
+	case ed25519.PublicKey:
+		return x509.PureEd25519, 0, nil
+	case *ecdsa.PublicKey:
+		return x509.ECDSAWithSHA256, crypto.SHA256, nil
+	case *rsa.PublicKey:
+		return x509.SHA256WithRSA, crypto.SHA256, nil
+	default:
+		return x509.UnknownSignatureAlgorithm, 0, util.Errorf("unsupported public key type %T", publicKey)
+	}
+}
+
+func stringsExtension(cert *x509.Certificate, oid asn1.ObjectIdentifier) ([]string, error) {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oid) {
+			continue
+		}
+		var values []string
+		rest, err := asn1.Unmarshal(ext.Value, &values)
+		if err != nil {
+			return nil, util.Errorf("could not parse extension %s: %s", oid, err)
+		}
+		if len(rest) > 0 {
+			return nil, util.Errorf("trailing data after extension %s", oid)
+		}
+		return values, nil
+	}
+	return nil, util.Errorf("signing certificate has no extension %s", oid)
+}
+
+func containsString(values []string, s string) bool {
+	for _, value := range values {
+		if value == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,311 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/openpgp/clearsign"
+
+	"github.com/square/p2/pkg/logging"
+	"github.com/square/p2/pkg/types"
+)
+
+var (
+	testPodIDsExtension = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 1}
+	testUsersExtension  = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 2}
+)
+
+// testCA issues signing certificates at test time
+type testCA struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+func newTestCA(t *testing.T) testCA {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test signing CA"},
+		NotBefore:             time.Now().Add(-24 * time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testCA{cert, key}
+}
+
+// issue returns a key and a certificate authorizing it to deploy the given
+// pods as the given users. The certificate is valid for the two hours before
+// notAfter. Extensions are marked critical
+func (ca testCA) issue(t *testing.T, key crypto.Signer, notAfter time.Time, podIDs []string, users []string) *x509.Certificate {
+	podIDsValue, err := asn1.Marshal(podIDs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	usersValue, err := asn1.Marshal(users)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "deploy bot"},
+		NotBefore:    notAfter.Add(-2 * time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		ExtraExtensions: []pkix.Extension{
+			{Id: testPodIDsExtension, Critical: true, Value: podIDsValue},
+			{Id: testUsersExtension, Critical: true, Value: usersValue},
+		},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, key.Public(), ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// newTestCertPolicy returns a cert policy trusting the CA and a function that
+// closes it and removes its roots file
+func newTestCertPolicy(t *testing.T, ca testCA, expiryGracePeriod time.Duration) (Policy, func()) {
+	dir, err := ioutil.TempDir("", "cert_policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootsPath := filepath.Join(dir, "roots.pem")
+	err = ioutil.WriteFile(rootsPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0644)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	policy, err := NewCertPolicy(rootsPath, testPodIDsExtension, testUsersExtension, expiryGracePeriod)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return policy, func() {
+		policy.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func certSigned(t *testing.T, key crypto.Signer, cert *x509.Certificate, podID string, user string) TestSigned {
+	return certSignedAt(t, key, cert, podID, user, time.Now())
+}
+
+func certSignedAt(t *testing.T, key crypto.Signer, cert *x509.Certificate, podID string, user string, signingTime time.Time) TestSigned {
+	plaintext := []byte("id: " + podID)
+	signature, err := SignWithCertAt(plaintext, key, []*x509.Certificate{cert}, signingTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return TestSigned{
+		Id:        types.PodID(podID),
+		User:      user,
+		Plaintext: plaintext,
+		Signature: signature,
+	}
+}
+
+func TestCertPolicy(t *testing.T) {
+	ca := newTestCA(t)
+	policy, closePolicy := newTestCertPolicy(t, ca, 0)
+	defer closePolicy()
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherCA := newTestCA(t)
+
+	valid := time.Now().Add(10 * time.Minute)
+	tests := []struct {
+		name       string
+		key        crypto.Signer
+		cert       *x509.Certificate
+		podID      string
+		user       string
+		authorized bool
+	}{
+		{"ed25519", edKey, ca.issue(t, edKey, valid, []string{"web", "worker"}, []string{"web_user"}), "worker", "web_user", true},
+		{"ecdsa", ecKey, ca.issue(t, ecKey, valid, []string{"web"}, []string{"web_user"}), "web", "web_user", true},
+		{"other pod", edKey, ca.issue(t, edKey, valid, []string{"web"}, []string{"web_user"}), "db", "web_user", false},
+		{"other user", edKey, ca.issue(t, edKey, valid, []string{"web"}, []string{"web_user"}), "web", "root", false},
+		{"expired", edKey, ca.issue(t, edKey, time.Now().Add(-time.Minute), []string{"web"}, []string{"web_user"}), "web", "web_user", false},
+		{"untrusted", edKey, otherCA.issue(t, edKey, valid, []string{"web"}, []string{"web_user"}), "web", "web_user", false},
+	}
+	for _, test := range tests {
+		signed := certSigned(t, test.key, test.cert, test.podID, test.user)
+		err := policy.AuthorizeApp(signed, logging.TestLogger())
+		if (err == nil) != test.authorized {
+			t.Errorf("%s: expected authorized to be %t, got error %v", test.name, test.authorized, err)
+		}
+	}
+}
+
+func TestCertPolicyExpiredLeaf(t *testing.T) {
+	ca := newTestCA(t)
+	policy, closePolicy := newTestCertPolicy(t, ca, 6*time.Hour)
+	defer closePolicy()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	expiredRecently := ca.issue(t, key, now.Add(-time.Hour), []string{"web"}, []string{"web_user"})
+	expiredLongAgo := ca.issue(t, key, now.Add(-12*time.Hour), []string{"web"}, []string{"web_user"})
+	valid := ca.issue(t, key, now.Add(time.Hour), []string{"web"}, []string{"web_user"})
+
+	tests := []struct {
+		name        string
+		cert        *x509.Certificate
+		signingTime time.Time
+		authorized  bool
+	}{
+		{"signed before expiry", expiredRecently, now.Add(-90 * time.Minute), true},
+		{"signed after expiry", expiredRecently, now.Add(-30 * time.Minute), false},
+		{"signed before validity", valid, now.Add(-2 * time.Hour), false},
+		{"expired beyond grace period", expiredLongAgo, now.Add(-13 * time.Hour), false},
+		{"signed in the future", valid, now.Add(30 * time.Minute), false},
+	}
+	for _, test := range tests {
+		signed := certSignedAt(t, key, test.cert, "web", "web_user", test.signingTime)
+		err := policy.AuthorizeApp(signed, logging.TestLogger())
+		if (err == nil) != test.authorized {
+			t.Errorf("%s: expected authorized to be %t, got error %v", test.name, test.authorized, err)
+		}
+	}
+}
+
+func TestCertPolicyRejectsChangedSigningTime(t *testing.T) {
+	ca := newTestCA(t)
+	policy, closePolicy := newTestCertPolicy(t, ca, 0)
+	defer closePolicy()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	cert := ca.issue(t, key, now.Add(-time.Hour), []string{"web"}, []string{"web_user"})
+
+	// signed after expiry, with the header claiming it was signed before
+	signed := certSignedAt(t, key, cert, "web", "web_user", now)
+	backdated := strings.Replace(
+		string(signed.Signature),
+		signingTimeHeader+": "+now.UTC().Format(time.RFC3339),
+		signingTimeHeader+": "+now.Add(-90*time.Minute).UTC().Format(time.RFC3339),
+		1,
+	)
+	if backdated == string(signed.Signature) {
+		t.Fatalf("expected the signing time header in %s", signed.Signature)
+	}
+	signed.Signature = []byte(backdated)
+	if err := policy.AuthorizeApp(signed, logging.TestLogger()); err == nil {
+		t.Error("expected a signature with a changed signing time to be rejected")
+	}
+}
+
+func TestCertPolicyRejectsTampering(t *testing.T) {
+	ca := newTestCA(t)
+	policy, closePolicy := newTestCertPolicy(t, ca, 0)
+	defer closePolicy()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := ca.issue(t, key, time.Now().Add(10*time.Minute), []string{"web"}, []string{"web_user"})
+
+	signed := certSigned(t, key, cert, "web", "web_user")
+	signed.Plaintext = []byte("id: web\nrun_as: root")
+	if err := policy.AuthorizeApp(signed, logging.TestLogger()); err == nil {
+		t.Error("expected a modified manifest to be rejected")
+	}
+
+	signed.Signature = nil
+	if err := policy.AuthorizeApp(signed, logging.TestLogger()); err == nil {
+		t.Error("expected an unsigned manifest to be rejected")
+	}
+	if err := policy.CheckDigest(signed); err != nil {
+		t.Errorf("expected an unsigned digest to be accepted: %s", err)
+	}
+}
+
+func TestClearsignWithCert(t *testing.T) {
+	ca := newTestCA(t)
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := ca.issue(t, key, time.Now().Add(10*time.Minute), []string{"web"}, []string{"web_user"})
+
+	plaintext := []byte("id: web\nlaunchables:  \n  - trailing whitespace\n---\n")
+	doc, err := ClearsignWithCert(plaintext, key, []*x509.Certificate{cert})
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := clearsign.Decode(doc)
+	if block == nil {
+		t.Fatalf("expected a clearsigned document but got %s", doc)
+	}
+	signature, err := ioutil.ReadAll(block.ArmoredSignature.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy, closePolicy := newTestCertPolicy(t, ca, 0)
+	defer closePolicy()
+	err = policy.CheckDigest(TestSigned{Plaintext: block.Bytes, Signature: signature})
+	if err != nil {
+		t.Errorf("expected the clearsigned document's signature to be valid: %s", err)
+	}
+}
+
+func TestParseOID(t *testing.T) {
+	oid, err := ParseOID("1.3.6.1.4.1.99999.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !oid.Equal(testPodIDsExtension) {
+		t.Errorf("expected %s but got %s", testPodIDsExtension, oid)
+	}
+	for _, invalid := range []string{"", "1", "1..2", "1.-2", "a.b"} {
+		if _, err := ParseOID(invalid); err == nil {
+			t.Errorf("expected %q to be rejected", invalid)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package autoscale
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/square/p2/pkg/audit"
+	"github.com/square/p2/pkg/autoscale/fields"
+	"github.com/square/p2/pkg/logging"
+	rc_fields "github.com/square/p2/pkg/rc/fields"
+	"github.com/square/p2/pkg/store/consul"
+	"github.com/square/p2/pkg/store/consul/transaction"
+	"github.com/square/p2/pkg/util"
+)
+
+type PolicyStore interface {
+	Get(rcID rc_fields.ID) (fields.Policy, error)
+	SetLastScaledTxn(ctx context.Context, policy fields.Policy, lastScaled time.Time) error
+}
+
+type ReplicationControllerStore interface {
+	Get(id rc_fields.ID) (rc_fields.RC, error)
+	SetDesiredReplicasTxn(ctx context.Context, id rc_fields.ID, n int) error
+}
+
+type ReplicationControllerLocker interface {
+	LockForMutationTxn(lockCtx context.Context, rcID rc_fields.ID, session consul.Session) (consul.TxnUnlocker, error)
+}
+
+type AuditLogStore interface {
+	Create(
+		ctx context.Context,
+		eventType audit.EventType,
+		eventDetails json.RawMessage,
+	) error
+}
+
+// DesiredReplicas computes the replica count that would bring the metric to
+// the policy's target value, assuming the metric is proportional to the load
+// on each replica. The result is kept within the policy's bounds. An RC with
+// no replicas is scaled to the policy's minimum, since the metric says
+// nothing about how many replicas are needed.
+func DesiredReplicas(policy fields.Policy, current int, value float64) int {
+	desired := policy.MinReplicas
+	if current > 0 {
+		desired = int(math.Ceil(float64(current) * value / policy.TargetValue))
+	}
+
+	if desired < policy.MinReplicas {
+		desired = policy.MinReplicas
+	}
+	if desired > policy.MaxReplicas {
+		desired = policy.MaxReplicas
+	}
+	return desired
+}
+
+// autoscaler periodically adjusts the replica count of a single RC according
+// to its autoscaler policy. It must only be run while the farm holds the
+// ownership lock on the policy.
+type autoscaler struct {
+	rcID          rc_fields.ID
+	policyStore   PolicyStore
+	rcStore       ReplicationControllerStore
+	rcLocker      ReplicationControllerLocker
+	auditLogStore AuditLogStore
+	txner         transaction.Txner
+	session       consul.Session
+	httpClient    *http.Client
+	logger        logging.Logger
+}
+
+// run evaluates the policy every interval until quit is closed.
+func (a *autoscaler) run(quit <-chan struct{}, interval time.Duration) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-quit:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	timer := time.NewTimer(time.Duration(0))
+	defer timer.Stop()
+	for {
+		select {
+		case <-quit:
+			return
+		case <-timer.C:
+		}
+		timer.Reset(interval)
+
+		err := a.evaluate(ctx, time.Now())
+		if err != nil {
+			a.logger.WithError(err).Errorln("Could not evaluate autoscaler policy")
+		}
+	}
+}
+
+// evaluate reads the policy's metric and, if the RC needs a different number
+// of replicas and the cooldown has passed, changes the RC's replica count.
+func (a *autoscaler) evaluate(ctx context.Context, now time.Time) error {
+	policy, err := a.policyStore.Get(a.rcID)
+	if err != nil {
+		return err
+	}
+
+	rc, err := a.rcStore.Get(a.rcID)
+	if err != nil {
+		return err
+	}
+	if rc.Disabled {
+		a.logger.NoFields().Debugln("RC is disabled, not autoscaling")
+		return nil
+	}
+
+	source, err := NewMetricSource(policy.Metric, a.httpClient)
+	if err != nil {
+		return err
+	}
+	value, err := source.Value(ctx)
+	if err != nil {
+		return err
+	}
+
+	current := rc.ReplicasDesired
+	desired := DesiredReplicas(policy, current, value)
+	logger := a.logger.SubLogger(logrus.Fields{
+		"metric_value":     value,
+		"target_value":     policy.TargetValue,
+		"current_replicas": current,
+		"desired_replicas": desired,
+	})
+	if desired == current {
+		logger.NoFields().Debugln("RC has the desired number of replicas")
+		return nil
+	}
+
+	cooldown := policy.ScaleUpCooldown
+	if desired < current {
+		cooldown = policy.ScaleDownCooldown
+	}
+	if now.Sub(policy.LastScaled) < cooldown {
+		logger.WithField("last_scaled", policy.LastScaled).Infoln("Not scaling RC until cooldown has passed")
+		return nil
+	}
+
+	txnCtx, cancel := transaction.New(ctx)
+	defer cancel()
+
+	// Rolling updates hold the RC's mutation lock while they change its
+	// replica count. Taking and releasing the lock in the same
+	// transaction makes the transaction fail if it is held by anyone
+	// else, so the autoscaler backs off until the update is finished.
+	unlocker, err := a.rcLocker.LockForMutationTxn(txnCtx, a.rcID, a.session)
+	if err != nil {
+		return err
+	}
+	err = a.rcStore.SetDesiredReplicasTxn(txnCtx, a.rcID, desired)
+	if err != nil {
+		return err
+	}
+	err = unlocker.UnlockTxn(txnCtx)
+	if err != nil {
+		return err
+	}
+
+	err = a.policyStore.SetLastScaledTxn(txnCtx, policy, now)
+	if err != nil {
+		return err
+	}
+
+	details, err := audit.NewRCAutoscaledEventDetails(a.rcID, current, desired, value, policy.TargetValue)
+	if err != nil {
+		return err
+	}
+	err = a.auditLogStore.Create(txnCtx, audit.RCAutoscaledEvent, details)
+	if err != nil {
+		return err
+	}
+
+	ok, resp, err := transaction.Commit(txnCtx, a.txner)
+	switch {
+	case err != nil:
+		return err
+	case !ok:
+		return util.Errorf(
+			"could not scale RC, it may be locked by a rolling update or have changed: %s",
+			transaction.TxnErrorsToString(resp.Errors),
+		)
+	}
+
+	logger.NoFields().Infoln("Scaled RC")
+	return nil
+}
@@ -0,0 +1,174 @@
+package autoscale
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/square/p2/pkg/audit"
+	"github.com/square/p2/pkg/autoscale/fields"
+	"github.com/square/p2/pkg/logging"
+	"github.com/square/p2/pkg/policyfarm/policyfarmtest"
+	"github.com/square/p2/pkg/store/consul/autoscalestore"
+)
+
+func TestDesiredReplicas(t *testing.T) {
+	policy := fields.Policy{
+		MinReplicas: 2,
+		MaxReplicas: 10,
+		TargetValue: 0.5,
+	}
+
+	tests := []struct {
+		current  int
+		value    float64
+		expected int
+	}{
+		{current: 4, value: 0.5, expected: 4},
+		{current: 4, value: 1, expected: 8},
+		{current: 4, value: 0.3, expected: 3},
+		{current: 4, value: 0.01, expected: 2},
+		{current: 4, value: 5, expected: 10},
+		{current: 0, value: 5, expected: 2},
+	}
+	for _, test := range tests {
+		desired := DesiredReplicas(policy, test.current, test.value)
+		if desired != test.expected {
+			t.Errorf("expected %d replicas at %v with %d current but got %d", test.expected, test.value, test.current, desired)
+		}
+	}
+}
+
+type testAutoscaler struct {
+	*autoscaler
+	policyfarmtest.Fixture
+	policyStore *autoscalestore.ConsulStore
+	metric      *string
+}
+
+func setupAutoscaler(t *testing.T) (testAutoscaler, func()) {
+	fixture := policyfarmtest.NewFixture(t, 4)
+
+	metric := "1"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, metric)
+	}))
+
+	policyStore := autoscalestore.NewConsul(fixture.Client)
+	err := policyStore.Set(fields.Policy{
+		RCID:              fixture.RCID,
+		MinReplicas:       1,
+		MaxReplicas:       10,
+		TargetValue:       0.5,
+		Metric:            fields.MetricSourceConfig{Type: fields.HTTPMetric, URL: server.URL},
+		ScaleUpCooldown:   time.Minute,
+		ScaleDownCooldown: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := testAutoscaler{
+		autoscaler: &autoscaler{
+			rcID:          fixture.RCID,
+			policyStore:   policyStore,
+			rcStore:       fixture.RCStore,
+			rcLocker:      fixture.RCStore,
+			auditLogStore: fixture.AuditLogStore,
+			txner:         fixture.Client.KV(),
+			session:       fixture.Session,
+			httpClient:    server.Client(),
+			logger:        logging.TestLogger(),
+		},
+		Fixture:     fixture,
+		policyStore: policyStore,
+		metric:      &metric,
+	}
+	return a, func() {
+		server.Close()
+		fixture.Close()
+	}
+}
+
+func TestEvaluateScalesWithCooldowns(t *testing.T) {
+	a, closeFn := setupAutoscaler(t)
+	defer closeFn()
+
+	now := time.Now()
+	err := a.evaluate(context.Background(), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replicas := a.Replicas(); replicas != 8 {
+		t.Fatalf("expected the RC to be scaled to 8 replicas but it has %d", replicas)
+	}
+
+	policy, err := a.policyStore.Get(a.rcID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !policy.LastScaled.Equal(now) {
+		t.Errorf("expected the policy's last scaled time to be %s but was %s", now, policy.LastScaled)
+	}
+
+	auditLogs, err := a.AuditLogStore.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(auditLogs) != 1 {
+		t.Fatalf("expected one audit log record but found %d", len(auditLogs))
+	}
+	for _, al := range auditLogs {
+		if al.EventType != audit.RCAutoscaledEvent {
+			t.Errorf("expected an audit log record of type %s but was %s", audit.RCAutoscaledEvent, al.EventType)
+		}
+	}
+
+	// the metric drops, but the scale down cooldown has not passed
+	*a.metric = "0.25"
+	err = a.evaluate(context.Background(), now.Add(30*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replicas := a.Replicas(); replicas != 8 {
+		t.Fatalf("expected the RC not to be scaled during the cooldown but it has %d replicas", replicas)
+	}
+
+	err = a.evaluate(context.Background(), now.Add(2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replicas := a.Replicas(); replicas != 4 {
+		t.Fatalf("expected the RC to be scaled to 4 replicas after the cooldown but it has %d", replicas)
+	}
+}
+
+func TestEvaluateBacksOffWhenRCIsLocked(t *testing.T) {
+	a, closeFn := setupAutoscaler(t)
+	defer closeFn()
+
+	unlocker := a.LockForMutation()
+
+	err := a.evaluate(context.Background(), time.Now())
+	if err == nil {
+		t.Fatal("expected an error scaling an RC that is locked for mutation")
+	}
+	if replicas := a.Replicas(); replicas != 4 {
+		t.Fatalf("expected the locked RC not to be scaled but it has %d replicas", replicas)
+	}
+
+	err = unlocker.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = a.evaluate(context.Background(), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replicas := a.Replicas(); replicas != 8 {
+		t.Fatalf("expected the RC to be scaled once unlocked but it has %d replicas", replicas)
+	}
+}
@@ -0,0 +1,110 @@
+// Package autoscale adjusts the replica counts of replication controllers
+// according to autoscaler policies stored in consul.
+package autoscale
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/square/p2/pkg/logging"
+	"github.com/square/p2/pkg/policyfarm"
+	rc_fields "github.com/square/p2/pkg/rc/fields"
+	"github.com/square/p2/pkg/store/consul"
+	"github.com/square/p2/pkg/store/consul/transaction"
+)
+
+const (
+	DefaultEvaluationInterval = 30 * time.Second
+	DefaultWatchPauseTime     = 1 * time.Second
+)
+
+// A subset of the consul.Store interface used by the farm
+type sessionStore interface {
+	NewUnmanagedSession(session, name string) consul.Session
+}
+
+type PolicyWatcher interface {
+	PolicyStore
+	WatchRCs(quit <-chan struct{}, pauseTime time.Duration) (<-chan []rc_fields.ID, <-chan error)
+	LockForOwnership(rcID rc_fields.ID, session consul.Session) (consul.Unlocker, error)
+}
+
+type FarmConfig struct {
+	// EvaluationInterval is how often each policy's metric is read and
+	// the replica count of its RC is adjusted
+	EvaluationInterval time.Duration
+
+	// WatchPauseTime is the time to wait between watches of the policy
+	// tree returning
+	WatchPauseTime time.Duration
+}
+
+// The Farm is responsible for running an autoscaler for each policy as
+// policies are added to and deleted from Consul. Multiple farms can exist
+// simultaneously, but each one must hold a different Consul session. This
+// ensures that no two farms run the autoscaler for the same RC.
+type Farm struct {
+	*policyfarm.Farm
+
+	policyStore   PolicyWatcher
+	rcStore       ReplicationControllerStore
+	rcLocker      ReplicationControllerLocker
+	auditLogStore AuditLogStore
+	txner         transaction.Txner
+	httpClient    *http.Client
+	config        FarmConfig
+}
+
+func NewFarm(
+	store sessionStore,
+	policyStore PolicyWatcher,
+	rcStore ReplicationControllerStore,
+	rcLocker ReplicationControllerLocker,
+	auditLogStore AuditLogStore,
+	txner transaction.Txner,
+	httpClient *http.Client,
+	sessions <-chan string,
+	logger logging.Logger,
+	config FarmConfig,
+) *Farm {
+	if config.EvaluationInterval == 0 {
+		config.EvaluationInterval = DefaultEvaluationInterval
+	}
+	if config.WatchPauseTime == 0 {
+		config.WatchPauseTime = DefaultWatchPauseTime
+	}
+
+	f := &Farm{
+		policyStore:   policyStore,
+		rcStore:       rcStore,
+		rcLocker:      rcLocker,
+		auditLogStore: auditLogStore,
+		txner:         txner,
+		httpClient:    httpClient,
+		config:        config,
+	}
+	f.Farm = policyfarm.New("autoscaler policy", store, policyStore, f.watchPolicies, f.newAutoscaler, sessions, logger)
+	return f
+}
+
+// watchPolicies publishes the IDs of the RCs that have autoscaler policies
+func (f *Farm) watchPolicies(quit <-chan struct{}) (<-chan []rc_fields.ID, <-chan error) {
+	return f.policyStore.WatchRCs(quit, f.config.WatchPauseTime)
+}
+
+func (f *Farm) newAutoscaler(rcID rc_fields.ID, session consul.Session, logger logging.Logger) policyfarm.Worker {
+	child := &autoscaler{
+		rcID:          rcID,
+		policyStore:   f.policyStore,
+		rcStore:       f.rcStore,
+		rcLocker:      f.rcLocker,
+		auditLogStore: f.auditLogStore,
+		txner:         f.txner,
+		session:       session,
+		httpClient:    f.httpClient,
+		logger:        logger,
+	}
+	return func(quit <-chan struct{}) {
+		child.run(quit, f.config.EvaluationInterval)
+	}
+}
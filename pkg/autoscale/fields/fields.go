@@ -0,0 +1,95 @@
+package fields
+
+import (
+	"time"
+
+	rc_fields "github.com/square/p2/pkg/rc/fields"
+	"github.com/square/p2/pkg/util"
+)
+
+// MetricType identifies an implementation of autoscale.MetricSource
+type MetricType string
+
+func (t MetricType) String() string { return string(t) }
+
+const (
+	// HTTPMetric reads the metric from an HTTP endpoint, usually served by
+	// a local agent. The response body must be a single number.
+	HTTPMetric = MetricType("http")
+)
+
+// MetricSourceConfig describes where an autoscaler reads its metric from.
+type MetricSourceConfig struct {
+	Type MetricType `json:"type"`
+
+	// URL is the endpoint read by the HTTPMetric source
+	URL string `json:"url,omitempty"`
+}
+
+func (c MetricSourceConfig) Validate() error {
+	switch c.Type {
+	case HTTPMetric:
+		if c.URL == "" {
+			return util.Errorf("%s metric source must have a url", c.Type)
+		}
+	default:
+		return util.Errorf("unknown metric source type %q", c.Type)
+	}
+	return nil
+}
+
+// Policy holds the configuration of an autoscaler for a single replication
+// controller as saved in Consul. There is at most one policy per RC, so it is
+// identified by the RC's ID.
+type Policy struct {
+	RCID rc_fields.ID `json:"rc_id"`
+
+	// The replica count of the RC is kept between MinReplicas and
+	// MaxReplicas inclusive
+	MinReplicas int `json:"min_replicas"`
+	MaxReplicas int `json:"max_replicas"`
+
+	Metric MetricSourceConfig `json:"metric"`
+
+	// TargetValue is the value of the metric per replica that the
+	// autoscaler aims for. If the metric is at twice the target, the
+	// replica count will be doubled.
+	TargetValue float64 `json:"target_value"`
+
+	// After changing the replica count, the autoscaler waits for the
+	// cooldown in the direction of the next change before making it
+	ScaleUpCooldown   time.Duration `json:"scale_up_cooldown"`
+	ScaleDownCooldown time.Duration `json:"scale_down_cooldown"`
+
+	// LastScaled is the time the autoscaler last changed the replica
+	// count. It is maintained by the autoscaler and should not be set by
+	// hand.
+	LastScaled time.Time `json:"last_scaled"`
+}
+
+// RC, Progress and SetProgress let policies be kept in an rcpolicystore. The
+// progress of a policy is the time it last scaled its RC.
+func (p Policy) RC() rc_fields.ID { return p.RCID }
+
+func (p Policy) Progress() time.Time { return p.LastScaled }
+
+func (p *Policy) SetProgress(lastScaled time.Time) { p.LastScaled = lastScaled }
+
+func (p Policy) Validate() error {
+	if p.RCID == "" {
+		return util.Errorf("autoscaler policy must have an RC ID")
+	}
+	if p.MinReplicas < 0 {
+		return util.Errorf("min replicas cannot be negative, was %d", p.MinReplicas)
+	}
+	if p.MaxReplicas < p.MinReplicas {
+		return util.Errorf("max replicas (%d) cannot be less than min replicas (%d)", p.MaxReplicas, p.MinReplicas)
+	}
+	if p.TargetValue <= 0 {
+		return util.Errorf("target value must be positive, was %v", p.TargetValue)
+	}
+	if p.ScaleUpCooldown < 0 || p.ScaleDownCooldown < 0 {
+		return util.Errorf("cooldowns cannot be negative")
+	}
+	return p.Metric.Validate()
+}
@@ -0,0 +1,92 @@
+package autoscale
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/square/p2/pkg/autoscale/fields"
+	"github.com/square/p2/pkg/util"
+	"github.com/square/p2/pkg/util/param"
+)
+
+// metricTimeoutSeconds bounds how long reading a metric may take, so that a
+// hung metrics endpoint can't stop an autoscaler from evaluating its policy
+var metricTimeoutSeconds = param.Int("autoscale_metric_timeout_seconds", 10)
+
+// MetricSource reads the current value of the metric that an autoscaler
+// compares with its policy's target value.
+type MetricSource interface {
+	Value(ctx context.Context) (float64, error)
+}
+
+// NewMetricSource returns the MetricSource described by config. The HTTP
+// client is used by sources that make HTTP requests.
+func NewMetricSource(config fields.MetricSourceConfig, client *http.Client) (MetricSource, error) {
+	err := config.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	switch config.Type {
+	case fields.HTTPMetric:
+		return HTTPMetricSource{
+			URL:     config.URL,
+			Client:  client,
+			Timeout: time.Duration(*metricTimeoutSeconds) * time.Second,
+		}, nil
+	default:
+		return nil, util.Errorf("unknown metric source type %q", config.Type)
+	}
+}
+
+// HTTPMetricSource reads a metric from an HTTP endpoint whose response body
+// is a single number, such as "0.75". It is intended for use with an agent
+// running on the same host that aggregates the metric from the RC's pods.
+// If Timeout is set, reading the metric fails once it has passed.
+type HTTPMetricSource struct {
+	URL     string
+	Client  *http.Client
+	Timeout time.Duration
+}
+
+func (s HTTPMetricSource) Value(ctx context.Context) (float64, error) {
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequest("GET", s.URL, nil)
+	if err != nil {
+		return 0, util.Errorf("could not build request for %s: %s", s.URL, err)
+	}
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, util.Errorf("could not read metric from %s: %s", s.URL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, util.Errorf("could not read metric from %s: status %d", s.URL, resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, util.Errorf("could not read metric from %s: %s", s.URL, err)
+	}
+
+	value, err := strconv.ParseFloat(strings.TrimSpace(string(body)), 64)
+	if err != nil {
+		return 0, util.Errorf("metric from %s was not a number: %s", s.URL, err)
+	}
+	return value, nil
+}
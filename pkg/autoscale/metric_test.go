@@ -0,0 +1,76 @@
+package autoscale
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/square/p2/pkg/autoscale/fields"
+)
+
+func TestHTTPMetricSource(t *testing.T) {
+	body := "0.75\n"
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+	}))
+	defer server.Close()
+
+	source, err := NewMetricSource(fields.MetricSourceConfig{Type: fields.HTTPMetric, URL: server.URL}, server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := source.Value(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != 0.75 {
+		t.Errorf("expected to read 0.75 but got %v", value)
+	}
+
+	body = "not a number"
+	_, err = source.Value(context.Background())
+	if err == nil {
+		t.Error("expected an error reading a metric that is not a number")
+	}
+
+	body = "0.75"
+	status = http.StatusInternalServerError
+	_, err = source.Value(context.Background())
+	if err == nil {
+		t.Error("expected an error reading a metric from an endpoint that failed")
+	}
+}
+
+func TestHTTPMetricSourceTimesOut(t *testing.T) {
+	unblock := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-unblock:
+		}
+	}))
+	defer server.Close()
+	defer close(unblock)
+
+	source := HTTPMetricSource{URL: server.URL, Client: server.Client(), Timeout: 50 * time.Millisecond}
+	errCh := make(chan error)
+	go func() {
+		_, err := source.Value(context.Background())
+		errCh <- err
+	}()
+
+	select {
+	case err := <-errCh:
+		if err == nil {
+			t.Error("expected an error reading a metric from a hung endpoint")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("reading a metric from a hung endpoint did not time out")
+	}
+}
@@ -0,0 +1,362 @@
+// Package disruptionbudget limits how many pods with a pod ID, or in a pod
+// cluster, may be voluntarily taken down at the same time. Callers that take
+// pods down reserve against the budget in the same consul transaction that
+// halts the pods, so that concurrent callers cannot exceed it between them.
+package disruptionbudget
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	klabels "k8s.io/kubernetes/pkg/labels"
+
+	"github.com/square/p2/pkg/disruptionbudget/fields"
+	"github.com/square/p2/pkg/health"
+	"github.com/square/p2/pkg/labels"
+	pc_fields "github.com/square/p2/pkg/pc/fields"
+	"github.com/square/p2/pkg/store/consul/disruptionbudgetstore"
+	"github.com/square/p2/pkg/types"
+	"github.com/square/p2/pkg/util"
+)
+
+// DefaultDisruptionTTL is how long a reservation counts against a budget if
+// it is not released. It should be long enough for the health of a disrupted
+// pod to reflect the disruption.
+const DefaultDisruptionTTL = 5 * time.Minute
+
+type Store interface {
+	Get(podID types.PodID, az pc_fields.AvailabilityZone, cn pc_fields.ClusterName) (fields.Budget, error)
+	SetDisruptionsTxn(ctx context.Context, budget fields.Budget, disruptions []fields.Disruption) error
+}
+
+type Labeler interface {
+	GetMatches(selector klabels.Selector, labelType labels.Type) ([]labels.Labeled, error)
+}
+
+type HealthChecker interface {
+	Service(serviceID string) (map[types.NodeName]health.Result, error)
+}
+
+// A Request describes pods that a caller intends to take down.
+type Request struct {
+	PodID types.PodID
+
+	// AvailabilityZone and ClusterName identify the pod cluster of the
+	// pods, if they belong to one. The budget of the pod cluster applies
+	// in addition to the budget of the pod ID.
+	AvailabilityZone pc_fields.AvailabilityZone
+	ClusterName      pc_fields.ClusterName
+
+	// Nodes are the nodes the pods will be taken down on. If the caller
+	// doesn't know which pods will be affected it sets Count instead.
+	Nodes []types.NodeName
+	Count int
+
+	// Holder describes the caller, and is used to release its
+	// reservations
+	Holder string
+}
+
+// Budgets checks and reserves against disruption budgets.
+type Budgets interface {
+	// ReserveTxn adds operations to the transaction in ctx that reserve
+	// the requested disruptions against every budget covering the pods.
+	// A BudgetExceededError is returned if any budget would be exceeded,
+	// in which case nothing is added to the transaction. Committing the
+	// transaction fails if a budget changed since it was checked.
+	ReserveTxn(ctx context.Context, request Request) error
+
+	// Check returns the error ReserveTxn would return for the request,
+	// without reserving anything. It is for callers that need to know
+	// whether a disruption is allowed before they can build the
+	// transaction that reserves it.
+	Check(request Request) error
+
+	// ReleaseTxn adds operations to the transaction in ctx that release
+	// the request holder's reservations on the requested nodes, or all of
+	// its reservations if no nodes are given.
+	ReleaseTxn(ctx context.Context, request Request) error
+
+	// Allowed returns the number of pods that may currently be taken down
+	// without exceeding any budget covering the request's pods. The
+	// boolean is false if no budget covers them.
+	Allowed(request Request) (int, bool, error)
+}
+
+type BudgetExceededError struct {
+	Budget    fields.Budget
+	Requested int
+	Allowed   int
+}
+
+func (e BudgetExceededError) Error() string {
+	scope := e.Budget.PodID.String()
+	if e.Budget.IsPodCluster() {
+		scope = fmt.Sprintf("%s in %s/%s", scope, e.Budget.AvailabilityZone, e.Budget.ClusterName)
+	}
+	return fmt.Sprintf("disruption budget for %s allows %d more pods to be taken down, %d were requested", scope, e.Allowed, e.Requested)
+}
+
+func IsBudgetExceeded(err error) bool {
+	_, ok := err.(BudgetExceededError)
+	return ok
+}
+
+// Checker enforces the budgets in a Store. A pod counts as available if it has
+// a pod label matching the budget, its health check is passing, and no
+// reservation has been made for its node. Reservations for pods that no longer
+// exist, or that were made without a node, count as unavailable pods until
+// they expire or are released, and reservations made without a node are also
+// taken out of the available pods. This may overlap with a replacement pod
+// being reported unhealthy, so budgets are enforced conservatively.
+type Checker struct {
+	store   Store
+	labeler Labeler
+	health  HealthChecker
+	ttl     time.Duration
+}
+
+var _ Budgets = &Checker{}
+
+func NewChecker(store Store, labeler Labeler, health HealthChecker, ttl time.Duration) *Checker {
+	if ttl == 0 {
+		ttl = DefaultDisruptionTTL
+	}
+	return &Checker{
+		store:   store,
+		labeler: labeler,
+		health:  health,
+		ttl:     ttl,
+	}
+}
+
+// budgetState is a budget along with the availability of the pods it covers
+type budgetState struct {
+	budget fields.Budget
+	active []fields.Disruption
+
+	// available holds the nodes of the covered pods that are available
+	available   types.NodeSet
+	unavailable int
+
+	// unplaced is the number of active reservations made without a node.
+	// Any of the available pods may be the ones they take down.
+	unplaced int
+}
+
+// allowed returns the number of additional disruptions the budget permits
+func (s budgetState) allowed() int {
+	var allowed int
+	if s.budget.MaxUnavailable != nil {
+		allowed = *s.budget.MaxUnavailable - s.unavailable
+	} else {
+		allowed = s.available.Len() - s.unplaced - *s.budget.MinAvailable
+	}
+	if allowed < 0 {
+		return 0
+	}
+	return allowed
+}
+
+// disruptions returns the new disruptions the request would cause. Nodes that
+// are already unavailable are not disrupted any further.
+func (s budgetState) disruptions(request Request, expires time.Time) []fields.Disruption {
+	var ret []fields.Disruption
+	if len(request.Nodes) == 0 {
+		for i := 0; i < request.Count; i++ {
+			ret = append(ret, fields.Disruption{Holder: request.Holder, Expires: expires})
+		}
+		return ret
+	}
+
+	for _, node := range request.Nodes {
+		if s.available.Has(node.String()) {
+			ret = append(ret, fields.Disruption{Holder: request.Holder, Node: node, Expires: expires})
+		}
+	}
+	return ret
+}
+
+func (c *Checker) budgets(request Request) ([]fields.Budget, error) {
+	scopes := [][2]string{{"", ""}}
+	if request.AvailabilityZone != "" && request.ClusterName != "" {
+		scopes = append(scopes, [2]string{request.AvailabilityZone.String(), request.ClusterName.String()})
+	}
+
+	var budgets []fields.Budget
+	for _, scope := range scopes {
+		budget, err := c.store.Get(request.PodID, pc_fields.AvailabilityZone(scope[0]), pc_fields.ClusterName(scope[1]))
+		switch {
+		case err == disruptionbudgetstore.NoBudget:
+		case err != nil:
+			return nil, err
+		default:
+			budgets = append(budgets, budget)
+		}
+	}
+	return budgets, nil
+}
+
+func (c *Checker) states(request Request, now time.Time) ([]budgetState, error) {
+	budgets, err := c.budgets(request)
+	if err != nil || len(budgets) == 0 {
+		return nil, err
+	}
+
+	healths, err := c.health.Service(request.PodID.String())
+	if err != nil {
+		return nil, util.Errorf("could not get health of %s: %s", request.PodID, err)
+	}
+
+	states := make([]budgetState, 0, len(budgets))
+	for _, budget := range budgets {
+		podLabels, err := c.labeler.GetMatches(budget.PodSelector(), labels.POD)
+		if err != nil {
+			return nil, err
+		}
+
+		covered := types.NewNodeSet()
+		for _, podLabel := range podLabels {
+			// pods identified by a pod unique key have no node in
+			// their label and are not counted
+			node, _, err := labels.NodeAndPodIDFromPodLabel(podLabel)
+			if err != nil {
+				continue
+			}
+			covered.InsertNode(node)
+		}
+
+		state := budgetState{
+			budget:    budget,
+			active:    budget.Active(now),
+			available: types.NewNodeSet(),
+		}
+		reserved := types.NewNodeSet()
+		for _, disruption := range state.active {
+			switch {
+			case disruption.Node == "":
+				state.unplaced++
+				state.unavailable++
+			case covered.Has(disruption.Node.String()):
+				reserved.InsertNode(disruption.Node)
+			default:
+				state.unavailable++
+			}
+		}
+		for _, node := range covered.ListNodes() {
+			result, ok := healths[node]
+			if ok && result.Status == health.Passing && !reserved.Has(node.String()) {
+				state.available.InsertNode(node)
+			} else {
+				state.unavailable++
+			}
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// plan returns the budgets covering the request's pods along with the
+// disruptions the request adds to each of them, or a BudgetExceededError if
+// any budget would be exceeded
+func (c *Checker) plan(request Request, now time.Time) ([]budgetState, [][]fields.Disruption, error) {
+	states, err := c.states(request, now)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	expires := now.Add(c.ttl).UTC()
+	toAdd := make([][]fields.Disruption, len(states))
+	for i, state := range states {
+		toAdd[i] = state.disruptions(request, expires)
+		if len(toAdd[i]) > state.allowed() {
+			return nil, nil, BudgetExceededError{
+				Budget:    state.budget,
+				Requested: len(toAdd[i]),
+				Allowed:   state.allowed(),
+			}
+		}
+	}
+	return states, toAdd, nil
+}
+
+func (c *Checker) ReserveTxn(ctx context.Context, request Request) error {
+	states, toAdd, err := c.plan(request, time.Now())
+	if err != nil {
+		return err
+	}
+
+	for i, state := range states {
+		if len(toAdd[i]) == 0 {
+			continue
+		}
+		err = c.store.SetDisruptionsTxn(ctx, state.budget, append(state.active, toAdd[i]...))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Checker) Check(request Request) error {
+	_, _, err := c.plan(request, time.Now())
+	return err
+}
+
+func (c *Checker) ReleaseTxn(ctx context.Context, request Request) error {
+	budgets, err := c.budgets(request)
+	if err != nil {
+		return err
+	}
+
+	nodes := types.NewNodeSet(request.Nodes...)
+	now := time.Now()
+	for _, budget := range budgets {
+		active := budget.Active(now)
+		var kept []fields.Disruption
+		for _, disruption := range active {
+			if disruption.Holder == request.Holder && (nodes.Len() == 0 || nodes.Has(disruption.Node.String())) {
+				continue
+			}
+			kept = append(kept, disruption)
+		}
+		if len(kept) == len(budget.Disruptions) {
+			continue
+		}
+
+		err = c.store.SetDisruptionsTxn(ctx, budget, kept)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Checker) Allowed(request Request) (int, bool, error) {
+	states, err := c.states(request, time.Now())
+	if err != nil || len(states) == 0 {
+		return 0, false, err
+	}
+
+	allowed := states[0].allowed()
+	for _, state := range states[1:] {
+		if state.allowed() < allowed {
+			allowed = state.allowed()
+		}
+	}
+	return allowed, true, nil
+}
+
+type nopBudgets struct{}
+
+// NewNop returns Budgets that allow every disruption, for callers that do not
+// enforce disruption budgets.
+func NewNop() Budgets {
+	return nopBudgets{}
+}
+
+func (nopBudgets) ReserveTxn(context.Context, Request) error { return nil }
+func (nopBudgets) Check(Request) error                       { return nil }
+func (nopBudgets) ReleaseTxn(context.Context, Request) error { return nil }
+func (nopBudgets) Allowed(Request) (int, bool, error)        { return 0, false, nil }
@@ -0,0 +1,305 @@
+package disruptionbudget
+
+import (
+	"context"
+	"testing"
+
+	"github.com/square/p2/pkg/disruptionbudget/fields"
+	"github.com/square/p2/pkg/health"
+	fake_checker "github.com/square/p2/pkg/health/checker/test"
+	"github.com/square/p2/pkg/labels"
+	"github.com/square/p2/pkg/store/consul/consulutil"
+	"github.com/square/p2/pkg/store/consul/disruptionbudgetstore"
+	"github.com/square/p2/pkg/store/consul/transaction"
+	"github.com/square/p2/pkg/types"
+)
+
+const testPodID = types.PodID("some_pod")
+
+var testNodes = []types.NodeName{"node1", "node2", "node3", "node4"}
+
+type testSetup struct {
+	fixture consulutil.Fixture
+	store   *disruptionbudgetstore.ConsulStore
+	checker *Checker
+}
+
+// setup labels a pod on each of the test nodes, all of which are healthy
+// except for unhealthy
+func setup(t *testing.T, budget fields.Budget, unhealthy ...types.NodeName) testSetup {
+	fixture := consulutil.NewFixture(t)
+	labeler := labels.NewConsulApplicator(fixture.Client, 0, 0)
+	store := disruptionbudgetstore.NewConsul(fixture.Client)
+
+	healths := make(map[types.NodeName]health.Result)
+	for _, node := range testNodes {
+		err := labeler.SetLabels(labels.POD, labels.MakePodLabelKey(node, testPodID), map[string]string{
+			types.PodIDLabel: testPodID.String(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		healths[node] = health.Result{ID: testPodID, Node: node, Status: health.Passing}
+	}
+	for _, node := range unhealthy {
+		healths[node] = health.Result{ID: testPodID, Node: node, Status: health.Critical}
+	}
+
+	err := store.Set(budget)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return testSetup{
+		fixture: fixture,
+		store:   store,
+		checker: NewChecker(store, labeler, fake_checker.NewSingleService(testPodID.String(), healths), 0),
+	}
+}
+
+func (s testSetup) reserve(t *testing.T, request Request) error {
+	ctx, cancel := transaction.New(context.Background())
+	defer cancel()
+	err := s.checker.ReserveTxn(ctx, request)
+	if err != nil {
+		return err
+	}
+	err = transaction.MustCommit(ctx, s.fixture.Client.KV())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return nil
+}
+
+func nodeRequest(holder string, nodes ...types.NodeName) Request {
+	return Request{
+		PodID:  testPodID,
+		Nodes:  nodes,
+		Holder: holder,
+	}
+}
+
+func TestReserveMaxUnavailable(t *testing.T) {
+	s := setup(t, fields.Budget{PodID: testPodID, MaxUnavailable: fields.Limit(2)}, "node4")
+	defer s.fixture.Stop()
+
+	// node4 is already unavailable, so only one more pod may be taken down
+	allowed, ok, err := s.checker.Allowed(nodeRequest("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || allowed != 1 {
+		t.Fatalf("expected 1 pod to be allowed but got %d (covered: %t)", allowed, ok)
+	}
+
+	err = s.reserve(t, nodeRequest("test", "node1", "node2"))
+	if !IsBudgetExceeded(err) {
+		t.Fatalf("expected the budget to be exceeded taking down two pods but got %v", err)
+	}
+
+	err = s.reserve(t, nodeRequest("test", "node1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.reserve(t, nodeRequest("test", "node2"))
+	if !IsBudgetExceeded(err) {
+		t.Fatalf("expected the budget to be exceeded after node1 was reserved but got %v", err)
+	}
+
+	// taking down an unavailable pod doesn't disrupt anything further
+	err = s.reserve(t, nodeRequest("test", "node4"))
+	if err != nil {
+		t.Fatalf("expected to be able to take down an unhealthy pod but got %v", err)
+	}
+}
+
+func TestReserveZeroMaxUnavailable(t *testing.T) {
+	s := setup(t, fields.Budget{PodID: testPodID, MaxUnavailable: fields.Limit(0)})
+	defer s.fixture.Stop()
+
+	allowed, ok, err := s.checker.Allowed(nodeRequest("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || allowed != 0 {
+		t.Fatalf("expected no pods to be allowed but got %d (covered: %t)", allowed, ok)
+	}
+	err = s.reserve(t, nodeRequest("test", "node1"))
+	if !IsBudgetExceeded(err) {
+		t.Fatalf("expected a budget with a max unavailable of zero to allow no disruptions but got %v", err)
+	}
+}
+
+func TestCheckDoesNotReserve(t *testing.T) {
+	s := setup(t, fields.Budget{PodID: testPodID, MaxUnavailable: fields.Limit(1)}, "node4")
+	defer s.fixture.Stop()
+
+	err := s.checker.Check(nodeRequest("test", "node1"))
+	if !IsBudgetExceeded(err) {
+		t.Fatalf("expected the budget to be exceeded with node4 unavailable but got %v", err)
+	}
+	// taking down an unavailable pod doesn't disrupt anything further
+	err = s.checker.Check(nodeRequest("test", "node4"))
+	if err != nil {
+		t.Fatalf("expected to be allowed to take down an unhealthy pod but got %v", err)
+	}
+
+	budget, err := s.store.Get(testPodID, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(budget.Disruptions) != 0 {
+		t.Fatalf("expected checking the budget not to reserve anything but found %d disruptions", len(budget.Disruptions))
+	}
+}
+
+func TestReserveMinAvailable(t *testing.T) {
+	s := setup(t, fields.Budget{PodID: testPodID, MinAvailable: fields.Limit(2)})
+	defer s.fixture.Stop()
+
+	allowed, _, err := s.checker.Allowed(nodeRequest("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allowed != 2 {
+		t.Fatalf("expected 2 pods to be allowed but got %d", allowed)
+	}
+
+	err = s.reserve(t, nodeRequest("test", "node1", "node2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.reserve(t, nodeRequest("test", "node3"))
+	if !IsBudgetExceeded(err) {
+		t.Fatalf("expected the budget to be exceeded with only 2 pods available but got %v", err)
+	}
+}
+
+func TestReserveMinAvailableCount(t *testing.T) {
+	s := setup(t, fields.Budget{PodID: testPodID, MinAvailable: fields.Limit(2)})
+	defer s.fixture.Stop()
+
+	err := s.reserve(t, Request{PodID: testPodID, Count: 1, Holder: "first"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.reserve(t, Request{PodID: testPodID, Count: 1, Holder: "second"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the reservations without a node may take down any of the 4 available
+	// pods, so the 2 that must stay available are spoken for
+	allowed, _, err := s.checker.Allowed(nodeRequest("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allowed != 0 {
+		t.Fatalf("expected no more pods to be allowed but got %d", allowed)
+	}
+
+	err = s.reserve(t, Request{PodID: testPodID, Count: 1, Holder: "third"})
+	if !IsBudgetExceeded(err) {
+		t.Fatalf("expected the budget to be exceeded by a third reservation but got %v", err)
+	}
+	err = s.reserve(t, nodeRequest("third", "node1"))
+	if !IsBudgetExceeded(err) {
+		t.Fatalf("expected the budget to be exceeded taking down node1 but got %v", err)
+	}
+}
+
+func TestConcurrentReservationsConflict(t *testing.T) {
+	s := setup(t, fields.Budget{PodID: testPodID, MaxUnavailable: fields.Limit(1)})
+	defer s.fixture.Stop()
+
+	ctx1, cancel1 := transaction.New(context.Background())
+	defer cancel1()
+	ctx2, cancel2 := transaction.New(context.Background())
+	defer cancel2()
+
+	// both reservations fit the budget when checked, but only one of them
+	// may be committed
+	err := s.checker.ReserveTxn(ctx1, nodeRequest("first", "node1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.checker.ReserveTxn(ctx2, nodeRequest("second", "node2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = transaction.MustCommit(ctx1, s.fixture.Client.KV())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = transaction.MustCommit(ctx2, s.fixture.Client.KV())
+	if err == nil {
+		t.Fatal("expected the second reservation to be rolled back")
+	}
+}
+
+func TestRelease(t *testing.T) {
+	s := setup(t, fields.Budget{PodID: testPodID, MaxUnavailable: fields.Limit(1)})
+	defer s.fixture.Stop()
+
+	err := s.reserve(t, nodeRequest("test", "node1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// releasing another holder's reservation does nothing
+	ctx, cancel := transaction.New(context.Background())
+	defer cancel()
+	err = s.checker.ReleaseTxn(ctx, nodeRequest("other", "node1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = transaction.MustCommit(ctx, s.fixture.Client.KV())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.reserve(t, nodeRequest("test", "node2"))
+	if !IsBudgetExceeded(err) {
+		t.Fatalf("expected the budget to be exceeded while node1 is reserved but got %v", err)
+	}
+
+	ctx, cancel = transaction.New(context.Background())
+	defer cancel()
+	err = s.checker.ReleaseTxn(ctx, nodeRequest("test", "node1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = transaction.MustCommit(ctx, s.fixture.Client.KV())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	budget, err := s.store.Get(testPodID, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(budget.Disruptions) != 0 {
+		t.Fatalf("expected no disruptions after release but got %+v", budget.Disruptions)
+	}
+	err = s.reserve(t, nodeRequest("test", "node2"))
+	if err != nil {
+		t.Fatalf("expected to be able to reserve node2 after node1 was released but got %v", err)
+	}
+}
+
+func TestNoBudget(t *testing.T) {
+	s := setup(t, fields.Budget{PodID: "other_pod", MaxUnavailable: fields.Limit(1)})
+	defer s.fixture.Stop()
+
+	_, ok, err := s.checker.Allowed(nodeRequest("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Fatal("expected no budget to cover the pods")
+	}
+	err = s.reserve(t, nodeRequest("test", testNodes...))
+	if err != nil {
+		t.Fatalf("expected pods without a budget to be taken down freely but got %v", err)
+	}
+}
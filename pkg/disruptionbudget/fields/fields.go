@@ -0,0 +1,99 @@
+package fields
+
+import (
+	"time"
+
+	klabels "k8s.io/kubernetes/pkg/labels"
+
+	pc_fields "github.com/square/p2/pkg/pc/fields"
+	"github.com/square/p2/pkg/types"
+	"github.com/square/p2/pkg/util"
+)
+
+// Budget limits the number of pods that may be voluntarily taken down at once,
+// for example by node transfers, rolling updates, daemon set deploys or
+// p2-rm. A budget covers either every pod with a pod ID, or only the pods of
+// one pod cluster if AvailabilityZone and ClusterName are set.
+type Budget struct {
+	PodID            types.PodID                `json:"pod_id"`
+	AvailabilityZone pc_fields.AvailabilityZone `json:"availability_zone,omitempty"`
+	ClusterName      pc_fields.ClusterName      `json:"cluster_name,omitempty"`
+
+	// Exactly one of MaxUnavailable and MinAvailable must be set.
+	// MaxUnavailable is the largest number of pods that may be
+	// unavailable, and MinAvailable is the smallest number of pods that
+	// must stay available, after a disruption. They are pointers so that
+	// a limit of zero can be told apart from an unset one, e.g. a
+	// MaxUnavailable of zero allows no voluntary disruptions at all.
+	MaxUnavailable *int `json:"max_unavailable,omitempty"`
+	MinAvailable   *int `json:"min_available,omitempty"`
+
+	// Disruptions are the reservations made against the budget. They are
+	// maintained by the callers taking pods down and should not be set by
+	// hand.
+	Disruptions []Disruption `json:"disruptions,omitempty"`
+}
+
+// A Disruption reserves one pod's worth of a budget while the pod is taken
+// down.
+type Disruption struct {
+	// Holder describes who made the reservation, e.g. "rc-node-transfer
+	// <rc id>"
+	Holder string `json:"holder"`
+
+	// Node is the node the pod is taken down on. It is empty for
+	// reservations made without knowing which pod will be affected, such
+	// as those made by rolling updates.
+	Node types.NodeName `json:"node,omitempty"`
+
+	// Expires is when the reservation stops counting against the budget.
+	// By then the pod's health should reflect the disruption.
+	Expires time.Time `json:"expires"`
+}
+
+// Limit returns a pointer to n, for setting MaxUnavailable or MinAvailable.
+func Limit(n int) *int {
+	return &n
+}
+
+func (b Budget) IsPodCluster() bool {
+	return b.AvailabilityZone != "" || b.ClusterName != ""
+}
+
+func (b Budget) Validate() error {
+	if b.PodID == "" {
+		return util.Errorf("disruption budget must have a pod ID")
+	}
+	if (b.AvailabilityZone == "") != (b.ClusterName == "") {
+		return util.Errorf("disruption budget must have both an availability zone and a cluster name, or neither")
+	}
+	if (b.MaxUnavailable == nil) == (b.MinAvailable == nil) {
+		return util.Errorf("disruption budget must set exactly one of max unavailable and min available")
+	}
+	if b.MaxUnavailable != nil && *b.MaxUnavailable < 0 || b.MinAvailable != nil && *b.MinAvailable < 0 {
+		return util.Errorf("disruption budget limits cannot be negative")
+	}
+	return nil
+}
+
+// PodSelector selects the pod labels of the pods covered by the budget.
+func (b Budget) PodSelector() klabels.Selector {
+	selector := klabels.Everything().Add(types.PodIDLabel, klabels.EqualsOperator, []string{b.PodID.String()})
+	if b.IsPodCluster() {
+		selector = selector.
+			Add(types.AvailabilityZoneLabel, klabels.EqualsOperator, []string{b.AvailabilityZone.String()}).
+			Add(types.ClusterNameLabel, klabels.EqualsOperator, []string{b.ClusterName.String()})
+	}
+	return selector
+}
+
+// Active returns the disruptions that have not expired by now.
+func (b Budget) Active(now time.Time) []Disruption {
+	var active []Disruption
+	for _, d := range b.Disruptions {
+		if d.Expires.After(now) {
+			active = append(active, d)
+		}
+	}
+	return active
+}
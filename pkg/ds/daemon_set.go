@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"github.com/square/p2/pkg/disruptionbudget"
 	"github.com/square/p2/pkg/ds/fields"
 	"github.com/square/p2/pkg/health"
 	"github.com/square/p2/pkg/health/checker"
@@ -76,16 +77,23 @@ type DaemonSet interface {
 	// CurrentPods() returns all nodes that are scheduled by this daemon set
 	CurrentPods() (types.PodLocations, error)
 
-	Replicate(context.Context, <-chan []types.NodeName, <-chan struct{}, <-chan struct{}, <-chan manifest.Manifest, <-chan time.Duration)
+	Replicate(context.Context, <-chan []types.NodeName, <-chan struct{}, <-chan struct{}, <-chan manifest.Manifest, <-chan time.Duration, <-chan Rollout)
+}
+
+// Rollout holds the fields of a daemon set that control how it deploys a new
+// manifest
+type Rollout struct {
+	MaxInFlight fields.MaxInFlight
+	CanaryNodes int
 }
 
 type Labeler interface {
 	SetLabelsTxn(ctx context.Context, labelType labels.Type, id string, labels map[string]string) error
-	RemoveLabelTxn(
+	RemoveLabelsTxn(
 		ctx context.Context,
 		labelType labels.Type,
 		id string,
-		name string,
+		keysToRemove []string,
 	) error
 	GetMatches(selector klabels.Selector, labelType labels.Type) ([]labels.Labeled, error)
 	GetCachedMatches(selector klabels.Selector, labelType labels.Type, aggregationRate time.Duration) ([]labels.Labeled, error)
@@ -164,6 +172,8 @@ type daemonSet struct {
 	labelsAggregationRate time.Duration
 
 	retryInterval time.Duration
+
+	disruptions disruptionbudget.Budgets
 }
 
 type dsReplication struct {
@@ -188,11 +198,15 @@ func New(
 	unlocker consul.TxnUnlocker,
 	statusStore StatusStore,
 	statusWritingInterval time.Duration,
+	disruptions disruptionbudget.Budgets,
 ) DaemonSet {
 
 	if retryInterval == 0 {
 		retryInterval = DefaultRetryInterval
 	}
+	if disruptions == nil {
+		disruptions = disruptionbudget.NewNop()
+	}
 
 	return &daemonSet{
 		DaemonSet: fields,
@@ -214,6 +228,7 @@ func New(
 		unlocker:              unlocker,
 		statusWritingInterval: statusWritingInterval,
 		statusStore:           statusStore,
+		disruptions:           disruptions,
 	}
 }
 
@@ -259,6 +274,15 @@ func (ds *daemonSet) MinHealth() int {
 	return ds.DaemonSet.MinHealth
 }
 
+func (ds *daemonSet) Rollout() Rollout {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	return Rollout{
+		MaxInFlight: ds.DaemonSet.MaxInFlight,
+		CanaryNodes: ds.DaemonSet.CanaryNodes,
+	}
+}
+
 func (ds *daemonSet) EligibleNodes() ([]types.NodeName, error) {
 	ds.mu.Lock()
 	m := ds.DaemonSet.Manifest
@@ -311,7 +335,10 @@ func (ds *daemonSet) WatchDesires(
 	unpauseReplication := make(chan struct{})
 	manifestChange := make(chan manifest.Manifest)
 	timeoutChange := make(chan time.Duration)
-	go ds.Replicate(ctx, nodesToAdd, pauseReplication, unpauseReplication, manifestChange, timeoutChange)
+	// rolloutChange holds only the latest rollout so that sending to it
+	// never blocks this loop, see sendRollout()
+	rolloutChange := make(chan Rollout, 1)
+	go ds.Replicate(ctx, nodesToAdd, pauseReplication, unpauseReplication, manifestChange, timeoutChange, rolloutChange)
 
 	nodesChangedCh := ds.watcher.WatchMatchDiff(ds.NodeSelector, labels.NODE, ds.labelsAggregationRate, watchMatchQuitCh)
 	// Do something whenever something is changed
@@ -409,9 +436,14 @@ func (ds *daemonSet) WatchDesires(
 				if ds.Timeout != newDS.Timeout {
 					timeoutChange <- newDS.Timeout
 				}
+				rolloutChanged := ds.MaxInFlight != newDS.MaxInFlight || ds.CanaryNodes != newDS.CanaryNodes
 				ds.DaemonSet = newDS
 				ds.mu.Unlock()
 
+				if rolloutChanged {
+					sendRollout(rolloutChange, ds.Rollout())
+				}
+
 				if reportErr := ds.reportEligible(); reportErr != nil {
 					// An error in sending the metrics shouldn't stop us from doing updates.
 					// Report it, and move on.
@@ -425,7 +457,7 @@ func (ds *daemonSet) WatchDesires(
 					continue
 				}
 
-				if paused || manifestChanged {
+				if paused || manifestChanged || rolloutChanged {
 					if paused {
 						ds.logger.Infoln("daemon set enabled, unpausing replication")
 					}
@@ -434,7 +466,8 @@ func (ds *daemonSet) WatchDesires(
 					manifestChange <- ds.Manifest()
 
 					ds.logger.Infoln("kicking off replication for all nodes")
-					// schedule all the nodes again cuz the manifest changed or we unpaused
+					// schedule all the nodes again cuz the manifest changed or we unpaused,
+					// or the rollout changed which retries nodes skipped after a failed canary
 					eligibleNodes, err = ds.EligibleNodes()
 					if err != nil {
 						err = util.Errorf("Unable to compute eligible nodes: %v", err)
@@ -576,6 +609,25 @@ func (ds *daemonSet) computeNodesToAdd() ([]types.NodeName, error) {
 	return toScheduleSorted, nil
 }
 
+// selectedNodes returns the nodes selected by ds.nodeSelector. Unlike
+// EligibleNodes it includes cordoned nodes: cordoning a node only keeps new
+// pods off of it, so the daemon set's pods already on it are left alone
+func (ds *daemonSet) selectedNodes() ([]types.NodeName, error) {
+	ds.mu.Lock()
+	nodeSelector := ds.DaemonSet.NodeSelector
+	ds.mu.Unlock()
+
+	matches, err := ds.applicator.GetMatches(nodeSelector, labels.NODE)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]types.NodeName, len(matches))
+	for i, match := range matches {
+		result[i] = types.NodeName(match.ID)
+	}
+	return result, nil
+}
+
 // removePods unschedules pods for all scheduled nodes not selected
 // by ds.nodeSelector
 func (ds *daemonSet) removePods() error {
@@ -585,33 +637,43 @@ func (ds *daemonSet) removePods() error {
 	}
 	currentNodes := podLocations.Nodes()
 
-	eligible, err := ds.EligibleNodes()
+	selected, err := ds.selectedNodes()
 	if err != nil {
-		return util.Errorf("Error retrieving eligible nodes for daemon set: %v", err)
+		return util.Errorf("Error retrieving selected nodes for daemon set: %v", err)
 	}
 
-	if len(eligible) == 0 {
-		return util.Errorf("No nodes eligible; daemon set refuses to unschedule everything.")
+	if len(selected) == 0 {
+		return util.Errorf("No nodes selected; daemon set refuses to unschedule everything.")
 	}
 
 	// Get the difference in nodes that we need to unschedule on and then sort them
 	// for deterministic ordering
-	toUnscheduleSorted := types.NewNodeSet(currentNodes...).Difference(types.NewNodeSet(eligible...)).ListNodes()
+	toUnscheduleSorted := types.NewNodeSet(currentNodes...).Difference(types.NewNodeSet(selected...)).ListNodes()
 	if len(toUnscheduleSorted) == 0 {
 		return nil
 	}
 
-	ds.logger.NoFields().Infof("Need to unschedule %d nodes, remaining on %d nodes", len(toUnscheduleSorted), len(eligible))
+	ds.logger.NoFields().Infof("Need to unschedule %d nodes, remaining on %d nodes", len(toUnscheduleSorted), len(selected))
 
 	// NOTE: there's it's possible that this node is in the replication's
 	// nodeQueue still and therefore it will be scheduled again, but for
 	// now we're willing to deal with that tradeoff
+	var budgetExceeded error
 	for _, node := range toUnscheduleSorted {
 		err := ds.unschedule(node)
-		if err != nil {
+		switch {
+		case disruptionbudget.IsBudgetExceeded(err):
+			// keep unscheduling the nodes the budget allows, the
+			// rest are retried after the retry interval
+			ds.logger.WithError(err).Infof("Waiting for disruption budget to unschedule node '%v'", node)
+			budgetExceeded = err
+		case err != nil:
 			return util.Errorf("Error unscheduling node: %v", err)
 		}
 	}
+	if budgetExceeded != nil {
+		return util.Errorf("Could not unschedule every node within the disruption budget: %v", budgetExceeded)
+	}
 
 	return nil
 }
@@ -622,17 +684,25 @@ func (ds *daemonSet) unschedule(node types.NodeName) error {
 	ctx, cancel := transaction.New(context.Background())
 	defer cancel()
 
+	// Removing the pod is a voluntary disruption, so it is reserved
+	// against the pod's disruption budgets in the same transaction. The
+	// reservation is left to expire since the pod is not coming back
+	err := ds.disruptions.ReserveTxn(ctx, ds.disruptionRequest(node))
+	if err != nil {
+		return err
+	}
+
 	// Will remove the following key:
 	// <consul.INTENT_TREE>/<node>/<ds.Manifest.ID()>
-	err := ds.store.DeletePodTxn(ctx, consul.INTENT_TREE, node, ds.Manifest().ID())
+	err = ds.store.DeletePodTxn(ctx, consul.INTENT_TREE, node, ds.Manifest().ID())
 	if err != nil {
 		return util.Errorf("unable to form pod deletion transaction for pod id '%v' from node '%v': %v", ds.Manifest().ID(), node, err)
 	}
 
-	// Will remove the following label on the key <labels.POD>/<node>/<ds.Manifest.ID()>: DSIDLabel
+	// Will remove the following labels on the key <labels.POD>/<node>/<ds.Manifest.ID()>: DSIDLabel, PodIDLabel
 	// This is for indicating that this pod path no longer belongs to this daemon set
 	id := labels.MakePodLabelKey(node, ds.Manifest().ID())
-	err = ds.applicator.RemoveLabelTxn(ctx, labels.POD, id, DSIDLabel)
+	err = ds.applicator.RemoveLabelsTxn(ctx, labels.POD, id, []string{DSIDLabel, types.PodIDLabel})
 	if err != nil {
 		return util.Errorf("error adding label removal to transaction: %v", err)
 	}
@@ -645,6 +715,16 @@ func (ds *daemonSet) unschedule(node types.NodeName) error {
 	return nil
 }
 
+// disruptionRequest describes the removal of the daemon set's pod from node
+// to the pod's disruption budgets
+func (ds *daemonSet) disruptionRequest(node types.NodeName) disruptionbudget.Request {
+	return disruptionbudget.Request{
+		PodID:  ds.Manifest().ID(),
+		Nodes:  []types.NodeName{node},
+		Holder: fmt.Sprintf("daemon-set-%s", ds.ID()),
+	}
+}
+
 func (ds *daemonSet) Replicate(
 	ctx context.Context,
 	nodesToAdd <-chan []types.NodeName,
@@ -652,6 +732,7 @@ func (ds *daemonSet) Replicate(
 	unpauseReplication <-chan struct{},
 	manifestChange <-chan manifest.Manifest,
 	timeoutChange <-chan time.Duration,
+	rolloutChange <-chan Rollout,
 ) {
 	nodeQueue := make(chan types.NodeName)
 
@@ -677,11 +758,12 @@ func (ds *daemonSet) Replicate(
 		}
 
 		lockMessage := fmt.Sprintf("%q from %q at %q", thisUser.Username, thisHost, time.Now())
+		rollout := ds.Rollout()
 		repl, err := replication.NewReplicator(
 			ds.Manifest(),
 			ds.logger,
 			nodes,
-			50, // always use max parallelism for daemon set deploys
+			ds.maxInFlightNodes(rollout.MaxInFlight, len(nodes)),
 			ds.store,
 			ds.txner,
 			ds.applicator,
@@ -690,6 +772,7 @@ func (ds *daemonSet) Replicate(
 			lockMessage,
 			ds.Timeout,
 			ds.healthWatchDelay,
+			ds.disruptions,
 		)
 		if err != nil {
 			ds.logger.WithError(err).Errorln("Could not initialize replicator")
@@ -698,8 +781,11 @@ func (ds *daemonSet) Replicate(
 
 		ds.logger.Info("New replicator was made")
 
+		// the pod ID label lets disruption budgets count the daemon
+		// set's pods
 		podLabels := map[string]string{
-			DSIDLabel: ds.ID().String(),
+			DSIDLabel:        ds.ID().String(),
+			types.PodIDLabel: ds.Manifest().ID().String(),
 		}
 
 		// Replication locks are designed to make sure that two replications to
@@ -727,6 +813,10 @@ func (ds *daemonSet) Replicate(
 
 		ds.logger.Info("Replication initialized")
 
+		// this must happen before the replication is enacted so that
+		// no nodes are updated before the canaries
+		replication.SetCanaryNodes(rollout.CanaryNodes)
+
 		// auto-drain this channel
 		go func() {
 			for err := range errCh {
@@ -768,18 +858,29 @@ func (ds *daemonSet) Replicate(
 				}
 			case timeout := <-timeoutChange:
 				ds.getDSReplication().replication.SetTimeout(timeout)
+			case rollout := <-rolloutChange:
+				ds.setRollout(rollout)
 			default:
 			}
 
-			select {
-			case nodeQueue <- node:
-			case <-ctx.Done():
-				return
-			case <-pauseReplication:
-				paused = true
-				return
-			case <-unpauseReplication:
-				paused = false
+			for {
+				select {
+				case nodeQueue <- node:
+				case <-ctx.Done():
+					return
+				case <-pauseReplication:
+					paused = true
+					return
+				case <-unpauseReplication:
+					paused = false
+				case rollout := <-rolloutChange:
+					// the node queue is blocked while canaries are
+					// failing, and changing the rollout is how
+					// that is resolved
+					ds.setRollout(rollout)
+					continue
+				}
+				break
 			}
 		}
 	}
@@ -799,6 +900,8 @@ func (ds *daemonSet) Replicate(
 			}
 		case timeout := <-timeoutChange:
 			ds.getDSReplication().replication.SetTimeout(timeout)
+		case rollout := <-rolloutChange:
+			ds.setRollout(rollout)
 		case <-pauseReplication:
 			paused = true
 		case <-unpauseReplication:
@@ -809,6 +912,55 @@ func (ds *daemonSet) Replicate(
 	}
 }
 
+// maxInFlightNodes resolves the number of nodes to deploy to at once. Daemon
+// sets without a limit use the replication's maximum.
+func (ds *daemonSet) maxInFlightNodes(maxInFlight fields.MaxInFlight, eligible int) int {
+	nodes, err := maxInFlight.Nodes(eligible)
+	if err != nil {
+		ds.logger.WithError(err).Errorln("invalid max in flight, using the maximum")
+		return replication.MaxActive
+	}
+	if nodes == 0 || nodes > replication.MaxActive {
+		return replication.MaxActive
+	}
+	return nodes
+}
+
+// sendRollout replaces any rollout that Replicate() has not received yet
+// with rollout. It never blocks as long as it is the only sender on ch.
+func sendRollout(ch chan Rollout, rollout Rollout) {
+	for {
+		select {
+		case ch <- rollout:
+			return
+		default:
+		}
+
+		select {
+		case <-ch:
+		default:
+		}
+	}
+}
+
+// setRollout applies changed rollout fields to the replication in progress
+func (ds *daemonSet) setRollout(rollout Rollout) {
+	dsReplication := ds.getDSReplication()
+	if dsReplication == nil || dsReplication.replication == nil {
+		// the rollout is read when the replication is started
+		return
+	}
+
+	eligible, err := ds.EligibleNodes()
+	if err != nil {
+		ds.logger.WithError(err).Errorln("could not compute eligible nodes to resolve max in flight")
+	}
+
+	repl := dsReplication.replication
+	repl.SetActive(ds.maxInFlightNodes(rollout.MaxInFlight, len(eligible)))
+	repl.SetCanaryNodes(rollout.CanaryNodes)
+}
+
 func (ds *daemonSet) setDSReplication(rep *dsReplication) {
 	ds.dsReplicationMu.Lock()
 	defer ds.dsReplicationMu.Unlock()
@@ -897,6 +1049,7 @@ func (ds *daemonSet) writeNewestStatus(ctx context.Context, lastStatus daemonset
 	}
 
 	toWrite.ManifestSHA = manifestSHA
+	toWrite.CanaryNodes = ds.Rollout().CanaryNodes
 	toWrite.NodesDeployed = lastStatus.NodesDeployed
 	if toWrite.ManifestSHA != lastStatus.ManifestSHA {
 		// reset the deployed count if the manifest has changed
@@ -914,6 +1067,9 @@ func (ds *daemonSet) writeNewestStatus(ctx context.Context, lastStatus daemonset
 		if int(dsReplication.replication.CompletedCount()) > toWrite.NodesDeployed {
 			toWrite.NodesDeployed = int(dsReplication.replication.CompletedCount())
 		}
+
+		toWrite.MaxInFlight = dsReplication.replication.Active()
+		toWrite.CanaryNodesHealthy = dsReplication.replication.CanaryNodesHealthy()
 	}
 
 	if toWrite == lastStatus {
@@ -8,6 +8,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/square/p2/pkg/disruptionbudget"
+	"github.com/square/p2/pkg/health"
 	"github.com/square/p2/pkg/replication"
 	"github.com/square/p2/pkg/store/consul"
 	"github.com/square/p2/pkg/util"
@@ -17,6 +19,7 @@ import (
 	"github.com/square/p2/pkg/manifest"
 	"github.com/square/p2/pkg/store/consul/consultest"
 	"github.com/square/p2/pkg/store/consul/consulutil"
+	"github.com/square/p2/pkg/store/consul/disruptionbudgetstore"
 	"github.com/square/p2/pkg/store/consul/dsstore"
 	"github.com/square/p2/pkg/store/consul/statusstore"
 	"github.com/square/p2/pkg/store/consul/statusstore/daemonsetstatus"
@@ -25,6 +28,7 @@ import (
 
 	. "github.com/anthonybishopric/gotcha"
 	"github.com/gofrs/uuid"
+	budget_fields "github.com/square/p2/pkg/disruptionbudget/fields"
 	ds_fields "github.com/square/p2/pkg/ds/fields"
 	fake_checker "github.com/square/p2/pkg/health/checker/test"
 	klabels "k8s.io/kubernetes/pkg/labels"
@@ -228,6 +232,7 @@ func TestSchedule(t *testing.T) {
 		nullUnlocker{},
 		statusStore,
 		DefaultStatusWritingInterval,
+		nil,
 	).(*daemonSet)
 
 	labeled := labeledPods(t, ds)
@@ -493,6 +498,7 @@ func TestPublishToReplication(t *testing.T) {
 		nullUnlocker{},
 		statusStore,
 		DefaultStatusWritingInterval,
+		nil,
 	).(*daemonSet)
 
 	labeled := labeledPods(t, ds)
@@ -573,6 +579,163 @@ func TestPublishToReplication(t *testing.T) {
 	Assert(t).IsNil(err, "unexpectedly unlabeled")
 }
 
+// newRemovalTestDaemonSet returns a daemon set selecting nodes labeled
+// nodeQuality=good, with pods already scheduled on node1 and node2
+func newRemovalTestDaemonSet(t *testing.T, fixture consulutil.Fixture, disruptions disruptionbudget.Budgets) (*daemonSet, *labels.ConsulApplicator, store) {
+	dsStore := dsstore.NewConsul(fixture.Client, 0, &logging.DefaultLogger)
+	podManifest := testManifest("testPod")
+	nodeSelector := klabels.Everything().Add("nodeQuality", klabels.EqualsOperator, []string{"good"})
+
+	ctx, cancel := transaction.New(context.Background())
+	defer cancel()
+	dsData, err := dsStore.Create(ctx, podManifest, 0, "some_name", nodeSelector, "testPod", replicationTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = transaction.MustCommit(ctx, fixture.Client.KV())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	consulStore := consul.NewConsulStore(fixture.Client)
+	applicator := labels.NewConsulApplicator(fixture.Client, 0, 0)
+	healthChecker := fake_checker.HappyHealthChecker([]types.NodeName{"node1", "node2"})
+	statusStore := daemonsetstatus.NewConsul(statusstore.NewConsul(fixture.Client), "test_removal")
+	ds := New(
+		dsData,
+		dsStore,
+		consulStore,
+		fixture.Client.KV(),
+		applicator,
+		applicator,
+		1*time.Nanosecond,
+		logging.DefaultLogger,
+		&healthChecker,
+		0,
+		false,
+		0,
+		testFarmRetryInterval,
+		nullUnlocker{},
+		statusStore,
+		DefaultStatusWritingInterval,
+		disruptions,
+	).(*daemonSet)
+
+	for _, node := range []types.NodeName{"node1", "node2"} {
+		err = applicator.SetLabel(labels.NODE, node.String(), "nodeQuality", "good")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = consulStore.SetPod(consul.INTENT_TREE, node, podManifest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = applicator.SetLabels(labels.POD, labels.MakePodLabelKey(node, "testPod"), map[string]string{
+			DSIDLabel:        ds.ID().String(),
+			types.PodIDLabel: "testPod",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ds, applicator, consulStore
+}
+
+func TestRemovePodsLeavesPodsOnCordonedNodes(t *testing.T) {
+	fixture := consulutil.NewFixture(t)
+	defer fixture.Stop()
+	ds, applicator, consulStore := newRemovalTestDaemonSet(t, fixture, nil)
+
+	err := applicator.SetLabel(labels.NODE, "node1", types.CordonedLabel, "true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	eligible, err := ds.EligibleNodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(eligible) != 1 || eligible[0] != "node2" {
+		t.Errorf("expected only node2 to be eligible for new pods but got %s", eligible)
+	}
+
+	err = ds.removePods()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(labeledPods(t, ds)) != 2 {
+		t.Error("expected the pod on the cordoned node to stay labeled")
+	}
+	err = waitForPodsInIntent(consulStore, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// nodes that are no longer selected still lose their pods
+	err = applicator.SetLabel(labels.NODE, "node2", "nodeQuality", "bad")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ds.removePods()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = waitForPodsInIntent(consulStore, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRemovePodsReservesDisruptionBudget(t *testing.T) {
+	fixture := consulutil.NewFixture(t)
+	defer fixture.Stop()
+	applicator := labels.NewConsulApplicator(fixture.Client, 0, 0)
+	budgetStore := disruptionbudgetstore.NewConsul(fixture.Client)
+	healthChecker := fake_checker.NewSingleService("testPod", map[types.NodeName]health.Result{
+		"node1": {ID: "testPod", Node: "node1", Status: health.Passing},
+		"node2": {ID: "testPod", Node: "node2", Status: health.Passing},
+	})
+	budgets := disruptionbudget.NewChecker(budgetStore, applicator, healthChecker, 0)
+	ds, applicator, consulStore := newRemovalTestDaemonSet(t, fixture, budgets)
+
+	err := budgetStore.Set(budget_fields.Budget{PodID: "testPod", MaxUnavailable: budget_fields.Limit(0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = applicator.SetLabel(labels.NODE, "node2", "nodeQuality", "bad")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ds.removePods()
+	if err == nil {
+		t.Fatal("expected an error unscheduling a pod that the disruption budget doesn't allow to be taken down")
+	}
+	err = waitForPodsInIntent(consulStore, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = budgetStore.Set(budget_fields.Budget{PodID: "testPod", MaxUnavailable: budget_fields.Limit(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ds.removePods()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = waitForPodsInIntent(consulStore, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	budget, err := budgetStore.Get("testPod", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(budget.Disruptions) != 1 || budget.Disruptions[0].Node != "node2" {
+		t.Errorf("expected the removal from node2 to be reserved against the budget but got %+v", budget.Disruptions)
+	}
+}
+
 // nullUnlocker satisfies consul.TxnUnlocker to avoid npe in tests but it doesn't actually do anything
 type nullUnlocker struct {
 }
@@ -624,6 +787,22 @@ func (n nullReplication) SetTimeout(time.Duration) {
 	panic("SetTimeout() not implemented on nullReplication")
 }
 
+func (n nullReplication) SetActive(int) {
+	panic("SetActive() not implemented on nullReplication")
+}
+
+func (n nullReplication) Active() int {
+	return 0
+}
+
+func (n nullReplication) SetCanaryNodes(int) {
+	panic("SetCanaryNodes() not implemented on nullReplication")
+}
+
+func (n nullReplication) CanaryNodesHealthy() int {
+	return 0
+}
+
 func TestWriteNewestStatus(t *testing.T) {
 	type writeStatusTestCase struct {
 		lastStatus         daemonsetstatus.Status
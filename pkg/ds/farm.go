@@ -10,6 +10,7 @@ import (
 	"github.com/sirupsen/logrus"
 
 	"github.com/square/p2/pkg/alerting"
+	"github.com/square/p2/pkg/disruptionbudget"
 	"github.com/square/p2/pkg/ds/fields"
 	ds_fields "github.com/square/p2/pkg/ds/fields"
 	"github.com/square/p2/pkg/health/checker"
@@ -107,6 +108,10 @@ type DSFarmConfig struct {
 	PodWhitelist []types.PodID `yaml:"pod_whitelist" json:"pod_whitelist"`
 
 	StatusWritingInterval time.Duration
+
+	// DisruptionBudgets, if set, limits how many pods daemon set
+	// replications may take down at once
+	DisruptionBudgets disruptionbudget.Budgets `yaml:"-" json:"-"`
 }
 
 func NewFarm(
@@ -252,7 +257,7 @@ func (dsf *Farm) cleanupDaemonSetPods(ctx context.Context) {
 			}
 
 			id := labels.MakePodLabelKey(nodeName, podID)
-			err = dsf.labeler.RemoveLabelTxn(ctx, labels.POD, id, DSIDLabel)
+			err = dsf.labeler.RemoveLabelsTxn(ctx, labels.POD, id, []string{DSIDLabel, types.PodIDLabel})
 			if err != nil {
 				dsf.logger.NoFields().Errorf("Error removing ds pod id label '%v': %v", id, err)
 				cancel()
@@ -558,6 +563,7 @@ func (dsf *Farm) spawnDaemonSet(
 		unlocker,
 		dsf.statusStore,
 		dsf.statusWritingInterval,
+		dsf.config.DisruptionBudgets,
 	)
 
 	updatedCh := make(chan ds_fields.DaemonSet)
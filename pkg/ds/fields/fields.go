@@ -2,11 +2,14 @@ package fields
 
 import (
 	"encoding/json"
+	"strconv"
+	"strings"
 	"time"
 
-	"k8s.io/kubernetes/pkg/labels"
+	klabels "k8s.io/kubernetes/pkg/labels"
 
 	"github.com/gofrs/uuid"
+	"github.com/square/p2/pkg/labels"
 	"github.com/square/p2/pkg/manifest"
 	"github.com/square/p2/pkg/types"
 	"github.com/square/p2/pkg/util"
@@ -51,12 +54,61 @@ type DaemonSet struct {
 	Name ClusterName
 
 	// Defines the set of nodes on which the manifest can be scheduled
-	NodeSelector labels.Selector
+	NodeSelector klabels.Selector
 
 	// PodID to deploy
 	PodID types.PodID
 
 	Timeout time.Duration
+
+	// MaxInFlight limits how many nodes are deployed to at once
+	MaxInFlight MaxInFlight
+
+	// CanaryNodes is the number of nodes that must be deployed to and
+	// healthy with a new manifest before any other nodes are deployed to
+	CanaryNodes int
+}
+
+// MaxInFlight is either a number of nodes, e.g. "10", or a percentage of a
+// daemon set's eligible nodes, e.g. "25%". The empty value leaves the number
+// of nodes up to the replication.
+type MaxInFlight string
+
+func (m MaxInFlight) String() string {
+	return string(m)
+}
+
+func (m MaxInFlight) Validate() error {
+	_, err := m.Nodes(1)
+	return err
+}
+
+// Nodes resolves the limit against the number of eligible nodes. Percentages
+// are rounded up so that at least one node is deployed to at a time. Zero is
+// returned for the empty value.
+func (m MaxInFlight) Nodes(eligible int) (int, error) {
+	if m == "" {
+		return 0, nil
+	}
+
+	s := string(m)
+	isPercent := strings.HasSuffix(s, "%")
+	n, err := strconv.Atoi(strings.TrimSuffix(s, "%"))
+	if err != nil {
+		return 0, util.Errorf("max in flight must be a number of nodes or a percentage, was %q", s)
+	}
+	if n < 1 || (isPercent && n > 100) {
+		return 0, util.Errorf("max in flight must be a positive number of nodes or a percentage no greater than 100%%, was %q", s)
+	}
+	if !isPercent {
+		return n, nil
+	}
+
+	nodes := (eligible*n + 99) / 100
+	if nodes < 1 {
+		nodes = 1
+	}
+	return nodes, nil
 }
 
 // RawDaemonSet defines the JSON format used to store data into Consul
@@ -69,6 +121,8 @@ type RawDaemonSet struct {
 	NodeSelector string        `json:"node_selector"`
 	PodID        types.PodID   `json:"pod_id"`
 	Timeout      time.Duration `json:"timeout"`
+	MaxInFlight  MaxInFlight   `json:"max_in_flight,omitempty"`
+	CanaryNodes  int           `json:"canary_nodes,omitempty"`
 }
 
 // MarshalJSON implements the json.Marshaler interface for serializing the DS
@@ -107,6 +161,8 @@ func (ds *DaemonSet) ToRaw() (RawDaemonSet, error) {
 		NodeSelector: nodeSelector,
 		PodID:        ds.PodID,
 		Timeout:      ds.Timeout,
+		MaxInFlight:  ds.MaxInFlight,
+		CanaryNodes:  ds.CanaryNodes,
 	}, nil
 }
 
@@ -131,7 +187,7 @@ func (ds *DaemonSet) UnmarshalJSON(b []byte) error {
 		}
 	}
 
-	nodeSelector, err := labels.Parse(rawDS.NodeSelector)
+	nodeSelector, err := labels.ParseSelector(rawDS.NodeSelector)
 	if err != nil {
 		return err
 	}
@@ -145,9 +201,94 @@ func (ds *DaemonSet) UnmarshalJSON(b []byte) error {
 		NodeSelector: nodeSelector,
 		PodID:        rawDS.PodID,
 		Timeout:      rawDS.Timeout,
+		MaxInFlight:  rawDS.MaxInFlight,
+		CanaryNodes:  rawDS.CanaryNodes,
 	}
 	return nil
 }
 
 // Assert DaemonSet.UnmarshalJSON is implemented in json.Unmarshaler
 var _ json.Unmarshaler = &DaemonSet{}
+
+// Revision is a version of a daemon set's manifest and node selector, kept
+// so that a daemon set can be rolled back to it
+type Revision struct {
+	// Revisions of a daemon set are numbered from 1 in the order they
+	// were made
+	Number int
+
+	Manifest manifest.Manifest
+
+	NodeSelector klabels.Selector
+
+	// When the daemon set was changed to this revision
+	Created time.Time
+}
+
+// RawRevision defines the JSON format used to store revisions in Consul
+type RawRevision struct {
+	Number       int       `json:"number"`
+	Manifest     string    `json:"manifest"`
+	NodeSelector string    `json:"node_selector"`
+	Created      time.Time `json:"created"`
+}
+
+// MarshalJSON implements the json.Marshaler interface for serializing the
+// revision to JSON format
+func (r Revision) MarshalJSON() ([]byte, error) {
+	var manifest []byte
+	var err error
+	if r.Manifest != nil {
+		manifest, err = r.Manifest.Marshal()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var nodeSelector string
+	if r.NodeSelector != nil {
+		nodeSelector = r.NodeSelector.String()
+	}
+
+	return json.Marshal(RawRevision{
+		Number:       r.Number,
+		Manifest:     string(manifest),
+		NodeSelector: nodeSelector,
+		Created:      r.Created,
+	})
+}
+
+var _ json.Marshaler = Revision{}
+
+// UnmarshalJSON implements the json.Unmarshaler interface for deserializing
+// the JSON representation of a revision
+func (r *Revision) UnmarshalJSON(b []byte) error {
+	var rawRevision RawRevision
+	if err := json.Unmarshal(b, &rawRevision); err != nil {
+		return err
+	}
+
+	var podManifest manifest.Manifest
+	if rawRevision.Manifest != "" {
+		var err error
+		podManifest, err = manifest.FromBytes([]byte(rawRevision.Manifest))
+		if err != nil {
+			return err
+		}
+	}
+
+	nodeSelector, err := labels.ParseSelector(rawRevision.NodeSelector)
+	if err != nil {
+		return err
+	}
+
+	*r = Revision{
+		Number:       rawRevision.Number,
+		Manifest:     podManifest,
+		NodeSelector: nodeSelector,
+		Created:      rawRevision.Created,
+	}
+	return nil
+}
+
+var _ json.Unmarshaler = &Revision{}
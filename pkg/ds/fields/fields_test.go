@@ -12,3 +12,58 @@ func TestZeroUnmarshal(t *testing.T) {
 		t.Fatal("error unmarshaling:", err)
 	}
 }
+
+func TestMaxInFlightNodes(t *testing.T) {
+	for _, test := range []struct {
+		maxInFlight MaxInFlight
+		eligible    int
+		nodes       int
+		err         bool
+	}{
+		{maxInFlight: "", eligible: 10, nodes: 0},
+		{maxInFlight: "3", eligible: 10, nodes: 3},
+		{maxInFlight: "25%", eligible: 10, nodes: 3},
+		{maxInFlight: "1%", eligible: 10, nodes: 1},
+		{maxInFlight: "100%", eligible: 10, nodes: 10},
+		{maxInFlight: "50%", eligible: 0, nodes: 1},
+		{maxInFlight: "0", err: true},
+		{maxInFlight: "0%", err: true},
+		{maxInFlight: "101%", err: true},
+		{maxInFlight: "-1", err: true},
+		{maxInFlight: "lots", err: true},
+	} {
+		nodes, err := test.maxInFlight.Nodes(test.eligible)
+		if test.err {
+			if err == nil {
+				t.Errorf("expected an error for max in flight %q", test.maxInFlight)
+			}
+			if test.maxInFlight.Validate() == nil {
+				t.Errorf("expected max in flight %q to be invalid", test.maxInFlight)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error for max in flight %q: %s", test.maxInFlight, err)
+			continue
+		}
+		if nodes != test.nodes {
+			t.Errorf("expected max in flight %q of %d eligible nodes to be %d but was %d", test.maxInFlight, test.eligible, test.nodes, nodes)
+		}
+	}
+}
+
+func TestRolloutRoundTrip(t *testing.T) {
+	ds := DaemonSet{ID: "some_ds", MaxInFlight: "10%", CanaryNodes: 2}
+	bytes, err := json.Marshal(ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var unmarshaled DaemonSet
+	err = json.Unmarshal(bytes, &unmarshaled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unmarshaled.MaxInFlight != ds.MaxInFlight || unmarshaled.CanaryNodes != ds.CanaryNodes {
+		t.Errorf("expected max in flight %q and %d canary nodes but got %q and %d", ds.MaxInFlight, ds.CanaryNodes, unmarshaled.MaxInFlight, unmarshaled.CanaryNodes)
+	}
+}
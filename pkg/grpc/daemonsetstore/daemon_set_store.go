@@ -5,6 +5,7 @@ import (
 
 	"github.com/square/p2/pkg/ds/fields"
 	daemonsetstore_protos "github.com/square/p2/pkg/grpc/daemonsetstore/protos"
+	"github.com/square/p2/pkg/labels"
 	"github.com/square/p2/pkg/manifest"
 	"github.com/square/p2/pkg/store/consul/dsstore"
 	"github.com/square/p2/pkg/types"
@@ -13,7 +14,6 @@ import (
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
-	klabels "k8s.io/kubernetes/pkg/labels"
 )
 
 type ConsulStore interface {
@@ -124,7 +124,7 @@ func ProtoDSToRawDS(protoDS *daemonsetstore_protos.DaemonSet) (fields.DaemonSet,
 		return fields.DaemonSet{}, util.Errorf("could not convert daemon set proto to raw daemon set: %s", err)
 	}
 
-	selector, err := klabels.Parse(protoDS.GetNodeSelector())
+	selector, err := labels.ParseSelector(protoDS.GetNodeSelector())
 	if err != nil {
 		return fields.DaemonSet{}, util.Errorf("could not convert daemon set proto to raw daemon set: %s", err)
 	}
@@ -37,7 +37,7 @@ func (l labelStore) WatchMatches(req *label_protos.WatchMatchesRequest, stream l
 		return grpc.Errorf(codes.InvalidArgument, "Unrecognized label type %s", req.LabelType.String())
 	}
 
-	selector, err := klabels.Parse(req.Selector)
+	selector, err := labels.ParseSelector(req.Selector)
 	if err != nil {
 		return grpc.Errorf(codes.InvalidArgument, "Invalid label selector %s", req.Selector)
 	}
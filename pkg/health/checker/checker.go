@@ -318,10 +318,12 @@ func (h healthChecker) Service(serviceID string) (map[types.NodeName]health.Resu
 
 func consulWatchToResult(w consul.WatchResult) health.Result {
 	return health.Result{
-		ID:      w.Id,
-		Node:    w.Node,
-		Service: w.Service,
-		Status:  health.ToHealthState(w.Status),
+		ID:          w.Id,
+		Node:        w.Node,
+		Service:     w.Service,
+		Status:      health.ToHealthState(w.Status),
+		Launchables: w.Launchables,
+		Output:      w.Output,
 	}
 }
 
@@ -46,7 +46,33 @@ func TestService(t *testing.T) {
 		Service: "slug",
 		Status:  "passing",
 	}
-	Assert(t).AreEqual(results["node1"], expected, "Unexpected results calling Service()")
+	Assert(t).IsTrue(results["node1"].Equal(expected), "Unexpected results calling Service()")
+}
+
+func TestServiceWithLaunchables(t *testing.T) {
+	result1 := consul.WatchResult{
+		Id:      "abc123",
+		Node:    "node1",
+		Service: "slug",
+		Status:  "critical",
+		Launchables: health.LaunchableStatuses{
+			"web":    health.Passing,
+			"worker": health.Critical,
+		},
+	}
+	hc := healthChecker{
+		consulStore: fakeConsulStore{
+			results: map[string]consul.WatchResult{"node1": result1},
+		},
+	}
+
+	results, err := hc.Service("some_service")
+	Assert(t).IsNil(err, "Unexpected error calling Service()")
+
+	launchables := results["node1"].Launchables
+	Assert(t).AreEqual(len(launchables), 2, "Expected the health of each launchable")
+	Assert(t).AreEqual(launchables["web"], health.Passing, "Unexpected launchable status")
+	Assert(t).AreEqual(launchables["worker"], health.Critical, "Unexpected launchable status")
 }
 
 func TestPublishLatestHealth(t *testing.T) {
@@ -1,6 +1,8 @@
 package health
 
 import (
+	"fmt"
+
 	"github.com/square/p2/pkg/types"
 )
 
@@ -10,6 +12,51 @@ type Result struct {
 	Node    types.NodeName
 	Service string
 	Status  HealthState
+
+	// Launchables holds the health of the launchables that have their own
+	// checks. Status already accounts for them
+	Launchables LaunchableStatuses `json:",omitempty"`
+
+	// Output describes why the result is not passing, such as the error of
+	// the last failing check. It is empty for passing results
+	Output string `json:",omitempty"`
+}
+
+// LaunchableStatuses holds the health of each of a pod's launchables, keyed
+// by launchable ID.
+type LaunchableStatuses map[string]HealthState
+
+// Equal returns whether two sets of launchable statuses hold the same
+// statuses. A nil set equals an empty one.
+func (l LaunchableStatuses) Equal(other LaunchableStatuses) bool {
+	if len(l) != len(other) {
+		return false
+	}
+	for launchableID, status := range l {
+		otherStatus, ok := other[launchableID]
+		if !ok || otherStatus != status {
+			return false
+		}
+	}
+	return true
+}
+
+// Equal returns whether two results are the same, including their launchable
+// statuses and output.
+func (r Result) Equal(other Result) bool {
+	return r.ID == other.ID &&
+		r.Node == other.Node &&
+		r.Service == other.Service &&
+		r.Status == other.Status &&
+		r.Launchables.Equal(other.Launchables) &&
+		r.Output == other.Output
+}
+
+// LaunchableService returns the service name the health of one of a pod's
+// launchables is published under, which follows the naming of launchable
+// service IDs so that it can't collide with a pod ID.
+func LaunchableService(podID types.PodID, launchableID string) string {
+	return fmt.Sprintf("%s__%s", podID, launchableID)
 }
 
 // ResultList is a type alias that adds some extra methods that operate on the list.
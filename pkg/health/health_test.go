@@ -1,6 +1,7 @@
 package health
 
 import (
+	"encoding/json"
 	"testing"
 
 	. "github.com/anthonybishopric/gotcha"
@@ -32,3 +33,32 @@ func TestFindWorst(t *testing.T) {
 	mp := ResultList{}.MinValue()
 	Assert(t).AreEqual(mp, (*Result)(nil), "MinValue found a min value for empty result slice")
 }
+
+func TestLaunchableStatuses(t *testing.T) {
+	a := Result{
+		ID:          "test",
+		Status:      Critical,
+		Launchables: LaunchableStatuses{"web": Passing, "worker": Critical},
+	}
+	b := Result{
+		ID:          "test",
+		Status:      Critical,
+		Launchables: LaunchableStatuses{"worker": Critical, "web": Passing},
+	}
+	Assert(t).IsTrue(a.Equal(b), "Results with the same launchable statuses should be equal")
+	b.Launchables = LaunchableStatuses{"worker": Passing, "web": Passing}
+	Assert(t).IsFalse(a.Equal(b), "Results with different launchable statuses should not be equal")
+	Assert(t).IsTrue(Result{ID: "test"}.Equal(Result{ID: "test", Launchables: LaunchableStatuses{}}), "Results without launchable statuses should be equal")
+
+	encoded, err := json.Marshal(a)
+	Assert(t).IsNil(err, "Unexpected error marshaling result")
+	var decoded Result
+	err = json.Unmarshal(encoded, &decoded)
+	Assert(t).IsNil(err, "Unexpected error unmarshaling result")
+	Assert(t).IsTrue(decoded.Equal(a), "Result should survive a JSON round trip")
+	Assert(t).AreEqual(decoded.Launchables["worker"], Critical, "Unexpected launchable status")
+
+	encoded, err = json.Marshal(Result{ID: "test", Status: Passing})
+	Assert(t).IsNil(err, "Unexpected error marshaling result")
+	Assert(t).AreEqual(string(encoded), `{"ID":"test","Node":"","Service":"","Status":"passing"}`, "Results without launchable statuses should omit them")
+}
@@ -29,6 +29,10 @@ type NodePodStatus struct {
 	RealityVersions    map[launch.LaunchableID]LaunchableVersion `json:"reality_versions,omitempty"`
 	Health             health.HealthState                        `json:"health,omitempty"`
 
+	// LaunchableHealth breaks down the pod's health for the launchables that
+	// have their own status checks. Health already accounts for them
+	LaunchableHealth map[launch.LaunchableID]health.HealthState `json:"launchable_health,omitempty"`
+
 	// These fields are kept for backwards compatibility with tools that
 	// parse the output of p2-inspect. intent_versions and reality_versions
 	// are preferred since those handle multiple versions of manifest syntax
@@ -55,9 +55,9 @@ type Labeled struct {
 }
 
 type LabeledChanges struct {
-	Created []Labeled
-	Updated []Labeled
-	Deleted []Labeled
+	Created []Labeled `json:"created"`
+	Updated []Labeled `json:"updated"`
+	Deleted []Labeled `json:"deleted"`
 }
 
 func (l Labeled) SameAs(o Labeled) bool {
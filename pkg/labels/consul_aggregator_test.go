@@ -327,3 +327,34 @@ func TestIdenticalSelectors(t *testing.T) {
 	case <-labeledChannel1:
 	}
 }
+
+func TestNumericSelectorWatch(t *testing.T) {
+	alterAggregationTime(100 * time.Millisecond)
+
+	fakeKV := &fakeLabelStore{
+		data: map[string][]byte{
+			path.Join(typePath(NODE), "small"):  []byte(`{"memory_gb": "32"}`),
+			path.Join(typePath(NODE), "large"):  []byte(`{"memory_gb": "128"}`),
+			path.Join(typePath(NODE), "broken"): []byte(`{"memory_gb": "unknown"}`),
+		},
+	}
+	aggreg := NewConsulAggregator(NODE, fakeKV, logging.DefaultLogger, metrics.NewRegistry(), 0)
+	go aggreg.Aggregate(0)
+	defer aggreg.Quit()
+
+	selector, err := ParseSelector("memory_gb>64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	quitCh := make(chan struct{})
+	defer close(quitCh)
+
+	select {
+	case <-time.After(time.Second):
+		t.Fatal("Should not have taken a second to get results")
+	case labeled := <-aggreg.Watch(selector, quitCh):
+		if len(labeled) != 1 || labeled[0].ID != "large" {
+			t.Errorf("expected only the large node to match but got %+v", labeled)
+		}
+	}
+}
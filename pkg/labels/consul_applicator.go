@@ -46,10 +46,12 @@ func (e CASError) Error() string {
 
 type consulKV interface {
 	List(prefix string, opts *api.QueryOptions) (api.KVPairs, *api.QueryMeta, error)
+	Keys(prefix, separator string, q *api.QueryOptions) ([]string, *api.QueryMeta, error)
 	CAS(pair *api.KVPair, opts *api.WriteOptions) (bool, *api.WriteMeta, error)
 	Delete(key string, opts *api.WriteOptions) (*api.WriteMeta, error)
 	DeleteCAS(pair *api.KVPair, opts *api.WriteOptions) (bool, *api.WriteMeta, error)
 	Get(key string, q *api.QueryOptions) (*api.KVPair, *api.QueryMeta, error)
+	Txn(txn api.KVTxnOps, q *api.QueryOptions) (bool, *api.KVTxnResponse, *api.QueryMeta, error)
 }
 
 type ConsulApplicator struct {
@@ -61,6 +63,10 @@ type ConsulApplicator struct {
 	metReg        MetricsRegistry
 	retryMetric   metrics.Gauge
 
+	// history decides which label changes made by this applicator are
+	// recorded in the label history and who they are attributed to
+	history historyRecorder
+
 	// watchJitterWindow is the "jitter window" that will be used when
 	// initiating watches on consul. A random amount of time between 0 and
 	// the jitter window will be slept when an error occurs, which is
@@ -77,6 +83,31 @@ func NewConsulApplicator(client consulutil.ConsulClient, retries int, watchJitte
 		aggregators:       map[Type]*consulAggregator{},
 		retryMetric:       metrics.NewGauge(),
 		watchJitterWindow: watchJitterWindow,
+		history:           newHistoryRecorder(DefaultAuthor(), DefaultHistoryTypes),
+	}
+}
+
+// SetAuthor changes the author recorded in the label history for changes made
+// by this applicator. It defaults to the current user and host
+func (c *ConsulApplicator) SetAuthor(author string) {
+	c.history.author = author
+}
+
+// SetHistoryTypes changes the label types whose changes are recorded in the
+// label history by this applicator. It defaults to DefaultHistoryTypes
+func (c *ConsulApplicator) SetHistoryTypes(labelTypes []Type) {
+	c.history = newHistoryRecorder(c.history.author, labelTypes)
+}
+
+// WithAuthor returns an applicator that shares this applicator's connection
+// but records changes in the label history as made by author. It is used by
+// the label server to attribute changes to its clients
+func (c *ConsulApplicator) WithAuthor(author string) ApplicatorWithoutWatches {
+	history := c.history
+	history.author = author
+	return &authoredConsulApplicator{
+		ConsulApplicator: c,
+		history:          history,
 	}
 }
 
@@ -178,41 +209,25 @@ func (c *ConsulApplicator) ListLabels(labelType Type) ([]Labeled, error) {
 }
 
 // generalized label mutator function - pass nil value for any label to delete it
-func (c *ConsulApplicator) mutateLabels(labelType Type, id string, labels map[string]*string) error {
-	l, index, err := c.GetLabelsWithIndex(labelType, id)
-	if err != nil {
-		return err
-	}
-
-	for key, value := range labels {
-		if value == nil {
-			delete(l.Labels, key)
-		} else {
-			l.Labels[key] = *value
-		}
-	}
+func (c *ConsulApplicator) mutateLabels(labelType Type, id string, labels map[string]*string, history historyRecorder) error {
+	ctx, cancelFunc := transaction.New(context.Background())
+	defer cancelFunc()
 
-	setkvp, err := convertLabeledToKVP(l)
+	err := mutateLabelsTxn(ctx, labelType, id, labels, c, history)
 	if err != nil {
 		return err
 	}
-	setkvp.ModifyIndex = index
 
-	var success bool
-	if len(l.Labels) == 0 {
-		// still have to use CAS when deleting, to avoid discarding someone
-		// else's concurrent modification
-		// DeleteCAS ignores the value on the KVPair, so it doesn't matter if
-		// we set it earlier
-		success, _, err = c.kv.DeleteCAS(setkvp, nil)
-	} else {
-		success, _, err = c.kv.CAS(setkvp, nil)
-	}
+	ok, _, err := transaction.Commit(ctx, c.kv)
 	if err != nil {
 		return err
 	}
-	if !success {
-		return CASError{setkvp.Key}
+	if !ok {
+		path, err := objectPath(labelType, id)
+		if err != nil {
+			return err
+		}
+		return CASError{path}
 	}
 	return nil
 }
@@ -222,7 +237,8 @@ type LabelFetcher interface {
 }
 
 // mutateLabelsTxn adds operations to the transaction within the passed context
-// to safely make the label mutations requested. It's written as a package
+// to safely make the label mutations requested and record them in the label
+// history if history is recorded for the label type. It's written as a package
 // global function to avoid obligating all "applicator" interface types from
 // providing it. For example it doesn't make sense for the "http applicator" to
 // provide a transaction function that doesn't actually make any http calls.
@@ -232,6 +248,7 @@ func mutateLabelsTxn(
 	id string,
 	labels map[string]*string,
 	f LabelFetcher,
+	history historyRecorder,
 ) error {
 	if len(labels) == 0 {
 		return nil
@@ -241,6 +258,11 @@ func mutateLabelsTxn(
 	if err != nil {
 		return err
 	}
+	before := l
+	before.Labels = make(map[string]string, len(l.Labels))
+	for key, value := range l.Labels {
+		before.Labels[key] = value
+	}
 
 	for key, value := range labels {
 		if value == nil {
@@ -277,7 +299,11 @@ func mutateLabelsTxn(
 		}
 	}
 
-	return transaction.Add(ctx, op)
+	err = transaction.Add(ctx, op)
+	if err != nil {
+		return err
+	}
+	return addHistoryTxn(ctx, before, l.Labels, history)
 }
 
 func labelsFromKeyValue(label string, value *string) map[string]*string {
@@ -288,11 +314,11 @@ func labelsFromKeyValue(label string, value *string) map[string]*string {
 
 // this function will attempt to mutateLabel. if it gets a CAS error, then it
 // will retry up to the number of attempts specified in c.Retries
-func (c *ConsulApplicator) retryMutate(labelType Type, id string, labels map[string]*string) error {
-	err := c.mutateLabels(labelType, id, labels)
+func (c *ConsulApplicator) retryMutate(labelType Type, id string, labels map[string]*string, history historyRecorder) error {
+	err := c.mutateLabels(labelType, id, labels, history)
 	for i := 0; i < c.retries; i++ {
 		if _, ok := err.(CASError); ok {
-			err = c.mutateLabels(labelType, id, labels)
+			err = c.mutateLabels(labelType, id, labels, history)
 		} else {
 			c.updateRetryCount(i)
 			break
@@ -306,14 +332,22 @@ func (c *ConsulApplicator) updateRetryCount(retryMetric int) {
 }
 
 func (c *ConsulApplicator) SetLabel(labelType Type, id, label, value string) error {
-	return c.retryMutate(labelType, id, labelsFromKeyValue(label, &value))
+	return c.setLabel(labelType, id, label, value, c.history)
+}
+
+func (c *ConsulApplicator) setLabel(labelType Type, id, label, value string, history historyRecorder) error {
+	return c.retryMutate(labelType, id, labelsFromKeyValue(label, &value), history)
 }
 
 func (c *ConsulApplicator) SetLabelTxn(ctx context.Context, labelType Type, id, label, value string) error {
-	return mutateLabelsTxn(ctx, labelType, id, labelsFromKeyValue(label, &value), c)
+	return mutateLabelsTxn(ctx, labelType, id, labelsFromKeyValue(label, &value), c, c.history)
 }
 
 func (c *ConsulApplicator) SetLabels(labelType Type, id string, labels map[string]string) error {
+	return c.setLabels(labelType, id, labels, c.history)
+}
+
+func (c *ConsulApplicator) setLabels(labelType Type, id string, labels map[string]string, history historyRecorder) error {
 	labelsToPointers := make(map[string]*string)
 	for label, value := range labels {
 		// We can't just use &value because that would be a pointer to
@@ -322,16 +356,16 @@ func (c *ConsulApplicator) SetLabels(labelType Type, id string, labels map[strin
 		valPtr = value
 		labelsToPointers[label] = &valPtr
 	}
-	return c.retryMutate(labelType, id, labelsToPointers)
+	return c.retryMutate(labelType, id, labelsToPointers, history)
 }
 
 // TODO: replace SetLabels() with this implementation. It's just separate right now to make
 // exploring solutions require less code churn
 func (c *ConsulApplicator) SetLabelsTxn(ctx context.Context, labelType Type, id string, labels map[string]string) error {
-	return setLabelsTxn(ctx, labelType, id, labels, c)
+	return setLabelsTxn(ctx, labelType, id, labels, c, c.history)
 }
 
-func setLabelsTxn(ctx context.Context, labelType Type, id string, labels map[string]string, f LabelFetcher) error {
+func setLabelsTxn(ctx context.Context, labelType Type, id string, labels map[string]string, f LabelFetcher, history historyRecorder) error {
 	labelsToPointers := make(map[string]*string)
 	for label, value := range labels {
 		// We can't just use &value because that would be a pointer to
@@ -341,55 +375,129 @@ func setLabelsTxn(ctx context.Context, labelType Type, id string, labels map[str
 		labelsToPointers[label] = &valPtr
 	}
 
-	return mutateLabelsTxn(ctx, labelType, id, labelsToPointers, f)
+	return mutateLabelsTxn(ctx, labelType, id, labelsToPointers, f, history)
 }
 
 func (c *ConsulApplicator) RemoveLabel(labelType Type, id, label string) error {
-	return c.retryMutate(labelType, id, labelsFromKeyValue(label, nil))
+	return c.retryMutate(labelType, id, labelsFromKeyValue(label, nil), c.history)
 }
 
 func (c *ConsulApplicator) RemoveLabelTxn(ctx context.Context, labelType Type, id, label string) error {
-	return removeLabelsTxn(ctx, labelType, id, []string{label}, c)
+	return removeLabelsTxn(ctx, labelType, id, []string{label}, c, c.history)
 }
 
 func (c *ConsulApplicator) RemoveLabelsTxn(ctx context.Context, labelType Type, id string, keysToRemove []string) error {
-	return removeLabelsTxn(ctx, labelType, id, keysToRemove, c)
+	return removeLabelsTxn(ctx, labelType, id, keysToRemove, c, c.history)
 }
 
-func removeLabelsTxn(ctx context.Context, labelType Type, id string, keysToRemove []string, f LabelFetcher) error {
+func removeLabelsTxn(ctx context.Context, labelType Type, id string, keysToRemove []string, f LabelFetcher, history historyRecorder) error {
 	mutation := make(map[string]*string)
 	for _, keyToRemove := range keysToRemove {
 		mutation[keyToRemove] = nil
 	}
-	return mutateLabelsTxn(ctx, labelType, id, mutation, f)
+	return mutateLabelsTxn(ctx, labelType, id, mutation, f, history)
 }
 
 func (c *ConsulApplicator) RemoveAllLabels(labelType Type, id string) error {
-	path, err := objectPath(labelType, id)
+	return c.removeAllLabels(labelType, id, c.history)
+}
+
+func (c *ConsulApplicator) removeAllLabels(labelType Type, id string, history historyRecorder) error {
+	if !history.records(labelType) {
+		path, err := objectPath(labelType, id)
+		if err != nil {
+			return err
+		}
+		_, err = c.kv.Delete(path, nil)
+		return err
+	}
+
+	ctx, cancelFunc := transaction.New(context.Background())
+	defer cancelFunc()
+
+	err := removeAllLabelsTxn(ctx, labelType, id, c, history)
 	if err != nil {
 		return err
 	}
-	_, err = c.kv.Delete(path, nil)
-	return err
+	return transaction.MustCommit(ctx, c.kv)
 }
 
 // RemoveAllLabelsTxn is the same as RemoveAllLabels but adds the operation to
 // the passed transaction rather than synchronously making the requisite consul
 // call
 func (c *ConsulApplicator) RemoveAllLabelsTxn(ctx context.Context, labelType Type, id string) error {
-	return removeAllLabelsTxn(ctx, labelType, id)
+	return removeAllLabelsTxn(ctx, labelType, id, c, c.history)
 }
 
-func removeAllLabelsTxn(ctx context.Context, labelType Type, id string) error {
+func removeAllLabelsTxn(ctx context.Context, labelType Type, id string, f LabelFetcher, history historyRecorder) error {
 	path, err := objectPath(labelType, id)
 	if err != nil {
 		return err
 	}
 
-	return transaction.Add(ctx, api.KVTxnOp{
+	deleteOp := api.KVTxnOp{
 		Verb: api.KVDelete,
 		Key:  path,
-	})
+	}
+	if !history.records(labelType) {
+		return transaction.Add(ctx, deleteOp)
+	}
+
+	// the labels being removed are only read when they are recorded in
+	// the label history
+	before, _, err := f.GetLabelsWithIndex(labelType, id)
+	if err != nil {
+		return err
+	}
+
+	err = transaction.Add(ctx, deleteOp)
+	if err != nil {
+		return err
+	}
+	return addHistoryTxn(ctx, before, labels.Set{}, history)
+}
+
+// authoredConsulApplicator attributes the label changes it makes to a
+// different author than the ConsulApplicator it wraps
+type authoredConsulApplicator struct {
+	*ConsulApplicator
+	history historyRecorder
+}
+
+func (a *authoredConsulApplicator) SetLabel(labelType Type, id, label, value string) error {
+	return a.setLabel(labelType, id, label, value, a.history)
+}
+
+func (a *authoredConsulApplicator) SetLabelTxn(ctx context.Context, labelType Type, id, label, value string) error {
+	return mutateLabelsTxn(ctx, labelType, id, labelsFromKeyValue(label, &value), a, a.history)
+}
+
+func (a *authoredConsulApplicator) SetLabels(labelType Type, id string, labels map[string]string) error {
+	return a.setLabels(labelType, id, labels, a.history)
+}
+
+func (a *authoredConsulApplicator) SetLabelsTxn(ctx context.Context, labelType Type, id string, labels map[string]string) error {
+	return setLabelsTxn(ctx, labelType, id, labels, a, a.history)
+}
+
+func (a *authoredConsulApplicator) RemoveLabel(labelType Type, id, label string) error {
+	return a.retryMutate(labelType, id, labelsFromKeyValue(label, nil), a.history)
+}
+
+func (a *authoredConsulApplicator) RemoveLabelTxn(ctx context.Context, labelType Type, id, label string) error {
+	return removeLabelsTxn(ctx, labelType, id, []string{label}, a, a.history)
+}
+
+func (a *authoredConsulApplicator) RemoveLabelsTxn(ctx context.Context, labelType Type, id string, keysToRemove []string) error {
+	return removeLabelsTxn(ctx, labelType, id, keysToRemove, a, a.history)
+}
+
+func (a *authoredConsulApplicator) RemoveAllLabels(labelType Type, id string) error {
+	return a.removeAllLabels(labelType, id, a.history)
+}
+
+func (a *authoredConsulApplicator) RemoveAllLabelsTxn(ctx context.Context, labelType Type, id string) error {
+	return removeAllLabelsTxn(ctx, labelType, id, a, a.history)
 }
 
 // kvp must be non-nil
@@ -2,6 +2,7 @@ package labels
 
 import (
 	"reflect"
+	"sort"
 	"strings"
 	"sync"
 	"testing"
@@ -45,6 +46,19 @@ func (f *fakeLabelStore) List(prefix string, opts *api.QueryOptions) (api.KVPair
 	return ret, &api.QueryMeta{}, nil
 }
 
+func (f *fakeLabelStore) Keys(prefix, separator string, q *api.QueryOptions) ([]string, *api.QueryMeta, error) {
+	f.dataMu.Lock()
+	defer f.dataMu.Unlock()
+	var ret []string
+	for k := range f.data {
+		if strings.HasPrefix(k, prefix) {
+			ret = append(ret, k)
+		}
+	}
+	sort.Strings(ret)
+	return ret, &api.QueryMeta{}, nil
+}
+
 func (f *fakeLabelStore) Delete(key string, opts *api.WriteOptions) (*api.WriteMeta, error) {
 	f.dataMu.Lock()
 	defer f.dataMu.Unlock()
@@ -66,6 +80,22 @@ func (f *fakeLabelStore) CAS(pair *api.KVPair, opts *api.WriteOptions) (bool, *a
 	return true, &api.WriteMeta{}, nil
 }
 
+// Txn applies the operations without checking indexes, like the other
+// fakeLabelStore writes
+func (f *fakeLabelStore) Txn(txn api.KVTxnOps, q *api.QueryOptions) (bool, *api.KVTxnResponse, *api.QueryMeta, error) {
+	f.dataMu.Lock()
+	defer f.dataMu.Unlock()
+	for _, op := range txn {
+		switch api.KVOp(op.Verb) {
+		case api.KVSet, api.KVCAS:
+			f.data[op.Key] = op.Value
+		case api.KVDelete, api.KVDeleteCAS:
+			delete(f.data, op.Key)
+		}
+	}
+	return true, &api.KVTxnResponse{}, &api.QueryMeta{}, nil
+}
+
 func (f *fakeLabelStore) Get(key string, q *api.QueryOptions) (*api.KVPair, *api.QueryMeta, error) {
 	f.dataMu.Lock()
 	defer f.dataMu.Unlock()
@@ -204,6 +234,10 @@ func (f *failOnceLabelStore) List(prefix string, opts *api.QueryOptions) (api.KV
 	return f.inner.List(prefix, opts)
 }
 
+func (f *failOnceLabelStore) Keys(prefix, separator string, q *api.QueryOptions) ([]string, *api.QueryMeta, error) {
+	return f.inner.Keys(prefix, separator, q)
+}
+
 func (f *failOnceLabelStore) Delete(key string, opts *api.WriteOptions) (*api.WriteMeta, error) {
 	return f.inner.Delete(key, opts)
 }
@@ -224,6 +258,14 @@ func (f *failOnceLabelStore) CAS(pair *api.KVPair, opts *api.WriteOptions) (bool
 	return f.inner.CAS(pair, opts)
 }
 
+func (f *failOnceLabelStore) Txn(txn api.KVTxnOps, q *api.QueryOptions) (bool, *api.KVTxnResponse, *api.QueryMeta, error) {
+	if !f.succeedCAS {
+		f.succeedCAS = true
+		return false, &api.KVTxnResponse{}, &api.QueryMeta{}, nil
+	}
+	return f.inner.Txn(txn, q)
+}
+
 func (f *failOnceLabelStore) Get(key string, q *api.QueryOptions) (*api.KVPair, *api.QueryMeta, error) {
 	return f.inner.Get(key, q)
 }
@@ -0,0 +1,575 @@
+package labels
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"os/user"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/sirupsen/logrus"
+	"k8s.io/kubernetes/pkg/labels"
+
+	"github.com/square/p2/pkg/store/consul"
+	"github.com/square/p2/pkg/store/consul/consulutil"
+	"github.com/square/p2/pkg/store/consul/transaction"
+	"github.com/square/p2/pkg/util"
+)
+
+// Label mutations made through a ConsulApplicator are also recorded under
+// historyRoot, in the same transaction as the mutation itself, if history is
+// recorded for the type of the labels. By default only node labels are
+// recorded, see DefaultHistoryTypes. Records are
+// never modified after they are written, so the history can be used to find
+// out who changed a label and what an object's labels were at a point in time.
+// /label_history/<type>/<id>/<unix nanoseconds>
+//
+// Each record is also indexed by the label keys it changes, so that the
+// history of a key is a prefix list as well. Index entries have empty values
+// and point at the record with the same type, timestamp and id:
+// /label_key_history/<type>/<label key>/<unix nanoseconds>/<id>
+//
+// A change to more than maxIndexedKeys keys gets a single index entry under
+// manyKeysIndex instead, so that recording the history adds a bounded number
+// of operations to the mutation's transaction, which consul limits:
+// /label_key_history/<type>/*/<unix nanoseconds>/<id>
+//
+// IDs and label keys are path escaped, so a prefix never matches the history
+// of another object or key, and no escaped key is equal to manyKeysIndex.
+// Records are kept until they are pruned according to a HistoryRetention
+const (
+	historyRoot    = "label_history"
+	keyHistoryRoot = "label_key_history"
+	manyKeysIndex  = "*"
+)
+
+// maxIndexedKeys is the most label keys a change is indexed by individually.
+// Recording a change adds at most maxIndexedKeys+1 operations to a transaction
+const maxIndexedKeys = 4
+
+// DefaultHistoryTypes are the label types whose changes are recorded in the
+// label history unless configured otherwise. Pod and RC labels are written
+// every time a pod is scheduled, so recording them has to be asked for
+var DefaultHistoryTypes = []Type{NODE}
+
+// DefaultHistoryPruneInterval is how often RunHistoryPruner prunes the label
+// history
+const DefaultHistoryPruneInterval = 10 * time.Minute
+
+// DefaultHistoryRetention is how much label history is kept unless configured
+// otherwise
+var DefaultHistoryRetention = HistoryRetention{
+	MaxAge:   30 * 24 * time.Hour,
+	MaxCount: 100,
+}
+
+// historyPrunerLockPath is held by the process pruning the label history, so
+// that only one of the processes configured to prune it does
+var historyPrunerLockPath = path.Join(consul.LOCK_TREE, historyRoot, "pruner")
+
+// historyPruneBatchSize is the number of history records deleted in each
+// transaction, which is limited by consul
+const historyPruneBatchSize = 64
+
+// LabelChangeAuthorHeader is set by the httpApplicator so that label changes
+// made through the label server are attributed to the client that made them
+const LabelChangeAuthorHeader = "X-P2-Label-Author"
+
+// LabelChange records a single mutation to the labels of an object
+type LabelChange struct {
+	LabelType Type   `json:"type"`
+	ID        string `json:"id"`
+
+	// Time is when the mutation was prepared. For transactional mutations
+	// this may be shortly before the transaction was committed
+	Time time.Time `json:"time"`
+
+	// Author identifies who made the change, e.g. user@host
+	Author string `json:"author"`
+
+	Before labels.Set `json:"before"`
+	After  labels.Set `json:"after"`
+}
+
+// ChangedKeys returns the label keys that were added, removed or modified by
+// the change, sorted
+func (c LabelChange) ChangedKeys() []string {
+	var keys []string
+	for key, value := range c.After {
+		if before, ok := c.Before[key]; !ok || before != value {
+			keys = append(keys, key)
+		}
+	}
+	for key := range c.Before {
+		if _, ok := c.After[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// HistoryReader answers questions about past label state
+type HistoryReader interface {
+	// LabelHistory returns the changes made to the labels of an object
+	// since the given time, oldest first
+	LabelHistory(labelType Type, id string, since time.Time) ([]LabelChange, error)
+
+	// LabelsAt returns the labels an object had at the given time
+	LabelsAt(labelType Type, id string, at time.Time) (Labeled, error)
+
+	// KeyHistory returns the changes made to the given label key on any
+	// object of a type since the given time, oldest first
+	KeyHistory(labelType Type, key string, since time.Time) ([]LabelChange, error)
+}
+
+// DefaultAuthor identifies the current process's user and host, for
+// attributing label changes
+func DefaultAuthor() string {
+	username := "unknown"
+	if currentUser, err := user.Current(); err == nil {
+		username = currentUser.Username
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return username + "@" + hostname
+}
+
+// historyRecorder decides which label changes are recorded in the label
+// history and who they are attributed to
+type historyRecorder struct {
+	author string
+	types  map[Type]bool
+}
+
+func newHistoryRecorder(author string, labelTypes []Type) historyRecorder {
+	types := make(map[Type]bool, len(labelTypes))
+	for _, labelType := range labelTypes {
+		types[labelType] = true
+	}
+	return historyRecorder{
+		author: author,
+		types:  types,
+	}
+}
+
+func (r historyRecorder) records(labelType Type) bool {
+	return r.types[labelType]
+}
+
+func historyObjectPath(labelType Type, id string) (string, error) {
+	if id == "" {
+		return "", util.Errorf("Empty ID in label history path")
+	}
+	return path.Join(historyRoot, labelType.String(), url.PathEscape(id)), nil
+}
+
+func keyHistoryPath(labelType Type, key string) (string, error) {
+	if key == "" {
+		return "", util.Errorf("Empty label key in label history path")
+	}
+	return path.Join(keyHistoryRoot, labelType.String(), url.PathEscape(key)), nil
+}
+
+// parseKeyHistoryIndex returns the record that a key index entry points at.
+// The object path and timestamp are left in their escaped form
+func parseKeyHistoryIndex(indexKey string) (historyRecord, bool) {
+	// label_key_history/<type>/<label key>/<timestamp>/<id>
+	parts := strings.Split(indexKey, "/")
+	if len(parts) != 5 || parts[0] != keyHistoryRoot {
+		return historyRecord{}, false
+	}
+	return historyRecord{
+		objectPath: path.Join(historyRoot, parts[1], parts[4]),
+		timestamp:  parts[3],
+	}, true
+}
+
+func historyTimestamp(t time.Time) string {
+	return fmt.Sprintf("%019d", t.UnixNano())
+}
+
+// addHistoryTxn adds operations to the transaction within the passed context
+// that record the change from before to after, along with its key index
+// entries. Nothing is recorded if the labels did not change or if history is
+// not recorded for their type
+func addHistoryTxn(ctx context.Context, before Labeled, after labels.Set, history historyRecorder) error {
+	if !history.records(before.LabelType) {
+		return nil
+	}
+
+	change := LabelChange{
+		LabelType: before.LabelType,
+		ID:        before.ID,
+		Time:      time.Now().UTC(),
+		Author:    history.author,
+		Before:    before.Labels,
+		After:     after,
+	}
+	if change.Before == nil {
+		change.Before = labels.Set{}
+	}
+	if change.After == nil {
+		change.After = labels.Set{}
+	}
+	changedKeys := change.ChangedKeys()
+	if len(changedKeys) == 0 {
+		return nil
+	}
+
+	objectPath, err := historyObjectPath(change.LabelType, change.ID)
+	if err != nil {
+		return err
+	}
+	value, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+
+	// a CAS with an index of 0 only succeeds if the key doesn't exist, so
+	// an existing record is never overwritten
+	timestamp := historyTimestamp(change.Time)
+	err = transaction.Add(ctx, api.KVTxnOp{
+		Verb:  api.KVCAS,
+		Key:   path.Join(objectPath, timestamp),
+		Value: value,
+		Index: 0,
+	})
+	if err != nil {
+		return err
+	}
+
+	var indexPaths []string
+	if len(changedKeys) > maxIndexedKeys {
+		indexPaths = []string{path.Join(keyHistoryRoot, change.LabelType.String(), manyKeysIndex)}
+	} else {
+		for _, key := range changedKeys {
+			keyPath, err := keyHistoryPath(change.LabelType, key)
+			if err != nil {
+				return err
+			}
+			indexPaths = append(indexPaths, keyPath)
+		}
+	}
+	for _, indexPath := range indexPaths {
+		err = transaction.Add(ctx, api.KVTxnOp{
+			Verb:  api.KVCAS,
+			Key:   path.Join(indexPath, timestamp, url.PathEscape(change.ID)),
+			Index: 0,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *ConsulApplicator) listHistory(prefix string) ([]LabelChange, error) {
+	pairs, _, err := c.kv.List(prefix+"/", nil)
+	if err != nil {
+		return nil, util.Errorf("Could not list label history under %s: %s", prefix, err)
+	}
+
+	changes := make([]LabelChange, 0, len(pairs))
+	for _, pair := range pairs {
+		var change LabelChange
+		err = json.Unmarshal(pair.Value, &change)
+		if err != nil {
+			return nil, util.Errorf("Could not parse label history record %s: %s", pair.Key, err)
+		}
+		changes = append(changes, change)
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].Time.Before(changes[j].Time)
+	})
+	return changes, nil
+}
+
+func (c *ConsulApplicator) objectHistory(labelType Type, id string) ([]LabelChange, error) {
+	objectPath, err := historyObjectPath(labelType, id)
+	if err != nil {
+		return nil, err
+	}
+	return c.listHistory(objectPath)
+}
+
+func (c *ConsulApplicator) LabelHistory(labelType Type, id string, since time.Time) ([]LabelChange, error) {
+	changes, err := c.objectHistory(labelType, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []LabelChange
+	for _, change := range changes {
+		if !change.Time.Before(since) {
+			ret = append(ret, change)
+		}
+	}
+	return ret, nil
+}
+
+// LabelsAt returns the labels of the object after the last change made at or
+// before the given time. If the object's history starts after that time, the
+// labels from before its first recorded change are returned, and if it has no
+// history at all its current labels are returned
+func (c *ConsulApplicator) LabelsAt(labelType Type, id string, at time.Time) (Labeled, error) {
+	changes, err := c.objectHistory(labelType, id)
+	if err != nil {
+		return Labeled{}, err
+	}
+	if len(changes) == 0 {
+		return c.GetLabels(labelType, id)
+	}
+
+	ret := Labeled{
+		LabelType: labelType,
+		ID:        id,
+		Labels:    changes[0].Before,
+	}
+	for _, change := range changes {
+		if change.Time.After(at) {
+			break
+		}
+		ret.Labels = change.After
+	}
+	return ret, nil
+}
+
+// KeyHistory reads the records that the key's index entries point at, along
+// with the records of changes to many keys that include the key. Index
+// entries whose record has been pruned are skipped
+func (c *ConsulApplicator) KeyHistory(labelType Type, key string, since time.Time) ([]LabelChange, error) {
+	keyPath, err := keyHistoryPath(labelType, key)
+	if err != nil {
+		return nil, err
+	}
+	keyIndex, _, err := c.kv.Keys(keyPath+"/", "", nil)
+	if err != nil {
+		return nil, util.Errorf("Could not list label history under %s: %s", keyPath, err)
+	}
+	manyKeysPath := path.Join(keyHistoryRoot, labelType.String(), manyKeysIndex)
+	manyKeysIndexKeys, _, err := c.kv.Keys(manyKeysPath+"/", "", nil)
+	if err != nil {
+		return nil, util.Errorf("Could not list label history under %s: %s", manyKeysPath, err)
+	}
+
+	sinceTimestamp := historyTimestamp(since)
+	var ret []LabelChange
+	for i, indexKey := range append(keyIndex, manyKeysIndexKeys...) {
+		record, ok := parseKeyHistoryIndex(indexKey)
+		if !ok || record.timestamp < sinceTimestamp {
+			continue
+		}
+		recordKey := path.Join(record.objectPath, record.timestamp)
+		pair, _, err := c.kv.Get(recordKey, nil)
+		if err != nil {
+			return nil, util.Errorf("Could not read label history record %s: %s", recordKey, err)
+		}
+		if pair == nil {
+			continue
+		}
+		var change LabelChange
+		err = json.Unmarshal(pair.Value, &change)
+		if err != nil {
+			return nil, util.Errorf("Could not parse label history record %s: %s", recordKey, err)
+		}
+		if i >= len(keyIndex) && !changesKey(change, key) {
+			continue
+		}
+		if !change.Time.Before(since) {
+			ret = append(ret, change)
+		}
+	}
+	sort.SliceStable(ret, func(i, j int) bool {
+		return ret[i].Time.Before(ret[j].Time)
+	})
+	return ret, nil
+}
+
+func changesKey(change LabelChange, key string) bool {
+	for _, changedKey := range change.ChangedKeys() {
+		if changedKey == key {
+			return true
+		}
+	}
+	return false
+}
+
+var _ HistoryReader = &ConsulApplicator{}
+
+// HistoryRetention bounds how much label history is kept
+type HistoryRetention struct {
+	// MaxAge is how long records are kept. Zero keeps records of any age
+	MaxAge time.Duration
+
+	// MaxCount is how many of the most recent records are kept for each
+	// object. Zero keeps any number of records
+	MaxCount int
+}
+
+// historyRecord identifies a record in the history of an object
+type historyRecord struct {
+	objectPath string
+	timestamp  string
+}
+
+// PruneHistory deletes the label history records, and their key index
+// entries, that fall outside the retention. It returns the number of records
+// that were deleted
+func (c *ConsulApplicator) PruneHistory(retention HistoryRetention) (int, error) {
+	if retention.MaxAge <= 0 && retention.MaxCount <= 0 {
+		return 0, nil
+	}
+	cutoff := ""
+	if retention.MaxAge > 0 {
+		cutoff = historyTimestamp(time.Now().Add(-retention.MaxAge))
+	}
+
+	keys, _, err := c.kv.Keys(historyRoot+"/", "", nil)
+	if err != nil {
+		return 0, util.Errorf("Could not list label history: %s", err)
+	}
+	// keys are returned sorted, and the timestamps are zero padded, so the
+	// records of each object are oldest first
+	timestamps := make(map[string][]string)
+	for _, key := range keys {
+		objectPath, timestamp := path.Split(key)
+		if _, err := strconv.ParseInt(timestamp, 10, 64); err != nil {
+			continue
+		}
+		objectPath = strings.TrimSuffix(objectPath, "/")
+		timestamps[objectPath] = append(timestamps[objectPath], timestamp)
+	}
+
+	pruned := make(map[historyRecord]bool)
+	var toDelete []string
+	for objectPath, objectTimestamps := range timestamps {
+		for i, timestamp := range objectTimestamps {
+			tooMany := retention.MaxCount > 0 && len(objectTimestamps)-i > retention.MaxCount
+			tooOld := timestamp < cutoff
+			if tooMany || tooOld {
+				pruned[historyRecord{objectPath: objectPath, timestamp: timestamp}] = true
+				toDelete = append(toDelete, path.Join(objectPath, timestamp))
+			}
+		}
+	}
+
+	indexKeys, _, err := c.kv.Keys(keyHistoryRoot+"/", "", nil)
+	if err != nil {
+		return 0, util.Errorf("Could not list label key history: %s", err)
+	}
+	for _, indexKey := range indexKeys {
+		record, ok := parseKeyHistoryIndex(indexKey)
+		if !ok {
+			continue
+		}
+		if pruned[record] || record.timestamp < cutoff {
+			toDelete = append(toDelete, indexKey)
+		}
+	}
+
+	err = c.deleteHistoryKeys(toDelete)
+	if err != nil {
+		return 0, err
+	}
+	return len(pruned), nil
+}
+
+func (c *ConsulApplicator) deleteHistoryKeys(keys []string) error {
+	for start := 0; start < len(keys); start += historyPruneBatchSize {
+		end := start + historyPruneBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		ctx, cancel := transaction.New(context.Background())
+		for _, key := range keys[start:end] {
+			err := transaction.Add(ctx, api.KVTxnOp{
+				Verb: api.KVDelete,
+				Key:  key,
+			})
+			if err != nil {
+				cancel()
+				return err
+			}
+		}
+		err := transaction.MustCommit(ctx, c.kv)
+		cancel()
+		if err != nil {
+			return util.Errorf("Could not delete label history records: %s", err)
+		}
+	}
+	return nil
+}
+
+// RunHistoryPruner prunes the label history according to the retention every
+// interval until quit is closed
+func (c *ConsulApplicator) RunHistoryPruner(retention HistoryRetention, interval time.Duration, quit <-chan struct{}) {
+	logger := c.logger.SubLogger(logrus.Fields{
+		"max_age":   retention.MaxAge,
+		"max_count": retention.MaxCount,
+	})
+	timer := time.NewTimer(0)
+	for {
+		select {
+		case <-quit:
+			return
+		case <-timer.C:
+			pruned, err := c.PruneHistory(retention)
+			if err != nil {
+				logger.WithError(err).Errorln("Could not prune label history")
+			} else if pruned > 0 {
+				logger.WithField("pruned", pruned).Infoln("Pruned label history")
+			}
+			timer.Reset(interval)
+		}
+	}
+}
+
+// RunLockedHistoryPruner runs RunHistoryPruner while holding a lock with the
+// latest session from sessions, so that only one process prunes the label
+// history when several are configured to. A process that can't acquire the
+// lock tries again every interval. It returns when quit or sessions is closed
+func (c *ConsulApplicator) RunLockedHistoryPruner(
+	client consulutil.ConsulClient,
+	sessions <-chan string,
+	retention HistoryRetention,
+	interval time.Duration,
+	quit <-chan struct{},
+) {
+	consulutil.WithSession(quit, sessions, func(sessionQuit <-chan struct{}, sessionID string) {
+		logger := c.logger.SubLogger(logrus.Fields{"session": sessionID})
+		session := consul.NewUnmanagedSession(client, sessionID, "label history pruner")
+		for {
+			unlocker, err := session.Lock(historyPrunerLockPath)
+			switch {
+			case err == nil:
+				logger.NoFields().Infoln("Acquired the label history pruner lock")
+				c.RunHistoryPruner(retention, interval, sessionQuit)
+				err = unlocker.Unlock()
+				if err != nil {
+					logger.WithError(err).Warnln("Could not release the label history pruner lock")
+				}
+				return
+			case consul.IsAlreadyLocked(err):
+			default:
+				logger.WithError(err).Errorln("Could not acquire the label history pruner lock")
+			}
+
+			select {
+			case <-sessionQuit:
+				return
+			case <-time.After(interval):
+			}
+		}
+	})
+}
@@ -0,0 +1,362 @@
+// +build !race
+
+package labels
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	klabels "k8s.io/kubernetes/pkg/labels"
+
+	"github.com/square/p2/pkg/logging"
+	"github.com/square/p2/pkg/store/consul"
+	"github.com/square/p2/pkg/store/consul/consulutil"
+	"github.com/square/p2/pkg/store/consul/transaction"
+)
+
+func TestLabelHistory(t *testing.T) {
+	fixture := consulutil.NewFixture(t)
+	defer fixture.Stop()
+
+	applicator := NewConsulApplicator(fixture.Client, 0, 0)
+	applicator.SetAuthor("alice")
+
+	start := time.Now()
+	err := applicator.SetLabel(NODE, "node1", "foo", "bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// setting a label to its current value doesn't change anything
+	err = applicator.SetLabel(NODE, "node1", "foo", "bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	afterFirstChange := time.Now()
+	time.Sleep(10 * time.Millisecond)
+
+	err = applicator.SetLabels(NODE, "node1", map[string]string{"baz": "qux"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = applicator.SetLabel(NODE, "node2", "foo", "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = applicator.RemoveLabel(NODE, "node1", "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = applicator.RemoveAllLabels(NODE, "node1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := applicator.LabelHistory(NODE, "node1", start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 4 {
+		t.Fatalf("expected 4 changes to node1 but there were %d: %+v", len(changes), changes)
+	}
+	expectedKeys := [][]string{{"foo"}, {"baz"}, {"foo"}, {"baz"}}
+	for i, change := range changes {
+		if change.Author != "alice" {
+			t.Errorf("expected change %d to be authored by alice but was %q", i, change.Author)
+		}
+		if keys := change.ChangedKeys(); !reflect.DeepEqual(keys, expectedKeys[i]) {
+			t.Errorf("expected change %d to change %s but it changed %s", i, expectedKeys[i], keys)
+		}
+	}
+
+	labeled, err := applicator.LabelsAt(NODE, "node1", afterFirstChange)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(labeled.Labels, klabels.Set{"foo": "bar"}) {
+		t.Errorf("expected node1 to have been labeled foo=bar after the first change but had %s", labeled.Labels)
+	}
+	labeled, err = applicator.LabelsAt(NODE, "node1", start.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(labeled.Labels) != 0 {
+		t.Errorf("expected node1 to have no labels before its first change but had %s", labeled.Labels)
+	}
+
+	keyChanges, err := applicator.KeyHistory(NODE, "foo", afterFirstChange)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keyChanges) != 2 || keyChanges[0].ID != "node2" || keyChanges[1].ID != "node1" {
+		t.Errorf("expected foo to be set on node2 then removed from node1 but the changes were %+v", keyChanges)
+	}
+}
+
+func TestLabelHistoryTypes(t *testing.T) {
+	fixture := consulutil.NewFixture(t)
+	defer fixture.Stop()
+
+	applicator := NewConsulApplicator(fixture.Client, 0, 0)
+	err := applicator.SetLabel(POD, "node1/web", "foo", "bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, err := applicator.LabelHistory(POD, "node1/web", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("expected pod label changes not to be recorded by default but got %+v", changes)
+	}
+
+	applicator.SetHistoryTypes([]Type{NODE, POD})
+	err = applicator.SetLabel(POD, "node1/web", "foo", "baz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, err = applicator.LabelHistory(POD, "node1/web", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].After["foo"] != "baz" {
+		t.Errorf("expected the pod label change to be recorded once pod history was asked for but got %+v", changes)
+	}
+
+	applicator.SetHistoryTypes(nil)
+	err = applicator.SetLabel(NODE, "node1", "foo", "bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, err = applicator.LabelHistory(NODE, "node1", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("expected no changes to be recorded with no history types but got %+v", changes)
+	}
+}
+
+// failingFetcher fails every read of labels
+type failingFetcher struct{}
+
+func (failingFetcher) GetLabelsWithIndex(labelType Type, id string) (Labeled, uint64, error) {
+	return Labeled{}, 0, fmt.Errorf("labels were read")
+}
+
+func TestRemoveAllLabelsTxnReadsOnlyRecordedTypes(t *testing.T) {
+	history := newHistoryRecorder("alice", DefaultHistoryTypes)
+
+	ctx, cancel := transaction.New(context.Background())
+	defer cancel()
+	err := removeAllLabelsTxn(ctx, POD, "node1/web", failingFetcher{}, history)
+	if err != nil {
+		t.Errorf("expected labels without history to be removed without reading them but got %s", err)
+	}
+
+	err = removeAllLabelsTxn(ctx, NODE, "node1", failingFetcher{}, history)
+	if err == nil {
+		t.Error("expected the labels of a recorded type to be read before they are removed")
+	}
+}
+
+func TestKeyHistoryOfManyKeyChange(t *testing.T) {
+	fixture := consulutil.NewFixture(t)
+	defer fixture.Stop()
+
+	applicator := NewConsulApplicator(fixture.Client, 0, 0)
+	manyLabels := map[string]string{}
+	for i := 0; i <= maxIndexedKeys; i++ {
+		manyLabels[fmt.Sprintf("key%d", i)] = "value"
+	}
+	err := applicator.SetLabels(NODE, "node1", manyLabels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = applicator.SetLabel(NODE, "node1", "key0", "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pairs, _, err := fixture.Client.KV().List(keyHistoryRoot+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pairs) != 2 {
+		t.Errorf("expected one index entry for each change but there were %d", len(pairs))
+	}
+	for _, pair := range pairs {
+		if len(pair.Value) != 0 {
+			t.Errorf("expected index entry %s to have an empty value but it was %q", pair.Key, pair.Value)
+		}
+	}
+
+	keyChanges, err := applicator.KeyHistory(NODE, "key0", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keyChanges) != 2 || keyChanges[1].After["key0"] != "other" {
+		t.Errorf("expected both changes to key0 but got %+v", keyChanges)
+	}
+	keyChanges, err = applicator.KeyHistory(NODE, "key1", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keyChanges) != 1 {
+		t.Errorf("expected only the change to many keys to change key1 but got %+v", keyChanges)
+	}
+}
+
+func TestLabelServerRecordsAuthor(t *testing.T) {
+	fixture := consulutil.NewFixture(t)
+	defer fixture.Stop()
+
+	consulApplicator := NewConsulApplicator(fixture.Client, 0, 0)
+	server := httptest.NewServer(NewHTTPLabelServer(consulApplicator, 0, logging.TestLogger()).Handler())
+	defer server.Close()
+
+	serverURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	applicator, err := NewHTTPApplicator(nil, serverURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	applicator.history.author = "bob"
+
+	err = applicator.SetLabel(NODE, "node1", "foo", "bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := consulApplicator.LabelHistory(NODE, "node1", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 {
+		t.Fatalf("expected 1 change but there were %d", len(changes))
+	}
+	if !strings.HasPrefix(changes[0].Author, "bob via ") {
+		t.Errorf("expected the change to be attributed to bob via the label server but was %q", changes[0].Author)
+	}
+}
+
+func TestPruneHistory(t *testing.T) {
+	fixture := consulutil.NewFixture(t)
+	defer fixture.Stop()
+
+	applicator := NewConsulApplicator(fixture.Client, 0, 0)
+	for _, value := range []string{"a", "b", "c"} {
+		err := applicator.SetLabel(NODE, "node1", "foo", value)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := applicator.SetLabel(NODE, "node2", "foo", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pruned, err := applicator.PruneHistory(HistoryRetention{MaxCount: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pruned != 1 {
+		t.Errorf("expected the oldest change to node1 to be pruned but %d records were", pruned)
+	}
+	changes, err := applicator.LabelHistory(NODE, "node1", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 || changes[0].After["foo"] != "b" || changes[1].After["foo"] != "c" {
+		t.Errorf("expected the two most recent changes to node1 to be kept but got %+v", changes)
+	}
+	keyChanges, err := applicator.KeyHistory(NODE, "foo", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keyChanges) != 3 {
+		t.Errorf("expected the pruned change to be removed from the history of foo but got %+v", keyChanges)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	pruned, err = applicator.PruneHistory(HistoryRetention{MaxAge: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pruned != 3 {
+		t.Errorf("expected every remaining record to be too old but %d were pruned", pruned)
+	}
+	keys, _, err := fixture.Client.KV().Keys("", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range keys {
+		if strings.HasPrefix(key, historyRoot+"/") || strings.HasPrefix(key, keyHistoryRoot+"/") {
+			t.Errorf("expected all label history to be pruned but found %s", key)
+		}
+	}
+}
+
+func TestLockedHistoryPrunerWaitsForLock(t *testing.T) {
+	fixture := consulutil.NewFixture(t)
+	defer fixture.Stop()
+
+	applicator := NewConsulApplicator(fixture.Client, 0, 0)
+	err := applicator.SetLabel(NODE, "node1", "foo", "bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// another process is already pruning the label history
+	otherSession, _, err := fixture.Client.Session().CreateNoChecks(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlocker, err := consul.NewUnmanagedSession(fixture.Client, otherSession, "other").Lock(historyPrunerLockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, _, err := fixture.Client.Session().CreateNoChecks(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions := make(chan string, 1)
+	sessions <- session
+	quit := make(chan struct{})
+	defer close(quit)
+	go applicator.RunLockedHistoryPruner(fixture.Client, sessions, HistoryRetention{MaxAge: time.Millisecond}, 10*time.Millisecond, quit)
+
+	time.Sleep(100 * time.Millisecond)
+	changes, err := applicator.LabelHistory(NODE, "node1", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 {
+		t.Fatalf("expected the history not to be pruned while another process holds the lock but got %+v", changes)
+	}
+
+	err = unlocker.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	timeout := time.After(5 * time.Second)
+	for len(changes) > 0 {
+		select {
+		case <-timeout:
+			t.Fatalf("expected the history to be pruned once the lock was released but got %+v", changes)
+		case <-time.After(10 * time.Millisecond):
+		}
+		changes, err = applicator.LabelHistory(NODE, "node1", time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
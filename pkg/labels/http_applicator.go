@@ -1,14 +1,18 @@
 package labels
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"k8s.io/kubernetes/pkg/labels"
@@ -25,9 +29,19 @@ type httpApplicator struct {
 	// the URL.)
 	matchesEndpoint *url.URL
 	logger          logging.Logger
+
+	// history's author is sent with label changes so that the label
+	// server can attribute them to this client in the label history. The
+	// label server decides which changes it records, history's label types
+	// only apply to the changes made in transactions
+	history historyRecorder
 }
 
-var _ ApplicatorWithoutWatches = &httpApplicator{}
+var _ Applicator = &httpApplicator{}
+
+// watchRetryInterval is how long the httpApplicator waits before reconnecting
+// after a watch stream breaks
+const watchRetryInterval = 2 * time.Second
 
 func NewHTTPApplicator(client *http.Client, matchesEndpoint *url.URL) (*httpApplicator, error) {
 	if matchesEndpoint == nil {
@@ -43,9 +57,16 @@ func NewHTTPApplicator(client *http.Client, matchesEndpoint *url.URL) (*httpAppl
 		logger:          logging.DefaultLogger,
 		matchesEndpoint: matchesEndpoint,
 		client:          c,
+		history:         newHistoryRecorder(DefaultAuthor(), DefaultHistoryTypes),
 	}, nil
 }
 
+// SetHistoryTypes changes the label types whose changes made in transactions
+// are recorded in the label history. It defaults to DefaultHistoryTypes
+func (h *httpApplicator) SetHistoryTypes(labelTypes []Type) {
+	h.history = newHistoryRecorder(h.history.author, labelTypes)
+}
+
 func (h *httpApplicator) toEntityURL(pathTail string, labelType Type, id string, params url.Values) *url.URL {
 	return h.toURL(fmt.Sprintf("/api/labels/%v/%v%v", labelType, url.QueryEscape(id), pathTail), params)
 }
@@ -111,6 +132,7 @@ func (h *httpApplicator) putJSON(target *url.URL, toMarshal interface{}) error {
 	}
 
 	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add(LabelChangeAuthorHeader, h.history.author)
 
 	resp, err := h.client.Do(req)
 	if err != nil {
@@ -158,7 +180,7 @@ func (h *httpApplicator) SetLabels(labelType Type, id string, labels map[string]
 }
 
 func (h *httpApplicator) SetLabelsTxn(ctx context.Context, labelType Type, id string, labels map[string]string) error {
-	return setLabelsTxn(ctx, labelType, id, labels, h)
+	return setLabelsTxn(ctx, labelType, id, labels, h, h.history)
 }
 
 // Removes all labels on the entity
@@ -171,6 +193,7 @@ func (h *httpApplicator) RemoveLabel(labelType Type, id, name string) error {
 	if err != nil {
 		return err
 	}
+	req.Header.Add(LabelChangeAuthorHeader, h.history.author)
 	resp, err := h.client.Do(req)
 	if err != nil {
 		return err
@@ -182,11 +205,11 @@ func (h *httpApplicator) RemoveLabel(labelType Type, id, name string) error {
 }
 
 func (h *httpApplicator) RemoveLabelTxn(ctx context.Context, labelType Type, id string, keyToRemove string) error {
-	return removeLabelsTxn(ctx, labelType, id, []string{keyToRemove}, h)
+	return removeLabelsTxn(ctx, labelType, id, []string{keyToRemove}, h, h.history)
 }
 
 func (h *httpApplicator) RemoveLabelsTxn(ctx context.Context, labelType Type, id string, keysToRemove []string) error {
-	return removeLabelsTxn(ctx, labelType, id, keysToRemove, h)
+	return removeLabelsTxn(ctx, labelType, id, keysToRemove, h, h.history)
 }
 
 // Removes all labels on the entity
@@ -199,6 +222,7 @@ func (h *httpApplicator) RemoveAllLabels(labelType Type, id string) error {
 	if err != nil {
 		return err
 	}
+	req.Header.Add(LabelChangeAuthorHeader, h.history.author)
 
 	resp, err := h.client.Do(req)
 	if err != nil {
@@ -211,7 +235,7 @@ func (h *httpApplicator) RemoveAllLabels(labelType Type, id string) error {
 }
 
 func (h *httpApplicator) RemoveAllLabelsTxn(ctx context.Context, labelType Type, id string) error {
-	return removeAllLabelsTxn(ctx, labelType, id)
+	return removeAllLabelsTxn(ctx, labelType, id, h, h.history)
 }
 
 // Finds all labels assigned to all entities under a type
@@ -335,3 +359,159 @@ func (h *httpApplicator) GetMatches(selector labels.Selector, labelType Type) ([
 func (h *httpApplicator) GetCachedMatches(selector labels.Selector, labelType Type, aggregationRate time.Duration) ([]Labeled, error) {
 	return h.getMatches(selector, labelType, true)
 }
+
+// WatchMatches streams changes to the matches of the selector from the label
+// server's watch endpoint and sends the full set of matches after each change.
+// If the stream breaks it is reopened. An error is only returned if the
+// initial request fails, e.g. because the server doesn't support watches.
+//
+// aggregationRate is ignored here because that is configured on the server.
+//
+// GET /api/watch?selector=:selector&type=:type
+func (h *httpApplicator) WatchMatches(selector labels.Selector, labelType Type, aggregationRate time.Duration, quitCh <-chan struct{}) (chan []Labeled, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	body, err := h.openWatch(ctx, selector, labelType)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	// like the consul aggregator's watches, the channel is buffered and
+	// stale results are replaced rather than blocking on slow readers
+	outCh := make(chan []Labeled, 1)
+	go func() {
+		<-quitCh
+		cancel()
+	}()
+	go func() {
+		defer close(outCh)
+		for {
+			err := h.readWatch(body, outCh)
+			select {
+			case <-quitCh:
+				return
+			default:
+			}
+			h.logger.WithError(err).Errorln("Label watch stream broke, reconnecting")
+
+			body = nil
+			for body == nil {
+				select {
+				case <-quitCh:
+					return
+				case <-time.After(watchRetryInterval):
+				}
+				body, err = h.openWatch(ctx, selector, labelType)
+				if err != nil {
+					h.logger.WithError(err).Errorln("Could not reconnect label watch stream, will retry")
+				}
+			}
+		}
+	}()
+	return outCh, nil
+}
+
+func (h *httpApplicator) WatchMatchDiff(
+	selector labels.Selector,
+	labelType Type,
+	aggregationRate time.Duration,
+	quitCh <-chan struct{},
+) <-chan *LabeledChanges {
+	inCh, err := h.WatchMatches(selector, labelType, aggregationRate, quitCh)
+	if err != nil {
+		h.logger.WithError(err).Errorln("Could not watch label selector")
+		inCh = make(chan []Labeled)
+		close(inCh)
+	}
+	return watchDiffLabels(inCh, quitCh, h.logger)
+}
+
+func (h *httpApplicator) openWatch(ctx context.Context, selector labels.Selector, labelType Type) (io.ReadCloser, error) {
+	params := url.Values{}
+	params.Add("selector", selector.String())
+	params.Add("type", labelType.String())
+	req, err := http.NewRequest("GET", h.toURL("/api/watch", params).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Accept", "text/event-stream")
+
+	// the stream is long lived, so the client's timeout can't apply to it.
+	// Dead connections are detected by missing keepalives instead
+	streamClient := *h.client
+	streamClient.Timeout = 0
+	resp, err := streamClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if err = convertHTTPRespToErr(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// readWatch reads server-sent events from the watch stream until it breaks,
+// keeping track of the matches and sending them on outCh after each event.
+// The first event from a stream has every match, so the matches from previous
+// streams are discarded
+func (h *httpApplicator) readWatch(body io.ReadCloser, outCh chan []Labeled) error {
+	// close the stream if the server stops sending keepalives
+	idleTimeout := 3 * WatchKeepaliveInterval
+	idle := time.AfterFunc(idleTimeout, func() { _ = body.Close() })
+	defer idle.Stop()
+	defer body.Close()
+
+	matches := make(map[string]Labeled)
+	reader := bufio.NewReader(body)
+	var data bytes.Buffer
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		idle.Reset(idleTimeout)
+
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case strings.HasPrefix(line, "data:"):
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+			continue
+		case line != "" || data.Len() == 0:
+			// event names and comments such as keepalives are ignored
+			continue
+		}
+
+		var changes LabeledChanges
+		err = json.Unmarshal(data.Bytes(), &changes)
+		data.Reset()
+		if err != nil {
+			return util.Errorf("Could not parse label changes: %s", err)
+		}
+		for _, labeled := range changes.Created {
+			matches[labeled.ID] = labeled
+		}
+		for _, labeled := range changes.Updated {
+			matches[labeled.ID] = labeled
+		}
+		for _, labeled := range changes.Deleted {
+			delete(matches, labeled.ID)
+		}
+
+		result := make([]Labeled, 0, len(matches))
+		for _, labeled := range matches {
+			result = append(result, labeled)
+		}
+		sort.Slice(result, func(i, j int) bool {
+			return result[i].ID < result[j].ID
+		})
+
+		// drop a result the reader hasn't picked up yet, since this
+		// one supersedes it
+		select {
+		case <-outCh:
+		default:
+		}
+		outCh <- result
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"reflect"
 	"sync"
 	"testing"
 	"time"
@@ -159,3 +160,66 @@ func fakeServerAndApplicator(t *testing.T, batchTime time.Duration) (*httptest.S
 	Assert(t).IsNil(err, "expected no error creating HTTP applicator")
 	return server, applicator
 }
+
+func waitForWatchIDs(t *testing.T, matchCh <-chan []Labeled, expected ...string) {
+	timeout := time.After(5 * time.Second)
+	var ids []string
+	for {
+		select {
+		case <-timeout:
+			t.Fatalf("expected the watch to match %s but the last result was %s", expected, ids)
+		case matches, ok := <-matchCh:
+			if !ok {
+				t.Fatal("watch channel closed unexpectedly")
+			}
+			ids = nil
+			for _, match := range matches {
+				ids = append(ids, match.ID)
+			}
+			if reflect.DeepEqual(ids, expected) {
+				return
+			}
+		}
+	}
+}
+
+func TestWatchMatches(t *testing.T) {
+	fakeApplicator := NewFakeApplicator()
+	Assert(t).IsNil(fakeApplicator.SetLabel(POD, "a", "color", "red"), "expected no error setting label")
+	server := httptest.NewServer(NewHTTPLabelServer(fakeApplicator, 0, logging.TestLogger()).Handler())
+	defer server.Close()
+
+	url, err := url.Parse(server.URL)
+	Assert(t).IsNil(err, "expected no error parsing url")
+	applicator, err := NewHTTPApplicator(nil, url)
+	Assert(t).IsNil(err, "expected no error creating HTTP applicator")
+
+	quitCh := make(chan struct{})
+	defer close(quitCh)
+	matchCh, err := applicator.WatchMatches(labels.Everything().Add("color", labels.EqualsOperator, []string{"red"}), POD, 0, quitCh)
+	Assert(t).IsNil(err, "expected no error starting watch")
+	waitForWatchIDs(t, matchCh, "a")
+
+	Assert(t).IsNil(fakeApplicator.SetLabel(POD, "b", "color", "red"), "expected no error setting label")
+	waitForWatchIDs(t, matchCh, "a", "b")
+
+	Assert(t).IsNil(fakeApplicator.RemoveLabel(POD, "a", "color"), "expected no error removing label")
+	waitForWatchIDs(t, matchCh, "b")
+}
+
+func TestWatchMatchesUnsupported(t *testing.T) {
+	// hide the fake applicator's WatchMatches from the server
+	withoutWatches := struct{ ApplicatorWithoutWatches }{NewFakeApplicator()}
+	server := httptest.NewServer(NewHTTPLabelServer(withoutWatches, 0, logging.TestLogger()).Handler())
+	defer server.Close()
+
+	url, err := url.Parse(server.URL)
+	Assert(t).IsNil(err, "expected no error parsing url")
+	applicator, err := NewHTTPApplicator(nil, url)
+	Assert(t).IsNil(err, "expected no error creating HTTP applicator")
+
+	quitCh := make(chan struct{})
+	defer close(quitCh)
+	_, err = applicator.WatchMatches(labels.Everything(), POD, 0, quitCh)
+	Assert(t).IsNotNil(err, "expected an error watching a server that can't watch")
+}
@@ -3,6 +3,7 @@ package labels
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -35,6 +36,24 @@ type ApplicatorWithoutWatches interface {
 	GetLabelsWithIndex(labelType Type, id string) (Labeled, uint64, error)
 }
 
+// WatchKeepaliveInterval is how often the watch endpoint sends a comment when
+// there are no changes, so that clients can detect broken connections
+const WatchKeepaliveInterval = 15 * time.Second
+
+// MatchWatcher is implemented by applicators that can watch a selector, such
+// as the ConsulApplicator. The label server can only serve watches if its
+// applicator is one
+type MatchWatcher interface {
+	WatchMatches(selector klabels.Selector, labelType Type, aggregationRate time.Duration, quitCh <-chan struct{}) (chan []Labeled, error)
+}
+
+// AuthoredApplicator is implemented by applicators that record label history.
+// The label server uses it to attribute label changes to its clients rather
+// than to itself
+type AuthoredApplicator interface {
+	WithAuthor(author string) ApplicatorWithoutWatches
+}
+
 // A simple http server that operates on a given applicator and terminates all
 // the endpoints expected by the httpApplicator.
 type labelHTTPServer struct {
@@ -52,6 +71,7 @@ func NewHTTPLabelServer(applicator ApplicatorWithoutWatches, batchTime time.Dura
 
 func (l *labelHTTPServer) AddRoutes(r *mux.Router) {
 	r.Methods("GET").Path("/api/select").HandlerFunc(l.Select)
+	r.Methods("GET").Path("/api/watch").HandlerFunc(l.Watch)
 	r.Methods("GET").Path("/api/labels/{type}/{id}").HandlerFunc(l.GetLabels)
 	r.Methods("GET").Path("/api/labels/{type}").HandlerFunc(l.ListLabels)
 	r.Methods("PUT").Path("/api/labels/{type}/{id}/{name}").HandlerFunc(l.SetLabel)
@@ -92,6 +112,22 @@ func (l *labelHTTPServer) unavailable(resp http.ResponseWriter, endpoint string,
 	counter.Inc(1)
 }
 
+// mutator returns the applicator to make a request's label changes with. The
+// author header is set by the client and isn't authenticated, so the client's
+// address is recorded alongside it
+func (l *labelHTTPServer) mutator(req *http.Request) ApplicatorWithoutWatches {
+	authored, ok := l.applicator.(AuthoredApplicator)
+	if !ok {
+		return l.applicator
+	}
+
+	author := req.Header.Get(LabelChangeAuthorHeader)
+	if author == "" {
+		author = "unknown"
+	}
+	return authored.WithAuthor(fmt.Sprintf("%s via %s", author, req.RemoteAddr))
+}
+
 func (l *labelHTTPServer) Handler() *mux.Router {
 	r := mux.NewRouter()
 	l.AddRoutes(r)
@@ -164,7 +200,7 @@ func (l *labelHTTPServer) Select(resp http.ResponseWriter, req *http.Request) {
 		return
 	}
 	timeHandler(endpoint, labelType, func(endpoint string) {
-		selector, err := klabels.Parse(req.URL.Query().Get("selector"))
+		selector, err := ParseSelector(req.URL.Query().Get("selector"))
 		if err != nil {
 			l.badRequest(resp, endpoint, err)
 			return
@@ -199,6 +235,115 @@ func (l *labelHTTPServer) Select(resp http.ResponseWriter, req *http.Request) {
 	})
 }
 
+// Watch streams the changes to the matches of a selector as server-sent events
+// named "changes", each with a JSON LabeledChanges as its data. The first event
+// has every match as created, and each later event has only what changed since
+// the previous one. The matches come from the applicator's watch, so when it's
+// a ConsulApplicator all watches of a label type share one aggregator rather
+// than each querying consul.
+//
+// GET /api/watch?selector=:selector&type=:type
+func (l *labelHTTPServer) Watch(resp http.ResponseWriter, req *http.Request) {
+	endpoint := "watch"
+	labelType, err := AsType(req.URL.Query().Get("type"))
+	if err != nil {
+		l.badRequest(resp, endpoint, err)
+		return
+	}
+	selector, err := ParseSelector(req.URL.Query().Get("selector"))
+	if err != nil {
+		l.badRequest(resp, endpoint, err)
+		return
+	}
+	watcher, ok := l.applicator.(MatchWatcher)
+	if !ok {
+		http.Error(resp, "this label server cannot watch selectors", http.StatusNotImplemented)
+		return
+	}
+	flusher, ok := resp.(http.Flusher)
+	if !ok {
+		l.unavailable(resp, endpoint, errors.New("streaming is not supported"))
+		return
+	}
+
+	counter := metrics.GetOrRegisterCounter(fmt.Sprintf("%v-%v-active", endpoint, labelType), p2metrics.Registry)
+	counter.Inc(1)
+	defer counter.Dec(1)
+
+	quitCh := make(chan struct{})
+	defer close(quitCh)
+	changesCh := watchDiffLabels(l.watchMatches(watcher, selector, labelType, quitCh), quitCh, l.logger)
+
+	resp.Header().Set("Content-Type", "text/event-stream")
+	resp.Header().Set("Cache-Control", "no-cache")
+	resp.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepalive := time.NewTicker(WatchKeepaliveInterval)
+	defer keepalive.Stop()
+	sentInitial := false
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-keepalive.C:
+			_, err = fmt.Fprint(resp, ": keepalive\n\n")
+		case changes, ok := <-changesCh:
+			if !ok {
+				return
+			}
+			if sentInitial && len(changes.Created) == 0 && len(changes.Updated) == 0 && len(changes.Deleted) == 0 {
+				continue
+			}
+			sentInitial = true
+
+			var data []byte
+			data, err = json.Marshal(changes)
+			if err != nil {
+				l.logger.WithError(err).Errorln("Could not marshal label changes")
+				return
+			}
+			_, err = fmt.Fprintf(resp, "event: changes\ndata: %s\n\n", data)
+		}
+		if err != nil {
+			// the client went away
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// watchMatches passes on the matches from the watcher until quitCh is closed.
+// Watches can end without quitCh being closed, in which case a new one is
+// started
+func (l *labelHTTPServer) watchMatches(watcher MatchWatcher, selector klabels.Selector, labelType Type, quitCh <-chan struct{}) <-chan []Labeled {
+	outCh := make(chan []Labeled)
+	go func() {
+		defer close(outCh)
+		for {
+			matchCh, err := watcher.WatchMatches(selector, labelType, DefaultAggregationRate, quitCh)
+			if err != nil {
+				l.logger.WithError(err).Errorln("Could not watch label selector")
+				return
+			}
+			for matches := range matchCh {
+				select {
+				case outCh <- matches:
+				case <-quitCh:
+					return
+				}
+			}
+
+			select {
+			case <-quitCh:
+				return
+			case <-time.After(time.Second):
+			}
+		}
+	}()
+	return outCh
+}
+
 type LabeledWithIndex struct {
 	Labeled
 	Index uint64 `json:"index"`
@@ -271,7 +416,7 @@ func (l *labelHTTPServer) SetLabel(resp http.ResponseWriter, req *http.Request)
 			l.unavailable(resp, endpoint, err)
 			return
 		}
-		err = l.applicator.SetLabel(labelType, id, name, setLabelRequest.Value)
+		err = l.mutator(req).SetLabel(labelType, id, name, setLabelRequest.Value)
 		if err != nil {
 			l.unavailable(resp, endpoint, err)
 			return
@@ -300,7 +445,7 @@ func (l *labelHTTPServer) SetLabels(resp http.ResponseWriter, req *http.Request)
 			l.unavailable(resp, endpoint, err)
 			return
 		}
-		err = l.applicator.SetLabels(labelType, id, setLabelsRequest.Values)
+		err = l.mutator(req).SetLabels(labelType, id, setLabelsRequest.Values)
 		if err != nil {
 			l.unavailable(resp, endpoint, err)
 			return
@@ -317,7 +462,7 @@ func (l *labelHTTPServer) RemoveLabel(resp http.ResponseWriter, req *http.Reques
 		return
 	}
 	timeHandler(endpoint, labelType, func(endpoint string) {
-		err = l.applicator.RemoveLabel(labelType, id, name)
+		err = l.mutator(req).RemoveLabel(labelType, id, name)
 		if err != nil {
 			l.unavailable(resp, endpoint, err)
 			return
@@ -334,7 +479,7 @@ func (l *labelHTTPServer) RemoveLabels(resp http.ResponseWriter, req *http.Reque
 		return
 	}
 	timeHandler(endpoint, labelType, func(endpoint string) {
-		err = l.applicator.RemoveAllLabels(labelType, id)
+		err = l.mutator(req).RemoveAllLabels(labelType, id)
 		if err != nil {
 			l.unavailable(resp, endpoint, err)
 			return
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
 
 	"github.com/square/p2/pkg/logging"
@@ -65,3 +66,42 @@ func TestGetLabels(t *testing.T) {
 		t.Fatalf("expected index to be %d but was %d", index, resp.Index)
 	}
 }
+
+func TestSelectNumeric(t *testing.T) {
+	fixture := consulutil.NewFixture(t)
+	defer fixture.Stop()
+
+	applicator := NewConsulApplicator(fixture.Client, 0, 0)
+	server := NewHTTPLabelServer(applicator, 0, logging.TestLogger())
+	router := mux.NewRouter()
+	server.AddRoutes(router)
+
+	for node, memory := range map[string]string{"node1": "32", "node2": "128"} {
+		err := applicator.SetLabel(NODE, node, "memory_gb", memory)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	req, err := http.NewRequest("GET", "http://doesntmatter.com/api/select?type=node&selector="+url.QueryEscape("memory_gb >= 64"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	respBytes := w.Body.Bytes()
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d response from server with respone: %s", w.Code, string(respBytes))
+	}
+
+	var matches []Labeled
+	err = json.Unmarshal(respBytes, &matches)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 || matches[0].ID != "node2" {
+		t.Errorf("expected only node2 to match but got %+v", matches)
+	}
+}
@@ -0,0 +1,219 @@
+package labels
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"k8s.io/kubernetes/pkg/labels"
+)
+
+// Numeric comparison operators. The vendored selector parser only supports
+// equality and set membership, so these requirements are parsed out of the
+// selector string by ParseSelector and matched by numericSelector. They only
+// match labels whose values are numbers, e.g. "memory_gb>64,kernel_minor>=10"
+const (
+	GreaterThanOperator        labels.Operator = ">"
+	GreaterThanOrEqualOperator labels.Operator = ">="
+	LessThanOperator           labels.Operator = "<"
+	LessThanOrEqualOperator    labels.Operator = "<="
+
+	// GreaterThanKeywordOperator and LessThanKeywordOperator are spelled
+	// like the "in" and "notin" keywords, e.g. "memory_gb gt 64". They are
+	// the same as > and <, which requirements using them are printed as
+	GreaterThanKeywordOperator labels.Operator = "gt"
+	LessThanKeywordOperator    labels.Operator = "lt"
+)
+
+// keywordOperators maps the keyword operators to the ones they spell
+var keywordOperators = map[labels.Operator]labels.Operator{
+	GreaterThanKeywordOperator: GreaterThanOperator,
+	LessThanKeywordOperator:    LessThanOperator,
+}
+
+// keyword operators must be separated from the key and value by whitespace,
+// since they could otherwise be part of either
+var numericRequirementRegexp = regexp.MustCompile(`^\s*([^\s<>=!(),]+)(\s*(?:>=|<=|>|<)\s*|\s+(?:gt|lt)\s+)([^\s<>=!(),]+)\s*$`)
+
+// ParseSelector parses a label selector in the same grammar as
+// k8s.io/kubernetes/pkg/labels.Parse, with the addition of numeric comparisons
+// using the gt, lt, >, >=, < and <= operators. Selectors without numeric
+// comparisons are parsed by the vendored parser unchanged
+func ParseSelector(selector string) (labels.Selector, error) {
+	var numeric []numericRequirement
+	var rest []string
+	for _, requirement := range splitRequirements(selector) {
+		parts := numericRequirementRegexp.FindStringSubmatch(requirement)
+		if parts == nil {
+			rest = append(rest, requirement)
+			continue
+		}
+		operator := labels.Operator(strings.TrimSpace(parts[2]))
+		req, err := newNumericRequirement(parts[1], operator, parts[3])
+		if err != nil {
+			return nil, err
+		}
+		numeric = append(numeric, req)
+	}
+
+	base, err := labels.Parse(strings.Join(rest, ","))
+	if err != nil {
+		return nil, err
+	}
+	if len(numeric) == 0 {
+		return base, nil
+	}
+	return newNumericSelector(base, numeric), nil
+}
+
+// splitRequirements splits a selector on the commas between requirements,
+// leaving the commas within "in" and "notin" value sets alone
+func splitRequirements(selector string) []string {
+	var requirements []string
+	depth := 0
+	start := 0
+	for i, c := range selector {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				requirements = append(requirements, selector[start:i])
+				start = i + 1
+			}
+		}
+	}
+	last := selector[start:]
+	if len(requirements) > 0 || strings.TrimSpace(last) != "" {
+		requirements = append(requirements, last)
+	}
+	return requirements
+}
+
+type numericRequirement struct {
+	key      string
+	operator labels.Operator
+	value    float64
+}
+
+func newNumericRequirement(key string, operator labels.Operator, value string) (numericRequirement, error) {
+	// validate the key the same way the vendored parser does
+	_, err := labels.NewRequirement(key, labels.ExistsOperator, nil)
+	if err != nil {
+		return numericRequirement{}, err
+	}
+	if spelled, ok := keywordOperators[operator]; ok {
+		operator = spelled
+	}
+	switch operator {
+	case GreaterThanOperator, GreaterThanOrEqualOperator, LessThanOperator, LessThanOrEqualOperator:
+	default:
+		return numericRequirement{}, fmt.Errorf("operator '%v' is not a numeric comparison", operator)
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return numericRequirement{}, fmt.Errorf("'%s' requires a numeric value but was given %q", operator, value)
+	}
+	return numericRequirement{
+		key:      key,
+		operator: operator,
+		value:    parsed,
+	}, nil
+}
+
+func (r numericRequirement) Matches(ls labels.Labels) bool {
+	if !ls.Has(r.key) {
+		return false
+	}
+	value, err := strconv.ParseFloat(ls.Get(r.key), 64)
+	if err != nil {
+		return false
+	}
+	switch r.operator {
+	case GreaterThanOperator:
+		return value > r.value
+	case GreaterThanOrEqualOperator:
+		return value >= r.value
+	case LessThanOperator:
+		return value < r.value
+	case LessThanOrEqualOperator:
+		return value <= r.value
+	}
+	return false
+}
+
+func (r numericRequirement) String() string {
+	return r.key + string(r.operator) + strconv.FormatFloat(r.value, 'f', -1, 64)
+}
+
+// numericSelector adds numeric comparisons to a selector returned by the
+// vendored parser
+type numericSelector struct {
+	base    labels.Selector
+	numeric []numericRequirement
+}
+
+func newNumericSelector(base labels.Selector, numeric []numericRequirement) numericSelector {
+	// sort to make String() deterministic, like the vendored parser does
+	sort.Slice(numeric, func(i, j int) bool {
+		return numeric[i].String() < numeric[j].String()
+	})
+	return numericSelector{
+		base:    base,
+		numeric: numeric,
+	}
+}
+
+func (s numericSelector) Matches(ls labels.Labels) bool {
+	for _, req := range s.numeric {
+		if !req.Matches(ls) {
+			return false
+		}
+	}
+	return s.base.Matches(ls)
+}
+
+func (s numericSelector) Empty() bool {
+	return len(s.numeric) == 0 && s.base.Empty()
+}
+
+// String returns the selector in a form that ParseSelector will parse back
+// into an equivalent selector
+func (s numericSelector) String() string {
+	var reqs []string
+	if base := s.base.String(); base != "" {
+		reqs = append(reqs, base)
+	}
+	for _, req := range s.numeric {
+		reqs = append(reqs, req.String())
+	}
+	return strings.Join(reqs, ",")
+}
+
+// Add returns a copy of the selector with the requirement added, which may use
+// a numeric comparison operator. Like the vendored selectors, invalid
+// requirements are ignored
+func (s numericSelector) Add(key string, operator labels.Operator, values []string) labels.Selector {
+	switch operator {
+	case GreaterThanOperator, GreaterThanOrEqualOperator, LessThanOperator, LessThanOrEqualOperator,
+		GreaterThanKeywordOperator, LessThanKeywordOperator:
+		if len(values) != 1 {
+			return s
+		}
+		req, err := newNumericRequirement(key, operator, values[0])
+		if err != nil {
+			return s
+		}
+		numeric := append(append([]numericRequirement(nil), s.numeric...), req)
+		return newNumericSelector(s.base, numeric)
+	default:
+		return numericSelector{
+			base:    s.base.Add(key, operator, values),
+			numeric: s.numeric,
+		}
+	}
+}
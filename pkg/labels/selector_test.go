@@ -0,0 +1,107 @@
+package labels
+
+import (
+	"testing"
+
+	klabels "k8s.io/kubernetes/pkg/labels"
+)
+
+func TestParseSelectorNumeric(t *testing.T) {
+	type testCase struct {
+		selector string
+		labels   klabels.Set
+		matches  bool
+	}
+	for _, tc := range []testCase{
+		{"memory_gb>64", klabels.Set{"memory_gb": "128"}, true},
+		{"memory_gb > 64", klabels.Set{"memory_gb": "64"}, false},
+		{"memory_gb>=64", klabels.Set{"memory_gb": "64"}, true},
+		{"kernel_minor<10", klabels.Set{"kernel_minor": "9"}, true},
+		{"kernel_minor<=10", klabels.Set{"kernel_minor": "10.5"}, false},
+		{"memory_gb>64", klabels.Set{"memory_gb": "lots"}, false},
+		{"memory_gb>64", klabels.Set{}, false},
+		{"az in (a,b),memory_gb>64,role=web", klabels.Set{"az": "b", "memory_gb": "96", "role": "web"}, true},
+		{"az in (a,b),memory_gb>64,role=web", klabels.Set{"az": "c", "memory_gb": "96", "role": "web"}, false},
+		{"role!=db", klabels.Set{"role": "web"}, true},
+		{"memory_gb gt 64", klabels.Set{"memory_gb": "128"}, true},
+		{"memory_gb gt 64", klabels.Set{"memory_gb": "64"}, false},
+		{"kernel_minor lt 10", klabels.Set{"kernel_minor": "9"}, true},
+		{"kernel_minor lt 10", klabels.Set{"kernel_minor": "10"}, false},
+		{"az in (a,b),memory_gb gt 64,cores lt 32", klabels.Set{"az": "a", "memory_gb": "96", "cores": "16"}, true},
+	} {
+		selector, err := ParseSelector(tc.selector)
+		if err != nil {
+			t.Errorf("unexpected error parsing %q: %s", tc.selector, err)
+			continue
+		}
+		if matches := selector.Matches(tc.labels); matches != tc.matches {
+			t.Errorf("expected %q matching %s to be %t but was %t", tc.selector, tc.labels, tc.matches, matches)
+		}
+
+		// selectors are sent to the label server as strings, so they
+		// need to survive a round trip
+		reparsed, err := ParseSelector(selector.String())
+		if err != nil {
+			t.Errorf("unexpected error reparsing %q: %s", selector.String(), err)
+			continue
+		}
+		if reparsed.String() != selector.String() {
+			t.Errorf("expected %q to be reparsed as itself but was %q", selector.String(), reparsed.String())
+		}
+	}
+}
+
+func TestParseSelectorErrors(t *testing.T) {
+	for _, selector := range []string{
+		"memory_gb>lots",
+		"-bad-key->3",
+		"memory_gb>",
+		"az in (a,b",
+		"memory_gb gt lots",
+		"memory_gb gt",
+	} {
+		_, err := ParseSelector(selector)
+		if err == nil {
+			t.Errorf("expected an error parsing %q", selector)
+		}
+	}
+}
+
+func TestParseSelectorKeywordOperators(t *testing.T) {
+	selector, err := ParseSelector("memory_gb gt 64,kernel_minor lt 10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	symbols, err := ParseSelector("memory_gb>64,kernel_minor<10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if selector.String() != symbols.String() {
+		t.Errorf("expected gt and lt to be the same as > and <, but %q was parsed as %q", symbols.String(), selector.String())
+	}
+
+	added := klabels.Everything().Add("role", klabels.EqualsOperator, []string{"web"})
+	added = newNumericSelector(added, nil).Add("cores", GreaterThanKeywordOperator, []string{"8"})
+	if added.String() != "role=web,cores>8" {
+		t.Errorf("expected adding a gt requirement to add a > requirement but got %q", added.String())
+	}
+}
+
+func TestNumericSelectorAdd(t *testing.T) {
+	selector, err := ParseSelector("memory_gb>64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	selector = selector.Add("role", klabels.EqualsOperator, []string{"web"}).
+		Add("cores", LessThanOperator, []string{"32"})
+
+	if selector.String() != "role=web,cores<32,memory_gb>64" {
+		t.Errorf("unexpected selector %q", selector.String())
+	}
+	if !selector.Matches(klabels.Set{"memory_gb": "128", "role": "web", "cores": "16"}) {
+		t.Error("expected selector to match")
+	}
+	if selector.Matches(klabels.Set{"memory_gb": "128", "role": "web", "cores": "64"}) {
+		t.Error("expected the added numeric requirement to be enforced")
+	}
+}
@@ -0,0 +1,115 @@
+package launch
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"time"
+)
+
+// LaunchableStatusStanza lists the status checks of a single launchable.
+// Checks are readiness checks: their results are published alongside the
+// pod's own health. Liveness checks are not published; instead the preparer
+// restarts the launchable once one of them reaches its failure threshold
+type LaunchableStatusStanza struct {
+	Checks   []HealthCheckStanza `yaml:"checks,omitempty"`
+	Liveness []HealthCheckStanza `yaml:"liveness,omitempty"`
+}
+
+type HealthCheckType string
+
+const (
+	// TCPHealthCheck passes if a TCP connection can be made to the port
+	TCPHealthCheck HealthCheckType = "tcp"
+
+	// HTTPHealthCheck passes if a GET request to the port and path returns
+	// the expected status and a body matching the body regex, if any
+	HTTPHealthCheck HealthCheckType = "http"
+
+	// ExecHealthCheck passes if the command exits zero when run under
+	// p2-exec as the pod's user
+	ExecHealthCheck HealthCheckType = "exec"
+
+	// RunitHealthCheck passes if every runit service of the pod, or of the
+	// launchable for launchable checks, is up
+	RunitHealthCheck HealthCheckType = "runit"
+)
+
+type HealthCheckStanza struct {
+	Type HealthCheckType `yaml:"type"`
+
+	// Port is used by tcp and http checks, Path and the fields after it by
+	// http checks only. HTTP and LocalhostOnly mean the same as they do in
+	// the pod's status stanza. ExpectedStatus defaults to any 2xx status
+	Port           int    `yaml:"port,omitempty"`
+	Path           string `yaml:"path,omitempty"`
+	HTTP           bool   `yaml:"http,omitempty"`
+	LocalhostOnly  bool   `yaml:"localhost_only,omitempty"`
+	ExpectedStatus int    `yaml:"expected_status,omitempty"`
+	BodyRegex      string `yaml:"body_regex,omitempty"`
+
+	// Command is used by exec checks
+	Command []string `yaml:"command,omitempty"`
+
+	// Interval and Timeout are in seconds. FailureThreshold is the number of
+	// consecutive failures after which the check is considered failing.
+	// Unset values get defaults from the health checker.
+	Interval         int `yaml:"interval,omitempty"`
+	Timeout          int `yaml:"timeout,omitempty"`
+	FailureThreshold int `yaml:"failure_threshold,omitempty"`
+
+	// InitialDelay is in seconds and only applies to liveness checks. They
+	// aren't run until this long after the launchable is started or
+	// restarted, so that a slow starting launchable isn't restarted before
+	// it could come up
+	InitialDelay int `yaml:"initial_delay,omitempty"`
+}
+
+func (c HealthCheckStanza) GetInterval() time.Duration {
+	return time.Duration(c.Interval) * time.Second
+}
+
+func (c HealthCheckStanza) GetTimeout() time.Duration {
+	return time.Duration(c.Timeout) * time.Second
+}
+
+func (c HealthCheckStanza) GetInitialDelay() time.Duration {
+	return time.Duration(c.InitialDelay) * time.Second
+}
+
+// GetPath returns the path of an http check, which defaults to the same path
+// as the pod's status check
+func (c HealthCheckStanza) GetPath() string {
+	if c.Path != "" {
+		return path.Join("/", c.Path)
+	}
+	return "/_status"
+}
+
+// Validate returns an error if the check is missing settings its type needs
+func (c HealthCheckStanza) Validate() error {
+	switch c.Type {
+	case TCPHealthCheck:
+		if c.Port <= 0 {
+			return fmt.Errorf("tcp health checks must contain a 'port'")
+		}
+	case HTTPHealthCheck:
+		if c.Port <= 0 {
+			return fmt.Errorf("http health checks must contain a 'port'")
+		}
+		if _, err := regexp.Compile(c.BodyRegex); err != nil {
+			return fmt.Errorf("http health check has an invalid 'body_regex': %s", err)
+		}
+	case ExecHealthCheck:
+		if len(c.Command) == 0 {
+			return fmt.Errorf("exec health checks must contain a 'command'")
+		}
+	case RunitHealthCheck:
+	default:
+		return fmt.Errorf("unknown health check type '%s'", c.Type)
+	}
+	if c.Interval < 0 || c.Timeout < 0 || c.FailureThreshold < 0 || c.InitialDelay < 0 {
+		return fmt.Errorf("%s health check 'interval', 'timeout', 'failure_threshold' and 'initial_delay' must not be negative", c.Type)
+	}
+	return nil
+}
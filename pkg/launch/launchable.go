@@ -92,6 +92,12 @@ type LaunchableStanza struct {
 
 	// PreStop: only supported for docker launchables. This value specifies what command to run before the container is stopped. This is equivalent to the disable script for hoist launchables
 	PreStop PreStop `yaml:"preStop,omitempty"`
+
+	// Status lists health checks for just this launchable. The results of
+	// its readiness checks are published with the pod's health, which is no
+	// better than the worst of them. Failing liveness checks get the
+	// launchable restarted
+	Status LaunchableStatusStanza `yaml:"status,omitempty"`
 }
 
 // DockerImage contains launchable information specific to the "docker" launchable type.
@@ -31,6 +31,11 @@ type StatusStanza struct {
 	Path          string `yaml:"path,omitempty"`
 	Port          int    `yaml:"port,omitempty"`
 	LocalhostOnly bool   `yaml:"localhost_only,omitempty"`
+
+	// Checks, if present, are run instead of the HTTP status check
+	// described by the fields above. The pod is healthy only while every
+	// check passes.
+	Checks []launch.HealthCheckStanza `yaml:"checks,omitempty"`
 }
 
 type Builder interface {
@@ -543,6 +548,16 @@ func ValidManifest(m Manifest) error {
 		if stanza.LaunchableType == "" {
 			return fmt.Errorf("'%s': launchable must contain a 'launchable_type'", launchableID)
 		}
+		for i, check := range stanza.Status.Checks {
+			if err := check.Validate(); err != nil {
+				return fmt.Errorf("'%s': status check %d: %s", launchableID, i, err)
+			}
+		}
+		for i, check := range stanza.Status.Liveness {
+			if err := check.Validate(); err != nil {
+				return fmt.Errorf("'%s': liveness check %d: %s", launchableID, i, err)
+			}
+		}
 		if stanza.LaunchableType == launch.HoistLaunchableType || stanza.LaunchableType == launch.OpenContainerLaunchableType {
 			switch {
 			case stanza.Location == "" && stanza.Version.ID == "":
@@ -562,5 +577,10 @@ func ValidManifest(m Manifest) error {
 			}
 		}
 	}
+	for i, check := range m.GetStatusStanza().Checks {
+		if err := check.Validate(); err != nil {
+			return fmt.Errorf("status check %d: %s", i, err)
+		}
+	}
 	return nil
 }
@@ -241,6 +241,86 @@ func TestStatusPort(t *testing.T) {
 	}
 }
 
+func TestStatusChecks(t *testing.T) {
+	config := `
+id: thepod
+status:
+  checks:
+  - type: http
+    port: 8080
+    path: health
+    expected_status: 204
+    body_regex: "^ok$"
+    interval: 10
+    timeout: 2
+    failure_threshold: 3
+  - type: runit
+`
+	manifest, err := FromBytes([]byte(config))
+	Assert(t).IsNil(err, "should not have erred when building manifest")
+
+	checks := manifest.GetStatusStanza().Checks
+	Assert(t).AreEqual(2, len(checks), "should have read both checks")
+	Assert(t).AreEqual(launch.HTTPHealthCheck, checks[0].Type, "should have read the check type")
+	Assert(t).AreEqual("/health", checks[0].GetPath(), "should have read the check path")
+	Assert(t).AreEqual(10*time.Second, checks[0].GetInterval(), "should have read the check interval")
+	Assert(t).AreEqual(2*time.Second, checks[0].GetTimeout(), "should have read the check timeout")
+	Assert(t).AreEqual(3, checks[0].FailureThreshold, "should have read the check failure threshold")
+	Assert(t).AreEqual(launch.RunitHealthCheck, checks[1].Type, "should have read the check type")
+
+	invalid := []string{
+		`{ id: thepod, status: { checks: [ { type: tcp } ] } }`,
+		`{ id: thepod, status: { checks: [ { type: http, port: 80, body_regex: "(" } ] } }`,
+		`{ id: thepod, status: { checks: [ { type: exec } ] } }`,
+		`{ id: thepod, status: { checks: [ { type: runit, interval: -1 } ] } }`,
+		`{ id: thepod, status: { checks: [ { type: ping } ] } }`,
+	}
+	for _, config := range invalid {
+		_, err := FromBytes([]byte(config))
+		Assert(t).IsNotNil(err, "should have rejected an invalid check: "+config)
+	}
+}
+
+func TestLaunchableStatusChecks(t *testing.T) {
+	config := `
+id: thepod
+launchables:
+  web:
+    launchable_type: hoist
+    location: https://localhost:4444/foo/bar/baz.tar.gz
+    status:
+      checks:
+      - type: tcp
+        port: 8080
+      liveness:
+      - type: runit
+        failure_threshold: 3
+        initial_delay: 30
+  worker:
+    launchable_type: hoist
+    location: https://localhost:4444/foo/bar/baz.tar.gz
+`
+	manifest, err := FromBytes([]byte(config))
+	Assert(t).IsNil(err, "should not have erred when building manifest")
+
+	stanzas := manifest.GetLaunchableStanzas()
+	Assert(t).AreEqual(1, len(stanzas["web"].Status.Checks), "should have read the launchable's check")
+	Assert(t).AreEqual(launch.TCPHealthCheck, stanzas["web"].Status.Checks[0].Type, "should have read the check type")
+	Assert(t).AreEqual(1, len(stanzas["web"].Status.Liveness), "should have read the launchable's liveness check")
+	Assert(t).AreEqual(3, stanzas["web"].Status.Liveness[0].FailureThreshold, "should have read the liveness check failure threshold")
+	Assert(t).AreEqual(30*time.Second, stanzas["web"].Status.Liveness[0].GetInitialDelay(), "should have read the liveness check initial delay")
+	Assert(t).AreEqual(0, len(stanzas["worker"].Status.Checks), "launchable without checks should have none")
+
+	_, err = FromBytes([]byte(`{ id: thepod, launchables: { web: { launchable_type: hoist, location: "https://localhost/foo.tar.gz", status: { checks: [ { type: tcp } ] } } } }`))
+	Assert(t).IsNotNil(err, "should have rejected an invalid launchable check")
+
+	_, err = FromBytes([]byte(`{ id: thepod, launchables: { web: { launchable_type: hoist, location: "https://localhost/foo.tar.gz", status: { liveness: [ { type: exec } ] } } } }`))
+	Assert(t).IsNotNil(err, "should have rejected an invalid liveness check")
+
+	_, err = FromBytes([]byte(`{ id: thepod, launchables: { web: { launchable_type: hoist, location: "https://localhost/foo.tar.gz", status: { liveness: [ { type: runit, initial_delay: -1 } ] } } } }`))
+	Assert(t).IsNotNil(err, "should have rejected a negative initial delay")
+}
+
 func TestRunAs(t *testing.T) {
 	config := testPod()
 	manifest, err := FromBytes([]byte(config))
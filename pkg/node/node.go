@@ -0,0 +1,449 @@
+// Package node takes nodes out of service and puts them back into service.
+//
+// A cordoned node carries the types.CordonedLabel node label, which makes the
+// schedulers consider it ineligible for every replication controller and
+// daemon set regardless of their node selectors. Replication controllers using
+// the dynamic allocation strategy transfer their pods off of cordoned nodes as
+// their min health allows. Daemon sets don't schedule new pods on cordoned
+// nodes but leave their existing pods there.
+//
+// Draining a node cordons it and then asks every replication controller with
+// a pod on the node to transfer it, by adding the node to the controller's
+// transfer nodes, and waits for the pods to be transferred.
+//
+// Decommissioning a drained node whose preparer has stopped cordons it and
+// deletes its inventory record, so that it is no longer watched for failures.
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/square/p2/pkg/audit"
+	"github.com/square/p2/pkg/labels"
+	"github.com/square/p2/pkg/logging"
+	"github.com/square/p2/pkg/rc"
+	"github.com/square/p2/pkg/rc/fields"
+	"github.com/square/p2/pkg/store/consul"
+	"github.com/square/p2/pkg/store/consul/nodestore"
+	"github.com/square/p2/pkg/store/consul/rcstore"
+	"github.com/square/p2/pkg/store/consul/transaction"
+	"github.com/square/p2/pkg/types"
+	"github.com/square/p2/pkg/util"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultDrainPollInterval is how often a drain checks for the pods left on
+// the node
+const DefaultDrainPollInterval = 15 * time.Second
+
+// maxTransferRequestAttempts is how many times adding or removing a transfer
+// request is attempted when the RC is changed concurrently
+const maxTransferRequestAttempts = 5
+
+type Labeler interface {
+	GetLabels(labelType labels.Type, id string) (labels.Labeled, error)
+	SetLabelsTxn(ctx context.Context, labelType labels.Type, id string, labels map[string]string) error
+	RemoveLabelsTxn(ctx context.Context, labelType labels.Type, id string, keysToRemove []string) error
+}
+
+type PodStore interface {
+	ListPods(podPrefix consul.PodPrefix, nodeName types.NodeName) ([]consul.ManifestResult, time.Duration, error)
+}
+
+type RCStore interface {
+	Get(id fields.ID) (fields.RC, error)
+	MutateRC(id fields.ID, mutator func(fields.RC) (fields.RC, error)) error
+}
+
+type NodeRecordStore interface {
+	Get(node types.NodeName) (nodestore.Record, error)
+	DeleteTxn(ctx context.Context, node types.NodeName) error
+}
+
+type AuditLogStore interface {
+	Create(
+		ctx context.Context,
+		eventType audit.EventType,
+		eventDetails json.RawMessage,
+	) error
+}
+
+// Manager cordons, uncordons, drains and decommissions nodes, recording an
+// audit log for each action
+type Manager struct {
+	labeler       Labeler
+	podStore      PodStore
+	rcStore       RCStore
+	nodeRecords   NodeRecordStore
+	auditLogStore AuditLogStore
+	txner         transaction.Txner
+	logger        logging.Logger
+
+	// user is recorded in the audit logs as having taken each action
+	user string
+
+	pollInterval time.Duration
+}
+
+func NewManager(
+	labeler Labeler,
+	podStore PodStore,
+	rcStore RCStore,
+	nodeRecords NodeRecordStore,
+	auditLogStore AuditLogStore,
+	txner transaction.Txner,
+	logger logging.Logger,
+	user string,
+) *Manager {
+	return &Manager{
+		labeler:       labeler,
+		podStore:      podStore,
+		rcStore:       rcStore,
+		nodeRecords:   nodeRecords,
+		auditLogStore: auditLogStore,
+		txner:         txner,
+		logger:        logger,
+		user:          user,
+		pollInterval:  DefaultDrainPollInterval,
+	}
+}
+
+// IsCordoned returns whether the node has been cordoned
+func (m *Manager) IsCordoned(node types.NodeName) (bool, error) {
+	labeled, err := m.labeler.GetLabels(labels.NODE, node.String())
+	if err != nil {
+		return false, err
+	}
+	return labeled.Labels.Has(types.CordonedLabel), nil
+}
+
+// Cordon takes the node out of service. The label and the audit log are
+// written in the same transaction
+func (m *Manager) Cordon(node types.NodeName, reason string) error {
+	ctx, cancel := transaction.New(context.Background())
+	defer cancel()
+
+	err := m.labeler.SetLabelsTxn(ctx, labels.NODE, node.String(), map[string]string{
+		types.CordonedLabel: "true",
+	})
+	if err != nil {
+		return err
+	}
+
+	err = m.auditTxn(ctx, audit.NodeCordonedEvent, node, reason, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	err = transaction.MustCommit(ctx, m.txner)
+	if err != nil {
+		return util.Errorf("could not cordon %s: %s", node, err)
+	}
+	m.logger.WithFields(logrus.Fields{"node": node, "reason": reason}).Infoln("Cordoned node")
+	return nil
+}
+
+// Uncordon puts a cordoned node back into service. Pods that were transferred
+// off of the node while it was cordoned are not moved back
+func (m *Manager) Uncordon(node types.NodeName, reason string) error {
+	ctx, cancel := transaction.New(context.Background())
+	defer cancel()
+
+	err := m.labeler.RemoveLabelsTxn(ctx, labels.NODE, node.String(), []string{types.CordonedLabel})
+	if err != nil {
+		return err
+	}
+
+	err = m.auditTxn(ctx, audit.NodeUncordonedEvent, node, reason, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	err = transaction.MustCommit(ctx, m.txner)
+	if err != nil {
+		return util.Errorf("could not uncordon %s: %s", node, err)
+	}
+	m.logger.WithFields(logrus.Fields{"node": node, "reason": reason}).Infoln("Uncordoned node")
+	return nil
+}
+
+// Drain cordons the node and then waits for the replication controllers with
+// pods on it to transfer them to other nodes. The node is added to the
+// transfer nodes of each replication controller, which makes it retry the
+// transfer until its pod is gone. A transfer only happens when the
+// controller's node transfer conditions such as min health are met, so a
+// drain may take a while. It returns when no transferable pods are left or
+// when ctx is done, in which case an error is returned. Either way the node is
+// removed from the transfer nodes again.
+//
+// Only replication controllers using the dynamic allocation strategy transfer
+// pods. Pods of other replication controllers and pods that are not managed
+// by a replication controller are left alone. The pods still scheduled on the
+// node when the drain finishes are returned either way.
+func (m *Manager) Drain(ctx context.Context, node types.NodeName, reason string) ([]types.PodID, error) {
+	logger := m.logger.SubLogger(logrus.Fields{"node": node})
+
+	err := m.Cordon(node, reason)
+	if err != nil {
+		return nil, err
+	}
+
+	owners, err := m.podOwners(node)
+	if err != nil {
+		return nil, err
+	}
+
+	transferable := make(map[fields.ID]bool)
+	for podID, rcID := range owners {
+		if rcID == "" {
+			logger.WithField("pod", podID).Warnln("Pod is not managed by a replication controller and will not be drained")
+			continue
+		}
+		if _, ok := transferable[rcID]; ok {
+			continue
+		}
+		rcFields, err := m.rcStore.Get(rcID)
+		if err != nil {
+			return nil, util.Errorf("could not get replication controller %s: %s", rcID, err)
+		}
+		transferable[rcID] = rcFields.AllocationStrategy == fields.DynamicStrategy
+		if !transferable[rcID] {
+			logger.WithFields(logrus.Fields{"pod": podID, "rc": rcID}).
+				Warnf("Replication controller uses the %q allocation strategy and will not transfer its pod", rcFields.AllocationStrategy)
+		}
+	}
+
+	var rcIDs []fields.ID
+	for rcID, ok := range transferable {
+		if ok {
+			rcIDs = append(rcIDs, rcID)
+		}
+	}
+	sort.Slice(rcIDs, func(i, j int) bool { return rcIDs[i] < rcIDs[j] })
+	err = m.audit(audit.NodeDrainStartEvent, node, reason, rcIDs, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		for _, rcID := range rcIDs {
+			m.withdrawTransfer(logger, rcID, node)
+		}
+	}()
+	for _, rcID := range rcIDs {
+		err = m.requestTransfer(rcID, node)
+		switch {
+		case err == rcstore.NoReplicationController:
+			logger.WithField("rc", rcID).Warnln("Replication controller was deleted during drain")
+			transferable[rcID] = false
+		case err != nil:
+			return nil, util.Errorf("could not ask replication controller %s to transfer its pod: %s", rcID, err)
+		}
+	}
+
+	drainErr := m.waitForTransfers(ctx, logger, node, transferable)
+
+	owners, err = m.podOwners(node)
+	if err != nil {
+		return nil, err
+	}
+	remaining := make([]types.PodID, 0, len(owners))
+	for podID := range owners {
+		remaining = append(remaining, podID)
+	}
+	sort.Slice(remaining, func(i, j int) bool { return remaining[i] < remaining[j] })
+
+	err = m.audit(audit.NodeDrainCompletionEvent, node, reason, rcIDs, remaining)
+	if err != nil {
+		return remaining, err
+	}
+	return remaining, drainErr
+}
+
+// Decommission takes the node out of service for good. The node is cordoned
+// and its inventory record is deleted in the same transaction as the audit
+// log, so the node failure monitor stops counting it as a failed node. The
+// node must have no pods scheduled on it and its preparer must have stopped
+// heartbeating, otherwise the preparer would publish the record again.
+func (m *Manager) Decommission(node types.NodeName, reason string) error {
+	results, _, err := m.podStore.ListPods(consul.INTENT_TREE, node)
+	if err != nil {
+		return err
+	}
+	if len(results) > 0 {
+		return util.Errorf("%s still has %d pods scheduled on it, drain it and remove them first", node, len(results))
+	}
+
+	record, err := m.nodeRecords.Get(node)
+	switch {
+	case err == nodestore.NoRecord:
+	case err != nil:
+		return err
+	case record.Alive:
+		return util.Errorf("the preparer on %s is still heartbeating, stop it first", node)
+	}
+
+	ctx, cancel := transaction.New(context.Background())
+	defer cancel()
+
+	err = m.labeler.SetLabelsTxn(ctx, labels.NODE, node.String(), map[string]string{
+		types.CordonedLabel: "true",
+	})
+	if err != nil {
+		return err
+	}
+
+	err = m.nodeRecords.DeleteTxn(ctx, node)
+	if err != nil {
+		return err
+	}
+
+	err = m.auditTxn(ctx, audit.NodeDecommissionedEvent, node, reason, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	err = transaction.MustCommit(ctx, m.txner)
+	if err != nil {
+		return util.Errorf("could not decommission %s: %s", node, err)
+	}
+	m.logger.WithFields(logrus.Fields{"node": node, "reason": reason}).Infoln("Decommissioned node")
+	return nil
+}
+
+func (m *Manager) waitForTransfers(ctx context.Context, logger logging.Logger, node types.NodeName, transferable map[fields.ID]bool) error {
+	for {
+		owners, err := m.podOwners(node)
+		if err != nil {
+			return err
+		}
+
+		waiting := 0
+		for podID, rcID := range owners {
+			if !transferable[rcID] {
+				continue
+			}
+
+			_, err = m.rcStore.Get(rcID)
+			switch {
+			case err == rcstore.NoReplicationController:
+				logger.WithFields(logrus.Fields{"pod": podID, "rc": rcID}).Warnln("Replication controller was deleted during drain")
+				transferable[rcID] = false
+				continue
+			case err != nil:
+				logger.WithErrorAndFields(err, logrus.Fields{"rc": rcID}).Warnln("Could not get replication controller")
+			}
+			waiting++
+		}
+		if waiting == 0 {
+			return nil
+		}
+		logger.Infof("Waiting for %d pods to be transferred", waiting)
+
+		select {
+		case <-ctx.Done():
+			return util.Errorf("%d pods were not transferred off of %s: %s", waiting, node, ctx.Err())
+		case <-time.After(m.pollInterval):
+		}
+	}
+}
+
+// requestTransfer adds the node to the RC's transfer nodes
+func (m *Manager) requestTransfer(rcID fields.ID, node types.NodeName) error {
+	return m.mutateTransferNodes(rcID, func(nodes types.NodeSet) {
+		nodes.InsertNode(node)
+	})
+}
+
+// withdrawTransfer removes the node from the RC's transfer nodes. Failures
+// are only logged, since the RC is not harmed by a node it has no pods on
+// staying in its transfer nodes
+func (m *Manager) withdrawTransfer(logger logging.Logger, rcID fields.ID, node types.NodeName) {
+	err := m.mutateTransferNodes(rcID, func(nodes types.NodeSet) {
+		nodes.DeleteNode(node)
+	})
+	if err != nil && err != rcstore.NoReplicationController {
+		logger.WithErrorAndFields(err, logrus.Fields{"rc": rcID}).Errorln("Could not remove node from the replication controller's transfer nodes")
+	}
+}
+
+func (m *Manager) mutateTransferNodes(rcID fields.ID, mutate func(types.NodeSet)) error {
+	var err error
+	for i := 0; i < maxTransferRequestAttempts; i++ {
+		err = m.rcStore.MutateRC(rcID, func(rcFields fields.RC) (fields.RC, error) {
+			nodes := types.NewNodeSet(rcFields.TransferNodes...)
+			mutate(nodes)
+			rcFields.TransferNodes = nodes.ListNodes()
+			if len(rcFields.TransferNodes) == 0 {
+				rcFields.TransferNodes = nil
+			}
+			return rcFields, nil
+		})
+		if _, ok := err.(rcstore.CASError); !ok {
+			return err
+		}
+	}
+	return err
+}
+
+// podOwners returns the pods scheduled on the node, mapped to the IDs of the
+// replication controllers that manage them. Pods not managed by a replication
+// controller map to the empty ID
+func (m *Manager) podOwners(node types.NodeName) (map[types.PodID]fields.ID, error) {
+	results, _, err := m.podStore.ListPods(consul.INTENT_TREE, node)
+	if err != nil {
+		return nil, err
+	}
+
+	owners := make(map[types.PodID]fields.ID, len(results))
+	for _, result := range results {
+		podID := result.Manifest.ID()
+		labeled, err := m.labeler.GetLabels(labels.POD, labels.MakePodLabelKey(node, podID))
+		if err != nil {
+			return nil, err
+		}
+		owners[podID] = fields.ID(labeled.Labels.Get(rc.RCIDLabel))
+	}
+	return owners, nil
+}
+
+func (m *Manager) audit(
+	eventType audit.EventType,
+	node types.NodeName,
+	reason string,
+	rcIDs []fields.ID,
+	remainingPods []types.PodID,
+) error {
+	ctx, cancel := transaction.New(context.Background())
+	defer cancel()
+
+	err := m.auditTxn(ctx, eventType, node, reason, rcIDs, remainingPods)
+	if err != nil {
+		return err
+	}
+
+	err = transaction.MustCommit(ctx, m.txner)
+	if err != nil {
+		return util.Errorf("could not record %s audit log for %s: %s", eventType, node, err)
+	}
+	return nil
+}
+
+func (m *Manager) auditTxn(
+	ctx context.Context,
+	eventType audit.EventType,
+	node types.NodeName,
+	reason string,
+	rcIDs []fields.ID,
+	remainingPods []types.PodID,
+) error {
+	details, err := audit.NewNodeEventDetails(node, m.user, reason, rcIDs, remainingPods)
+	if err != nil {
+		return err
+	}
+	return m.auditLogStore.Create(ctx, eventType, details)
+}
@@ -0,0 +1,292 @@
+// +build !race
+
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/square/p2/pkg/audit"
+	"github.com/square/p2/pkg/labels"
+	"github.com/square/p2/pkg/logging"
+	"github.com/square/p2/pkg/manifest"
+	"github.com/square/p2/pkg/rc"
+	"github.com/square/p2/pkg/rc/fields"
+	"github.com/square/p2/pkg/store/consul"
+	"github.com/square/p2/pkg/store/consul/auditlogstore"
+	"github.com/square/p2/pkg/store/consul/consulutil"
+	"github.com/square/p2/pkg/store/consul/nodestore"
+	"github.com/square/p2/pkg/store/consul/rcstore"
+	"github.com/square/p2/pkg/types"
+)
+
+const testNode = types.NodeName("node1.example.com")
+
+type podDeleter interface {
+	DeletePod(podPrefix consul.PodPrefix, nodeName types.NodeName, podID types.PodID) (time.Duration, error)
+}
+
+// transferringRCStore stands in for the RC farm: an RC that is asked to
+// transfer its pod off of testNode moves it off, like a node transfer would
+type transferringRCStore struct {
+	t           *testing.T
+	rcs         map[fields.ID]fields.RC
+	consulStore podDeleter
+	applicator  *labels.ConsulApplicator
+	// requested records the RCs that were asked to transfer off of testNode
+	requested map[fields.ID]bool
+}
+
+func (s *transferringRCStore) Get(id fields.ID) (fields.RC, error) {
+	rcFields, ok := s.rcs[id]
+	if !ok {
+		return fields.RC{}, rcstore.NoReplicationController
+	}
+	return rcFields, nil
+}
+
+func (s *transferringRCStore) MutateRC(id fields.ID, mutator func(fields.RC) (fields.RC, error)) error {
+	rcFields, err := s.Get(id)
+	if err != nil {
+		return err
+	}
+	rcFields, err = mutator(rcFields)
+	if err != nil {
+		return err
+	}
+	s.rcs[id] = rcFields
+
+	if !types.NewNodeSet(rcFields.TransferNodes...).Has(testNode.String()) {
+		return nil
+	}
+	s.requested[id] = true
+	podID := rcFields.Manifest.ID()
+	_, err = s.consulStore.DeletePod(consul.INTENT_TREE, testNode, podID)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	err = s.applicator.RemoveAllLabels(labels.POD, labels.MakePodLabelKey(testNode, podID))
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return nil
+}
+
+func testManifest(podID types.PodID) manifest.Manifest {
+	builder := manifest.NewBuilder()
+	builder.SetID(podID)
+	return builder.GetManifest()
+}
+
+func nodeEvents(t *testing.T, store auditlogstore.ConsulStore) map[audit.EventType][]audit.NodeEventDetails {
+	records, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ret := make(map[audit.EventType][]audit.NodeEventDetails)
+	for _, record := range records {
+		var details audit.NodeEventDetails
+		err = json.Unmarshal(*record.EventDetails, &details)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ret[record.EventType] = append(ret[record.EventType], details)
+	}
+	return ret
+}
+
+func TestCordonAndUncordon(t *testing.T) {
+	fixture := consulutil.NewFixture(t)
+	defer fixture.Stop()
+
+	applicator := labels.NewConsulApplicator(fixture.Client, 0, 0)
+	auditLogStore := auditlogstore.NewConsulStore(fixture.Client.KV())
+	manager := NewManager(
+		applicator,
+		consul.NewConsulStore(fixture.Client),
+		&transferringRCStore{},
+		nodestore.NewConsul(fixture.Client),
+		auditLogStore,
+		fixture.Client.KV(),
+		logging.TestLogger(),
+		"some_user",
+	)
+
+	err := manager.Cordon(testNode, "bad disk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cordoned, err := manager.IsCordoned(testNode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cordoned {
+		t.Error("expected node to be cordoned")
+	}
+
+	err = manager.Uncordon(testNode, "disk replaced")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cordoned, err = manager.IsCordoned(testNode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cordoned {
+		t.Error("expected node to be uncordoned")
+	}
+
+	events := nodeEvents(t, auditLogStore)
+	if len(events[audit.NodeCordonedEvent]) != 1 || events[audit.NodeCordonedEvent][0].Reason != "bad disk" {
+		t.Errorf("expected one cordon audit log with the reason, got %+v", events[audit.NodeCordonedEvent])
+	}
+	if len(events[audit.NodeUncordonedEvent]) != 1 || events[audit.NodeUncordonedEvent][0].User != "some_user" {
+		t.Errorf("expected one uncordon audit log with the user, got %+v", events[audit.NodeUncordonedEvent])
+	}
+}
+
+func TestDrain(t *testing.T) {
+	fixture := consulutil.NewFixture(t)
+	defer fixture.Stop()
+
+	applicator := labels.NewConsulApplicator(fixture.Client, 0, 0)
+	consulStore := consul.NewConsulStore(fixture.Client)
+	auditLogStore := auditlogstore.NewConsulStore(fixture.Client.KV())
+	rcStore := &transferringRCStore{
+		t: t,
+		rcs: map[fields.ID]fields.RC{
+			"dynamic_rc": {ID: "dynamic_rc", Manifest: testManifest("web"), AllocationStrategy: fields.DynamicStrategy},
+			"static_rc":  {ID: "static_rc", Manifest: testManifest("db"), AllocationStrategy: fields.StaticStrategy},
+		},
+		consulStore: consulStore,
+		applicator:  applicator,
+		requested:   make(map[fields.ID]bool),
+	}
+
+	for _, podID := range []types.PodID{"web", "db", "agent"} {
+		_, err := consulStore.SetPod(consul.INTENT_TREE, testNode, testManifest(podID))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for rcID, rcFields := range rcStore.rcs {
+		err := applicator.SetLabel(labels.POD, labels.MakePodLabelKey(testNode, rcFields.Manifest.ID()), rc.RCIDLabel, rcID.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	manager := NewManager(applicator, consulStore, rcStore, nodestore.NewConsul(fixture.Client), auditLogStore, fixture.Client.KV(), logging.TestLogger(), "some_user")
+	manager.pollInterval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	remaining, err := manager.Drain(ctx, testNode, "decommission")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []types.PodID{"agent", "db"}
+	if !reflect.DeepEqual(remaining, expected) {
+		t.Errorf("expected %s to remain on the node but %s did", expected, remaining)
+	}
+	if !rcStore.requested["dynamic_rc"] {
+		t.Error("expected the dynamic RC to be asked to transfer its pod")
+	}
+	if rcStore.requested["static_rc"] {
+		t.Error("expected the static RC not to be asked to transfer its pod")
+	}
+	for rcID, rcFields := range rcStore.rcs {
+		if len(rcFields.TransferNodes) != 0 {
+			t.Errorf("expected the transfer request of %s to be removed after the drain but it has transfer nodes %s", rcID, rcFields.TransferNodes)
+		}
+	}
+
+	cordoned, err := manager.IsCordoned(testNode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cordoned {
+		t.Error("expected a drained node to be cordoned")
+	}
+
+	events := nodeEvents(t, auditLogStore)
+	for _, eventType := range []audit.EventType{audit.NodeCordonedEvent, audit.NodeDrainStartEvent, audit.NodeDrainCompletionEvent} {
+		if len(events[eventType]) != 1 {
+			t.Errorf("expected one %s audit log but there were %d", eventType, len(events[eventType]))
+		}
+	}
+	if start := events[audit.NodeDrainStartEvent]; len(start) == 1 && !reflect.DeepEqual(start[0].ReplicationControllers, []fields.ID{"dynamic_rc"}) {
+		t.Errorf("expected the drain start audit log to list the dynamic RC but it listed %s", start[0].ReplicationControllers)
+	}
+	if completion := events[audit.NodeDrainCompletionEvent]; len(completion) == 1 && !reflect.DeepEqual(completion[0].RemainingPods, expected) {
+		t.Errorf("expected the drain completion audit log to list %s but it listed %s", expected, completion[0].RemainingPods)
+	}
+}
+
+func TestDecommission(t *testing.T) {
+	fixture := consulutil.NewFixture(t)
+	defer fixture.Stop()
+
+	applicator := labels.NewConsulApplicator(fixture.Client, 0, 0)
+	consulStore := consul.NewConsulStore(fixture.Client)
+	nodeRecords := nodestore.NewConsul(fixture.Client)
+	auditLogStore := auditlogstore.NewConsulStore(fixture.Client.KV())
+	manager := NewManager(applicator, consulStore, &transferringRCStore{}, nodeRecords, auditLogStore, fixture.Client.KV(), logging.TestLogger(), "some_user")
+
+	session, _, err := fixture.Client.Session().CreateNoChecks(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = nodeRecords.Publish(session, nodestore.Inventory{Node: testNode, UpdatedAt: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = consulStore.SetPod(consul.INTENT_TREE, testNode, testManifest("web"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = manager.Decommission(testNode, "retired")
+	if err == nil {
+		t.Fatal("expected a node with pods scheduled on it not to be decommissioned")
+	}
+
+	_, err = consulStore.DeletePod(consul.INTENT_TREE, testNode, "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = manager.Decommission(testNode, "retired")
+	if err == nil {
+		t.Fatal("expected a node whose preparer is heartbeating not to be decommissioned")
+	}
+
+	_, err = fixture.Client.Session().Destroy(session, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = manager.Decommission(testNode, "retired")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = nodeRecords.Get(testNode)
+	if err != nodestore.NoRecord {
+		t.Errorf("expected the inventory record to be deleted but got %v", err)
+	}
+	cordoned, err := manager.IsCordoned(testNode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cordoned {
+		t.Error("expected a decommissioned node to be cordoned")
+	}
+	events := nodeEvents(t, auditLogStore)
+	if len(events[audit.NodeDecommissionedEvent]) != 1 || events[audit.NodeDecommissionedEvent][0].Reason != "retired" {
+		t.Errorf("expected one decommission audit log with the reason, got %+v", events[audit.NodeDecommissionedEvent])
+	}
+}
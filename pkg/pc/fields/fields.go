@@ -4,10 +4,11 @@ import (
 	"encoding/json"
 	"reflect"
 
+	"github.com/square/p2/pkg/labels"
 	rc_fields "github.com/square/p2/pkg/rc/fields"
 	"github.com/square/p2/pkg/types"
 
-	"k8s.io/kubernetes/pkg/labels"
+	klabels "k8s.io/kubernetes/pkg/labels"
 )
 
 // Types stored in the actual pod cluster document
@@ -53,7 +54,7 @@ type PodCluster struct {
 	Name ClusterName
 
 	// Selector to identify the pods that are members of this pod cluster
-	PodSelector labels.Selector
+	PodSelector klabels.Selector
 
 	// AllocationStrategy tweaks certain characteristic about how pods
 	// within this cluster are managed. For example the "static" strategy will
@@ -150,7 +151,7 @@ func (pc *PodCluster) UnmarshalJSON(b []byte) error {
 		return err
 	}
 
-	podSel, err := labels.Parse(rawPC.PodSelector)
+	podSel, err := labels.ParseSelector(rawPC.PodSelector)
 	if err != nil {
 		return err
 	}
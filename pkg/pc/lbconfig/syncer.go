@@ -0,0 +1,376 @@
+// Package lbconfig implements a pcstore.ConcreteSyncer that renders the pods
+// of every pod cluster into a local load balancer or service discovery
+// config file, such as HAProxy backends or an Envoy EDS file.
+package lbconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/square/p2/pkg/health"
+	"github.com/square/p2/pkg/labels"
+	"github.com/square/p2/pkg/logging"
+	"github.com/square/p2/pkg/manifest"
+	"github.com/square/p2/pkg/pc/fields"
+	"github.com/square/p2/pkg/pods"
+	"github.com/square/p2/pkg/store/consul"
+	"github.com/square/p2/pkg/store/consul/pcstore"
+	"github.com/square/p2/pkg/types"
+	"github.com/square/p2/pkg/util"
+)
+
+const SyncerType pcstore.ConcreteSyncerType = "lb_config"
+
+// Endpoint is a pod of a pod cluster that can receive traffic
+type Endpoint struct {
+	Node  types.NodeName
+	PodID types.PodID
+
+	// Port is the status port from the pod's manifest
+	Port int
+
+	Labels map[string]string
+
+	// Health is the pod's health, or empty if health isn't being synced
+	// or there is no health result for the pod. Pods are healthy if they
+	// are passing or if their health is empty, so that pods without health
+	// checks receive traffic and a restarted syncer doesn't disable every
+	// pod until health results arrive
+	Health  health.HealthState
+	Healthy bool
+}
+
+// Cluster holds a pod cluster and its endpoints, sorted by node
+type Cluster struct {
+	ID               fields.ID
+	PodID            types.PodID
+	AvailabilityZone fields.AvailabilityZone
+	Name             fields.ClusterName
+	Annotations      fields.Annotations
+
+	// Key is a name for the cluster that is unique among pod clusters
+	// and safe to use as a load balancer backend or cluster name
+	Key string
+
+	Endpoints []Endpoint
+}
+
+// Config is passed to the template. Clusters are sorted by key
+type Config struct {
+	Clusters []Cluster
+}
+
+// ManifestStore is used to read the manifests of labeled pods to find their
+// ports
+type ManifestStore interface {
+	Pod(podPrefix consul.PodPrefix, nodename types.NodeName, podId types.PodID) (manifest.Manifest, time.Duration, error)
+}
+
+// ClusterLister lists the pod clusters that exist when the syncer starts
+type ClusterLister interface {
+	List() ([]fields.PodCluster, error)
+}
+
+// DefaultInitialSyncTimeout is how long a Syncer waits for every pod cluster
+// to be synced before writing its first config anyway
+const DefaultInitialSyncTimeout = 5 * time.Minute
+
+// Syncer renders the pod clusters it is told about with a template. The
+// config file is written whenever the rendered config changes, and the
+// reload command is then run so the load balancer picks up the new config.
+//
+// When it starts, the Syncer doesn't write anything until every pod cluster
+// that existed at the time has been synced or deleted, or until
+// initialSyncTimeout passes. Otherwise the first config would only contain
+// the first cluster synced, and the load balancer would drop the backends of
+// every other cluster until they were synced too.
+type Syncer struct {
+	manifests          ManifestStore
+	clusterLister      ClusterLister
+	template           *template.Template
+	outputPath         string
+	reloadCommand      []string
+	initialSyncTimeout time.Duration
+	logger             logging.Logger
+
+	// mu protects clusters, unsynced and lastRendered, since SyncCluster
+	// and DeleteCluster are called concurrently
+	mu       sync.Mutex
+	clusters map[fields.ID]Cluster
+	// unsynced holds the clusters that existed at startup and haven't
+	// been synced or deleted yet. Nothing is written while it is non-empty
+	unsynced     map[fields.ID]struct{}
+	lastRendered []byte
+}
+
+var _ pcstore.HealthAwareSyncer = &Syncer{}
+
+// NewSyncer returns a Syncer that renders tmpl to outputPath. reloadCommand
+// may be empty if nothing needs to be run after the config changes
+func NewSyncer(
+	manifests ManifestStore,
+	clusterLister ClusterLister,
+	tmpl *template.Template,
+	outputPath string,
+	reloadCommand []string,
+	logger logging.Logger,
+) *Syncer {
+	return &Syncer{
+		manifests:          manifests,
+		clusterLister:      clusterLister,
+		template:           tmpl,
+		outputPath:         outputPath,
+		reloadCommand:      reloadCommand,
+		initialSyncTimeout: DefaultInitialSyncTimeout,
+		logger:             logger,
+		clusters:           make(map[fields.ID]Cluster),
+		unsynced:           make(map[fields.ID]struct{}),
+	}
+}
+
+// ParseTemplate parses a config template, adding the functions that the
+// built-in templates use
+func ParseTemplate(name string, text string) (*template.Template, error) {
+	return template.New(name).Funcs(template.FuncMap{
+		"json": toJSON,
+	}).Parse(text)
+}
+
+func toJSON(v interface{}) (string, error) {
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(bytes), nil
+}
+
+func (s *Syncer) SyncCluster(pc *fields.PodCluster, pods []labels.Labeled) error {
+	endpoints := make([]pcstore.Endpoint, 0, len(pods))
+	for _, pod := range pods {
+		endpoints = append(endpoints, pcstore.Endpoint{Labeled: pod})
+	}
+	return s.SyncClusterWithHealth(pc, endpoints)
+}
+
+func (s *Syncer) SyncClusterWithHealth(pc *fields.PodCluster, endpoints []pcstore.Endpoint) error {
+	cluster := Cluster{
+		ID:               pc.ID,
+		PodID:            pc.PodID,
+		AvailabilityZone: pc.AvailabilityZone,
+		Name:             pc.Name,
+		Annotations:      pc.Annotations,
+		Key:              clusterKey(pc),
+	}
+
+	for _, pcEndpoint := range endpoints {
+		endpoint, ok, err := s.endpoint(pcEndpoint.Labeled)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		endpoint.Health = pcEndpoint.Health
+		endpoint.Healthy = pcEndpoint.Health == "" || pcEndpoint.Health == health.Passing
+		cluster.Endpoints = append(cluster.Endpoints, endpoint)
+	}
+	sort.Slice(cluster.Endpoints, func(i, j int) bool {
+		return cluster.Endpoints[i].Node < cluster.Endpoints[j].Node
+	})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clusters[pc.ID] = cluster
+	delete(s.unsynced, pc.ID)
+	return s.renderLocked()
+}
+
+func (s *Syncer) DeleteCluster(id fields.ID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.clusters, id)
+	delete(s.unsynced, id)
+	return s.renderLocked()
+}
+
+// GetInitialClusters returns the pod clusters that currently exist, which
+// the config is not written without. The config file itself is rendered from
+// scratch each time the syncer starts. Clusters that are deleted before the
+// first watch result are passed to DeleteCluster, so they aren't waited for
+func (s *Syncer) GetInitialClusters() ([]fields.ID, error) {
+	if s.clusterLister == nil {
+		return []fields.ID{}, nil
+	}
+	pcs, err := s.clusterLister.List()
+	if err != nil {
+		return nil, util.Errorf("Could not list pod clusters: %s", err)
+	}
+
+	ids := make([]fields.ID, 0, len(pcs))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, pc := range pcs {
+		ids = append(ids, pc.ID)
+		if _, ok := s.clusters[pc.ID]; !ok {
+			s.unsynced[pc.ID] = struct{}{}
+		}
+	}
+	if len(s.unsynced) > 0 {
+		time.AfterFunc(s.initialSyncTimeout, s.stopWaitingForInitialClusters)
+	}
+	return ids, nil
+}
+
+// stopWaitingForInitialClusters writes the config even though some of the
+// clusters that existed at startup haven't been synced, e.g. because their
+// syncs keep failing
+func (s *Syncer) stopWaitingForInitialClusters() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.unsynced) == 0 {
+		return
+	}
+
+	unsynced := make([]fields.ID, 0, len(s.unsynced))
+	for id := range s.unsynced {
+		unsynced = append(unsynced, id)
+	}
+	s.logger.WithField("unsynced", unsynced).Warnf("Writing config before %d pod clusters were synced", len(unsynced))
+	s.unsynced = make(map[fields.ID]struct{})
+	err := s.renderLocked()
+	if err != nil {
+		s.logger.WithError(err).Errorln("Could not write config")
+	}
+}
+
+func (s *Syncer) Type() pcstore.ConcreteSyncerType {
+	return SyncerType
+}
+
+// endpoint returns the endpoint of a labeled pod. Pods that are no longer
+// scheduled or whose manifests have no status port can't receive traffic, so
+// they are skipped. So are pods identified by a pod unique key, whose labels
+// don't say which node they are on
+func (s *Syncer) endpoint(pod labels.Labeled) (Endpoint, bool, error) {
+	node, podID, err := labels.NodeAndPodIDFromPodLabel(pod)
+	if err != nil {
+		s.logger.WithError(err).WithField("pod_label", pod.ID).Warnln("Skipping labeled pod without a node")
+		return Endpoint{}, false, nil
+	}
+
+	podLogger := s.logger.SubLogger(logrus.Fields{
+		"node": node,
+		"pod":  podID,
+	})
+
+	podManifest, _, err := s.manifests.Pod(consul.INTENT_TREE, node, podID)
+	if err == pods.NoCurrentManifest {
+		podLogger.Warnln("Skipping labeled pod that is not scheduled")
+		return Endpoint{}, false, nil
+	}
+	if err != nil {
+		return Endpoint{}, false, util.Errorf("Could not read manifest of %s on %s: %s", podID, node, err)
+	}
+
+	port := podManifest.GetStatusPort()
+	if port == 0 {
+		podLogger.Warnln("Skipping labeled pod without a status port")
+		return Endpoint{}, false, nil
+	}
+
+	return Endpoint{
+		Node:   node,
+		PodID:  podID,
+		Port:   port,
+		Labels: pod.Labels,
+	}, true, nil
+}
+
+// renderLocked renders every cluster and writes the result if it changed.
+// s.mu must be held
+func (s *Syncer) renderLocked() error {
+	if len(s.unsynced) > 0 {
+		s.logger.Debugf("Not writing config until %d more pod clusters are synced", len(s.unsynced))
+		return nil
+	}
+
+	config := Config{
+		Clusters: make([]Cluster, 0, len(s.clusters)),
+	}
+	for _, cluster := range s.clusters {
+		config.Clusters = append(config.Clusters, cluster)
+	}
+	sort.Slice(config.Clusters, func(i, j int) bool {
+		return config.Clusters[i].Key < config.Clusters[j].Key
+	})
+
+	var buf bytes.Buffer
+	err := s.template.Execute(&buf, config)
+	if err != nil {
+		return util.Errorf("Could not render %s: %s", s.outputPath, err)
+	}
+
+	rendered := buf.Bytes()
+	if s.lastRendered != nil && bytes.Equal(rendered, s.lastRendered) {
+		return nil
+	}
+
+	err = writeFileAtomically(s.outputPath, rendered)
+	if err != nil {
+		return err
+	}
+	s.lastRendered = rendered
+	s.logger.WithField("path", s.outputPath).Infof("Wrote config for %d pod clusters", len(config.Clusters))
+
+	if len(s.reloadCommand) == 0 {
+		return nil
+	}
+	output, err := exec.Command(s.reloadCommand[0], s.reloadCommand[1:]...).CombinedOutput()
+	if err != nil {
+		// the config is rendered again with the next change, but
+		// nothing would retry the reload
+		s.lastRendered = nil
+		return util.Errorf("Reload command failed: %s: %s", err, output)
+	}
+	return nil
+}
+
+// writeFileAtomically writes to a temporary file in the same directory and
+// renames it, so that the load balancer never reads a partial config
+func writeFileAtomically(path string, contents []byte) error {
+	tempFile, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return util.Errorf("Could not create temporary file for %s: %s", path, err)
+	}
+	defer os.Remove(tempFile.Name())
+
+	_, err = tempFile.Write(contents)
+	if err != nil {
+		_ = tempFile.Close()
+		return util.Errorf("Could not write %s: %s", tempFile.Name(), err)
+	}
+	err = tempFile.Close()
+	if err != nil {
+		return util.Errorf("Could not write %s: %s", tempFile.Name(), err)
+	}
+
+	err = os.Chmod(tempFile.Name(), 0644)
+	if err != nil {
+		return util.Errorf("Could not change the mode of %s: %s", tempFile.Name(), err)
+	}
+	return os.Rename(tempFile.Name(), path)
+}
+
+func clusterKey(pc *fields.PodCluster) string {
+	return pc.PodID.String() + "_" + pc.AvailabilityZone.String() + "_" + pc.Name.String()
+}
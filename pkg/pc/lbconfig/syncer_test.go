@@ -0,0 +1,340 @@
+package lbconfig
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	klabels "k8s.io/kubernetes/pkg/labels"
+
+	"github.com/square/p2/pkg/health"
+	"github.com/square/p2/pkg/labels"
+	"github.com/square/p2/pkg/logging"
+	"github.com/square/p2/pkg/manifest"
+	"github.com/square/p2/pkg/pc/fields"
+	"github.com/square/p2/pkg/pods"
+	"github.com/square/p2/pkg/store/consul"
+	"github.com/square/p2/pkg/store/consul/pcstore"
+	"github.com/square/p2/pkg/types"
+)
+
+type fakeManifestStore map[string]manifest.Manifest
+
+func (f fakeManifestStore) Pod(podPrefix consul.PodPrefix, node types.NodeName, podID types.PodID) (manifest.Manifest, time.Duration, error) {
+	podManifest, ok := f[labels.MakePodLabelKey(node, podID)]
+	if !ok {
+		return nil, 0, pods.NoCurrentManifest
+	}
+	return podManifest, 0, nil
+}
+
+func testManifest(podID types.PodID, port int) manifest.Manifest {
+	builder := manifest.NewBuilder()
+	builder.SetID(podID)
+	builder.SetStatusPort(port)
+	return builder.GetManifest()
+}
+
+func podLabel(node types.NodeName, podID types.PodID) labels.Labeled {
+	return labels.Labeled{
+		LabelType: labels.POD,
+		ID:        labels.MakePodLabelKey(node, podID),
+		Labels:    klabels.Set{types.PodIDLabel: podID.String()},
+	}
+}
+
+func testCluster(id fields.ID, podID types.PodID) *fields.PodCluster {
+	return &fields.PodCluster{
+		ID:               id,
+		PodID:            podID,
+		AvailabilityZone: "some_az",
+		Name:             "some_cn",
+		PodSelector:      klabels.Everything(),
+	}
+}
+
+func newTestSyncer(t *testing.T, templateText string) (*Syncer, string, func()) {
+	dir, err := ioutil.TempDir("", "lbconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl, err := ParseTemplate("test", templateText)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifests := fakeManifestStore{
+		"node2/web":  testManifest("web", 8080),
+		"node1/web":  testManifest("web", 8080),
+		"node1/api":  testManifest("api", 9090),
+		"node3/nope": testManifest("nope", 0),
+	}
+	outputPath := filepath.Join(dir, "lb.cfg")
+	syncer := NewSyncer(manifests, nil, tmpl, outputPath, nil, logging.TestLogger())
+	return syncer, outputPath, func() { os.RemoveAll(dir) }
+}
+
+func readFile(t *testing.T, path string) string {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(contents)
+}
+
+func TestSyncHAProxy(t *testing.T) {
+	syncer, outputPath, cleanup := newTestSyncer(t, HAProxyTemplate)
+	defer cleanup()
+
+	err := syncer.SyncCluster(testCluster("web_id", "web"), []labels.Labeled{
+		podLabel("node2", "web"),
+		podLabel("node1", "web"),
+		// not scheduled
+		podLabel("node4", "web"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = syncer.SyncCluster(testCluster("api_id", "api"), []labels.Labeled{
+		podLabel("node1", "api"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `# Generated by p2-pc-syncer, do not edit
+
+backend api_some_az_some_cn
+    balance roundrobin
+    server node1 node1:9090 check
+
+backend web_some_az_some_cn
+    balance roundrobin
+    server node1 node1:8080 check
+    server node2 node2:8080 check
+`
+	if rendered := readFile(t, outputPath); rendered != expected {
+		t.Errorf("expected config to be\n%s\nbut was\n%s", expected, rendered)
+	}
+
+	err = syncer.DeleteCluster("api_id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rendered := readFile(t, outputPath); strings.Contains(rendered, "api_some_az_some_cn") {
+		t.Errorf("expected the deleted cluster to be removed from the config but it was\n%s", rendered)
+	}
+}
+
+func TestSyncSkipsPodsLabeledByUniqueKey(t *testing.T) {
+	syncer, outputPath, cleanup := newTestSyncer(t, HAProxyTemplate)
+	defer cleanup()
+
+	err := syncer.SyncCluster(testCluster("web_id", "web"), []labels.Labeled{
+		podLabel("node1", "web"),
+		// labeled by its pod unique key, so it has no node
+		{
+			LabelType: labels.POD,
+			ID:        "0e8e6fc5-6cba-4de0-a1d3-d7f6d8a1f3a4",
+			Labels:    klabels.Set{types.PodIDLabel: "web"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("expected a pod labeled by its unique key not to fail the sync: %s", err)
+	}
+
+	if rendered := readFile(t, outputPath); !strings.Contains(rendered, "server node1 node1:8080 check") {
+		t.Errorf("expected the cluster to be rendered with the pod on node1 but it was\n%s", rendered)
+	}
+}
+
+type fakeClusterLister []fields.ID
+
+func (f fakeClusterLister) List() ([]fields.PodCluster, error) {
+	pcs := make([]fields.PodCluster, 0, len(f))
+	for _, id := range f {
+		pcs = append(pcs, fields.PodCluster{ID: id})
+	}
+	return pcs, nil
+}
+
+func TestWaitForInitialClusters(t *testing.T) {
+	syncer, outputPath, cleanup := newTestSyncer(t, HAProxyTemplate)
+	defer cleanup()
+	syncer.clusterLister = fakeClusterLister{"web_id", "api_id", "deleted_id"}
+
+	initial, err := syncer.GetInitialClusters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(initial) != 3 {
+		t.Errorf("expected every existing cluster to be returned but got %s", initial)
+	}
+
+	err = syncer.SyncCluster(testCluster("web_id", "web"), []labels.Labeled{podLabel("node1", "web")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = syncer.DeleteCluster("deleted_id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(outputPath); !os.IsNotExist(err) {
+		t.Fatalf("expected no config to be written before every cluster was synced but got %v", err)
+	}
+
+	err = syncer.SyncCluster(testCluster("api_id", "api"), []labels.Labeled{podLabel("node1", "api")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rendered := readFile(t, outputPath)
+	if !strings.Contains(rendered, "web_some_az_some_cn") || !strings.Contains(rendered, "api_some_az_some_cn") {
+		t.Errorf("expected the first config to contain every cluster but it was\n%s", rendered)
+	}
+}
+
+func TestInitialSyncTimeout(t *testing.T) {
+	syncer, outputPath, cleanup := newTestSyncer(t, HAProxyTemplate)
+	defer cleanup()
+	syncer.clusterLister = fakeClusterLister{"web_id", "failing_id"}
+	syncer.initialSyncTimeout = 10 * time.Millisecond
+
+	_, err := syncer.GetInitialClusters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = syncer.SyncCluster(testCluster("web_id", "web"), []labels.Labeled{podLabel("node1", "web")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(outputPath); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the config to be written once the initial sync timed out")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if rendered := readFile(t, outputPath); !strings.Contains(rendered, "web_some_az_some_cn") {
+		t.Errorf("expected the synced cluster to be written but the config was\n%s", rendered)
+	}
+}
+
+func TestSyncHAProxyWithHealth(t *testing.T) {
+	syncer, outputPath, cleanup := newTestSyncer(t, HAProxyTemplate)
+	defer cleanup()
+
+	err := syncer.SyncClusterWithHealth(testCluster("web_id", "web"), []pcstore.Endpoint{
+		{Labeled: podLabel("node1", "web"), Node: "node1", Health: health.Passing},
+		{Labeled: podLabel("node2", "web"), Node: "node2", Health: health.Critical},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `# Generated by p2-pc-syncer, do not edit
+
+backend web_some_az_some_cn
+    balance roundrobin
+    server node1 node1:8080 check
+    server node2 node2:8080 check disabled
+`
+	if rendered := readFile(t, outputPath); rendered != expected {
+		t.Errorf("expected config to be\n%s\nbut was\n%s", expected, rendered)
+	}
+}
+
+func TestSyncHAProxyWithoutHealthResults(t *testing.T) {
+	syncer, outputPath, cleanup := newTestSyncer(t, HAProxyTemplate)
+	defer cleanup()
+
+	// node2 has no health result, e.g. because the syncer just started or
+	// the pod has no health checks
+	err := syncer.SyncClusterWithHealth(testCluster("web_id", "web"), []pcstore.Endpoint{
+		{Labeled: podLabel("node1", "web"), Node: "node1", Health: health.Critical},
+		{Labeled: podLabel("node2", "web"), Node: "node2"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `# Generated by p2-pc-syncer, do not edit
+
+backend web_some_az_some_cn
+    balance roundrobin
+    server node1 node1:8080 check disabled
+    server node2 node2:8080 check
+`
+	if rendered := readFile(t, outputPath); rendered != expected {
+		t.Errorf("expected pods without health results to be enabled, config was\n%s", rendered)
+	}
+}
+
+func TestSyncEnvoyEDS(t *testing.T) {
+	syncer, outputPath, cleanup := newTestSyncer(t, EnvoyEDSTemplate)
+	defer cleanup()
+
+	err := syncer.SyncCluster(testCluster("web_id", "web"), []labels.Labeled{
+		podLabel("node1", "web"),
+		podLabel("node2", "web"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// pods without a status port are skipped
+	err = syncer.SyncCluster(testCluster("nope_id", "nope"), []labels.Labeled{
+		podLabel("node3", "nope"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var eds struct {
+		Resources []struct {
+			ClusterName string `json:"cluster_name"`
+			Endpoints   []struct {
+				LBEndpoints []struct {
+					HealthStatus string `json:"health_status"`
+					Endpoint     struct {
+						Address struct {
+							SocketAddress struct {
+								Address   string `json:"address"`
+								PortValue int    `json:"port_value"`
+							} `json:"socket_address"`
+						} `json:"address"`
+					} `json:"endpoint"`
+				} `json:"lb_endpoints"`
+			} `json:"endpoints"`
+		} `json:"resources"`
+	}
+	rendered := readFile(t, outputPath)
+	err = json.Unmarshal([]byte(rendered), &eds)
+	if err != nil {
+		t.Fatalf("expected the EDS config to be valid JSON: %s\n%s", err, rendered)
+	}
+
+	if len(eds.Resources) != 2 {
+		t.Fatalf("expected 2 cluster load assignments but got %d", len(eds.Resources))
+	}
+	nope, web := eds.Resources[0], eds.Resources[1]
+	if nope.ClusterName != "nope_some_az_some_cn" || len(nope.Endpoints[0].LBEndpoints) != 0 {
+		t.Errorf("expected no endpoints for a pod without a status port but got %+v", nope)
+	}
+	if web.ClusterName != "web_some_az_some_cn" || len(web.Endpoints[0].LBEndpoints) != 2 {
+		t.Fatalf("expected 2 endpoints for the web cluster but got %+v", web)
+	}
+	address := web.Endpoints[0].LBEndpoints[1].Endpoint.Address.SocketAddress
+	if address.Address != "node2" || address.PortValue != 8080 {
+		t.Errorf("expected the second endpoint to be node2:8080 but was %s:%d", address.Address, address.PortValue)
+	}
+	if status := web.Endpoints[0].LBEndpoints[1].HealthStatus; status != "HEALTHY" {
+		t.Errorf("expected pods to be healthy when health isn't synced but the status was %q", status)
+	}
+}
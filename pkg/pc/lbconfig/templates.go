@@ -0,0 +1,50 @@
+package lbconfig
+
+// HAProxyTemplate renders a backend for each pod cluster. It is meant to be
+// included in an HAProxy config alongside the frontends that use the
+// backends. Unhealthy pods are disabled
+const HAProxyTemplate = `# Generated by p2-pc-syncer, do not edit
+{{- range .Clusters}}
+
+backend {{.Key}}
+    balance roundrobin
+{{- range .Endpoints}}
+    server {{.Node}} {{.Node}}:{{.Port}} check{{if not .Healthy}} disabled{{end}}
+{{- end}}
+{{- end}}
+`
+
+// EnvoyEDSTemplate renders a ClusterLoadAssignment for each pod cluster in
+// the format of an Envoy file-based EDS discovery response. Envoy clusters
+// must be named after the pod cluster keys. Unhealthy pods are given an
+// UNHEALTHY health status so that Envoy doesn't route to them
+const EnvoyEDSTemplate = `{
+  "resources": [
+{{- range $i, $cluster := .Clusters}}{{if $i}},{{end}}
+    {
+      "@type": "type.googleapis.com/envoy.config.endpoint.v3.ClusterLoadAssignment",
+      "cluster_name": {{json $cluster.Key}},
+      "endpoints": [
+        {
+          "lb_endpoints": [
+{{- range $j, $endpoint := $cluster.Endpoints}}{{if $j}},{{end}}
+            {
+              "health_status": {{if $endpoint.Healthy}}"HEALTHY"{{else}}"UNHEALTHY"{{end}},
+              "endpoint": {
+                "address": {
+                  "socket_address": {
+                    "address": {{json $endpoint.Node}},
+                    "port_value": {{$endpoint.Port}}
+                  }
+                }
+              }
+            }
+{{- end}}
+          ]
+        }
+      ]
+    }
+{{- end}}
+  ]
+}
+`
@@ -0,0 +1,216 @@
+// Package policyfarm runs a worker for each replication controller that has a
+// policy stored in consul, such as an autoscaler policy or a replica schedule.
+// Each worker runs while its farm holds the ownership lock on the policy, so
+// no two farms work on the policy of the same RC.
+package policyfarm
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/square/p2/pkg/logging"
+	rc_fields "github.com/square/p2/pkg/rc/fields"
+	"github.com/square/p2/pkg/store/consul"
+	"github.com/square/p2/pkg/store/consul/consulutil"
+	"github.com/square/p2/pkg/util"
+)
+
+// A subset of the consul.Store interface used by the farm
+type sessionStore interface {
+	NewUnmanagedSession(session, name string) consul.Session
+}
+
+type Locker interface {
+	LockForOwnership(rcID rc_fields.ID, session consul.Session) (consul.Unlocker, error)
+}
+
+// WatchFunc publishes the IDs of the RCs that have a policy each time a policy
+// changes, until quit is closed.
+type WatchFunc func(quit <-chan struct{}) (<-chan []rc_fields.ID, <-chan error)
+
+// Worker carries out the policy of an RC until quit is closed.
+type Worker func(quit <-chan struct{})
+
+// NewWorkerFunc returns the worker for the policy of an RC. The worker should
+// use the session to take any other locks it needs.
+type NewWorkerFunc func(rcID rc_fields.ID, session consul.Session, logger logging.Logger) Worker
+
+// The Farm is responsible for running a worker for each policy as policies are
+// added to and deleted from Consul. Multiple farms can exist simultaneously,
+// but each one must hold a different Consul session.
+type Farm struct {
+	// kind names the policies in log messages, e.g. "autoscaler policy"
+	kind      string
+	store     sessionStore
+	locker    Locker
+	watch     WatchFunc
+	newWorker NewWorkerFunc
+
+	// session stream for the policies locked by this farm
+	sessions <-chan string
+
+	children map[rc_fields.ID]child
+	childMu  sync.Mutex
+	session  consul.Session
+
+	logger logging.Logger
+}
+
+type child struct {
+	unlocker consul.Unlocker
+	quit     chan<- struct{}
+}
+
+func New(
+	kind string,
+	store sessionStore,
+	locker Locker,
+	watch WatchFunc,
+	newWorker NewWorkerFunc,
+	sessions <-chan string,
+	logger logging.Logger,
+) *Farm {
+	return &Farm{
+		kind:      kind,
+		store:     store,
+		locker:    locker,
+		watch:     watch,
+		newWorker: newWorker,
+		sessions:  sessions,
+		children:  make(map[rc_fields.ID]child),
+		logger:    logger,
+	}
+}
+
+// Start is a blocking function that monitors Consul for policies. The Farm
+// will attempt to claim policies as they appear and, if successful, will run a
+// worker for each one. Closing the quit channel will cause this function to
+// return, releasing all locks it holds.
+//
+// Start is not safe for concurrent execution. Do not execute multiple
+// concurrent instances of Start.
+func (f *Farm) Start(quit <-chan struct{}) {
+	consulutil.WithSession(quit, f.sessions, func(sessionQuit <-chan struct{}, sessionID string) {
+		f.logger.WithField("session", sessionID).Infof("Acquired new session for %s farm", f.kind)
+		f.session = f.store.NewUnmanagedSession(sessionID, "")
+		f.mainLoop(sessionQuit)
+	})
+}
+
+func (f *Farm) mainLoop(quit <-chan struct{}) {
+	subQuit := make(chan struct{})
+	defer close(subQuit)
+
+	policyWatch, policyErr := f.watch(subQuit)
+
+	for {
+		select {
+		case <-quit:
+			f.logger.NoFields().Infof("Session expired, releasing %s locks", f.kind)
+			f.session = nil
+			f.releaseChildren()
+			return
+		case err := <-policyErr:
+			f.logger.WithError(err).Errorf("Could not read consul %s", f.kind)
+		case rcIDs, ok := <-policyWatch:
+			if !ok {
+				f.releaseChildren()
+				return
+			}
+			f.handlePolicies(rcIDs)
+		}
+	}
+}
+
+func (f *Farm) handlePolicies(rcIDs []rc_fields.ID) {
+	f.childMu.Lock()
+	defer f.childMu.Unlock()
+
+	found := make(map[rc_fields.ID]struct{})
+	for _, rcID := range rcIDs {
+		found[rcID] = struct{}{}
+		if _, ok := f.children[rcID]; ok {
+			// already ours, workers read the latest policy each time
+			// they act on it
+			continue
+		}
+
+		logger := f.logger.SubLogger(logrus.Fields{
+			"rc": rcID,
+		})
+		unlocker, err := f.locker.LockForOwnership(rcID, f.session)
+		if _, ok := err.(consul.AlreadyLockedError); ok {
+			logger.NoFields().Debugf("Lock on %s was denied", f.kind)
+			continue
+		} else if err != nil {
+			logger.WithError(err).Errorf("Got error while locking %s - session may be expired", f.kind)
+			return
+		}
+
+		logger.NoFields().Infof("Acquired lock on %s, spawning", f.kind)
+		worker := f.newWorker(rcID, f.session, logger)
+		childQuit := make(chan struct{})
+		f.children[rcID] = child{
+			unlocker: unlocker,
+			quit:     childQuit,
+		}
+
+		go func(rcID rc_fields.ID) {
+			defer func() {
+				if r := recover(); r != nil {
+					err := util.Errorf("Caught panic in %s farm: %s", f.kind, r)
+
+					stackErr, ok := err.(util.StackError)
+					msg := fmt.Sprintf("Caught panic in %s farm", f.kind)
+					if ok {
+						msg = fmt.Sprintf("%s:\n%s", msg, stackErr.Stack())
+					}
+					logger.WithError(err).Errorln(msg)
+
+					// Release the policy so that another farm can reattempt
+					f.childMu.Lock()
+					defer f.childMu.Unlock()
+					if _, ok := f.children[rcID]; ok {
+						f.releaseChild(rcID)
+					}
+				}
+			}()
+			worker(childQuit)
+		}(rcID)
+	}
+
+	for rcID := range f.children {
+		if _, ok := found[rcID]; !ok {
+			f.releaseChild(rcID)
+		}
+	}
+}
+
+// close one child
+// should only be called with f.childMu locked
+func (f *Farm) releaseChild(rcID rc_fields.ID) {
+	f.logger.WithField("rc", rcID).Infof("Releasing %s", f.kind)
+	close(f.children[rcID].quit)
+
+	// if our lock is active, attempt to gracefully release it
+	if f.session != nil {
+		err := f.children[rcID].unlocker.Unlock()
+		if err != nil {
+			f.logger.WithField("rc", rcID).Warnf("Could not release %s lock", f.kind)
+		}
+	}
+	delete(f.children, rcID)
+}
+
+// close all children
+func (f *Farm) releaseChildren() {
+	f.childMu.Lock()
+	defer f.childMu.Unlock()
+	for rcID := range f.children {
+		// it's safe to delete this element during iteration,
+		// because we have already iterated over it
+		f.releaseChild(rcID)
+	}
+}
@@ -0,0 +1,89 @@
+package policyfarm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/square/p2/pkg/logging"
+	rc_fields "github.com/square/p2/pkg/rc/fields"
+	"github.com/square/p2/pkg/store/consul"
+	"github.com/square/p2/pkg/store/consul/consultest"
+)
+
+type fakeSessionStore struct {
+	session consul.Session
+}
+
+func (s fakeSessionStore) NewUnmanagedSession(session, name string) consul.Session {
+	return s.session
+}
+
+type fakeLocker struct{}
+
+func (fakeLocker) LockForOwnership(rcID rc_fields.ID, session consul.Session) (consul.Unlocker, error) {
+	return session.Lock("lock/" + rcID.String())
+}
+
+// workerEvent records a worker starting or stopping
+type workerEvent struct {
+	rcID    rc_fields.ID
+	running bool
+}
+
+func expectEvent(t *testing.T, events <-chan workerEvent, expected workerEvent) {
+	select {
+	case event := <-events:
+		if event != expected {
+			t.Fatalf("expected %+v but got %+v", expected, event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %+v", expected)
+	}
+}
+
+func TestFarmRunsWorkerForEachPolicy(t *testing.T) {
+	rcIDs := make(chan []rc_fields.ID)
+	watch := func(quit <-chan struct{}) (<-chan []rc_fields.ID, <-chan error) {
+		return rcIDs, make(chan error)
+	}
+	events := make(chan workerEvent)
+	newWorker := func(rcID rc_fields.ID, session consul.Session, logger logging.Logger) Worker {
+		return func(quit <-chan struct{}) {
+			events <- workerEvent{rcID: rcID, running: true}
+			<-quit
+			events <- workerEvent{rcID: rcID, running: false}
+		}
+	}
+
+	sessions := make(chan string, 1)
+	sessions <- "some_session"
+	farm := New(
+		"test policy",
+		fakeSessionStore{session: consultest.NewSession()},
+		fakeLocker{},
+		watch,
+		newWorker,
+		sessions,
+		logging.TestLogger(),
+	)
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		farm.Start(quit)
+	}()
+
+	rcIDs <- []rc_fields.ID{"rc1"}
+	expectEvent(t, events, workerEvent{rcID: "rc1", running: true})
+
+	// a policy that is already being worked on doesn't get another worker
+	rcIDs <- []rc_fields.ID{"rc1", "rc2"}
+	expectEvent(t, events, workerEvent{rcID: "rc2", running: true})
+
+	rcIDs <- []rc_fields.ID{"rc2"}
+	expectEvent(t, events, workerEvent{rcID: "rc1", running: false})
+
+	close(quit)
+	expectEvent(t, events, workerEvent{rcID: "rc2", running: false})
+	<-done
+}
@@ -0,0 +1,100 @@
+// Package policyfarmtest provides a consul fixture holding a replication
+// controller, for testing the workers of policy farms.
+package policyfarmtest
+
+import (
+	"context"
+	"testing"
+
+	klabels "k8s.io/kubernetes/pkg/labels"
+
+	"github.com/square/p2/pkg/labels"
+	"github.com/square/p2/pkg/manifest"
+	rc_fields "github.com/square/p2/pkg/rc/fields"
+	"github.com/square/p2/pkg/store/consul"
+	"github.com/square/p2/pkg/store/consul/auditlogstore"
+	"github.com/square/p2/pkg/store/consul/consulutil"
+	"github.com/square/p2/pkg/store/consul/rcstore"
+)
+
+type Fixture struct {
+	Client        consulutil.ConsulClient
+	RCStore       *rcstore.ConsulStore
+	AuditLogStore auditlogstore.ConsulStore
+
+	// RCID is the ID of an RC created by the fixture
+	RCID rc_fields.ID
+
+	// Session is a session for the worker under test to lock the RC with
+	Session consul.Session
+
+	t      *testing.T
+	ctx    context.Context
+	cancel context.CancelFunc
+	consul consulutil.Fixture
+}
+
+// NewFixture starts consul and creates an RC with the given replica count.
+// Call Close when done with it.
+func NewFixture(t *testing.T, replicas int) Fixture {
+	fixture := consulutil.NewFixture(t)
+
+	applicator := labels.NewConsulApplicator(fixture.Client, 0, 0)
+	rcStore := rcstore.NewConsul(fixture.Client, applicator, 0)
+	builder := manifest.NewBuilder()
+	builder.SetID("some_pod")
+	rc, err := rcStore.Create(builder.GetManifest(), klabels.Everything(), "some_az", "some_cn", nil, nil, rc_fields.StaticStrategy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rcStore.SetDesiredReplicas(rc.ID, replicas)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	_, session, err := consul.SessionContext(ctx, fixture.Client, "test-policy-farm")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return Fixture{
+		Client:        fixture.Client,
+		RCStore:       rcStore,
+		AuditLogStore: auditlogstore.NewConsulStore(fixture.Client.KV()),
+		RCID:          rc.ID,
+		Session:       session,
+		t:             t,
+		ctx:           ctx,
+		cancel:        cancel,
+		consul:        fixture,
+	}
+}
+
+func (f Fixture) Close() {
+	f.cancel()
+	f.consul.Stop()
+}
+
+// Replicas returns the RC's desired replica count
+func (f Fixture) Replicas() int {
+	rc, err := f.RCStore.Get(f.RCID)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return rc.ReplicasDesired
+}
+
+// LockForMutation locks the RC for mutation in a session of its own, the way
+// a rolling update does
+func (f Fixture) LockForMutation() consul.Unlocker {
+	_, session, err := consul.SessionContext(f.ctx, f.Client, "test-rolling-update")
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	unlocker, err := f.RCStore.LockForMutation(f.RCID, session)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return unlocker
+}
@@ -0,0 +1,104 @@
+package preparer
+
+import (
+	"bufio"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/square/p2/pkg/cgroups"
+	"github.com/square/p2/pkg/store/consul"
+	"github.com/square/p2/pkg/store/consul/nodestore"
+	"github.com/square/p2/pkg/util"
+	"github.com/square/p2/pkg/util/size"
+	"github.com/square/p2/pkg/version"
+)
+
+// DefaultNodeInventoryInterval is how often the preparer publishes its node's
+// inventory record when node_inventory_interval isn't configured
+const DefaultNodeInventoryInterval = 1 * time.Minute
+
+// memInfoPath is where the node's memory capacity is read from. It is a
+// variable so that tests can point it elsewhere
+var memInfoPath = "/proc/meminfo"
+
+// PublishNodeInventory publishes the node's inventory record to consul
+// periodically until quit is closed. The record is published under a session,
+// so readers can tell when the preparer has stopped.
+func (p *Preparer) PublishNodeInventory(quit <-chan struct{}) {
+	p.nodeStore.Heartbeat(p.node, p.nodeInventoryInterval, p.nodeInventory, quit, p.Logger)
+}
+
+func (p *Preparer) nodeInventory() (nodestore.Inventory, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nodestore.Inventory{}, util.Errorf("could not determine hostname: %s", err)
+	}
+
+	memory, err := totalMemory(memInfoPath)
+	if err != nil {
+		return nodestore.Inventory{}, err
+	}
+
+	realityResults, _, err := p.store.ListPods(consul.REALITY_TREE, p.node)
+	if err != nil {
+		return nodestore.Inventory{}, err
+	}
+	installed := make([]nodestore.InstalledPod, 0, len(realityResults))
+	for _, result := range realityResults {
+		installed = append(installed, nodestore.InstalledPod{
+			ID:        result.Manifest.ID(),
+			UniqueKey: result.PodUniqueKey,
+		})
+	}
+
+	inventory := nodestore.Inventory{
+		Node:            p.node,
+		Hostname:        hostname,
+		CPUs:            runtime.NumCPU(),
+		Memory:          memory,
+		PreparerVersion: version.VERSION,
+		Pods:            installed,
+	}
+
+	// nodes without a supported OS or without cgroups still get a record
+	inventory.OS, inventory.OSVersion, err = p.osVersionDetector.Version()
+	if err != nil {
+		p.Logger.WithError(err).Debugln("Could not detect OS version for node inventory")
+	}
+	inventory.CgroupSubsystems, err = cgroups.DefaultSubsystemer.Find()
+	if err != nil {
+		p.Logger.WithError(err).Warnln("Could not find cgroup subsystems for node inventory")
+	}
+
+	return inventory, nil
+}
+
+// totalMemory reads the MemTotal line of /proc/meminfo, which is given in
+// kibibytes
+func totalMemory(path string) (size.ByteCount, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, util.Errorf("could not read memory capacity: %s", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "MemTotal:" {
+			continue
+		}
+		kibibytes, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return 0, util.Errorf("could not parse MemTotal in %s: %s", path, err)
+		}
+		return size.ByteCount(kibibytes) * size.Kibibyte, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, util.Errorf("could not read memory capacity: %s", err)
+	}
+	return 0, util.Errorf("no MemTotal found in %s", path)
+}
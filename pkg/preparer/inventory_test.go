@@ -0,0 +1,123 @@
+package preparer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+
+	. "github.com/anthonybishopric/gotcha"
+	"github.com/square/p2/pkg/osversion"
+	"github.com/square/p2/pkg/store/consul/nodestore"
+	"github.com/square/p2/pkg/types"
+	"github.com/square/p2/pkg/util/size"
+	"github.com/square/p2/pkg/version"
+)
+
+const testMemInfo = `MemTotal:       16318648 kB
+MemFree:         1260128 kB
+MemAvailable:   10337052 kB
+Buffers:          588944 kB
+`
+
+func TestTotalMemory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "meminfo")
+	Assert(t).IsNil(err, "should have created temp dir")
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name     string
+		contents string
+		expected size.ByteCount
+		valid    bool
+	}{
+		{"meminfo", testMemInfo, 16318648 * size.Kibibyte, true},
+		{"MemTotal not first", "MemFree: 1 kB\nMemTotal: 2048 kB\n", 2 * size.Mebibyte, true},
+		{"no MemTotal", "MemFree: 1260128 kB\n", 0, false},
+		{"MemTotal not a number", "MemTotal: lots kB\n", 0, false},
+		{"MemTotal without value", "MemTotal:\n", 0, false},
+		{"empty", "", 0, false},
+	}
+	for i, test := range tests {
+		path := filepath.Join(dir, fmt.Sprintf("meminfo%d", i))
+		err := ioutil.WriteFile(path, []byte(test.contents), 0644)
+		Assert(t).IsNil(err, "should have written meminfo")
+
+		memory, err := totalMemory(path)
+		if test.valid {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %s", test.name, err)
+			} else if memory != test.expected {
+				t.Errorf("%s: expected %s but got %s", test.name, test.expected, memory)
+			}
+		} else if err == nil {
+			t.Errorf("%s: expected an error but got %s", test.name, memory)
+		}
+	}
+
+	_, err = totalMemory(filepath.Join(dir, "missing"))
+	Assert(t).IsNotNil(err, "expected an error reading a missing meminfo file")
+}
+
+func TestNodeInventory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inventory")
+	Assert(t).IsNil(err, "should have created temp dir")
+	defer os.RemoveAll(dir)
+
+	oldMemInfoPath := memInfoPath
+	defer func() { memInfoPath = oldMemInfoPath }()
+	memInfoPath = filepath.Join(dir, "meminfo")
+	err = ioutil.WriteFile(memInfoPath, []byte(testMemInfo), 0644)
+	Assert(t).IsNil(err, "should have written meminfo")
+	releaseFile := filepath.Join(dir, "redhat-release")
+	err = ioutil.WriteFile(releaseFile, []byte("CentOS Linux release 7.9 (Core)\n"), 0644)
+	Assert(t).IsNil(err, "should have written release file")
+
+	p, _, fakePodRoot := testPreparer(t, &FakeStore{currentManifest: testManifest(t)}, hooksManifestDefault)
+	defer p.Close()
+	defer os.RemoveAll(fakePodRoot)
+	p.osVersionDetector = osversion.NewDetector(releaseFile)
+
+	inventory, err := p.nodeInventory()
+	Assert(t).IsNil(err, "should have built the node inventory")
+
+	hostname, err := os.Hostname()
+	Assert(t).IsNil(err, "should have read hostname")
+	Assert(t).AreEqual(types.NodeName("hostname"), inventory.Node, "should have recorded the preparer's node name")
+	Assert(t).AreEqual(hostname, inventory.Hostname, "should have recorded the hostname")
+	Assert(t).AreEqual(runtime.NumCPU(), inventory.CPUs, "should have recorded the CPU count")
+	Assert(t).AreEqual(16318648*size.Kibibyte, inventory.Memory, "should have recorded the memory capacity")
+	Assert(t).AreEqual(version.VERSION, inventory.PreparerVersion, "should have recorded the preparer version")
+	Assert(t).AreEqual(osversion.OS("CentOS"), inventory.OS, "should have recorded the OS")
+	Assert(t).AreEqual(osversion.OSVersion("7.9"), inventory.OSVersion, "should have recorded the OS version")
+
+	expectedPods := []nodestore.InstalledPod{{ID: "hello", UniqueKey: "1"}}
+	if !reflect.DeepEqual(inventory.Pods, expectedPods) {
+		t.Errorf("expected installed pods %+v but got %+v", expectedPods, inventory.Pods)
+	}
+}
+
+func TestNodeInventoryWithoutOSVersion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inventory")
+	Assert(t).IsNil(err, "should have created temp dir")
+	defer os.RemoveAll(dir)
+
+	oldMemInfoPath := memInfoPath
+	defer func() { memInfoPath = oldMemInfoPath }()
+	memInfoPath = filepath.Join(dir, "meminfo")
+	err = ioutil.WriteFile(memInfoPath, []byte(testMemInfo), 0644)
+	Assert(t).IsNil(err, "should have written meminfo")
+
+	p, _, fakePodRoot := testPreparer(t, &FakeStore{}, hooksManifestDefault)
+	defer p.Close()
+	defer os.RemoveAll(fakePodRoot)
+	p.osVersionDetector = osversion.NewDetector(filepath.Join(dir, "missing-release"))
+
+	inventory, err := p.nodeInventory()
+	Assert(t).IsNil(err, "should have published an inventory for a node without a supported OS")
+	Assert(t).AreEqual(osversion.OS(""), inventory.OS, "should not have recorded an OS")
+	Assert(t).AreEqual(0, len(inventory.Pods), "should not have recorded any pods")
+}
@@ -352,11 +352,15 @@ func (p *Preparer) handlePods(podChan <-chan ManifestPair, quit <-chan struct{})
 						break
 					}
 				}
+				workerID := podWorkerID{podID: nextLaunch.ID, podUniqueKey: nextLaunch.PodUniqueKey}
+				p.setPodBusy(workerID, true)
 				err = p.preparePod(&nextLaunch, pod, manifestLogger)
 				if err != nil {
+					p.setPodBusy(workerID, false)
 					break
 				}
 				ok := p.resolvePair(nextLaunch, pod, manifestLogger)
+				p.setPodBusy(workerID, false)
 				if ok {
 					nextLaunch = ManifestPair{}
 					working = false
@@ -374,6 +378,29 @@ func (p *Preparer) handlePods(podChan <-chan ManifestPair, quit <-chan struct{})
 	}
 }
 
+// PodBusy returns true while the preparer is installing, launching, halting or
+// uninstalling the given pod. Its processes are expected to be down or
+// restarting in the meantime, so the pod shouldn't be judged by its liveness
+func (p *Preparer) PodBusy(podID types.PodID, podUniqueKey types.PodUniqueKey) bool {
+	p.busyPodsMu.Lock()
+	defer p.busyPodsMu.Unlock()
+	_, busy := p.busyPods[podWorkerID{podID: podID, podUniqueKey: podUniqueKey}]
+	return busy
+}
+
+func (p *Preparer) setPodBusy(workerID podWorkerID, busy bool) {
+	p.busyPodsMu.Lock()
+	defer p.busyPodsMu.Unlock()
+	if !busy {
+		delete(p.busyPods, workerID)
+		return
+	}
+	if p.busyPods == nil {
+		p.busyPods = make(map[podWorkerID]struct{})
+	}
+	p.busyPods[workerID] = struct{}{}
+}
+
 func (p *Preparer) preparePod(nextLaunch *ManifestPair, pod *pods.Pod, manifestLogger logging.Logger) error {
 	// TODO better solution: force the preparer to have a 0s default timeout, prevent KILLs
 	if pod.Id == constants.PreparerPodID {
@@ -2,8 +2,15 @@ package preparer
 
 import (
 	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
 	"fmt"
 	"io/ioutil"
+	"math/big"
 	"os"
 	"runtime"
 	"testing"
@@ -573,6 +580,172 @@ func TestPreparerWillAcceptSignatureWhenKeyringIsNil(t *testing.T) {
 	)
 }
 
+// testCertAuth generates a signing CA and a certificate issued by it that
+// may deploy the given pods as the given users and is valid for the two hours
+// before notAfter. It returns a "cert" auth config trusting the CA, a
+// function that signs manifests with the certificate at the given time and
+// the directory holding the CA, which the caller should remove
+func testCertAuth(t *testing.T, podIDs []string, users []string, notAfter time.Time) (map[string]interface{}, func(manifest.Manifest, time.Time) manifest.Manifest, string) {
+	podIDsExtension := asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 1}
+	usersExtension := asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 2}
+
+	_, caKey, err := ed25519.GenerateKey(rand.Reader)
+	Assert(t).IsNil(err, "should have generated CA key")
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test signing CA"},
+		NotBefore:             time.Now().Add(-24 * time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, caKey.Public(), caKey)
+	Assert(t).IsNil(err, "should have created CA certificate")
+	caCert, err := x509.ParseCertificate(caDER)
+	Assert(t).IsNil(err, "should have parsed CA certificate")
+
+	podIDsValue, err := asn1.Marshal(podIDs)
+	Assert(t).IsNil(err, "should have marshaled pod IDs")
+	usersValue, err := asn1.Marshal(users)
+	Assert(t).IsNil(err, "should have marshaled users")
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	Assert(t).IsNil(err, "should have generated signing key")
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "deploy bot"},
+		NotBefore:    notAfter.Add(-2 * time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		ExtraExtensions: []pkix.Extension{
+			{Id: podIDsExtension, Critical: true, Value: podIDsValue},
+			{Id: usersExtension, Critical: true, Value: usersValue},
+		},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, key.Public(), caKey)
+	Assert(t).IsNil(err, "should have created signing certificate")
+	cert, err := x509.ParseCertificate(der)
+	Assert(t).IsNil(err, "should have parsed signing certificate")
+
+	rootsDir, err := ioutil.TempDir("", "cert_auth")
+	Assert(t).IsNil(err, "should have created temp dir")
+	rootsPath := filepath.Join(rootsDir, "roots.pem")
+	err = ioutil.WriteFile(rootsPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw}), 0644)
+	Assert(t).IsNil(err, "should have written trusted roots")
+
+	authConfig := map[string]interface{}{
+		"type":              auth.Cert,
+		"roots":             rootsPath,
+		"pod_ids_extension": podIDsExtension.String(),
+		"users_extension":   usersExtension.String(),
+	}
+	sign := func(unsigned manifest.Manifest, signingTime time.Time) manifest.Manifest {
+		manifestBytes, err := unsigned.Marshal()
+		Assert(t).IsNil(err, "manifest bytes error should have been nil")
+		signedBytes, err := auth.ClearsignWithCertAt(manifestBytes, key, []*x509.Certificate{cert}, signingTime)
+		Assert(t).IsNil(err, "should have signed manifest")
+		signed, err := manifest.FromBytes(signedBytes)
+		Assert(t).IsNil(err, "should have generated manifest from signed bytes")
+		return signed
+	}
+	return authConfig, sign, rootsDir
+}
+
+func TestPreparerWillAcceptCertSignedManifest(t *testing.T) {
+	authConfig, sign, rootsDir := testCertAuth(t, []string{"hello"}, []string{"hello"}, time.Now().Add(10*time.Minute))
+	defer os.RemoveAll(rootsDir)
+
+	p, _, fakePodRoot := testPreparer(t, &FakeStore{}, hooksManifestDefault)
+	defer p.Close()
+	defer os.RemoveAll(fakePodRoot)
+	var err error
+	p.authPolicy, err = getDeployerAuth(&PreparerConfig{Auth: authConfig})
+	Assert(t).IsNil(err, "should have configured cert auth")
+
+	Assert(t).IsTrue(
+		p.authorize(sign(testManifest(t), time.Now()), logging.DefaultLogger),
+		"should have accepted manifest signed with an authorized certificate",
+	)
+	Assert(t).IsFalse(
+		p.authorize(testManifest(t), logging.DefaultLogger),
+		"should have rejected unsigned manifest",
+	)
+}
+
+func TestPreparerWillAcceptCertSignedManifestAfterCertExpires(t *testing.T) {
+	expiry := time.Now().Add(-time.Hour)
+	authConfig, sign, rootsDir := testCertAuth(t, []string{"hello"}, []string{"hello"}, expiry)
+	defer os.RemoveAll(rootsDir)
+
+	p, _, fakePodRoot := testPreparer(t, &FakeStore{}, hooksManifestDefault)
+	defer p.Close()
+	defer os.RemoveAll(fakePodRoot)
+	var err error
+	p.authPolicy, err = getDeployerAuth(&PreparerConfig{Auth: authConfig})
+	Assert(t).IsNil(err, "should have configured cert auth")
+
+	Assert(t).IsTrue(
+		p.authorize(sign(testManifest(t), expiry.Add(-time.Minute)), logging.DefaultLogger),
+		"should have accepted manifest signed before its certificate expired",
+	)
+	Assert(t).IsFalse(
+		p.authorize(sign(testManifest(t), time.Now()), logging.DefaultLogger),
+		"should have rejected manifest signed after its certificate expired",
+	)
+
+	authConfig["expiry_grace_period"] = "30m"
+	p.authPolicy, err = getDeployerAuth(&PreparerConfig{Auth: authConfig})
+	Assert(t).IsNil(err, "should have configured cert auth")
+	Assert(t).IsFalse(
+		p.authorize(sign(testManifest(t), expiry.Add(-time.Minute)), logging.DefaultLogger),
+		"should have rejected manifest whose certificate expired before the grace period",
+	)
+}
+
+func TestPreparerWillRejectCertSignedManifestForUnlistedPod(t *testing.T) {
+	authConfig, sign, rootsDir := testCertAuth(t, []string{"goodbye"}, []string{"hello"}, time.Now().Add(10*time.Minute))
+	defer os.RemoveAll(rootsDir)
+
+	p, _, fakePodRoot := testPreparer(t, &FakeStore{}, hooksManifestDefault)
+	defer p.Close()
+	defer os.RemoveAll(fakePodRoot)
+	var err error
+	p.authPolicy, err = getDeployerAuth(&PreparerConfig{Auth: authConfig})
+	Assert(t).IsNil(err, "should have configured cert auth")
+
+	Assert(t).IsFalse(
+		p.authorize(sign(testManifest(t), time.Now()), logging.DefaultLogger),
+		"should have rejected manifest for a pod the certificate does not list",
+	)
+}
+
+func TestPreparerWillRejectCertSignedManifestForUnlistedUser(t *testing.T) {
+	authConfig, sign, rootsDir := testCertAuth(t, []string{"hello"}, []string{"nobodylol"}, time.Now().Add(10*time.Minute))
+	defer os.RemoveAll(rootsDir)
+
+	p, _, fakePodRoot := testPreparer(t, &FakeStore{}, hooksManifestDefault)
+	defer p.Close()
+	defer os.RemoveAll(fakePodRoot)
+	var err error
+	p.authPolicy, err = getDeployerAuth(&PreparerConfig{Auth: authConfig})
+	Assert(t).IsNil(err, "should have configured cert auth")
+
+	Assert(t).IsFalse(
+		p.authorize(sign(testManifest(t), time.Now()), logging.DefaultLogger),
+		"should have rejected manifest run as a user the certificate does not list",
+	)
+}
+
+func TestCertAuthRequiresRoots(t *testing.T) {
+	authConfig, _, rootsDir := testCertAuth(t, []string{"hello"}, []string{"hello"}, time.Now().Add(10*time.Minute))
+	defer os.RemoveAll(rootsDir)
+	delete(authConfig, "roots")
+
+	_, err := getDeployerAuth(&PreparerConfig{Auth: authConfig})
+	Assert(t).IsNotNil(err, "expected cert auth without trusted roots to be rejected")
+}
+
 func TestPrepareHooksFailureRequired(t *testing.T) {
 	store := &FakeStore{currentManifest: testManifest(t)}
 	p, fakeHooks, _ := testPreparer(t, store, hooksManifestDefault)
@@ -37,6 +37,7 @@ import (
 	"github.com/square/p2/pkg/runit"
 	"github.com/square/p2/pkg/store/consul"
 	"github.com/square/p2/pkg/store/consul/consulutil"
+	"github.com/square/p2/pkg/store/consul/nodestore"
 	"github.com/square/p2/pkg/store/consul/podstore"
 	"github.com/square/p2/pkg/store/consul/statusstore"
 	"github.com/square/p2/pkg/store/consul/statusstore/podstatus"
@@ -92,6 +93,9 @@ type Preparer struct {
 	artifactVerifier       auth.ArtifactVerifier
 	artifactRegistry       artifact.Registry
 	fetcher                uri.Fetcher // cached (potentially nil) uri.Fetcher configured based on the preparer's manifest
+	osVersionDetector      osversion.Detector
+	nodeStore              *nodestore.ConsulStore
+	nodeInventoryInterval  time.Duration
 
 	// Exported so it can be checked for nil (it only runs if configured)
 	// and quit channel conditially created
@@ -113,6 +117,11 @@ type Preparer struct {
 	containerRegistryAuthStr string
 
 	dockerImageDirectoryWhitelist []string
+
+	// busyPods holds the pods that handlePods is currently changing, see
+	// PodBusy(). It is written by the goroutine handling each pod
+	busyPodsMu sync.Mutex
+	busyPods   map[podWorkerID]struct{}
 }
 
 type store interface {
@@ -210,6 +219,10 @@ type PreparerConfig struct {
 	// IdleConnTimeout will be set on the preparer's HTTP client transport.
 	IdleConnTimeout time.Duration `yaml:"idle_conn_timeout"`
 
+	// NodeInventoryInterval is how often the preparer publishes the node's
+	// inventory record. Defaults to DefaultNodeInventoryInterval
+	NodeInventoryInterval time.Duration `yaml:"node_inventory_interval,omitempty"`
+
 	podHome string `yaml:"pod_home"`
 
 	// Use a single Store so that all requests go through the same HTTP client.
@@ -236,6 +249,20 @@ type UserAuth struct {
 	DeployPolicyPath string `yaml:"deploy_policy"`
 }
 
+// Configuration fields for the "cert" auth type. Roots is a PEM file of the
+// CAs trusted to issue signing certificates, and the extensions are the OIDs,
+// in dotted form, of the certificate extensions listing the pod IDs and
+// run-as users that a certificate may deploy. Manifests are accepted for the
+// expiry grace period (e.g. "720h", default auth.DefaultCertExpiryGracePeriod)
+// after their signing certificate expires, and must be re-signed after that
+type CertAuth struct {
+	Type              string
+	RootsPath         string        `yaml:"roots"`
+	PodIDsExtension   string        `yaml:"pod_ids_extension"`
+	UsersExtension    string        `yaml:"users_extension"`
+	ExpiryGracePeriod time.Duration `yaml:"expiry_grace_period,omitempty"`
+}
+
 // --- Artifact verification strategies ---
 //
 // The type matches one of the auth.Verify* constants
@@ -245,11 +272,15 @@ type UserAuth struct {
 // "type: manifest" - checks that builds have corresponding digest manifest and
 //  						      manifest signature files.
 // "type: either"   - checks that one of "build" or "manifest" strategies pass.
+// "type: attestation" - checks that builds have an in-toto attestation signed
+//                       by one of "public_keys" and built by one of "builders".
 //
 type ManifestVerification struct {
 	Type           string
 	KeyringPath    string   `yaml:"keyring,omitempty"`
 	AllowedSigners []string `yaml:"allowed_signers"`
+	PublicKeysPath string   `yaml:"public_keys,omitempty"`
+	Builders       []string `yaml:"builders,omitempty"`
 }
 
 // LoadConfig reads the preparer's configuration from a file.
@@ -720,6 +751,12 @@ func New(preparerConfig *PreparerConfig, logger logging.Logger) (*Preparer, erro
 	}
 
 	podFactory.SetDockerClient(*dockerClient)
+
+	nodeInventoryInterval := preparerConfig.NodeInventoryInterval
+	if nodeInventoryInterval == 0 {
+		nodeInventoryInterval = DefaultNodeInventoryInterval
+	}
+
 	return &Preparer{
 		node:                          preparerConfig.NodeName,
 		store:                         store,
@@ -745,6 +782,9 @@ func New(preparerConfig *PreparerConfig, logger logging.Logger) (*Preparer, erro
 		hooksExecDir:                  preparerConfig.HooksDirectory,
 		hooksRequired:                 preparerConfig.HooksRequired,
 		fetcher:                       fetcher,
+		osVersionDetector:             osVersionDetector,
+		nodeStore:                     nodestore.NewConsul(client),
+		nodeInventoryInterval:         nodeInventoryInterval,
 	}, nil
 }
 
@@ -792,6 +832,27 @@ func getDeployerAuth(preparerConfig *PreparerConfig) (auth.Policy, error) {
 		if err != nil {
 			return nil, util.Errorf("error configuring user auth: %s", err)
 		}
+	case auth.Cert:
+		var certConfig CertAuth
+		err := castYaml(preparerConfig.Auth, &certConfig)
+		if err != nil {
+			return nil, util.Errorf("error configuring cert auth: %s", err)
+		}
+		if certConfig.RootsPath == "" {
+			return nil, util.Errorf("cert auth must contain a path to the trusted roots")
+		}
+		podIDsExtension, err := auth.ParseOID(certConfig.PodIDsExtension)
+		if err != nil {
+			return nil, util.Errorf("cert auth must contain the pod IDs extension: %s", err)
+		}
+		usersExtension, err := auth.ParseOID(certConfig.UsersExtension)
+		if err != nil {
+			return nil, util.Errorf("cert auth must contain the users extension: %s", err)
+		}
+		authPolicy, err = auth.NewCertPolicy(certConfig.RootsPath, podIDsExtension, usersExtension, certConfig.ExpiryGracePeriod)
+		if err != nil {
+			return nil, util.Errorf("error configuring cert auth: %s", err)
+		}
 	default:
 		if t, ok := preparerConfig.Auth["type"].(string); ok {
 			return nil, util.Errorf("unrecognized auth type: %s", t)
@@ -832,6 +893,12 @@ func getArtifactVerifier(preparerConfig *PreparerConfig, logger *logging.Logger)
 			return nil, util.Errorf("error configuring artifact verification: %v", err)
 		}
 		return auth.NewCompositeVerifier(verif.KeyringPath, fetcher, logger)
+	case auth.VerifyAttestation:
+		err = castYaml(preparerConfig.ArtifactAuth, &verif)
+		if err != nil {
+			return nil, util.Errorf("error configuring artifact verification: %v", err)
+		}
+		return auth.NewAttestationVerifier(verif.PublicKeysPath, verif.Builders, fetcher, logger)
 	default:
 		return nil, util.Errorf("Unrecognized artifact verification type: %v", t)
 	}
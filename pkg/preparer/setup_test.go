@@ -1,6 +1,10 @@
 package preparer
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -46,6 +50,35 @@ func TestLoadConfigWillMarshalYaml(t *testing.T) {
 	Assert(t).AreEqual("/var/log/p2-socket.out", destination.Path, "should have parsed path correctly")
 }
 
+func TestGetArtifactVerifierForAttestations(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	Assert(t).IsNil(err, "should have generated key")
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	Assert(t).IsNil(err, "should have marshaled public key")
+	keysDir, err := ioutil.TempDir("", "artifact_auth")
+	Assert(t).IsNil(err, "should have created temp dir")
+	defer os.RemoveAll(keysDir)
+	keysPath := filepath.Join(keysDir, "public_keys.pem")
+	err = ioutil.WriteFile(keysPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644)
+	Assert(t).IsNil(err, "should have written public keys")
+
+	preparerConfig := &PreparerConfig{
+		ArtifactAuth: map[string]interface{}{
+			"type":        auth.VerifyAttestation,
+			"public_keys": keysPath,
+			"builders":    []string{"https://ci.example.com/builders/hoist"},
+		},
+	}
+	verifier, err := getArtifactVerifier(preparerConfig, &logging.DefaultLogger)
+	Assert(t).IsNil(err, "should have configured attestation verification")
+	_, ok := verifier.(*auth.AttestationVerifier)
+	Assert(t).IsTrue(ok, "should have returned an attestation verifier")
+
+	delete(preparerConfig.ArtifactAuth, "builders")
+	_, err = getArtifactVerifier(preparerConfig, &logging.DefaultLogger)
+	Assert(t).IsNotNil(err, "expected attestation verification without trusted builders to be rejected")
+}
+
 func TestInstallHooks(t *testing.T) {
 	destDir, _ := ioutil.TempDir("", "pods")
 	defer os.RemoveAll(destDir)
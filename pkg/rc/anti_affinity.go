@@ -0,0 +1,64 @@
+package rc
+
+import (
+	klabels "k8s.io/kubernetes/pkg/labels"
+
+	"github.com/square/p2/pkg/labels"
+	"github.com/square/p2/pkg/rc/fields"
+	"github.com/square/p2/pkg/types"
+)
+
+// antiAffinityConflicts returns the nodes running a pod that matches the
+// selector and is not an instance of the given pod ID. Instances of the RC's
+// own pod never conflict with it, whether they are managed by the RC itself or
+// by another RC for the same pod. In particular the old and new RCs of a
+// rolling update usually share their anti-affinity selector, and if they
+// conflicted with each other the new RC could never be scheduled on the nodes
+// the old RC still occupies.
+func antiAffinityConflicts(labeler LabelMatcher, podID types.PodID, selector klabels.Selector) (types.NodeSet, error) {
+	matches, err := labeler.GetMatches(selector, labels.POD)
+	if err != nil {
+		return types.NodeSet{}, err
+	}
+
+	conflicts := types.NewNodeSet()
+	for _, match := range matches {
+		node, matchPodID, err := labels.NodeAndPodIDFromPodLabel(match)
+		if err != nil {
+			return types.NodeSet{}, err
+		}
+		if matchPodID == podID {
+			continue
+		}
+		conflicts.InsertNode(node)
+	}
+	return conflicts, nil
+}
+
+// withoutAntiAffinityConflicts removes the nodes that conflict with the RC's
+// anti-affinity selector from eligible. Nodes already running one of the RC's
+// pods are kept, so a pod landing next to an existing replica later causes
+// new replicas to avoid the node rather than the existing replica to be moved.
+func (rc *replicationController) withoutAntiAffinityConflicts(rcFields fields.RC, eligible []types.NodeName) ([]types.NodeName, error) {
+	conflicts, err := antiAffinityConflicts(rc.podApplicator, rcFields.Manifest.ID(), rcFields.AntiAffinity)
+	if err != nil {
+		return nil, err
+	}
+	if conflicts.Len() == 0 {
+		return eligible, nil
+	}
+
+	current, err := rc.CurrentPods()
+	if err != nil {
+		return nil, err
+	}
+	conflicts = conflicts.Difference(types.NewNodeSet(current.Nodes()...))
+
+	result := make([]types.NodeName, 0, len(eligible))
+	for _, node := range eligible {
+		if !conflicts.Has(node.String()) {
+			result = append(result, node)
+		}
+	}
+	return result, nil
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/square/p2/pkg/alerting"
 	"github.com/square/p2/pkg/artifact"
 	"github.com/square/p2/pkg/audit"
+	"github.com/square/p2/pkg/disruptionbudget"
 	"github.com/square/p2/pkg/health/checker"
 	"github.com/square/p2/pkg/labels"
 	"github.com/square/p2/pkg/logging"
@@ -28,6 +29,12 @@ import (
 	"github.com/rcrowley/go-metrics"
 )
 
+// reservationSweepInterval is how often a farm releases the scheduler
+// reservations of RCs that no longer exist. The reservations of RCs deleted
+// while a farm owns them are released right away, but RCs can also be deleted
+// while no farm owns them, e.g. while the farms are down.
+const reservationSweepInterval = 5 * time.Minute
+
 // subset of labels.Applicator
 type Labeler interface {
 	SetLabelsTxn(ctx context.Context, labelType labels.Type, id string, labels map[string]string) error
@@ -104,6 +111,8 @@ type Farm struct {
 
 	artifactRegistry artifact.Registry
 	sdChecker        ServiceDiscoveryChecker
+	disruptions      disruptionbudget.Budgets
+	nodeFailures     NodeFailureDetector
 }
 
 type childRC struct {
@@ -131,6 +140,8 @@ func NewFarm(
 	rcWatchPauseTime time.Duration,
 	artifactRegistry artifact.Registry,
 	sdChecker ServiceDiscoveryChecker,
+	disruptions disruptionbudget.Budgets,
+	nodeFailures NodeFailureDetector,
 ) *Farm {
 	if alerter == nil {
 		alerter = alerting.NewNop()
@@ -156,6 +167,8 @@ func NewFarm(
 		rcWatchPauseTime: rcWatchPauseTime,
 		artifactRegistry: artifactRegistry,
 		sdChecker:        sdChecker,
+		disruptions:      disruptions,
+		nodeFailures:     nodeFailures,
 	}
 }
 
@@ -183,6 +196,9 @@ func (rcf *Farm) mainLoop(quit <-chan struct{}) {
 
 	rcKeyWatch, rcErr := rcf.rcStore.WatchRCKeysWithLockInfo(subQuit, rcf.rcWatchPauseTime)
 
+	sweepTicker := time.NewTicker(reservationSweepInterval)
+	defer sweepTicker.Stop()
+
 	go func(errCh <-chan error) {
 		for {
 			select {
@@ -214,6 +230,8 @@ START_LOOP:
 			rcf.session = nil
 			rcf.releaseChildren()
 			return
+		case <-sweepTicker.C:
+			rcf.releaseOrphanedReservations()
 		case rcKeys := <-rcKeyWatch:
 			startTime := time.Now()
 			rcf.logger.WithField("n", len(rcKeys)).Debugln("Received replication controller update")
@@ -286,6 +304,8 @@ START_LOOP:
 					rcf.healthChecker,
 					rcf.artifactRegistry,
 					rcf.sdChecker,
+					rcf.disruptions,
+					rcf.nodeFailures,
 				)
 				childQuit := make(chan struct{})
 				rcf.children[rcKey.ID] = childRC{
@@ -398,7 +418,63 @@ func (rcf *Farm) releaseDeletedChildren(foundChildren map[fields.ID]struct{}) {
 	for id := range rcf.children {
 		if _, ok := foundChildren[id]; !ok {
 			rcf.releaseChild(id)
+			rcf.releaseReservations(id)
+		}
+	}
+}
+
+// releaseReservations frees the resources the scheduler reserved for a
+// deleted RC, if it reserves any
+func (rcf *Farm) releaseReservations(id fields.ID) {
+	releaser, ok := schedulerForRC(rcf.scheduler, id).(reservationReleaser)
+	if !ok {
+		return
+	}
+	err := releaser.ReleaseReservations()
+	if err != nil {
+		rcf.logger.WithError(err).WithField("rc", id).Errorln("Could not release the scheduler reservations of deleted replication controller")
+	}
+}
+
+// releaseOrphanedReservations frees the resources the scheduler reserved for
+// RCs that no longer exist. The owners of reservations are read before the
+// RCs, so an RC that reserves resources in between is always found.
+func (rcf *Farm) releaseOrphanedReservations() {
+	lister, ok := rcf.scheduler.(reservationOwnerLister)
+	if !ok {
+		return
+	}
+	owners, err := lister.ReservationOwners()
+	if err != nil {
+		rcf.logger.WithError(err).Errorln("Could not list the owners of scheduler reservations")
+		return
+	}
+	if len(owners) == 0 {
+		return
+	}
+
+	rcs, err := rcf.rcStore.List()
+	if err != nil {
+		rcf.logger.WithError(err).Errorln("Could not list replication controllers to release orphaned scheduler reservations")
+		return
+	}
+	if len(rcs) == 0 {
+		// the failsafe treats no RCs at all as a bad read, so don't
+		// release every reservation because of one
+		rcf.logger.NoFields().Warnln("No replication controllers found, not releasing any scheduler reservations")
+		return
+	}
+
+	existing := make(map[fields.ID]struct{}, len(rcs))
+	for _, rc := range rcs {
+		existing[rc.ID] = struct{}{}
+	}
+	for _, owner := range owners {
+		if _, ok := existing[owner]; ok {
+			continue
 		}
+		rcf.logger.WithField("rc", owner).Infoln("Releasing scheduler reservations of replication controller that no longer exists")
+		rcf.releaseReservations(owner)
 	}
 }
 
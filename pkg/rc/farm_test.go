@@ -7,7 +7,11 @@ import (
 
 	"github.com/square/p2/pkg/alerting"
 	"github.com/square/p2/pkg/labels"
+	"github.com/square/p2/pkg/logging"
 	"github.com/square/p2/pkg/manifest"
+	"github.com/square/p2/pkg/rc/fields"
+	"github.com/square/p2/pkg/scheduler"
+	"github.com/square/p2/pkg/store/consul/consulutil"
 	"github.com/square/p2/pkg/store/consul/rcstore"
 
 	. "github.com/anthonybishopric/gotcha"
@@ -88,6 +92,90 @@ func TestRCsWithZeroCountsWillTriggerIncident(t *testing.T) {
 	rcf.initialFailsafe()
 }
 
+func TestDeletedRCReleasesReservations(t *testing.T) {
+	fixture := consulutil.NewFixture(t)
+	defer fixture.Stop()
+	applicator := labels.NewConsulApplicator(fixture.Client, 0, 0)
+
+	err := applicator.SetLabel(labels.NODE, "node1", "pool", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	selector := klabels.Everything().Add("pool", klabels.EqualsOperator, []string{"test"})
+	resources := scheduler.NewResourceScheduler(applicator, fixture.Client.KV(), fixture.Client.KV(), scheduler.BinPackPolicy)
+	_, err = resources.ForRC("some_rc").AllocateNodes(testManifest(), selector, 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rcf := &Farm{
+		scheduler: resources,
+		logger:    logging.TestLogger(),
+		children: map[fields.ID]childRC{
+			"some_rc": {quit: make(chan struct{})},
+		},
+	}
+	rcf.releaseDeletedChildren(map[fields.ID]struct{}{})
+
+	eligible, err := resources.ForRC("some_rc").EligibleNodes(testManifest(), selector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(eligible) != 0 {
+		t.Errorf("expected the deleted RC's reservations to be released, but %s are still allocated to it", eligible)
+	}
+}
+
+func TestSweepReleasesReservationsOfUnownedDeletedRCs(t *testing.T) {
+	fixture := consulutil.NewFixture(t)
+	defer fixture.Stop()
+	applicator := labels.NewConsulApplicator(fixture.Client, 0, 0)
+
+	err := applicator.SetLabel(labels.NODE, "node1", "pool", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	selector := klabels.Everything().Add("pool", klabels.EqualsOperator, []string{"test"})
+	resources := scheduler.NewResourceScheduler(applicator, fixture.Client.KV(), fixture.Client.KV(), scheduler.BinPackPolicy)
+
+	fakeStore := rcstore.NewFake()
+	rc, err := fakeStore.Create(testManifest(), selector, "some_az", "some_cn", map[string]string{}, nil, "some_strategy")
+	if err != nil {
+		t.Fatalf("could not put an RC in the fake store: %s", err)
+	}
+	// "deleted_rc" isn't in the store and was never owned by the farm, as
+	// if it was deleted while no farm owned it
+	for _, id := range []fields.ID{rc.ID, "deleted_rc"} {
+		_, err = resources.ForRC(id).AllocateNodes(testManifest(), selector, 1, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rcf := &Farm{
+		scheduler: resources,
+		rcStore:   fakeStore,
+		logger:    logging.TestLogger(),
+		children:  make(map[fields.ID]childRC),
+	}
+	rcf.releaseOrphanedReservations()
+
+	eligible, err := resources.ForRC("deleted_rc").EligibleNodes(testManifest(), selector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(eligible) != 0 {
+		t.Errorf("expected the deleted RC's reservations to be released, but %s are still allocated to it", eligible)
+	}
+	eligible, err = resources.ForRC(rc.ID).EligibleNodes(testManifest(), selector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(eligible) != 1 {
+		t.Errorf("expected the existing RC to keep its reservations, but %s are allocated to it", eligible)
+	}
+}
+
 func TestHTTPApplicatorImplementsFunctionality(t *testing.T) {
 	// assign an http applicator to Labeler to make sure http applicator implements the
 	// functionality it needs to
@@ -4,10 +4,12 @@ import (
 	"encoding/json"
 	"sort"
 
-	"k8s.io/kubernetes/pkg/labels"
+	klabels "k8s.io/kubernetes/pkg/labels"
 
 	"github.com/gofrs/uuid"
+	"github.com/square/p2/pkg/labels"
 	"github.com/square/p2/pkg/manifest"
+	"github.com/square/p2/pkg/types"
 	"github.com/square/p2/pkg/util"
 )
 
@@ -51,10 +53,10 @@ type RC struct {
 	Manifest manifest.Manifest
 
 	// Defines the set of nodes on which the manifest can be scheduled
-	NodeSelector labels.Selector
+	NodeSelector klabels.Selector
 
 	// A set of labels that will be added to every pod scheduled by this controller.
-	PodLabels labels.Set
+	PodLabels klabels.Set
 
 	// The desired number of instances of the manifest that should be
 	// scheduled.
@@ -66,23 +68,67 @@ type RC struct {
 	// Distinguishes between dynamic, static or other strategies for allocating
 	// nodes on which the rc can schedule the manifest.
 	AllocationStrategy Strategy
+
+	// SpreadConstraint, if set, keeps the controller's replicas balanced
+	// across the failure domains defined by a node label.
+	SpreadConstraint *SpreadConstraint
+
+	// AntiAffinity, if set, selects pods by their labels. The controller
+	// will not schedule new replicas on nodes already running a matching
+	// pod with a different pod ID. Instances of the controller's own pod,
+	// such as those of the other controller in a rolling update, don't
+	// count.
+	AntiAffinity klabels.Selector
+
+	// TransferNodes lists the nodes the controller has been asked to move
+	// its pods off of, e.g. by a node drain. Its pods on them are
+	// transferred as if the nodes were ineligible, and the transfers are
+	// retried until none of its pods are left on them.
+	TransferNodes []types.NodeName
+}
+
+// A SpreadConstraint describes how an RC's replicas should be distributed
+// across failure domains such as racks or availability zones.
+type SpreadConstraint struct {
+	// Key is the node label whose values identify the failure domain of
+	// each node, e.g. "rack". Nodes without the label are treated as a
+	// single domain.
+	Key string `json:"key"`
+
+	// MaxSkew is the largest difference that is allowed between the
+	// number of replicas in the most and least populated domains. The RC
+	// will not schedule a replica that would exceed it.
+	MaxSkew int `json:"max_skew"`
+}
+
+func (c SpreadConstraint) Validate() error {
+	if c.Key == "" {
+		return util.Errorf("spread constraint must have a node label key")
+	}
+	if c.MaxSkew < 1 {
+		return util.Errorf("spread constraint max skew must be at least 1, was %d", c.MaxSkew)
+	}
+	return nil
 }
 
 // RawRC defines the JSON format used to store data into Consul. It should only be used
 // while (de-)serializing the RC state. Prefer using the "RC" when possible.
 type RawRC struct {
-	ID           ID         `json:"id"`
-	Manifest     string     `json:"manifest"`
-	NodeSelector string     `json:"node_selector"`
-	PodLabels    labels.Set `json:"pod_labels"`
+	ID           ID          `json:"id"`
+	Manifest     string      `json:"manifest"`
+	NodeSelector string      `json:"node_selector"`
+	PodLabels    klabels.Set `json:"pod_labels"`
 
 	// ReplicasDesired is an int pointer so we can distinguish between a
 	// zero-count indicating the RC handler should remove any and all pods
 	// from a case (for instance if the json key was changed) where golang
 	// is defaulting to the 0 value
-	ReplicasDesired    *int     `json:"replicas_desired"`
-	Disabled           bool     `json:"disabled"`
-	AllocationStrategy Strategy `json:"allocation_strategy"`
+	ReplicasDesired    *int              `json:"replicas_desired"`
+	Disabled           bool              `json:"disabled"`
+	AllocationStrategy Strategy          `json:"allocation_strategy"`
+	SpreadConstraint   *SpreadConstraint `json:"spread_constraint,omitempty"`
+	AntiAffinity       string            `json:"anti_affinity,omitempty"`
+	TransferNodes      []types.NodeName  `json:"transfer_nodes,omitempty"`
 }
 
 // MarshalJSON implements the json.Marshaler interface for serializing the RC to JSON
@@ -119,6 +165,11 @@ func (rc RC) ToRaw() (RawRC, error) {
 		nodeSel = rc.NodeSelector.String()
 	}
 
+	var antiAffinity string
+	if rc.AntiAffinity != nil {
+		antiAffinity = rc.AntiAffinity.String()
+	}
+
 	return RawRC{
 		ID:                 rc.ID,
 		Manifest:           string(manifest),
@@ -127,6 +178,9 @@ func (rc RC) ToRaw() (RawRC, error) {
 		ReplicasDesired:    &rc.ReplicasDesired,
 		Disabled:           rc.Disabled,
 		AllocationStrategy: rc.AllocationStrategy,
+		SpreadConstraint:   rc.SpreadConstraint,
+		AntiAffinity:       antiAffinity,
+		TransferNodes:      rc.TransferNodes,
 	}, nil
 }
 
@@ -153,11 +207,21 @@ func (rc *RC) UnmarshalJSON(b []byte) error {
 		}
 	}
 
-	nodeSel, err := labels.Parse(rawRC.NodeSelector)
+	nodeSel, err := labels.ParseSelector(rawRC.NodeSelector)
 	if err != nil {
 		return err
 	}
 
+	// an empty anti-affinity selector would match every pod, so it is
+	// left nil to mean that there is no anti-affinity
+	var antiAffinity klabels.Selector
+	if rawRC.AntiAffinity != "" {
+		antiAffinity, err = labels.ParseSelector(rawRC.AntiAffinity)
+		if err != nil {
+			return err
+		}
+	}
+
 	*rc = RC{
 		ID:                 rawRC.ID,
 		Manifest:           m,
@@ -166,6 +230,9 @@ func (rc *RC) UnmarshalJSON(b []byte) error {
 		ReplicasDesired:    *rawRC.ReplicasDesired,
 		Disabled:           rawRC.Disabled,
 		AllocationStrategy: rawRC.AllocationStrategy,
+		SpreadConstraint:   rawRC.SpreadConstraint,
+		AntiAffinity:       antiAffinity,
+		TransferNodes:      rawRC.TransferNodes,
 	}
 	return nil
 }
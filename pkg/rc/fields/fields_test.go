@@ -6,6 +6,7 @@ import (
 
 	. "github.com/anthonybishopric/gotcha"
 	"github.com/square/p2/pkg/manifest"
+	"k8s.io/kubernetes/pkg/labels"
 )
 
 func TestJSONMarshal(t *testing.T) {
@@ -42,3 +43,44 @@ func TestZeroUnmarshal(t *testing.T) {
 		t.Errorf("got an error unmarshaling an otherwise-empty RC with a replicas_desired count of 0: %s", err)
 	}
 }
+
+func TestSpreadConstraintJSONMarshal(t *testing.T) {
+	rc1 := RC{
+		ID:               "hello",
+		SpreadConstraint: &SpreadConstraint{Key: "rack", MaxSkew: 1},
+	}
+
+	b, err := json.Marshal(&rc1)
+	Assert(t).IsNil(err, "should have marshaled")
+
+	var rc2 RC
+	err = json.Unmarshal(b, &rc2)
+	Assert(t).IsNil(err, "should have unmarshaled")
+	if rc2.SpreadConstraint == nil || *rc2.SpreadConstraint != *rc1.SpreadConstraint {
+		t.Errorf("spread constraint changed when serialized: %+v", rc2.SpreadConstraint)
+	}
+}
+
+func TestAntiAffinityJSONMarshal(t *testing.T) {
+	rc1 := RC{
+		ID:           "hello",
+		AntiAffinity: labels.Everything().Add("latency_critical", labels.EqualsOperator, []string{"true"}),
+	}
+
+	b, err := json.Marshal(&rc1)
+	Assert(t).IsNil(err, "should have marshaled")
+
+	var rc2 RC
+	err = json.Unmarshal(b, &rc2)
+	Assert(t).IsNil(err, "should have unmarshaled")
+	if rc2.AntiAffinity == nil || rc2.AntiAffinity.String() != rc1.AntiAffinity.String() {
+		t.Errorf("anti-affinity selector changed when serialized: %v", rc2.AntiAffinity)
+	}
+
+	var rc3 RC
+	err = json.Unmarshal([]byte(`{"id":"hello","replicas_desired":0}`), &rc3)
+	Assert(t).IsNil(err, "should have unmarshaled")
+	if rc3.AntiAffinity != nil {
+		t.Errorf("expected no anti-affinity selector but got %v", rc3.AntiAffinity)
+	}
+}
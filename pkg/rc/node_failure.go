@@ -0,0 +1,240 @@
+package rc
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/square/p2/pkg/alerting"
+	"github.com/square/p2/pkg/logging"
+	"github.com/square/p2/pkg/store/consul/nodestore"
+	"github.com/square/p2/pkg/types"
+)
+
+// A NodeFailureDetector decides whether a node has failed even though its
+// labels may still make it eligible. Replication controllers treat failed
+// nodes as ineligible, so RCs with the dynamic allocation strategy transfer
+// their pods off of failed nodes.
+type NodeFailureDetector interface {
+	Failed(node types.NodeName) bool
+}
+
+type nopNodeFailureDetector struct{}
+
+func (nopNodeFailureDetector) Failed(types.NodeName) bool { return false }
+
+// subset of nodestore.ConsulStore
+type NodeRecordLister interface {
+	List() ([]nodestore.Record, error)
+}
+
+var _ NodeRecordLister = &nodestore.ConsulStore{}
+
+// NodeFailureMonitor is a NodeFailureDetector that watches the inventory
+// records published by each node's preparer. A node is considered failed when
+// its record hasn't changed within the timeout. Changes are detected by the
+// record's consul ModifyIndex and timed with the monitor's own clock, so
+// clock skew between nodes and the monitor doesn't matter. A record that is
+// merely not alive does not mean the node failed, because preparers release
+// their session whenever they restart. Nodes that have never published a
+// record are never considered failed. Nodes that are taken out of service for
+// good should be decommissioned with p2-node, which deletes their record, or
+// they will be considered failed forever.
+//
+// If more than maxFailedFraction of the nodes look failed at once, the
+// problem is more likely with consul or the monitor than with the nodes, so
+// no node is considered failed until enough of them recover.
+type NodeFailureMonitor struct {
+	records           NodeRecordLister
+	timeout           time.Duration
+	maxFailedFraction float64
+	pollInterval      time.Duration
+	alerter           alerting.Alerter
+	logger            logging.Logger
+
+	// lastChanges is only accessed by check
+	lastChanges map[types.NodeName]recordChange
+	tripped     bool
+
+	mu     sync.RWMutex
+	failed map[types.NodeName]struct{}
+}
+
+// recordChange is when the monitor saw a node's record at a modify index for
+// the first time
+type recordChange struct {
+	modifyIndex uint64
+	seenAt      time.Time
+}
+
+var _ NodeFailureDetector = &NodeFailureMonitor{}
+
+func NewNodeFailureMonitor(
+	records NodeRecordLister,
+	timeout time.Duration,
+	maxFailedFraction float64,
+	alerter alerting.Alerter,
+	logger logging.Logger,
+) *NodeFailureMonitor {
+	if alerter == nil {
+		alerter = alerting.NewNop()
+	}
+
+	// poll often enough that a failure is noticed soon after the timeout
+	pollInterval := timeout / 4
+	if pollInterval > 1*time.Minute {
+		pollInterval = 1 * time.Minute
+	}
+
+	return &NodeFailureMonitor{
+		records:           records,
+		timeout:           timeout,
+		maxFailedFraction: maxFailedFraction,
+		pollInterval:      pollInterval,
+		alerter:           alerter,
+		logger:            logger,
+		lastChanges:       make(map[types.NodeName]recordChange),
+		failed:            make(map[types.NodeName]struct{}),
+	}
+}
+
+// Run checks node records until quit is closed, alerting once for each node
+// as it fails.
+func (m *NodeFailureMonitor) Run(quit <-chan struct{}) {
+	ticker := time.NewTicker(m.pollInterval)
+	defer ticker.Stop()
+	for {
+		err := m.check(time.Now())
+		if err != nil {
+			m.logger.WithError(err).Errorln("Could not check node inventory records for failed nodes")
+		}
+
+		select {
+		case <-quit:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *NodeFailureMonitor) Failed(node types.NodeName) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.failed[node]
+	return ok
+}
+
+func (m *NodeFailureMonitor) check(now time.Time) error {
+	records, err := m.records.List()
+	if err != nil {
+		return err
+	}
+
+	lastChanges := make(map[types.NodeName]recordChange, len(records))
+	failed := make(map[types.NodeName]struct{})
+	var failedRecords []nodestore.Record
+	for _, record := range records {
+		change, ok := m.lastChanges[record.Node]
+		if !ok || change.modifyIndex != record.ModifyIndex {
+			change = recordChange{modifyIndex: record.ModifyIndex, seenAt: now}
+		}
+		lastChanges[record.Node] = change
+		if now.Sub(change.seenAt) <= m.timeout {
+			continue
+		}
+		failed[record.Node] = struct{}{}
+		failedRecords = append(failedRecords, record)
+	}
+	m.lastChanges = lastChanges
+
+	if len(records) > 0 && float64(len(failed))/float64(len(records)) > m.maxFailedFraction {
+		if !m.tripped {
+			m.tripped = true
+			m.logger.WithFields(logrus.Fields{
+				"failed": len(failed),
+				"nodes":  len(records),
+			}).Errorln("Too many nodes look failed, not treating any node as failed")
+			err := m.alerter.Alert(m.trippedAlertInfo(len(failed), len(records)), alerting.HighUrgency)
+			if err != nil {
+				m.logger.WithError(err).Errorln("Unable to send alert")
+			}
+		}
+		failed = make(map[types.NodeName]struct{})
+		failedRecords = nil
+	} else if m.tripped {
+		m.tripped = false
+		m.logger.Infoln("Few enough nodes look failed, treating failed nodes as failed again")
+	}
+
+	var newlyFailed []nodestore.Record
+	for _, record := range failedRecords {
+		if !m.Failed(record.Node) {
+			newlyFailed = append(newlyFailed, record)
+		}
+	}
+
+	m.mu.Lock()
+	for node := range m.failed {
+		if _, ok := failed[node]; !ok {
+			m.logger.WithField("node", node).Infoln("Node is no longer considered failed")
+		}
+	}
+	m.failed = failed
+	m.mu.Unlock()
+
+	for _, record := range newlyFailed {
+		m.logger.WithFields(logrus.Fields{
+			"node":         record.Node,
+			"modify_index": record.ModifyIndex,
+		}).Warnln("Node has failed")
+		err := m.alerter.Alert(m.alertInfo(record), alerting.LowUrgency)
+		if err != nil {
+			m.logger.WithError(err).Errorln("Unable to send alert")
+		}
+	}
+	return nil
+}
+
+func (m *NodeFailureMonitor) alertInfo(record nodestore.Record) alerting.AlertInfo {
+	return alerting.AlertInfo{
+		Description: fmt.Sprintf(
+			"Node %s has not published an inventory record in %s. Pods of replication controllers with the dynamic allocation strategy will be transferred off of it",
+			record.Node,
+			m.timeout,
+		),
+		IncidentKey: fmt.Sprintf("%s-node_failure", record.Node),
+		Details: struct {
+			Node            string `json:"node"`
+			Hostname        string `json:"hostname"`
+			PreparerVersion string `json:"preparer_version"`
+			Alive           bool   `json:"alive"`
+		}{
+			Node:            record.Node.String(),
+			Hostname:        record.Hostname,
+			PreparerVersion: record.PreparerVersion,
+			Alive:           record.Alive,
+		},
+	}
+}
+
+func (m *NodeFailureMonitor) trippedAlertInfo(failed int, nodes int) alerting.AlertInfo {
+	return alerting.AlertInfo{
+		Description: fmt.Sprintf(
+			"%d of %d nodes have not published an inventory record in %s. This is more than the %.0f%% that may be treated as failed, so no pods will be transferred off of them",
+			failed,
+			nodes,
+			m.timeout,
+			m.maxFailedFraction*100,
+		),
+		IncidentKey: "node_failure-too_many_failed",
+		Details: struct {
+			Failed int `json:"failed"`
+			Nodes  int `json:"nodes"`
+		}{
+			Failed: failed,
+			Nodes:  nodes,
+		},
+	}
+}
@@ -0,0 +1,156 @@
+package rc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/square/p2/pkg/alerting/alertingtest"
+	"github.com/square/p2/pkg/logging"
+	"github.com/square/p2/pkg/store/consul/nodestore"
+	"github.com/square/p2/pkg/types"
+)
+
+type fakeNodeRecords []nodestore.Record
+
+func (f *fakeNodeRecords) List() ([]nodestore.Record, error) {
+	return *f, nil
+}
+
+func nodeRecord(node types.NodeName, modifyIndex uint64, alive bool) nodestore.Record {
+	return nodestore.Record{
+		Inventory:   nodestore.Inventory{Node: node},
+		Alive:       alive,
+		ModifyIndex: modifyIndex,
+	}
+}
+
+func TestNodeFailureMonitor(t *testing.T) {
+	now := time.Now()
+	records := &fakeNodeRecords{
+		nodeRecord("healthy", 1, true),
+		nodeRecord("restarting", 1, false),
+		nodeRecord("dead", 1, false),
+		nodeRecord("stuck", 1, true),
+	}
+	alerter := alertingtest.NewRecorder()
+	monitor := NewNodeFailureMonitor(records, 10*time.Minute, 0.5, alerter, logging.TestLogger())
+
+	err := monitor.check(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range *records {
+		if monitor.Failed(record.Node) {
+			t.Errorf("expected %s not to be failed when first seen", record.Node)
+		}
+	}
+
+	// only the clock of the monitor matters, so a record whose UpdatedAt is
+	// far in the past or future doesn't change anything
+	now = now.Add(20 * time.Minute)
+	*records = fakeNodeRecords{
+		nodeRecord("healthy", 2, true),
+		nodeRecord("restarting", 3, false),
+		nodeRecord("dead", 1, false),
+		nodeRecord("stuck", 1, true),
+	}
+	(*records)[0].UpdatedAt = now.Add(-1 * time.Hour)
+	(*records)[3].UpdatedAt = now.Add(1 * time.Hour)
+	err = monitor.check(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for node, expected := range map[types.NodeName]bool{
+		"healthy":    false,
+		"restarting": false,
+		"dead":       true,
+		"stuck":      true,
+		"no_record":  false,
+	} {
+		if monitor.Failed(node) != expected {
+			t.Errorf("expected %s failed to be %t", node, expected)
+		}
+	}
+	if len(alerter.Alerts) != 2 {
+		t.Errorf("expected an alert for each failed node but there were %d", len(alerter.Alerts))
+	}
+
+	// failed nodes are only alerted on once, and recover once they publish
+	// a record again
+	(*records)[2] = nodeRecord("dead", 4, true)
+	err = monitor.check(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if monitor.Failed("dead") {
+		t.Error("expected the node to recover after publishing a record")
+	}
+	if !monitor.Failed("stuck") {
+		t.Error("expected the node to stay failed")
+	}
+	if len(alerter.Alerts) != 2 {
+		t.Errorf("expected no more alerts but there were %d in total", len(alerter.Alerts))
+	}
+}
+
+func TestNodeFailureMonitorTooManyFailed(t *testing.T) {
+	now := time.Now()
+	records := &fakeNodeRecords{
+		nodeRecord("node1", 1, true),
+		nodeRecord("node2", 1, true),
+		nodeRecord("node3", 1, true),
+		nodeRecord("node4", 1, true),
+	}
+	alerter := alertingtest.NewRecorder()
+	monitor := NewNodeFailureMonitor(records, 10*time.Minute, 0.5, alerter, logging.TestLogger())
+
+	err := monitor.check(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// three of four nodes stop publishing, which is more than the monitor
+	// may treat as failed
+	now = now.Add(20 * time.Minute)
+	(*records)[0] = nodeRecord("node1", 2, true)
+	err = monitor.check(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range *records {
+		if monitor.Failed(record.Node) {
+			t.Errorf("expected %s not to be failed while too many nodes look failed", record.Node)
+		}
+	}
+	if len(alerter.Alerts) != 1 {
+		t.Fatalf("expected a single alert about too many failed nodes but there were %d", len(alerter.Alerts))
+	}
+
+	err = monitor.check(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(alerter.Alerts) != 1 {
+		t.Errorf("expected no more alerts while too many nodes look failed but there were %d in total", len(alerter.Alerts))
+	}
+
+	// once enough nodes recover the remaining ones are treated as failed
+	(*records)[1] = nodeRecord("node2", 2, true)
+	err = monitor.check(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for node, expected := range map[types.NodeName]bool{
+		"node1": false,
+		"node2": false,
+		"node3": true,
+		"node4": true,
+	} {
+		if monitor.Failed(node) != expected {
+			t.Errorf("expected %s failed to be %t", node, expected)
+		}
+	}
+	if len(alerter.Alerts) != 3 {
+		t.Errorf("expected an alert for each failed node but there were %d in total", len(alerter.Alerts))
+	}
+}
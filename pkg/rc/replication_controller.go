@@ -12,12 +12,14 @@ import (
 
 	"github.com/square/p2/pkg/alerting"
 	"github.com/square/p2/pkg/artifact"
+	"github.com/square/p2/pkg/disruptionbudget"
 	grpc_scheduler "github.com/square/p2/pkg/grpc/scheduler/client"
 	"github.com/square/p2/pkg/health"
 	"github.com/square/p2/pkg/health/checker"
 	"github.com/square/p2/pkg/labels"
 	"github.com/square/p2/pkg/logging"
 	"github.com/square/p2/pkg/manifest"
+	pc_fields "github.com/square/p2/pkg/pc/fields"
 	"github.com/square/p2/pkg/pods"
 	"github.com/square/p2/pkg/rc/fields"
 	"github.com/square/p2/pkg/scheduler"
@@ -38,6 +40,10 @@ const (
 
 	// The maximum number of times to attempt a node allocate or deallocate
 	MaxAllocateAttempts = 10
+
+	// TransferRetryInterval is how often an RC retries transferring its
+	// pods off of the nodes it has been asked to transfer them off of
+	TransferRetryInterval = 5 * time.Second
 )
 
 type ReplicationController interface {
@@ -79,8 +85,40 @@ type Scheduler interface {
 }
 
 var _ Scheduler = &scheduler.ApplicatorScheduler{}
+var _ Scheduler = &scheduler.ResourceScheduler{}
 var _ Scheduler = &grpc_scheduler.Client{}
 
+// schedulerForRC returns the scheduler an RC should use. A ResourceScheduler
+// records reservations per RC, so each RC gets a copy scoped to it.
+func schedulerForRC(s Scheduler, rcID fields.ID) Scheduler {
+	if resources, ok := s.(*scheduler.ResourceScheduler); ok {
+		return resources.ForRC(rcID)
+	}
+	return s
+}
+
+// allocatingScheduler is implemented by schedulers that only treat the nodes
+// allocated to an RC as eligible. They must be told about pods that were
+// scheduled without an allocation, and the RC allocates nodes from them when it
+// runs out of eligible nodes and deallocates the nodes it removes pods from.
+// Other schedulers are only asked to allocate nodes for node transfers
+type allocatingScheduler interface {
+	ReserveExisting(manifest.Manifest, []types.NodeName) error
+}
+
+// reservationReleaser is implemented by schedulers that hold reservations for
+// an RC until they are released, so they must be told when the RC is deleted
+type reservationReleaser interface {
+	ReleaseReservations() error
+}
+
+// reservationOwnerLister is implemented by schedulers that hold reservations
+// for RCs, so that the farm can find the reservations of RCs that were deleted
+// while no farm owned them
+type reservationOwnerLister interface {
+	ReservationOwners() ([]fields.ID, error)
+}
+
 type ServiceDiscoveryChecker interface {
 	// IsSyncedWithCluster can be called by the RC when it needs to know that
 	// the service discovery system is up to date with P2's latest cluster
@@ -132,6 +170,8 @@ type replicationController struct {
 	healthChecker    checker.HealthChecker
 	artifactRegistry artifact.Registry
 	sdChecker        ServiceDiscoveryChecker
+	disruptions      disruptionbudget.Budgets
+	nodeFailures     NodeFailureDetector
 }
 
 type ReplicationControllerWatcher interface {
@@ -154,10 +194,18 @@ func New(
 	healthChecker checker.HealthChecker,
 	artifactRegistry artifact.Registry,
 	sdChecker ServiceDiscoveryChecker,
+	disruptions disruptionbudget.Budgets,
+	nodeFailures NodeFailureDetector,
 ) ReplicationController {
 	if alerter == nil {
 		alerter = alerting.NewNop()
 	}
+	if disruptions == nil {
+		disruptions = disruptionbudget.NewNop()
+	}
+	if nodeFailures == nil {
+		nodeFailures = nopNodeFailureDetector{}
+	}
 
 	return &replicationController{
 		rcID: rcID,
@@ -170,12 +218,14 @@ func New(
 		auditLogStore:    auditLogStore,
 		txner:            txner,
 		rcWatcher:        rcWatcher,
-		scheduler:        scheduler,
+		scheduler:        schedulerForRC(scheduler, rcID),
 		podApplicator:    podApplicator,
 		alerter:          alerter,
 		healthChecker:    healthChecker,
 		artifactRegistry: artifactRegistry,
 		sdChecker:        sdChecker,
+		disruptions:      disruptions,
+		nodeFailures:     nodeFailures,
 	}
 }
 
@@ -192,8 +242,25 @@ func (rc *replicationController) WatchDesires(quit <-chan struct{}) <-chan error
 
 	// When seeing any changes, try to meet them.
 	// If meeting produces any error, send it on the output error channel.
+	// While the RC has pods on nodes it was asked to transfer them off of,
+	// its desires are also met again every TransferRetryInterval.
 	go func() {
-		for rcFields := range rcChanges {
+		defer func() {
+			channelsClosed <- struct{}{}
+		}()
+
+		var rcFields fields.RC
+		var retryTransfers <-chan time.Time
+		for {
+			select {
+			case changed, ok := <-rcChanges:
+				if !ok {
+					return
+				}
+				rcFields = changed
+			case <-retryTransfers:
+			}
+
 			select {
 			case <-shouldCheck:
 				rc.checkMissingArtifacts(rcFields)
@@ -204,8 +271,16 @@ func (rc *replicationController) WatchDesires(quit <-chan struct{}) <-chan error
 			if err != nil {
 				errOutChannel <- err
 			}
+
+			retryTransfers = nil
+			pending, err := rc.pendingTransfers(rcFields)
+			if err != nil {
+				errOutChannel <- err
+			} else if len(pending) > 0 {
+				rc.logger.WithField("nodes", pending).Infof("Waiting to transfer pods off of %d requested nodes", len(pending))
+				retryTransfers = time.After(TransferRetryInterval)
+			}
 		}
-		channelsClosed <- struct{}{}
 	}()
 
 	// When seeing any errors, forward them to the output error channel.
@@ -238,6 +313,10 @@ func (rc *replicationController) meetDesires(rcFields fields.RC) error {
 	if err != nil {
 		return err
 	}
+	err = rc.reserveExisting(rcFields, current)
+	if err != nil {
+		return err
+	}
 	eligible, err := rc.eligibleNodes(rcFields)
 	if err != nil {
 		return err
@@ -298,14 +377,45 @@ func (rc *replicationController) addPods(rcFields fields.RC, current types.PodLo
 	// TODO: With Docker or runc we would not be constrained to running only once per node.
 	// So it may be the case that we need to make the Scheduler interface smarter and use it here.
 	possible := types.NewNodeSet(eligible...).Difference(types.NewNodeSet(currentNodes...))
+	toSchedule := rcFields.ReplicasDesired - len(currentNodes)
+
+	// RCs that allocate nodes ask the scheduler for more when there aren't
+	// enough eligible nodes. If that fails the RC is alerted on below
+	_, allocating := rc.scheduler.(allocatingScheduler)
+	if shortfall := toSchedule - possible.Len(); shortfall > 0 && allocating && rcFields.AllocationStrategy == fields.DynamicStrategy {
+		allocated, err := rc.allocateNodes(rcFields, shortfall)
+		if err != nil {
+			rc.logger.WithError(err).Warnf("Could not allocate %d more nodes", shortfall)
+		} else {
+			eligible = append(append([]types.NodeName{}, eligible...), allocated...)
+			possible = types.NewNodeSet(eligible...).Difference(types.NewNodeSet(currentNodes...))
+		}
+	}
 
 	// Users want deterministic ordering of nodes being populated to a new
 	// RC. Move nodes in sorted order by hostname to achieve this
 	possibleSorted := possible.ListNodes()
-	toSchedule := rcFields.ReplicasDesired - len(currentNodes)
 
 	rc.logger.NoFields().Infof("Need to schedule %d nodes out of %s", toSchedule, possible)
 
+	if rcFields.SpreadConstraint != nil {
+		// Instead of hostname order, fill the least populated failure
+		// domains first
+		domains, err := newSpreadDomains(rc.podApplicator, *rcFields.SpreadConstraint, currentNodes, eligible)
+		if err != nil {
+			return err
+		}
+		possibleSorted = domains.scheduleOrder(possibleSorted, toSchedule, rcFields.SpreadConstraint.MaxSkew)
+		if len(possibleSorted) < toSchedule && len(possibleSorted) < possible.Len() {
+			rc.logger.NoFields().Warnf(
+				"Only %d nodes can be scheduled without exceeding the max skew of %d across %q",
+				len(possibleSorted),
+				rcFields.SpreadConstraint.MaxSkew,
+				rcFields.SpreadConstraint.Key,
+			)
+		}
+	}
+
 	txn, cancelFunc := rc.newAuditingTransaction(context.Background(), rcFields, currentNodes)
 	defer func() {
 		// we write the defer this way so that reassignments to cancelFunc
@@ -407,6 +517,24 @@ func (rc *replicationController) removePods(rcFields fields.RC, current types.Po
 	toUnschedule := len(current) - rcFields.ReplicasDesired
 	rc.logger.NoFields().Infof("Need to unschedule %d nodes out of %s", toUnschedule, current)
 
+	// With a spread constraint, eligible nodes are removed from the
+	// failure domains with the most replicas first
+	var domains *spreadDomains
+	if rcFields.SpreadConstraint != nil {
+		d, err := newSpreadDomains(rc.podApplicator, *rcFields.SpreadConstraint, currentNodes, eligible)
+		if err != nil {
+			return err
+		}
+		domains = &d
+	}
+
+	// nodes are deallocated once the transactions unscheduling from them
+	// have been committed, including when a later one fails
+	var unscheduled, pending []types.NodeName
+	defer func() {
+		rc.deallocateUnscheduled(rcFields, unscheduled)
+	}()
+
 	txn, cancelFunc := rc.newAuditingTransaction(context.Background(), rcFields, currentNodes)
 	defer func() {
 		cancelFunc()
@@ -424,6 +552,7 @@ func (rc *replicationController) removePods(rcFields fields.RC, current types.Po
 			case !ok:
 				return util.Errorf("could not schedule pods due to transaction violation: %s", transaction.TxnErrorsToString(resp.Errors))
 			}
+			unscheduled, pending = append(unscheduled, pending...), nil
 
 			cancelFunc()
 			txn, cancelFunc = rc.newAuditingTransaction(context.Background(), rcFields, txn.Nodes())
@@ -432,7 +561,11 @@ func (rc *replicationController) removePods(rcFields fields.RC, current types.Po
 		unscheduleFrom, ok := ineligible.PopAny()
 		if !ok {
 			var ok bool
-			unscheduleFrom, ok = rest.PopAny()
+			if domains != nil {
+				unscheduleFrom, ok = domains.popMostPopulated(rest)
+			} else {
+				unscheduleFrom, ok = rest.PopAny()
+			}
 			if !ok {
 				// This should be mathematically impossible unless replicasDesired was negative
 				// commit any queued operations
@@ -443,6 +576,7 @@ func (rc *replicationController) removePods(rcFields fields.RC, current types.Po
 				case !ok:
 					return util.Errorf("could not schedule pods due to transaction violation: %s", transaction.TxnErrorsToString(resp.Errors))
 				}
+				unscheduled = append(unscheduled, pending...)
 
 				return util.Errorf(
 					"Unable to unschedule enough nodes to meet replicas desired: %d replicas desired, %d current.",
@@ -455,6 +589,10 @@ func (rc *replicationController) removePods(rcFields fields.RC, current types.Po
 		if err != nil {
 			return err
 		}
+		pending = append(pending, unscheduleFrom)
+		if domains != nil {
+			domains.unscheduled(unscheduleFrom)
+		}
 	}
 
 	ok, resp, err := txn.Commit(rc.txner)
@@ -464,10 +602,25 @@ func (rc *replicationController) removePods(rcFields fields.RC, current types.Po
 	case !ok:
 		return util.Errorf("could not schedule pods due to transaction violation: %s", transaction.TxnErrorsToString(resp.Errors))
 	}
+	unscheduled = append(unscheduled, pending...)
 
 	return nil
 }
 
+// deallocateUnscheduled tells the scheduler that the RC's pods were removed
+// from nodes, so that the resources reserved for them are freed. Only RCs that
+// allocate nodes from an allocating scheduler hold reservations.
+func (rc *replicationController) deallocateUnscheduled(rcFields fields.RC, nodes []types.NodeName) {
+	_, allocating := rc.scheduler.(allocatingScheduler)
+	if !allocating || rcFields.AllocationStrategy != fields.DynamicStrategy || len(nodes) == 0 {
+		return
+	}
+	err := rc.scheduler.DeallocateNodes(rcFields.NodeSelector, nodes)
+	if err != nil {
+		rc.logger.WithError(err).Errorf("Could not release unscheduled nodes %s", nodes)
+	}
+}
+
 func (rc *replicationController) ensureConsistency(rcFields fields.RC) error {
 	if rcFields.Disabled {
 		return nil
@@ -568,7 +721,101 @@ func (rc *replicationController) checkForIneligible(current types.PodLocations,
 }
 
 func (rc *replicationController) eligibleNodes(rcFields fields.RC) ([]types.NodeName, error) {
-	return rc.scheduler.EligibleNodes(rcFields.Manifest, rcFields.NodeSelector)
+	eligible, err := rc.schedulerFor(rcFields).EligibleNodes(rcFields.Manifest, rcFields.NodeSelector)
+	if err != nil {
+		return nil, err
+	}
+	return rc.usableNodes(rcFields, eligible)
+}
+
+// usableNodes removes the nodes that the RC can't schedule on even though the
+// scheduler considers them eligible
+func (rc *replicationController) usableNodes(rcFields fields.RC, nodes []types.NodeName) ([]types.NodeName, error) {
+	nodes = rc.withoutFailedNodes(nodes)
+	if len(rcFields.TransferNodes) > 0 {
+		nodes = types.NewNodeSet(nodes...).Difference(types.NewNodeSet(rcFields.TransferNodes...)).ListNodes()
+	}
+
+	if rcFields.AntiAffinity == nil {
+		return nodes, nil
+	}
+	return rc.withoutAntiAffinityConflicts(rcFields, nodes)
+}
+
+// schedulerFor returns the scheduler to find the RC's eligible nodes with. A
+// ResourceScheduler only treats the nodes allocated to an RC as eligible, and
+// only RCs with the dynamic allocation strategy allocate nodes, so other RCs
+// are eligible for every node matching their selector instead.
+func (rc *replicationController) schedulerFor(rcFields fields.RC) Scheduler {
+	if resources, ok := rc.scheduler.(*scheduler.ResourceScheduler); ok && rcFields.AllocationStrategy != fields.DynamicStrategy {
+		return resources.SelectorScheduler()
+	}
+	return rc.scheduler
+}
+
+// reserveExisting tells the scheduler about the RC's current pods, if it
+// needs to be, so that pods scheduled before their nodes were allocated to the
+// RC stay eligible
+func (rc *replicationController) reserveExisting(rcFields fields.RC, current types.PodLocations) error {
+	reserver, ok := rc.scheduler.(allocatingScheduler)
+	if !ok || rcFields.AllocationStrategy != fields.DynamicStrategy || len(current) == 0 {
+		return nil
+	}
+	return reserver.ReserveExisting(rcFields.Manifest, current.Nodes())
+}
+
+// allocateNodes asks the scheduler to allocate count more nodes to the RC.
+// Allocated nodes that the RC can't use, e.g. because they have failed, are
+// released again
+func (rc *replicationController) allocateNodes(rcFields fields.RC, count int) ([]types.NodeName, error) {
+	allocated, err := rc.scheduler.AllocateNodes(rcFields.Manifest, rcFields.NodeSelector, count, false)
+	if err != nil {
+		return nil, err
+	}
+	usable, err := rc.usableNodes(rcFields, allocated)
+	if err != nil {
+		return nil, err
+	}
+
+	unusable := types.NewNodeSet(allocated...).Difference(types.NewNodeSet(usable...)).ListNodes()
+	if len(unusable) > 0 {
+		err = rc.scheduler.DeallocateNodes(rcFields.NodeSelector, unusable)
+		if err != nil {
+			rc.logger.WithError(err).Errorf("Could not release allocated nodes %s", unusable)
+		}
+	}
+	return usable, nil
+}
+
+// pendingTransfers returns the nodes the RC has been asked to transfer its
+// pods off of that it still has pods on. Only RCs with the dynamic allocation
+// strategy transfer pods.
+func (rc *replicationController) pendingTransfers(rcFields fields.RC) ([]types.NodeName, error) {
+	if rcFields.AllocationStrategy != fields.DynamicStrategy || len(rcFields.TransferNodes) == 0 {
+		return nil, nil
+	}
+
+	current, err := rc.CurrentPods()
+	if err != nil {
+		return nil, err
+	}
+	return types.NewNodeSet(current.Nodes()...).Intersection(types.NewNodeSet(rcFields.TransferNodes...)).ListNodes(), nil
+}
+
+// withoutFailedNodes removes the nodes the node failure detector considers
+// failed. Their pods are then treated like pods on ineligible nodes: they are
+// transferred if the RC has the dynamic allocation strategy and are preferred
+// when unscheduling.
+func (rc *replicationController) withoutFailedNodes(eligible []types.NodeName) []types.NodeName {
+	var ret []types.NodeName
+	for _, node := range eligible {
+		if rc.nodeFailures.Failed(node) {
+			rc.logger.WithField("node", node).Debugln("Treating failed node as ineligible")
+			continue
+		}
+		ret = append(ret, node)
+	}
+	return ret
 }
 
 // CurrentPods returns all pods managed by an RC with the given ID.
@@ -667,6 +914,8 @@ func (rc *replicationController) unschedule(txn *auditingTransaction, rcFields f
 //   3) The RC can acquire a mutation lock on its ID
 //   4) OR the RC is disabled, only on ineligible nodes, and has fewer desired
 //      replicas than current (described more in canNodeTransfer())
+//   5) AND the disruption budgets of the pods allow the ineligible pod to be
+//      taken down, which is reserved in the same transaction as the transfer
 // If the conditions are not met, the function will be called again on the next
 // call of meetDesires() should a node still be ineligible. It returns true
 // when a node transfer occurs
@@ -691,6 +940,18 @@ func (rc *replicationController) attemptNodeTransfer(rcFields fields.RC, current
 	}
 
 	ineligible := ineligibles[0]
+	// check the disruption budget before allocating a new node. The
+	// reservation itself is made by swapNodes()
+	err = rc.disruptions.Check(rc.disruptionRequest(rcFields, ineligible))
+	switch {
+	case disruptionbudget.IsBudgetExceeded(err):
+		rc.logger.WithError(err).Infoln("skipping node transfer; disruption budget does not allow it")
+		return false, nil
+	case err != nil:
+		rc.logger.WithError(err).Errorln("skipping node transfer; error checking disruption budget")
+		return false, err
+	}
+
 	err = rc.swapNodes(rcFields, current, ineligible, allocAttempts)
 	if err != nil {
 		rc.logger.WithError(err).Errorln("could not swap nodes")
@@ -842,6 +1103,11 @@ func (rc *replicationController) swapNodes(rcFields fields.RC, current types.Pod
 	txn, cancelFunc := rc.newAuditingTransaction(ctx, rcFields, current.Nodes())
 	defer cancelFunc()
 
+	err = rc.disruptions.ReserveTxn(txn.Context(), rc.disruptionRequest(rcFields, ineligible))
+	if err != nil {
+		allocErr.err = err
+		return &allocErr
+	}
 	err = rc.unschedule(txn, rcFields, ineligible)
 	if err != nil {
 		allocErr.err = err
@@ -858,7 +1124,8 @@ func (rc *replicationController) swapNodes(rcFields fields.RC, current types.Pod
 		allocErr.err = util.Errorf("schedule and unschedule transaction could not complete within timeout: %s", err)
 		return &allocErr
 	} else if !ok {
-		// This doesn't make sense because there are no "check" conditions in the transaction
+		// The only "check" conditions in the transaction are on the
+		// disruption budgets, which may have changed since they were read
 		allocErr.err = util.Errorf(
 			"transaction violation trying to swap nodes: %s",
 			transaction.TxnErrorsToString(resp.Errors),
@@ -869,6 +1136,18 @@ func (rc *replicationController) swapNodes(rcFields fields.RC, current types.Pod
 	return nil
 }
 
+// disruptionRequest returns a request to the disruption budgets of the RC's
+// pods to take down the pod on node.
+func (rc *replicationController) disruptionRequest(rcFields fields.RC, node types.NodeName) disruptionbudget.Request {
+	return disruptionbudget.Request{
+		PodID:            rcFields.Manifest.ID(),
+		AvailabilityZone: pc_fields.AvailabilityZone(rcFields.PodLabels[types.AvailabilityZoneLabel]),
+		ClusterName:      pc_fields.ClusterName(rcFields.PodLabels[types.ClusterNameLabel]),
+		Nodes:            []types.NodeName{node},
+		Holder:           fmt.Sprintf("rc-node-transfer-%s", rc.rcID),
+	}
+}
+
 func (rc *replicationController) retryDeallocate(rcFields fields.RC, ineligible types.NodeName, attempts int) error {
 	for i := 0; i < attempts; i++ {
 		backoff := time.Duration(math.Pow(float64(i), 2)) * time.Second
@@ -6,6 +6,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"reflect"
 	"sync"
 	"testing"
 	"time"
@@ -177,6 +178,8 @@ func setup(t *testing.T) (
 		healthChecker,
 		artifactRegistry,
 		sdChecker,
+		nil,
+		nil,
 	).(*replicationController)
 
 	return
@@ -1371,6 +1374,122 @@ func TestNodeTransferWhenIneligibleNodeUnhealthy(t *testing.T) {
 	}
 }
 
+type fakeNodeFailures map[types.NodeName]bool
+
+func (f fakeNodeFailures) Failed(node types.NodeName) bool {
+	return f[node]
+}
+
+func TestNodeTransferOffFailedNode(t *testing.T) {
+	_, _, applicator, rc, _, _, _, closeFn := setup(t)
+	defer closeFn()
+
+	rcFields := fields.RC{
+		ID:                 rc.rcID,
+		ReplicasDesired:    3,
+		Manifest:           testManifest(),
+		Disabled:           false,
+		NodeSelector:       klabels.Everything().Add("nodeQuality", klabels.EqualsOperator, []string{"good"}),
+		AllocationStrategy: fields.DynamicStrategy,
+	}
+
+	for i := 0; i < 3; i++ {
+		err := applicator.SetLabel(labels.NODE, fmt.Sprintf("node%d", i), "nodeQuality", "good")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := rc.meetDesires(rcFields)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// node2 still matches the node selector, but its preparer has stopped
+	// heartbeating so it has no health results either
+	rc.nodeFailures = fakeNodeFailures{"node2": true}
+	rc.healthChecker = fake_checker.NewSingleService("some_pod", map[types.NodeName]health.Result{
+		"node0": {Status: health.Passing},
+		"node1": {Status: health.Passing},
+	})
+
+	err = rc.meetDesires(rcFields)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	current, err := rc.CurrentPods()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := types.NewNodeSet("node0", "node1", newTransferNode)
+	if actual := types.NewNodeSet(current.Nodes()...); !actual.Equal(expected) {
+		t.Fatalf("expected current nodes to be %v after transferring off the failed node, was %v", expected, actual)
+	}
+}
+
+func TestNodeTransferOffRequestedNode(t *testing.T) {
+	_, _, applicator, rc, _, _, _, closeFn := setup(t)
+	defer closeFn()
+
+	rcFields := fields.RC{
+		ID:                 rc.rcID,
+		ReplicasDesired:    3,
+		Manifest:           testManifest(),
+		Disabled:           false,
+		NodeSelector:       klabels.Everything().Add("nodeQuality", klabels.EqualsOperator, []string{"good"}),
+		AllocationStrategy: fields.DynamicStrategy,
+	}
+
+	for i := 0; i < 3; i++ {
+		err := applicator.SetLabel(labels.NODE, fmt.Sprintf("node%d", i), "nodeQuality", "good")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := rc.meetDesires(rcFields)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// node2 still matches the node selector, but the RC has been asked to
+	// transfer its pod off of it, e.g. by a node drain
+	rcFields.TransferNodes = []types.NodeName{"node2"}
+	pending, err := rc.pendingTransfers(rcFields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(pending, []types.NodeName{"node2"}) {
+		t.Fatalf("expected a transfer off of node2 to be pending, was %v", pending)
+	}
+
+	rc.healthChecker = fake_checker.NewSingleService("some_pod", map[types.NodeName]health.Result{
+		"node0": {Status: health.Passing},
+		"node1": {Status: health.Passing},
+		"node2": {Status: health.Passing},
+	})
+	err = rc.meetDesires(rcFields)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	current, err := rc.CurrentPods()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := types.NewNodeSet("node0", "node1", newTransferNode)
+	if actual := types.NewNodeSet(current.Nodes()...); !actual.Equal(expected) {
+		t.Fatalf("expected current nodes to be %v after transferring off the requested node, was %v", expected, actual)
+	}
+
+	pending, err = rc.pendingTransfers(rcFields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 0 {
+		t.Errorf("expected no pending transfers after transferring off of node2, was %v", pending)
+	}
+}
+
 func TestNodeTransferDoesNotAlertIfAllocateFails(t *testing.T) {
 	_, _, applicator, rc, alerter, _, _, closeFn := setup(t)
 	defer closeFn()
@@ -1517,3 +1636,381 @@ func TestNodeTransferNoopIfServiceDiscoveryNotSynced(t *testing.T) {
 		t.Fatalf("expected current nodes to be %v, was %v", expected, actual)
 	}
 }
+
+func setupSpreadNodes(t *testing.T, applicator testApplicator) {
+	racks := map[types.NodeName]string{
+		"node1": "a",
+		"node2": "a",
+		"node3": "a",
+		"node4": "b",
+		"node5": "c",
+		"node6": "c",
+	}
+	for node, rack := range racks {
+		err := applicator.SetLabel(labels.NODE, node.String(), "rack", rack)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func racksOf(t *testing.T, applicator testApplicator, pods types.PodLocations) map[string]int {
+	racks := make(map[string]int)
+	for _, node := range pods.Nodes() {
+		nodeLabels, err := applicator.GetLabels(labels.NODE, node.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		racks[nodeLabels.Labels.Get("rack")]++
+	}
+	return racks
+}
+
+func TestAddPodsSpread(t *testing.T) {
+	_, _, applicator, rc, _, _, _, closeFn := setup(t)
+	defer closeFn()
+	setupSpreadNodes(t, applicator)
+
+	rcFields := fields.RC{
+		ID:               rc.rcID,
+		ReplicasDesired:  3,
+		Manifest:         testManifest(),
+		NodeSelector:     klabels.Everything(),
+		SpreadConstraint: &fields.SpreadConstraint{Key: "rack", MaxSkew: 1},
+	}
+	eligible := []types.NodeName{"node1", "node2", "node3", "node4", "node5", "node6"}
+
+	err := rc.addPods(rcFields, types.PodLocations{}, eligible)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	currentPods, err := rc.CurrentPods()
+	if err != nil {
+		t.Fatal(err)
+	}
+	racks := racksOf(t, applicator, currentPods)
+	if racks["a"] != 1 || racks["b"] != 1 || racks["c"] != 1 {
+		t.Fatalf("expected one pod in each rack but got %v", racks)
+	}
+
+	// rack b only has one node, so only 2 more pods can be scheduled
+	// before the skew would exceed 1
+	rcFields.ReplicasDesired = 6
+	err = rc.addPods(rcFields, currentPods, eligible)
+	if err == nil {
+		t.Fatal("expected an error when the max skew prevents meeting desired replicas")
+	}
+
+	currentPods, err = rc.CurrentPods()
+	if err != nil {
+		t.Fatal(err)
+	}
+	racks = racksOf(t, applicator, currentPods)
+	if racks["a"] != 2 || racks["b"] != 1 || racks["c"] != 2 {
+		t.Fatalf("expected pods to be spread 2, 1, 2 across racks but got %v", racks)
+	}
+}
+
+func TestRemovePodsSpread(t *testing.T) {
+	_, _, applicator, rc, _, _, _, closeFn := setup(t)
+	defer closeFn()
+	setupSpreadNodes(t, applicator)
+
+	rcFields := fields.RC{
+		ID:               rc.rcID,
+		ReplicasDesired:  5,
+		Manifest:         testManifest(),
+		NodeSelector:     klabels.Everything(),
+		SpreadConstraint: &fields.SpreadConstraint{Key: "rack", MaxSkew: 1},
+	}
+	eligible := []types.NodeName{"node1", "node2", "node3", "node4", "node5", "node6"}
+
+	err := rc.addPods(rcFields, types.PodLocations{}, eligible)
+	if err != nil {
+		t.Fatal(err)
+	}
+	currentPods, err := rc.CurrentPods()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rcFields.ReplicasDesired = 3
+	err = rc.removePods(rcFields, currentPods, eligible)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	currentPods, err = rc.CurrentPods()
+	if err != nil {
+		t.Fatal(err)
+	}
+	racks := racksOf(t, applicator, currentPods)
+	if racks["a"] != 1 || racks["b"] != 1 || racks["c"] != 1 {
+		t.Fatalf("expected pods to be removed from the most populated racks leaving one in each but got %v", racks)
+	}
+}
+
+func TestEligibleNodesAntiAffinity(t *testing.T) {
+	_, _, applicator, rc, _, _, _, closeFn := setup(t)
+	defer closeFn()
+
+	for _, node := range []string{"node1", "node2", "node3"} {
+		err := applicator.SetLabel(labels.NODE, node, "nodeQuality", "good")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a latency critical pod from another RC on node1
+	err := applicator.SetLabels(labels.POD, "node1/other_pod", map[string]string{
+		"latency_critical": "true",
+		RCIDLabel:          "other_rc",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// and one of this RC's own pods on node2
+	err = applicator.SetLabels(labels.POD, "node2/testPod", map[string]string{
+		"latency_critical": "true",
+		RCIDLabel:          rc.rcID.String(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rcFields := fields.RC{
+		ID:           rc.rcID,
+		Manifest:     testManifest(),
+		NodeSelector: klabels.Everything().Add("nodeQuality", klabels.EqualsOperator, []string{"good"}),
+	}
+	eligible, err := rc.eligibleNodes(rcFields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(eligible) != 3 {
+		t.Fatalf("expected all 3 nodes to be eligible without anti-affinity but got %s", eligible)
+	}
+
+	rcFields.AntiAffinity = klabels.Everything().Add("latency_critical", klabels.EqualsOperator, []string{"true"})
+	eligible, err = rc.eligibleNodes(rcFields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := types.NewNodeSet("node2", "node3")
+	if !types.NewNodeSet(eligible...).Equal(expected) {
+		t.Fatalf("expected %s to be eligible but got %s", expected.ListNodes(), eligible)
+	}
+}
+
+func TestAntiAffinityDuringRollingUpdate(t *testing.T) {
+	_, _, applicator, rc, _, _, _, closeFn := setup(t)
+	defer closeFn()
+
+	for _, node := range []string{"node1", "node2", "node3"} {
+		err := applicator.SetLabel(labels.NODE, node, "nodeQuality", "good")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the old RC of the rolling update still runs the pod on node1 and
+	// node2, and a different latency critical pod runs on node3
+	rcFields := fields.RC{
+		ID:              rc.rcID,
+		ReplicasDesired: 2,
+		Manifest:        testManifest(),
+		NodeSelector:    klabels.Everything().Add("nodeQuality", klabels.EqualsOperator, []string{"good"}),
+		AntiAffinity:    klabels.Everything().Add("latency_critical", klabels.EqualsOperator, []string{"true"}),
+	}
+	for _, node := range []string{"node1", "node2"} {
+		err := applicator.SetLabels(labels.POD, node+"/"+rcFields.Manifest.ID().String(), map[string]string{
+			"latency_critical": "true",
+			RCIDLabel:          "old_rc",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := applicator.SetLabels(labels.POD, "node3/other_pod", map[string]string{
+		"latency_critical": "true",
+		RCIDLabel:          "other_rc",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	eligible, err := rc.eligibleNodes(rcFields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := types.NewNodeSet("node1", "node2")
+	if !types.NewNodeSet(eligible...).Equal(expected) {
+		t.Fatalf("expected the old RC's nodes %s to be eligible for the new RC but got %s", expected.ListNodes(), eligible)
+	}
+
+	err = rc.addPods(rcFields, types.PodLocations{}, eligible)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := rc.CurrentPods()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !types.NewNodeSet(current.Nodes()...).Equal(expected) {
+		t.Fatalf("expected the new RC to replace the old RC's pods on %s but it has pods on %s", expected.ListNodes(), current.Nodes())
+	}
+}
+
+func TestResourceSchedulerAllocatesNodes(t *testing.T) {
+	_, _, applicator, rc, _, _, _, closeFn := setup(t)
+	defer closeFn()
+
+	for _, node := range []string{"node1", "node2"} {
+		err := applicator.SetLabel(labels.NODE, node, "nodeQuality", "good")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	rcFields := fields.RC{
+		ID:                 rc.rcID,
+		ReplicasDesired:    1,
+		Manifest:           testManifest(),
+		NodeSelector:       klabels.Everything().Add("nodeQuality", klabels.EqualsOperator, []string{"good"}),
+		AllocationStrategy: fields.DynamicStrategy,
+	}
+
+	// the first pod is scheduled before the RC uses a resource scheduler,
+	// so its node has no reservation
+	err := rc.meetDesires(rcFields)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resources := scheduler.NewResourceScheduler(applicator, rc.consulClient.KV(), rc.consulClient.KV(), scheduler.BinPackPolicy)
+	rc.scheduler = schedulerForRC(resources, rc.rcID)
+	rcFields.ReplicasDesired = 2
+	err = rc.meetDesires(rcFields)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	current, err := rc.CurrentPods()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := types.NewNodeSet("node1", "node2")
+	if actual := types.NewNodeSet(current.Nodes()...); !actual.Equal(expected) {
+		t.Fatalf("expected the existing pod to be kept and a node to be allocated for the new one, but the RC is on %s", current.Nodes())
+	}
+	eligible, err := rc.eligibleNodes(rcFields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := types.NewNodeSet(eligible...); !actual.Equal(expected) {
+		t.Errorf("expected the nodes of both pods to be allocated to the RC but %s were eligible", eligible)
+	}
+}
+
+func TestRemovePodsDeallocatesNodes(t *testing.T) {
+	_, _, applicator, rc, _, _, _, closeFn := setup(t)
+	defer closeFn()
+
+	for _, node := range []string{"node1", "node2"} {
+		err := applicator.SetLabel(labels.NODE, node, "nodeQuality", "good")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	resources := scheduler.NewResourceScheduler(applicator, rc.consulClient.KV(), rc.consulClient.KV(), scheduler.BinPackPolicy)
+	rc.scheduler = schedulerForRC(resources, rc.rcID)
+	rcFields := fields.RC{
+		ID:                 rc.rcID,
+		ReplicasDesired:    2,
+		Manifest:           testManifest(),
+		NodeSelector:       klabels.Everything().Add("nodeQuality", klabels.EqualsOperator, []string{"good"}),
+		AllocationStrategy: fields.DynamicStrategy,
+	}
+	err := rc.meetDesires(rcFields)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rcFields.ReplicasDesired = 1
+	err = rc.meetDesires(rcFields)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	current, err := rc.CurrentPods()
+	if err != nil {
+		t.Fatal(err)
+	}
+	eligible, err := rc.scheduler.EligibleNodes(rcFields.Manifest, rcFields.NodeSelector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !types.NewNodeSet(eligible...).Equal(types.NewNodeSet(current.Nodes()...)) {
+		t.Errorf("expected only the node still running a pod (%s) to stay allocated, but %s were", current.Nodes(), eligible)
+	}
+}
+
+// countingScheduler counts the nodes it is asked to allocate and deallocate
+type countingScheduler struct {
+	testScheduler
+	allocated   int
+	deallocated int
+}
+
+func (s *countingScheduler) AllocateNodes(manifest manifest.Manifest, nodeSelector klabels.Selector, allocationCount int, force bool) ([]types.NodeName, error) {
+	s.allocated += allocationCount
+	return s.testScheduler.AllocateNodes(manifest, nodeSelector, allocationCount, force)
+}
+
+func (s *countingScheduler) DeallocateNodes(nodeSelector klabels.Selector, nodes []types.NodeName) error {
+	s.deallocated += len(nodes)
+	return s.testScheduler.DeallocateNodes(nodeSelector, nodes)
+}
+
+func TestOnlyAllocatingSchedulersAllocateOnShortfall(t *testing.T) {
+	_, _, applicator, rc, _, _, _, closeFn := setup(t)
+	defer closeFn()
+
+	err := applicator.SetLabel(labels.NODE, "node1", "nodeQuality", "good")
+	if err != nil {
+		t.Fatal(err)
+	}
+	counting := &countingScheduler{testScheduler: testScheduler{applicator: applicator}}
+	rc.scheduler = counting
+	rcFields := fields.RC{
+		ID:                 rc.rcID,
+		ReplicasDesired:    2,
+		Manifest:           testManifest(),
+		NodeSelector:       klabels.Everything().Add("nodeQuality", klabels.EqualsOperator, []string{"good"}),
+		AllocationStrategy: fields.DynamicStrategy,
+	}
+	// with a single eligible node the RC can't meet its desire
+	err = rc.meetDesires(rcFields)
+	if err == nil {
+		t.Fatal("expected an error scheduling 2 replicas on 1 eligible node")
+	}
+	if counting.allocated != 0 {
+		t.Errorf("expected a scheduler that doesn't allocate nodes to RCs not to be asked for more, but %d nodes were allocated", counting.allocated)
+	}
+
+	rcFields.ReplicasDesired = 0
+	err = rc.meetDesires(rcFields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := rc.CurrentPods()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(current) != 0 {
+		t.Fatalf("expected the RC to be scaled down but it has pods on %s", current.Nodes())
+	}
+	if counting.deallocated != 0 {
+		t.Errorf("expected a scheduler that doesn't allocate nodes to RCs not to be told about removed pods, but %d nodes were deallocated", counting.deallocated)
+	}
+}
@@ -0,0 +1,139 @@
+package rc
+
+import (
+	"sort"
+
+	klabels "k8s.io/kubernetes/pkg/labels"
+
+	"github.com/square/p2/pkg/labels"
+	"github.com/square/p2/pkg/rc/fields"
+	"github.com/square/p2/pkg/types"
+)
+
+// spreadDomains tracks how many of an RC's replicas are in each of the failure
+// domains defined by its spread constraint.
+type spreadDomains struct {
+	// domainOf maps each node to the value of its spread label. Nodes
+	// without the label are in the "" domain
+	domainOf map[types.NodeName]string
+	counts   map[string]int
+}
+
+// newSpreadDomains reads the failure domain of every node from its labels and
+// counts the current replicas in each domain. Every domain containing a node in
+// eligible is tracked, even if it has no replicas.
+func newSpreadDomains(
+	labeler LabelMatcher,
+	constraint fields.SpreadConstraint,
+	current []types.NodeName,
+	eligible []types.NodeName,
+) (spreadDomains, error) {
+	selector := klabels.Everything().Add(constraint.Key, klabels.ExistsOperator, nil)
+	labeled, err := labeler.GetMatches(selector, labels.NODE)
+	if err != nil {
+		return spreadDomains{}, err
+	}
+
+	s := spreadDomains{
+		domainOf: make(map[types.NodeName]string, len(labeled)),
+		counts:   make(map[string]int),
+	}
+	for _, node := range labeled {
+		s.domainOf[types.NodeName(node.ID)] = node.Labels.Get(constraint.Key)
+	}
+	for _, node := range eligible {
+		s.counts[s.domainOf[node]] += 0
+	}
+	for _, node := range current {
+		s.counts[s.domainOf[node]]++
+	}
+	return s, nil
+}
+
+func (s spreadDomains) min() int {
+	min := -1
+	for _, count := range s.counts {
+		if min == -1 || count < min {
+			min = count
+		}
+	}
+	return min
+}
+
+// scheduleOrder chooses up to toSchedule nodes from possible, each time
+// picking a node in the domain with the fewest replicas. It stops early if
+// scheduling another replica would make the difference between the most and
+// least populated domains exceed maxSkew, which can happen when the least
+// populated domains have no possible nodes left. Within a domain, nodes are
+// chosen in sorted order.
+func (s spreadDomains) scheduleOrder(possible []types.NodeName, toSchedule int, maxSkew int) []types.NodeName {
+	byDomain := make(map[string][]types.NodeName)
+	for _, node := range possible {
+		domain := s.domainOf[node]
+		byDomain[domain] = append(byDomain[domain], node)
+	}
+	for _, nodes := range byDomain {
+		sort.Slice(nodes, func(i, j int) bool { return nodes[i] < nodes[j] })
+	}
+
+	var chosen []types.NodeName
+	for len(chosen) < toSchedule {
+		domain, ok := s.leastPopulated(byDomain)
+		if !ok {
+			break
+		}
+		if s.counts[domain]+1-s.min() > maxSkew {
+			break
+		}
+
+		chosen = append(chosen, byDomain[domain][0])
+		byDomain[domain] = byDomain[domain][1:]
+		s.counts[domain]++
+	}
+	return chosen
+}
+
+// leastPopulated returns the domain with the fewest replicas that still has
+// nodes available, breaking ties by domain name.
+func (s spreadDomains) leastPopulated(available map[string][]types.NodeName) (string, bool) {
+	found := false
+	var best string
+	for domain, nodes := range available {
+		if len(nodes) == 0 {
+			continue
+		}
+		if !found || s.counts[domain] < s.counts[best] || (s.counts[domain] == s.counts[best] && domain < best) {
+			best = domain
+			found = true
+		}
+	}
+	return best, found
+}
+
+// unscheduled records that a replica was removed from node
+func (s spreadDomains) unscheduled(node types.NodeName) {
+	s.counts[s.domainOf[node]]--
+}
+
+// popMostPopulated removes and returns a node from candidates that is in the
+// domain with the most replicas, breaking ties by domain and then node name.
+func (s spreadDomains) popMostPopulated(candidates types.NodeSet) (types.NodeName, bool) {
+	found := false
+	var best types.NodeName
+	for _, node := range candidates.ListNodes() {
+		if !found {
+			best = node
+			found = true
+			continue
+		}
+
+		domain, bestDomain := s.domainOf[node], s.domainOf[best]
+		if s.counts[domain] > s.counts[bestDomain] || (s.counts[domain] == s.counts[bestDomain] && domain < bestDomain) {
+			best = node
+		}
+	}
+	if found {
+		candidates.DeleteNode(best)
+	}
+	return best, found
+}
@@ -0,0 +1,200 @@
+package replicaschedule
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/square/p2/pkg/audit"
+	"github.com/square/p2/pkg/logging"
+	rc_fields "github.com/square/p2/pkg/rc/fields"
+	"github.com/square/p2/pkg/replicaschedule/fields"
+	"github.com/square/p2/pkg/store/consul"
+	"github.com/square/p2/pkg/store/consul/transaction"
+	"github.com/square/p2/pkg/util"
+	"github.com/square/p2/pkg/util/cron"
+)
+
+// MaxCatchUp bounds how far in the past an entry can have fired and still be
+// applied, e.g. after all schedule farms were down for a while.
+const MaxCatchUp = 24 * time.Hour
+
+type ScheduleStore interface {
+	Get(rcID rc_fields.ID) (fields.Schedule, error)
+	SetLastAppliedTxn(ctx context.Context, schedule fields.Schedule, lastApplied time.Time) error
+}
+
+type ReplicationControllerStore interface {
+	Get(id rc_fields.ID) (rc_fields.RC, error)
+	SetDesiredReplicasTxn(ctx context.Context, id rc_fields.ID, n int) error
+}
+
+type ReplicationControllerLocker interface {
+	LockForMutationTxn(lockCtx context.Context, rcID rc_fields.ID, session consul.Session) (consul.TxnUnlocker, error)
+}
+
+type AuditLogStore interface {
+	Create(
+		ctx context.Context,
+		eventType audit.EventType,
+		eventDetails json.RawMessage,
+	) error
+}
+
+// Due returns the entry of the schedule that most recently fired after since
+// and no later than now. If several entries fired at the same time, the first
+// one in the schedule wins.
+func Due(schedule fields.Schedule, since time.Time, now time.Time) (fields.Entry, bool, error) {
+	loc, err := schedule.Location()
+	if err != nil {
+		return fields.Entry{}, false, err
+	}
+
+	var due fields.Entry
+	var dueAt time.Time
+	for _, entry := range schedule.Entries {
+		expr, err := cron.Parse(entry.Cron)
+		if err != nil {
+			return fields.Entry{}, false, err
+		}
+
+		var last time.Time
+		for t := expr.Next(since.In(loc)); !t.IsZero() && !t.After(now); t = expr.Next(t) {
+			last = t
+		}
+		if !last.IsZero() && last.After(dueAt) {
+			due, dueAt = entry, last
+		}
+	}
+	return due, !dueAt.IsZero(), nil
+}
+
+// applier applies the schedule of a single RC. It must only be run while the
+// farm holds the ownership lock on the schedule.
+type applier struct {
+	rcID          rc_fields.ID
+	scheduleStore ScheduleStore
+	rcStore       ReplicationControllerStore
+	rcLocker      ReplicationControllerLocker
+	auditLogStore AuditLogStore
+	txner         transaction.Txner
+	session       consul.Session
+	logger        logging.Logger
+}
+
+// run checks the schedule every interval until quit is closed.
+func (a *applier) run(quit <-chan struct{}, interval time.Duration) {
+	timer := time.NewTimer(time.Duration(0))
+	defer timer.Stop()
+	for {
+		select {
+		case <-quit:
+			return
+		case <-timer.C:
+		}
+		timer.Reset(interval)
+
+		err := a.apply(context.Background(), time.Now())
+		if err != nil {
+			a.logger.WithError(err).Errorln("Could not apply replica schedule")
+		}
+	}
+}
+
+// apply sets the RC's replica count if an entry of its schedule has fired
+// since the schedule was last applied. The replica count, the audit record of
+// the change and the time the schedule was applied are written in a single
+// transaction. If it fails, e.g. because a rolling update holds the RC's
+// mutation lock, nothing is written so the change is retried on the next
+// call.
+func (a *applier) apply(ctx context.Context, now time.Time) error {
+	schedule, err := a.scheduleStore.Get(a.rcID)
+	if err != nil {
+		return err
+	}
+
+	txnCtx, cancel := transaction.New(ctx)
+	defer cancel()
+
+	if schedule.LastApplied.IsZero() {
+		// a new schedule only applies entries that fire from now on
+		err = a.scheduleStore.SetLastAppliedTxn(txnCtx, schedule, now)
+		if err != nil {
+			return err
+		}
+		return transaction.MustCommit(txnCtx, a.txner)
+	}
+
+	since := schedule.LastApplied
+	if now.Sub(since) > MaxCatchUp {
+		since = now.Add(-MaxCatchUp)
+	}
+	entry, ok, err := Due(schedule, since, now)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+
+	rc, err := a.rcStore.Get(a.rcID)
+	if err != nil {
+		return err
+	}
+	logger := a.logger.SubLogger(logrus.Fields{
+		"cron":             entry.Cron,
+		"current_replicas": rc.ReplicasDesired,
+		"desired_replicas": entry.Replicas,
+	})
+
+	err = a.scheduleStore.SetLastAppliedTxn(txnCtx, schedule, now)
+	if err != nil {
+		return err
+	}
+	if rc.ReplicasDesired == entry.Replicas {
+		logger.NoFields().Infoln("Schedule entry fired but RC already has the scheduled replica count")
+		return transaction.MustCommit(txnCtx, a.txner)
+	}
+
+	// Rolling updates hold the RC's mutation lock while they change its
+	// replica count. Taking and releasing the lock in the same
+	// transaction makes the transaction fail if it is held by anyone
+	// else, so the schedule waits for the update to finish.
+	unlocker, err := a.rcLocker.LockForMutationTxn(txnCtx, a.rcID, a.session)
+	if err != nil {
+		return err
+	}
+	err = a.rcStore.SetDesiredReplicasTxn(txnCtx, a.rcID, entry.Replicas)
+	if err != nil {
+		return err
+	}
+	err = unlocker.UnlockTxn(txnCtx)
+	if err != nil {
+		return err
+	}
+
+	details, err := audit.NewRCScheduledReplicasEventDetails(a.rcID, rc.ReplicasDesired, entry.Replicas, entry.Cron)
+	if err != nil {
+		return err
+	}
+	err = a.auditLogStore.Create(txnCtx, audit.RCScheduledReplicasEvent, details)
+	if err != nil {
+		return err
+	}
+
+	ok, resp, err := transaction.Commit(txnCtx, a.txner)
+	switch {
+	case err != nil:
+		return err
+	case !ok:
+		return util.Errorf(
+			"could not set scheduled replica count, the RC may be locked by a rolling update or have changed: %s",
+			transaction.TxnErrorsToString(resp.Errors),
+		)
+	}
+
+	logger.NoFields().Infoln("Applied scheduled replica count")
+	return nil
+}
@@ -0,0 +1,181 @@
+package replicaschedule
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/square/p2/pkg/audit"
+	"github.com/square/p2/pkg/logging"
+	"github.com/square/p2/pkg/policyfarm/policyfarmtest"
+	"github.com/square/p2/pkg/replicaschedule/fields"
+	"github.com/square/p2/pkg/store/consul/replicaschedulestore"
+)
+
+var testEntries = []fields.Entry{
+	{Cron: "0 22 * * *", Replicas: 2},
+	{Cron: "0 6 * * *", Replicas: 10},
+}
+
+func TestDue(t *testing.T) {
+	schedule := fields.Schedule{RCID: "some_rc", Entries: testEntries}
+	day := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		since, now time.Time
+		ok         bool
+		replicas   int
+	}{
+		{since: day.Add(7 * time.Hour), now: day.Add(21 * time.Hour), ok: false},
+		{since: day.Add(7 * time.Hour), now: day.Add(22 * time.Hour), ok: true, replicas: 2},
+		{since: day.Add(22 * time.Hour), now: day.Add(23 * time.Hour), ok: false},
+		// the most recent entry wins when several have fired
+		{since: day.Add(7 * time.Hour), now: day.Add(31 * time.Hour), ok: true, replicas: 10},
+	}
+	for _, test := range tests {
+		entry, ok, err := Due(schedule, test.since, test.now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != test.ok || entry.Replicas != test.replicas {
+			t.Errorf("expected (%d, %v) to be due between %s and %s but got (%d, %v)", test.replicas, test.ok, test.since, test.now, entry.Replicas, ok)
+		}
+	}
+}
+
+type testApplier struct {
+	*applier
+	policyfarmtest.Fixture
+	scheduleStore *replicaschedulestore.ConsulStore
+}
+
+func setupApplier(t *testing.T) (testApplier, func()) {
+	fixture := policyfarmtest.NewFixture(t, 10)
+	scheduleStore := replicaschedulestore.NewConsul(fixture.Client)
+	err := scheduleStore.Set(fields.Schedule{RCID: fixture.RCID, Entries: testEntries})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := testApplier{
+		applier: &applier{
+			rcID:          fixture.RCID,
+			scheduleStore: scheduleStore,
+			rcStore:       fixture.RCStore,
+			rcLocker:      fixture.RCStore,
+			auditLogStore: fixture.AuditLogStore,
+			txner:         fixture.Client.KV(),
+			session:       fixture.Session,
+			logger:        logging.TestLogger(),
+		},
+		Fixture:       fixture,
+		scheduleStore: scheduleStore,
+	}
+	return a, fixture.Close
+}
+
+func TestApply(t *testing.T) {
+	a, closeFn := setupApplier(t)
+	defer closeFn()
+
+	day := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	// the first check of a new schedule doesn't apply entries that fired
+	// in the past
+	err := a.apply(context.Background(), day.Add(23*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replicas := a.Replicas(); replicas != 10 {
+		t.Fatalf("expected a new schedule not to change the replica count but it is %d", replicas)
+	}
+
+	err = a.apply(context.Background(), day.Add(30*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replicas := a.Replicas(); replicas != 10 {
+		t.Fatalf("expected no change before the next entry fired but the replica count is %d", replicas)
+	}
+
+	err = a.apply(context.Background(), day.Add(46*time.Hour+time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replicas := a.Replicas(); replicas != 2 {
+		t.Fatalf("expected the 10pm entry to set 2 replicas but the replica count is %d", replicas)
+	}
+
+	auditLogs, err := a.AuditLogStore.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(auditLogs) != 1 {
+		t.Fatalf("expected one audit log record but found %d", len(auditLogs))
+	}
+	for _, al := range auditLogs {
+		if al.EventType != audit.RCScheduledReplicasEvent {
+			t.Errorf("expected an audit log record of type %s but was %s", audit.RCScheduledReplicasEvent, al.EventType)
+		}
+	}
+
+	// the same entry should not be applied twice
+	err = a.RCStore.SetDesiredReplicas(a.rcID, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = a.apply(context.Background(), day.Add(47*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replicas := a.Replicas(); replicas != 5 {
+		t.Fatalf("expected the entry not to be applied again but the replica count is %d", replicas)
+	}
+}
+
+func TestApplyRetriesWhenRCIsLocked(t *testing.T) {
+	a, closeFn := setupApplier(t)
+	defer closeFn()
+
+	day := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	err := a.apply(context.Background(), day.Add(21*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unlocker := a.LockForMutation()
+
+	err = a.apply(context.Background(), day.Add(22*time.Hour))
+	if err == nil {
+		t.Fatal("expected an error applying a schedule to an RC that is locked for mutation")
+	}
+	if replicas := a.Replicas(); replicas != 10 {
+		t.Fatalf("expected the locked RC not to be changed but the replica count is %d", replicas)
+	}
+	auditLogs, err := a.AuditLogStore.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(auditLogs) != 0 {
+		t.Fatalf("expected no audit log records while the RC is locked but found %d", len(auditLogs))
+	}
+
+	err = unlocker.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = a.apply(context.Background(), day.Add(22*time.Hour+time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replicas := a.Replicas(); replicas != 2 {
+		t.Fatalf("expected the entry to be applied once the RC was unlocked but the replica count is %d", replicas)
+	}
+	auditLogs, err = a.AuditLogStore.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(auditLogs) != 1 {
+		t.Fatalf("expected one audit log record once the entry was applied but found %d", len(auditLogs))
+	}
+}
@@ -0,0 +1,105 @@
+// Package replicaschedule changes the replica counts of replication controllers
+// at the times given by cron expressions in schedules stored in consul.
+package replicaschedule
+
+import (
+	"time"
+
+	"github.com/square/p2/pkg/logging"
+	"github.com/square/p2/pkg/policyfarm"
+	rc_fields "github.com/square/p2/pkg/rc/fields"
+	"github.com/square/p2/pkg/store/consul"
+	"github.com/square/p2/pkg/store/consul/transaction"
+)
+
+const (
+	DefaultCheckInterval  = 30 * time.Second
+	DefaultWatchPauseTime = 1 * time.Second
+)
+
+// A subset of the consul.Store interface used by the farm
+type sessionStore interface {
+	NewUnmanagedSession(session, name string) consul.Session
+}
+
+type ScheduleWatcher interface {
+	ScheduleStore
+	WatchRCs(quit <-chan struct{}, pauseTime time.Duration) (<-chan []rc_fields.ID, <-chan error)
+	LockForOwnership(rcID rc_fields.ID, session consul.Session) (consul.Unlocker, error)
+}
+
+type FarmConfig struct {
+	// CheckInterval is how often each schedule is checked for entries
+	// that have fired. It bounds how late a scheduled change is applied.
+	CheckInterval time.Duration
+
+	// WatchPauseTime is the time to wait between watches of the schedule
+	// tree returning
+	WatchPauseTime time.Duration
+}
+
+// The Farm is responsible for applying each replica schedule as schedules are
+// added to and deleted from Consul. Multiple farms can exist simultaneously,
+// but each one must hold a different Consul session. This ensures that no two
+// farms apply the schedule of the same RC.
+type Farm struct {
+	*policyfarm.Farm
+
+	scheduleStore ScheduleWatcher
+	rcStore       ReplicationControllerStore
+	rcLocker      ReplicationControllerLocker
+	auditLogStore AuditLogStore
+	txner         transaction.Txner
+	config        FarmConfig
+}
+
+func NewFarm(
+	store sessionStore,
+	scheduleStore ScheduleWatcher,
+	rcStore ReplicationControllerStore,
+	rcLocker ReplicationControllerLocker,
+	auditLogStore AuditLogStore,
+	txner transaction.Txner,
+	sessions <-chan string,
+	logger logging.Logger,
+	config FarmConfig,
+) *Farm {
+	if config.CheckInterval == 0 {
+		config.CheckInterval = DefaultCheckInterval
+	}
+	if config.WatchPauseTime == 0 {
+		config.WatchPauseTime = DefaultWatchPauseTime
+	}
+
+	f := &Farm{
+		scheduleStore: scheduleStore,
+		rcStore:       rcStore,
+		rcLocker:      rcLocker,
+		auditLogStore: auditLogStore,
+		txner:         txner,
+		config:        config,
+	}
+	f.Farm = policyfarm.New("replica schedule", store, scheduleStore, f.watchSchedules, f.newApplier, sessions, logger)
+	return f
+}
+
+// watchSchedules publishes the IDs of the RCs that have replica schedules
+func (f *Farm) watchSchedules(quit <-chan struct{}) (<-chan []rc_fields.ID, <-chan error) {
+	return f.scheduleStore.WatchRCs(quit, f.config.WatchPauseTime)
+}
+
+func (f *Farm) newApplier(rcID rc_fields.ID, session consul.Session, logger logging.Logger) policyfarm.Worker {
+	child := &applier{
+		rcID:          rcID,
+		scheduleStore: f.scheduleStore,
+		rcStore:       f.rcStore,
+		rcLocker:      f.rcLocker,
+		auditLogStore: f.auditLogStore,
+		txner:         f.txner,
+		session:       session,
+		logger:        logger,
+	}
+	return func(quit <-chan struct{}) {
+		child.run(quit, f.config.CheckInterval)
+	}
+}
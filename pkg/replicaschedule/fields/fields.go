@@ -0,0 +1,97 @@
+package fields
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	rc_fields "github.com/square/p2/pkg/rc/fields"
+	"github.com/square/p2/pkg/util"
+	"github.com/square/p2/pkg/util/cron"
+)
+
+// Entry sets the replica count of an RC each time its cron expression
+// matches.
+type Entry struct {
+	// Cron is a five field cron expression, e.g. "0 22 * * 1-5" for 10pm
+	// on weekdays
+	Cron string `json:"cron"`
+
+	Replicas int `json:"replicas"`
+}
+
+// ParseEntry parses an entry written as a cron expression and a replica
+// count separated by "=", e.g. "0 22 * * 1-5=4".
+func ParseEntry(s string) (Entry, error) {
+	i := strings.LastIndex(s, "=")
+	if i == -1 {
+		return Entry{}, util.Errorf("schedule entry %q must have the form CRON=REPLICAS", s)
+	}
+	replicas, err := strconv.Atoi(strings.TrimSpace(s[i+1:]))
+	if err != nil {
+		return Entry{}, util.Errorf("schedule entry %q has an invalid replica count: %s", s, err)
+	}
+	return Entry{Cron: strings.TrimSpace(s[:i]), Replicas: replicas}, nil
+}
+
+// Schedule holds the scheduled replica changes for a single replication
+// controller as saved in Consul. There is at most one schedule per RC, so it
+// is identified by the RC's ID.
+type Schedule struct {
+	RCID rc_fields.ID `json:"rc_id"`
+
+	Entries []Entry `json:"entries"`
+
+	// Timezone is the name of the IANA time zone the cron expressions are
+	// evaluated in, e.g. "America/Los_Angeles". UTC is used if it is
+	// empty.
+	Timezone string `json:"timezone,omitempty"`
+
+	// LastApplied is the time up to which the entries have been applied.
+	// It is maintained by the schedule farm and should not be set by
+	// hand.
+	LastApplied time.Time `json:"last_applied"`
+}
+
+// RC, Progress and SetProgress let schedules be kept in an rcpolicystore. The
+// progress of a schedule is the time up to which it has been applied.
+func (s Schedule) RC() rc_fields.ID { return s.RCID }
+
+func (s Schedule) Progress() time.Time { return s.LastApplied }
+
+func (s *Schedule) SetProgress(lastApplied time.Time) { s.LastApplied = lastApplied }
+
+// Location returns the time zone the schedule's cron expressions are
+// evaluated in.
+func (s Schedule) Location() (*time.Location, error) {
+	if s.Timezone == "" {
+		return time.UTC, nil
+	}
+
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return nil, util.Errorf("invalid timezone %q: %s", s.Timezone, err)
+	}
+	return loc, nil
+}
+
+func (s Schedule) Validate() error {
+	if s.RCID == "" {
+		return util.Errorf("replica schedule must have an RC ID")
+	}
+	if len(s.Entries) == 0 {
+		return util.Errorf("replica schedule must have at least one entry")
+	}
+	for _, entry := range s.Entries {
+		_, err := cron.Parse(entry.Cron)
+		if err != nil {
+			return err
+		}
+		if entry.Replicas < 0 {
+			return util.Errorf("replica count for %q cannot be negative, was %d", entry.Cron, entry.Replicas)
+		}
+	}
+
+	_, err := s.Location()
+	return err
+}
@@ -46,6 +46,7 @@ func testReplicatorAndServer(t *testing.T) (Replicator, Store, consulutil.Fixtur
 		testLockMessage,
 		NoTimeout,
 		0,
+		nil,
 	)
 
 	if err != nil {
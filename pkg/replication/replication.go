@@ -2,6 +2,7 @@ package replication
 
 import (
 	"context"
+	"fmt"
 	"path"
 	"sort"
 	"strings"
@@ -9,6 +10,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/square/p2/pkg/disruptionbudget"
 	"github.com/square/p2/pkg/health"
 	"github.com/square/p2/pkg/health/checker"
 	"github.com/square/p2/pkg/labels"
@@ -33,6 +35,10 @@ type Labeler interface {
 var (
 	ensureRealityPeriodMillis = param.Int("ensure_in_reality_millis", 5000)
 	ensureHealthyPeriodMillis = param.Int("ensure_healthy_millis", 1000)
+
+	// canaryTimeoutSeconds bounds how long a canary node may take to
+	// become healthy, even when the replication has no timeout
+	canaryTimeoutSeconds = param.Int("canary_timeout_seconds", 1800)
 )
 
 type nodeUpdated struct {
@@ -80,6 +86,20 @@ type Replication interface {
 
 	// SetTimeout() is used to change the timeout used for the replication while it is in progress
 	SetTimeout(timeout time.Duration)
+
+	// SetActive() changes the number of nodes that are updated concurrently
+	// while a replication is in progress
+	SetActive(active int)
+
+	Active() int
+
+	// SetCanaryNodes() sets the number of nodes that must be updated and
+	// healthy with a new manifest before any other nodes are updated
+	SetCanaryNodes(canaryNodes int)
+
+	// CanaryNodesHealthy() returns the number of canary nodes that are
+	// healthy with the current manifest
+	CanaryNodesHealthy() int
 }
 
 type Store interface {
@@ -159,6 +179,32 @@ type replication struct {
 	// If provided, the replication will not exit until the caller closes
 	// the channel
 	nodeQueue <-chan types.NodeName
+
+	// disruptions limits how many pods the replication may take down at
+	// once. A node is not updated until its pod can be reserved against
+	// the pod's disruption budgets, and the reservation is released once
+	// the node is healthy again.
+	disruptions disruptionbudget.Budgets
+
+	// inFlight is the number of nodes being updated, which is limited to
+	// active. Protected by mu
+	inFlight int
+
+	// canaryNodes is the number of nodes that must be updated and healthy
+	// with a new manifest before any other nodes are updated.
+	// canariesStarted and canariesHealthy count the canaries of the
+	// current manifest, and canaryFailed is set when one of them did not
+	// become healthy. They are reset along with canaryGeneration whenever
+	// the manifest changes. Protected by mu
+	canaryNodes      int
+	canariesStarted  int
+	canariesHealthy  int
+	canaryFailed     bool
+	canaryGeneration int
+
+	// rolloutCh is closed whenever inFlight, active or the canary state
+	// changes, to wake the goroutines waiting for them. Protected by mu
+	rolloutCh chan struct{}
 }
 
 func newReplication(
@@ -181,6 +227,7 @@ func newReplication(
 	concurrentRealityRequests chan struct{},
 	timeout time.Duration,
 	nodeQueue chan types.NodeName,
+	disruptions disruptionbudget.Budgets,
 ) *replication {
 	return &replication{
 		active:                    active,
@@ -202,6 +249,7 @@ func newReplication(
 		concurrentRealityRequests: concurrentRealityRequests,
 		timeout:                   timeout,
 		nodeQueue:                 nodeQueue,
+		disruptions:               disruptions,
 	}
 }
 
@@ -351,56 +399,123 @@ func (r *replication) Enact() {
 	defer aggregateHealth.Stop()
 	// this loop multiplexes the node queue across some goroutines
 
+	// the pool starts with r.active goroutines and grows when r.active is
+	// raised while the replication is in progress. Only r.active of them
+	// update a node at once, so the pool doesn't shrink when it is lowered
 	var updatePool sync.WaitGroup
-	for i := 0; i < r.active; i++ {
-		updatePool.Add(1)
-		go func() {
-			// nodeQueue is managed below to throttle these goroutines
-			defer updatePool.Done()
-			for node := range nodeQueue {
-				exitCh := make(chan struct{})
-				ctx, cancel := context.WithCancel(context.Background())
-				r.mu.Lock()
-				if r.timeout != NoTimeout {
-					ctx, cancel = context.WithTimeout(ctx, r.timeout)
-				}
-				r.mu.Unlock()
-				ctx, _ = transaction.New(ctx)
-
-				go func(ctx context.Context, cancel context.CancelFunc) {
-					defer cancel()
-					defer close(exitCh)
-					err := r.updateOne(ctx, node, aggregateHealth)
-					if err == nil {
-						r.logger.Infof("The host '%v' successfully replicated the pod '%v'", node, r.GetManifest().ID())
-						return
-					}
-
-					switch err {
-					case errTimeout:
-						r.timedOutReplicationsMutex.Lock()
-						r.timedOutReplications = append(r.timedOutReplications, node)
-						r.timedOutReplicationsMutex.Unlock()
-						r.logger.Errorf("The host '%v' timed out during replication for pod '%v'", node, r.GetManifest().ID())
-					case errCancelled:
-						r.logger.Errorf("The host '%v' was cancelled (probably due to an update) during replication for pod '%v'", node, r.GetManifest().ID())
-					default:
-						r.logger.Errorf("An unexpected error has occurred: %v", err)
-					}
-				}(ctx, cancel)
+	drained := make(chan struct{})
+	var drainedOnce sync.Once
+	updateNodes := func() {
+		// nodeQueue is managed below to throttle these goroutines
+		defer updatePool.Done()
+		for {
+			// a node is only taken off the queue once it can be
+			// updated, so that nodes are updated in order
+			if !r.acquireSlot() {
+				return
+			}
+			node, ok := <-nodeQueue
+			if !ok {
+				drainedOnce.Do(func() { close(drained) })
+				r.releaseSlot()
+				return
+			}
+			canaryGeneration, isCanary, err := r.waitForCanaries(node)
+			if err == errCanaryFailed {
+				r.logger.Errorf("Skipping the host '%v' because a canary node did not become healthy with pod '%v'", node, r.GetManifest().ID())
+				r.releaseSlot()
+				continue
+			}
+			if err != nil {
+				r.releaseSlot()
+				return
+			}
 
-				select {
-				case <-ctx.Done():
-				case <-r.quitCh:
+			exitCh := make(chan struct{})
+			ctx, cancel := context.WithCancel(context.Background())
+			r.mu.Lock()
+			timeout := r.timeout
+			r.mu.Unlock()
+			if canaryTimeout := time.Duration(*canaryTimeoutSeconds) * time.Second; isCanary && (timeout == NoTimeout || timeout > canaryTimeout) {
+				timeout = canaryTimeout
+			}
+			if timeout != NoTimeout {
+				ctx, cancel = context.WithTimeout(ctx, timeout)
+			}
+			ctx, _ = transaction.New(ctx)
+
+			go func(ctx context.Context, cancel context.CancelFunc) {
+				defer r.releaseSlot()
+				defer cancel()
+				defer close(exitCh)
+				err := r.updateOne(ctx, node, aggregateHealth)
+				if isCanary {
+					r.finishCanary(node, canaryGeneration, err == nil)
+				}
+				if err == nil {
+					r.logger.Infof("The host '%v' successfully replicated the pod '%v'", node, r.GetManifest().ID())
 					return
 				}
+
+				switch err {
+				case errTimeout:
+					r.timedOutReplicationsMutex.Lock()
+					r.timedOutReplications = append(r.timedOutReplications, node)
+					r.timedOutReplicationsMutex.Unlock()
+					r.logger.Errorf("The host '%v' timed out during replication for pod '%v'", node, r.GetManifest().ID())
+				case errCancelled:
+					r.logger.Errorf("The host '%v' was cancelled (probably due to an update) during replication for pod '%v'", node, r.GetManifest().ID())
+				default:
+					r.logger.Errorf("An unexpected error has occurred: %v", err)
+				}
+			}(ctx, cancel)
+
+			select {
+			case <-ctx.Done():
+			case <-r.quitCh:
+				return
 			}
-		}()
+		}
 	}
 
+	// the pool is waited on until the goroutine growing it returns, so that
+	// it can't grow once it has been waited on
+	updatePool.Add(1)
+	go func() {
+		defer updatePool.Done()
+		r.growUpdatePool(func() {
+			updatePool.Add(1)
+			go updateNodes()
+		}, drained)
+	}()
 	updatePool.Wait()
 }
 
+// growUpdatePool calls startWorker until r.active workers have been started,
+// and again whenever r.active is raised, until drained is closed or the
+// replication ends
+func (r *replication) growUpdatePool(startWorker func(), drained <-chan struct{}) {
+	workers := 0
+	for {
+		r.mu.Lock()
+		for ; workers < r.active; workers++ {
+			startWorker()
+		}
+		wait := r.rolloutWaitLocked()
+		r.mu.Unlock()
+
+		select {
+		case <-wait:
+		case <-drained:
+			return
+		case <-r.quitCh:
+			return
+		case <-r.replicationCancelledCh:
+			return
+		}
+	}
+}
+
 // Cancels all goroutines (e.g. replication and lock renewal)
 // NOTE: Cancel() should only be called on replications that were initialized
 // with a nil nodeQueue, otherwise nothing will be listening on this channel
@@ -426,6 +541,13 @@ func (r *replication) SetManifest(man manifest.Manifest) {
 	if oldSHA != newSHA {
 		// reset the completed count to 0 because we changed the manifest
 		atomic.StoreInt32(&r.completedCount, 0)
+
+		// the new manifest needs canaries of its own
+		r.canaryGeneration++
+		r.canariesStarted = 0
+		r.canariesHealthy = 0
+		r.canaryFailed = false
+		r.rolloutChangedLocked()
 	}
 	r.manifest = man
 }
@@ -436,6 +558,166 @@ func (r *replication) SetTimeout(timeout time.Duration) {
 	r.mu.Unlock()
 }
 
+func (r *replication) SetActive(active int) {
+	if active < 1 {
+		active = 1
+	}
+	if active > MaxActive {
+		active = MaxActive
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.active = active
+	r.rolloutChangedLocked()
+}
+
+func (r *replication) Active() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.active
+}
+
+func (r *replication) SetCanaryNodes(canaryNodes int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if canaryNodes != r.canaryNodes {
+		// give the rollout another chance after a canary failed
+		r.canaryFailed = false
+	}
+	r.canaryNodes = canaryNodes
+	r.rolloutChangedLocked()
+}
+
+func (r *replication) CanaryNodesHealthy() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.canariesHealthy
+}
+
+// rolloutChangedLocked wakes any goroutines waiting in acquireSlot() or
+// waitForCanaries(). r.mu must be held.
+func (r *replication) rolloutChangedLocked() {
+	if r.rolloutCh != nil {
+		close(r.rolloutCh)
+		r.rolloutCh = nil
+	}
+}
+
+// rolloutWaitLocked returns a channel that is closed the next time
+// rolloutChangedLocked() is called. r.mu must be held.
+func (r *replication) rolloutWaitLocked() <-chan struct{} {
+	if r.rolloutCh == nil {
+		r.rolloutCh = make(chan struct{})
+	}
+	return r.rolloutCh
+}
+
+// acquireSlot waits until fewer than r.active nodes are being updated and
+// then counts another one. It returns false if the replication ended while
+// waiting.
+func (r *replication) acquireSlot() bool {
+	for {
+		r.mu.Lock()
+		if r.inFlight < r.active {
+			r.inFlight++
+			r.mu.Unlock()
+			return true
+		}
+		wait := r.rolloutWaitLocked()
+		r.mu.Unlock()
+
+		select {
+		case <-wait:
+		case <-r.quitCh:
+			return false
+		case <-r.replicationCancelledCh:
+			return false
+		}
+	}
+}
+
+func (r *replication) releaseSlot() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.inFlight--
+	r.rolloutChangedLocked()
+}
+
+// waitForCanaries waits until node may be updated. While the current manifest
+// has fewer healthy canaries than r.canaryNodes, nodes that need the manifest
+// become canaries until there are enough of them, and the rest wait for the
+// canaries to become healthy. The returned generation identifies the manifest
+// a canary was started for. errCanaryFailed is returned if a canary of the
+// current manifest failed, in which case node must not be updated, and
+// errCancelled if the replication ended while waiting.
+func (r *replication) waitForCanaries(node types.NodeName) (int, bool, error) {
+	nodeLogger := r.logger.SubLogger(logrus.Fields{"node": node})
+	for {
+		r.mu.RLock()
+		done := r.canariesHealthy >= r.canaryNodes
+		r.mu.RUnlock()
+		if done {
+			return 0, false, nil
+		}
+
+		// nodes that already have the manifest don't need to wait
+		if !r.shouldScheduleForNode(node, nodeLogger) {
+			return 0, false, nil
+		}
+
+		r.mu.Lock()
+		if r.canariesHealthy >= r.canaryNodes {
+			r.mu.Unlock()
+			return 0, false, nil
+		}
+		if r.canaryFailed {
+			r.mu.Unlock()
+			return 0, false, errCanaryFailed
+		}
+		if r.canariesStarted < r.canaryNodes {
+			r.canariesStarted++
+			generation := r.canaryGeneration
+			r.mu.Unlock()
+			nodeLogger.Infoln("Updating node as a canary")
+			return generation, true, nil
+		}
+		wait := r.rolloutWaitLocked()
+		r.mu.Unlock()
+
+		select {
+		case <-wait:
+		case <-r.quitCh:
+			return 0, false, errCancelled
+		case <-r.replicationCancelledCh:
+			return 0, false, errCancelled
+		}
+	}
+}
+
+// finishCanary records the outcome of updating a canary node. A canary that
+// does not become healthy (including one that times out) aborts the rollout
+// of the current manifest: the nodes after it are skipped until the manifest
+// or the number of canary nodes changes.
+func (r *replication) finishCanary(node types.NodeName, generation int, healthy bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if generation != r.canaryGeneration {
+		// the manifest changed while the canary was updated
+		return
+	}
+
+	if !healthy {
+		r.logger.WithField("node", node).Errorln("Canary node did not become healthy, aborting the rollout until the manifest or the number of canary nodes changes")
+		r.canariesStarted--
+		r.canaryFailed = true
+		r.rolloutChangedLocked()
+		return
+	}
+	r.canariesHealthy++
+	r.rolloutChangedLocked()
+}
+
 func (r *replication) GetManifest() manifest.Manifest {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
@@ -539,8 +821,35 @@ func (r *replication) updateOne(
 	// only add if we actually intend to schedule it
 	defer atomic.AddInt32(&r.completedCount, 1)
 
+	err := r.reserveDisruption(ctx, node, nodeLogger)
+	if err != nil {
+		return err
+	}
+
 	targetSHA, _ := manifest.SHA()
 	nodeLogger.WithField("sha", targetSHA).Infoln("Updating node")
+	err = r.writeIntent(ctx, node, manifest, nodeLogger)
+	if err != nil {
+		r.releaseDisruption(node, nodeLogger)
+		return err
+	}
+
+	err = r.ensureInReality(ctx, node, nodeLogger, targetSHA)
+	if err != nil {
+		return err
+	}
+	err = r.ensureHealthy(ctx, node, nodeLogger, aggregateHealth)
+	if err != nil {
+		return err
+	}
+
+	r.releaseDisruption(node, nodeLogger)
+	return nil
+}
+
+// writeIntent schedules the manifest on node and labels the pod, using the
+// transaction in ctx
+func (r *replication) writeIntent(ctx context.Context, node types.NodeName, manifest manifest.Manifest, nodeLogger logging.Logger) error {
 	err := r.store.SetPodTxn(
 		ctx,
 		consul.INTENT_TREE,
@@ -578,12 +887,82 @@ func (r *replication) updateOne(
 		nodeLogger.WithError(err).Errorln("Could not write intent store")
 		return err
 	}
+	return nil
+}
+
+func (r *replication) disruptionRequest(node types.NodeName) disruptionbudget.Request {
+	podID := r.GetManifest().ID()
+	return disruptionbudget.Request{
+		PodID:  podID,
+		Nodes:  []types.NodeName{node},
+		Holder: fmt.Sprintf("replication-%s", podID),
+	}
+}
 
-	err = r.ensureInReality(ctx, node, nodeLogger, targetSHA)
+// reserveDisruption waits until the disruption budgets of the pod allow it to
+// be taken down on node, and then reserves the disruption in a transaction of
+// its own. Nodes are updated concurrently and their reservations are
+// written to the same budget, so a reservation that loses a race with another
+// node's is retried against the updated budget.
+func (r *replication) reserveDisruption(ctx context.Context, node types.NodeName, nodeLogger logging.Logger) error {
+	for {
+		reserved, err := r.tryReserveDisruption(ctx, node)
+		switch {
+		case reserved:
+			return nil
+		case err == nil:
+			nodeLogger.Debugln("Disruption budget changed while reserving, retrying")
+			continue
+		case !disruptionbudget.IsBudgetExceeded(err):
+			return err
+		}
+		nodeLogger.WithError(err).Infoln("Waiting for disruption budget")
+
+		select {
+		case <-r.quitCh:
+			return errQuit
+		case <-ctx.Done():
+			return errTimeout
+		case <-r.replicationCancelledCh:
+			return errCancelled
+		case <-time.After(time.Duration(*ensureRealityPeriodMillis) * time.Millisecond):
+		}
+	}
+}
+
+// tryReserveDisruption makes one attempt at reserving the disruption of the
+// pod on node. It returns false without an error if the budget was changed
+// between reading and writing it.
+func (r *replication) tryReserveDisruption(ctx context.Context, node types.NodeName) (bool, error) {
+	// the transaction in ctx is still empty, so the reservation's
+	// transaction does not inherit any other operations
+	reserveCtx, cancel := transaction.New(ctx)
+	defer cancel()
+	err := r.disruptions.ReserveTxn(reserveCtx, r.disruptionRequest(node))
 	if err != nil {
-		return err
+		return false, err
+	}
+
+	ok, _, err := transaction.CommitWithRetries(reserveCtx, r.txner)
+	if err != nil {
+		// this means we hit the timeout before getting a successful result
+		return false, errTimeout
+	}
+	return ok, nil
+}
+
+// releaseDisruption releases the reservation made for node once it is healthy
+// again. Failures are only logged because the reservation will expire.
+func (r *replication) releaseDisruption(node types.NodeName, nodeLogger logging.Logger) {
+	ctx, cancel := transaction.New(context.Background())
+	defer cancel()
+	err := r.disruptions.ReleaseTxn(ctx, r.disruptionRequest(node))
+	if err == nil {
+		err = transaction.MustCommit(ctx, r.txner)
+	}
+	if err != nil {
+		nodeLogger.WithError(err).Errorln("Could not release disruption budget reservation")
 	}
-	return r.ensureHealthy(ctx, node, nodeLogger, aggregateHealth)
 }
 
 func (r *replication) queryReality(node types.NodeName) (manifest.Manifest, error) {
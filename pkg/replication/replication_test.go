@@ -3,12 +3,15 @@
 package replication
 
 import (
+	"context"
 	"testing"
 
 	"time"
 
 	"github.com/square/p2/pkg/logging"
 
+	"github.com/square/p2/pkg/disruptionbudget"
+	"github.com/square/p2/pkg/disruptionbudget/fields"
 	"github.com/square/p2/pkg/health"
 	"github.com/square/p2/pkg/health/checker/test"
 	"github.com/square/p2/pkg/labels"
@@ -16,6 +19,7 @@ import (
 	"github.com/square/p2/pkg/store/consul"
 	"github.com/square/p2/pkg/store/consul/consultest"
 	"github.com/square/p2/pkg/store/consul/consulutil"
+	"github.com/square/p2/pkg/store/consul/disruptionbudgetstore"
 	"github.com/square/p2/pkg/types"
 )
 
@@ -103,6 +107,191 @@ func TestEnactCancellation(t *testing.T) {
 	}
 }
 
+func TestCanaryNodesGateRollout(t *testing.T) {
+	errCh := make(chan error)
+	go proccessErrors(errCh, t)
+	defer close(errCh)
+	r, fixture := newTestReplication(t, errCh)
+	defer fixture.Stop()
+	r.SetCanaryNodes(1)
+
+	generation, isCanary, err := r.waitForCanaries(r.nodes[0])
+	if err != nil || !isCanary {
+		t.Fatal("expected the first node to be updated as a canary")
+	}
+
+	type waitResult struct {
+		isCanary bool
+		err      error
+	}
+	waitedCh := make(chan waitResult)
+	go func() {
+		_, isCanary, err := r.waitForCanaries(r.nodes[1])
+		waitedCh <- waitResult{isCanary, err}
+	}()
+	select {
+	case <-waitedCh:
+		t.Fatal("expected the second node to wait for the canary to become healthy")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// a failed canary aborts the rollout instead of stalling it
+	r.finishCanary(r.nodes[0], generation, false)
+	select {
+	case result := <-waitedCh:
+		if result.err != errCanaryFailed {
+			t.Fatalf("expected the second node to be skipped after the canary failed, got %v", result.err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the second node after the canary failed")
+	}
+
+	// a new manifest starts over with new canaries
+	mb := r.GetManifest().GetBuilder()
+	err = mb.SetConfig(map[interface{}]interface{}{"version": 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.SetManifest(mb.GetManifest())
+	_, isCanary, err = r.waitForCanaries(r.nodes[1])
+	if err != nil || !isCanary {
+		t.Fatalf("expected the second node to become a canary for the new manifest, got %v", err)
+	}
+
+	if r.CanaryNodesHealthy() != 0 {
+		t.Errorf("expected no healthy canaries but got %d", r.CanaryNodesHealthy())
+	}
+}
+
+func TestSetActiveReleasesWaiters(t *testing.T) {
+	errCh := make(chan error)
+	go proccessErrors(errCh, t)
+	defer close(errCh)
+	r, fixture := newTestReplication(t, errCh)
+	defer fixture.Stop()
+	r.SetActive(1)
+
+	if !r.acquireSlot() {
+		t.Fatal("expected to acquire the only slot")
+	}
+	acquiredCh := make(chan bool)
+	go func() {
+		acquiredCh <- r.acquireSlot()
+	}()
+	select {
+	case <-acquiredCh:
+		t.Fatal("expected the second slot to wait while max in flight is 1")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	r.SetActive(2)
+	select {
+	case acquired := <-acquiredCh:
+		if !acquired {
+			t.Fatal("expected to acquire a slot after raising max in flight")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a slot after raising max in flight")
+	}
+}
+
+func TestUpdatePoolGrowsWithActive(t *testing.T) {
+	errCh := make(chan error)
+	go proccessErrors(errCh, t)
+	defer close(errCh)
+	r, fixture := newTestReplication(t, errCh)
+	defer fixture.Stop()
+	r.SetActive(1)
+
+	started := make(chan struct{}, MaxActive)
+	drained := make(chan struct{})
+	growerDone := make(chan struct{})
+	go func() {
+		defer close(growerDone)
+		r.growUpdatePool(func() { started <- struct{}{} }, drained)
+	}()
+
+	waitForWorkers := func(expected int) {
+		for i := 0; i < expected; i++ {
+			select {
+			case <-started:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timed out waiting for %d more workers to be started", expected-i)
+			}
+		}
+		select {
+		case <-started:
+			t.Fatalf("expected only %d more workers to be started", expected)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	waitForWorkers(1)
+	r.SetActive(3)
+	waitForWorkers(2)
+	// lowering active leaves the extra workers idle instead of stopping them
+	r.SetActive(2)
+	waitForWorkers(0)
+	r.SetActive(3)
+	waitForWorkers(0)
+
+	close(drained)
+	select {
+	case <-growerDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the pool to stop growing once the node queue was drained")
+	}
+}
+
+func TestReserveDisruptionConcurrently(t *testing.T) {
+	errCh := make(chan error)
+	go proccessErrors(errCh, t)
+	defer close(errCh)
+	r, fixture := newTestReplication(t, errCh)
+	defer fixture.Stop()
+
+	podID := r.GetManifest().ID()
+	labeler := labels.NewConsulApplicator(fixture.Client, 0, 0)
+	for _, node := range r.nodes {
+		err := labeler.SetLabels(labels.POD, labels.MakePodLabelKey(node, podID), map[string]string{
+			types.PodIDLabel: podID.String(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	budgetStore := disruptionbudgetstore.NewConsul(fixture.Client)
+	err := budgetStore.Set(fields.Budget{PodID: podID, MaxUnavailable: fields.Limit(len(r.nodes))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.disruptions = disruptionbudget.NewChecker(budgetStore, labeler, r.health, 0)
+
+	// every node reserves against the same budget at once, so all but
+	// one of the first attempts lose the race
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	reservedCh := make(chan error)
+	for _, node := range r.nodes {
+		go func(node types.NodeName) {
+			reservedCh <- r.reserveDisruption(ctx, node, logging.TestLogger())
+		}(node)
+	}
+	for range r.nodes {
+		if err := <-reservedCh; err != nil {
+			t.Errorf("expected every node to reserve its disruption but got %s", err)
+		}
+	}
+
+	budget, err := budgetStore.Get(podID, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(budget.Disruptions) != len(r.nodes) {
+		t.Errorf("expected %d disruptions to be reserved but there were %d", len(r.nodes), len(budget.Disruptions))
+	}
+}
+
 // newTestReplication returns a replication and podStore suitable for test
 // The errCh is managed and
 // podStore is passed via secondary returv value so it can be used to read
@@ -146,6 +335,7 @@ func newTestReplication(t *testing.T, errCh chan error) (*replication, consuluti
 		quitCh:                 quitCh,
 		concurrentRealityRequests: concurrentRealityRequests,
 		timeout:                   timeout,
+		disruptions:               disruptionbudget.NewNop(),
 	}, fixture
 
 }
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/square/p2/pkg/constants"
+	"github.com/square/p2/pkg/disruptionbudget"
 	"github.com/square/p2/pkg/health"
 	"github.com/square/p2/pkg/health/checker"
 	"github.com/square/p2/pkg/logging"
@@ -18,6 +19,10 @@ import (
 const (
 	DefaultConcurrentReality = 3
 
+	// MaxActive is the largest number of nodes a replication will update
+	// concurrently
+	MaxActive = 50
+
 	// Normal replications will have no timeout, but daemon sets will
 	// because it is unlikely that all hosts are healthy at all times
 	NoTimeout = time.Duration(-1)
@@ -27,6 +32,8 @@ var (
 	errTimeout   = errors.New("Update timed out")
 	errCancelled = errors.New("Replication cancelled")
 	errQuit      = errors.New("Replication quit")
+
+	errCanaryFailed = errors.New("Canary node did not become healthy")
 )
 
 type Replicator interface {
@@ -68,6 +75,9 @@ type replicator struct {
 
 	// Used to timeout daemon set replications
 	timeout time.Duration
+
+	// Used to limit how many pods are taken down at once
+	disruptions disruptionbudget.Budgets
 }
 
 func NewReplicator(
@@ -83,13 +93,17 @@ func NewReplicator(
 	lockMessage string,
 	timeout time.Duration,
 	healthWatchDelay time.Duration,
+	disruptions disruptionbudget.Budgets,
 ) (Replicator, error) {
 	if active < 1 {
 		return replicator{}, util.Errorf("Active must be >= 1, was %d", active)
 	}
-	if active > 50 {
-		logger.Infof("Number of concurrent updates (%v) is greater than 50, reducing to 50", active)
-		active = 50
+	if active > MaxActive {
+		logger.Infof("Number of concurrent updates (%v) is greater than %d, reducing to %d", active, MaxActive, MaxActive)
+		active = MaxActive
+	}
+	if disruptions == nil {
+		disruptions = disruptionbudget.NewNop()
 	}
 	return replicator{
 		manifest:         manifest,
@@ -104,6 +118,7 @@ func NewReplicator(
 		lockMessage:      lockMessage,
 		timeout:          timeout,
 		healthWatchDelay: healthWatchDelay,
+		disruptions:      disruptions,
 	}, nil
 }
 
@@ -196,6 +211,7 @@ func (r replicator) initializeReplicationWithCheck(
 		make(chan struct{}, concurrentRealityRequests),
 		r.timeout,
 		nodeQueue,
+		r.disruptions,
 	)
 
 	var session consul.Session
@@ -8,6 +8,7 @@ import (
 
 	"github.com/square/p2/pkg/alerting"
 	"github.com/square/p2/pkg/audit"
+	"github.com/square/p2/pkg/disruptionbudget"
 	"github.com/square/p2/pkg/health/checker"
 	"github.com/square/p2/pkg/labels"
 	"github.com/square/p2/pkg/logging"
@@ -40,6 +41,7 @@ type UpdateFactory struct {
 	RCLocker      ReplicationControllerLocker
 	RCStore       ReplicationControllerStore
 	RCStatusStore RCStatusStore
+	RUStatusStore RUStatusStore
 	RollStore     RollingUpdateStore
 	HealthChecker checker.HealthChecker
 	Labeler       labeler
@@ -48,6 +50,10 @@ type UpdateFactory struct {
 
 	ShouldCreateAuditLogRecords bool
 	AuditLogStore               auditlogstore.ConsulStore
+
+	// DisruptionBudgets, if set, limits how many pods updates may take
+	// down at once
+	DisruptionBudgets disruptionbudget.Budgets
 }
 
 type labeler interface {
@@ -62,6 +68,7 @@ func NewUpdateFactory(
 	rcLocker ReplicationControllerLocker,
 	rcStore ReplicationControllerStore,
 	rcStatusStore RCStatusStore,
+	ruStatusStore RUStatusStore,
 	rollStore RollingUpdateStore,
 	healthChecker checker.HealthChecker,
 	labeler labeler,
@@ -77,6 +84,7 @@ func NewUpdateFactory(
 		RCLocker:                    rcLocker,
 		RCStore:                     rcStore,
 		RCStatusStore:               rcStatusStore,
+		RUStatusStore:               ruStatusStore,
 		RollStore:                   rollStore,
 		HealthChecker:               healthChecker,
 		Labeler:                     labeler,
@@ -95,6 +103,7 @@ func (f UpdateFactory) New(u roll_fields.Update, l logging.Logger, session consu
 		f.RCLocker,
 		f.RCStore,
 		f.RCStatusStore,
+		f.RUStatusStore,
 		f.RollStore,
 		f.Txner,
 		f.HealthChecker,
@@ -105,6 +114,7 @@ func (f UpdateFactory) New(u roll_fields.Update, l logging.Logger, session consu
 		f.Alerter,
 		f.ShouldCreateAuditLogRecords,
 		f.AuditLogStore,
+		f.DisruptionBudgets,
 	)
 }
 
@@ -114,7 +124,11 @@ type RCGetter interface {
 
 type RollingUpdateStore interface {
 	Watch(quit <-chan struct{}, jitterWindow time.Duration) (<-chan []roll_fields.Update, <-chan error)
+	Get(id roll_fields.ID) (roll_fields.Update, error)
+	WatchRU(id roll_fields.ID, quit <-chan struct{}) (<-chan roll_fields.Update, <-chan error)
 	Delete(ctx context.Context, id roll_fields.ID) error
+	SetRollingBackTxn(ctx context.Context, id roll_fields.ID) error
+	AdvanceStepTxn(ctx context.Context, id roll_fields.ID, fromStep int) error
 }
 
 // The Farm is responsible for spawning and reaping rolling updates as they are
@@ -1,9 +1,12 @@
 package fields
 
 import (
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/square/p2/pkg/rc/fields"
+	"github.com/square/p2/pkg/util"
 )
 
 type ID string
@@ -52,6 +55,168 @@ type Update struct {
 	// unhealthy after being healthy for a short duration. Naive implementations like
 	// p2-replicate do not handle such after-the-fact unhealthiness. Default is 0.
 	RollDelay time.Duration
+
+	// RollbackPolicy, if set, causes the update to reverse direction when
+	// the new RC's pods become unhealthy. The old RC will be scaled back up
+	// to DesiredReplicas and the new RC will be drained. If nil, the update
+	// will only ever move forward and will stall if the new RC's pods are
+	// unhealthy.
+	RollbackPolicy *RollbackPolicy
+
+	// RollingBack is set by the farm processing the update once the
+	// RollbackPolicy has been triggered. It is persisted so that a farm
+	// that picks up the update later continues the rollback instead of
+	// resuming the rollout. It should not be set when creating an update.
+	RollingBack bool
+
+	// Steps optionally breaks the update into stages, for example one
+	// canary node, then 10%, then 50% and finally all of DesiredReplicas.
+	// The update will not move replicas to the new RC beyond the current
+	// step's target until the step is complete. If empty, the update rolls
+	// straight through to DesiredReplicas.
+	Steps []Step
+
+	// CurrentStep is the index into Steps of the step the update is
+	// working on. It is advanced by the farm processing the update when a
+	// step completes, or by an operator approving a step. A value equal to
+	// len(Steps) means all steps are complete and the update proceeds to
+	// DesiredReplicas.
+	CurrentStep int
+
+	// Paused, if set, stops the update from making any further changes to
+	// the replica counts of its RCs until it is unset. The farm processing
+	// the update continues to hold the RC locks while it is paused, so no
+	// other update can take over the RCs.
+	Paused bool
+}
+
+// A Step is one stage of a staged rollout. Exactly one of Replicas or
+// Percent should be set.
+type Step struct {
+	// Replicas is the absolute number of replicas the new RC should have
+	// when this step is complete.
+	Replicas int
+
+	// Percent is the percentage of DesiredReplicas the new RC should have
+	// when this step is complete. It is rounded up, so any non-zero
+	// percentage results in at least one replica.
+	Percent int
+
+	// Pause is how long to wait after the new RC's replicas for this step
+	// are healthy before moving on to the next step.
+	Pause time.Duration
+
+	// ManualApproval, if set, causes the update to wait once this step's
+	// replicas are healthy until an operator advances the update to the
+	// next step.
+	ManualApproval bool
+}
+
+// Target returns the number of replicas the new RC should have when the step
+// is complete, given the update's DesiredReplicas.
+func (s Step) Target(desiredReplicas int) int {
+	target := s.Replicas
+	if s.Percent > 0 {
+		target = (desiredReplicas*s.Percent + 99) / 100
+	}
+
+	if target > desiredReplicas {
+		return desiredReplicas
+	}
+	return target
+}
+
+// ParseStep parses a step from its command line representation: a replica
+// count or percentage, optionally followed by comma separated options. For
+// example "1,manual" is a single canary replica that requires approval and
+// "50%,pause=10m" is half of the desired replicas followed by a ten minute
+// pause.
+func ParseStep(str string) (Step, error) {
+	parts := strings.Split(str, ",")
+
+	var step Step
+	var err error
+	if strings.HasSuffix(parts[0], "%") {
+		step.Percent, err = strconv.Atoi(strings.TrimSuffix(parts[0], "%"))
+		if err != nil || step.Percent <= 0 || step.Percent > 100 {
+			return Step{}, util.Errorf("invalid step percentage %q", parts[0])
+		}
+	} else {
+		step.Replicas, err = strconv.Atoi(parts[0])
+		if err != nil || step.Replicas <= 0 {
+			return Step{}, util.Errorf("invalid step replica count %q", parts[0])
+		}
+	}
+
+	for _, option := range parts[1:] {
+		switch {
+		case option == "manual":
+			step.ManualApproval = true
+		case strings.HasPrefix(option, "pause="):
+			step.Pause, err = time.ParseDuration(strings.TrimPrefix(option, "pause="))
+			if err != nil {
+				return Step{}, util.Errorf("invalid step pause %q: %s", option, err)
+			}
+		default:
+			return Step{}, util.Errorf("unknown step option %q", option)
+		}
+	}
+
+	return step, nil
+}
+
+// ValidateSteps returns an error if a step of the update targets fewer
+// replicas than the step before it, given the update's DesiredReplicas. The
+// update never takes replicas away from the new RC, so such a step would be
+// reached as soon as it started and would not stage the rollout.
+func (u Update) ValidateSteps() error {
+	for i := 1; i < len(u.Steps); i++ {
+		previous := u.Steps[i-1].Target(u.DesiredReplicas)
+		target := u.Steps[i].Target(u.DesiredReplicas)
+		if target < previous {
+			return util.Errorf("step %d targets %d of %d replicas, fewer than the %d targeted by step %d", i+1, target, u.DesiredReplicas, previous, i)
+		}
+	}
+	return nil
+}
+
+// TargetReplicas returns the number of replicas the RC taking over replicas
+// should currently be working towards. This is the target of the current step,
+// or DesiredReplicas if there are no steps remaining or the update is rolling
+// back.
+func (u Update) TargetReplicas() int {
+	if u.RollingBack || u.CurrentStep < 0 || u.CurrentStep >= len(u.Steps) {
+		return u.DesiredReplicas
+	}
+
+	return u.Steps[u.CurrentStep].Target(u.DesiredReplicas)
+}
+
+// RollbackPolicy describes the conditions under which a rolling update
+// should give up on the new RC and return to the old one.
+type RollbackPolicy struct {
+	// MaxUnhealthyRatio is the fraction of the new RC's real pods (pods
+	// that are running the new manifest) that may be unhealthy before the
+	// new RC is considered to be failing. For example, a value of 0.5 means
+	// the new RC is failing once more than half of its pods are unhealthy.
+	// The default of 0 means that any unhealthy pod counts as a failure.
+	MaxUnhealthyRatio float64
+
+	// MaxUnhealthyDuration is how long the new RC must be continuously
+	// failing before the update is rolled back. This can be used to
+	// tolerate pods that are briefly unhealthy while starting up. The
+	// default of 0 rolls back as soon as a failure is observed.
+	MaxUnhealthyDuration time.Duration
+}
+
+// Exceeded returns true if the given number of unhealthy pods out of the
+// given number of real pods is more than the policy tolerates.
+func (p RollbackPolicy) Exceeded(unhealthy int, real int) bool {
+	if unhealthy <= 0 || real <= 0 {
+		return false
+	}
+
+	return float64(unhealthy)/float64(real) > p.MaxUnhealthyRatio
 }
 
 // Implementation detail: a rolling updates ID matches that of it's NewRC. We may
@@ -8,9 +8,11 @@ import (
 
 	"github.com/square/p2/pkg/alerting"
 	"github.com/square/p2/pkg/audit"
+	"github.com/square/p2/pkg/disruptionbudget"
 	"github.com/square/p2/pkg/health"
 	"github.com/square/p2/pkg/logging"
 	"github.com/square/p2/pkg/manifest"
+	pc_fields "github.com/square/p2/pkg/pc/fields"
 	"github.com/square/p2/pkg/pods"
 	"github.com/square/p2/pkg/rc"
 	rcf "github.com/square/p2/pkg/rc/fields"
@@ -20,6 +22,7 @@ import (
 	"github.com/square/p2/pkg/store/consul/consulutil"
 	"github.com/square/p2/pkg/store/consul/rcstore"
 	"github.com/square/p2/pkg/store/consul/statusstore/rcstatus"
+	"github.com/square/p2/pkg/store/consul/statusstore/rustatus"
 	"github.com/square/p2/pkg/store/consul/transaction"
 	"github.com/square/p2/pkg/types"
 	"github.com/square/p2/pkg/util"
@@ -78,6 +81,7 @@ type update struct {
 	consulClient  consulutil.ConsulClient
 	rcStore       ReplicationControllerStore
 	rcStatusStore RCStatusStore
+	ruStatusStore RUStatusStore
 	rollStore     RollingUpdateStore
 	rcLocker      ReplicationControllerLocker
 	hcheck        ServiceWatcher
@@ -100,12 +104,41 @@ type update struct {
 	// to signify that the rolling update was successful
 	shouldCreateAuditLogRecords bool
 	auditLogStore               auditlogstore.ConsulStore
+
+	// disruptions limits the number of pods the update may take down at
+	// once, in addition to the update's minimum replicas
+	disruptions disruptionbudget.Budgets
+
+	// disruptionsReleased is set once the update's disruption budget
+	// reservations have been released after the RCs caught up, and unset
+	// when it reserves more, so that they aren't released again on every
+	// health check
+	disruptionsReleased bool
+
+	// unhealthySince records when the new RC started continuously
+	// exceeding the update's rollback policy. It is the zero time if the
+	// new RC is not currently considered to be failing.
+	unhealthySince time.Time
+
+	// stepReachedAt records when the new RC first reached the current
+	// step's target with all replicas healthy, and is used to enforce the
+	// step's pause. It is the zero time if the step has not been reached.
+	stepReachedAt time.Time
+
+	// lastStatus is the status most recently written to the status store,
+	// used to avoid rewriting it when nothing has changed
+	lastStatus rustatus.Status
 }
 
 type RCStatusStore interface {
 	Get(rcID rcf.ID) (rcstatus.Status, *api.QueryMeta, error)
 }
 
+type RUStatusStore interface {
+	Set(id fields.ID, status rustatus.Status) error
+	DeleteTxn(ctx context.Context, id fields.ID) error
+}
+
 // Create a new Update. The consul.Store, rcstore.Store, and labels.Applicator
 // arguments should be the same as those of the RCs themselves. The
 // session must be valid for the lifetime of the Update; maintaining this is the
@@ -117,6 +150,7 @@ func NewUpdate(
 	rcLocker ReplicationControllerLocker,
 	rcStore ReplicationControllerStore,
 	rcStatusStore RCStatusStore,
+	ruStatusStore RUStatusStore,
 	rollStore RollingUpdateStore,
 	txner transaction.Txner,
 	hcheck ServiceWatcher,
@@ -127,7 +161,11 @@ func NewUpdate(
 	alerter alerting.Alerter,
 	shouldCreateAuditLogRecords bool,
 	auditLogStore auditlogstore.ConsulStore,
+	disruptions disruptionbudget.Budgets,
 ) Update {
+	if disruptions == nil {
+		disruptions = disruptionbudget.NewNop()
+	}
 	logger = logger.SubLogger(logrus.Fields{
 		"desired_replicas": f.DesiredReplicas,
 		"minimum_replicas": f.MinimumReplicas,
@@ -138,6 +176,7 @@ func NewUpdate(
 		rcLocker:                    rcLocker,
 		rcStore:                     rcStore,
 		rcStatusStore:               rcStatusStore,
+		ruStatusStore:               ruStatusStore,
 		rollStore:                   rollStore,
 		txner:                       txner,
 		hcheck:                      hcheck,
@@ -148,6 +187,7 @@ func NewUpdate(
 		consulClient:                consulClient,
 		auditLogStore:               auditLogStore,
 		shouldCreateAuditLogRecords: shouldCreateAuditLogRecords,
+		disruptions:                 disruptions,
 	}
 }
 
@@ -328,8 +368,10 @@ func (u *update) Run(ctx context.Context) (ret bool) {
 		return false
 	}
 
-	// rollout complete, clean up old RC if told to do so
-	if !u.LeaveOld {
+	// rollout complete, clean up old RC if told to do so. If the update was
+	// rolled back the old RC is the one that should survive, so it is
+	// never cleaned up
+	if !u.LeaveOld && !u.RollingBack {
 		ok = u.cleanupOldRC(cleanupCtx)
 		if !ok {
 			// we already alerted inside cleanupOldRC
@@ -350,8 +392,20 @@ func (u *update) Run(ctx context.Context) (ret bool) {
 		return false
 	}
 
+	err = u.ruStatusStore.DeleteTxn(cleanupCtx, u.ID())
+	if err != nil {
+		u.logger.WithError(err).Errorln("could not construct transaction to delete RU status")
+		u.mustAlert(
+			context.Background(),
+			"could not build RU deletion transaction due to RU status operation",
+			"ru-deletion-txn"+u.ID().String(),
+			err,
+		)
+		return false
+	}
+
 	if u.shouldCreateAuditLogRecords {
-		succeeded := true
+		succeeded := !u.RollingBack
 		canceled := false
 		details, err := audit.NewRUCompletionEventDetails(u.ID(), succeeded, canceled, u.labeler)
 		if err != nil {
@@ -482,6 +536,10 @@ func (u *update) cleanupOldRC(ctx context.Context) bool {
 
 // returns true if roll succeeded, false if asked to quit.
 func (u *update) rollLoop(ctx context.Context, podID types.PodID, hChecks <-chan map[types.NodeName]health.Result, hErrs <-chan error) bool {
+	// operators change the update's record while it is running, so it is
+	// watched for changes instead of being read on every health check
+	ruUpdates, ruErrs := u.rollStore.WatchRU(u.ID(), ctx.Done())
+
 	for {
 		// Select on just the quit channel before entering the select with both quit and hChecks. This protects against a situation where
 		// hChecks and quit are both ready, and hChecks might be chosen due to the random choice semantics of select {}. If multiple
@@ -498,6 +556,18 @@ func (u *update) rollLoop(ctx context.Context, podID types.PodID, hChecks <-chan
 			return false
 		case err := <-hErrs:
 			u.logger.WithError(err).Errorln("Could not read health checks")
+		case ru, ok := <-ruUpdates:
+			if !ok {
+				ruUpdates = nil
+				break
+			}
+			u.refresh(ru)
+		case err, ok := <-ruErrs:
+			if !ok {
+				ruErrs = nil
+				break
+			}
+			u.logger.WithError(err).Errorln("Could not watch rolling update")
 		case checks := <-hChecks:
 			newNodes, err := u.countHealthy(u.NewRC, checks)
 			if err != nil {
@@ -514,13 +584,63 @@ func (u *update) rollLoop(ctx context.Context, podID types.PodID, hChecks <-chan
 				break
 			}
 
-			if nextAction := u.shouldStop(oldNodes, newNodes); nextAction == ruShouldTerminate {
+			if u.Paused {
+				u.publishStatus(oldNodes, newNodes, 0, 0, "update is paused")
+				u.logger.NoFields().Debugln("Update is paused, not scheduling")
+				break
+			}
+
+			if u.shouldRollback(newNodes, time.Now()) {
+				reason := fmt.Sprintf(
+					"%d of %d pods on new RC %s were unhealthy for at least %s",
+					newNodes.Unhealthy,
+					newNodes.Real,
+					u.NewRC,
+					u.RollbackPolicy.MaxUnhealthyDuration,
+				)
+				u.logger.WithFields(logrus.Fields{
+					"old": oldNodes.ToString(),
+					"new": newNodes.ToString(),
+				}).Warnf("Rollback policy triggered: %s", reason)
+
+				err = u.startRollback(ctx, reason)
+				if err != nil {
+					u.logger.WithError(err).Errorln("Could not start rollback")
+					break
+				}
+			}
+
+			// fromNodes and toNodes are the counts for the RC that is
+			// giving up replicas and the RC that is taking them over.
+			// When rolling back these are the new and old RCs
+			// respectively
+			fromRC, toRC := u.rollDirection()
+			fromNodes, toNodes := oldNodes, newNodes
+			if u.RollingBack {
+				fromNodes, toNodes = newNodes, oldNodes
+			} else {
+				err = u.advanceSteps(ctx, newNodes, time.Now())
+				if err != nil {
+					u.logger.WithError(err).Errorln("Could not advance rolling update step")
+					break
+				}
+			}
+
+			u.releaseSettledDisruptions(fromRC, podID, fromNodes, toNodes)
+
+			if nextAction := u.shouldStop(fromNodes, toNodes); nextAction == ruShouldTerminate {
 				u.logger.WithFields(logrus.Fields{
 					"old": oldNodes.ToString(),
 					"new": newNodes.ToString(),
 				}).Debugln("Upgrade complete")
 				return true
 			} else if nextAction == ruShouldBlock {
+				u.publishStatus(oldNodes, newNodes, 0, 0, fmt.Sprintf(
+					"waiting for RC %s to schedule itself on %d nodes, currently on %d",
+					toRC,
+					u.DesiredReplicas,
+					toNodes.Current,
+				))
 				u.logger.WithFields(logrus.Fields{
 					"old": oldNodes.ToString(),
 					"new": newNodes.ToString(),
@@ -528,19 +648,24 @@ func (u *update) rollLoop(ctx context.Context, podID types.PodID, hChecks <-chan
 				break
 			}
 
-			nextRemove, nextAdd := rollAlgorithm(u.rollAlgorithmParams(oldNodes, newNodes))
+			nextRemove, nextAdd := rollAlgorithm(u.rollAlgorithmParams(fromNodes, toNodes))
 			if nextRemove > 0 || nextAdd > 0 {
 				// apply the delay only if we've already added to the new RC, since there's
 				// no value in sitting around doing nothing before anything has happened.
-				if newNodes.Desired > 0 && u.RollDelay > time.Duration(0) {
+				if toNodes.Desired > 0 && u.RollDelay > time.Duration(0) {
+					u.publishStatus(oldNodes, newNodes, nextRemove, nextAdd, fmt.Sprintf("waiting for roll delay of %s", u.RollDelay))
 					u.logger.WithField("delay", u.RollDelay).Infof("Waiting %v before continuing deploy", u.RollDelay)
 
-					select {
-					case <-time.After(u.RollDelay):
-					case <-ctx.Done():
+					if !u.waitRollDelay(ctx, ruUpdates) {
 						return false
 					}
 
+					// the update may have been paused during the delay
+					if u.Paused {
+						u.logger.NoFields().Infoln("Update was paused during roll delay, not scheduling")
+						break
+					}
+
 					// determine the new value of `next`, which may have changed
 					// following the delay.
 					nextRemove, nextAdd, err = u.shouldRollAfterDelay(podID)
@@ -551,6 +676,25 @@ func (u *update) rollLoop(ctx context.Context, podID types.PodID, hChecks <-chan
 					}
 				}
 
+				budgetRequest, err := u.disruptionRequest(fromRC, podID)
+				if err != nil {
+					u.logger.WithError(err).Errorln("Could not check disruption budget")
+					break
+				}
+				allowed, limited, err := u.disruptions.Allowed(budgetRequest)
+				if err != nil {
+					u.logger.WithError(err).Errorln("Could not check disruption budget")
+					break
+				}
+				if limited && nextRemove > allowed {
+					nextRemove = allowed
+					if nextRemove == 0 && nextAdd == 0 {
+						u.publishStatus(oldNodes, newNodes, 0, 0, "waiting for the disruption budget to allow pods to be taken down")
+						u.logger.NoFields().Debugln("Blocking for disruption budget")
+						break
+					}
+				}
+
 				u.logger.WithFields(logrus.Fields{
 					"old":        oldNodes.ToString(),
 					"new":        newNodes.ToString(),
@@ -558,16 +702,26 @@ func (u *update) rollLoop(ctx context.Context, podID types.PodID, hChecks <-chan
 					"nextAdd":    nextAdd,
 				}).Infof("Adding %d new nodes and removing %d old nodes", nextAdd, nextRemove)
 				transferReq := rcstore.TransferReplicaCountsRequest{
-					ToRCID:               u.NewRC,
-					FromRCID:             u.OldRC,
+					ToRCID:               toRC,
+					FromRCID:             fromRC,
 					ReplicasToAdd:        &nextAdd,
 					ReplicasToRemove:     &nextRemove,
-					StartingToReplicas:   &newNodes.Desired,
-					StartingFromReplicas: &oldNodes.Desired,
+					StartingToReplicas:   &toNodes.Desired,
+					StartingFromReplicas: &fromNodes.Desired,
 				}
 
 				// branch off of the passed ctx which implicitly ensures that RC locks are held
 				transferCtx, cancel := transaction.New(ctx)
+				budgetRequest.Count = nextRemove
+				err = u.disruptions.ReserveTxn(transferCtx, budgetRequest)
+				if err != nil {
+					// the budget may have been used up since
+					// it was checked, in which case the next
+					// iteration will remove fewer nodes
+					cancel()
+					u.logger.WithError(err).Errorln("could not reserve disruption budget")
+					break
+				}
 				err = u.rcStore.TransferReplicaCounts(transferCtx, transferReq)
 				if err != nil {
 					// this error is really bad because it means
@@ -581,7 +735,7 @@ func (u *update) rollLoop(ctx context.Context, podID types.PodID, hChecks <-chan
 					panic(fmt.Sprintf("could not update RC replica counts: %s", err))
 				}
 
-				err := transaction.MustCommit(transferCtx, u.txner)
+				err = transaction.MustCommit(transferCtx, u.txner)
 				if err != nil {
 					// This can happen for a few reasons:
 					// 1) a CAS violation in the operations added
@@ -596,7 +750,10 @@ func (u *update) rollLoop(ctx context.Context, podID types.PodID, hChecks <-chan
 					break
 				}
 				cancel()
+				u.disruptionsReleased = false
+				u.publishStatus(oldNodes, newNodes, nextRemove, nextAdd, "")
 			} else {
+				u.publishStatus(oldNodes, newNodes, 0, 0, u.blockedReason(toRC, fromNodes, toNodes))
 				u.logger.WithFields(logrus.Fields{
 					"old": oldNodes.ToString(),
 					"new": newNodes.ToString(),
@@ -606,6 +763,9 @@ func (u *update) rollLoop(ctx context.Context, podID types.PodID, hChecks <-chan
 	}
 }
 
+// shouldStop decides whether the update is finished based on the counts of
+// the RC giving up replicas and the RC taking them over. Normally these are
+// the old and new RC respectively, but they are reversed when rolling back.
 func (u *update) shouldStop(oldNodes, newNodes rcNodeCounts) ruStep {
 	if newNodes.Desired < u.DesiredReplicas {
 		// Not enough nodes scheduled on the new side, so deploy should continue.
@@ -633,6 +793,248 @@ func (u *update) shouldStop(oldNodes, newNodes rcNodeCounts) ruStep {
 	return ruShouldBlock
 }
 
+// rollDirection returns the ID of the RC that replicas are being moved away
+// from and the ID of the RC they are being moved to. These are the old and new
+// RCs respectively unless the update is rolling back.
+func (u *update) rollDirection() (from rcf.ID, to rcf.ID) {
+	if u.RollingBack {
+		return u.NewRC, u.OldRC
+	}
+	return u.OldRC, u.NewRC
+}
+
+// shouldRollback returns true if the update has a rollback policy and the new
+// RC has exceeded it for at least the policy's MaxUnhealthyDuration. It
+// tracks how long the policy has been exceeded across calls, so it should be
+// called once for each set of health results.
+func (u *update) shouldRollback(newNodes rcNodeCounts, now time.Time) bool {
+	if u.RollbackPolicy == nil || u.RollingBack {
+		return false
+	}
+
+	if !u.RollbackPolicy.Exceeded(newNodes.Unhealthy, newNodes.Real) {
+		u.unhealthySince = time.Time{}
+		return false
+	}
+
+	if u.unhealthySince.IsZero() {
+		u.unhealthySince = now
+	}
+
+	return now.Sub(u.unhealthySince) >= u.RollbackPolicy.MaxUnhealthyDuration
+}
+
+// blockedReason explains why the roll algorithm decided not to move any
+// replicas given the counts of the RC giving up replicas and the RC taking
+// them over.
+func (u *update) blockedReason(toRC rcf.ID, fromNodes, toNodes rcNodeCounts) string {
+	target := u.TargetReplicas()
+	if toNodes.Desired < target {
+		return fmt.Sprintf(
+			"moving any replicas could take the %d healthy replicas below the minimum of %d",
+			fromNodes.Healthy+toNodes.Healthy,
+			u.MinimumReplicas,
+		)
+	}
+
+	if u.RollingBack || u.CurrentStep >= len(u.Steps) {
+		return fmt.Sprintf("waiting for RC %s to reach %d replicas", toRC, target)
+	}
+
+	step := u.Steps[u.CurrentStep]
+	switch {
+	case toNodes.Healthy < target:
+		return fmt.Sprintf("waiting for %d replicas of step %d of %d to be healthy", target, u.CurrentStep+1, len(u.Steps))
+	case step.ManualApproval:
+		return fmt.Sprintf("step %d of %d requires approval", u.CurrentStep+1, len(u.Steps))
+	default:
+		return fmt.Sprintf("pausing for %s after step %d of %d", step.Pause, u.CurrentStep+1, len(u.Steps))
+	}
+}
+
+// publishStatus records the update's progress in the status store so that it
+// can be inspected by operators. The status is only written when it differs
+// from the one most recently written. Failures are logged but are otherwise
+// not fatal to the update.
+func (u *update) publishStatus(oldNodes, newNodes rcNodeCounts, nextRemove, nextAdd int, blockedReason string) {
+	status := rustatus.Status{
+		OldRC:         oldNodes.toStatus(u.OldRC),
+		NewRC:         newNodes.toStatus(u.NewRC),
+		NextRemove:    nextRemove,
+		NextAdd:       nextAdd,
+		RollingBack:   u.RollingBack,
+		Paused:        u.Paused,
+		BlockedReason: blockedReason,
+		LastChanged:   u.lastStatus.LastChanged,
+	}
+	if status == u.lastStatus {
+		return
+	}
+
+	status.LastChanged = time.Now()
+	err := u.ruStatusStore.Set(u.ID(), status)
+	if err != nil {
+		u.logger.WithError(err).Warnln("Could not write rolling update status")
+		return
+	}
+	u.lastStatus = status
+}
+
+// refresh picks up the changes made by operators to the update's record while
+// the update is running: pausing or resuming it, and advancing it past a step.
+func (u *update) refresh(ru fields.Update) {
+	if ru.Paused != u.Paused {
+		if ru.Paused {
+			u.logger.NoFields().Infoln("Update was paused")
+		} else {
+			u.logger.NoFields().Infoln("Update was resumed")
+		}
+		u.Paused = ru.Paused
+	}
+
+	// operators may advance the update at any time, either to approve a
+	// step that requires it or to skip a step's pause
+	if ru.CurrentStep > u.CurrentStep {
+		u.logger.WithField("step", ru.CurrentStep).Infoln("Update was advanced by an operator")
+		u.CurrentStep = ru.CurrentStep
+		u.stepReachedAt = time.Time{}
+	}
+}
+
+// waitRollDelay waits for the update's roll delay, picking up changes to its
+// record in the meantime. It returns false if ctx is done first.
+func (u *update) waitRollDelay(ctx context.Context, ruUpdates <-chan fields.Update) bool {
+	delay := time.After(u.RollDelay)
+	for {
+		select {
+		case <-delay:
+			return true
+		case ru, ok := <-ruUpdates:
+			if !ok {
+				ruUpdates = nil
+				break
+			}
+			u.refresh(ru)
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// advanceSteps moves the update past any of its steps that are complete. A
+// step is complete when the new RC has the step's target number of replicas,
+// all of them are healthy, and the step's pause or manual approval gate has
+// been satisfied. Progress is recorded in consul so that another farm picking
+// up the update will resume from the same step. The passed context is
+// expected to check that the RC locks are held.
+func (u *update) advanceSteps(ctx context.Context, newNodes rcNodeCounts, now time.Time) error {
+	for u.CurrentStep < len(u.Steps) {
+		step := u.Steps[u.CurrentStep]
+		target := step.Target(u.DesiredReplicas)
+		if newNodes.Desired < target || newNodes.Healthy < target {
+			return nil
+		}
+
+		if u.stepReachedAt.IsZero() {
+			u.stepReachedAt = now
+			u.logger.WithFields(logrus.Fields{
+				"step":   u.CurrentStep,
+				"target": target,
+			}).Infof("Reached step %d of %d", u.CurrentStep+1, len(u.Steps))
+		}
+
+		if step.ManualApproval {
+			u.logger.WithField("step", u.CurrentStep).Debugln("Waiting for an operator to approve step")
+			return nil
+		}
+
+		if now.Sub(u.stepReachedAt) < step.Pause {
+			return nil
+		}
+
+		txnCtx, cancel := transaction.New(ctx)
+		err := u.rollStore.AdvanceStepTxn(txnCtx, u.ID(), u.CurrentStep)
+		if err != nil {
+			cancel()
+			return err
+		}
+
+		err = transaction.MustCommit(txnCtx, u.txner)
+		cancel()
+		if err != nil {
+			return err
+		}
+
+		u.CurrentStep++
+		u.stepReachedAt = time.Time{}
+	}
+
+	return nil
+}
+
+// startRollback reverses the direction of the update: the new RC is disabled,
+// the old RC is enabled, and the update is marked as rolling back in consul so
+// that the rollback will be continued if another farm picks up the update.
+// The passed context is expected to check that the RC locks are held.
+func (u *update) startRollback(ctx context.Context, reason string) error {
+	oldRC, err := u.rcStore.Get(u.OldRC)
+	if err != nil {
+		return err
+	}
+
+	// the old RC is about to be enabled, so the same caution that is
+	// exercised when enabling the new RC at the beginning of the update
+	// applies here
+	if oldRC.Disabled {
+		err = u.validateRCCounts(oldRC)
+		if err != nil {
+			return err
+		}
+	}
+
+	txnCtx, cancel := transaction.New(ctx)
+	defer cancel()
+
+	err = u.rollStore.SetRollingBackTxn(txnCtx, u.ID())
+	if err != nil {
+		return err
+	}
+
+	err = u.rcStore.DisableTxn(txnCtx, u.NewRC)
+	if err != nil {
+		return err
+	}
+
+	err = u.rcStore.EnableTxn(txnCtx, u.OldRC)
+	if err != nil {
+		return err
+	}
+
+	if u.shouldCreateAuditLogRecords {
+		details, err := audit.NewRURollbackEventDetails(u.ID(), reason, u.labeler)
+		if err != nil {
+			return err
+		}
+
+		err = u.auditLogStore.Create(txnCtx, audit.RURollbackEvent, details)
+		if err != nil {
+			return err
+		}
+	}
+
+	ok, resp, err := transaction.CommitWithRetries(txnCtx, u.txner)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return util.Errorf("could not start rollback due to transaction failure: %s", transaction.TxnErrorsToString(resp.Errors))
+	}
+
+	u.RollingBack = true
+	return nil
+}
+
 func (u *update) lockRCs(
 	lockCtx context.Context,
 	unlockCtx context.Context,
@@ -675,16 +1077,18 @@ func (u *update) lockRCs(
 }
 
 // enable sets the old & new RCs to a known-good state to start a rolling update:
-// the old RC should be disabled and the new RC should be enabled.
+// the old RC should be disabled and the new RC should be enabled. If the
+// update is rolling back, the reverse is true.
 func (u *update) enable(checkLocksCtx context.Context) error {
-	newRC, err := u.rcStore.Get(u.NewRC)
+	fromID, toID := u.rollDirection()
+	toRC, err := u.rcStore.Get(toID)
 	if err != nil {
-		u.logger.WithError(err).Errorln("could not fetch new RC for enabling")
+		u.logger.WithError(err).Errorln("could not fetch RC for enabling")
 		return err
 	}
 
-	if newRC.Disabled {
-		err = u.validateNewRCCounts(newRC)
+	if toRC.Disabled {
+		err = u.validateRCCounts(toRC)
 		if err != nil {
 			return err
 		}
@@ -695,12 +1099,12 @@ func (u *update) enable(checkLocksCtx context.Context) error {
 	// We do this AFTER the convergence check, because we don't want to reach this state:
 	// disabled, 1 desired, 2 labeled | disabled, 1 desired, 0 labeled.
 	// In this case, neither RC will act, so manual intervention is required.
-	err = u.rcStore.DisableTxn(ctx, u.OldRC)
+	err = u.rcStore.DisableTxn(ctx, fromID)
 	if err != nil {
 		return err
 	}
 
-	err = u.rcStore.EnableTxn(ctx, u.NewRC)
+	err = u.rcStore.EnableTxn(ctx, toID)
 	if err != nil {
 		return err
 	}
@@ -717,7 +1121,7 @@ func (u *update) enable(checkLocksCtx context.Context) error {
 	return nil
 }
 
-func (u *update) validateNewRCCounts(newRC rcf.RC) error {
+func (u *update) validateRCCounts(rcFields rcf.RC) error {
 	// We must exercise caution before enabling a disabled RC.
 	//
 	// Consider a deploy:
@@ -737,13 +1141,13 @@ func (u *update) validateNewRCCounts(newRC rcf.RC) error {
 	// Solution: Wait until formerly-old RC has only 1 node labeled.
 	// TODO: We can explore whether it's safe to just set the RCs to 2 desired if it has 2 RCs labeled,
 	// but we would be more comfortable with this if we could ascertain there is no chance of race.
-	currentPods, err := rc.CurrentPods(u.NewRC, u.labeler)
+	currentPods, err := rc.CurrentPods(rcFields.ID, u.labeler)
 	if err != nil {
 		return err
 	}
 
-	if len(currentPods) != newRC.ReplicasDesired {
-		return util.Errorf("RC %s currently has %d replicas but wants %d - waiting until it matches to enable.", u.NewRC, len(currentPods), newRC.ReplicasDesired)
+	if len(currentPods) != rcFields.ReplicasDesired {
+		return util.Errorf("RC %s currently has %d replicas but wants %d - waiting until it matches to enable.", rcFields.ID, len(currentPods), rcFields.ReplicasDesired)
 	}
 
 	return nil
@@ -766,6 +1170,18 @@ func (r rcNodeCounts) ToString() string {
 	return fmt.Sprintf("%+v", r)
 }
 
+func (r rcNodeCounts) toStatus(id rcf.ID) rustatus.RCCounts {
+	return rustatus.RCCounts{
+		ID:        id,
+		Desired:   r.Desired,
+		Current:   r.Current,
+		Real:      r.Real,
+		Healthy:   r.Healthy,
+		Unhealthy: r.Unhealthy,
+		Unknown:   r.Unknown,
+	}
+}
+
 func (u *update) countHealthy(id rcf.ID, checks map[types.NodeName]health.Result) (rcNodeCounts, error) {
 	ret := rcNodeCounts{}
 	rcFields, err := u.rcStore.Get(id)
@@ -846,6 +1262,54 @@ func (u *update) currentNodeIDs() ([]types.NodeName, error) {
 	return nodeIDs, nil
 }
 
+// disruptionRequest returns a request to the disruption budgets of the pods of
+// the RC that is giving up replicas, without a count.
+func (u *update) disruptionRequest(fromRC rcf.ID, podID types.PodID) (disruptionbudget.Request, error) {
+	rcFields, err := u.rcStore.Get(fromRC)
+	if err != nil {
+		return disruptionbudget.Request{}, err
+	}
+
+	return disruptionbudget.Request{
+		PodID:            podID,
+		AvailabilityZone: pc_fields.AvailabilityZone(rcFields.PodLabels[types.AvailabilityZoneLabel]),
+		ClusterName:      pc_fields.ClusterName(rcFields.PodLabels[types.ClusterNameLabel]),
+		Holder:           fmt.Sprintf("rolling-update-%s", u.ID()),
+	}, nil
+}
+
+// releaseSettledDisruptions releases the update's disruption budget
+// reservations once both RCs have caught up with the replica counts transferred
+// between them. By then the pods taken down have been unscheduled and their
+// replacements scheduled, so the budgets see the disruption through the
+// replacements' health instead. Reservations made without a node are never
+// matched to a pod, so they would otherwise count against the budgets until
+// they expire. Once released they are not released again until the update
+// reserves more.
+func (u *update) releaseSettledDisruptions(fromRC rcf.ID, podID types.PodID, fromNodes, toNodes rcNodeCounts) {
+	if u.disruptionsReleased || fromNodes.Current > fromNodes.Desired || toNodes.Current < toNodes.Desired {
+		return
+	}
+
+	budgetRequest, err := u.disruptionRequest(fromRC, podID)
+	if err != nil {
+		u.logger.WithError(err).Errorln("Could not release disruption budget reservations")
+		return
+	}
+	ctx, cancel := transaction.New(context.Background())
+	defer cancel()
+	err = u.disruptions.ReleaseTxn(ctx, budgetRequest)
+	if err == nil {
+		err = transaction.MustCommit(ctx, u.txner)
+	}
+	if err != nil {
+		// the reservations will expire
+		u.logger.WithError(err).Errorln("Could not release disruption budget reservations")
+		return
+	}
+	u.disruptionsReleased = true
+}
+
 func (u *update) shouldRollAfterDelay(podID types.PodID) (int, int, error) {
 	// Check health again following the roll delay. If things have gotten
 	// worse since we last looked, or there is an error, we break this iteration.
@@ -864,7 +1328,12 @@ func (u *update) shouldRollAfterDelay(podID types.PodID) (int, int, error) {
 		return 0, 0, util.Errorf("Could not determine old service health: %v", err)
 	}
 
-	afterDelayRemove, afterDelayAdd := rollAlgorithm(u.rollAlgorithmParams(afterDelayOld, afterDelayNew))
+	afterDelayFrom, afterDelayTo := afterDelayOld, afterDelayNew
+	if u.RollingBack {
+		afterDelayFrom, afterDelayTo = afterDelayNew, afterDelayOld
+	}
+
+	afterDelayRemove, afterDelayAdd := rollAlgorithm(u.rollAlgorithmParams(afterDelayFrom, afterDelayTo))
 
 	if afterDelayRemove <= 0 && afterDelayAdd <= 0 {
 		return 0, 0, util.Errorf("No nodes can be safely updated after %v roll delay, will wait again", u.RollDelay)
@@ -889,7 +1358,7 @@ func (u *update) rollAlgorithmParams(oldHealth, newHealth rcNodeCounts) (oldHeal
 	newHealthy = newHealth.Healthy
 	oldDesired = oldHealth.Desired
 	newDesired = newHealth.Desired
-	targetDesired = u.DesiredReplicas
+	targetDesired = u.TargetReplicas()
 	minHealthy = u.MinimumReplicas
 	return
 }
@@ -7,12 +7,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"math/rand"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/square/p2/pkg/alerting"
 	"github.com/square/p2/pkg/audit"
+	"github.com/square/p2/pkg/disruptionbudget"
 	"github.com/square/p2/pkg/health"
 	checkertest "github.com/square/p2/pkg/health/checker/test"
 	"github.com/square/p2/pkg/labels"
@@ -27,11 +29,14 @@ import (
 	"github.com/square/p2/pkg/store/consul/consulutil"
 	"github.com/square/p2/pkg/store/consul/rcstore"
 	"github.com/square/p2/pkg/store/consul/rollstore"
+	"github.com/square/p2/pkg/store/consul/statusstore"
+	"github.com/square/p2/pkg/store/consul/statusstore/rustatus"
 	"github.com/square/p2/pkg/store/consul/transaction"
 	"github.com/square/p2/pkg/types"
 	"github.com/square/p2/pkg/util"
 
 	. "github.com/anthonybishopric/gotcha"
+	"github.com/hashicorp/consul/api"
 	klabels "k8s.io/kubernetes/pkg/labels"
 )
 
@@ -516,6 +521,8 @@ func updateWithHealth(t *testing.T,
 		auditLogStore:               auditLogStore,
 		shouldCreateAuditLogRecords: true,
 		rollStore:                   rollStore,
+		ruStatusStore:               rustatus.NewConsul(statusstore.NewConsul(fixture.Client), "test"),
+		disruptions:                 disruptionbudget.NewNop(),
 	}, oldManifest, newManifest, rcs, fixture.Stop
 }
 
@@ -1329,3 +1336,477 @@ func TestRollLoopStallsIfUnhealthy(t *testing.T) {
 	wg.Wait()
 	assertRollLoopResult(t, rollLoopResult, false)
 }
+
+func TestShouldRollback(t *testing.T) {
+	upd := update{}
+	now := time.Now()
+	unhealthy := rcNodeCounts{Real: 4, Unhealthy: 2, Healthy: 2}
+	healthy := rcNodeCounts{Real: 4, Healthy: 4}
+
+	if upd.shouldRollback(unhealthy, now) {
+		t.Fatal("expected no rollback for an update without a rollback policy")
+	}
+
+	upd.RollbackPolicy = &fields.RollbackPolicy{
+		MaxUnhealthyRatio:    0.25,
+		MaxUnhealthyDuration: time.Minute,
+	}
+	if upd.shouldRollback(unhealthy, now) {
+		t.Fatal("expected no rollback before the max unhealthy duration has passed")
+	}
+	if !upd.shouldRollback(unhealthy, now.Add(time.Minute)) {
+		t.Fatal("expected rollback after being unhealthy for the max unhealthy duration")
+	}
+
+	// recovering should reset the clock
+	if upd.shouldRollback(healthy, now.Add(2*time.Minute)) {
+		t.Fatal("expected no rollback when the new RC is healthy")
+	}
+	if upd.shouldRollback(unhealthy, now.Add(3*time.Minute)) {
+		t.Fatal("expected the unhealthy duration to be reset after the new RC was healthy")
+	}
+
+	// 1 of 4 unhealthy does not exceed a ratio of 0.25
+	if upd.shouldRollback(rcNodeCounts{Real: 4, Unhealthy: 1, Healthy: 3}, now.Add(time.Hour)) {
+		t.Fatal("expected no rollback when the unhealthy ratio is not exceeded")
+	}
+
+	upd.RollingBack = true
+	if upd.shouldRollback(unhealthy, now.Add(time.Hour)) {
+		t.Fatal("expected no rollback for an update that is already rolling back")
+	}
+}
+
+func TestBlockedReasonNamesRCBeingScaledUp(t *testing.T) {
+	upd := update{}
+	upd.OldRC = "old_rc"
+	upd.NewRC = "new_rc"
+	upd.DesiredReplicas = 3
+	upd.RollingBack = true
+
+	_, toRC := upd.rollDirection()
+	reason := upd.blockedReason(toRC, rcNodeCounts{Desired: 0}, rcNodeCounts{Desired: 3})
+	if !strings.Contains(reason, "old_rc") || strings.Contains(reason, "new_rc") {
+		t.Errorf("expected a rolling back update to be waiting on the old RC but reason was %q", reason)
+	}
+}
+
+// releaseRecorder is a disruptionbudget.Budgets that records the requests
+// whose reservations are released
+type releaseRecorder struct {
+	disruptionbudget.Budgets
+	released []disruptionbudget.Request
+}
+
+func (r *releaseRecorder) ReleaseTxn(ctx context.Context, request disruptionbudget.Request) error {
+	r.released = append(r.released, request)
+	return nil
+}
+
+func TestReleaseSettledDisruptions(t *testing.T) {
+	upd, _, f := updateWithUniformHealth(t, 3, health.Passing)
+	defer f()
+	recorder := &releaseRecorder{Budgets: disruptionbudget.NewNop()}
+	upd.disruptions = recorder
+
+	// the old RC has not yet removed the pods transferred away from it
+	upd.releaseSettledDisruptions(upd.OldRC, "some_pod", rcNodeCounts{Desired: 2, Current: 3}, rcNodeCounts{Desired: 1, Current: 1})
+	// the new RC has not yet scheduled the pods transferred to it
+	upd.releaseSettledDisruptions(upd.OldRC, "some_pod", rcNodeCounts{Desired: 2, Current: 2}, rcNodeCounts{Desired: 1, Current: 0})
+	if len(recorder.released) != 0 {
+		t.Fatalf("expected no reservations to be released before the RCs caught up, but %d were", len(recorder.released))
+	}
+
+	upd.releaseSettledDisruptions(upd.OldRC, "some_pod", rcNodeCounts{Desired: 2, Current: 2}, rcNodeCounts{Desired: 1, Current: 1})
+	if len(recorder.released) != 1 {
+		t.Fatalf("expected the update's reservations to be released once the RCs caught up, but %d releases were made", len(recorder.released))
+	}
+	request := recorder.released[0]
+	if len(request.Nodes) != 0 || request.Holder != fmt.Sprintf("rolling-update-%s", upd.ID()) {
+		t.Errorf("expected every reservation held by the update to be released, but the request was %+v", request)
+	}
+
+	// nothing is reserved until the update transfers more replicas
+	upd.releaseSettledDisruptions(upd.OldRC, "some_pod", rcNodeCounts{Desired: 2, Current: 2}, rcNodeCounts{Desired: 1, Current: 1})
+	if len(recorder.released) != 1 {
+		t.Errorf("expected the reservations not to be released again while the RCs stay settled, but %d releases were made", len(recorder.released))
+	}
+}
+
+func waitForRCDesire(t *testing.T, rcCh <-chan rc_fields.RC, expect int, desc string) {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case rc := <-rcCh:
+			if rc.ReplicasDesired == expect {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %s to have %d replicas desired", desc, expect)
+		}
+	}
+}
+
+// sendChecksUntilRCDesire keeps sending health checks to the roll loop until
+// the RC has the expected replicas desired. The roll loop picks up changes to
+// the update's record asynchronously, so they may only be acted on after a few
+// health checks
+func sendChecksUntilRCDesire(t *testing.T, healths chan<- map[types.NodeName]health.Result, checks map[types.NodeName]health.Result, rcCh <-chan rc_fields.RC, expect int, desc string) {
+	timeout := time.After(5 * time.Second)
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	send := healths
+	for {
+		select {
+		case rc := <-rcCh:
+			if rc.ReplicasDesired == expect {
+				return
+			}
+		case send <- checks:
+			send = nil
+		case <-ticker.C:
+			send = healths
+		case <-timeout:
+			t.Fatalf("timed out waiting for %s to have %d replicas desired", desc, expect)
+		}
+	}
+}
+
+func TestRollLoopRollsBackIfUnhealthy(t *testing.T) {
+	upd, oldManifest, newManifest, rcWatcher, f := updateWithHealth(t, 3, 0, map[types.NodeName]bool{
+		"node1": true,
+		"node2": true,
+		"node3": true,
+	}, nil, nil, nil, rc_fields.StaticStrategy)
+	defer f()
+	upd.DesiredReplicas = 3
+	upd.MinimumReplicas = 2
+	upd.RollbackPolicy = &fields.RollbackPolicy{}
+
+	healths := make(chan map[types.NodeName]health.Result)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var wg sync.WaitGroup
+	oldRCCh := watchRCOrFail(ctx, t, rcWatcher, upd.OldRC, "old RC", &wg)
+	newRCCh := watchRCOrFail(ctx, t, rcWatcher, upd.NewRC, "new RC", &wg)
+
+	rollLoopResult := make(chan bool)
+
+	go func() {
+		rollLoopResult <- upd.rollLoop(ctx, newManifest.ID(), healths, nil)
+		close(rollLoopResult)
+	}()
+
+	checks := map[types.NodeName]health.Result{
+		"node1": {Status: health.Passing},
+		"node2": {Status: health.Passing},
+		"node3": {Status: health.Passing},
+	}
+	healths <- checks
+
+	waitForRCDesire(t, oldRCCh, 2, "old RC")
+	waitForRCDesire(t, newRCCh, 1, "new RC")
+
+	err := transferNode("node1", newManifest, upd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checks["node1"] = health.Result{Status: health.Critical}
+	healths <- checks
+
+	waitForRCDesire(t, oldRCCh, 3, "old RC")
+	waitForRCDesire(t, newRCCh, 0, "new RC")
+
+	ru, err := upd.rollStore.(rollstore.ConsulStore).Get(upd.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ru.RollingBack {
+		t.Error("expected the RU to be marked as rolling back in consul")
+	}
+
+	newRC, err := upd.rcStore.Get(upd.NewRC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !newRC.Disabled {
+		t.Error("expected the new RC to be disabled after rolling back")
+	}
+
+	// simulate the old RC taking node1 back over
+	if _, err := upd.consuls.SetPod(consul.REALITY_TREE, "node1", oldManifest); err != nil {
+		t.Fatal(err)
+	}
+	err = upd.labeler.(testLabeler).SetLabel(labels.POD, labels.MakePodLabelKey("node1", oldManifest.ID()), rc.RCIDLabel, string(upd.OldRC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checks["node1"] = health.Result{Status: health.Passing}
+	healths <- checks
+
+	assertRollLoopResult(t, rollLoopResult, true)
+
+	cancel()
+	wg.Wait()
+
+	als, err := upd.auditLogStore.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	foundRollback := false
+	for _, al := range als {
+		if al.EventType == audit.RURollbackEvent {
+			foundRollback = true
+		}
+	}
+	if !foundRollback {
+		t.Errorf("expected an audit log record of type %q", audit.RURollbackEvent)
+	}
+}
+
+func TestStepTarget(t *testing.T) {
+	for _, tc := range []struct {
+		stepStr  string
+		desired  int
+		expected int
+	}{
+		{"1", 10, 1},
+		{"1,manual", 10, 1},
+		{"20", 10, 10},
+		{"10%", 10, 1},
+		{"10%,pause=5m", 5, 1},
+		{"50%", 5, 3},
+		{"100%", 7, 7},
+	} {
+		step, err := fields.ParseStep(tc.stepStr)
+		if err != nil {
+			t.Fatalf("unexpected error parsing step %q: %s", tc.stepStr, err)
+		}
+		if target := step.Target(tc.desired); target != tc.expected {
+			t.Errorf("expected step %q with %d desired replicas to target %d but was %d", tc.stepStr, tc.desired, tc.expected, target)
+		}
+	}
+
+	step, err := fields.ParseStep("50%,pause=10m,manual")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if step.Pause != 10*time.Minute || !step.ManualApproval {
+		t.Errorf("expected step options to be parsed but got %+v", step)
+	}
+
+	for _, bad := range []string{"", "0", "-1", "0%", "101%", "1,bogus", "1,pause=soon"} {
+		if _, err := fields.ParseStep(bad); err == nil {
+			t.Errorf("expected an error parsing step %q", bad)
+		}
+	}
+}
+
+func TestValidateSteps(t *testing.T) {
+	for _, tc := range []struct {
+		stepStrs []string
+		desired  int
+		valid    bool
+	}{
+		{nil, 10, true},
+		{[]string{"1", "10%", "50%"}, 10, true},
+		// both steps target one replica
+		{[]string{"1", "10%"}, 5, true},
+		{[]string{"5", "10%"}, 10, false},
+		{[]string{"50%", "2"}, 10, false},
+		// steps are capped at the desired replicas
+		{[]string{"20", "100%"}, 10, true},
+	} {
+		update := fields.Update{DesiredReplicas: tc.desired}
+		for _, stepStr := range tc.stepStrs {
+			step, err := fields.ParseStep(stepStr)
+			if err != nil {
+				t.Fatalf("unexpected error parsing step %q: %s", stepStr, err)
+			}
+			update.Steps = append(update.Steps, step)
+		}
+
+		err := update.ValidateSteps()
+		if tc.valid && err != nil {
+			t.Errorf("expected steps %s with %d desired replicas to be valid but got %s", tc.stepStrs, tc.desired, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("expected steps %s with %d desired replicas to be rejected", tc.stepStrs, tc.desired)
+		}
+	}
+}
+
+func TestRollLoopWaitsForStepApproval(t *testing.T) {
+	upd, _, newManifest, rcWatcher, f := updateWithHealth(t, 3, 0, map[types.NodeName]bool{
+		"node1": true,
+		"node2": true,
+		"node3": true,
+	}, nil, nil, nil, rc_fields.StaticStrategy)
+	defer f()
+	upd.DesiredReplicas = 3
+	upd.MinimumReplicas = 2
+	upd.Steps = []fields.Step{{Replicas: 1, ManualApproval: true}}
+
+	// the steps need to be stored in consul so that they can be advanced
+	ruPath, err := rollstore.RollPath(upd.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ruBytes, err := json.Marshal(upd.Update)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = upd.consulClient.KV().Put(&api.KVPair{Key: ruPath, Value: ruBytes}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	healths := make(chan map[types.NodeName]health.Result)
+
+	var wg sync.WaitGroup
+	oldRCCh := watchRCOrFail(ctx, t, rcWatcher, upd.OldRC, "old RC", &wg)
+	newRCCh := watchRCOrFail(ctx, t, rcWatcher, upd.NewRC, "new RC", &wg)
+
+	rollLoopResult := make(chan bool)
+
+	go func() {
+		rollLoopResult <- upd.rollLoop(ctx, newManifest.ID(), healths, nil)
+		close(rollLoopResult)
+	}()
+
+	checks := map[types.NodeName]health.Result{
+		"node1": {Status: health.Passing},
+		"node2": {Status: health.Passing},
+		"node3": {Status: health.Passing},
+	}
+	healths <- checks
+
+	waitForRCDesire(t, oldRCCh, 2, "old RC")
+	waitForRCDesire(t, newRCCh, 1, "new RC")
+
+	err = transferNode("node1", newManifest, upd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		healths <- checks
+	}
+
+	newRC, err := upd.rcStore.Get(upd.NewRC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newRC.ReplicasDesired != 1 {
+		t.Fatalf("expected the update to wait for approval with 1 new replica but new RC had %d", newRC.ReplicasDesired)
+	}
+
+	approveCtx, approveCancel := transaction.New(ctx)
+	defer approveCancel()
+	err = upd.rollStore.AdvanceStepTxn(approveCtx, upd.ID(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = transaction.MustCommit(approveCtx, upd.txner)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sendChecksUntilRCDesire(t, healths, checks, oldRCCh, 1, "old RC")
+	waitForRCDesire(t, newRCCh, 2, "new RC")
+
+	cancel()
+	wg.Wait()
+	assertRollLoopResult(t, rollLoopResult, false)
+}
+
+func TestRollLoopDoesNotScheduleWhilePaused(t *testing.T) {
+	upd, _, newManifest, rcWatcher, f := updateWithHealth(t, 3, 0, map[types.NodeName]bool{
+		"node1": true,
+		"node2": true,
+		"node3": true,
+	}, nil, nil, nil, rc_fields.StaticStrategy)
+	defer f()
+	upd.DesiredReplicas = 3
+	upd.MinimumReplicas = 2
+	upd.Paused = true
+
+	ruPath, err := rollstore.RollPath(upd.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ruBytes, err := json.Marshal(upd.Update)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = upd.consulClient.KV().Put(&api.KVPair{Key: ruPath, Value: ruBytes}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	healths := make(chan map[types.NodeName]health.Result)
+
+	var wg sync.WaitGroup
+	oldRCCh := watchRCOrFail(ctx, t, rcWatcher, upd.OldRC, "old RC", &wg)
+	newRCCh := watchRCOrFail(ctx, t, rcWatcher, upd.NewRC, "new RC", &wg)
+
+	rollLoopResult := make(chan bool)
+
+	go func() {
+		rollLoopResult <- upd.rollLoop(ctx, newManifest.ID(), healths, nil)
+		close(rollLoopResult)
+	}()
+
+	checks := map[types.NodeName]health.Result{
+		"node1": {Status: health.Passing},
+		"node2": {Status: health.Passing},
+		"node3": {Status: health.Passing},
+	}
+	for i := 0; i < 3; i++ {
+		healths <- checks
+	}
+
+	newRC, err := upd.rcStore.Get(upd.NewRC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newRC.ReplicasDesired != 0 {
+		t.Fatalf("expected a paused update not to schedule but new RC had %d replicas desired", newRC.ReplicasDesired)
+	}
+
+	status, _, err := upd.ruStatusStore.(rustatus.ConsulStore).Get(upd.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Paused || status.BlockedReason == "" {
+		t.Errorf("expected status to show the update as paused and blocked but was %+v", status)
+	}
+	if status.OldRC.Healthy != 3 {
+		t.Errorf("expected status to show 3 healthy nodes on the old RC but was %+v", status.OldRC)
+	}
+
+	resumeCtx, resumeCancel := transaction.New(ctx)
+	defer resumeCancel()
+	err = upd.rollStore.(rollstore.ConsulStore).SetPausedTxn(resumeCtx, upd.ID(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = transaction.MustCommit(resumeCtx, upd.txner)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sendChecksUntilRCDesire(t, healths, checks, oldRCCh, 2, "old RC")
+	waitForRCDesire(t, newRCCh, 1, "new RC")
+
+	cancel()
+	wg.Wait()
+	assertRollLoopResult(t, rollLoopResult, false)
+}
@@ -0,0 +1,589 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+
+	"github.com/hashicorp/consul/api"
+	klabels "k8s.io/kubernetes/pkg/labels"
+
+	"github.com/square/p2/pkg/labels"
+	"github.com/square/p2/pkg/manifest"
+	"github.com/square/p2/pkg/rc/fields"
+	"github.com/square/p2/pkg/store/consul/consulutil"
+	"github.com/square/p2/pkg/store/consul/transaction"
+	"github.com/square/p2/pkg/types"
+	"github.com/square/p2/pkg/util"
+	"github.com/square/p2/pkg/util/size"
+)
+
+const (
+	// CPUCapacityLabel is the node label holding the number of logical
+	// CPUs on a node that may be reserved by pods, e.g. "32"
+	CPUCapacityLabel = "cpu_capacity"
+
+	// MemoryCapacityLabel is the node label holding the amount of memory
+	// on a node that may be reserved by pods, e.g. "64G"
+	MemoryCapacityLabel = "memory_capacity"
+
+	// reservationTree is the consul prefix under which one reservation
+	// record per node is stored
+	reservationTree = "scheduler_reservations"
+
+	// reservationBatchSize is the number of node reservation records
+	// written per transaction, which keeps each transaction within the 64
+	// operation limit consul imposes
+	reservationBatchSize = 64
+)
+
+// Policy determines which of the nodes with enough free capacity are
+// preferred by a ResourceScheduler when allocating.
+type Policy string
+
+const (
+	// BinPackPolicy prefers the nodes with the least capacity left over
+	// after the allocation, which keeps other nodes free for large pods
+	BinPackPolicy = Policy("bin_pack")
+
+	// SpreadPolicy prefers the nodes with the most capacity left over
+	// after the allocation, which evens out utilization
+	SpreadPolicy = Policy("spread")
+)
+
+// Resources is an amount of CPU and memory, either requested by a pod or
+// available on a node.
+type Resources struct {
+	CPUs   int            `json:"cpus"`
+	Memory size.ByteCount `json:"memory"`
+}
+
+func (r Resources) add(other Resources) Resources {
+	return Resources{CPUs: r.CPUs + other.CPUs, Memory: r.Memory + other.Memory}
+}
+
+func (r Resources) sub(other Resources) Resources {
+	return Resources{CPUs: r.CPUs - other.CPUs, Memory: r.Memory - other.Memory}
+}
+
+func (r Resources) String() string {
+	return fmt.Sprintf("%d CPUs and %s of memory", r.CPUs, r.Memory)
+}
+
+func (r Resources) fits(request Resources) bool {
+	return request.CPUs <= r.CPUs && request.Memory <= r.Memory
+}
+
+// ResourceRequest returns the resources a pod requires. If the manifest has
+// pod wide resource limits those are used, since they bound the usage of the
+// whole pod. Otherwise it is the sum of the cgroup limits of its launchables.
+func ResourceRequest(man manifest.Manifest) Resources {
+	if limits := man.GetResourceLimits(); limits.Cgroup != nil {
+		return Resources{CPUs: limits.Cgroup.CPUs, Memory: limits.Cgroup.Memory}
+	}
+
+	var request Resources
+	for _, stanza := range man.GetLaunchableStanzas() {
+		request.CPUs += stanza.CgroupConfig.CPUs
+		request.Memory += stanza.CgroupConfig.Memory
+	}
+	return request
+}
+
+// nodeCapacity reads a node's capacity from its labels. Nodes without
+// capacity labels have no capacity, so they can only be allocated to pods
+// that request no resources or when forced.
+func nodeCapacity(node labels.Labeled) (Resources, error) {
+	var capacity Resources
+	if cpus := node.Labels.Get(CPUCapacityLabel); cpus != "" {
+		var err error
+		capacity.CPUs, err = strconv.Atoi(cpus)
+		if err != nil {
+			return Resources{}, util.Errorf("node %s has invalid %s label %q: %s", node.ID, CPUCapacityLabel, cpus, err)
+		}
+	}
+	if memory := node.Labels.Get(MemoryCapacityLabel); memory != "" {
+		var err error
+		capacity.Memory, err = size.Parse(memory)
+		if err != nil {
+			return Resources{}, util.Errorf("node %s has invalid %s label %q: %s", node.ID, MemoryCapacityLabel, memory, err)
+		}
+	}
+	return capacity, nil
+}
+
+// nodeReservations is the record stored in consul for each node, holding
+// the resources reserved on it keyed by the owner of the allocation, which
+// is the ID of the RC it was made for.
+type nodeReservations struct {
+	Reservations map[string]Resources `json:"reservations"`
+}
+
+func (n nodeReservations) total() Resources {
+	var total Resources
+	for _, reserved := range n.Reservations {
+		total = total.add(reserved)
+	}
+	return total
+}
+
+type reservationRecord struct {
+	nodeReservations
+	modifyIndex uint64
+}
+
+type ReservationKV interface {
+	Get(key string, opts *api.QueryOptions) (*api.KVPair, *api.QueryMeta, error)
+	List(prefix string, opts *api.QueryOptions) (api.KVPairs, *api.QueryMeta, error)
+}
+
+// ResourceScheduler allocates nodes to node selectors based on the CPU and
+// memory capacity of each node, as advertised by its labels, and the
+// resources requested by each manifest. Reservations are recorded in consul so
+// that multiple schedulers share a view of how much capacity is free.
+//
+// Reservations are owned by a single RC, so a ResourceScheduler must be
+// scoped to an RC with ForRC before it is used. The nodes eligible for an RC
+// are the uncordoned nodes matching its selector that have been allocated to
+// it, so only RCs with the DynamicStrategy, which allocate nodes, should use a
+// ResourceScheduler. Other RCs can use SelectorScheduler instead.
+type ResourceScheduler struct {
+	applicator NodeLabeler
+	kv         ReservationKV
+	txner      transaction.Txner
+	policy     Policy
+	owner      string
+}
+
+func NewResourceScheduler(applicator NodeLabeler, kv ReservationKV, txner transaction.Txner, policy Policy) *ResourceScheduler {
+	if policy == "" {
+		policy = BinPackPolicy
+	}
+	return &ResourceScheduler{
+		applicator: applicator,
+		kv:         kv,
+		txner:      txner,
+		policy:     policy,
+	}
+}
+
+// ForRC returns a copy of the scheduler whose reservations are owned by the
+// given RC. RCs sharing a node selector are allocated nodes independently.
+func (s *ResourceScheduler) ForRC(id fields.ID) *ResourceScheduler {
+	scoped := *s
+	scoped.owner = id.String()
+	return &scoped
+}
+
+// SelectorScheduler returns a scheduler that treats every uncordoned node
+// matching a selector as eligible, without reserving any resources, for RCs
+// that don't allocate nodes.
+func (s *ResourceScheduler) SelectorScheduler() *ApplicatorScheduler {
+	return NewApplicatorScheduler(s.applicator)
+}
+
+func (s *ResourceScheduler) checkOwner() error {
+	if s.owner == "" {
+		return util.Errorf("resource scheduler must be scoped to an RC with ForRC")
+	}
+	return nil
+}
+
+func reservationPath(node types.NodeName) string {
+	return path.Join(reservationTree, node.String())
+}
+
+// reservations reads the reservation records of every node
+func (s *ResourceScheduler) reservations() (map[types.NodeName]reservationRecord, error) {
+	pairs, _, err := s.kv.List(reservationTree+"/", nil)
+	if err != nil {
+		return nil, consulutil.NewKVError("list", reservationTree+"/", err)
+	}
+
+	ret := make(map[types.NodeName]reservationRecord, len(pairs))
+	for _, pair := range pairs {
+		record, err := parseReservations(pair)
+		if err != nil {
+			return nil, err
+		}
+		ret[types.NodeName(path.Base(pair.Key))] = record
+	}
+	return ret, nil
+}
+
+// reservationsOn reads the reservation records of the given nodes only.
+// Nodes without a record are missing from the returned map.
+func (s *ResourceScheduler) reservationsOn(nodes []types.NodeName) (map[types.NodeName]reservationRecord, error) {
+	ret := make(map[types.NodeName]reservationRecord, len(nodes))
+	for _, node := range nodes {
+		key := reservationPath(node)
+		pair, _, err := s.kv.Get(key, nil)
+		if err != nil {
+			return nil, consulutil.NewKVError("get", key, err)
+		}
+		if pair == nil {
+			continue
+		}
+
+		record, err := parseReservations(pair)
+		if err != nil {
+			return nil, err
+		}
+		ret[node] = record
+	}
+	return ret, nil
+}
+
+func parseReservations(pair *api.KVPair) (reservationRecord, error) {
+	var record nodeReservations
+	err := json.Unmarshal(pair.Value, &record)
+	if err != nil {
+		return reservationRecord{}, util.Errorf("could not unmarshal reservations at %s: %s", pair.Key, err)
+	}
+	return reservationRecord{
+		nodeReservations: record,
+		modifyIndex:      pair.ModifyIndex,
+	}, nil
+}
+
+func (s *ResourceScheduler) EligibleNodes(_ manifest.Manifest, selector klabels.Selector) ([]types.NodeName, error) {
+	err := s.checkOwner()
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, err := s.applicator.GetMatches(selector, labels.NODE)
+	if err != nil {
+		return nil, err
+	}
+	nodes = uncordoned(nodes)
+
+	reservations, err := s.reservations()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]types.NodeName, 0, len(nodes))
+	for _, node := range nodes {
+		nodeName := types.NodeName(node.ID)
+		if _, ok := reservations[nodeName].Reservations[s.owner]; ok {
+			result = append(result, nodeName)
+		}
+	}
+	return result, nil
+}
+
+type candidate struct {
+	node types.NodeName
+	// score is the fraction of the node's capacity that would be left
+	// over after the allocation, summed over CPU and memory. It is
+	// negative if the node would be overcommitted.
+	score float64
+	fits  bool
+}
+
+func fraction(free, capacity float64) float64 {
+	if capacity <= 0 {
+		return 0
+	}
+	return free / capacity
+}
+
+// AllocateNodes reserves the manifest's requested resources on
+// allocationCount nodes matching the selector that have not already been
+// allocated to the RC. Unless force is set, only nodes with enough free
+// capacity are considered and an error is returned if there are not enough of
+// them. With force, nodes are overcommitted if necessary. Either all or none
+// of the nodes are allocated: the reservations are written in batches, and if
+// a batch fails the batches already written are released.
+func (s *ResourceScheduler) AllocateNodes(man manifest.Manifest, selector klabels.Selector, allocationCount int, force bool) ([]types.NodeName, error) {
+	err := s.checkOwner()
+	if err != nil {
+		return nil, err
+	}
+	if allocationCount <= 0 {
+		return nil, nil
+	}
+
+	nodes, err := s.applicator.GetMatches(selector, labels.NODE)
+	if err != nil {
+		return nil, err
+	}
+	nodes = uncordoned(nodes)
+
+	reservations, err := s.reservations()
+	if err != nil {
+		return nil, err
+	}
+
+	request := ResourceRequest(man)
+	var candidates []candidate
+	for _, node := range nodes {
+		nodeName := types.NodeName(node.ID)
+		if _, ok := reservations[nodeName].Reservations[s.owner]; ok {
+			// already allocated to this RC
+			continue
+		}
+
+		capacity, err := nodeCapacity(node)
+		if err != nil {
+			return nil, err
+		}
+
+		free := capacity.sub(reservations[nodeName].total())
+		left := free.sub(request)
+		candidates = append(candidates, candidate{
+			node:  nodeName,
+			score: fraction(float64(left.CPUs), float64(capacity.CPUs)) + fraction(float64(left.Memory), float64(capacity.Memory)),
+			fits:  free.fits(request),
+		})
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].fits != candidates[j].fits {
+			return candidates[i].fits
+		}
+		if candidates[i].score != candidates[j].score {
+			if s.policy == SpreadPolicy || !candidates[i].fits {
+				// nodes that don't fit are always ordered by
+				// the least overcommitment
+				return candidates[i].score > candidates[j].score
+			}
+			return candidates[i].score < candidates[j].score
+		}
+		return candidates[i].node < candidates[j].node
+	})
+
+	if !force {
+		fitting := 0
+		for _, c := range candidates {
+			if c.fits {
+				fitting++
+			}
+		}
+		candidates = candidates[:fitting]
+	}
+	if len(candidates) < allocationCount {
+		return nil, util.Errorf(
+			"only %d of %d requested nodes matching %q have %s available",
+			len(candidates),
+			allocationCount,
+			selector.String(),
+			request,
+		)
+	}
+
+	allocated := make([]types.NodeName, allocationCount)
+	updated := make(map[types.NodeName]reservationRecord, allocationCount)
+	for i, c := range candidates[:allocationCount] {
+		record := reservations[c.node]
+		if record.Reservations == nil {
+			record.Reservations = make(map[string]Resources)
+		}
+		record.Reservations[s.owner] = request
+		updated[c.node] = record
+		allocated[i] = c.node
+	}
+
+	for start := 0; start < len(allocated); start += reservationBatchSize {
+		end := start + reservationBatchSize
+		if end > len(allocated) {
+			end = len(allocated)
+		}
+
+		err = s.writeReservations(allocated[start:end], updated)
+		if err != nil {
+			if start > 0 {
+				// release the batches that were already
+				// written. If this fails too the reservations
+				// are leaked until the RC deallocates them
+				_ = s.DeallocateNodes(selector, allocated[:start])
+			}
+			return nil, util.Errorf("could not reserve resources on %s: %s", allocated[start:end], err)
+		}
+	}
+	return allocated, nil
+}
+
+// ReserveExisting reserves the manifest's requested resources for the RC on
+// the given nodes, which run its pods. It is used for pods that were scheduled
+// without an allocation, e.g. before the RC used a ResourceScheduler, so that
+// they stay eligible, and updates reservations made for a different request
+// when the RC's manifest changes. Capacity isn't checked, since the pods are
+// already running.
+func (s *ResourceScheduler) ReserveExisting(man manifest.Manifest, nodes []types.NodeName) error {
+	err := s.checkOwner()
+	if err != nil {
+		return err
+	}
+
+	reservations, err := s.reservationsOn(nodes)
+	if err != nil {
+		return err
+	}
+
+	request := ResourceRequest(man)
+	var reserved []types.NodeName
+	updated := make(map[types.NodeName]reservationRecord)
+	for _, node := range nodes {
+		record := reservations[node]
+		if existing, ok := record.Reservations[s.owner]; ok && existing == request {
+			continue
+		}
+		if record.Reservations == nil {
+			record.Reservations = make(map[string]Resources)
+		}
+		record.Reservations[s.owner] = request
+		updated[node] = record
+		reserved = append(reserved, node)
+	}
+
+	for start := 0; start < len(reserved); start += reservationBatchSize {
+		end := start + reservationBatchSize
+		if end > len(reserved) {
+			end = len(reserved)
+		}
+
+		err = s.writeReservations(reserved[start:end], updated)
+		if err != nil {
+			return util.Errorf("could not reserve resources for existing pods on %s: %s", reserved[start:end], err)
+		}
+	}
+	return nil
+}
+
+// DeallocateNodes releases the RC's reservations on the given nodes. Nodes
+// that are not allocated to the RC are ignored.
+func (s *ResourceScheduler) DeallocateNodes(_ klabels.Selector, nodes []types.NodeName) error {
+	err := s.checkOwner()
+	if err != nil {
+		return err
+	}
+
+	reservations, err := s.reservationsOn(nodes)
+	if err != nil {
+		return err
+	}
+	return s.release(nodes, reservations)
+}
+
+// ReleaseReservations releases all of the RC's reservations. It is called
+// when the RC is deleted, since its nodes are no longer deallocated one by
+// one then.
+func (s *ResourceScheduler) ReleaseReservations() error {
+	err := s.checkOwner()
+	if err != nil {
+		return err
+	}
+
+	reservations, err := s.reservations()
+	if err != nil {
+		return err
+	}
+
+	nodes := make([]types.NodeName, 0, len(reservations))
+	for node := range reservations {
+		nodes = append(nodes, node)
+	}
+	return s.release(nodes, reservations)
+}
+
+// ReservationOwners returns the IDs of every RC holding reservations on any
+// node. It doesn't need to be scoped to an RC, and is used to find the
+// reservations of RCs that were deleted without releasing them.
+func (s *ResourceScheduler) ReservationOwners() ([]fields.ID, error) {
+	reservations, err := s.reservations()
+	if err != nil {
+		return nil, err
+	}
+
+	owners := make(map[fields.ID]struct{})
+	for _, record := range reservations {
+		for owner := range record.Reservations {
+			owners[fields.ID(owner)] = struct{}{}
+		}
+	}
+
+	ret := make([]fields.ID, 0, len(owners))
+	for owner := range owners {
+		ret = append(ret, owner)
+	}
+	return ret, nil
+}
+
+// release removes the RC's reservations on the given nodes from the
+// reservation records read for them
+func (s *ResourceScheduler) release(nodes []types.NodeName, reservations map[types.NodeName]reservationRecord) error {
+	var released []types.NodeName
+	updated := make(map[types.NodeName]reservationRecord)
+	for _, node := range nodes {
+		record, ok := reservations[node]
+		if !ok {
+			continue
+		}
+		if _, ok := record.Reservations[s.owner]; !ok {
+			continue
+		}
+		delete(record.Reservations, s.owner)
+		updated[node] = record
+		released = append(released, node)
+	}
+
+	for start := 0; start < len(released); start += reservationBatchSize {
+		end := start + reservationBatchSize
+		if end > len(released) {
+			end = len(released)
+		}
+
+		err := s.writeReservations(released[start:end], updated)
+		if err != nil {
+			return util.Errorf("could not release reservations on %s: %s", released[start:end], err)
+		}
+	}
+	return nil
+}
+
+// writeReservations writes the updated reservation records of the given
+// nodes in a single transaction
+func (s *ResourceScheduler) writeReservations(nodes []types.NodeName, records map[types.NodeName]reservationRecord) error {
+	ctx, cancel := transaction.New(context.Background())
+	defer cancel()
+	for _, node := range nodes {
+		err := s.casReservationsTxn(ctx, node, records[node])
+		if err != nil {
+			return err
+		}
+	}
+
+	return transaction.MustCommit(ctx, s.txner)
+}
+
+// casReservationsTxn adds an operation to the transaction in ctx that writes
+// a node's reservations if they have not changed since they were read, or
+// deletes them if there are none left.
+func (s *ResourceScheduler) casReservationsTxn(ctx context.Context, node types.NodeName, record reservationRecord) error {
+	if len(record.Reservations) == 0 {
+		return transaction.Add(ctx, api.KVTxnOp{
+			Verb:  api.KVDeleteCAS,
+			Key:   reservationPath(node),
+			Index: record.modifyIndex,
+		})
+	}
+
+	bytes, err := json.Marshal(record.nodeReservations)
+	if err != nil {
+		return util.Errorf("could not marshal reservations for %s: %s", node, err)
+	}
+
+	// an index of 0 means the write only succeeds if the key doesn't
+	// exist, which is what we want for nodes with no reservations yet
+	return transaction.Add(ctx, api.KVTxnOp{
+		Verb:  api.KVCAS,
+		Key:   reservationPath(node),
+		Value: bytes,
+		Index: record.modifyIndex,
+	})
+}
@@ -0,0 +1,401 @@
+package scheduler
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	klabels "k8s.io/kubernetes/pkg/labels"
+
+	"github.com/square/p2/pkg/cgroups"
+	"github.com/square/p2/pkg/labels"
+	"github.com/square/p2/pkg/launch"
+	"github.com/square/p2/pkg/manifest"
+	"github.com/square/p2/pkg/store/consul/consulutil"
+	"github.com/square/p2/pkg/types"
+	"github.com/square/p2/pkg/util/size"
+)
+
+func testManifest(cpus int, memory size.ByteCount) manifest.Manifest {
+	builder := manifest.NewBuilder()
+	builder.SetID("some_pod")
+	builder.SetLaunchables(map[launch.LaunchableID]launch.LaunchableStanza{
+		"app": {
+			CgroupConfig: cgroups.Config{CPUs: cpus, Memory: memory},
+		},
+	})
+	return builder.GetManifest()
+}
+
+func setupResourceScheduler(t *testing.T, policy Policy) (*ResourceScheduler, klabels.Selector, func()) {
+	fixture := consulutil.NewFixture(t)
+	applicator := labels.NewConsulApplicator(fixture.Client, 0, 0)
+
+	capacities := map[string][2]string{
+		"big.example.com":   {"8", "16G"},
+		"small.example.com": {"4", "8G"},
+	}
+	for node, capacity := range capacities {
+		err := applicator.SetLabels(labels.NODE, node, map[string]string{
+			"pool":              "test",
+			CPUCapacityLabel:    capacity[0],
+			MemoryCapacityLabel: capacity[1],
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	selector := klabels.Everything().Add("pool", klabels.EqualsOperator, []string{"test"})
+	s := NewResourceScheduler(applicator, fixture.Client.KV(), fixture.Client.KV(), policy).ForRC("some_rc")
+	return s, selector, fixture.Stop
+}
+
+func TestResourceRequest(t *testing.T) {
+	builder := manifest.NewBuilder()
+	builder.SetID("some_pod")
+	builder.SetLaunchables(map[launch.LaunchableID]launch.LaunchableStanza{
+		"app1": {CgroupConfig: cgroups.Config{CPUs: 1, Memory: size.Gibibyte}},
+		"app2": {CgroupConfig: cgroups.Config{CPUs: 2, Memory: size.Gibibyte}},
+	})
+
+	request := ResourceRequest(builder.GetManifest())
+	if request.CPUs != 3 || request.Memory != 2*size.Gibibyte {
+		t.Errorf("expected launchable limits to be summed, got %s", request)
+	}
+
+	builder.SetResourceLimits(manifest.ResourceLimitsStanza{
+		Cgroup: &cgroups.Config{CPUs: 2, Memory: size.Gibibyte},
+	})
+	request = ResourceRequest(builder.GetManifest())
+	if request.CPUs != 2 || request.Memory != size.Gibibyte {
+		t.Errorf("expected pod limits to be used, got %s", request)
+	}
+}
+
+func TestAllocateNodesBinPack(t *testing.T) {
+	s, selector, closeFn := setupResourceScheduler(t, BinPackPolicy)
+	defer closeFn()
+
+	nodes, err := s.AllocateNodes(testManifest(2, 4*size.Gibibyte), selector, 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 1 || nodes[0] != "small.example.com" {
+		t.Fatalf("expected the fullest node to be allocated, got %s", nodes)
+	}
+
+	eligible, err := s.EligibleNodes(testManifest(2, 4*size.Gibibyte), selector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(eligible) != 1 || eligible[0] != "small.example.com" {
+		t.Fatalf("expected allocated node to be eligible, got %s", eligible)
+	}
+}
+
+func TestAllocateNodesSpread(t *testing.T) {
+	s, selector, closeFn := setupResourceScheduler(t, SpreadPolicy)
+	defer closeFn()
+
+	nodes, err := s.AllocateNodes(testManifest(2, 4*size.Gibibyte), selector, 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 1 || nodes[0] != "big.example.com" {
+		t.Fatalf("expected the emptiest node to be allocated, got %s", nodes)
+	}
+}
+
+func TestAllocateNodesRespectsCapacity(t *testing.T) {
+	s, selector, closeFn := setupResourceScheduler(t, BinPackPolicy)
+	defer closeFn()
+
+	// another RC takes most of the big node
+	_, err := s.ForRC("other_rc").AllocateNodes(testManifest(7, 14*size.Gibibyte), selector, 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.AllocateNodes(testManifest(6, 4*size.Gibibyte), selector, 1, false)
+	if err == nil {
+		t.Fatal("expected an error allocating more CPUs than are free on any node")
+	}
+
+	nodes, err := s.AllocateNodes(testManifest(6, 4*size.Gibibyte), selector, 1, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 1 || nodes[0] != "small.example.com" {
+		t.Fatalf("expected the least overcommitted node to be allocated when forced, got %s", nodes)
+	}
+}
+
+func TestDeallocateNodes(t *testing.T) {
+	s, selector, closeFn := setupResourceScheduler(t, BinPackPolicy)
+	defer closeFn()
+
+	man := testManifest(4, 8*size.Gibibyte)
+	nodes, err := s.AllocateNodes(man, selector, 2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 2 {
+		t.Fatalf("expected 2 nodes to be allocated, got %s", nodes)
+	}
+
+	// the small node is full, so a second RC with the same selector can
+	// only use the big one
+	other := s.ForRC("other_rc")
+	_, err = other.AllocateNodes(man, selector, 2, false)
+	if err == nil {
+		t.Fatal("expected an error allocating to a full node")
+	}
+
+	err = s.DeallocateNodes(selector, []types.NodeName{"small.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	eligible, err := s.EligibleNodes(man, selector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(eligible) != 1 || eligible[0] != "big.example.com" {
+		t.Fatalf("expected only the big node to remain allocated, got %s", eligible)
+	}
+
+	_, err = other.AllocateNodes(man, selector, 2, false)
+	if err != nil {
+		t.Fatalf("expected deallocation to free capacity: %s", err)
+	}
+}
+
+func TestReserveExisting(t *testing.T) {
+	s, selector, closeFn := setupResourceScheduler(t, BinPackPolicy)
+	defer closeFn()
+
+	// a pod already runs on the small node, so it is reserved even though
+	// it doesn't have the capacity
+	man := testManifest(6, 4*size.Gibibyte)
+	err := s.ReserveExisting(man, []types.NodeName{"small.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// reserving a node again doesn't reserve its resources twice
+	err = s.ReserveExisting(man, []types.NodeName{"small.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	eligible, err := s.EligibleNodes(man, selector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(eligible) != 1 || eligible[0] != "small.example.com" {
+		t.Fatalf("expected the node of the existing pod to be eligible, got %s", eligible)
+	}
+
+	reservations, err := s.reservations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total := reservations["small.example.com"].total(); total.CPUs != 6 {
+		t.Errorf("expected the existing pod's request to be reserved once, got %s", total)
+	}
+}
+
+func TestReserveExistingUpdatesRequest(t *testing.T) {
+	s, _, closeFn := setupResourceScheduler(t, BinPackPolicy)
+	defer closeFn()
+
+	err := s.ReserveExisting(testManifest(1, size.Gibibyte), []types.NodeName{"big.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the RC's manifest now requests more resources
+	err = s.ReserveExisting(testManifest(3, 2*size.Gibibyte), []types.NodeName{"big.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reservations, err := s.reservationsOn([]types.NodeName{"big.example.com", "small.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total := reservations["big.example.com"].total(); total.CPUs != 3 || total.Memory != 2*size.Gibibyte {
+		t.Errorf("expected the reservation to be updated to the new request, got %s", total)
+	}
+	if _, ok := reservations["small.example.com"]; ok {
+		t.Error("expected no reservation record for a node that wasn't reserved")
+	}
+}
+
+func TestReleaseReservations(t *testing.T) {
+	s, selector, closeFn := setupResourceScheduler(t, BinPackPolicy)
+	defer closeFn()
+
+	man := testManifest(1, size.Gibibyte)
+	_, err := s.AllocateNodes(man, selector, 2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := s.ForRC("other_rc")
+	_, err = other.AllocateNodes(man, selector, 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.ReleaseReservations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	eligible, err := s.EligibleNodes(man, selector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(eligible) != 0 {
+		t.Errorf("expected no nodes to remain allocated to the released RC, got %s", eligible)
+	}
+	eligible, err = other.EligibleNodes(man, selector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(eligible) != 1 {
+		t.Errorf("expected the other RC to keep its allocation, got %s", eligible)
+	}
+}
+
+func TestReservationOwners(t *testing.T) {
+	s, selector, closeFn := setupResourceScheduler(t, BinPackPolicy)
+	defer closeFn()
+
+	man := testManifest(1, size.Gibibyte)
+	_, err := s.AllocateNodes(man, selector, 2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.ForRC("other_rc").AllocateNodes(man, selector, 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	owners, err := s.ReservationOwners()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(owners, func(i, j int) bool { return owners[i] < owners[j] })
+	if len(owners) != 2 || owners[0] != "other_rc" || owners[1] != "some_rc" {
+		t.Errorf("expected other_rc and some_rc to own reservations, got %s", owners)
+	}
+}
+
+func TestCordonedNodesAreIneligible(t *testing.T) {
+	fixture := consulutil.NewFixture(t)
+	defer fixture.Stop()
+	applicator := labels.NewConsulApplicator(fixture.Client, 0, 0)
+
+	for _, node := range []string{"node1.example.com", "node2.example.com"} {
+		err := applicator.SetLabels(labels.NODE, node, map[string]string{
+			"pool":              "test",
+			CPUCapacityLabel:    "4",
+			MemoryCapacityLabel: "8G",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	selector := klabels.Everything().Add("pool", klabels.EqualsOperator, []string{"test"})
+	man := testManifest(1, size.Gibibyte)
+
+	s := NewResourceScheduler(applicator, fixture.Client.KV(), fixture.Client.KV(), BinPackPolicy).ForRC("some_rc")
+	_, err := s.AllocateNodes(man, selector, 2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = applicator.SetLabel(labels.NODE, "node2.example.com", types.CordonedLabel, "true")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	eligible, err := NewApplicatorScheduler(applicator).EligibleNodes(man, selector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(eligible) != 1 || eligible[0] != "node1.example.com" {
+		t.Errorf("expected only the uncordoned node to be eligible, got %s", eligible)
+	}
+
+	eligible, err = s.EligibleNodes(man, selector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(eligible) != 1 || eligible[0] != "node1.example.com" {
+		t.Errorf("expected the cordoned node to be ineligible even though it was allocated, got %s", eligible)
+	}
+
+	_, err = s.ForRC("other_rc").AllocateNodes(man, selector, 2, true)
+	if err == nil {
+		t.Error("expected an error allocating more nodes than are uncordoned")
+	}
+}
+
+func TestAllocateNodesInBatches(t *testing.T) {
+	fixture := consulutil.NewFixture(t)
+	defer fixture.Stop()
+	applicator := labels.NewConsulApplicator(fixture.Client, 0, 0)
+
+	nodeCount := reservationBatchSize + 6
+	for i := 0; i < nodeCount; i++ {
+		err := applicator.SetLabels(labels.NODE, fmt.Sprintf("node%d.example.com", i), map[string]string{
+			"pool":              "test",
+			CPUCapacityLabel:    "4",
+			MemoryCapacityLabel: "8G",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	selector := klabels.Everything().Add("pool", klabels.EqualsOperator, []string{"test"})
+	man := testManifest(1, size.Gibibyte)
+
+	s := NewResourceScheduler(applicator, fixture.Client.KV(), fixture.Client.KV(), BinPackPolicy).ForRC("some_rc")
+	nodes, err := s.AllocateNodes(man, selector, nodeCount, false)
+	if err != nil {
+		t.Fatalf("expected more nodes than fit in one transaction to be allocated: %s", err)
+	}
+
+	eligible, err := s.EligibleNodes(man, selector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(eligible) != nodeCount {
+		t.Fatalf("expected %d nodes to be eligible, got %d", nodeCount, len(eligible))
+	}
+
+	err = s.DeallocateNodes(selector, nodes)
+	if err != nil {
+		t.Fatalf("expected more nodes than fit in one transaction to be deallocated: %s", err)
+	}
+	eligible, err = s.EligibleNodes(man, selector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(eligible) != 0 {
+		t.Errorf("expected no nodes to remain allocated, got %s", eligible)
+	}
+}
+
+func TestUnscopedResourceSchedulerErrors(t *testing.T) {
+	s, selector, closeFn := setupResourceScheduler(t, BinPackPolicy)
+	defer closeFn()
+
+	unscoped := *s
+	unscoped.owner = ""
+	_, err := unscoped.AllocateNodes(testManifest(1, size.Gibibyte), selector, 1, false)
+	if err == nil {
+		t.Error("expected an error allocating nodes without an owning RC")
+	}
+}
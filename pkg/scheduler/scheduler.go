@@ -17,8 +17,8 @@ type ApplicatorScheduler struct {
 	applicator NodeLabeler
 }
 
-// ApplicatorSchedulers simply return the results of node label selector.
-// The manifest is ignored.
+// ApplicatorSchedulers simply return the results of node label selector,
+// excluding cordoned nodes. The manifest is ignored.
 func NewApplicatorScheduler(applicator NodeLabeler) *ApplicatorScheduler {
 	return &ApplicatorScheduler{applicator: applicator}
 }
@@ -29,6 +29,7 @@ func (sel *ApplicatorScheduler) EligibleNodes(_ manifest.Manifest, selector klab
 		return nil, err
 	}
 
+	nodes = uncordoned(nodes)
 	result := make([]types.NodeName, len(nodes))
 	for i, node := range nodes {
 		result[i] = types.NodeName(node.ID)
@@ -36,6 +37,19 @@ func (sel *ApplicatorScheduler) EligibleNodes(_ manifest.Manifest, selector klab
 	return result, nil
 }
 
+// uncordoned filters out the nodes that have been cordoned. Node selectors
+// can't be relied on to exclude them, since cordoning a node must take it out
+// of service for every selector
+func uncordoned(nodes []labels.Labeled) []labels.Labeled {
+	ret := make([]labels.Labeled, 0, len(nodes))
+	for _, node := range nodes {
+		if !node.Labels.Has(types.CordonedLabel) {
+			ret = append(ret, node)
+		}
+	}
+	return ret
+}
+
 func (sel *ApplicatorScheduler) AllocateNodes(manifest.Manifest, klabels.Selector, int, bool) ([]types.NodeName, error) {
 	return nil, util.Errorf("AllocateNodes() not yet implemented")
 }
@@ -0,0 +1,66 @@
+// Package autoscalestore stores the autoscaler policies of replication
+// controllers in consul.
+package autoscalestore
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/square/p2/pkg/autoscale/fields"
+	rc_fields "github.com/square/p2/pkg/rc/fields"
+	"github.com/square/p2/pkg/store/consul/consulutil"
+	"github.com/square/p2/pkg/store/consul/rcpolicystore"
+)
+
+var NoPolicy error = errors.New("No autoscaler policy found")
+
+// ConsulStore is an rcpolicystore.Store of autoscaler policies. Its Delete,
+// WatchRCs and LockForOwnership methods are those of the underlying store.
+type ConsulStore struct {
+	*rcpolicystore.Store
+}
+
+func NewConsul(client consulutil.ConsulClient) *ConsulStore {
+	return &ConsulStore{
+		Store: rcpolicystore.New(client.KV(), rcpolicystore.AutoscalerTree, "autoscaler policy", NoPolicy, func() rcpolicystore.Policy {
+			return &fields.Policy{}
+		}),
+	}
+}
+
+// Set creates or replaces the autoscaler policy for an RC. The time the RC was
+// last scaled is preserved, so replacing a policy does not skip its cooldown.
+func (s *ConsulStore) Set(policy fields.Policy) error {
+	return s.Store.Set(&policy)
+}
+
+// Get returns the autoscaler policy for an RC, or NoPolicy if it has none.
+func (s *ConsulStore) Get(rcID rc_fields.ID) (fields.Policy, error) {
+	policy, err := s.Store.Get(rcID)
+	if err != nil {
+		return fields.Policy{}, err
+	}
+	return *policy.(*fields.Policy), nil
+}
+
+func (s *ConsulStore) List() ([]fields.Policy, error) {
+	listed, err := s.Store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]fields.Policy, 0, len(listed))
+	for _, policy := range listed {
+		ret = append(ret, *policy.(*fields.Policy))
+	}
+	return ret, nil
+}
+
+// SetLastScaledTxn adds an operation to the transaction in ctx that records
+// that the RC was scaled at the given time. The operation fails if the policy
+// has been changed since it was read, so that a scaling decision made using a
+// stale policy is not committed.
+func (s *ConsulStore) SetLastScaledTxn(ctx context.Context, policy fields.Policy, lastScaled time.Time) error {
+	return s.Store.SetProgressTxn(ctx, &policy, lastScaled)
+}
@@ -0,0 +1,73 @@
+package autoscalestore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/square/p2/pkg/autoscale/fields"
+	"github.com/square/p2/pkg/store/consul/consulutil"
+	"github.com/square/p2/pkg/store/consul/transaction"
+)
+
+func testPolicy() fields.Policy {
+	return fields.Policy{
+		RCID:        "some_rc",
+		MinReplicas: 1,
+		MaxReplicas: 5,
+		TargetValue: 0.5,
+		Metric: fields.MetricSourceConfig{
+			Type: fields.HTTPMetric,
+			URL:  "http://localhost:8080/metric",
+		},
+	}
+}
+
+func TestSetLastScaledTxn(t *testing.T) {
+	fixture := consulutil.NewFixture(t)
+	defer fixture.Stop()
+	store := NewConsul(fixture.Client)
+
+	_, err := store.Get("some_rc")
+	if err != NoPolicy {
+		t.Fatalf("expected NoPolicy before the policy was set but got %v", err)
+	}
+	err = store.Set(testPolicy())
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy, err := store.Get("some_rc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy != testPolicy() {
+		t.Errorf("expected to get %+v but got %+v", testPolicy(), policy)
+	}
+
+	lastScaled := time.Now()
+	ctx, cancel := transaction.New(context.Background())
+	defer cancel()
+	err = store.SetLastScaledTxn(ctx, policy, lastScaled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = transaction.MustCommit(ctx, fixture.Client.KV())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// replacing the policy should keep the time it was last scaled
+	replacement := testPolicy()
+	replacement.MaxReplicas = 10
+	err = store.Set(replacement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policies, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(policies) != 1 || !policies[0].LastScaled.Equal(lastScaled) || policies[0].MaxReplicas != 10 {
+		t.Errorf("expected the replaced policy to have max replicas 10 and last scaled %s but got %+v", lastScaled, policies)
+	}
+}
@@ -0,0 +1,220 @@
+// Package disruptionbudgetstore stores the disruption budgets of pod IDs and
+// pod clusters in consul.
+package disruptionbudgetstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path"
+
+	"github.com/hashicorp/consul/api"
+
+	"github.com/square/p2/pkg/disruptionbudget/fields"
+	pc_fields "github.com/square/p2/pkg/pc/fields"
+	"github.com/square/p2/pkg/store/consul/consulutil"
+	"github.com/square/p2/pkg/store/consul/transaction"
+	"github.com/square/p2/pkg/types"
+	"github.com/square/p2/pkg/util"
+)
+
+const budgetTree string = "disruption_budgets"
+
+var NoBudget error = errors.New("No disruption budget found")
+
+type KV interface {
+	Get(key string, q *api.QueryOptions) (*api.KVPair, *api.QueryMeta, error)
+	List(prefix string, q *api.QueryOptions) (api.KVPairs, *api.QueryMeta, error)
+	CAS(p *api.KVPair, q *api.WriteOptions) (bool, *api.WriteMeta, error)
+	Delete(key string, w *api.WriteOptions) (*api.WriteMeta, error)
+}
+
+var _ KV = &api.KV{}
+
+type ConsulStore struct {
+	kv KV
+}
+
+func NewConsul(client consulutil.ConsulClient) *ConsulStore {
+	return &ConsulStore{
+		kv: client.KV(),
+	}
+}
+
+// budgetPath returns the key of the budget covering a pod ID, or of the budget
+// covering one of its pod clusters if az and cn are set.
+func budgetPath(podID types.PodID, az pc_fields.AvailabilityZone, cn pc_fields.ClusterName) (string, error) {
+	if podID == "" {
+		return "", util.Errorf("path requested for empty pod id")
+	}
+	if az == "" && cn == "" {
+		return path.Join(budgetTree, podID.String()), nil
+	}
+	if az == "" || cn == "" {
+		return "", util.Errorf("path requested for pod cluster of %s with availability zone %q and cluster name %q", podID, az, cn)
+	}
+	return path.Join(budgetTree, podID.String(), az.String(), cn.String()), nil
+}
+
+// Set creates or replaces a disruption budget. Reservations already made
+// against the budget are preserved.
+func (s *ConsulStore) Set(budget fields.Budget) error {
+	err := budget.Validate()
+	if err != nil {
+		return err
+	}
+
+	existing, index, err := s.getWithIndex(budget.PodID, budget.AvailabilityZone, budget.ClusterName)
+	switch {
+	case err == NoBudget:
+	case err != nil:
+		return err
+	default:
+		budget.Disruptions = existing.Disruptions
+	}
+
+	key, err := budgetPath(budget.PodID, budget.AvailabilityZone, budget.ClusterName)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(budget)
+	if err != nil {
+		return util.Errorf("could not marshal disruption budget as json: %s", err)
+	}
+
+	success, _, err := s.kv.CAS(&api.KVPair{
+		Key:         key,
+		Value:       b,
+		ModifyIndex: index,
+	}, nil)
+	if err != nil {
+		return consulutil.NewKVError("cas", key, err)
+	}
+	if !success {
+		return util.Errorf("disruption budget at %s was modified concurrently", key)
+	}
+	return nil
+}
+
+// Get returns the budget covering a pod ID, or the budget covering one of its
+// pod clusters if az and cn are set. NoBudget is returned if there is none.
+func (s *ConsulStore) Get(podID types.PodID, az pc_fields.AvailabilityZone, cn pc_fields.ClusterName) (fields.Budget, error) {
+	budget, _, err := s.getWithIndex(podID, az, cn)
+	return budget, err
+}
+
+func (s *ConsulStore) getWithIndex(podID types.PodID, az pc_fields.AvailabilityZone, cn pc_fields.ClusterName) (fields.Budget, uint64, error) {
+	key, err := budgetPath(podID, az, cn)
+	if err != nil {
+		return fields.Budget{}, 0, err
+	}
+
+	kvp, _, err := s.kv.Get(key, nil)
+	if err != nil {
+		return fields.Budget{}, 0, consulutil.NewKVError("get", key, err)
+	}
+	if kvp == nil {
+		return fields.Budget{}, 0, NoBudget
+	}
+
+	budget, err := kvpToBudget(kvp)
+	if err != nil {
+		return fields.Budget{}, 0, err
+	}
+	return budget, kvp.ModifyIndex, nil
+}
+
+func (s *ConsulStore) List() ([]fields.Budget, error) {
+	listed, _, err := s.kv.List(budgetTree+"/", nil)
+	if err != nil {
+		return nil, consulutil.NewKVError("list", budgetTree+"/", err)
+	}
+
+	budgets := make([]fields.Budget, 0, len(listed))
+	for _, kvp := range listed {
+		budget, err := kvpToBudget(kvp)
+		if err != nil {
+			return nil, err
+		}
+		budgets = append(budgets, budget)
+	}
+	return budgets, nil
+}
+
+func (s *ConsulStore) Delete(podID types.PodID, az pc_fields.AvailabilityZone, cn pc_fields.ClusterName) error {
+	key, err := budgetPath(podID, az, cn)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.kv.Delete(key, nil)
+	if err != nil {
+		return consulutil.NewKVError("delete", key, err)
+	}
+	return nil
+}
+
+// SetDisruptionsTxn adds an operation to the transaction in ctx that replaces
+// the reservations made against a budget. The operation fails if the budget
+// has been changed since it was read, including by another reservation, so
+// that two callers cannot both reserve the last of a budget.
+func (s *ConsulStore) SetDisruptionsTxn(ctx context.Context, budget fields.Budget, disruptions []fields.Disruption) error {
+	current, index, err := s.getWithIndex(budget.PodID, budget.AvailabilityZone, budget.ClusterName)
+	if err != nil {
+		return err
+	}
+	if !sameBudget(current, budget) {
+		return util.Errorf("disruption budget for %s changed since it was read", budget.PodID)
+	}
+
+	current.Disruptions = disruptions
+	key, err := budgetPath(current.PodID, current.AvailabilityZone, current.ClusterName)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(current)
+	if err != nil {
+		return util.Errorf("could not marshal disruption budget as json: %s", err)
+	}
+
+	return transaction.Add(ctx, api.KVTxnOp{
+		Verb:  api.KVCAS,
+		Key:   key,
+		Value: b,
+		Index: index,
+	})
+}
+
+// sameBudget compares two budgets, using time.Time.Equal for the expiry of
+// their disruptions since the locations of unmarshaled times may differ.
+func sameBudget(a fields.Budget, b fields.Budget) bool {
+	if a.PodID != b.PodID ||
+		a.AvailabilityZone != b.AvailabilityZone ||
+		a.ClusterName != b.ClusterName ||
+		!sameLimit(a.MaxUnavailable, b.MaxUnavailable) ||
+		!sameLimit(a.MinAvailable, b.MinAvailable) ||
+		len(a.Disruptions) != len(b.Disruptions) {
+		return false
+	}
+	for i := range a.Disruptions {
+		if a.Disruptions[i].Holder != b.Disruptions[i].Holder ||
+			a.Disruptions[i].Node != b.Disruptions[i].Node ||
+			!a.Disruptions[i].Expires.Equal(b.Disruptions[i].Expires) {
+			return false
+		}
+	}
+	return true
+}
+
+func kvpToBudget(kvp *api.KVPair) (fields.Budget, error) {
+	var budget fields.Budget
+	err := json.Unmarshal(kvp.Value, &budget)
+	if err != nil {
+		return fields.Budget{}, util.Errorf("could not unmarshal disruption budget at %s: %s", kvp.Key, err)
+	}
+	return budget, nil
+}
+
+func sameLimit(a *int, b *int) bool {
+	return a == nil && b == nil || a != nil && b != nil && *a == *b
+}
@@ -0,0 +1,134 @@
+package disruptionbudgetstore
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/square/p2/pkg/disruptionbudget/fields"
+	"github.com/square/p2/pkg/store/consul/consulutil"
+	"github.com/square/p2/pkg/store/consul/transaction"
+)
+
+func TestSetAndGet(t *testing.T) {
+	fixture := consulutil.NewFixture(t)
+	defer fixture.Stop()
+	store := NewConsul(fixture.Client)
+
+	_, err := store.Get("some_pod", "", "")
+	if err != NoBudget {
+		t.Fatalf("expected NoBudget before the budget was set but got %v", err)
+	}
+
+	err = store.Set(fields.Budget{PodID: "some_pod", MaxUnavailable: fields.Limit(1), MinAvailable: fields.Limit(2)})
+	if err == nil {
+		t.Fatal("expected an error setting a budget with both max unavailable and min available")
+	}
+	err = store.Set(fields.Budget{PodID: "some_pod"})
+	if err == nil {
+		t.Fatal("expected an error setting a budget with neither max unavailable nor min available")
+	}
+
+	podBudget := fields.Budget{PodID: "some_pod", MaxUnavailable: fields.Limit(1)}
+	clusterBudget := fields.Budget{
+		PodID:            "some_pod",
+		AvailabilityZone: "some_az",
+		ClusterName:      "some_cn",
+		MinAvailable:     fields.Limit(3),
+	}
+	for _, budget := range []fields.Budget{podBudget, clusterBudget} {
+		err = store.Set(budget)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	budget, err := store.Get("some_pod", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(budget, podBudget) {
+		t.Errorf("expected to get %+v but got %+v", podBudget, budget)
+	}
+	budget, err = store.Get("some_pod", "some_az", "some_cn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(budget, clusterBudget) {
+		t.Errorf("expected to get %+v but got %+v", clusterBudget, budget)
+	}
+
+	budgets, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(budgets) != 2 {
+		t.Errorf("expected to list 2 budgets but got %d", len(budgets))
+	}
+
+	err = store.Delete("some_pod", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.Get("some_pod", "", "")
+	if err != NoBudget {
+		t.Fatalf("expected NoBudget after the budget was deleted but got %v", err)
+	}
+	_, err = store.Get("some_pod", "some_az", "some_cn")
+	if err != nil {
+		t.Fatalf("expected the pod cluster budget to survive deleting the pod budget but got %v", err)
+	}
+}
+
+func TestSetDisruptionsTxn(t *testing.T) {
+	fixture := consulutil.NewFixture(t)
+	defer fixture.Stop()
+	store := NewConsul(fixture.Client)
+
+	err := store.Set(fields.Budget{PodID: "some_pod", MaxUnavailable: fields.Limit(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	budget, err := store.Get("some_pod", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	disruption := fields.Disruption{
+		Holder:  "some_holder",
+		Node:    "node1",
+		Expires: time.Now().Add(time.Minute),
+	}
+	ctx, cancel := transaction.New(context.Background())
+	defer cancel()
+	err = store.SetDisruptionsTxn(ctx, budget, []fields.Disruption{disruption})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = transaction.MustCommit(ctx, fixture.Client.KV())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// replacing the budget should keep its disruptions
+	err = store.Set(fields.Budget{PodID: "some_pod", MaxUnavailable: fields.Limit(2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated, err := store.Get("some_pod", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *updated.MaxUnavailable != 2 || len(updated.Disruptions) != 1 || !updated.Disruptions[0].Expires.Equal(disruption.Expires) {
+		t.Errorf("expected the replaced budget to have max unavailable 2 and disruption %+v but got %+v", disruption, updated)
+	}
+
+	// the budget that was read before the disruption was reserved is stale
+	ctx, cancel = transaction.New(context.Background())
+	defer cancel()
+	err = store.SetDisruptionsTxn(ctx, budget, []fields.Disruption{disruption})
+	if err == nil {
+		t.Fatal("expected an error reserving against a stale budget")
+	}
+}
@@ -41,8 +41,9 @@ func (a AuditingStore) Create(
 	podID types.PodID,
 	timeout time.Duration,
 	user string,
+	opts ...CreateOptions,
 ) (fields.DaemonSet, error) {
-	ds, err := a.innerStore.Create(ctx, manifest, minHealth, name, nodeSelector, podID, timeout)
+	ds, err := a.innerStore.Create(ctx, manifest, minHealth, name, nodeSelector, podID, timeout, opts...)
 	if err != nil {
 		return fields.DaemonSet{}, err
 	}
@@ -160,6 +161,52 @@ func (a AuditingStore) UpdateNodeSelector(
 	return ds, nil
 }
 
+// Rollback changes the daemon set's manifest and node selector back to those
+// of the revision with the given number. The audit log records are the same
+// as if the manifest and node selector had been updated by hand
+func (a AuditingStore) Rollback(
+	ctx context.Context,
+	id fields.ID,
+	revisionNumber int,
+	user string,
+) (fields.DaemonSet, error) {
+	revision, err := a.innerStore.GetRevision(id, revisionNumber)
+	if err != nil {
+		return fields.DaemonSet{}, err
+	}
+
+	selectorChanged := false
+	mutator := func(ds fields.DaemonSet) (fields.DaemonSet, error) {
+		selectorChanged = ds.NodeSelector.String() != revision.NodeSelector.String()
+		ds.Manifest = revision.Manifest
+		ds.NodeSelector = revision.NodeSelector
+		return ds, nil
+	}
+
+	ds, err := a.innerStore.MutateDSTxn(ctx, id, mutator)
+	if err != nil {
+		return fields.DaemonSet{}, err
+	}
+
+	details, err := audit.NewDaemonSetDetails(ds, user)
+	if err != nil {
+		return fields.DaemonSet{}, err
+	}
+	err = a.auditLogStore.Create(ctx, audit.DSManifestUpdatedEvent, details)
+	if err != nil {
+		return fields.DaemonSet{}, util.Errorf("could not create audit log record for daemon set rollback: %s", err)
+	}
+
+	if selectorChanged {
+		err = a.auditLogStore.Create(ctx, audit.DSNodeSelectorUpdatedEvent, details)
+		if err != nil {
+			return fields.DaemonSet{}, util.Errorf("could not create audit log record for daemon set rollback: %s", err)
+		}
+	}
+
+	return ds, nil
+}
+
 func (a AuditingStore) Delete(
 	ctx context.Context,
 	id fields.ID,
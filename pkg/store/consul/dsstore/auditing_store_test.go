@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/square/p2/pkg/audit"
+	"github.com/square/p2/pkg/ds/fields"
 	"github.com/square/p2/pkg/logging"
 	"github.com/square/p2/pkg/manifest"
 	"github.com/square/p2/pkg/store/consul/auditlogstore"
@@ -859,6 +860,102 @@ func TestUpdateTimeout(t *testing.T) {
 	}
 }
 
+func TestRollback(t *testing.T) {
+	fixture := consulutil.NewFixture(t)
+	defer fixture.Stop()
+
+	logger := logging.TestLogger()
+	dsStore := NewConsul(fixture.Client, 0, &logger)
+	auditLogStore := auditlogstore.NewConsulStore(fixture.Client.KV())
+
+	auditingStore := NewAuditingStore(dsStore, auditLogStore)
+
+	ctx, cancel := transaction.New(context.Background())
+	defer cancel()
+	ds, err := dsStore.Create(ctx, testManifest(), 1, "some_name", klabels.Everything(), "some_pod", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = transaction.MustCommit(ctx, fixture.Client.KV())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	builder := testManifest().GetBuilder()
+	err = builder.SetConfig(map[interface{}]interface{}{"foo": "bar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newSelector := klabels.Everything().Add("some_key", klabels.EqualsOperator, []string{"some_value"})
+	_, err = dsStore.MutateDS(ds.ID, func(ds fields.DaemonSet) (fields.DaemonSet, error) {
+		ds.Manifest = builder.GetManifest()
+		ds.NodeSelector = newSelector
+		return ds, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel = transaction.New(context.Background())
+	defer cancel()
+	_, err = auditingStore.Rollback(ctx, ds.ID, 5, "some_user")
+	if err != NoRevision {
+		t.Errorf("expected NoRevision rolling back to a revision that doesn't exist but got %v", err)
+	}
+
+	ctx, cancel = transaction.New(context.Background())
+	defer cancel()
+	_, err = auditingStore.Rollback(ctx, ds.ID, 1, "some_user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = transaction.MustCommit(ctx, fixture.Client.KV())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ds, _, err = dsStore.Get(ds.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dsSHA, err := ds.Manifest.SHA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	testSHA, err := testManifest().SHA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dsSHA != testSHA {
+		t.Error("expected the daemon set's manifest to be rolled back")
+	}
+	if ds.NodeSelector.String() != klabels.Everything().String() {
+		t.Errorf("expected the daemon set's node selector to be rolled back but was %q", ds.NodeSelector.String())
+	}
+
+	// the rollback is itself a revision
+	revisions, err := dsStore.History(ds.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 3 {
+		t.Errorf("expected 3 revisions after rolling back but there were %d", len(revisions))
+	}
+
+	alMap, err := auditLogStore.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	eventTypes := make(map[audit.EventType]int)
+	for _, v := range alMap {
+		eventTypes[v.EventType]++
+	}
+	if eventTypes[audit.DSManifestUpdatedEvent] != 1 || eventTypes[audit.DSNodeSelectorUpdatedEvent] != 1 || len(alMap) != 2 {
+		t.Errorf("expected a manifest update and a node selector update audit log record but got %v", eventTypes)
+	}
+}
+
 func testManifest() manifest.Manifest {
 	builder := manifest.NewBuilder()
 	builder.SetID("some_pod")
@@ -24,13 +24,24 @@ import (
 
 const dsTree string = "daemon_sets"
 
+// dsHistoryTree holds the revision history of each daemon set. It must not
+// share a prefix with dsTree because dsTree is watched by prefix
+const dsHistoryTree string = "daemon_set_history"
+
+// RevisionHistoryLimit is the number of revisions of a daemon set's manifest
+// and node selector that are kept. Older revisions are discarded
+const RevisionHistoryLimit = 10
+
 var NoDaemonSet error = errors.New("No daemon set found")
 
+var NoRevision error = errors.New("No daemon set revision found")
+
 type consulKV interface {
 	CAS(pair *api.KVPair, opts *api.WriteOptions) (bool, *api.WriteMeta, error)
 	Delete(key string, w *api.WriteOptions) (*api.WriteMeta, error)
 	Get(key string, opts *api.QueryOptions) (*api.KVPair, *api.QueryMeta, error)
 	List(prefix string, opts *api.QueryOptions) (api.KVPairs, *api.QueryMeta, error)
+	Txn(txn api.KVTxnOps, q *api.QueryOptions) (bool, *api.KVTxnResponse, *api.QueryMeta, error)
 }
 
 // store represents an interface for persisting daemon set to Consul,
@@ -56,9 +67,23 @@ func NewConsul(client consulutil.ConsulClient, retries int, logger *logging.Logg
 	}
 }
 
+// CreateOptions holds the optional settings of a new daemon set. The zero
+// value deploys a new manifest to as many nodes at once as the replication
+// allows, without canary nodes.
+type CreateOptions struct {
+	// MaxInFlight limits the number of nodes a new manifest is deployed
+	// to at once
+	MaxInFlight fields.MaxInFlight
+
+	// CanaryNodes is the number of nodes that must be healthy with a new
+	// manifest before it is deployed to any other nodes
+	CanaryNodes int
+}
+
 // Create creates a daemon set with the specified manifest and selectors.
 // The node selector is used to determine what nodes the daemon set may schedule on.
 // The pod label set is applied to every pod the daemon set schedules.
+// At most one CreateOptions may be passed.
 func (s *ConsulStore) Create(
 	ctx context.Context,
 	manifest manifest.Manifest,
@@ -67,12 +92,24 @@ func (s *ConsulStore) Create(
 	nodeSelector klabels.Selector,
 	podID types.PodID,
 	timeout time.Duration,
+	opts ...CreateOptions,
 ) (fields.DaemonSet, error) {
 	if err := checkManifestPodID(podID, manifest); err != nil {
 		return fields.DaemonSet{}, util.Errorf("Error verifying manifest pod id: %v", err)
 	}
+	var options CreateOptions
+	switch len(opts) {
+	case 0:
+	case 1:
+		options = opts[0]
+	default:
+		return fields.DaemonSet{}, util.Errorf("Expected at most one set of create options, got %d", len(opts))
+	}
+	if err := checkRollout(options.MaxInFlight, options.CanaryNodes); err != nil {
+		return fields.DaemonSet{}, util.Errorf("Error verifying rollout: %v", err)
+	}
 
-	ds, err := s.innerCreate(ctx, manifest, minHealth, name, nodeSelector, podID, timeout)
+	ds, err := s.innerCreate(ctx, manifest, minHealth, name, nodeSelector, podID, timeout, options)
 	if err != nil {
 		return fields.DaemonSet{}, util.Errorf("Error creating daemon set: %v", err)
 	}
@@ -88,6 +125,7 @@ func (s *ConsulStore) innerCreate(
 	nodeSelector klabels.Selector,
 	podID types.PodID,
 	timeout time.Duration,
+	options CreateOptions,
 ) (fields.DaemonSet, error) {
 	id := fields.ID(uuid.Must(uuid.NewV4()).String())
 	dsPath, err := s.dsPath(id)
@@ -103,6 +141,8 @@ func (s *ConsulStore) innerCreate(
 		NodeSelector: nodeSelector,
 		PodID:        podID,
 		Timeout:      timeout,
+		MaxInFlight:  options.MaxInFlight,
+		CanaryNodes:  options.CanaryNodes,
 	}
 	// Marshals ds into []bytes using overloaded MarshalJSON
 	rawDS, err := json.Marshal(ds)
@@ -119,6 +159,11 @@ func (s *ConsulStore) innerCreate(
 	if err != nil {
 		return fields.DaemonSet{}, err
 	}
+
+	err = s.recordRevisionTxn(ctx, nil, ds)
+	if err != nil {
+		return fields.DaemonSet{}, err
+	}
 	return ds, nil
 }
 
@@ -134,6 +179,15 @@ func (s *ConsulStore) Delete(id fields.ID) error {
 		return consulutil.NewKVError("delete", dsPath, err)
 	}
 
+	historyPath, err := s.historyPath(id)
+	if err != nil {
+		return util.Errorf("Error getting daemon set history path: %v", err)
+	}
+	_, err = s.kv.Delete(historyPath, nil)
+	if err != nil {
+		return consulutil.NewKVError("delete", historyPath, err)
+	}
+
 	return nil
 }
 
@@ -154,7 +208,15 @@ func (s *ConsulStore) DeleteTxn(ctx context.Context, id fields.ID) error {
 		return err
 
 	}
-	return nil
+
+	historyPath, err := s.historyPath(id)
+	if err != nil {
+		return util.Errorf("Error getting daemon set history path: %v", err)
+	}
+	return transaction.Add(ctx, api.KVTxnOp{
+		Verb: api.KVDelete,
+		Key:  historyPath,
+	})
 }
 
 // Get retrieves a daemon set by ID. If it does not exist, it will produce an error
@@ -201,32 +263,12 @@ func (s *ConsulStore) MutateDS(
 	id fields.ID,
 	mutator func(fields.DaemonSet) (fields.DaemonSet, error),
 ) (fields.DaemonSet, error) {
-	ds, metadata, err := s.Get(id)
-	if err != nil {
-		if err == NoDaemonSet {
-			// we need to pass this through so callers can distinguish this from other errors
-			return fields.DaemonSet{}, err
-		}
+	ctx, cancel := transaction.New(context.Background())
+	defer cancel()
 
-		return fields.DaemonSet{}, util.Errorf("Error getting daemon set: %v", err)
-	}
-
-	ds, err = mutator(ds)
-	if err != nil {
-		return fields.DaemonSet{}, util.Errorf("Error mutating daemon set: %v", err)
-	}
-	if ds.ID != id {
-		// If the user wants a new uuid, they should delete it and create it
-		return fields.DaemonSet{},
-			util.Errorf("Explicitly changing daemon set ID is not permitted: Wanted '%s' got '%s'", id, ds.ID)
-	}
-	if err := checkManifestPodID(ds.PodID, ds.Manifest); err != nil {
-		return fields.DaemonSet{}, util.Errorf("Error verifying manifest pod id: %v", err)
-	}
-
-	rawDS, err := json.Marshal(ds)
+	ds, err := s.MutateDSTxn(ctx, id, mutator)
 	if err != nil {
-		return fields.DaemonSet{}, util.Errorf("Could not marshal DS as json: %s", err)
+		return fields.DaemonSet{}, err
 	}
 
 	dsPath, err := s.dsPath(id)
@@ -234,16 +276,16 @@ func (s *ConsulStore) MutateDS(
 		return fields.DaemonSet{}, util.Errorf("Error getting daemon set path: %v", err)
 	}
 
-	success, _, err := s.kv.CAS(&api.KVPair{
-		Key:         dsPath,
-		Value:       rawDS,
-		ModifyIndex: metadata.LastIndex,
-	}, nil)
+	// the daemon set and its revision history are written together so
+	// that every manifest the daemon set has had can be rolled back to.
+	// Only a rolled back transaction is a CAS failure, other errors are
+	// passed through as they are
+	ok, _, err := transaction.Commit(ctx, s.kv)
 	if err != nil {
-		return fields.DaemonSet{}, consulutil.NewKVError("cas", dsPath, err)
+		return fields.DaemonSet{}, util.Errorf("Could not commit daemon set mutation: %s", err)
 	}
 
-	if !success {
+	if !ok {
 		return fields.DaemonSet{}, CASError(dsPath)
 	}
 
@@ -268,6 +310,7 @@ func (s *ConsulStore) MutateDSTxn(
 		return fields.DaemonSet{}, util.Errorf("Error getting daemon set: %v", err)
 	}
 
+	oldDS := ds
 	ds, err = mutator(ds)
 	if err != nil {
 		return fields.DaemonSet{}, util.Errorf("Error mutating daemon set: %v", err)
@@ -280,6 +323,9 @@ func (s *ConsulStore) MutateDSTxn(
 	if err := checkManifestPodID(ds.PodID, ds.Manifest); err != nil {
 		return fields.DaemonSet{}, util.Errorf("Error verifying manifest pod id: %v", err)
 	}
+	if err := checkRollout(ds.MaxInFlight, ds.CanaryNodes); err != nil {
+		return fields.DaemonSet{}, util.Errorf("Error verifying rollout: %v", err)
+	}
 
 	rawDS, err := json.Marshal(ds)
 	if err != nil {
@@ -302,9 +348,142 @@ func (s *ConsulStore) MutateDSTxn(
 		return fields.DaemonSet{}, err
 	}
 
+	err = s.recordRevisionTxn(ctx, &oldDS, ds)
+	if err != nil {
+		return fields.DaemonSet{}, err
+	}
+
 	return ds, nil
 }
 
+// History returns the revisions of the daemon set's manifest and node
+// selector that have been kept, oldest first
+func (s *ConsulStore) History(id fields.ID) ([]fields.Revision, error) {
+	revisions, _, err := s.getHistory(id)
+	return revisions, err
+}
+
+// GetRevision returns the revision of the daemon set with the given number.
+// It returns NoRevision if the revision was never made or has been discarded
+func (s *ConsulStore) GetRevision(id fields.ID, number int) (fields.Revision, error) {
+	revisions, _, err := s.getHistory(id)
+	if err != nil {
+		return fields.Revision{}, err
+	}
+
+	for _, revision := range revisions {
+		if revision.Number == number {
+			return revision, nil
+		}
+	}
+	return fields.Revision{}, NoRevision
+}
+
+func (s *ConsulStore) getHistory(id fields.ID) ([]fields.Revision, uint64, error) {
+	historyPath, err := s.historyPath(id)
+	if err != nil {
+		return nil, 0, util.Errorf("Error getting daemon set history path: %v", err)
+	}
+
+	kvp, _, err := s.kv.Get(historyPath, nil)
+	if err != nil {
+		return nil, 0, consulutil.NewKVError("get", historyPath, err)
+	}
+	if kvp == nil {
+		return nil, 0, nil
+	}
+
+	var revisions []fields.Revision
+	err = json.Unmarshal(kvp.Value, &revisions)
+	if err != nil {
+		return nil, 0, util.Errorf("Could not unmarshal daemon set history ('%s') as json: %s", string(kvp.Value), err)
+	}
+	return revisions, kvp.ModifyIndex, nil
+}
+
+// recordRevisionTxn adds an operation to the passed transaction to append a
+// revision to the daemon set's history if its manifest or node selector
+// differs from oldDS. oldDS is nil when the daemon set is being created.
+// Daemon sets created before their history was kept get a revision for oldDS
+// first, so that the change can be rolled back
+func (s *ConsulStore) recordRevisionTxn(ctx context.Context, oldDS *fields.DaemonSet, ds fields.DaemonSet) error {
+	if oldDS != nil {
+		changed, err := revisionChanged(*oldDS, ds)
+		if err != nil {
+			return err
+		}
+		if !changed {
+			return nil
+		}
+	}
+
+	revisions, index, err := s.getHistory(ds.ID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if len(revisions) == 0 && oldDS != nil {
+		// the daemon set was created before its history was kept, so
+		// when it got its first revision is unknown. The earliest time
+		// it is known to have had it is now
+		revisions = append(revisions, fields.Revision{
+			Number:       1,
+			Manifest:     oldDS.Manifest,
+			NodeSelector: oldDS.NodeSelector,
+			Created:      now,
+		})
+	}
+
+	number := 1
+	if len(revisions) > 0 {
+		number = revisions[len(revisions)-1].Number + 1
+	}
+	revisions = append(revisions, fields.Revision{
+		Number:       number,
+		Manifest:     ds.Manifest,
+		NodeSelector: ds.NodeSelector,
+		Created:      now,
+	})
+	if len(revisions) > RevisionHistoryLimit {
+		revisions = revisions[len(revisions)-RevisionHistoryLimit:]
+	}
+
+	rawRevisions, err := json.Marshal(revisions)
+	if err != nil {
+		return util.Errorf("Could not marshal daemon set history as json: %s", err)
+	}
+
+	historyPath, err := s.historyPath(ds.ID)
+	if err != nil {
+		return util.Errorf("Error getting daemon set history path: %v", err)
+	}
+
+	// an index of 0 means the history must not exist yet
+	return transaction.Add(ctx, api.KVTxnOp{
+		Verb:  api.KVCAS,
+		Key:   historyPath,
+		Index: index,
+		Value: rawRevisions,
+	})
+}
+
+func revisionChanged(oldDS fields.DaemonSet, ds fields.DaemonSet) (bool, error) {
+	if oldDS.NodeSelector.String() != ds.NodeSelector.String() {
+		return true, nil
+	}
+
+	oldSHA, err := oldDS.Manifest.SHA()
+	if err != nil {
+		return false, util.Errorf("Unable to get SHA from daemon set manifest: %v", err)
+	}
+	newSHA, err := ds.Manifest.SHA()
+	if err != nil {
+		return false, util.Errorf("Unable to get SHA from daemon set manifest: %v", err)
+	}
+	return oldSHA != newSHA, nil
+}
+
 // Disable sets a flag on the daemon set to prevent it from operating.
 func (s *ConsulStore) Disable(id fields.ID) (fields.DaemonSet, error) {
 	mutator := func(dsToUpdate fields.DaemonSet) (fields.DaemonSet, error) {
@@ -530,6 +709,16 @@ func checkManifestPodID(dsPodID types.PodID, manifest manifest.Manifest) error {
 	return nil
 }
 
+func checkRollout(maxInFlight fields.MaxInFlight, canaryNodes int) error {
+	if err := maxInFlight.Validate(); err != nil {
+		return err
+	}
+	if canaryNodes < 0 {
+		return util.Errorf("Daemon set canary nodes cannot be negative, was %d", canaryNodes)
+	}
+	return nil
+}
+
 func (s *ConsulStore) dsPath(dsID fields.ID) (string, error) {
 	if dsID == "" {
 		return "", util.Errorf("Path requested for empty DS id")
@@ -537,6 +726,13 @@ func (s *ConsulStore) dsPath(dsID fields.ID) (string, error) {
 	return path.Join(dsTree, dsID.String()), nil
 }
 
+func (s *ConsulStore) historyPath(dsID fields.ID) (string, error) {
+	if dsID == "" {
+		return "", util.Errorf("Path requested for empty DS id")
+	}
+	return path.Join(dsHistoryTree, dsID.String()), nil
+}
+
 func (s *ConsulStore) dsLockPath(dsID fields.ID) (string, error) {
 	dsPath, err := s.dsPath(dsID)
 	if err != nil {
@@ -65,6 +65,41 @@ func TestCreate(t *testing.T) {
 	}
 }
 
+func TestCreateWithOptions(t *testing.T) {
+	fixture := consulutil.NewFixture(t)
+	defer fixture.Stop()
+	store := newStore(fixture.Client.KV())
+
+	podID := types.PodID("some_pod_id")
+	manifestBuilder := manifest.NewBuilder()
+	manifestBuilder.SetID(podID)
+	podManifest := manifestBuilder.GetManifest()
+
+	ctx, cancelFunc := transaction.New(context.Background())
+	defer cancelFunc()
+	options := CreateOptions{MaxInFlight: "25%", CanaryNodes: 2}
+	ds, err := store.Create(ctx, podManifest, 0, "some_name", klabels.Everything(), podID, replication.NoTimeout, options)
+	if err != nil {
+		t.Fatalf("Unable to create daemon set: %s", err)
+	}
+	err = transaction.MustCommit(ctx, fixture.Client.KV())
+	if err != nil {
+		t.Fatalf("could not commit transaction to create daemon set: %s", err)
+	}
+
+	ds, _, err = store.Get(ds.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Assert(t).AreEqual(ds.MaxInFlight, options.MaxInFlight, "Daemon set max in flight was not set correctly")
+	Assert(t).AreEqual(ds.CanaryNodes, options.CanaryNodes, "Daemon set canary nodes was not set correctly")
+
+	badOptions := CreateOptions{CanaryNodes: -1}
+	if _, err := store.Create(ctx, podManifest, 0, "some_name", klabels.Everything(), podID, replication.NoTimeout, badOptions); err == nil {
+		t.Error("Expected create to fail on negative canary nodes")
+	}
+}
+
 func createDaemonSet(store *ConsulStore, txner transaction.Txner, t *testing.T) ds_fields.DaemonSet {
 	podID := types.PodID("some_pod_id")
 	minHealth := 0
@@ -912,6 +947,149 @@ func TestLockForOwnershipTxn(t *testing.T) {
 	}
 }
 
+func TestHistoryOfDaemonSetWithoutHistory(t *testing.T) {
+	fixture := consulutil.NewFixture(t)
+	defer fixture.Stop()
+	store := newStore(fixture.Client.KV())
+	ds := createDaemonSet(store, fixture.Client.KV(), t)
+
+	// daemon sets created before history was kept have none
+	historyPath, err := store.historyPath(ds.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = fixture.Client.KV().Delete(historyPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	builder := ds.Manifest.GetBuilder()
+	err = builder.SetConfig(map[interface{}]interface{}{"version": "new"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newManifest := builder.GetManifest()
+	_, err = store.MutateDS(ds.ID, func(ds ds_fields.DaemonSet) (ds_fields.DaemonSet, error) {
+		ds.Manifest = newManifest
+		return ds, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	revisions, err := store.History(ds.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 2 || revisions[0].Number != 1 || revisions[1].Number != 2 {
+		t.Fatalf("expected the daemon set's original manifest to be kept as revision 1 but got %+v", revisions)
+	}
+	for _, revision := range revisions {
+		if revision.Created.IsZero() {
+			t.Errorf("expected revision %d to have a creation time", revision.Number)
+		}
+	}
+}
+
+func TestHistory(t *testing.T) {
+	fixture := consulutil.NewFixture(t)
+	defer fixture.Stop()
+	store := newStore(fixture.Client.KV())
+	ds := createDaemonSet(store, fixture.Client.KV(), t)
+
+	revisions, err := store.History(ds.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 1 || revisions[0].Number != 1 {
+		t.Fatalf("expected creating a daemon set to make revision 1 but got %+v", revisions)
+	}
+
+	// changes to other fields don't make revisions
+	_, err = store.MutateDS(ds.ID, func(ds ds_fields.DaemonSet) (ds_fields.DaemonSet, error) {
+		ds.MinHealth++
+		return ds, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	revisions, err = store.History(ds.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 1 {
+		t.Fatalf("expected changing the minimum health not to make a revision but there were %d revisions", len(revisions))
+	}
+
+	for i := 0; i < RevisionHistoryLimit+2; i++ {
+		builder := ds.Manifest.GetBuilder()
+		err = builder.SetConfig(map[interface{}]interface{}{"version": i})
+		if err != nil {
+			t.Fatal(err)
+		}
+		newManifest := builder.GetManifest()
+		_, err = store.MutateDS(ds.ID, func(ds ds_fields.DaemonSet) (ds_fields.DaemonSet, error) {
+			ds.Manifest = newManifest
+			return ds, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	revisions, err = store.History(ds.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != RevisionHistoryLimit {
+		t.Fatalf("expected %d revisions to be kept but there were %d", RevisionHistoryLimit, len(revisions))
+	}
+	latest := revisions[len(revisions)-1]
+	if latest.Number != RevisionHistoryLimit+3 {
+		t.Errorf("expected the latest revision to be %d but was %d", RevisionHistoryLimit+3, latest.Number)
+	}
+
+	current, _, err := store.Get(ds.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	currentSHA, err := current.Manifest.SHA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	latestSHA, err := latest.Manifest.SHA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if currentSHA != latestSHA {
+		t.Error("expected the latest revision to have the daemon set's manifest")
+	}
+
+	_, err = store.GetRevision(ds.ID, 1)
+	if err != NoRevision {
+		t.Errorf("expected revision 1 to have been discarded but got %v", err)
+	}
+	revision, err := store.GetRevision(ds.ID, latest.Number-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revision.Number != latest.Number-1 {
+		t.Errorf("expected to get revision %d but got %d", latest.Number-1, revision.Number)
+	}
+
+	err = store.Delete(ds.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revisions, err = store.History(ds.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 0 {
+		t.Errorf("expected the history to be deleted with the daemon set but there were %d revisions", len(revisions))
+	}
+}
+
 func newStore(kv consulKV) *ConsulStore {
 	return &ConsulStore{
 		kv:      kv,
@@ -27,6 +27,9 @@ func ParseWithConsulOptions() (string, consul.Options, labels.ApplicatorWithoutW
 	caFile := kingpin.Flag("tls-ca-file", "File containing the x509 PEM-encoded CA ").ExistingFile()
 	keyFile := kingpin.Flag("tls-key-file", "File containing the x509 PEM-encoded private key").ExistingFile()
 	certFile := kingpin.Flag("tls-cert-file", "File containing the x509 PEM-encoded public key certificate").ExistingFile()
+	labelHistoryTypes := kingpin.Flag("label-history-type", "A label type whose changes are recorded in the label history. Can be specified multiple times. Defaults to node labels only").
+		Default(labels.DefaultHistoryTypes[0].String()).
+		Enums(labels.NODE.String(), labels.POD.String(), labels.RC.String(), labels.PC.String(), labels.RU.String())
 
 	cmd := kingpin.Parse()
 
@@ -65,16 +68,28 @@ func ParseWithConsulOptions() (string, consul.Options, labels.ApplicatorWithoutW
 		WaitTime: *wait,
 	}
 
+	historyTypes := make([]labels.Type, 0, len(*labelHistoryTypes))
+	for _, labelHistoryType := range *labelHistoryTypes {
+		historyType, err := labels.AsType(labelHistoryType)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		historyTypes = append(historyTypes, historyType)
+	}
+
 	var applicator labels.ApplicatorWithoutWatches
-	var err error
 	if *httpApplicatorURL != nil {
-		applicator, err = labels.NewHTTPApplicator(httpClient, *httpApplicatorURL)
+		httpApplicator, err := labels.NewHTTPApplicator(httpClient, *httpApplicatorURL)
 		if err != nil {
 			log.Fatalln(err)
 		}
+		httpApplicator.SetHistoryTypes(historyTypes)
+		applicator = httpApplicator
 	} else {
 		jitterWindow := 0 * time.Second // we don't initiate watches in CLIs so this doesn't matter
-		applicator = labels.NewConsulApplicator(consul.NewConsulClient(consulOpts), 0, jitterWindow)
+		consulApplicator := labels.NewConsulApplicator(consul.NewConsulClient(consulOpts), 0, jitterWindow)
+		consulApplicator.SetHistoryTypes(historyTypes)
+		applicator = consulApplicator
 	}
 	return cmd, consulOpts, applicator
 }
@@ -381,10 +381,13 @@ func (m *consulHealthManager) processHealthUpdater(
 	}
 }
 
-// Helper to processHealthUpdater()
+// Helper to processHealthUpdater(). Like WatchResult.ValueEquiv it ignores
+// the output, which is only written along with a change in health
 func healthEquiv(x *WatchResult, y *WatchResult) bool {
 	return x == nil && y == nil ||
-		x != nil && y != nil && x.Status == y.Status
+		x != nil && y != nil &&
+			x.Status == y.Status &&
+			x.Launchables.Equal(y.Launchables)
 }
 
 func toThrottled(wr *WatchResult) *WatchResult {
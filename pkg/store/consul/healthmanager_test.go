@@ -5,6 +5,7 @@ package consul
 import (
 	"encoding/json"
 	"fmt"
+	"reflect"
 	"sync"
 	"testing"
 	"time"
@@ -39,7 +40,7 @@ func TestHealthBasic(t *testing.T) {
 
 	// Creating an updater with no health statuses shouldn't write anything
 	time.Sleep(100 * time.Millisecond)
-	if r, err := f.Store.GetHealth("svc", "node"); err != nil || r != hEmpty {
+	if r, err := f.Store.GetHealth("svc", "node"); err != nil || !reflect.DeepEqual(r, hEmpty) {
 		t.Fatalf("health expected to be empty, got value %#v error %#v", r, err)
 	}
 
@@ -57,7 +58,7 @@ func TestHealthBasic(t *testing.T) {
 	// Destroy the service, health check should disappear
 	updater.Close()
 	waiter.WaitForChange()
-	if r, err := f.Store.GetHealth("svc", "node"); err != nil || r != hEmpty {
+	if r, err := f.Store.GetHealth("svc", "node"); err != nil || !reflect.DeepEqual(r, hEmpty) {
 		t.Fatalf("health expected to be empty, got value %#v error %#v", r, err)
 	}
 }
@@ -135,7 +136,7 @@ func TestHealthSessionRequired(t *testing.T) {
 	go m.processHealthUpdater(f.Client.KV(), checks, sessions, logging.TestLogger())
 
 	// There should be no health check initially
-	if r, err := f.Store.GetHealth("svc", "node"); err != nil || r != hEmpty {
+	if r, err := f.Store.GetHealth("svc", "node"); err != nil || !reflect.DeepEqual(r, hEmpty) {
 		t.Fatalf("health expected to be empty, got value %#v error %#v", r, err)
 	}
 
@@ -146,7 +147,7 @@ func TestHealthSessionRequired(t *testing.T) {
 	time.Sleep(50 * time.Millisecond)
 	checks <- h2
 	time.Sleep(100 * time.Millisecond)
-	if r, err := f.Store.GetHealth("svc", "node"); err != nil || r != hEmpty {
+	if r, err := f.Store.GetHealth("svc", "node"); err != nil || !reflect.DeepEqual(r, hEmpty) {
 		t.Fatalf("health expected to be empty, got value %#v error %#v", r, err)
 	}
 }
@@ -184,14 +185,14 @@ func TestHealthSessionRestart(t *testing.T) {
 	f.DestroySession(s1)
 	sessions <- ""
 	waiter.WaitForChange()
-	if r, err := f.Store.GetHealth("svc", "node"); err != nil || r != hEmpty {
+	if r, err := f.Store.GetHealth("svc", "node"); err != nil || !reflect.DeepEqual(r, hEmpty) {
 		t.Fatalf("health expected to be empty, got value %#v error %#v", r, err)
 	}
 
 	// No change when updating health mid-session
 	checks <- h3
 	time.Sleep(50 * time.Millisecond)
-	if r, err := f.Store.GetHealth("svc", "node"); err != nil || r != hEmpty {
+	if r, err := f.Store.GetHealth("svc", "node"); err != nil || !reflect.DeepEqual(r, hEmpty) {
 		t.Fatalf("health expected to be empty, got value %#v error %#v", r, err)
 	}
 
@@ -206,7 +207,7 @@ func TestHealthSessionRestart(t *testing.T) {
 	// Shut down the health checker, deleting the health check
 	close(checks)
 	waiter.WaitForChange()
-	if r, err := f.Store.GetHealth("svc", "node"); err != nil || r != hEmpty {
+	if r, err := f.Store.GetHealth("svc", "node"); err != nil || !reflect.DeepEqual(r, hEmpty) {
 		t.Fatalf("health expected to be empty, got value %#v error %#v", r, err)
 	}
 }
@@ -484,3 +485,30 @@ func TestHealthUpdatesTolerantToConsulFailures(t *testing.T) {
 		}
 	}
 }
+
+func TestHealthEquivIgnoresOutput(t *testing.T) {
+	critical := WatchResult{
+		Id:      "pod_id",
+		Service: "service_name",
+		Status:  string(health.Critical),
+		Output:  "connection refused",
+	}
+	if !healthEquiv(&critical, &critical) {
+		t.Error("expected a result to be equivalent to itself")
+	}
+
+	timedOut := critical
+	timedOut.Output = "timed out"
+	if !healthEquiv(&critical, &timedOut) {
+		t.Error("expected results with the same status but a different output to be equivalent")
+	}
+	if !critical.ValueEquiv(timedOut) {
+		t.Error("expected ValueEquiv to agree with healthEquiv about results that differ in output")
+	}
+
+	passing := critical
+	passing.Status = string(health.Passing)
+	if healthEquiv(&critical, &passing) {
+		t.Error("expected results with different statuses not to be equivalent")
+	}
+}
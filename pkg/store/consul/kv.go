@@ -12,6 +12,7 @@ import (
 
 	"github.com/hashicorp/consul/api"
 
+	"github.com/square/p2/pkg/health"
 	"github.com/square/p2/pkg/logging"
 	"github.com/square/p2/pkg/manifest"
 	"github.com/square/p2/pkg/pods"
@@ -31,6 +32,7 @@ const (
 	// Don't change this, it affects where status keys are read and written from
 	PreparerPodStatusNamespace statusstore.Namespace = "preparer"
 	RCStatusNamespace          statusstore.Namespace = "replication_controller"
+	RUStatusNamespace          statusstore.Namespace = "rolling_update"
 )
 
 type ManifestResult struct {
@@ -70,15 +72,24 @@ type WatchResult struct {
 	Status  string
 	Time    time.Time
 	Expires time.Time `json:"Expires,omitempty"`
+
+	// Launchables holds the health of the launchables that have their own
+	// checks
+	Launchables health.LaunchableStatuses `json:"Launchables,omitempty"`
+
+	// Output describes why the result is not passing
+	Output string `json:"Output,omitempty"`
 }
 
 // ValueEquiv returns true if the value of the WatchResult--everything except the
-// timestamps--is equivalent to another WatchResult.
+// timestamps and output--is equivalent to another WatchResult. The output of exec
+// checks can differ on every run, so comparing it would make every check a write.
 func (r WatchResult) ValueEquiv(s WatchResult) bool {
 	return r.Id == s.Id &&
 		r.Node == s.Node &&
 		r.Service == s.Service &&
-		r.Status == s.Status
+		r.Status == s.Status &&
+		r.Launchables.Equal(s.Launchables)
 }
 
 // IsStale returns true when the result is stale according to the local clock.
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/square/p2/pkg/health"
 	"github.com/square/p2/pkg/manifest"
 	"github.com/square/p2/pkg/pods"
 	"github.com/square/p2/pkg/store/consul/consulutil"
@@ -92,6 +93,30 @@ func TestGetHealthWithEntry(t *testing.T) {
 	}
 }
 
+func TestWatchResultValueEquivLaunchables(t *testing.T) {
+	r := WatchResult{
+		Id:          "svc",
+		Node:        "node",
+		Service:     "svc",
+		Status:      "critical",
+		Launchables: health.LaunchableStatuses{"web": health.Critical, "worker": health.Passing},
+	}
+	s := r
+	s.Launchables = health.LaunchableStatuses{"worker": health.Passing, "web": health.Critical}
+	if !r.ValueEquiv(s) {
+		t.Error("expected results with the same launchable statuses to be equivalent")
+	}
+
+	s.Launchables = health.LaunchableStatuses{"web": health.Passing, "worker": health.Passing}
+	if r.ValueEquiv(s) {
+		t.Error("expected results with different launchable statuses not to be equivalent")
+	}
+	s.Launchables = nil
+	if r.ValueEquiv(s) {
+		t.Error("expected results with different launchables not to be equivalent")
+	}
+}
+
 func TestMutate(t *testing.T) {
 	f := NewConsulTestFixture(t)
 	defer f.Close()
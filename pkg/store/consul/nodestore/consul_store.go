@@ -0,0 +1,268 @@
+// Package nodestore stores the inventory record that each node's preparer
+// publishes to consul. Records are written under a session held by the
+// preparer, so a record whose session has been released belongs to a node
+// whose preparer has stopped heartbeating.
+package nodestore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/sirupsen/logrus"
+
+	"github.com/square/p2/pkg/cgroups"
+	"github.com/square/p2/pkg/logging"
+	"github.com/square/p2/pkg/osversion"
+	"github.com/square/p2/pkg/store/consul/consulutil"
+	"github.com/square/p2/pkg/store/consul/transaction"
+	"github.com/square/p2/pkg/types"
+	"github.com/square/p2/pkg/util"
+	"github.com/square/p2/pkg/util/param"
+	"github.com/square/p2/pkg/util/size"
+)
+
+const nodeTree string = "node_inventory"
+
+var (
+	// SessionTTLSec sets the TTL of the session node records are published
+	// under. It controls how long it takes for a record to stop being alive
+	// after its preparer stops heartbeating.
+	SessionTTLSec = param.Int("node_inventory_session_ttl_sec", 30)
+)
+
+var NoRecord error = errors.New("No node inventory record found")
+
+// InstalledPod identifies a pod the preparer has installed on the node.
+// UniqueKey is empty for legacy pods
+type InstalledPod struct {
+	ID        types.PodID        `json:"id"`
+	UniqueKey types.PodUniqueKey `json:"unique_key,omitempty"`
+}
+
+// Inventory describes a node as observed by its preparer
+type Inventory struct {
+	Node     types.NodeName `json:"node"`
+	Hostname string         `json:"hostname"`
+
+	OS        osversion.OS        `json:"os,omitempty"`
+	OSVersion osversion.OSVersion `json:"os_version,omitempty"`
+
+	// CPUs and Memory are the node's total capacity, not what is free
+	CPUs   int            `json:"cpus"`
+	Memory size.ByteCount `json:"memory"`
+
+	// CgroupSubsystems holds the mount points of the cgroup subsystems
+	// found on the node. Subsystems that weren't found are empty
+	CgroupSubsystems cgroups.Subsystems `json:"cgroup_subsystems"`
+
+	PreparerVersion string         `json:"preparer_version"`
+	Pods            []InstalledPod `json:"pods"`
+
+	// UpdatedAt is when the record was last published
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Record is an inventory record as read from consul
+type Record struct {
+	Inventory
+
+	// Alive is true while the session the record was published under is
+	// held, i.e. while the node's preparer is heartbeating
+	Alive bool `json:"alive"`
+
+	// ModifyIndex is consul's index of the last write to the record. Unlike
+	// UpdatedAt it doesn't depend on the node's clock, so it can be used to
+	// tell whether the record has changed between two reads.
+	ModifyIndex uint64 `json:"modify_index"`
+}
+
+// Stale returns true if the record's preparer has stopped heartbeating or
+// hasn't published the record within maxAge
+func (r Record) Stale(maxAge time.Duration) bool {
+	return !r.Alive || time.Since(r.UpdatedAt) > maxAge
+}
+
+type KV interface {
+	Get(key string, q *api.QueryOptions) (*api.KVPair, *api.QueryMeta, error)
+	List(prefix string, q *api.QueryOptions) (api.KVPairs, *api.QueryMeta, error)
+	Acquire(p *api.KVPair, q *api.WriteOptions) (bool, *api.WriteMeta, error)
+}
+
+var _ KV = &api.KV{}
+
+type ConsulStore struct {
+	client consulutil.ConsulClient
+	kv     KV
+}
+
+func NewConsul(client consulutil.ConsulClient) *ConsulStore {
+	return &ConsulStore{
+		client: client,
+		kv:     client.KV(),
+	}
+}
+
+func nodePath(node types.NodeName) (string, error) {
+	if node == "" {
+		return "", util.Errorf("path requested for empty node name")
+	}
+	return path.Join(nodeTree, node.String()), nil
+}
+
+// Publish writes a node's inventory record under the given session. It fails
+// if the record is held by a different session, e.g. that of a preparer that
+// was restarted before its old session expired.
+func (s *ConsulStore) Publish(session string, inventory Inventory) error {
+	key, err := nodePath(inventory.Node)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(inventory)
+	if err != nil {
+		return util.Errorf("could not marshal node inventory as json: %s", err)
+	}
+
+	success, _, err := s.kv.Acquire(&api.KVPair{
+		Key:     key,
+		Value:   b,
+		Session: session,
+	}, nil)
+	if err != nil {
+		return consulutil.NewKVError("acquire", key, err)
+	}
+	if !success {
+		return util.Errorf("node inventory record at %s is held by another session", key)
+	}
+	return nil
+}
+
+// Get returns a node's inventory record. NoRecord is returned if the node has
+// never published one.
+func (s *ConsulStore) Get(node types.NodeName) (Record, error) {
+	key, err := nodePath(node)
+	if err != nil {
+		return Record{}, err
+	}
+
+	kvp, _, err := s.kv.Get(key, nil)
+	if err != nil {
+		return Record{}, consulutil.NewKVError("get", key, err)
+	}
+	if kvp == nil {
+		return Record{}, NoRecord
+	}
+	return kvpToRecord(kvp)
+}
+
+// List returns the inventory records of every node that has published one
+func (s *ConsulStore) List() ([]Record, error) {
+	pairs, _, err := s.kv.List(nodeTree+"/", nil)
+	if err != nil {
+		return nil, consulutil.NewKVError("list", nodeTree+"/", err)
+	}
+
+	records := make([]Record, 0, len(pairs))
+	for _, pair := range pairs {
+		record, err := kvpToRecord(pair)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// DeleteTxn adds an operation deleting a node's inventory record to the
+// transaction. It should only be used for nodes that have been taken out of
+// service for good, since a running preparer publishes the record again.
+func (s *ConsulStore) DeleteTxn(ctx context.Context, node types.NodeName) error {
+	key, err := nodePath(node)
+	if err != nil {
+		return err
+	}
+	return transaction.Add(ctx, api.KVTxnOp{
+		Verb: api.KVDelete,
+		Key:  key,
+	})
+}
+
+func kvpToRecord(kvp *api.KVPair) (Record, error) {
+	var inventory Inventory
+	err := json.Unmarshal(kvp.Value, &inventory)
+	if err != nil {
+		return Record{}, util.Errorf("could not unmarshal node inventory at %s: %s", kvp.Key, err)
+	}
+	return Record{
+		Inventory:   inventory,
+		Alive:       kvp.Session != "",
+		ModifyIndex: kvp.ModifyIndex,
+	}, nil
+}
+
+// Heartbeat publishes a node's inventory every interval until quit is closed.
+// The records are published under a session that is renewed for as long as
+// Heartbeat runs and released when it returns, or when the session can't be
+// renewed, so that readers can tell the node has stopped heartbeating. Errors
+// gathering or publishing the inventory are logged and retried on the next
+// interval.
+func (s *ConsulStore) Heartbeat(
+	node types.NodeName,
+	interval time.Duration,
+	inventory func() (Inventory, error),
+	quit <-chan struct{},
+	logger logging.Logger,
+) {
+	logger = logger.SubLogger(logrus.Fields{"node": node})
+	sessions := make(chan string)
+	done := make(chan struct{})
+	sessionsClosed := make(chan struct{})
+	go func() {
+		defer close(sessionsClosed)
+		consulutil.SessionManager(
+			api.SessionEntry{
+				Name:      fmt.Sprintf("node-inventory:%s:%d", node, os.Getpid()),
+				LockDelay: 1 * time.Millisecond,
+				Behavior:  api.SessionBehaviorRelease,
+				TTL:       fmt.Sprintf("%ds", *SessionTTLSec),
+			},
+			s.client,
+			sessions,
+			done,
+			logger,
+		)
+	}()
+	defer func() {
+		close(done)
+		<-sessionsClosed
+	}()
+
+	consulutil.WithSession(quit, sessions, func(sessionQuit <-chan struct{}, session string) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			inv, err := inventory()
+			if err != nil {
+				logger.WithError(err).Errorln("Could not gather node inventory")
+			} else {
+				inv.Node = node
+				inv.UpdatedAt = time.Now()
+				err = s.Publish(session, inv)
+				if err != nil {
+					logger.WithError(err).Errorln("Could not publish node inventory")
+				}
+			}
+
+			select {
+			case <-sessionQuit:
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
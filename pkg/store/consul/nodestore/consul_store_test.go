@@ -0,0 +1,122 @@
+package nodestore
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/square/p2/pkg/logging"
+	"github.com/square/p2/pkg/store/consul/consulutil"
+	"github.com/square/p2/pkg/types"
+	"github.com/square/p2/pkg/util/size"
+)
+
+func TestPublishAndGet(t *testing.T) {
+	fixture := consulutil.NewFixture(t)
+	defer fixture.Stop()
+	store := NewConsul(fixture.Client)
+
+	_, err := store.Get("node1.example.com")
+	if err != NoRecord {
+		t.Fatalf("expected NoRecord before the node published one but got %v", err)
+	}
+
+	session, _, err := fixture.Client.Session().CreateNoChecks(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inventory := Inventory{
+		Node:            "node1.example.com",
+		Hostname:        "node1",
+		CPUs:            8,
+		Memory:          16 * size.Gibibyte,
+		PreparerVersion: "some_version",
+		Pods:            []InstalledPod{{ID: "some_pod"}},
+		UpdatedAt:       time.Now().UTC(),
+	}
+	err = store.Publish(session, inventory)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherSession, _, err := fixture.Client.Session().CreateNoChecks(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Publish(otherSession, inventory)
+	if err == nil {
+		t.Error("expected an error publishing a record held by another session")
+	}
+
+	record, err := store.Get("node1.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !record.Alive {
+		t.Error("expected the record to be alive while its session is held")
+	}
+	if !reflect.DeepEqual(record.Inventory, inventory) {
+		t.Errorf("expected inventory %+v but got %+v", inventory, record.Inventory)
+	}
+	if record.Stale(time.Minute) {
+		t.Error("expected a fresh record not to be stale")
+	}
+	if !record.Stale(0) {
+		t.Error("expected the record to be stale with a max age of 0")
+	}
+
+	_, err = fixture.Client.Session().Destroy(session, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("expected the record to outlive its session but there were %d records", len(records))
+	}
+	if records[0].Alive || !records[0].Stale(time.Minute) {
+		t.Error("expected the record to be dead and stale once its session was released")
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	fixture := consulutil.NewFixture(t)
+	defer fixture.Stop()
+	store := NewConsul(fixture.Client)
+
+	quit := make(chan struct{})
+	heartbeatDone := make(chan struct{})
+	go func() {
+		defer close(heartbeatDone)
+		store.Heartbeat("node1.example.com", 10*time.Millisecond, func() (Inventory, error) {
+			return Inventory{Hostname: "node1"}, nil
+		}, quit, logging.TestLogger())
+	}()
+
+	var record Record
+	var err error
+	timeout := time.After(5 * time.Second)
+	for record.Node == "" {
+		select {
+		case <-timeout:
+			t.Fatalf("timed out waiting for a heartbeat, last error was %v", err)
+		case <-time.After(10 * time.Millisecond):
+		}
+		record, err = store.Get("node1.example.com")
+	}
+	if record.Node != types.NodeName("node1.example.com") || record.Hostname != "node1" || !record.Alive {
+		t.Errorf("unexpected record %+v", record)
+	}
+
+	close(quit)
+	<-heartbeatDone
+	record, err = store.Get("node1.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Alive {
+		t.Error("expected the record not to be alive once heartbeating stopped")
+	}
+}
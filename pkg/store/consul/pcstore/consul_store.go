@@ -1,6 +1,7 @@
 package pcstore
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -14,6 +15,7 @@ import (
 	klabels "k8s.io/kubernetes/pkg/labels"
 	"k8s.io/kubernetes/pkg/util/sets"
 
+	"github.com/square/p2/pkg/health"
 	"github.com/square/p2/pkg/labels"
 	"github.com/square/p2/pkg/logging"
 	"github.com/square/p2/pkg/pc/fields"
@@ -79,6 +81,12 @@ type ConsulStore struct {
 	logger logging.Logger
 
 	metricsRegistry MetricsRegistry
+
+	// healthChecker is used to join the pods of each pod cluster with
+	// their health for syncers that implement HealthAwareSyncer. Health
+	// is not watched if it is nil
+	healthChecker    HealthWatcher
+	healthWatchDelay time.Duration
 }
 
 type pcLabeler interface {
@@ -89,6 +97,18 @@ type pcLabeler interface {
 	GetMatches(selector klabels.Selector, labelType labels.Type) ([]labels.Labeled, error)
 }
 
+// HealthWatcher is the subset of checker.HealthChecker used to watch the
+// health of the pods in pod clusters
+type HealthWatcher interface {
+	WatchService(
+		ctx context.Context,
+		serviceID string,
+		resultCh chan<- map[types.NodeName]health.Result,
+		errCh chan<- error,
+		watchDelay time.Duration,
+	)
+}
+
 type pcWatcher interface {
 	WatchMatches(
 		selector klabels.Selector,
@@ -117,6 +137,13 @@ func (s *ConsulStore) SetMetricsRegistry(reg MetricsRegistry) {
 	s.metricsRegistry = reg
 }
 
+// SetHealthChecker makes WatchAndSync pass the health of each pod to syncers
+// that implement HealthAwareSyncer. watchDelay is passed to WatchService()
+func (s *ConsulStore) SetHealthChecker(healthChecker HealthWatcher, watchDelay time.Duration) {
+	s.healthChecker = healthChecker
+	s.healthWatchDelay = watchDelay
+}
+
 func (s *ConsulStore) Create(
 	podID types.PodID,
 	availabilityZone fields.AvailabilityZone,
@@ -526,6 +553,31 @@ type ConcreteSyncer interface {
 	Type() ConcreteSyncerType
 }
 
+// Endpoint is a labeled pod in a pod cluster joined with its health. Pods
+// that have no health result, for example because health hasn't been
+// received yet or the pod has no health checks, or whose label doesn't
+// identify a node, have an empty health
+type Endpoint struct {
+	labels.Labeled
+
+	Node   types.NodeName
+	Health health.HealthState
+}
+
+// HealthAwareSyncer is a ConcreteSyncer that is told the health of every pod
+// in a pod cluster, for example so that unhealthy pods can be removed from a
+// load balancer.
+//
+// If the store passed to WatchAndSync has a health checker (see
+// SetHealthChecker()), SyncClusterWithHealth is called instead of
+// SyncCluster, and it is also called whenever the health of an endpoint
+// changes. Otherwise SyncCluster is called as for any other ConcreteSyncer.
+type HealthAwareSyncer interface {
+	ConcreteSyncer
+
+	SyncClusterWithHealth(pc *fields.PodCluster, endpoints []Endpoint) error
+}
+
 // WatchAndSync registers a ConcreteSyncer which will have its
 // functions invoked on certain pod cluster changes. See the
 // ConcreteSyncer interface for details on how to use this function
@@ -660,9 +712,59 @@ func (s *ConsulStore) handlePCUpdates(concrete ConcreteSyncer, changes chan podC
 		close(podWatchQuit)
 	}()
 
+	// health is only watched if both the store and the syncer support it.
+	// healthWatch stays nil otherwise, so it is never selected
+	healthSyncer, syncHealth := concrete.(HealthAwareSyncer)
+	syncHealth = syncHealth && s.healthChecker != nil
+	var healthWatch <-chan map[types.NodeName]health.Result
+	var healthResults map[types.NodeName]health.Result
+	var prevEndpoints []Endpoint
+	cancelHealthWatch := func() {}
+	defer func() {
+		cancelHealthWatch()
+	}()
+
+	startHealthWatch := func(podID types.PodID) {
+		cancelHealthWatch()
+		ctx, cancel := context.WithCancel(context.Background())
+		cancelHealthWatch = cancel
+
+		resultCh := make(chan map[types.NodeName]health.Result)
+		errCh := make(chan error)
+		go s.healthChecker.WatchService(ctx, podID.String(), resultCh, errCh, s.healthWatchDelay)
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case err := <-errCh:
+					s.logger.WithError(err).Errorf("Error watching the health of %s", podID)
+				}
+			}
+		}()
+		healthWatch = resultCh
+		healthResults = nil
+	}
+
+	sync := func(labeledPods []labels.Labeled) error {
+		if !syncHealth {
+			s.logger.Debugf("Calling SyncCluster with %v / %v", change.current, labeledPods)
+			return concrete.SyncCluster(change.current, labeledPods)
+		}
+
+		endpoints := joinHealth(labeledPods, healthResults)
+		s.logger.Debugf("Calling SyncClusterWithHealth with %v / %v", change.current, endpoints)
+		err := healthSyncer.SyncClusterWithHealth(change.current, endpoints)
+		if err == nil {
+			prevEndpoints = endpoints
+		}
+		return err
+	}
+
 	var ok bool
 	var pcChangePending bool = false
 	var prevLabeledPods []labels.Labeled
+	var labelsSynced bool
 	var startTime time.Time
 
 	for {
@@ -677,19 +779,44 @@ func (s *ConsulStore) handlePCUpdates(concrete ConcreteSyncer, changes chan podC
 			}
 
 			if pcChangePending || !labeledEqual(labeledPods, prevLabeledPods) {
-				s.logger.Debugf("Calling SyncCluster with %v / %v", change.current, labeledPods)
-				err := concrete.SyncCluster(change.current, labeledPods)
+				err := sync(labeledPods)
 				if err != nil {
 					s.logger.WithError(err).Errorf("Failed to SyncCluster on %v / %v", change.current, labeledPods)
 				} else {
 					pcChangePending = false
 					prevLabeledPods = labeledPods
+					labelsSynced = true
 				}
 
 				timeElapsed := time.Now().Sub(startTime)
 				histogram.Update(timeElapsed.Nanoseconds())
 				s.logger.Debugf("Sync cluster took %s", timeElapsed.String())
 			}
+		case results, ok := <-healthWatch:
+			if !ok {
+				healthWatch = nil
+				continue
+			}
+			healthResults = results
+
+			// pods are synced with the latest health the next time
+			// their labels are synced
+			if !labelsSynced || pcChangePending {
+				continue
+			}
+			if endpointsEqual(joinHealth(prevLabeledPods, healthResults), prevEndpoints) {
+				continue
+			}
+
+			startTime = time.Now()
+			err := sync(prevLabeledPods)
+			if err != nil {
+				s.logger.WithError(err).Errorf("Failed to SyncCluster on %v after a health change", change.current)
+			}
+
+			timeElapsed := time.Now().Sub(startTime)
+			histogram.Update(timeElapsed.Nanoseconds())
+			s.logger.Debugf("Sync cluster took %s", timeElapsed.String())
 		case change, ok = <-changes:
 			pcChangePending = true
 
@@ -714,6 +841,9 @@ func (s *ConsulStore) handlePCUpdates(concrete ConcreteSyncer, changes chan podC
 				} else {
 					watching = true
 				}
+				if syncHealth {
+					startHealthWatch(change.current.PodID)
+				}
 			} else if change.current == nil && change.previous != nil {
 				// if no current cluster exists, but there is a previous cluster,
 				// it means we need to destroy this concrete cluster
@@ -740,11 +870,49 @@ func (s *ConsulStore) handlePCUpdates(concrete ConcreteSyncer, changes chan podC
 						s.logger.WithError(err).Errorf("Unable to alter pod selector watch for %v", change.current.ID)
 					}
 				}
+				if syncHealth && change.current.PodID != change.previous.PodID {
+					startHealthWatch(change.current.PodID)
+				}
 			}
 		}
 	}
 }
 
+// joinHealth pairs each labeled pod with the health of the node it is on
+func joinHealth(labeledPods []labels.Labeled, healthResults map[types.NodeName]health.Result) []Endpoint {
+	endpoints := make([]Endpoint, 0, len(labeledPods))
+	for _, labeled := range labeledPods {
+		endpoint := Endpoint{
+			Labeled: labeled,
+		}
+		node, _, err := labels.NodeAndPodIDFromPodLabel(labeled)
+		if err == nil {
+			endpoint.Node = node
+			if result, ok := healthResults[node]; ok {
+				endpoint.Health = result.Status
+			}
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+	return endpoints
+}
+
+func endpointsEqual(left, right []Endpoint) bool {
+	if len(left) != len(right) {
+		return false
+	}
+	leftHealth := make(map[string]health.HealthState, len(left))
+	for _, l := range left {
+		leftHealth[l.ID] = l.Health
+	}
+	for _, r := range right {
+		if state, ok := leftHealth[r.ID]; !ok || state != r.Health {
+			return false
+		}
+	}
+	return true
+}
+
 func labeledEqual(left, right []labels.Labeled) bool {
 	leftSet, rightSet := sets.NewString(), sets.NewString()
 	for _, l := range left {
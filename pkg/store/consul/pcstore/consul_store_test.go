@@ -1,11 +1,13 @@
 package pcstore
 
 import (
+	"context"
 	"fmt"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/square/p2/pkg/health"
 	"github.com/square/p2/pkg/labels"
 	"github.com/square/p2/pkg/logging"
 	"github.com/square/p2/pkg/pc/fields"
@@ -713,6 +715,155 @@ func TestConcreteSyncer(t *testing.T) {
 	close(changes)
 }
 
+type healthSync struct {
+	cluster   *fields.PodCluster
+	endpoints []Endpoint
+}
+
+type fakeHealthSyncer struct {
+	fakeSyncer
+	syncedWithHealth chan healthSync
+}
+
+func (f *fakeHealthSyncer) SyncClusterWithHealth(cluster *fields.PodCluster, endpoints []Endpoint) error {
+	f.syncedWithHealth <- healthSync{
+		cluster:   cluster,
+		endpoints: endpoints,
+	}
+	return nil
+}
+
+type fakeHealthWatcher struct {
+	results chan map[types.NodeName]health.Result
+}
+
+func (f fakeHealthWatcher) WatchService(
+	ctx context.Context,
+	serviceID string,
+	resultCh chan<- map[types.NodeName]health.Result,
+	errCh chan<- error,
+	watchDelay time.Duration,
+) {
+	defer close(resultCh)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case results := <-f.results:
+			select {
+			case <-ctx.Done():
+				return
+			case resultCh <- results:
+			}
+		}
+	}
+}
+
+func TestHealthAwareSyncer(t *testing.T) {
+	store := ConsulStoreWithFakeKV()
+	healthWatcher := fakeHealthWatcher{
+		results: make(chan map[types.NodeName]health.Result),
+	}
+	store.SetHealthChecker(healthWatcher, 0)
+
+	store.labeler.SetLabel(labels.POD, "node1/vvv", "color", "red")
+	store.labeler.SetLabel(labels.POD, "node2/vvv", "color", "red")
+
+	syncer := &fakeHealthSyncer{
+		fakeSyncer: fakeSyncer{
+			synced:  make(chan fakeSync),
+			deleted: make(chan fakeSync),
+		},
+		syncedWithHealth: make(chan healthSync),
+	}
+
+	changes := make(chan podClusterChange)
+	go store.handlePCUpdates(syncer, changes, metrics.NewHistogram(metrics.NewExpDecaySample(1028, 0.015)))
+	defer close(changes)
+
+	change := podClusterChange{
+		current: &fields.PodCluster{
+			ID:               fields.ID("abc123"),
+			PodID:            types.PodID("vvv"),
+			AvailabilityZone: fields.AvailabilityZone("west"),
+			Name:             "production",
+			PodSelector:      klabels.Everything().Add("color", klabels.EqualsOperator, []string{"red"}),
+		},
+	}
+	select {
+	case changes <- change:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Test timed out trying to write change to handlePCChange")
+	}
+
+	// waitForHealth returns once the syncer has been passed the expected
+	// health for each node
+	waitForHealth := func(expected map[types.NodeName]health.HealthState) {
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case <-timeout:
+				t.Fatalf("Test timed out waiting for the syncer to be passed health %v", expected)
+			case <-syncer.synced:
+				t.Fatal("expected SyncClusterWithHealth to be called instead of SyncCluster")
+			case sync := <-syncer.syncedWithHealth:
+				if sync.cluster.ID != change.current.ID {
+					t.Fatalf("got unexpected synced cluster %v", sync.cluster.ID)
+				}
+				actual := make(map[types.NodeName]health.HealthState)
+				for _, endpoint := range sync.endpoints {
+					actual[endpoint.Node] = endpoint.Health
+				}
+				if len(actual) != len(expected) {
+					continue
+				}
+				matched := true
+				for node, state := range expected {
+					if actual[node] != state {
+						matched = false
+					}
+				}
+				if matched {
+					return
+				}
+			}
+		}
+	}
+
+	// the pods are synced before any health is known
+	waitForHealth(map[types.NodeName]health.HealthState{
+		"node1": "",
+		"node2": "",
+	})
+
+	sendHealth := func(results map[types.NodeName]health.Result) {
+		select {
+		case healthWatcher.results <- results:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Test timed out trying to send health results")
+		}
+	}
+
+	// a health change alone causes another sync
+	sendHealth(map[types.NodeName]health.Result{
+		"node1": {ID: "vvv", Node: "node1", Status: health.Passing},
+		"node2": {ID: "vvv", Node: "node2", Status: health.Critical},
+	})
+	waitForHealth(map[types.NodeName]health.HealthState{
+		"node1": health.Passing,
+		"node2": health.Critical,
+	})
+
+	sendHealth(map[types.NodeName]health.Result{
+		"node1": {ID: "vvv", Node: "node1", Status: health.Passing},
+		"node2": {ID: "vvv", Node: "node2", Status: health.Passing},
+	})
+	waitForHealth(map[types.NodeName]health.HealthState{
+		"node1": health.Passing,
+		"node2": health.Passing,
+	})
+}
+
 func TestConcreteSyncerWithPrevious(t *testing.T) {
 	store := ConsulStoreWithFakeKV()
 	store.logger.Logger.Level = logrus.DebugLevel
@@ -216,6 +216,39 @@ func (c *consulStore) Unschedule(podKey types.PodUniqueKey) error {
 	return nil
 }
 
+// UnscheduleTxn adds operations to the transaction in ctx that delete the pod
+// and its secondary index in /intent, so that the pod can be unscheduled along
+// with other changes such as a disruption budget reservation.
+func (c *consulStore) UnscheduleTxn(ctx context.Context, podKey types.PodUniqueKey) error {
+	if podKey == "" {
+		return util.Errorf("Pod store can only delete pods with uuid keys")
+	}
+
+	// Read the pod so we know which node the secondary index will have
+	pod, err := c.ReadPod(podKey)
+	if err != nil {
+		return err
+	}
+
+	err = transaction.Add(ctx, api.KVTxnOp{
+		Verb: string(api.KVDelete),
+		Key:  computePodPath(podKey),
+	})
+	if err != nil {
+		return err
+	}
+	err = transaction.Add(ctx, api.KVTxnOp{
+		Verb: string(api.KVDelete),
+		Key:  computeIntentIndexPath(podKey, pod.Node),
+	})
+	if err != nil {
+		return err
+	}
+
+	c.deleteFromCache(podKey)
+	return nil
+}
+
 // Writes a key to the /reality tree to signify that the pod specified by the UUID has been
 // launched on the given node.
 func (c *consulStore) WriteRealityIndex(ctx context.Context, podKey types.PodUniqueKey, node types.NodeName) error {
@@ -108,6 +108,52 @@ func TestUnschedule(t *testing.T) {
 	}
 }
 
+func TestUnscheduleTxn(t *testing.T) {
+	node := types.NodeName("some_node")
+
+	fixture := consulutil.NewFixture(t)
+	defer fixture.Stop()
+	kv := fixture.Client.KV()
+	store := NewConsul(kv)
+
+	key, err := store.Schedule(testManifest(), node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	podPath := fmt.Sprintf("pods/%s", key)
+	indexPath := fmt.Sprintf("intent/%s/%s", node, key)
+
+	ctx, cancelFunc := transaction.New(context.Background())
+	defer cancelFunc()
+	err = store.UnscheduleTxn(ctx, key)
+	if err != nil {
+		t.Fatalf("Unexpected error deleting pod: %s", err)
+	}
+
+	pair, _, err := kv.Get(podPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pair == nil {
+		t.Fatalf("Key '%s' was deleted before the transaction was committed", podPath)
+	}
+
+	err = transaction.MustCommit(ctx, kv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{podPath, indexPath} {
+		pair, _, err := kv.Get(path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pair != nil {
+			t.Fatalf("Key '%s' was not deleted", path)
+		}
+	}
+}
+
 func TestReadPod(t *testing.T) {
 	node := types.NodeName("some_node")
 	key := types.NewPodUUID()
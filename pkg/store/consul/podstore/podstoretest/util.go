@@ -1,6 +1,8 @@
 package podstoretest
 
 import (
+	"context"
+
 	"github.com/square/p2/pkg/manifest"
 	"github.com/square/p2/pkg/types"
 	"github.com/square/p2/pkg/util"
@@ -24,3 +26,7 @@ func (FailingPodStore) Schedule(manifest.Manifest, types.NodeName) (types.PodUni
 func (FailingPodStore) Unschedule(types.PodUniqueKey) error {
 	return util.Errorf("failing pod store failed to unschedule pod")
 }
+
+func (FailingPodStore) UnscheduleTxn(context.Context, types.PodUniqueKey) error {
+	return util.Errorf("failing pod store failed to unschedule pod")
+}
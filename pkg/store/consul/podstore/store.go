@@ -27,6 +27,7 @@ type Store interface {
 	ReadPodFromIndex(index PodIndex) (Pod, error)
 	Schedule(manifest manifest.Manifest, node types.NodeName) (types.PodUniqueKey, error)
 	Unschedule(key types.PodUniqueKey) error
+	UnscheduleTxn(ctx context.Context, key types.PodUniqueKey) error
 
 	DeleteRealityIndex(podKey types.PodUniqueKey, node types.NodeName) error
 	WriteRealityIndex(ctx context.Context, podKey types.PodUniqueKey, node types.NodeName) error
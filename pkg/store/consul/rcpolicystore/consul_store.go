@@ -0,0 +1,313 @@
+// Package rcpolicystore stores a JSON policy for each replication controller
+// in consul, for policies that are carried out by a policyfarm such as
+// autoscaler policies and replica schedules. The stores of those policies
+// wrap a Store with their policy type.
+package rcpolicystore
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"reflect"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+
+	rc_fields "github.com/square/p2/pkg/rc/fields"
+	"github.com/square/p2/pkg/store/consul"
+	"github.com/square/p2/pkg/store/consul/consulutil"
+	"github.com/square/p2/pkg/store/consul/transaction"
+	"github.com/square/p2/pkg/util"
+)
+
+const (
+	AutoscalerTree      = "autoscalers"
+	ReplicaScheduleTree = "replica_schedules"
+)
+
+// replicaCountTrees are the trees of the policies that set the desired replica
+// count of their RC, mapped to the kind of policy stored in them. An RC may
+// have only one of these policies, because their farms would keep overwriting
+// each other's replica counts.
+var replicaCountTrees = map[string]string{
+	AutoscalerTree:      "autoscaler policy",
+	ReplicaScheduleTree: "replica schedule",
+}
+
+// Policy is implemented by pointers to the policies kept in a Store.
+type Policy interface {
+	// RC returns the ID of the RC the policy belongs to
+	RC() rc_fields.ID
+
+	Validate() error
+
+	// Progress returns the time maintained by the farm carrying out the
+	// policy, e.g. when an autoscaler last scaled its RC. It is kept when
+	// the policy is replaced.
+	Progress() time.Time
+	SetProgress(time.Time)
+}
+
+type KV interface {
+	Get(key string, q *api.QueryOptions) (*api.KVPair, *api.QueryMeta, error)
+	List(prefix string, q *api.QueryOptions) (api.KVPairs, *api.QueryMeta, error)
+	CAS(p *api.KVPair, q *api.WriteOptions) (bool, *api.WriteMeta, error)
+	Delete(key string, w *api.WriteOptions) (*api.WriteMeta, error)
+}
+
+var _ KV = &api.KV{}
+
+type Store struct {
+	kv KV
+
+	// tree is the consul prefix the policies are stored under
+	tree string
+	// kind names the policies in error messages, e.g. "autoscaler policy"
+	kind string
+	// noPolicy is returned by Get for RCs without a policy
+	noPolicy error
+	// newPolicy returns an empty policy to unmarshal into
+	newPolicy func() Policy
+	// exclusive maps the trees of policies an RC can't have alongside a
+	// policy in this store to the kind of policy stored in them
+	exclusive map[string]string
+}
+
+func New(kv KV, tree string, kind string, noPolicy error, newPolicy func() Policy) *Store {
+	exclusive := make(map[string]string)
+	if _, ok := replicaCountTrees[tree]; ok {
+		for otherTree, otherKind := range replicaCountTrees {
+			if otherTree != tree {
+				exclusive[otherTree] = otherKind
+			}
+		}
+	}
+
+	return &Store{
+		kv:        kv,
+		tree:      tree,
+		kind:      kind,
+		noPolicy:  noPolicy,
+		newPolicy: newPolicy,
+		exclusive: exclusive,
+	}
+}
+
+func (s *Store) policyPath(rcID rc_fields.ID) (string, error) {
+	if rcID == "" {
+		return "", util.Errorf("path requested for empty RC id")
+	}
+	return path.Join(s.tree, rcID.String()), nil
+}
+
+// Set creates or replaces the policy for an RC. The progress of the policy it
+// replaces is kept, so replacing a policy doesn't undo what its farm has done.
+// Set fails if the RC has a policy that can't be combined with this one. That
+// check is not part of the write, so policies set concurrently are not caught.
+func (s *Store) Set(policy Policy) error {
+	err := policy.Validate()
+	if err != nil {
+		return err
+	}
+
+	err = s.checkExclusive(policy.RC())
+	if err != nil {
+		return err
+	}
+
+	existing, index, err := s.getWithIndex(policy.RC())
+	switch {
+	case err == s.noPolicy:
+	case err != nil:
+		return err
+	default:
+		policy.SetProgress(existing.Progress())
+	}
+
+	key, err := s.policyPath(policy.RC())
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(policy)
+	if err != nil {
+		return util.Errorf("could not marshal %s as json: %s", s.kind, err)
+	}
+
+	success, _, err := s.kv.CAS(&api.KVPair{
+		Key:         key,
+		Value:       b,
+		ModifyIndex: index,
+	}, nil)
+	if err != nil {
+		return consulutil.NewKVError("cas", key, err)
+	}
+	if !success {
+		return util.Errorf("%s for %s was modified concurrently", s.kind, policy.RC())
+	}
+	return nil
+}
+
+// checkExclusive returns an error if the RC has a policy in one of the trees
+// that are exclusive with this store's
+func (s *Store) checkExclusive(rcID rc_fields.ID) error {
+	for tree, kind := range s.exclusive {
+		key := path.Join(tree, rcID.String())
+		kvp, _, err := s.kv.Get(key, nil)
+		if err != nil {
+			return consulutil.NewKVError("get", key, err)
+		}
+		if kvp != nil {
+			return util.Errorf("%s already has a %s; delete it before setting a %s", rcID, kind, s.kind)
+		}
+	}
+	return nil
+}
+
+// Get returns the policy for an RC, or the store's no policy error if it has
+// none.
+func (s *Store) Get(rcID rc_fields.ID) (Policy, error) {
+	policy, _, err := s.getWithIndex(rcID)
+	return policy, err
+}
+
+func (s *Store) getWithIndex(rcID rc_fields.ID) (Policy, uint64, error) {
+	key, err := s.policyPath(rcID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	kvp, _, err := s.kv.Get(key, nil)
+	if err != nil {
+		return nil, 0, consulutil.NewKVError("get", key, err)
+	}
+	if kvp == nil {
+		return nil, 0, s.noPolicy
+	}
+
+	policy, err := s.kvpToPolicy(kvp)
+	if err != nil {
+		return nil, 0, err
+	}
+	return policy, kvp.ModifyIndex, nil
+}
+
+func (s *Store) List() ([]Policy, error) {
+	listed, _, err := s.kv.List(s.tree+"/", nil)
+	if err != nil {
+		return nil, consulutil.NewKVError("list", s.tree+"/", err)
+	}
+	return s.kvpsToPolicies(listed)
+}
+
+func (s *Store) Delete(rcID rc_fields.ID) error {
+	key, err := s.policyPath(rcID)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.kv.Delete(key, nil)
+	if err != nil {
+		return consulutil.NewKVError("delete", key, err)
+	}
+	return nil
+}
+
+// SetProgressTxn adds an operation to the transaction in ctx that records the
+// progress of the policy's farm. The operation fails if the policy has been
+// changed since it was read, so that a decision made using a stale policy is
+// not committed.
+func (s *Store) SetProgressTxn(ctx context.Context, policy Policy, progress time.Time) error {
+	current, index, err := s.getWithIndex(policy.RC())
+	if err != nil {
+		return err
+	}
+	current.SetProgress(policy.Progress())
+	if !reflect.DeepEqual(current, policy) {
+		return util.Errorf("%s for %s changed since it was read", s.kind, policy.RC())
+	}
+
+	current.SetProgress(progress)
+	key, err := s.policyPath(current.RC())
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(current)
+	if err != nil {
+		return util.Errorf("could not marshal %s as json: %s", s.kind, err)
+	}
+
+	return transaction.Add(ctx, api.KVTxnOp{
+		Verb:  api.KVCAS,
+		Key:   key,
+		Value: b,
+		Index: index,
+	})
+}
+
+// WatchRCs publishes the IDs of the RCs that have a policy each time a policy
+// changes. This function does not block.
+func (s *Store) WatchRCs(quit <-chan struct{}, pauseTime time.Duration) (<-chan []rc_fields.ID, <-chan error) {
+	inCh := make(chan api.KVPairs)
+	outCh := make(chan []rc_fields.ID)
+	errCh := make(chan error, 1)
+
+	go consulutil.WatchPrefix(s.tree+"/", s.kv, inCh, quit, errCh, pauseTime, 1*time.Minute)
+
+	go func() {
+		defer close(outCh)
+		for listed := range inCh {
+			policies, err := s.kvpsToPolicies(listed)
+			if err != nil {
+				select {
+				case errCh <- err:
+				case <-quit:
+					return
+				}
+				continue
+			}
+
+			rcIDs := make([]rc_fields.ID, 0, len(policies))
+			for _, policy := range policies {
+				rcIDs = append(rcIDs, policy.RC())
+			}
+			select {
+			case outCh <- rcIDs:
+			case <-quit:
+				return
+			}
+		}
+	}()
+
+	return outCh, errCh
+}
+
+// LockForOwnership acquires a lock on the policy that is held by the farm that
+// is carrying it out.
+func (s *Store) LockForOwnership(rcID rc_fields.ID, session consul.Session) (consul.Unlocker, error) {
+	policyPath, err := s.policyPath(rcID)
+	if err != nil {
+		return nil, err
+	}
+	return session.Lock(path.Join(consul.LOCK_TREE, policyPath))
+}
+
+func (s *Store) kvpToPolicy(kvp *api.KVPair) (Policy, error) {
+	policy := s.newPolicy()
+	err := json.Unmarshal(kvp.Value, policy)
+	if err != nil {
+		return nil, util.Errorf("could not unmarshal %s at %s: %s", s.kind, kvp.Key, err)
+	}
+	return policy, nil
+}
+
+func (s *Store) kvpsToPolicies(l api.KVPairs) ([]Policy, error) {
+	ret := make([]Policy, 0, len(l))
+	for _, kvp := range l {
+		policy, err := s.kvpToPolicy(kvp)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, policy)
+	}
+	return ret, nil
+}
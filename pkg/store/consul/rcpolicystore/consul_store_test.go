@@ -0,0 +1,157 @@
+package rcpolicystore
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	rc_fields "github.com/square/p2/pkg/rc/fields"
+	"github.com/square/p2/pkg/store/consul/consulutil"
+	"github.com/square/p2/pkg/store/consul/transaction"
+	"github.com/square/p2/pkg/util"
+)
+
+var noTestPolicy = errors.New("No test policy found")
+
+type testPolicy struct {
+	RCID     rc_fields.ID `json:"rc_id"`
+	Value    []int        `json:"value"`
+	Modified time.Time    `json:"modified"`
+}
+
+func (p testPolicy) RC() rc_fields.ID { return p.RCID }
+
+func (p testPolicy) Progress() time.Time { return p.Modified }
+
+func (p *testPolicy) SetProgress(modified time.Time) { p.Modified = modified }
+
+func (p testPolicy) Validate() error {
+	if len(p.Value) == 0 {
+		return util.Errorf("test policy must have a value")
+	}
+	return nil
+}
+
+func newTestStore(t *testing.T) (*Store, consulutil.Fixture) {
+	fixture := consulutil.NewFixture(t)
+	store := New(fixture.Client.KV(), "test_policies", "test policy", noTestPolicy, func() Policy {
+		return &testPolicy{}
+	})
+	return store, fixture
+}
+
+func TestSetAndGet(t *testing.T) {
+	store, fixture := newTestStore(t)
+	defer fixture.Stop()
+
+	_, err := store.Get("some_rc")
+	if err != noTestPolicy {
+		t.Fatalf("expected the store's no policy error before the policy was set but got %v", err)
+	}
+
+	err = store.Set(&testPolicy{RCID: "some_rc"})
+	if err == nil {
+		t.Fatal("expected an error setting an invalid policy")
+	}
+
+	err = store.Set(&testPolicy{RCID: "some_rc", Value: []int{1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy, err := store.Get("some_rc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value := policy.(*testPolicy).Value; len(value) != 1 || value[0] != 1 {
+		t.Errorf("expected to get the policy that was set but got %+v", policy)
+	}
+
+	policies, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(policies) != 1 {
+		t.Errorf("expected to list 1 policy but got %d", len(policies))
+	}
+
+	err = store.Delete("some_rc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.Get("some_rc")
+	if err != noTestPolicy {
+		t.Fatalf("expected the store's no policy error after the policy was deleted but got %v", err)
+	}
+}
+
+func TestSetProgressTxn(t *testing.T) {
+	store, fixture := newTestStore(t)
+	defer fixture.Stop()
+
+	err := store.Set(&testPolicy{RCID: "some_rc", Value: []int{1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy, err := store.Get("some_rc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	progress := time.Now()
+	ctx, cancel := transaction.New(context.Background())
+	defer cancel()
+	err = store.SetProgressTxn(ctx, policy, progress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = transaction.MustCommit(ctx, fixture.Client.KV())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// replacing the policy should keep its progress
+	err = store.Set(&testPolicy{RCID: "some_rc", Value: []int{1, 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated, err := store.Get("some_rc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !updated.Progress().Equal(progress) || len(updated.(*testPolicy).Value) != 2 {
+		t.Errorf("expected the replaced policy to have the new value and progress %s but got %+v", progress, updated)
+	}
+
+	// the policy that was read before the replacement is stale
+	ctx, cancel = transaction.New(context.Background())
+	defer cancel()
+	err = store.SetProgressTxn(ctx, policy, time.Now())
+	if err == nil {
+		t.Fatal("expected an error recording progress made with a stale policy")
+	}
+}
+
+func TestWatchRCs(t *testing.T) {
+	store, fixture := newTestStore(t)
+	defer fixture.Stop()
+
+	err := store.Set(&testPolicy{RCID: "some_rc", Value: []int{1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	quit := make(chan struct{})
+	defer close(quit)
+	rcIDs, errCh := store.WatchRCs(quit, 0)
+	select {
+	case ids := <-rcIDs:
+		if len(ids) != 1 || ids[0] != "some_rc" {
+			t.Errorf("expected to watch the RC with a policy but got %s", ids)
+		}
+	case err := <-errCh:
+		t.Fatal(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the policy watch")
+	}
+}
@@ -121,6 +121,29 @@ func (s *ConsulStore) Create(
 	additionalLabels klabels.Set,
 	allocationStrategy fields.Strategy,
 ) (fields.RC, error) {
+	return s.CreateWithConstraints(manifest, nodeSelector, availabilityZone, clusterName, podLabels, additionalLabels, allocationStrategy, nil, nil)
+}
+
+// CreateWithConstraints is like Create, but also sets the RC's spread
+// constraint and anti-affinity selector if they are not nil. They are written
+// along with the RC, so the RC never exists without them.
+func (s *ConsulStore) CreateWithConstraints(
+	manifest manifest.Manifest,
+	nodeSelector klabels.Selector,
+	availabilityZone pc_fields.AvailabilityZone,
+	clusterName pc_fields.ClusterName,
+	podLabels klabels.Set,
+	additionalLabels klabels.Set,
+	allocationStrategy fields.Strategy,
+	spreadConstraint *fields.SpreadConstraint,
+	antiAffinity klabels.Selector,
+) (fields.RC, error) {
+	if spreadConstraint != nil {
+		err := spreadConstraint.Validate()
+		if err != nil {
+			return fields.RC{}, err
+		}
+	}
 
 	if podLabels == nil {
 		podLabels = make(klabels.Set)
@@ -128,12 +151,22 @@ func (s *ConsulStore) Create(
 	podLabels[types.ClusterNameLabel] = clusterName.String()
 	podLabels[types.AvailabilityZoneLabel] = availabilityZone.String()
 
-	rc, err := s.innerCreate(manifest, nodeSelector, podLabels, allocationStrategy)
+	newRC := fields.RC{
+		Manifest:           manifest,
+		NodeSelector:       nodeSelector,
+		PodLabels:          podLabels,
+		ReplicasDesired:    0,
+		Disabled:           false,
+		AllocationStrategy: allocationStrategy,
+		SpreadConstraint:   spreadConstraint,
+		AntiAffinity:       antiAffinity,
+	}
+	rc, err := s.innerCreate(newRC)
 
 	// TODO: measure whether retries are is important in practice
 	for i := 0; i < s.retries; i++ {
 		if _, ok := err.(CASError); ok {
-			rc, err = s.innerCreate(manifest, nodeSelector, podLabels, allocationStrategy)
+			rc, err = s.innerCreate(newRC)
 		} else {
 			break
 		}
@@ -153,6 +186,8 @@ func (s *ConsulStore) Create(
 }
 
 // TODO: replace Create() with this
+// The spread constraint and anti-affinity selector are written along with the
+// RC if they are not nil.
 func (s *ConsulStore) CreateTxn(
 	ctx context.Context,
 	manifest manifest.Manifest,
@@ -162,8 +197,17 @@ func (s *ConsulStore) CreateTxn(
 	podLabels klabels.Set,
 	additionalLabels klabels.Set,
 	allocationStrategy fields.Strategy,
+	spreadConstraint *fields.SpreadConstraint,
+	antiAffinity klabels.Selector,
 ) (fields.RC, error) {
-	rc, err := s.innerCreateTxn(ctx, manifest, nodeSelector, podLabels, allocationStrategy)
+	if spreadConstraint != nil {
+		err := spreadConstraint.Validate()
+		if err != nil {
+			return fields.RC{}, err
+		}
+	}
+
+	rc, err := s.innerCreateTxn(ctx, manifest, nodeSelector, podLabels, allocationStrategy, spreadConstraint, antiAffinity)
 	if err != nil {
 		return fields.RC{}, err
 	}
@@ -178,24 +222,14 @@ func (s *ConsulStore) CreateTxn(
 	return rc, nil
 }
 
-// these parts of Create may require a retry
-func (s *ConsulStore) innerCreate(manifest manifest.Manifest, nodeSelector klabels.Selector, podLabels klabels.Set, allocationStrategy fields.Strategy) (fields.RC, error) {
-	id := fields.ID(uuid.Must(uuid.NewV4()).String())
-	rcp, err := s.rcPath(id)
+// these parts of Create may require a retry. The RC is written with a new ID
+func (s *ConsulStore) innerCreate(rc fields.RC) (fields.RC, error) {
+	rc.ID = fields.ID(uuid.Must(uuid.NewV4()).String())
+	rcp, err := s.rcPath(rc.ID)
 	if err != nil {
 		return fields.RC{}, err
 	}
 
-	rc := fields.RC{
-		ID:                 id,
-		Manifest:           manifest,
-		NodeSelector:       nodeSelector,
-		PodLabels:          podLabels,
-		ReplicasDesired:    0,
-		Disabled:           false,
-		AllocationStrategy: allocationStrategy,
-	}
-
 	jsonRC, err := json.Marshal(rc)
 	if err != nil {
 		return fields.RC{}, util.Errorf("Could not marshal RC as json: %s", err)
@@ -219,7 +253,7 @@ func (s *ConsulStore) innerCreate(manifest manifest.Manifest, nodeSelector klabe
 }
 
 // TODO: replace innerCreate() with this function
-func (s *ConsulStore) innerCreateTxn(ctx context.Context, manifest manifest.Manifest, nodeSelector klabels.Selector, podLabels klabels.Set, allocationStrategy fields.Strategy) (fields.RC, error) {
+func (s *ConsulStore) innerCreateTxn(ctx context.Context, manifest manifest.Manifest, nodeSelector klabels.Selector, podLabels klabels.Set, allocationStrategy fields.Strategy, spreadConstraint *fields.SpreadConstraint, antiAffinity klabels.Selector) (fields.RC, error) {
 	id := fields.ID(uuid.Must(uuid.NewV4()).String())
 	rcp, err := s.rcPath(id)
 	if err != nil {
@@ -234,6 +268,8 @@ func (s *ConsulStore) innerCreateTxn(ctx context.Context, manifest manifest.Mani
 		ReplicasDesired:    0,
 		Disabled:           false,
 		AllocationStrategy: allocationStrategy,
+		SpreadConstraint:   spreadConstraint,
+		AntiAffinity:       antiAffinity,
 	}
 
 	jsonRC, err := json.Marshal(rc)
@@ -637,6 +673,15 @@ func (s *ConsulStore) SetDesiredReplicas(id fields.ID, n int) error {
 	})
 }
 
+// SetDesiredReplicasTxn adds the KV operations required to update the
+// replica count for the RC with the given ID to ctx.
+func (s *ConsulStore) SetDesiredReplicasTxn(ctx context.Context, id fields.ID, n int) error {
+	return s.mutateRCTxn(ctx, id, func(rc fields.RC) (fields.RC, error) {
+		rc.ReplicasDesired = n
+		return rc, nil
+	})
+}
+
 // AddDesiredReplicas increments the replica count for the specified RC
 // by n.
 func (s *ConsulStore) AddDesiredReplicas(id fields.ID, n int) error {
@@ -84,6 +84,8 @@ func (s *fakeStore) CreateTxn(
 	podLabels labels.Set,
 	additionalLabels labels.Set,
 	allocationStrategy fields.Strategy,
+	spreadConstraint *fields.SpreadConstraint,
+	antiAffinity labels.Selector,
 ) (fields.RC, error) {
 	panic("transactions not implemented in fake rc store")
 }
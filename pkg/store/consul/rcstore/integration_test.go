@@ -32,7 +32,7 @@ func TestCreateTxn(t *testing.T) {
 
 	ctx, cancelFunc := transaction.New(context.Background())
 	defer cancelFunc()
-	rc, err := store.CreateTxn(ctx, testManifest(), klabels.Everything(), "some_az", "some_cn", nil, rcLabelsToSet, "some_strategy")
+	rc, err := store.CreateTxn(ctx, testManifest(), klabels.Everything(), "some_az", "some_cn", nil, rcLabelsToSet, "some_strategy", nil, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -86,6 +86,37 @@ func TestCreateTxn(t *testing.T) {
 	}
 }
 
+func TestCreateWithConstraints(t *testing.T) {
+	fixture := consulutil.NewFixture(t)
+	defer fixture.Stop()
+
+	applicator := labels.NewConsulApplicator(fixture.Client, 0, 0)
+	store := NewConsul(fixture.Client, applicator, 0)
+
+	antiAffinity := klabels.Everything().Add("app", klabels.EqualsOperator, []string{"web"})
+	spreadConstraint := &rcfields.SpreadConstraint{Key: "rack", MaxSkew: 1}
+	rc, err := store.CreateWithConstraints(testManifest(), klabels.Everything(), "some_az", "some_cn", nil, nil, rcfields.DynamicStrategy, spreadConstraint, antiAffinity)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := store.Get(rc.ID)
+	if err != nil {
+		t.Fatalf("unexpected error fetching created RC: %s", err)
+	}
+	if stored.SpreadConstraint == nil || *stored.SpreadConstraint != *spreadConstraint {
+		t.Errorf("expected the RC to be created with spread constraint %v but it had %v", spreadConstraint, stored.SpreadConstraint)
+	}
+	if stored.AntiAffinity == nil || stored.AntiAffinity.String() != antiAffinity.String() {
+		t.Errorf("expected the RC to be created with anti-affinity %s but it had %v", antiAffinity, stored.AntiAffinity)
+	}
+
+	_, err = store.CreateWithConstraints(testManifest(), klabels.Everything(), "some_az", "some_cn", nil, nil, rcfields.DynamicStrategy, &rcfields.SpreadConstraint{Key: "rack"}, nil)
+	if err == nil {
+		t.Error("expected an invalid spread constraint to be rejected")
+	}
+}
+
 func TestDeleteTxnHappy(t *testing.T) {
 	fixture := consulutil.NewFixture(t)
 	defer fixture.Stop()
@@ -0,0 +1,67 @@
+// Package replicaschedulestore stores the scheduled replica changes of
+// replication controllers in consul.
+package replicaschedulestore
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	rc_fields "github.com/square/p2/pkg/rc/fields"
+	"github.com/square/p2/pkg/replicaschedule/fields"
+	"github.com/square/p2/pkg/store/consul/consulutil"
+	"github.com/square/p2/pkg/store/consul/rcpolicystore"
+)
+
+var NoSchedule error = errors.New("No replica schedule found")
+
+// ConsulStore is an rcpolicystore.Store of replica schedules. Its Delete,
+// WatchRCs and LockForOwnership methods are those of the underlying store.
+type ConsulStore struct {
+	*rcpolicystore.Store
+}
+
+func NewConsul(client consulutil.ConsulClient) *ConsulStore {
+	return &ConsulStore{
+		Store: rcpolicystore.New(client.KV(), rcpolicystore.ReplicaScheduleTree, "replica schedule", NoSchedule, func() rcpolicystore.Policy {
+			return &fields.Schedule{}
+		}),
+	}
+}
+
+// Set creates or replaces the replica schedule for an RC. The time up to
+// which the schedule has been applied is preserved, so replacing a schedule
+// does not re-apply entries that have already fired.
+func (s *ConsulStore) Set(schedule fields.Schedule) error {
+	return s.Store.Set(&schedule)
+}
+
+// Get returns the replica schedule for an RC, or NoSchedule if it has none.
+func (s *ConsulStore) Get(rcID rc_fields.ID) (fields.Schedule, error) {
+	schedule, err := s.Store.Get(rcID)
+	if err != nil {
+		return fields.Schedule{}, err
+	}
+	return *schedule.(*fields.Schedule), nil
+}
+
+func (s *ConsulStore) List() ([]fields.Schedule, error) {
+	listed, err := s.Store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]fields.Schedule, 0, len(listed))
+	for _, schedule := range listed {
+		ret = append(ret, *schedule.(*fields.Schedule))
+	}
+	return ret, nil
+}
+
+// SetLastAppliedTxn adds an operation to the transaction in ctx that records
+// that the schedule has been applied up to the given time. The operation
+// fails if the schedule has been changed since it was read, so that changes
+// made using a stale schedule are not committed.
+func (s *ConsulStore) SetLastAppliedTxn(ctx context.Context, schedule fields.Schedule, lastApplied time.Time) error {
+	return s.Store.SetProgressTxn(ctx, &schedule, lastApplied)
+}
@@ -0,0 +1,123 @@
+package replicaschedulestore
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	autoscale_fields "github.com/square/p2/pkg/autoscale/fields"
+	"github.com/square/p2/pkg/replicaschedule/fields"
+	"github.com/square/p2/pkg/store/consul/autoscalestore"
+	"github.com/square/p2/pkg/store/consul/consulutil"
+	"github.com/square/p2/pkg/store/consul/transaction"
+)
+
+func testSchedule() fields.Schedule {
+	return fields.Schedule{
+		RCID: "some_rc",
+		Entries: []fields.Entry{
+			{Cron: "0 22 * * *", Replicas: 2},
+			{Cron: "0 6 * * *", Replicas: 10},
+		},
+	}
+}
+
+func TestSetLastAppliedTxn(t *testing.T) {
+	fixture := consulutil.NewFixture(t)
+	defer fixture.Stop()
+	store := NewConsul(fixture.Client)
+
+	_, err := store.Get("some_rc")
+	if err != NoSchedule {
+		t.Fatalf("expected NoSchedule before the schedule was set but got %v", err)
+	}
+	err = store.Set(testSchedule())
+	if err != nil {
+		t.Fatal(err)
+	}
+	schedule, err := store.Get("some_rc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(schedule, testSchedule()) {
+		t.Errorf("expected to get %+v but got %+v", testSchedule(), schedule)
+	}
+
+	lastApplied := time.Now()
+	ctx, cancel := transaction.New(context.Background())
+	defer cancel()
+	err = store.SetLastAppliedTxn(ctx, schedule, lastApplied)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = transaction.MustCommit(ctx, fixture.Client.KV())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// replacing the schedule should keep the time it was last applied
+	replacement := testSchedule()
+	replacement.Timezone = "UTC"
+	err = store.Set(replacement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schedules, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(schedules) != 1 || !schedules[0].LastApplied.Equal(lastApplied) || schedules[0].Timezone != "UTC" {
+		t.Errorf("expected the replaced schedule to have timezone UTC and last applied %s but got %+v", lastApplied, schedules)
+	}
+}
+
+func TestSetRejectsAutoscaledRCs(t *testing.T) {
+	fixture := consulutil.NewFixture(t)
+	defer fixture.Stop()
+	store := NewConsul(fixture.Client)
+	autoscalers := autoscalestore.NewConsul(fixture.Client)
+
+	policy := autoscale_fields.Policy{
+		RCID:        "some_rc",
+		MinReplicas: 1,
+		MaxReplicas: 5,
+		TargetValue: 0.5,
+		Metric: autoscale_fields.MetricSourceConfig{
+			Type: autoscale_fields.HTTPMetric,
+			URL:  "http://localhost:8080/metric",
+		},
+	}
+	err := autoscalers.Set(policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Set(testSchedule())
+	if err == nil {
+		t.Fatal("expected an error setting a replica schedule for an RC with an autoscaler policy")
+	}
+	_, err = store.Get("some_rc")
+	if err != NoSchedule {
+		t.Fatalf("expected the rejected schedule not to be written but got %v", err)
+	}
+
+	err = autoscalers.Delete("some_rc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.Set(testSchedule())
+	if err != nil {
+		t.Fatalf("expected the schedule to be set once the autoscaler policy was deleted but got %s", err)
+	}
+
+	// the autoscaler policy can't be set back while the RC has a schedule
+	err = autoscalers.Set(policy)
+	if err == nil {
+		t.Fatal("expected an error setting an autoscaler policy for an RC with a replica schedule")
+	}
+	_, err = autoscalers.Get("some_rc")
+	if err != autoscalestore.NoPolicy {
+		t.Fatalf("expected the rejected autoscaler policy not to be written but got %v", err)
+	}
+}
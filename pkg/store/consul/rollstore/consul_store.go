@@ -71,7 +71,10 @@ type ReplicationControllerStore interface {
 		podLabels klabels.Set,
 		additionalLabels klabels.Set,
 		allocationStrategy rc_fields.Strategy,
+		spreadConstraint *rc_fields.SpreadConstraint,
+		antiAffinity klabels.Selector,
 	) (rc_fields.RC, error)
+	Get(id rc_fields.ID) (rc_fields.RC, error)
 	Delete(id rc_fields.ID, force bool) error
 	UpdateCreationLockPath(rcID rc_fields.ID) (string, error)
 
@@ -182,6 +185,21 @@ func (s ConsulStore) newRUCreationSession() (consul.Session, error) {
 	return session, nil
 }
 
+// carriedOverFields returns the old RC of an update being created, whose
+// scheduling constraints are carried over to the new RC so that deploys don't
+// drop them. An old RC that doesn't exist yet, such as one created in the
+// same transaction as the update, has nothing to carry over.
+func (s ConsulStore) carriedOverFields(oldRCID rc_fields.ID) (rc_fields.RC, error) {
+	oldRC, err := s.rcstore.Get(oldRCID)
+	switch {
+	case rcstore.IsNotExist(err):
+		return rc_fields.RC{}, nil
+	case err != nil:
+		return rc_fields.RC{}, util.Errorf("could not read old RC %s: %s", oldRCID, err)
+	}
+	return oldRC, nil
+}
+
 // Obtains a lock for each RC in the list, or errors. RCs are locked in
 // lexicographical order by RC id to avoid deadlocks.
 // TODO: lock RCs in a transaction so order doesn't matter
@@ -344,7 +362,12 @@ func (s ConsulStore) CreateRollingUpdateFromOneExistingRCWithID(
 		return roll_fields.Update{}, err
 	}
 
-	rc, err := s.rcstore.CreateTxn(ctx, newRCManifest, newRCNodeSelector, availabilityZone, clusterName, newRCPodLabels, newRCLabels, newAllocationStrategy)
+	oldRC, err := s.carriedOverFields(oldRCID)
+	if err != nil {
+		return roll_fields.Update{}, err
+	}
+
+	rc, err := s.rcstore.CreateTxn(ctx, newRCManifest, newRCNodeSelector, availabilityZone, clusterName, newRCPodLabels, newRCLabels, newAllocationStrategy, oldRC.SpreadConstraint, oldRC.AntiAffinity)
 	if err != nil {
 		return roll_fields.Update{}, err
 	}
@@ -431,7 +454,7 @@ func (s ConsulStore) CreateRollingUpdateFromOneMaybeExistingWithLabelSelector(
 
 		// Create the old RC using the same info as the new RC, it'll be
 		// removed when the update completes anyway
-		rc, err := s.rcstore.CreateTxn(ctx, newRCManifest, newRCNodeSelector, availabilityZone, clusterName, newRCPodLabels, newRCLabels, newAllocationStrategy)
+		rc, err := s.rcstore.CreateTxn(ctx, newRCManifest, newRCNodeSelector, availabilityZone, clusterName, newRCPodLabels, newRCLabels, newAllocationStrategy, nil, nil)
 		if err != nil {
 			return roll_fields.Update{}, err
 		}
@@ -451,9 +474,14 @@ func (s ConsulStore) CreateRollingUpdateFromOneMaybeExistingWithLabelSelector(
 		return roll_fields.Update{}, err
 	}
 
+	oldRC, err := s.carriedOverFields(oldRCID)
+	if err != nil {
+		return roll_fields.Update{}, err
+	}
+
 	// Create the new RC
 	var newRCID rc_fields.ID
-	rc, err := s.rcstore.CreateTxn(ctx, newRCManifest, newRCNodeSelector, availabilityZone, clusterName, newRCPodLabels, newRCLabels, newAllocationStrategy)
+	rc, err := s.rcstore.CreateTxn(ctx, newRCManifest, newRCNodeSelector, availabilityZone, clusterName, newRCPodLabels, newRCLabels, newAllocationStrategy, oldRC.SpreadConstraint, oldRC.AntiAffinity)
 	if err != nil {
 		return roll_fields.Update{}, err
 	}
@@ -508,6 +536,79 @@ func (s ConsulStore) Delete(ctx context.Context, id roll_fields.ID) error {
 	return nil
 }
 
+// SetRollingBackTxn adds operations to ctx that mark the rolling update with
+// the given ID as rolling back. The operations will fail the transaction if
+// the rolling update was modified after it was read.
+func (s ConsulStore) SetRollingBackTxn(ctx context.Context, id roll_fields.ID) error {
+	return s.mutateRUTxn(ctx, id, func(u roll_fields.Update) (roll_fields.Update, error) {
+		u.RollingBack = true
+		return u, nil
+	})
+}
+
+// AdvanceStepTxn adds operations to ctx that move the rolling update with the
+// given ID from step fromStep to the next step. An error is returned if the
+// update is not on fromStep, which protects against advancing twice when
+// both an operator and a farm try to advance the same step.
+func (s ConsulStore) AdvanceStepTxn(ctx context.Context, id roll_fields.ID, fromStep int) error {
+	return s.mutateRUTxn(ctx, id, func(u roll_fields.Update) (roll_fields.Update, error) {
+		if u.CurrentStep != fromStep {
+			return u, util.Errorf("rolling update %s is on step %d, not %d", id, u.CurrentStep, fromStep)
+		}
+		if u.CurrentStep >= len(u.Steps) {
+			return u, util.Errorf("rolling update %s has no steps remaining", id)
+		}
+		u.CurrentStep++
+		return u, nil
+	})
+}
+
+// SetPausedTxn adds operations to ctx that pause or resume the rolling update
+// with the given ID.
+func (s ConsulStore) SetPausedTxn(ctx context.Context, id roll_fields.ID, paused bool) error {
+	return s.mutateRUTxn(ctx, id, func(u roll_fields.Update) (roll_fields.Update, error) {
+		u.Paused = paused
+		return u, nil
+	})
+}
+
+func (s ConsulStore) mutateRUTxn(ctx context.Context, id roll_fields.ID, mutator func(roll_fields.Update) (roll_fields.Update, error)) error {
+	key, err := RollPath(id)
+	if err != nil {
+		return err
+	}
+
+	kvp, _, err := s.kv.Get(key, nil)
+	if err != nil {
+		return consulutil.NewKVError("get", key, err)
+	}
+	if kvp == nil {
+		return util.Errorf("rolling update %s does not exist", id)
+	}
+
+	ru, err := kvpToRU(kvp)
+	if err != nil {
+		return err
+	}
+
+	ru, err = mutator(ru)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(ru)
+	if err != nil {
+		return util.Errorf("could not marshal rolling update as JSON: %s", err)
+	}
+
+	return transaction.Add(ctx, api.KVTxnOp{
+		Verb:  api.KVCAS,
+		Key:   key,
+		Value: b,
+		Index: kvp.ModifyIndex,
+	})
+}
+
 // Lock takes a lock on a rolling update by ID. Before taking ownership of an
 // Update, its new RC ID, and old RC ID if any, should both be locked. If the
 // error return is nil, then the boolean indicates whether the lock was
@@ -540,6 +641,53 @@ func (s ConsulStore) Watch(quit <-chan struct{}, jitterWindow time.Duration) (<-
 	return outCh, errCh
 }
 
+// WatchRU sends the rolling update's record each time it changes, until quit
+// is closed. Nothing is sent while the update does not exist. Both output
+// channels are closed once quit is closed.
+func (s ConsulStore) WatchRU(id roll_fields.ID, quit <-chan struct{}) (<-chan roll_fields.Update, <-chan error) {
+	updated := make(chan roll_fields.Update)
+
+	key, err := RollPath(id)
+	if err != nil {
+		errors := make(chan error, 1)
+		errors <- err
+		close(errors)
+		close(updated)
+		return updated, errors
+	}
+
+	errors := make(chan error)
+	input := make(chan *api.KVPair)
+	go consulutil.WatchSingle(key, s.kv, input, quit, errors)
+
+	go func() {
+		defer close(updated)
+		defer close(errors)
+
+		for kvp := range input {
+			if kvp == nil {
+				// the update was deleted, whoever is running it
+				// will be stopped shortly
+				continue
+			}
+			ru, err := kvpToRU(kvp)
+			if err != nil {
+				select {
+				case errors <- err:
+				case <-quit:
+				}
+			} else {
+				select {
+				case updated <- ru:
+				case <-quit:
+				}
+			}
+		}
+	}()
+
+	return updated, errors
+}
+
 func publishLatestRolls(inCh <-chan api.KVPairs, quit <-chan struct{}) (<-chan []roll_fields.Update, chan error) {
 	outCh := make(chan []roll_fields.Update)
 	errCh := make(chan error)
@@ -652,6 +800,11 @@ func (s ConsulStore) createRU(
 	rollLabels klabels.Set,
 	session string,
 ) error {
+	err := u.ValidateSteps()
+	if err != nil {
+		return err
+	}
+
 	b, err := json.Marshal(u)
 	if err != nil {
 		return err
@@ -211,6 +211,29 @@ func TestCreateExistingRCsMutualExclusion(t *testing.T) {
 	}
 }
 
+func TestCreateFailsIfStepsDecrease(t *testing.T) {
+	rollstore, _ := newRollStoreWithFakeConsul(t, nil)
+
+	update := fields.Update{
+		NewRC:           rc_fields.ID("new_rc"),
+		OldRC:           rc_fields.ID("old_rc"),
+		DesiredReplicas: 10,
+		Steps:           []fields.Step{{Percent: 50}, {Replicas: 1}},
+	}
+
+	ctx, cancel := transaction.New(context.Background())
+	defer cancel()
+	_, err := rollstore.CreateRollingUpdateFromExistingRCs(ctx, update, nil, nil)
+	if err == nil {
+		t.Fatal("Expected update creation to fail because a step targets fewer replicas than the one before it")
+	}
+
+	ru, _ := rollstore.Get(fields.ID(update.NewRC))
+	if ru.NewRC != "" || ru.OldRC != "" {
+		t.Fatal("New ru shouldn't have been created but it was")
+	}
+}
+
 func TestCreateFailsIfCantAcquireLock(t *testing.T) {
 	newRCID := rc_fields.ID("new_rc")
 	oldRCID := rc_fields.ID("old_rc")
@@ -510,6 +510,74 @@ func TestCreateRollingUpdateFromOneMaybeExistingWithLabelSelectorFailsWhenConfli
 
 }
 
+func TestCreateRollingUpdateKeepsOldRCConstraints(t *testing.T) {
+	fixture := consulutil.NewFixture(t)
+	defer fixture.Stop()
+	rollstore, rcStore := newRollStoreWithRealConsul(t, fixture, nil)
+
+	spreadConstraint := &rc_fields.SpreadConstraint{Key: "rack", MaxSkew: 1}
+	antiAffinity := klabels.Everything().Add("app", klabels.EqualsOperator, []string{"web"})
+	// each way of creating an update gets its own old RC, labeled with
+	// the name of the create function
+	createOldRC := func(name string) rc_fields.RC {
+		oldRC, err := rollstore.rcstore.(*rcstore.ConsulStore).CreateWithConstraints(
+			testManifest(),
+			testNodeSelector(),
+			"some_az",
+			"some_cn",
+			nil,
+			klabels.Set{"test_rc": name},
+			"some_strategy",
+			spreadConstraint,
+			antiAffinity,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return oldRC
+	}
+
+	createFuncs := map[string]func(ctx context.Context) (fields.Update, error){
+		"with_id": func(ctx context.Context) (fields.Update, error) {
+			return rollstore.CreateRollingUpdateFromOneExistingRCWithID(
+				ctx, createOldRC("with_id").ID, 1, 0, false, 0, "some_az", "some_cn",
+				testManifest(), testNodeSelector(), nil, nil, nil, "some_strategy",
+			)
+		},
+		"with_selector": func(ctx context.Context) (fields.Update, error) {
+			createOldRC("with_selector")
+			return rollstore.CreateRollingUpdateFromOneMaybeExistingWithLabelSelector(
+				ctx, klabels.Everything().Add("test_rc", klabels.EqualsOperator, []string{"with_selector"}), 1, 0, false, 0, "some_az", "some_cn",
+				testManifest(), testNodeSelector(), nil, nil, nil, "some_strategy",
+			)
+		},
+	}
+	for name, create := range createFuncs {
+		txn, cancelFunc := transaction.New(context.Background())
+		u, err := create(txn)
+		if err != nil {
+			cancelFunc()
+			t.Fatalf("could not create update %s: %s", name, err)
+		}
+		err = transaction.MustCommit(txn, fixture.Client.KV())
+		cancelFunc()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		newRC, err := rcStore.Get(u.NewRC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if newRC.SpreadConstraint == nil || *newRC.SpreadConstraint != *spreadConstraint {
+			t.Errorf("expected the new RC of the update created %s to keep the spread constraint %+v but got %+v", name, spreadConstraint, newRC.SpreadConstraint)
+		}
+		if newRC.AntiAffinity == nil || newRC.AntiAffinity.String() != antiAffinity.String() {
+			t.Errorf("expected the new RC of the update created %s to keep the anti-affinity %s but got %v", name, antiAffinity, newRC.AntiAffinity)
+		}
+	}
+}
+
 func newRollStoreWithRealConsul(t *testing.T, fixture consulutil.Fixture, entries []fields.Update) (*ConsulStore, testRCStore) {
 	for _, u := range entries {
 		path, err := RollPath(fields.ID(u.NewRC))
@@ -546,3 +614,57 @@ func podLabels() klabels.Set {
 		types.ClusterNameLabel:      "some_cn",
 	}
 }
+
+func TestWatchRU(t *testing.T) {
+	fixture := consulutil.NewFixture(t)
+	defer fixture.Stop()
+	store := ConsulStore{kv: fixture.Client.KV()}
+
+	update := fields.Update{
+		NewRC:           rc_fields.ID("new_rc"),
+		OldRC:           rc_fields.ID("old_rc"),
+		DesiredReplicas: 3,
+	}
+	putRU := func(u fields.Update) {
+		key, err := RollPath(u.ID())
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := json.Marshal(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = fixture.Client.KV().Put(&api.KVPair{Key: key, Value: b}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	putRU(update)
+
+	quit := make(chan struct{})
+	defer close(quit)
+	updates, errs := store.WatchRU(update.ID(), quit)
+	waitForRU := func(paused bool) {
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case ru := <-updates:
+				if ru.NewRC != update.NewRC {
+					t.Fatalf("expected the watched update to be %s but was %s", update.NewRC, ru.NewRC)
+				}
+				if ru.Paused == paused {
+					return
+				}
+			case err := <-errs:
+				t.Fatal(err)
+			case <-timeout:
+				t.Fatalf("timed out waiting for the update with paused %t", paused)
+			}
+		}
+	}
+
+	waitForRU(false)
+	update.Paused = true
+	putRU(update)
+	waitForRU(true)
+}
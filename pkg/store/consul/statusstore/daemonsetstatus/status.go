@@ -12,4 +12,15 @@ type Status struct {
 	NodesDeployed int `json:"nodes_deployed"`
 
 	ReplicationInProgress bool `json:"replication_in_progress"`
+
+	// MaxInFlight is the number of nodes the replication deploys to at
+	// once, after resolving the daemon set's max in flight against its
+	// eligible nodes
+	MaxInFlight int `json:"max_in_flight"`
+
+	// CanaryNodes is the number of nodes that must be deployed to and
+	// healthy with the manifest before any other nodes are deployed to,
+	// and CanaryNodesHealthy is the number of them that are
+	CanaryNodes        int `json:"canary_nodes"`
+	CanaryNodesHealthy int `json:"canary_nodes_healthy"`
 }
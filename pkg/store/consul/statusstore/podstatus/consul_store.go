@@ -150,6 +150,28 @@ func (c ConsulStore) SetLastExit(ctx context.Context, podUniqueKey types.PodUniq
 	return c.MutateStatus(ctx, podUniqueKey, mutator)
 }
 
+// A helper method for counting the restarts of a process caused by its
+// launchable failing a liveness check. Increments LivenessRestarts of the
+// process matching the launchable ID and entry point, adding the process if
+// it is not found.
+func (c ConsulStore) IncrementLivenessRestarts(ctx context.Context, podUniqueKey types.PodUniqueKey, launchableID launch.LaunchableID, entryPoint string) error {
+	mutator := func(p PodStatus) (PodStatus, error) {
+		for i := range p.ProcessStatuses {
+			if p.ProcessStatuses[i].LaunchableID == launchableID && p.ProcessStatuses[i].EntryPoint == entryPoint {
+				p.ProcessStatuses[i].LivenessRestarts++
+				return p, nil
+			}
+		}
+		p.ProcessStatuses = append(p.ProcessStatuses, ProcessStatus{
+			LaunchableID:     launchableID,
+			EntryPoint:       entryPoint,
+			LivenessRestarts: 1,
+		})
+		return p, nil
+	}
+	return c.MutateStatus(ctx, podUniqueKey, mutator)
+}
+
 // List lists all of the pod status entries in consul.
 func (c ConsulStore) List() (map[types.PodUniqueKey]PodStatus, error) {
 	allStatus, err := c.statusStore.GetAllStatusForResourceType(statusstore.POD)
@@ -93,3 +93,50 @@ func TestMutateStatusExistingKey(t *testing.T) {
 		t.Error("ProcessStatus field didn't go untouched when mutating PodStatus")
 	}
 }
+
+func TestIncrementLivenessRestarts(t *testing.T) {
+	fixture := consulutil.NewFixture(t)
+	defer fixture.Stop()
+	consulStore := statusstore.NewConsul(fixture.Client)
+	podStore := NewConsul(consulStore, "test_namespace")
+
+	key := types.NewPodUUID()
+	err := podStore.Set(key, PodStatus{
+		ProcessStatuses: []ProcessStatus{
+			{LaunchableID: "some_launchable", EntryPoint: "launch", LastExit: &ExitStatus{ExitCode: 1}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	increment := func(entryPoint string) {
+		ctx, cancelFunc := transaction.New(context.Background())
+		defer cancelFunc()
+		err := podStore.IncrementLivenessRestarts(ctx, key, "some_launchable", entryPoint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = transaction.MustCommit(ctx, fixture.Client.KV())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	increment("launch")
+	increment("launch")
+	increment("worker")
+
+	status, _, err := podStore.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status.ProcessStatuses) != 2 {
+		t.Fatalf("Expected a status entry for each entry point, but there were %d", len(status.ProcessStatuses))
+	}
+	if status.ProcessStatuses[0].LivenessRestarts != 2 || status.ProcessStatuses[0].LastExit == nil {
+		t.Errorf("Expected two restarts and the last exit to be kept, was %+v", status.ProcessStatuses[0])
+	}
+	if status.ProcessStatuses[1].EntryPoint != "worker" || status.ProcessStatuses[1].LivenessRestarts != 1 {
+		t.Errorf("Expected a new entry with one restart, was %+v", status.ProcessStatuses[1])
+	}
+}
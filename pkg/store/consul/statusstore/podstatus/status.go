@@ -36,12 +36,14 @@ type ExitStatus struct {
 	ExitStatus int       `json:"exit_status"`
 }
 
-// Encapsulates information regarding the state of a process. Currently only
-// information about the last exit is exposed.
+// Encapsulates information regarding the state of a process: its last exit
+// and how many times the preparer restarted it because its launchable failed
+// a liveness check.
 type ProcessStatus struct {
-	LaunchableID launch.LaunchableID `json:"launchable_id"`
-	EntryPoint   string              `json:"entry_point"`
-	LastExit     *ExitStatus         `json:"last_exit"`
+	LaunchableID     launch.LaunchableID `json:"launchable_id"`
+	EntryPoint       string              `json:"entry_point"`
+	LastExit         *ExitStatus         `json:"last_exit"`
+	LivenessRestarts int                 `json:"liveness_restarts,omitempty"`
 }
 
 // Encapsulates the state of all processes running in a pod.
@@ -0,0 +1,74 @@
+package rustatus
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/square/p2/pkg/rc/fields"
+	"github.com/square/p2/pkg/store/consul/statusstore"
+	"github.com/square/p2/pkg/util"
+)
+
+// Status describes the progress of a rolling update as most recently
+// observed by the farm processing it.
+type Status struct {
+	OldRC RCCounts `json:"old_rc"`
+	NewRC RCCounts `json:"new_rc"`
+
+	// NextRemove and NextAdd are the most recent decision of the rolling
+	// update algorithm: the number of replicas to remove from the RC
+	// giving them up and to add to the RC taking them over. When the
+	// update is rolling back these are the new and old RC respectively.
+	NextRemove int `json:"next_remove"`
+	NextAdd    int `json:"next_add"`
+
+	RollingBack bool `json:"rolling_back"`
+	Paused      bool `json:"paused"`
+
+	// BlockedReason is a human readable explanation of why the update is
+	// not currently moving any replicas. It is empty if the update is
+	// making progress.
+	BlockedReason string `json:"blocked_reason,omitempty"`
+
+	// LastChanged is when any other field of the status last changed. The
+	// status is only written when it changes, so this can be used to tell
+	// how long an update has been blocked.
+	LastChanged time.Time `json:"last_changed"`
+}
+
+// RCCounts holds the node counts of one of the RCs involved in a rolling
+// update.
+type RCCounts struct {
+	ID fields.ID `json:"id"`
+
+	// Desired is the number of nodes the RC wants to be on
+	Desired int `json:"desired"`
+	// Current is the number of nodes the RC has scheduled itself on
+	Current int `json:"current"`
+	// Real is the number of current nodes that have finished scheduling
+	Real int `json:"real"`
+
+	Healthy   int `json:"healthy"`
+	Unhealthy int `json:"unhealthy"`
+	Unknown   int `json:"unknown"`
+}
+
+func rawStatusToStatus(rawStatus statusstore.Status) (Status, error) {
+	var status Status
+
+	err := json.Unmarshal(rawStatus.Bytes(), &status)
+	if err != nil {
+		return Status{}, util.Errorf("Could not unmarshal raw status as rolling update status: %s", err)
+	}
+
+	return status, nil
+}
+
+func statusToRawStatus(status Status) (statusstore.Status, error) {
+	bytes, err := json.Marshal(status)
+	if err != nil {
+		return statusstore.Status{}, util.Errorf("Could not marshal rolling update status as json bytes: %s", err)
+	}
+
+	return statusstore.Status(bytes), nil
+}
@@ -0,0 +1,75 @@
+package rustatus
+
+import (
+	"context"
+
+	roll_fields "github.com/square/p2/pkg/roll/fields"
+	"github.com/square/p2/pkg/store/consul/statusstore"
+	"github.com/square/p2/pkg/util"
+
+	"github.com/hashicorp/consul/api"
+)
+
+type ConsulStore struct {
+	statusStore statusstore.Store
+
+	// The consul implementation statusstore.Store formats keys like
+	// /status/<resource-type>/<resource-id>/<namespace>. The namespace
+	// portion is useful if multiple subsystems need to record their
+	// own view of a resource.
+	namespace statusstore.Namespace
+}
+
+func NewConsul(statusStore statusstore.Store, namespace statusstore.Namespace) ConsulStore {
+	return ConsulStore{
+		statusStore: statusStore,
+		namespace:   namespace,
+	}
+}
+
+func (c ConsulStore) Get(id roll_fields.ID) (Status, *api.QueryMeta, error) {
+	if id == "" {
+		return Status{}, nil, util.Errorf("Provided rolling update ID was empty")
+	}
+
+	rawStatus, queryMeta, err := c.statusStore.GetStatus(statusstore.RU, statusstore.ResourceID(id), c.namespace)
+	if err != nil {
+		return Status{}, queryMeta, err
+	}
+
+	status, err := rawStatusToStatus(rawStatus)
+	if err != nil {
+		return Status{}, queryMeta, err
+	}
+
+	return status, queryMeta, nil
+}
+
+func (c ConsulStore) Set(id roll_fields.ID, status Status) error {
+	if id == "" {
+		return util.Errorf("Provided rolling update ID was empty")
+	}
+
+	rawStatus, err := statusToRawStatus(status)
+	if err != nil {
+		return err
+	}
+
+	return c.statusStore.SetStatus(statusstore.RU, statusstore.ResourceID(id), c.namespace, rawStatus)
+}
+
+func (c ConsulStore) Delete(id roll_fields.ID) error {
+	if id == "" {
+		return util.Errorf("Provided rolling update ID was empty")
+	}
+
+	return c.statusStore.DeleteStatus(statusstore.RU, statusstore.ResourceID(id), c.namespace)
+}
+
+func (c ConsulStore) DeleteTxn(ctx context.Context, id roll_fields.ID) error {
+	if id == "" {
+		return util.Errorf("Provided rolling update ID was empty")
+	}
+
+	return c.statusStore.DeleteStatusTxn(ctx, statusstore.RU, statusstore.ResourceID(id), c.namespace)
+}
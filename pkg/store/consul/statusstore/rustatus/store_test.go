@@ -0,0 +1,105 @@
+package rustatus
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	roll_fields "github.com/square/p2/pkg/roll/fields"
+	"github.com/square/p2/pkg/store/consul/consulutil"
+	"github.com/square/p2/pkg/store/consul/statusstore"
+	"github.com/square/p2/pkg/store/consul/transaction"
+)
+
+type testHelper struct {
+	consulFixture consulutil.Fixture
+	store         ConsulStore
+	status        Status
+	id            roll_fields.ID
+}
+
+func (th *testHelper) stop() {
+	th.consulFixture.Stop()
+}
+
+func initTestHelper(t *testing.T) testHelper {
+	consulFixture := consulutil.NewFixture(t)
+	store := NewConsul(statusstore.NewConsul(consulFixture.Client), "test")
+	status := Status{
+		OldRC: RCCounts{
+			ID:      "old_rc_id",
+			Desired: 2,
+			Current: 2,
+			Real:    2,
+			Healthy: 2,
+		},
+		NewRC: RCCounts{
+			ID:        "new_rc_id",
+			Desired:   1,
+			Current:   1,
+			Real:      1,
+			Unhealthy: 1,
+		},
+		BlockedReason: "cannot schedule",
+		LastChanged:   time.Now().UTC().Truncate(time.Second),
+	}
+	id := roll_fields.ID("new_rc_id")
+
+	return testHelper{
+		consulFixture: consulFixture,
+		store:         store,
+		status:        status,
+		id:            id,
+	}
+}
+
+func TestSetAndGetStatus(t *testing.T) {
+	th := initTestHelper(t)
+	defer th.stop()
+
+	_, _, err := th.store.Get(th.id)
+	if !statusstore.IsNoStatus(err) {
+		t.Fatalf("Expected no status error, got: %s", err)
+	}
+
+	err = th.store.Set(th.id, th.status)
+	if err != nil {
+		t.Fatalf("Unexpected error setting status: %s", err)
+	}
+
+	status, _, err := th.store.Get(th.id)
+	if err != nil {
+		t.Fatalf("Unexpected error getting status: %s", err)
+	}
+
+	if status != th.status {
+		t.Fatalf("Status was %+v, wanted %+v", status, th.status)
+	}
+}
+
+func TestDeleteTxn(t *testing.T) {
+	th := initTestHelper(t)
+	defer th.stop()
+
+	err := th.store.Set(th.id, th.status)
+	if err != nil {
+		t.Fatalf("Unexpected error setting status: %s", err)
+	}
+
+	ctx, cancel := transaction.New(context.Background())
+	defer cancel()
+	err = th.store.DeleteTxn(ctx, th.id)
+	if err != nil {
+		t.Fatalf("Unexpected error building status deletion transaction: %s", err)
+	}
+
+	err = transaction.MustCommit(ctx, th.consulFixture.Client.KV())
+	if err != nil {
+		t.Fatalf("Unexpected error deleting rolling update status: %s", err)
+	}
+
+	_, _, err = th.store.Get(th.id)
+	if !statusstore.IsNoStatus(err) {
+		t.Errorf("Expected error to be NoStatus but was %v", err)
+	}
+}
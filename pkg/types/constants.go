@@ -4,4 +4,9 @@ const (
 	AvailabilityZoneLabel = "availability_zone"
 	ClusterNameLabel      = "cluster_name"
 	PodIDLabel            = "pod_id"
+
+	// CordonedLabel is set on nodes that have been taken out of service.
+	// The schedulers in pkg/scheduler never consider cordoned nodes
+	// eligible, regardless of the node selector being scheduled
+	CordonedLabel = "cordoned"
 )
@@ -0,0 +1,167 @@
+// Package cron parses cron expressions and computes the times they match.
+package cron
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/square/p2/pkg/util"
+)
+
+// Expression is a parsed cron expression in the standard five field format:
+//
+//	minute hour day-of-month month day-of-week
+//
+// Each field may be "*", a number, a range such as "1-5", or a comma
+// separated list of these, and "*" and ranges may be followed by a step such
+// as "*/15". Days of the week are numbered 0-7 where both 0 and 7 are
+// Sunday. Names of months and days are not supported.
+type Expression struct {
+	minute, hour, dom, month, dow uint64
+
+	// as in other cron implementations, if both the day of month and the
+	// day of week are restricted a day matches if either does. A field
+	// starting with "*", such as "*/2", is not restricted
+	domStar, dowStar bool
+}
+
+type cronField struct {
+	name     string
+	min, max int
+}
+
+var cronFields = []cronField{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// Parse parses a cron expression
+func Parse(expr string) (Expression, error) {
+	parts := strings.Fields(expr)
+	if len(parts) != len(cronFields) {
+		return Expression{}, util.Errorf("cron expression %q must have %d fields", expr, len(cronFields))
+	}
+
+	bits := make([]uint64, len(cronFields))
+	for i, field := range cronFields {
+		var err error
+		bits[i], err = parseCronField(parts[i], field)
+		if err != nil {
+			return Expression{}, util.Errorf("invalid cron expression %q: %s", expr, err)
+		}
+	}
+
+	// Sunday can be written as 7
+	if bits[4]&(1<<7) != 0 {
+		bits[4] |= 1
+	}
+
+	return Expression{
+		minute:  bits[0],
+		hour:    bits[1],
+		dom:     bits[2],
+		month:   bits[3],
+		dow:     bits[4],
+		domStar: strings.HasPrefix(parts[2], "*"),
+		dowStar: strings.HasPrefix(parts[4], "*"),
+	}, nil
+}
+
+func parseCronField(value string, field cronField) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(value, ",") {
+		rangeStr, step := item, 1
+		if i := strings.Index(item, "/"); i >= 0 {
+			var err error
+			rangeStr = item[:i]
+			step, err = strconv.Atoi(item[i+1:])
+			if err != nil || step < 1 {
+				return 0, util.Errorf("invalid step in %s %q", field.name, item)
+			}
+		}
+
+		var low, high int
+		switch {
+		case rangeStr == "*":
+			low, high = field.min, field.max
+		case strings.Contains(rangeStr, "-"):
+			bounds := strings.SplitN(rangeStr, "-", 2)
+			var err error
+			low, err = strconv.Atoi(bounds[0])
+			if err != nil {
+				return 0, util.Errorf("invalid %s %q", field.name, item)
+			}
+			high, err = strconv.Atoi(bounds[1])
+			if err != nil {
+				return 0, util.Errorf("invalid %s %q", field.name, item)
+			}
+		default:
+			var err error
+			low, err = strconv.Atoi(rangeStr)
+			if err != nil {
+				return 0, util.Errorf("invalid %s %q", field.name, item)
+			}
+			high = low
+			if step != 1 {
+				// "5/10" means starting at 5 in steps of 10
+				high = field.max
+			}
+		}
+
+		if low < field.min || high > field.max || low > high {
+			return 0, util.Errorf("%s %q is not within %d-%d", field.name, item, field.min, field.max)
+		}
+		for v := low; v <= high; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func has(bits uint64, v int) bool {
+	return bits&(1<<uint(v)) != 0
+}
+
+func (e Expression) dayMatches(t time.Time) bool {
+	domMatch := has(e.dom, t.Day())
+	dowMatch := has(e.dow, int(t.Weekday()))
+	if e.domStar || e.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// Next returns the first time strictly after the passed time that the
+// expression matches, in the passed time's location. The zero time is
+// returned if the expression does not match any time in the next five years,
+// e.g. "0 0 30 2 *".
+func (e Expression) Next(after time.Time) time.Time {
+	loc := after.Location()
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if !has(e.month, int(t.Month())) {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !e.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !has(e.hour, t.Hour()) {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+		if !has(e.minute, t.Minute()) {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
@@ -0,0 +1,79 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+	} {
+		_, err := Parse(expr)
+		if err == nil {
+			t.Errorf("expected an error parsing %q", expr)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	// a Wednesday
+	start := time.Date(2020, time.January, 1, 12, 30, 15, 0, time.UTC)
+
+	tests := []struct {
+		expr     string
+		expected time.Time
+	}{
+		{"* * * * *", time.Date(2020, time.January, 1, 12, 31, 0, 0, time.UTC)},
+		{"30 12 * * *", time.Date(2020, time.January, 2, 12, 30, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2020, time.January, 1, 12, 45, 0, 0, time.UTC)},
+		{"0 22 * * 1-5", time.Date(2020, time.January, 1, 22, 0, 0, 0, time.UTC)},
+		{"0 6 * * 0", time.Date(2020, time.January, 5, 6, 0, 0, 0, time.UTC)},
+		{"0 6 * * 7", time.Date(2020, time.January, 5, 6, 0, 0, 0, time.UTC)},
+		{"0 0 1 3 *", time.Date(2020, time.March, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2020, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		// day of month or day of week when both are restricted
+		{"0 0 15 * 5", time.Date(2020, time.January, 3, 0, 0, 0, 0, time.UTC)},
+		{"0 0 2,15 * 5", time.Date(2020, time.January, 2, 0, 0, 0, 0, time.UTC)},
+		// a stepped "*" is not a restriction, so both have to match
+		{"0 0 */2 * 4", time.Date(2020, time.January, 9, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, test := range tests {
+		expr, err := Parse(test.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		next := expr.Next(start)
+		if !next.Equal(test.expected) {
+			t.Errorf("expected %q to next match at %s but got %s", test.expr, test.expected, next)
+		}
+	}
+}
+
+func TestNextInLocation(t *testing.T) {
+	loc, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Skip("time zone database is not available")
+	}
+
+	expr, err := Parse("0 22 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	next := expr.Next(start.In(loc))
+	expected := time.Date(2020, time.January, 1, 6, 0, 0, 0, time.UTC)
+	if !next.Equal(expected) {
+		t.Errorf("expected 10pm Pacific to be %s but got %s", expected, next.UTC())
+	}
+}
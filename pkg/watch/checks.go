@@ -0,0 +1,365 @@
+package watch
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/square/p2/pkg/constants"
+	"github.com/square/p2/pkg/launch"
+	"github.com/square/p2/pkg/manifest"
+	"github.com/square/p2/pkg/p2exec"
+	"github.com/square/p2/pkg/runit"
+	"github.com/square/p2/pkg/types"
+	"github.com/square/p2/pkg/util"
+)
+
+// Default failure threshold for checks listed in a manifest's status stanza.
+// Checks without an interval run every HEALTHCHECK_INTERVAL and checks
+// without a timeout use the healthcheck_timeout tunable
+const DefaultFailureThreshold = 1
+
+// Default failure threshold for liveness checks, which is higher because
+// reaching it gets the launchable restarted
+const DefaultLivenessFailureThreshold = 3
+
+// The most of an http check's response body that is matched against its
+// body regex
+const maxCheckBodyBytes = 64 * 1024
+
+// A prober performs a single attempt of a health check, returning an error
+// if the attempt failed
+type prober interface {
+	probe(ctx context.Context) error
+}
+
+// HealthCheck runs one of the checks listed in a manifest's status stanza and
+// tracks its consecutive failures
+type HealthCheck struct {
+	Type             launch.HealthCheckType
+	Interval         time.Duration
+	Timeout          time.Duration
+	FailureThreshold int
+
+	// InitialDelay is how long the check waits before its first attempt,
+	// and again after each reset
+	InitialDelay time.Duration
+
+	prober prober
+
+	// mu protects the fields below, which are written by the goroutine
+	// running the check and read by whoever reports its results
+	mu        sync.Mutex
+	failures  int
+	lastErr   error
+	notBefore time.Time
+
+	// succeeded is set once an attempt has succeeded since the check was
+	// last reset
+	succeeded bool
+}
+
+// Run probes the check every Interval until quit is closed. Each check runs
+// in its own goroutine, so a check that is slow to time out doesn't delay
+// the others or stretch their intervals
+func (c *HealthCheck) Run(quit <-chan struct{}) {
+	c.reset()
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+	for {
+		c.probe()
+		select {
+		case <-quit:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probe performs a single attempt of the check, giving up after Timeout. No
+// attempt is made during the initial delay
+func (c *HealthCheck) probe() {
+	c.mu.Lock()
+	delayed := time.Now().Before(c.notBefore)
+	c.mu.Unlock()
+	if delayed {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+	err := c.prober.probe(ctx)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		c.failures++
+		c.lastErr = err
+	} else {
+		c.failures = 0
+		c.lastErr = nil
+		c.succeeded = true
+	}
+}
+
+// Failing returns true once the check has failed FailureThreshold times in a
+// row, along with the error of its last attempt
+func (c *HealthCheck) Failing() (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.failures >= c.FailureThreshold, c.lastErr
+}
+
+// Succeeded returns true once an attempt of the check has succeeded since it
+// was last reset, along with the error of its last attempt
+func (c *HealthCheck) Succeeded() (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.succeeded, c.lastErr
+}
+
+// Passing returns true if the last attempt of the check succeeded. A check
+// that hasn't run since it was last reset isn't passing
+func (c *HealthCheck) Passing() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.succeeded && c.failures == 0
+}
+
+// reset forgets the check's failures, so that it has to reach its failure
+// threshold again, and waits for the initial delay from now before the next
+// attempt
+func (c *HealthCheck) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failures = 0
+	c.lastErr = nil
+	c.succeeded = false
+	c.notBefore = time.Now().Add(c.InitialDelay)
+}
+
+// runHealthChecks starts a goroutine running each check until quit is closed
+func runHealthChecks(checks []*HealthCheck, quit <-chan struct{}) {
+	for _, check := range checks {
+		go check.Run(quit)
+	}
+}
+
+// newHealthChecks returns the checks listed in a manifest's status stanza
+func newHealthChecks(
+	man manifest.Manifest,
+	node types.NodeName,
+	secureClient *http.Client,
+	insecureClient *http.Client,
+) ([]*HealthCheck, error) {
+	var checks []*HealthCheck
+	for _, stanza := range man.GetStatusStanza().Checks {
+		check, err := newHealthCheck(stanza, DefaultFailureThreshold, man.RunAsUser(), man.ID().String()+"__", node, secureClient, insecureClient)
+		if err != nil {
+			return nil, util.Errorf("invalid status check for %s: %s", man.ID(), err)
+		}
+		checks = append(checks, check)
+	}
+	return checks, nil
+}
+
+// newLaunchableHealthChecks returns the checks listed in the status stanzas
+// of a manifest's launchables, keyed by launchable ID. Launchables without
+// checks are left out
+func newLaunchableHealthChecks(
+	man manifest.Manifest,
+	node types.NodeName,
+	secureClient *http.Client,
+	insecureClient *http.Client,
+) (map[launch.LaunchableID][]*HealthCheck, error) {
+	ret := make(map[launch.LaunchableID][]*HealthCheck)
+	for launchableID, launchableStanza := range man.GetLaunchableStanzas() {
+		// runit services of a launchable are named
+		// <pod>__<launchable>__<entry point>
+		servicePrefix := fmt.Sprintf("%s__%s__", man.ID(), launchableID)
+		for _, stanza := range launchableStanza.Status.Checks {
+			check, err := newHealthCheck(stanza, DefaultFailureThreshold, man.RunAsUser(), servicePrefix, node, secureClient, insecureClient)
+			if err != nil {
+				return nil, util.Errorf("invalid status check for %s launchable %s: %s", man.ID(), launchableID, err)
+			}
+			ret[launchableID] = append(ret[launchableID], check)
+		}
+	}
+	return ret, nil
+}
+
+// newHealthCheck sets up a single check. Exec checks run as user and runit
+// checks look at the services whose names start with servicePrefix
+func newHealthCheck(
+	stanza launch.HealthCheckStanza,
+	defaultFailureThreshold int,
+	user string,
+	servicePrefix string,
+	node types.NodeName,
+	secureClient *http.Client,
+	insecureClient *http.Client,
+) (*HealthCheck, error) {
+	check := &HealthCheck{
+		Type:             stanza.Type,
+		Interval:         stanza.GetInterval(),
+		Timeout:          stanza.GetTimeout(),
+		FailureThreshold: stanza.FailureThreshold,
+	}
+	if check.Interval == 0 {
+		check.Interval = HEALTHCHECK_INTERVAL
+	}
+	if check.Timeout == 0 {
+		check.Timeout = time.Duration(*constants.HEALTHCHECK_TIMEOUT) * time.Second
+	}
+	if check.FailureThreshold == 0 {
+		check.FailureThreshold = defaultFailureThreshold
+	}
+
+	host := node
+	client := secureClient
+	if stanza.LocalhostOnly {
+		host = "localhost"
+		client = insecureClient
+	}
+
+	switch stanza.Type {
+	case launch.TCPHealthCheck:
+		check.prober = tcpProber{
+			address: net.JoinHostPort(host.String(), fmt.Sprint(stanza.Port)),
+		}
+	case launch.HTTPHealthCheck:
+		bodyRegex, err := regexp.Compile(stanza.BodyRegex)
+		if err != nil {
+			return nil, util.Errorf("invalid body regex: %s", err)
+		}
+		scheme := "https"
+		if stanza.HTTP {
+			scheme = "http"
+		}
+		check.prober = httpProber{
+			client:         client,
+			uri:            fmt.Sprintf("%s://%s:%d%s", scheme, host, stanza.Port, stanza.GetPath()),
+			expectedStatus: stanza.ExpectedStatus,
+			bodyRegex:      bodyRegex,
+		}
+	case launch.ExecHealthCheck:
+		p2ExecArgs := p2exec.P2ExecArgs{
+			User:    user,
+			Command: stanza.Command,
+		}
+		check.prober = execProber{
+			command: append([]string{p2exec.DefaultP2Exec}, p2ExecArgs.CommandLine()...),
+		}
+	case launch.RunitHealthCheck:
+		check.prober = runitProber{
+			sv:            runit.DefaultSV,
+			runitRoot:     runit.DefaultBuilder.RunitRoot,
+			servicePrefix: servicePrefix,
+		}
+	default:
+		return nil, util.Errorf("unknown health check type %q", stanza.Type)
+	}
+	return check, nil
+}
+
+type tcpProber struct {
+	address string
+}
+
+func (p tcpProber) probe(ctx context.Context) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", p.address)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+type httpProber struct {
+	client         *http.Client
+	uri            string
+	expectedStatus int
+	bodyRegex      *regexp.Regexp
+}
+
+func (p httpProber) probe(ctx context.Context) error {
+	req, err := http.NewRequest("GET", p.uri, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if p.expectedStatus != 0 && resp.StatusCode != p.expectedStatus {
+		return util.Errorf("%s returned status %d instead of %d", p.uri, resp.StatusCode, p.expectedStatus)
+	} else if p.expectedStatus == 0 && (resp.StatusCode < 200 || resp.StatusCode >= 300) {
+		return util.Errorf("%s returned status %d", p.uri, resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxCheckBodyBytes))
+	if err != nil {
+		return util.Errorf("could not read response from %s: %s", p.uri, err)
+	}
+	if !p.bodyRegex.Match(body) {
+		return util.Errorf("response from %s did not match %q", p.uri, p.bodyRegex)
+	}
+	return nil
+}
+
+type execProber struct {
+	command []string
+}
+
+func (p execProber) probe(ctx context.Context) error {
+	output, err := exec.CommandContext(ctx, p.command[0], p.command[1:]...).CombinedOutput()
+	if err != nil {
+		return util.Errorf("%s failed: %s, output: %s", strings.Join(p.command, " "), err, output)
+	}
+	return nil
+}
+
+// runitProber checks the runit services whose names start with
+// servicePrefix. The services of a legacy pod are named after the pod ID
+// followed by "__"
+type runitProber struct {
+	sv            runit.SV
+	runitRoot     string
+	servicePrefix string
+}
+
+func (p runitProber) probe(ctx context.Context) error {
+	paths, err := filepath.Glob(filepath.Join(p.runitRoot, p.servicePrefix+"*"))
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		return util.Errorf("no runit services found starting with %s", p.servicePrefix)
+	}
+
+	for _, path := range paths {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		service := &runit.Service{Path: path, Name: filepath.Base(path)}
+		stat, err := p.sv.Stat(service)
+		if err != nil {
+			return util.Errorf("could not stat %s: %s", service.Name, err)
+		}
+		if stat.ChildStatus != runit.STATUS_RUN {
+			return util.Errorf("%s is %s", service.Name, stat.ChildStatus)
+		}
+	}
+	return nil
+}
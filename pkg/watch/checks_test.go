@@ -0,0 +1,388 @@
+package watch
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/square/p2/pkg/health"
+	"github.com/square/p2/pkg/launch"
+	"github.com/square/p2/pkg/manifest"
+	"github.com/square/p2/pkg/runit"
+)
+
+type countingProber struct {
+	mu    sync.Mutex
+	calls int
+	err   error
+}
+
+func (p *countingProber) probe(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls++
+	return p.err
+}
+
+func (p *countingProber) setErr(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.err = err
+}
+
+func (p *countingProber) callCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.calls
+}
+
+// hangingProber blocks until its attempt times out
+type hangingProber struct{}
+
+func (hangingProber) probe(ctx context.Context) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestHealthCheckThreshold(t *testing.T) {
+	prober := &countingProber{err: fmt.Errorf("down")}
+	check := &HealthCheck{
+		Type:             launch.HTTPHealthCheck,
+		Interval:         10 * time.Second,
+		Timeout:          time.Second,
+		FailureThreshold: 2,
+		prober:           prober,
+	}
+	sc := StatusChecker{ID: "some_pod", Checks: []*HealthCheck{check}}
+
+	prober.setErr(nil)
+	check.probe()
+	prober.setErr(fmt.Errorf("down"))
+	check.probe()
+	if res := sc.resultFromChecks(); res.Status != health.Passing {
+		t.Errorf("expected a single failure below the threshold to pass but got %s", res.Status)
+	}
+	check.probe()
+	res := sc.resultFromChecks()
+	if res.Status != health.Critical {
+		t.Errorf("expected the check to fail once it reached its threshold but got %s", res.Status)
+	}
+	if expected := fmt.Sprintf("%s check failed: down", launch.HTTPHealthCheck); res.Output != expected {
+		t.Errorf("expected the result's output to be %q but was %q", expected, res.Output)
+	}
+
+	prober.setErr(nil)
+	check.probe()
+	res = sc.resultFromChecks()
+	if res.Status != health.Passing {
+		t.Errorf("expected the check to pass after a successful attempt but got %s", res.Status)
+	}
+	if res.Output != "" {
+		t.Errorf("expected a passing result to have no output but got %q", res.Output)
+	}
+}
+
+func TestHealthCheckCriticalUntilPassed(t *testing.T) {
+	prober := &countingProber{err: fmt.Errorf("down")}
+	check := &HealthCheck{
+		Type:             launch.TCPHealthCheck,
+		Interval:         10 * time.Second,
+		Timeout:          time.Second,
+		FailureThreshold: 3,
+		prober:           prober,
+	}
+	check.reset()
+	sc := StatusChecker{ID: "some_pod", Checks: []*HealthCheck{check}}
+
+	res := sc.resultFromChecks()
+	if res.Status != health.Critical {
+		t.Errorf("expected a check that hasn't run to be critical but got %s", res.Status)
+	}
+	if expected := fmt.Sprintf("%s check has not passed yet", launch.TCPHealthCheck); res.Output != expected {
+		t.Errorf("expected the result's output to be %q but was %q", expected, res.Output)
+	}
+
+	// the failure threshold doesn't apply to a check that never passed
+	check.probe()
+	res = sc.resultFromChecks()
+	if res.Status != health.Critical {
+		t.Errorf("expected a check that never passed to be critical below its threshold but got %s", res.Status)
+	}
+	if expected := fmt.Sprintf("%s check failed: down", launch.TCPHealthCheck); res.Output != expected {
+		t.Errorf("expected the result's output to be %q but was %q", expected, res.Output)
+	}
+
+	prober.setErr(nil)
+	check.probe()
+	if res = sc.resultFromChecks(); res.Status != health.Passing {
+		t.Errorf("expected the check to pass once it succeeded but got %s", res.Status)
+	}
+}
+
+func TestHealthChecksRunIndependently(t *testing.T) {
+	fastProber := &countingProber{}
+	fast := &HealthCheck{
+		Interval:         10 * time.Millisecond,
+		Timeout:          time.Second,
+		FailureThreshold: 1,
+		prober:           fastProber,
+	}
+	slowProber := &countingProber{}
+	slow := &HealthCheck{
+		Interval:         time.Hour,
+		Timeout:          time.Second,
+		FailureThreshold: 1,
+		prober:           slowProber,
+	}
+	hanging := &HealthCheck{
+		Interval:         10 * time.Millisecond,
+		Timeout:          time.Hour,
+		FailureThreshold: 1,
+		prober:           hangingProber{},
+	}
+
+	quit := make(chan struct{})
+	defer close(quit)
+	runHealthChecks([]*HealthCheck{hanging, fast, slow}, quit)
+
+	time.Sleep(200 * time.Millisecond)
+	if calls := fastProber.callCount(); calls < 5 {
+		t.Errorf("expected the fast check to run on its own interval despite a hanging check, but it ran %d times", calls)
+	}
+	if calls := slowProber.callCount(); calls != 1 {
+		t.Errorf("expected the slow check to run once before its interval passed, but it ran %d times", calls)
+	}
+}
+
+func TestTCPProber(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+
+	err = tcpProber{address: address}.probe(context.Background())
+	if err != nil {
+		t.Errorf("expected connecting to a listening port to succeed: %s", err)
+	}
+
+	listener.Close()
+	err = tcpProber{address: address}.probe(context.Background())
+	if err == nil {
+		t.Error("expected connecting to a closed port to fail")
+	}
+}
+
+func TestHTTPProber(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		fmt.Fprint(w, "status: ok")
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		path           string
+		expectedStatus int
+		bodyRegex      string
+		passes         bool
+	}{
+		{"/_status", 0, "", true},
+		{"/_status", http.StatusOK, "ok$", true},
+		{"/_status", 0, "^degraded", false},
+		{"/missing", 0, "", false},
+		{"/missing", http.StatusNotFound, "", true},
+	}
+	for _, test := range tests {
+		prober := httpProber{
+			client:         http.DefaultClient,
+			uri:            server.URL + test.path,
+			expectedStatus: test.expectedStatus,
+			bodyRegex:      regexp.MustCompile(test.bodyRegex),
+		}
+		err := prober.probe(context.Background())
+		if (err == nil) != test.passes {
+			t.Errorf("expected check of %s with status %d and regex %q to pass: %t, got error %v", test.path, test.expectedStatus, test.bodyRegex, test.passes, err)
+		}
+	}
+}
+
+func TestExecProber(t *testing.T) {
+	err := execProber{command: []string{"true"}}.probe(context.Background())
+	if err != nil {
+		t.Errorf("expected a command exiting zero to pass: %s", err)
+	}
+	err = execProber{command: []string{"false"}}.probe(context.Background())
+	if err == nil {
+		t.Error("expected a command exiting nonzero to fail")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = execProber{command: []string{"sleep", "10"}}.probe(ctx)
+	if err == nil {
+		t.Error("expected a command running past the timeout to fail")
+	}
+}
+
+// statusSV reports the given child status for every service
+type statusSV struct {
+	runit.SV
+	statuses map[string]string
+}
+
+func (s statusSV) Stat(service *runit.Service) (*runit.StatResult, error) {
+	return &runit.StatResult{ChildStatus: s.statuses[service.Name]}, nil
+}
+
+func TestRunitProber(t *testing.T) {
+	runitRoot, err := ioutil.TempDir("", "runit_prober")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(runitRoot)
+
+	prober := runitProber{
+		sv:            statusSV{statuses: map[string]string{}},
+		runitRoot:     runitRoot,
+		servicePrefix: "some_pod__",
+	}
+	if err := prober.probe(context.Background()); err == nil {
+		t.Error("expected a pod without runit services to fail")
+	}
+
+	for _, name := range []string{"some_pod__web__launch", "some_pod__worker__launch", "other_pod__web__launch"} {
+		err = os.Mkdir(filepath.Join(runitRoot, name), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	prober.sv = statusSV{statuses: map[string]string{
+		"some_pod__web__launch":    runit.STATUS_RUN,
+		"some_pod__worker__launch": runit.STATUS_RUN,
+		"other_pod__web__launch":   runit.STATUS_DOWN,
+	}}
+	if err := prober.probe(context.Background()); err != nil {
+		t.Errorf("expected a pod with all of its services up to pass: %s", err)
+	}
+
+	prober.sv.(statusSV).statuses["some_pod__worker__launch"] = runit.STATUS_DOWN
+	if err := prober.probe(context.Background()); err == nil {
+		t.Error("expected a pod with a service down to fail")
+	}
+}
+
+func TestNewHealthChecksDefaults(t *testing.T) {
+	man, err := manifest.FromBytes([]byte(`{ id: some_pod, status: { checks: [ { type: tcp, port: 8080, localhost_only: true }, { type: runit, interval: 30, failure_threshold: 3 } ] } }`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checks, err := newHealthChecks(man, "node1", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checks) != 2 {
+		t.Fatalf("expected 2 checks but got %d", len(checks))
+	}
+	if checks[0].Interval != HEALTHCHECK_INTERVAL || checks[0].FailureThreshold != DefaultFailureThreshold {
+		t.Errorf("expected the tcp check to get default settings but got %+v", checks[0])
+	}
+	if prober, ok := checks[0].prober.(tcpProber); !ok || prober.address != "localhost:8080" {
+		t.Errorf("expected a tcp check against localhost but got %+v", checks[0].prober)
+	}
+	if checks[1].Interval != 30*time.Second || checks[1].FailureThreshold != 3 {
+		t.Errorf("expected the runit check to use its own settings but got %+v", checks[1])
+	}
+}
+
+func TestLaunchableChecksRollUp(t *testing.T) {
+	failing := &HealthCheck{
+		Interval:         time.Second,
+		Timeout:          time.Second,
+		FailureThreshold: 1,
+		prober:           &countingProber{err: fmt.Errorf("down")},
+	}
+	passing := &HealthCheck{
+		Interval:         time.Second,
+		Timeout:          time.Second,
+		FailureThreshold: 1,
+		prober:           &countingProber{},
+	}
+	sc := StatusChecker{
+		ID:   "some_pod",
+		Node: "node1",
+		LaunchableChecks: map[launch.LaunchableID][]*HealthCheck{
+			"worker": {failing},
+			"web":    {passing},
+		},
+	}
+
+	failing.probe()
+	passing.probe()
+	res, launchableResults, err := sc.check()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != health.Critical {
+		t.Errorf("expected a failing launchable to fail the pod but got %s", res.Status)
+	}
+	launchables := res.Launchables
+	if len(launchables) != 2 {
+		t.Fatalf("expected the health of each launchable but got %+v", launchables)
+	}
+	if launchables["web"] != health.Passing {
+		t.Errorf("expected web to pass but got %s", launchables["web"])
+	}
+	if launchables["worker"] != health.Critical {
+		t.Errorf("expected worker to fail but got %s", launchables["worker"])
+	}
+	if !strings.HasPrefix(res.Output, "worker: ") || !strings.HasSuffix(res.Output, "failed: down") {
+		t.Errorf("expected the output to carry the error of the failing worker check but got %q", res.Output)
+	}
+
+	consulRes := resToConsulRes(res)
+	if !consulRes.Launchables.Equal(res.Launchables) || consulRes.Output != res.Output {
+		t.Errorf("expected launchable health and output to be written along with the pod's but got %+v", consulRes)
+	}
+
+	if len(launchableResults) != 2 {
+		t.Fatalf("expected a result for each launchable to be published but got %+v", launchableResults)
+	}
+	worker := launchableResults["worker"]
+	if worker.Service != "some_pod__worker" || worker.Status != health.Critical || !strings.HasSuffix(worker.Output, "failed: down") {
+		t.Errorf("expected the worker's own result to be critical with the error of its check but got %+v", worker)
+	}
+	if web := launchableResults["web"]; web.Service != "some_pod__web" || web.Status != health.Passing {
+		t.Errorf("expected the web launchable's own result to pass but got %+v", web)
+	}
+}
+
+func TestNewLaunchableHealthChecks(t *testing.T) {
+	man, err := manifest.FromBytes([]byte(`{ id: some_pod, launchables: { web: { launchable_type: hoist, location: "https://localhost/web.tar.gz", status: { checks: [ { type: runit } ] } }, worker: { launchable_type: hoist, location: "https://localhost/worker.tar.gz" } } }`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checks, err := newLaunchableHealthChecks(man, "node1", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checks) != 1 || len(checks["web"]) != 1 {
+		t.Fatalf("expected only web to have a check but got %+v", checks)
+	}
+	if prober, ok := checks["web"][0].prober.(runitProber); !ok || prober.servicePrefix != "some_pod__web__" {
+		t.Errorf("expected a runit check of web's services but got %+v", checks["web"][0].prober)
+	}
+}
@@ -3,10 +3,14 @@ package watch
 import (
 	"fmt"
 	"net/http"
+	"reflect"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/square/p2/pkg/constants"
 	"github.com/square/p2/pkg/health"
+	"github.com/square/p2/pkg/launch"
 	"github.com/square/p2/pkg/logging"
 	"github.com/square/p2/pkg/manifest"
 	"github.com/square/p2/pkg/preparer"
@@ -35,6 +39,10 @@ type PodWatch struct {
 	updater       consul.HealthUpdater
 	statusChecker StatusChecker
 
+	// launchableUpdaters publish the health of each launchable with its
+	// own checks, under the service named by health.LaunchableService
+	launchableUpdaters map[launch.LaunchableID]consul.HealthUpdater
+
 	// For tracking/controlling the go routine that performs health checks
 	// on the pod associated with this PodWatch
 	shutdownCh chan bool
@@ -43,12 +51,17 @@ type PodWatch struct {
 }
 
 // StatusChecker holds all the data required to perform
-// a status check on a particular service
+// a status check on a particular service. If Checks is set, they
+// are run instead of the HTTP status check against URI.
+// LaunchableChecks are run in addition to either of them, and a
+// launchable failing its checks fails the whole pod
 type StatusChecker struct {
-	ID     types.PodID
-	Node   types.NodeName
-	URI    string
-	Client *http.Client
+	ID               types.PodID
+	Node             types.NodeName
+	URI              string
+	Client           *http.Client
+	Checks           []*HealthCheck
+	LaunchableChecks map[launch.LaunchableID][]*HealthCheck
 }
 
 // MonitorPodHealth is meant to be a long running go routine.
@@ -136,7 +149,9 @@ func updatePods(
 				man.Manifest.GetStatusHTTP() == pod.manifest.GetStatusHTTP() &&
 				man.Manifest.GetStatusLocalhostOnly() == pod.manifest.GetStatusLocalhostOnly() &&
 				man.Manifest.GetStatusPath() == pod.manifest.GetStatusPath() &&
-				man.Manifest.GetStatusPort() == pod.manifest.GetStatusPort() {
+				man.Manifest.GetStatusPort() == pod.manifest.GetStatusPort() &&
+				reflect.DeepEqual(man.Manifest.GetStatusStanza().Checks, pod.manifest.GetStatusStanza().Checks) &&
+				reflect.DeepEqual(launchableStatusStanzas(man.Manifest), launchableStatusStanzas(pod.manifest)) {
 				inReality = true
 				break
 			}
@@ -191,12 +206,30 @@ func updatePods(
 			} else {
 				sc.URI = fmt.Sprintf("https://%s:%d%s", statusHost, man.Manifest.GetStatusPort(), man.Manifest.GetStatusPath())
 			}
+			checks, err := newHealthChecks(man.Manifest, node, secureClient, insecureClient)
+			if err != nil {
+				logger.WithError(err).Errorln("could not set up health checks")
+				continue
+			}
+			sc.Checks = checks
+			launchableChecks, err := newLaunchableHealthChecks(man.Manifest, node, secureClient, insecureClient)
+			if err != nil {
+				logger.WithError(err).Errorln("could not set up launchable health checks")
+				continue
+			}
+			sc.LaunchableChecks = launchableChecks
+			launchableUpdaters := make(map[launch.LaunchableID]consul.HealthUpdater, len(launchableChecks))
+			for launchableID := range launchableChecks {
+				service := health.LaunchableService(man.Manifest.ID(), launchableID.String())
+				launchableUpdaters[launchableID] = healthManager.NewUpdater(man.Manifest.ID(), service)
+			}
 			newPod := PodWatch{
-				manifest:      man.Manifest,
-				updater:       healthManager.NewUpdater(man.Manifest.ID(), string(man.Manifest.ID())),
-				statusChecker: sc,
-				shutdownCh:    make(chan bool, 1),
-				logger:        logger,
+				manifest:           man.Manifest,
+				updater:            healthManager.NewUpdater(man.Manifest.ID(), string(man.Manifest.ID())),
+				statusChecker:      sc,
+				launchableUpdaters: launchableUpdaters,
+				shutdownCh:         make(chan bool, 1),
+				logger:             logger,
 			}
 
 			// Each health monitor will have its own statusChecker
@@ -210,45 +243,117 @@ func updatePods(
 // Monitor Health is a go routine that runs as long as the
 // service it is monitoring. Every HEALTHCHECK_INTERVAL it
 // performs a health check and writes that information to
-// consul
+// consul. The checks listed in the status stanzas run on
+// their own intervals in the background and only their
+// latest results are reported
 func (p *PodWatch) MonitorHealth() {
+	checksQuit := make(chan struct{})
+	runHealthChecks(p.statusChecker.Checks, checksQuit)
+	for _, checks := range p.statusChecker.LaunchableChecks {
+		runHealthChecks(checks, checksQuit)
+	}
+
 	for {
 		select {
 		case <-time.After(HEALTHCHECK_INTERVAL):
 			p.checkHealth()
 		case <-p.shutdownCh:
+			close(checksQuit)
 			p.updater.Close()
+			for _, updater := range p.launchableUpdaters {
+				updater.Close()
+			}
 			return
 		}
 	}
 }
 
 func (p *PodWatch) checkHealth() {
-	health, err := p.statusChecker.Check()
+	health, launchableResults, err := p.statusChecker.check()
 	if err != nil {
 		p.logger.WithError(err).Warningln("health check failed")
 		return
 	}
-
 	if err = p.updater.PutHealth(resToConsulRes(health)); err != nil {
 		p.logger.WithError(err).Warningln("failed to write health")
 	}
+	for launchableID, res := range launchableResults {
+		updater, ok := p.launchableUpdaters[launchableID]
+		if !ok {
+			continue
+		}
+		if err = updater.PutHealth(resToConsulRes(res)); err != nil {
+			p.logger.WithError(err).Warningf("failed to write health of launchable %s", launchableID)
+		}
+	}
 }
 
 // Given the result of a status check this method
-// creates a health.Result for that node/service/result
+// creates a health.Result for that node/service/result. The
+// health of any launchables with checks is attached to it, and
+// its status is the worst of the pod's and its launchables'
+// statuses. The output of failing launchables is appended to
+// the pod's
 func (sc *StatusChecker) Check() (health.Result, error) {
-	if sc.URI != "" {
+	res, _, err := sc.check()
+	return res, err
+}
+
+// check is like Check, but also returns the result of each launchable with
+// its own checks, which is published under the launchable's own service
+func (sc *StatusChecker) check() (health.Result, map[launch.LaunchableID]health.Result, error) {
+	res, err := sc.podCheck()
+	if err != nil || len(sc.LaunchableChecks) == 0 {
+		return res, nil, err
+	}
+
+	launchableIDs := make([]string, 0, len(sc.LaunchableChecks))
+	for launchableID := range sc.LaunchableChecks {
+		launchableIDs = append(launchableIDs, launchableID.String())
+	}
+	sort.Strings(launchableIDs)
+
+	results := health.ResultList{res}
+	launchableResults := make(map[launch.LaunchableID]health.Result, len(sc.LaunchableChecks))
+	statuses := make(health.LaunchableStatuses, len(sc.LaunchableChecks))
+	var outputs []string
+	if res.Output != "" {
+		outputs = append(outputs, res.Output)
+	}
+	for _, launchableID := range launchableIDs {
+		status, output := checksStatus(sc.LaunchableChecks[launch.LaunchableID(launchableID)])
+		launchableRes := health.Result{
+			ID:      sc.ID,
+			Node:    sc.Node,
+			Service: health.LaunchableService(sc.ID, launchableID),
+			Status:  status,
+			Output:  output,
+		}
+		results = append(results, launchableRes)
+		launchableResults[launch.LaunchableID(launchableID)] = launchableRes
+		statuses[launchableID] = status
+		if output != "" {
+			outputs = append(outputs, fmt.Sprintf("%s: %s", launchableID, output))
+		}
+	}
+	res.Status = results.MinValue().Status
+	res.Launchables = statuses
+	res.Output = strings.Join(outputs, "; ")
+	return res, launchableResults, nil
+}
+
+func (sc *StatusChecker) podCheck() (health.Result, error) {
+	if len(sc.Checks) > 0 {
+		return sc.resultFromChecks(), nil
+	} else if sc.URI != "" {
 		return sc.resultFromCheck(sc.StatusCheck())
 	} else {
 		// "unknown" is probably more accurate, but automated tools can't handle an app that is
 		// always non-"passing". For instance, p2-replicate by default waits for a node to
 		// become "passing" before it considers the deployment a success.
 		//
-		// TODO: P2 has the capacity to check whether the app's process is running. This would
-		// make a great default status check! However, that information isn't easily accessible
-		// over here in the watch package. It would take a lot of refactoring to make this
-		// happen.
+		// Pods that want their processes checked can list a "runit" check in their
+		// status stanza.
 		return health.Result{
 			ID:      sc.ID,
 			Node:    sc.Node,
@@ -258,6 +363,57 @@ func (sc *StatusChecker) Check() (health.Result, error) {
 	}
 }
 
+// resultFromChecks returns a passing result unless one of the checks hasn't
+// succeeded yet or has reached its failure threshold, in which case the output
+// of the result is the error of the last failing check
+func (sc *StatusChecker) resultFromChecks() health.Result {
+	status, output := checksStatus(sc.Checks)
+	return health.Result{
+		ID:      sc.ID,
+		Node:    sc.Node,
+		Service: string(sc.ID),
+		Status:  status,
+		Output:  output,
+	}
+}
+
+// checksStatus returns critical if any of the checks has reached its failure
+// threshold, along with the error of the last failing check. The failure
+// threshold only applies once a check has succeeded, so a pod isn't reported
+// passing before each of its checks has passed
+func checksStatus(checks []*HealthCheck) (health.HealthState, string) {
+	status := health.Passing
+	output := ""
+	for _, check := range checks {
+		succeeded, err := check.Succeeded()
+		if succeeded {
+			var failing bool
+			failing, err = check.Failing()
+			if !failing {
+				continue
+			}
+		}
+
+		status = health.Critical
+		if err != nil {
+			output = fmt.Sprintf("%s check failed: %s", check.Type, err)
+		} else if output == "" {
+			output = fmt.Sprintf("%s check has not passed yet", check.Type)
+		}
+	}
+	return status, output
+}
+
+// launchableStatusStanzas returns the status stanza of each of a manifest's
+// launchables, so changes to them can be noticed
+func launchableStatusStanzas(man manifest.Manifest) map[launch.LaunchableID]launch.LaunchableStatusStanza {
+	ret := make(map[launch.LaunchableID]launch.LaunchableStatusStanza)
+	for launchableID, stanza := range man.GetLaunchableStanzas() {
+		ret[launchableID] = stanza.Status
+	}
+	return ret
+}
+
 func (sc *StatusChecker) resultFromCheck(resp *http.Response, err error) (health.Result, error) {
 	res := health.Result{
 		ID:      sc.ID,
@@ -284,9 +440,11 @@ func (sc *StatusChecker) StatusCheck() (*http.Response, error) {
 
 func resToConsulRes(res health.Result) consul.WatchResult {
 	return consul.WatchResult{
-		Service: res.Service,
-		Node:    res.Node,
-		Id:      res.ID,
-		Status:  string(res.Status),
+		Service:     res.Service,
+		Node:        res.Node,
+		Id:          res.ID,
+		Status:      string(res.Status),
+		Launchables: res.Launchables,
+		Output:      res.Output,
 	}
 }
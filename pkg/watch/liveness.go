@@ -0,0 +1,356 @@
+package watch
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/square/p2/pkg/constants"
+	"github.com/square/p2/pkg/launch"
+	"github.com/square/p2/pkg/logging"
+	"github.com/square/p2/pkg/manifest"
+	"github.com/square/p2/pkg/pods"
+	"github.com/square/p2/pkg/preparer"
+	"github.com/square/p2/pkg/runit"
+	"github.com/square/p2/pkg/store/consul"
+	"github.com/square/p2/pkg/store/consul/statusstore"
+	"github.com/square/p2/pkg/store/consul/statusstore/podstatus"
+	"github.com/square/p2/pkg/store/consul/transaction"
+	"github.com/square/p2/pkg/types"
+	"github.com/square/p2/pkg/util"
+	"github.com/square/p2/pkg/util/param"
+)
+
+// After restarting a launchable that failed a liveness check, the launchable
+// isn't restarted again until the backoff has passed. The backoff doubles
+// with each restart that doesn't get the launchable's liveness checks passing
+// again, up to the max.
+var (
+	livenessRestartBackoffSeconds    = param.Int("liveness_restart_backoff_seconds", 10)
+	livenessRestartMaxBackoffSeconds = param.Int("liveness_restart_max_backoff_seconds", 300)
+)
+
+// LivenessRestartCounter records restarts of a uuid pod's processes in the
+// pod's status. Legacy pods don't have a status, so their restarts are only
+// logged
+type LivenessRestartCounter interface {
+	IncrementLivenessRestarts(ctx context.Context, podUniqueKey types.PodUniqueKey, launchableID launch.LaunchableID, entryPoint string) error
+}
+
+var _ LivenessRestartCounter = podstatus.ConsulStore{}
+
+// PodActivity tells whether the preparer is currently installing, launching
+// or stopping a pod. The liveness checks of such pods are expected to fail,
+// so they are skipped until the preparer is done
+type PodActivity interface {
+	PodBusy(podID types.PodID, podUniqueKey types.PodUniqueKey) bool
+}
+
+var _ PodActivity = &preparer.Preparer{}
+
+// MonitorLiveness is meant to be a long running go routine. It runs the
+// liveness checks of every launchable installed on the host that lists any,
+// and restarts a launchable's runit services once one of its liveness checks
+// reaches its failure threshold. Unlike MonitorPodHealth it also covers uuid
+// pods, whose restarts are counted in their pod status. Each launchable is
+// watched in its own goroutine, so a launchable that is slow to check or
+// restart doesn't hold up the others.
+func MonitorLiveness(config *preparer.PreparerConfig, activity PodActivity, logger *logging.Logger, shutdownCh chan struct{}) {
+	client, err := config.GetConsulClient()
+	if err != nil {
+		// A bad config should have already produced a nice, user-friendly error message.
+		logger.WithError(err).Fatalln("error creating liveness monitor KV client")
+	}
+	store := consul.NewConsulStore(client)
+
+	secureClient, err := config.GetClient(time.Duration(*constants.HEALTHCHECK_TIMEOUT) * time.Second)
+	if err != nil {
+		logger.WithError(err).Fatalln("failed to get http client for this preparer")
+	}
+	insecureClient, err := config.GetInsecureClient(time.Duration(*constants.HEALTHCHECK_TIMEOUT) * time.Second)
+	if err != nil {
+		logger.WithError(err).Fatalln("failed to get http client for this preparer")
+	}
+
+	monitor := livenessMonitor{
+		node:           config.NodeName,
+		sv:             runit.DefaultSV,
+		runitRoot:      runit.DefaultBuilder.RunitRoot,
+		restartCounter: podstatus.NewConsul(statusstore.NewConsul(client), consul.PreparerPodStatusNamespace),
+		txner:          client.KV(),
+		secureClient:   secureClient,
+		insecureClient: insecureClient,
+		activity:       activity,
+		logger:         *logger,
+		watches:        make(map[string]*livenessWatch),
+	}
+
+	watchQuitCh := make(chan struct{})
+	watchErrCh := make(chan error)
+	watchPodCh := make(chan []consul.ManifestResult)
+	go store.WatchPods(
+		consul.REALITY_TREE,
+		config.NodeName,
+		watchQuitCh,
+		watchErrCh,
+		watchPodCh,
+	)
+
+	for {
+		select {
+		case results := <-watchPodCh:
+			monitor.updateWatches(results)
+		case err := <-watchErrCh:
+			logger.WithError(err).Errorln("there was an error reading reality manifests for liveness monitor")
+		case <-shutdownCh:
+			close(watchQuitCh)
+			monitor.updateWatches(nil)
+			return
+		}
+	}
+}
+
+type livenessMonitor struct {
+	node           types.NodeName
+	sv             runit.SV
+	runitRoot      string
+	restartCounter LivenessRestartCounter
+	txner          transaction.Txner
+	secureClient   *http.Client
+	insecureClient *http.Client
+	activity       PodActivity
+	logger         logging.Logger
+
+	// keyed by the service prefix of each launchable
+	watches map[string]*livenessWatch
+}
+
+// updateWatches starts watching the launchables in reality, keeping the
+// watches of launchables whose liveness checks did not change and stopping
+// the watches of launchables that are gone
+func (m *livenessMonitor) updateWatches(reality []consul.ManifestResult) {
+	watches := make(map[string]*livenessWatch)
+	for _, result := range reality {
+		man := result.Manifest
+		uniqueName := pods.ComputeUniqueName(man.ID(), result.PodUniqueKey)
+		for launchableID, launchableStanza := range man.GetLaunchableStanzas() {
+			if len(launchableStanza.Status.Liveness) == 0 {
+				continue
+			}
+
+			servicePrefix := uniqueName + "__" + launchableID.String() + "__"
+			if existing, ok := m.watches[servicePrefix]; ok && reflect.DeepEqual(existing.stanzas, launchableStanza.Status.Liveness) {
+				watches[servicePrefix] = existing
+				continue
+			}
+
+			watch, err := m.newWatch(man, result.PodUniqueKey, launchableID, servicePrefix, launchableStanza.Status.Liveness)
+			if err != nil {
+				m.logger.WithError(err).Errorln("could not set up liveness checks")
+				continue
+			}
+			go watch.run()
+			watches[servicePrefix] = watch
+		}
+	}
+	for servicePrefix, watch := range m.watches {
+		if watches[servicePrefix] != watch {
+			close(watch.quit)
+		}
+	}
+	m.watches = watches
+}
+
+func (m *livenessMonitor) newWatch(
+	man manifest.Manifest,
+	podUniqueKey types.PodUniqueKey,
+	launchableID launch.LaunchableID,
+	servicePrefix string,
+	stanzas []launch.HealthCheckStanza,
+) (*livenessWatch, error) {
+	watch := &livenessWatch{
+		podID:          man.ID(),
+		podUniqueKey:   podUniqueKey,
+		launchableID:   launchableID,
+		servicePrefix:  servicePrefix,
+		stanzas:        stanzas,
+		sv:             m.sv,
+		runitRoot:      m.runitRoot,
+		restartCounter: m.restartCounter,
+		txner:          m.txner,
+		activity:       m.activity,
+		quit:           make(chan struct{}),
+		logger: m.logger.SubLogger(logrus.Fields{
+			"pod":        man.ID(),
+			"uuid":       podUniqueKey,
+			"launchable": launchableID,
+		}),
+	}
+	for _, stanza := range stanzas {
+		check, err := newHealthCheck(stanza, DefaultLivenessFailureThreshold, man.RunAsUser(), servicePrefix, m.node, m.secureClient, m.insecureClient)
+		if err != nil {
+			return nil, util.Errorf("invalid liveness check for %s launchable %s: %s", man.ID(), launchableID, err)
+		}
+		check.InitialDelay = stanza.GetInitialDelay()
+		watch.checks = append(watch.checks, check)
+	}
+	return watch, nil
+}
+
+// livenessWatch runs the liveness checks of a single launchable
+type livenessWatch struct {
+	podID         types.PodID
+	podUniqueKey  types.PodUniqueKey
+	launchableID  launch.LaunchableID
+	servicePrefix string
+	stanzas       []launch.HealthCheckStanza
+	checks        []*HealthCheck
+
+	sv             runit.SV
+	runitRoot      string
+	restartCounter LivenessRestartCounter
+	txner          transaction.Txner
+	activity       PodActivity
+	logger         logging.Logger
+
+	// quit stops the watch and the goroutines running its checks
+	quit chan struct{}
+
+	// restarts counts the restarts since the checks last all passed, and
+	// determines the backoff before the next one
+	restarts    int
+	nextRestart time.Time
+}
+
+// run starts the launchable's checks and acts on their results every
+// HEALTHCHECK_INTERVAL until the watch is stopped
+func (w *livenessWatch) run() {
+	runHealthChecks(w.checks, w.quit)
+
+	ticker := time.NewTicker(HEALTHCHECK_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.quit:
+			return
+		case now := <-ticker.C:
+			w.check(now)
+		}
+	}
+}
+
+func (w *livenessWatch) check(now time.Time) {
+	if w.activity != nil && w.activity.PodBusy(w.podID, w.podUniqueKey) {
+		// the failures of a pod that is being changed don't count, and
+		// the launchable gets its initial delay again once it's up
+		w.resetChecks()
+		return
+	}
+
+	failing := false
+	passing := true
+	var lastErr error
+	for _, check := range w.checks {
+		if !check.Passing() {
+			passing = false
+		}
+		if checkFailing, err := check.Failing(); checkFailing {
+			failing = true
+			lastErr = err
+		}
+	}
+
+	// checks that haven't run since the launchable was restarted aren't
+	// passing, so the backoff keeps growing until the restart takes
+	if passing {
+		w.restarts = 0
+		return
+	}
+	if !failing {
+		return
+	}
+	if now.Before(w.nextRestart) {
+		w.logger.WithError(lastErr).Debugln("liveness check is failing, backing off before restarting")
+		return
+	}
+
+	w.logger.WithError(lastErr).Warningln("liveness check failed, restarting launchable")
+	err := w.restart()
+	if err != nil {
+		w.logger.WithError(err).Errorln("could not restart launchable")
+	}
+
+	w.restarts++
+	w.nextRestart = now.Add(livenessRestartBackoff(w.restarts))
+	// the restarted launchable has to reach the failure threshold again
+	w.resetChecks()
+}
+
+func (w *livenessWatch) resetChecks() {
+	for _, check := range w.checks {
+		check.reset()
+	}
+}
+
+// restart restarts each of the launchable's runit services, counting the
+// restart of each entry point for uuid pods
+func (w *livenessWatch) restart() error {
+	paths, err := filepath.Glob(filepath.Join(w.runitRoot, w.servicePrefix+"*"))
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		return util.Errorf("no runit services found starting with %s", w.servicePrefix)
+	}
+
+	var lastErr error
+	for _, path := range paths {
+		service := &runit.Service{Path: path, Name: filepath.Base(path)}
+		_, err := w.sv.Restart(service, runit.DefaultTimeout)
+		if err != nil && err != runit.Killed {
+			lastErr = util.Errorf("could not restart %s: %s", service.Name, err)
+			continue
+		}
+		if w.podUniqueKey == "" {
+			continue
+		}
+
+		// The services of uuid pods are named after the relative path of
+		// their entry point with slashes replaced by "__"
+		entryPoint := strings.Replace(strings.TrimPrefix(service.Name, w.servicePrefix), "__", "/", -1)
+		err = w.countRestart(entryPoint)
+		if err != nil {
+			lastErr = util.Errorf("could not count restart of %s: %s", service.Name, err)
+		}
+	}
+	return lastErr
+}
+
+func (w *livenessWatch) countRestart(entryPoint string) error {
+	ctx, cancelFunc := transaction.New(context.Background())
+	defer cancelFunc()
+	err := w.restartCounter.IncrementLivenessRestarts(ctx, w.podUniqueKey, w.launchableID, entryPoint)
+	if err != nil {
+		return err
+	}
+	return transaction.MustCommit(ctx, w.txner)
+}
+
+// livenessRestartBackoff returns how long to wait after the given number of
+// consecutive restarts before restarting again
+func livenessRestartBackoff(restarts int) time.Duration {
+	backoff := time.Duration(*livenessRestartBackoffSeconds) * time.Second
+	maxBackoff := time.Duration(*livenessRestartMaxBackoffSeconds) * time.Second
+	for i := 1; i < restarts && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	return backoff
+}
@@ -0,0 +1,235 @@
+package watch
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/square/p2/pkg/launch"
+	"github.com/square/p2/pkg/logging"
+	"github.com/square/p2/pkg/manifest"
+	"github.com/square/p2/pkg/runit"
+	"github.com/square/p2/pkg/store/consul"
+	"github.com/square/p2/pkg/types"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// restartSV records the services that were restarted
+type restartSV struct {
+	runit.SV
+	restarted []string
+}
+
+func (s *restartSV) Restart(service *runit.Service, timeout time.Duration) (string, error) {
+	s.restarted = append(s.restarted, service.Name)
+	return "", nil
+}
+
+type fakeRestartCounter map[string]int
+
+func (f fakeRestartCounter) IncrementLivenessRestarts(ctx context.Context, podUniqueKey types.PodUniqueKey, launchableID launch.LaunchableID, entryPoint string) error {
+	f[launchableID.String()+"/"+entryPoint]++
+	return nil
+}
+
+type fakeTxner struct{}
+
+func (fakeTxner) Txn(txn api.KVTxnOps, q *api.QueryOptions) (bool, *api.KVTxnResponse, *api.QueryMeta, error) {
+	return true, &api.KVTxnResponse{}, nil, nil
+}
+
+func TestLivenessRestartWithBackoff(t *testing.T) {
+	runitRoot, err := ioutil.TempDir("", "liveness")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(runitRoot)
+	servicePrefix := "some_pod-abc__web__"
+	for _, name := range []string{servicePrefix + "bin__launch", "some_pod-abc__worker__bin__launch"} {
+		err = os.Mkdir(filepath.Join(runitRoot, name), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	prober := &countingProber{err: fmt.Errorf("down")}
+	sv := &restartSV{}
+	counter := fakeRestartCounter{}
+	var watch *livenessWatch
+	// check probes the watch's checks as their goroutines would before
+	// checking the watch at the given time
+	check := func(now time.Time) {
+		for _, healthCheck := range watch.checks {
+			healthCheck.probe()
+		}
+		watch.check(now)
+	}
+	watch = &livenessWatch{
+		podUniqueKey:  "abc",
+		launchableID:  "web",
+		servicePrefix: servicePrefix,
+		checks: []*HealthCheck{{
+			Timeout:          time.Second,
+			FailureThreshold: 2,
+			prober:           prober,
+		}},
+		sv:             sv,
+		runitRoot:      runitRoot,
+		restartCounter: counter,
+		txner:          fakeTxner{},
+		logger:         logging.TestLogger(),
+	}
+
+	now := time.Now()
+	check(now)
+	if len(sv.restarted) != 0 {
+		t.Fatal("expected no restart below the failure threshold")
+	}
+	check(now.Add(time.Second))
+	if len(sv.restarted) != 1 || sv.restarted[0] != servicePrefix+"bin__launch" {
+		t.Fatalf("expected only the launchable's service to be restarted but got %s", sv.restarted)
+	}
+	if counter["web/bin/launch"] != 1 {
+		t.Errorf("expected the restart to be counted for the entry point but got %v", counter)
+	}
+
+	// the restarted launchable hasn't passed until its checks have run
+	watch.check(now.Add(2 * time.Second))
+	if watch.restarts != 1 {
+		t.Fatalf("expected the restart to count until the checks pass but got %d restarts", watch.restarts)
+	}
+
+	// the failure threshold has to be reached again, and then the backoff
+	// has to pass
+	check(now.Add(2 * time.Second))
+	check(now.Add(3 * time.Second))
+	if len(sv.restarted) != 1 {
+		t.Errorf("expected no restart during the backoff but got %d restarts", len(sv.restarted))
+	}
+	firstBackoff := livenessRestartBackoff(1)
+	check(now.Add(time.Second + firstBackoff))
+	if len(sv.restarted) != 2 {
+		t.Errorf("expected a restart once the backoff passed but got %d restarts", len(sv.restarted))
+	}
+	if watch.nextRestart.Sub(now.Add(time.Second+firstBackoff)) != livenessRestartBackoff(2) {
+		t.Errorf("expected the backoff to grow after another restart")
+	}
+
+	// passing resets the backoff
+	prober.setErr(nil)
+	check(now.Add(time.Hour))
+	if watch.restarts != 0 {
+		t.Errorf("expected the restarts since passing to be reset but got %d", watch.restarts)
+	}
+}
+
+func TestLivenessRestartBackoff(t *testing.T) {
+	base := time.Duration(*livenessRestartBackoffSeconds) * time.Second
+	max := time.Duration(*livenessRestartMaxBackoffSeconds) * time.Second
+	if livenessRestartBackoff(1) != base {
+		t.Errorf("expected the first backoff to be %s but got %s", base, livenessRestartBackoff(1))
+	}
+	if livenessRestartBackoff(3) != 4*base {
+		t.Errorf("expected the backoff to double with each restart but got %s", livenessRestartBackoff(3))
+	}
+	if livenessRestartBackoff(100) != max {
+		t.Errorf("expected the backoff to be capped at %s but got %s", max, livenessRestartBackoff(100))
+	}
+}
+
+func TestLivenessUpdateWatches(t *testing.T) {
+	monitor := livenessMonitor{
+		node:    "node1",
+		logger:  logging.TestLogger(),
+		watches: make(map[string]*livenessWatch),
+	}
+	man, err := manifest.FromBytes([]byte(`{ id: some_pod, launchables: { web: { launchable_type: hoist, location: "https://localhost/web.tar.gz", status: { liveness: [ { type: runit } ] } }, worker: { launchable_type: hoist, location: "https://localhost/worker.tar.gz" } } }`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	monitor.updateWatches([]consul.ManifestResult{
+		{Manifest: man},
+		{Manifest: man, PodUniqueKey: "abc"},
+	})
+	if len(monitor.watches) != 2 {
+		t.Fatalf("expected a watch for the launchable of each pod but got %d", len(monitor.watches))
+	}
+	legacy, ok := monitor.watches["some_pod__web__"]
+	if !ok || legacy.checks[0].FailureThreshold != DefaultLivenessFailureThreshold {
+		t.Errorf("expected a watch of the legacy pod's launchable with the liveness default threshold but got %+v", legacy)
+	}
+	if _, ok := monitor.watches["some_pod-abc__web__"]; !ok {
+		t.Errorf("expected a watch of the uuid pod's launchable")
+	}
+
+	uuid := monitor.watches["some_pod-abc__web__"]
+
+	// unchanged launchables keep their watch, and the watches of
+	// launchables that are gone are stopped
+	monitor.updateWatches([]consul.ManifestResult{{Manifest: man}})
+	if len(monitor.watches) != 1 || monitor.watches["some_pod__web__"] != legacy {
+		t.Errorf("expected only the unchanged watch to be kept but got %+v", monitor.watches)
+	}
+	select {
+	case <-uuid.quit:
+	default:
+		t.Error("expected the watch of the removed pod to be stopped")
+	}
+
+	monitor.updateWatches(nil)
+	select {
+	case <-legacy.quit:
+	default:
+		t.Error("expected every watch to be stopped once there are no pods")
+	}
+}
+
+type fakePodActivity map[types.PodID]bool
+
+func (f fakePodActivity) PodBusy(podID types.PodID, podUniqueKey types.PodUniqueKey) bool {
+	return f[podID]
+}
+
+func TestLivenessSkipsBusyPods(t *testing.T) {
+	sv := &restartSV{}
+	activity := fakePodActivity{"some_pod": true}
+	check := &HealthCheck{
+		Timeout:          time.Second,
+		FailureThreshold: 1,
+		InitialDelay:     time.Hour,
+		prober:           &countingProber{err: fmt.Errorf("down")},
+	}
+	watch := &livenessWatch{
+		podID:         "some_pod",
+		launchableID:  "web",
+		servicePrefix: "some_pod__web__",
+		checks:        []*HealthCheck{check},
+		sv:            sv,
+		activity:      activity,
+		logger:        logging.TestLogger(),
+	}
+
+	check.probe()
+	watch.check(time.Now())
+	if len(sv.restarted) != 0 {
+		t.Errorf("expected a pod the preparer is changing not to be restarted but got %s", sv.restarted)
+	}
+	if failing, _ := check.Failing(); failing {
+		t.Error("expected the failures of a pod the preparer is changing to be forgotten")
+	}
+
+	// once the preparer is done, the launchable gets its initial delay
+	// before it is checked again
+	activity["some_pod"] = false
+	check.probe()
+	watch.check(time.Now())
+	if failing, _ := check.Failing(); failing || len(sv.restarted) != 0 {
+		t.Errorf("expected the check not to run during its initial delay")
+	}
+}